@@ -11,6 +11,7 @@ import (
 	"github.com/gorilla/mux"
 	_ "github.com/lib/pq"
 
+	"github.com/djalben/xplr-core/backend/domain"
 	h "github.com/djalben/xplr-core/backend/handler"
 	"github.com/djalben/xplr-core/backend/middleware"
 	"github.com/djalben/xplr-core/backend/repository"
@@ -59,6 +60,7 @@ func ensureDB() {
 	// 2. Wire DB into packages
 	h.GlobalDB = db
 	repository.GlobalDB = db
+	service.InitCardProvider(db)
 
 	// 3. Telegram — ОБЯЗАТЕЛЬНО
 	tgToken := os.Getenv("TELEGRAM_BOT_TOKEN")
@@ -95,8 +97,11 @@ func ensureDB() {
 	// 4c. Shop infrastructure — registers VlessProvider, fulfillment engine, deposit monitor
 	h.InitShopInfrastructure()
 
-	// 5. Start auto-replenishment (runs as goroutine inside the invocation)
+	// 5. Start auto-replenishment, scheduled transfers, notification & webhook dispatchers (run as goroutines inside the invocation)
 	go usecase.StartAutoReplenishmentWorker()
+	go usecase.StartScheduledTransferWorker()
+	go usecase.StartNotificationDispatcher()
+	go usecase.StartWebhookDispatcher()
 
 	// 6. Auto-migrations (idempotent)
 	migrations := []string{
@@ -121,7 +126,6 @@ func ensureDB() {
 		)`,
 		`DO $$ BEGIN IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name='users' AND column_name='balance_arbitrage') THEN ALTER TABLE users ADD COLUMN balance_arbitrage NUMERIC(20,4) DEFAULT 0; END IF; END $$`,
 		`DO $$ BEGIN IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name='users' AND column_name='balance_personal') THEN ALTER TABLE users ADD COLUMN balance_personal NUMERIC(20,4) DEFAULT 0; END IF; END $$`,
-		`UPDATE users SET balance_arbitrage = COALESCE(balance, 0) WHERE balance_arbitrage = 0 AND balance > 0`,
 	}
 	for _, m := range migrations {
 		if _, err := db.Exec(m); err != nil {
@@ -328,6 +332,99 @@ func ensureDB() {
 		log.Printf("Warning: could not ensure translations table: %v", err)
 	}
 
+	// 9b3. Double-entry ledger tables
+	if err := repository.EnsureLedgerTables(); err != nil {
+		log.Printf("Warning: could not ensure ledger tables: %v", err)
+	}
+
+	// 9b4. Reconciliation report tables
+	if err := repository.EnsureReconciliationTables(); err != nil {
+		log.Printf("Warning: could not ensure reconciliation tables: %v", err)
+	}
+
+	// 9b5. Idempotency-Key store
+	if err := repository.EnsureIdempotencyTable(); err != nil {
+		log.Printf("Warning: could not ensure idempotency table: %v", err)
+	}
+
+	// 9b6. Async card issue jobs
+	if err := repository.EnsureCardIssueJobTables(); err != nil {
+		log.Printf("Warning: could not ensure card issue job tables: %v", err)
+	}
+
+	// 9b7. Card provider routing (card_provider_routes + cards.provider)
+	if err := repository.EnsureCardProviderRouting(); err != nil {
+		log.Printf("Warning: could not ensure card provider routing: %v", err)
+	}
+
+	// 9b8. Spending rules + authorization decline log
+	if err := repository.EnsureSpendingRuleTables(); err != nil {
+		log.Printf("Warning: could not ensure spending rule tables: %v", err)
+	}
+
+	// 9b9. Velocity limits (cards.weekly_spend_limit / monthly_spend_limit / max_tx_per_hour)
+	if err := repository.EnsureVelocityLimitColumns(); err != nil {
+		log.Printf("Warning: could not ensure velocity limit columns: %v", err)
+	}
+	// 9b10. Authorization holds (card_holds, card_hold_expiry_days)
+	if err := repository.EnsureCardHoldTables(); err != nil {
+		log.Printf("Warning: could not ensure card hold tables: %v", err)
+	}
+	// 9b11. Card disputes and refund links (card_disputes, transactions.original_tx_id)
+	if err := repository.EnsureDisputeTables(); err != nil {
+		log.Printf("Warning: could not ensure dispute tables: %v", err)
+	}
+	// 9b12. Multi-currency wallets (wallet_balances, transactions.wallet_currency / fx_rate), legacy users.balance* migration
+	if err := repository.EnsureWalletBalanceTables(); err != nil {
+		log.Printf("Warning: could not ensure wallet balance tables: %v", err)
+	}
+	// 9b13. FX quotes (fx_quotes, transactions.fx_quote_id)
+	if err := repository.EnsureFXQuoteTables(); err != nil {
+		log.Printf("Warning: could not ensure fx quote tables: %v", err)
+	}
+	// 9b14. Exchange rate history (exchange_rate_history, transactions/fx_quotes.rate_snapshot_id)
+	if err := repository.EnsureExchangeRateHistoryTables(); err != nil {
+		log.Printf("Warning: could not ensure exchange rate history tables: %v", err)
+	}
+	// 9b15. Scheduled transfers (scheduled_transfers, scheduled_transfer_runs)
+	if err := repository.EnsureScheduledTransferTables(); err != nil {
+		log.Printf("Warning: could not ensure scheduled transfer tables: %v", err)
+	}
+	// 9b16. Team wallet (team_wallet_balances, team_member_allowances, team_wallet_operations)
+	if err := repository.EnsureTeamWalletTables(); err != nil {
+		log.Printf("Warning: could not ensure team wallet tables: %v", err)
+	}
+	// 9b16a. Ledger opening balances (one-time OPENING_BALANCE postings against Suspense)
+	if err := repository.PostLedgerOpeningBalances(); err != nil {
+		log.Printf("Warning: could not post ledger opening balances: %v", err)
+	}
+	// 9b17. Team roles (team_roles, team_members.role_id)
+	if err := repository.EnsureTeamRoleTables(); err != nil {
+		log.Printf("Warning: could not ensure team role tables: %v", err)
+	}
+	// 9b18. Team invitations (team_invitations)
+	if err := repository.EnsureTeamInvitationTables(); err != nil {
+		log.Printf("Warning: could not ensure team invitation tables: %v", err)
+	}
+	// 9b19. Card ownership transfers (card_ownership_transfers)
+	if err := repository.EnsureCardOwnershipTables(); err != nil {
+		log.Printf("Warning: could not ensure card ownership tables: %v", err)
+	}
+	// 9b20. Notification outbox (notification_outbox, notification_deliveries)
+	if err := repository.EnsureNotificationOutboxTables(); err != nil {
+		log.Printf("Warning: could not ensure notification outbox tables: %v", err)
+	}
+
+	// 9b21. Per-event notification preferences (notification_event_prefs)
+	if err := repository.EnsureNotificationEventPrefTables(); err != nil {
+		log.Printf("Warning: could not ensure notification event prefs table: %v", err)
+	}
+
+	// 9b22. Outgoing webhooks (webhook_endpoints, webhook_deliveries, webhook_delivery_attempts)
+	if err := repository.EnsureWebhookTables(); err != nil {
+		log.Printf("Warning: could not ensure webhook tables: %v", err)
+	}
+
 	// 9c. HARD migration: force claimed_by column (DO $$ may fail on Vercel)
 	if _, err := db.Exec(`ALTER TABLE chat_conversations ADD COLUMN IF NOT EXISTS claimed_by INTEGER DEFAULT 0`); err != nil {
 		log.Printf("[CHAT-MIGRATION] claimed_by ALTER TABLE: %v (may already exist, OK)", err)
@@ -569,8 +666,11 @@ func ensureDB() {
 		}
 	}
 
-	// 9. Seed default exchange rates & start fetcher
+	// 9. Seed default exchange rates, fetcher guard settings & start fetcher
 	repository.SeedDefaultExchangeRates()
+	if err := repository.EnsureExchangeRateGuardSettings(); err != nil {
+		log.Printf("Warning: could not ensure exchange rate guard settings: %v", err)
+	}
 	go service.StartExchangeRateFetcher()
 
 	// 10. Email transport diagnostics
@@ -626,6 +726,7 @@ func buildRouter() *mux.Router {
 	r.HandleFunc("/api/v1/auth/register", h.RegisterHandler).Methods("POST")
 	r.HandleFunc("/api/v1/auth/login", h.LoginHandler).Methods("POST")
 	r.HandleFunc("/api/v1/auth/verify-email", h.VerifyEmailHandler).Methods("GET")
+	r.HandleFunc("/api/v1/auth/team-invitation", h.GetTeamInvitationHandler).Methods("GET")
 	r.HandleFunc("/api/v1/auth/resend-verification", h.ResendVerificationHandler).Methods("POST")
 	r.HandleFunc("/api/v1/auth/reset-password-request", h.ResetPasswordRequestHandler).Methods("POST")
 	r.HandleFunc("/api/v1/auth/reset-password", h.ResetPasswordHandler).Methods("POST")
@@ -654,6 +755,7 @@ func buildRouter() *mux.Router {
 
 	// Public exchange rates
 	r.HandleFunc("/api/v1/rates", h.PublicGetExchangeRatesHandler).Methods("GET")
+	r.HandleFunc("/api/v1/rates/history", h.PublicGetRateHistoryHandler).Methods("GET")
 
 	// Public SBP status check
 	r.HandleFunc("/api/v1/sbp-status", h.GetSBPStatusHandler).Methods("GET")
@@ -673,30 +775,56 @@ func buildRouter() *mux.Router {
 	protected.HandleFunc("/topup", h.TopUpBalanceHandler).Methods("POST")
 	protected.HandleFunc("/stats", h.GetUserStatsHandler).Methods("GET")
 	protected.HandleFunc("/cards", h.GetUserCardsHandler).Methods("GET")
-	protected.HandleFunc("/cards/issue", h.MassIssueCardsHandler).Methods("POST")
-	protected.HandleFunc("/cards/{id}/status", h.PatchCardStatusHandler).Methods("PATCH")
+	protected.HandleFunc("/cards/issue", middleware.RequireBodyTeamPermission(domain.PermCardsIssue, middleware.Idempotent(h.MassIssueCardsHandler))).Methods("POST")
+	protected.HandleFunc("/cards/issue-jobs/{id}", h.GetCardIssueJobHandler).Methods("GET")
+	protected.HandleFunc("/cards/{id}/status", middleware.RequireCardPermission(domain.PermCardsFreeze, h.PatchCardStatusHandler)).Methods("PATCH")
 	protected.HandleFunc("/cards/{id}/auto-replenishment", h.SetCardAutoReplenishmentHandler).Methods("POST")
 	protected.HandleFunc("/cards/{id}/auto-replenishment", h.UnsetCardAutoReplenishmentHandler).Methods("DELETE")
-	protected.HandleFunc("/cards/{id}/details", h.GetCardDetailsHandler).Methods("GET")
+	protected.HandleFunc("/cards/{id}/details", middleware.RequireCardPermission(domain.PermCardsViewPAN, h.GetCardDetailsHandler)).Methods("GET")
 	protected.HandleFunc("/cards/{id}/auto-pay", h.ToggleAutoPayHandler).Methods("PATCH")
 	protected.HandleFunc("/cards/{id}/subscriptions", h.CardSubscriptionsHandler).Methods("GET")
 	protected.HandleFunc("/cards/{id}/subscriptions/{subId}", h.ToggleSubscriptionHandler).Methods("PATCH")
-	protected.HandleFunc("/cards/{id}/freeze-all-subscriptions", h.FreezeAllSubscriptionsHandler).Methods("POST")
-	protected.HandleFunc("/cards/{id}/mock-details", h.MockCardDetailsHandler).Methods("GET")
+	protected.HandleFunc("/cards/{id}/freeze-all-subscriptions", middleware.RequireCardPermission(domain.PermCardsFreeze, h.FreezeAllSubscriptionsHandler)).Methods("POST")
+	protected.HandleFunc("/cards/{id}/mock-details", middleware.RequireCardPermission(domain.PermCardsViewPAN, h.MockCardDetailsHandler)).Methods("GET")
 	protected.HandleFunc("/cards/{id}/limit", h.UpdateCardSpendLimitHandler).Methods("PATCH")
 	protected.HandleFunc("/cards/{id}/sync-balance", h.SyncCardBalanceHandler).Methods("POST")
 	protected.HandleFunc("/cards/{id}/spending-limit", h.SetSpendingLimitHandler).Methods("PATCH")
+	protected.HandleFunc("/cards/{id}/spending-rules", h.GetCardSpendingRulesHandler).Methods("GET")
+	protected.HandleFunc("/cards/{id}/spending-rules", h.CreateCardSpendingRuleHandler).Methods("POST")
+	protected.HandleFunc("/cards/{id}/spending-rules/{ruleId}", h.DeleteCardSpendingRuleHandler).Methods("DELETE")
+	protected.HandleFunc("/cards/{id}/declines", h.GetCardAuthDeclinesHandler).Methods("GET")
+	protected.HandleFunc("/cards/{id}/velocity-limits", h.GetCardVelocityLimitsHandler).Methods("GET")
+	protected.HandleFunc("/cards/{id}/velocity-limits", h.SetCardVelocityLimitsHandler).Methods("PATCH")
 	protected.HandleFunc("/wallet", h.GetWalletHandler).Methods("GET")
-	protected.HandleFunc("/wallet/topup", h.TopUpWalletHandler).Methods("POST")
-	protected.HandleFunc("/wallet/transfer-to-card", h.TransferWalletToCardHandler).Methods("POST")
+	protected.HandleFunc("/wallet/topup", middleware.Idempotent(h.TopUpWalletHandler)).Methods("POST")
+	protected.HandleFunc("/wallet/transfer-to-card", middleware.Idempotent(h.TransferWalletToCardHandler)).Methods("POST")
 	protected.HandleFunc("/wallet/auto-topup", h.SetAutoTopupHandler).Methods("PATCH")
+	protected.HandleFunc("/wallet/convert", middleware.Idempotent(h.ConvertWalletHandler)).Methods("POST")
+	protected.HandleFunc("/wallets", h.GetWalletsHandler).Methods("GET")
+	protected.HandleFunc("/fx/quote", h.CreateFXQuoteHandler).Methods("POST")
+	protected.HandleFunc("/scheduled-transfers", h.GetScheduledTransfersHandler).Methods("GET")
+	protected.HandleFunc("/scheduled-transfers", h.CreateScheduledTransferHandler).Methods("POST")
+	protected.HandleFunc("/scheduled-transfers/{id}", h.UpdateScheduledTransferHandler).Methods("PATCH")
+	protected.HandleFunc("/scheduled-transfers/{id}", h.DeleteScheduledTransferHandler).Methods("DELETE")
+	protected.HandleFunc("/scheduled-transfers/{id}/runs", h.GetScheduledTransferRunsHandler).Methods("GET")
+	protected.HandleFunc("/webhooks", h.GetWebhooksHandler).Methods("GET")
+	protected.HandleFunc("/webhooks", h.CreateWebhookHandler).Methods("POST")
+	protected.HandleFunc("/webhooks/{id}", h.UpdateWebhookHandler).Methods("PATCH")
+	protected.HandleFunc("/webhooks/{id}", h.DeleteWebhookHandler).Methods("DELETE")
+	protected.HandleFunc("/webhooks/{id}/rotate-secret", h.RotateWebhookSecretHandler).Methods("POST")
+	protected.HandleFunc("/webhooks/{id}/test", h.TestWebhookHandler).Methods("POST")
+	protected.HandleFunc("/webhooks/{id}/deliveries", h.GetWebhookDeliveriesHandler).Methods("GET")
+	protected.HandleFunc("/webhooks/{id}/deliveries/{deliveryId}/attempts", h.GetWebhookDeliveryAttemptsHandler).Methods("GET")
+	protected.HandleFunc("/webhooks/{id}/deliveries/{deliveryId}/redeliver", h.RedeliverWebhookHandler).Methods("POST")
 	protected.HandleFunc("/report", h.GetUserTransactionReportHandler).Methods("GET")
 	protected.HandleFunc("/transactions", h.GetUnifiedTransactionsHandler).Methods("GET")
 	protected.HandleFunc("/transactions/export", h.ExportTransactionsHandler).Methods("GET")
+	protected.HandleFunc("/transactions/{id}/dispute", h.CreateDisputeHandler).Methods("POST")
+	protected.HandleFunc("/disputes", h.GetUserDisputesHandler).Methods("GET")
 	protected.HandleFunc("/dashboard-stats", h.GetDashboardStatsHandler).Methods("GET")
 	protected.HandleFunc("/settings/auto-replenish", h.SetAutoTopupHandler).Methods("PATCH")
 	protected.HandleFunc("/api-key", h.CreateAPIKeyHandler).Methods("POST")
-	protected.HandleFunc("/upgrade-tier", h.UpgradeTierHandler).Methods("POST")
+	protected.HandleFunc("/upgrade-tier", middleware.Idempotent(h.UpgradeTierHandler)).Methods("POST")
 	protected.HandleFunc("/tier-info", h.GetTierInfoHandler).Methods("GET")
 	protected.HandleFunc("/news", h.GetNewsHandler).Methods("GET")
 	protected.HandleFunc("/news-notifications", h.GetNewsNotificationsHandler).Methods("GET")
@@ -706,11 +834,11 @@ func buildRouter() *mux.Router {
 
 	// Store
 	protected.HandleFunc("/store/catalog", h.StoreCatalogHandler).Methods("GET")
-	protected.HandleFunc("/store/purchase", h.StorePurchaseHandler).Methods("POST")
+	protected.HandleFunc("/store/purchase", middleware.Idempotent(h.StorePurchaseHandler)).Methods("POST")
 	protected.HandleFunc("/store/orders", h.StoreOrdersHandler).Methods("GET")
 	protected.HandleFunc("/store/esim/destinations", h.ESIMDestinationsHandler).Methods("GET")
 	protected.HandleFunc("/store/esim/plans", h.ESIMPlansHandler).Methods("GET")
-	protected.HandleFunc("/store/esim/order", h.ESIMOrderHandler).Methods("POST")
+	protected.HandleFunc("/store/esim/order", middleware.Idempotent(h.ESIMOrderHandler)).Methods("POST")
 	protected.HandleFunc("/store/vpn-status", h.VPNKeyStatusHandler).Methods("GET")
 
 	log.Println("Registered route: GET /api/v1/user/dashboard-stats")
@@ -719,9 +847,25 @@ func buildRouter() *mux.Router {
 	protected.HandleFunc("/teams", h.GetUserTeamsHandler).Methods("GET")
 	protected.HandleFunc("/teams", h.CreateTeamHandler).Methods("POST")
 	protected.HandleFunc("/teams/{id}", h.GetTeamHandler).Methods("GET")
-	protected.HandleFunc("/teams/{id}/members", h.InviteTeamMemberHandler).Methods("POST")
-	protected.HandleFunc("/teams/{id}/members/{userId}", h.RemoveTeamMemberHandler).Methods("DELETE")
-	protected.HandleFunc("/teams/{id}/members/{userId}/role", h.UpdateTeamMemberRoleHandler).Methods("PATCH")
+	protected.HandleFunc("/teams/{id}/members", middleware.RequireTeamPermission(domain.PermMembersInvite, h.InviteTeamMemberHandler)).Methods("POST")
+	protected.HandleFunc("/teams/{id}/members/{userId}", middleware.RequireTeamPermission(domain.PermMembersInvite, h.RemoveTeamMemberHandler)).Methods("DELETE")
+	protected.HandleFunc("/teams/{id}/invitations/{inviteId}", middleware.RequireTeamPermission(domain.PermMembersInvite, h.RevokeTeamInvitationHandler)).Methods("DELETE")
+	protected.HandleFunc("/team-invitations/accept", h.AcceptTeamInvitationHandler).Methods("POST")
+	protected.HandleFunc("/teams/{id}/members/{userId}/role", middleware.RequireTeamPermission(domain.PermRolesManage, h.UpdateTeamMemberRoleHandler)).Methods("PATCH")
+	protected.HandleFunc("/teams/{id}/members/{userId}/allowance", middleware.RequireTeamPermission(domain.PermTeamManage, h.SetTeamMemberAllowanceHandler)).Methods("PUT")
+	protected.HandleFunc("/teams/{id}/wallet", middleware.RequireTeamPermission(domain.PermWalletFund, h.GetTeamWalletHandler)).Methods("GET")
+	protected.HandleFunc("/teams/{id}/wallet/topup", middleware.RequireTeamPermission(domain.PermWalletFund, middleware.Idempotent(h.TopUpTeamWalletHandler))).Methods("POST")
+	protected.HandleFunc("/teams/{id}/wallet/fund-card", middleware.RequireTeamPermission(domain.PermWalletFund, middleware.Idempotent(h.FundTeamCardHandler))).Methods("POST")
+	protected.HandleFunc("/teams/{id}/wallet/spend", middleware.RequireTeamPermission(domain.PermReportsExport, h.GetTeamSpendBreakdownHandler)).Methods("GET")
+	protected.HandleFunc("/teams/{id}/cards/reassign", middleware.RequireTeamPermission(domain.PermTeamManage, middleware.Idempotent(h.ReassignTeamCardsHandler))).Methods("POST")
+	protected.HandleFunc("/teams/{id}/cards/transfers", middleware.RequireTeamPermission(domain.PermTeamManage, h.GetTeamCardTransfersHandler)).Methods("GET")
+	protected.HandleFunc("/teams/{id}/roles", middleware.RequireTeamPermission(domain.PermMembersInvite, h.GetTeamRolesHandler)).Methods("GET")
+	protected.HandleFunc("/teams/{id}/roles", middleware.RequireTeamPermission(domain.PermRolesManage, h.CreateTeamRoleHandler)).Methods("POST")
+	protected.HandleFunc("/teams/{id}/roles/{roleId}", middleware.RequireTeamPermission(domain.PermRolesManage, h.UpdateTeamRoleHandler)).Methods("PATCH")
+	protected.HandleFunc("/teams/{id}/roles/{roleId}", middleware.RequireTeamPermission(domain.PermRolesManage, h.DeleteTeamRoleHandler)).Methods("DELETE")
+	protected.HandleFunc("/teams/{id}/spending-rules", middleware.RequireTeamPermission(domain.PermTeamManage, h.GetTeamSpendingRulesHandler)).Methods("GET")
+	protected.HandleFunc("/teams/{id}/spending-rules", middleware.RequireTeamPermission(domain.PermTeamManage, h.CreateTeamSpendingRuleHandler)).Methods("POST")
+	protected.HandleFunc("/teams/{id}/spending-rules/{ruleId}", middleware.RequireTeamPermission(domain.PermTeamManage, h.DeleteTeamSpendingRuleHandler)).Methods("DELETE")
 
 	// Referrals
 	protected.HandleFunc("/referrals", h.GetReferralStatsHandler).Methods("GET")
@@ -775,6 +919,8 @@ func buildRouter() *mux.Router {
 	admin.HandleFunc("/dashboard", h.AdminDashboardStatsHandler).Methods("GET")
 	admin.HandleFunc("/rates", h.AdminGetExchangeRatesHandler).Methods("GET")
 	admin.HandleFunc("/rates/{id}/markup", h.AdminUpdateMarkupHandler).Methods("PATCH")
+	admin.HandleFunc("/rates/snapshots/{id}", h.AdminGetRateSnapshotHandler).Methods("GET")
+	admin.HandleFunc("/fx/quotes", h.AdminGetFXQuotesHandler).Methods("GET")
 	admin.HandleFunc("/report", h.GetAdminTransactionReportHandler).Methods("GET")
 	admin.HandleFunc("/users/search", h.AdminSearchUsersHandler).Methods("GET")
 	admin.HandleFunc("/users/{id}/grade", h.AdminUpdateUserGradeHandler).Methods("PATCH")
@@ -782,6 +928,8 @@ func buildRouter() *mux.Router {
 	admin.HandleFunc("/commissions/{id}", h.AdminUpdateCommissionConfigHandler).Methods("PATCH")
 	admin.HandleFunc("/tickets", h.AdminGetSupportTicketsHandler).Methods("GET")
 	admin.HandleFunc("/tickets/{id}", h.AdminUpdateTicketStatusHandler).Methods("PATCH")
+	admin.HandleFunc("/disputes", h.AdminGetDisputesHandler).Methods("GET")
+	admin.HandleFunc("/disputes/{id}", h.AdminUpdateDisputeHandler).Methods("PATCH")
 	admin.HandleFunc("/users/{id}/full-details", h.AdminUserFullDetailsHandler).Methods("GET")
 	admin.HandleFunc("/users/{id}/emergency-freeze", h.AdminEmergencyFreezeHandler).Methods("POST")
 	admin.HandleFunc("/users/{id}/toggle-block", h.AdminToggleBlockHandler).Methods("POST")
@@ -793,7 +941,17 @@ func buildRouter() *mux.Router {
 	admin.HandleFunc("/translations", h.AdminUpsertTranslationHandler).Methods("PUT")
 	admin.HandleFunc("/translations/{id}", h.AdminDeleteTranslationHandler).Methods("DELETE")
 	admin.HandleFunc("/logs", h.AdminGetLogsHandler).Methods("GET")
+	admin.HandleFunc("/reconciliation", h.AdminGetReconciliationHandler).Methods("GET")
+	admin.HandleFunc("/reconciliation/run", h.AdminRunReconciliationHandler).Methods("POST")
+	admin.HandleFunc("/card-provider-routes", h.AdminGetCardProviderRoutesHandler).Methods("GET")
+	admin.HandleFunc("/card-provider-routes", h.AdminCreateCardProviderRouteHandler).Methods("POST")
+	admin.HandleFunc("/card-provider-routes/{id}", h.AdminUpdateCardProviderRouteHandler).Methods("PATCH")
+	admin.HandleFunc("/card-provider-routes/{id}", h.AdminDeleteCardProviderRouteHandler).Methods("DELETE")
 	admin.HandleFunc("/test-notify", h.AdminTestNotifyHandler).Methods("GET")
+	admin.HandleFunc("/notifications", h.AdminGetNotificationsHandler).Methods("GET")
+	admin.HandleFunc("/notifications/resend-failed", h.AdminResendFailedNotificationsHandler).Methods("POST")
+	admin.HandleFunc("/notifications/{id}/resend", h.AdminResendNotificationHandler).Methods("POST")
+	admin.HandleFunc("/users/{id}/notifications", h.AdminGetUserNotificationDeliveriesHandler).Methods("GET")
 	admin.HandleFunc("/system-settings", h.GetSystemSettingsHandler).Methods("GET")
 	admin.HandleFunc("/system-settings/{key}", h.UpdateSystemSettingHandler).Methods("PATCH")
 	admin.HandleFunc("/news", h.AdminCreateNewsHandler).Methods("POST")
@@ -818,6 +976,16 @@ func buildRouter() *mux.Router {
 	r.HandleFunc("/api/v1/cron/vpn-traffic", h.VPNTrafficCronHandler).Methods("GET")
 	// VPN cleanup cron (called by Vercel cron every 6h: fix 0/0 records, expire keys)
 	r.HandleFunc("/api/v1/cron/vpn-cleanup", h.VPNCleanupCronHandler).Methods("GET")
+	// Nightly balance reconciliation (Vercel cron, protected by CRON_SECRET)
+	r.HandleFunc("/api/v1/cron/reconciliation", h.ReconciliationCronHandler).Methods("GET")
+	// Card issue jobs that were not started or were interrupted (Vercel cron, protected by CRON_SECRET)
+	r.HandleFunc("/api/v1/cron/card-issue-jobs", h.CardIssueJobsCronHandler).Methods("GET")
+	// Release card holds not captured within card_hold_expiry_days (Vercel cron, protected by CRON_SECRET)
+	r.HandleFunc("/api/v1/cron/card-holds", h.CardHoldsCronHandler).Methods("GET")
+	// Queued user notifications (Vercel cron, protected by CRON_SECRET)
+	r.HandleFunc("/api/v1/cron/notifications", h.NotificationOutboxCronHandler).Methods("GET")
+	// Outgoing customer webhooks (Vercel cron, protected by CRON_SECRET)
+	r.HandleFunc("/api/v1/cron/webhooks", h.WebhookDeliveriesCronHandler).Methods("GET")
 	// Also allow admin to trigger manually
 	admin.HandleFunc("/cron/vpn-traffic", h.VPNTrafficCronHandler).Methods("GET", "POST")
 	admin.HandleFunc("/cron/vpn-cleanup", h.VPNCleanupCronHandler).Methods("GET", "POST")
@@ -846,7 +1014,7 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 	if origin != "" {
 		w.Header().Set("Access-Control-Allow-Origin", origin)
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Accept, X-API-Key")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Accept, X-API-Key, Idempotency-Key")
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 		w.Header().Set("Access-Control-Max-Age", "300")
 	}
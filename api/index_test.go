@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/middleware"
+	"github.com/djalben/xplr-core/backend/pkg/utils"
+)
+
+// buyerTeam: карта 10 выпущена для команды 1, где пользователь 2 — buyer (без cards.view_pan).
+type buyerTeam struct{}
+
+func (buyerTeam) MemberPermissions(teamID, userID int) ([]string, bool, error) {
+	if teamID != 1 || userID != 2 {
+		return nil, false, nil
+	}
+	return domain.TeamRoleTemplates["buyer"], true, nil
+}
+
+func (buyerTeam) CardTeamID(cardID int) (int, error) {
+	if cardID == 10 {
+		return 1, nil
+	}
+	return 0, sql.ErrNoRows
+}
+
+func TestCardPANRoutesRequireViewPAN(t *testing.T) {
+	prev := middleware.TeamPermissions
+	middleware.TeamPermissions = buyerTeam{}
+	t.Cleanup(func() { middleware.TeamPermissions = prev })
+
+	token, err := utils.GenerateJWT(2, false, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := buildRouter()
+	for _, path := range []string{"/api/v1/user/cards/10/details", "/api/v1/user/cards/10/mock-details"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s: buyer получил %d, ожидался 403", path, rec.Code)
+		}
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/rs/cors"
 
 	// ВАЖНО: Убедитесь, что пути верны
+	"github.com/djalben/xplr-core/backend/domain"
 	"github.com/djalben/xplr-core/backend/handler"
 	"github.com/djalben/xplr-core/backend/middleware"
 	"github.com/djalben/xplr-core/backend/repository"
@@ -109,6 +110,116 @@ func main() {
 		log.Printf("⚠️ Warning: could not ensure chat tables: %v", err)
 	}
 
+	// Ensure double-entry ledger tables exist
+	if err := repository.EnsureLedgerTables(); err != nil {
+		log.Printf("⚠️ Warning: could not ensure ledger tables: %v", err)
+	}
+
+	// Ensure reconciliation report tables exist
+	if err := repository.EnsureReconciliationTables(); err != nil {
+		log.Printf("⚠️ Warning: could not ensure reconciliation tables: %v", err)
+	}
+
+	// Ensure Idempotency-Key store exists
+	if err := repository.EnsureIdempotencyTable(); err != nil {
+		log.Printf("⚠️ Warning: could not ensure idempotency table: %v", err)
+	}
+
+	// Ensure async card issue job tables exist
+	if err := repository.EnsureCardIssueJobTables(); err != nil {
+		log.Printf("⚠️ Warning: could not ensure card issue job tables: %v", err)
+	}
+
+	// Ensure card provider routing table and cards.provider column exist
+	if err := repository.EnsureCardProviderRouting(); err != nil {
+		log.Printf("⚠️ Warning: could not ensure card provider routing: %v", err)
+	}
+
+	// Ensure spending rules and authorization decline log exist
+	if err := repository.EnsureSpendingRuleTables(); err != nil {
+		log.Printf("⚠️ Warning: could not ensure spending rule tables: %v", err)
+	}
+
+	// Ensure weekly/monthly/hourly velocity limit columns exist
+	if err := repository.EnsureVelocityLimitColumns(); err != nil {
+		log.Printf("⚠️ Warning: could not ensure velocity limit columns: %v", err)
+	}
+
+	// Ensure authorization holds table exists
+	if err := repository.EnsureCardHoldTables(); err != nil {
+		log.Printf("⚠️ Warning: could not ensure card hold tables: %v", err)
+	}
+
+	// Ensure card disputes table and refund links (transactions.original_tx_id) exist
+	if err := repository.EnsureDisputeTables(); err != nil {
+		log.Printf("⚠️ Warning: could not ensure dispute tables: %v", err)
+	}
+
+	// Ensure multi-currency wallets exist and migrate legacy users.balance* columns into them
+	if err := repository.EnsureWalletBalanceTables(); err != nil {
+		log.Printf("⚠️ Warning: could not ensure wallet balance tables: %v", err)
+	}
+
+	// Ensure FX quotes table exists (rate locks for conversions, transactions.fx_quote_id)
+	if err := repository.EnsureFXQuoteTables(); err != nil {
+		log.Printf("⚠️ Warning: could not ensure fx quote tables: %v", err)
+	}
+
+	// Ensure exchange rate history exists (every exchange_rates change, transactions.rate_snapshot_id)
+	if err := repository.EnsureExchangeRateHistoryTables(); err != nil {
+		log.Printf("⚠️ Warning: could not ensure exchange rate history tables: %v", err)
+	}
+
+	// Ensure scheduled transfer tables exist (recurring wallet → card transfer rules and their run log)
+	if err := repository.EnsureScheduledTransferTables(); err != nil {
+		log.Printf("⚠️ Warning: could not ensure scheduled transfer tables: %v", err)
+	}
+
+	// Ensure team wallet tables exist (shared team balance, member allowances, per-member operation log)
+	if err := repository.EnsureTeamWalletTables(); err != nil {
+		log.Printf("⚠️ Warning: could not ensure team wallet tables: %v", err)
+	}
+
+	// Post pre-ledger wallet and card balances to the journal once (OPENING_BALANCE against Suspense)
+	if err := repository.PostLedgerOpeningBalances(); err != nil {
+		log.Printf("⚠️ Warning: could not post ledger opening balances: %v", err)
+	}
+
+	// Ensure team role tables exist (custom team roles and team_members.role_id)
+	if err := repository.EnsureTeamRoleTables(); err != nil {
+		log.Printf("⚠️ Warning: could not ensure team role tables: %v", err)
+	}
+
+	// Ensure team invitation tables exist (emailed invitations with signed tokens)
+	if err := repository.EnsureTeamInvitationTables(); err != nil {
+		log.Printf("⚠️ Warning: could not ensure team invitation tables: %v", err)
+	}
+
+	// Ensure card ownership transfer tables exist (audit log of team cards handed between members)
+	if err := repository.EnsureCardOwnershipTables(); err != nil {
+		log.Printf("⚠️ Warning: could not ensure card ownership tables: %v", err)
+	}
+
+	// Ensure notification outbox tables exist (queued user notifications and their delivery log)
+	if err := repository.EnsureNotificationOutboxTables(); err != nil {
+		log.Printf("⚠️ Warning: could not ensure notification outbox tables: %v", err)
+	}
+
+	// Ensure per-event notification preferences table
+	if err := repository.EnsureNotificationEventPrefTables(); err != nil {
+		log.Printf("⚠️ Warning: could not ensure notification event prefs table: %v", err)
+	}
+
+	// Ensure outgoing webhook tables (customer endpoints, delivery queue and attempt log)
+	if err := repository.EnsureWebhookTables(); err != nil {
+		log.Printf("⚠️ Warning: could not ensure webhook tables: %v", err)
+	}
+
+	// Ensure exchange rate fetcher guard settings (max deviation per hour, staleness alarm)
+	if err := repository.EnsureExchangeRateGuardSettings(); err != nil {
+		log.Printf("⚠️ Warning: could not ensure exchange rate guard settings: %v", err)
+	}
+
 	// Telegram bot token (для реальной отправки уведомлений)
 	// CRITICAL: Сервер НЕ запустится без токена — уведомления обязательны
 	tgToken := os.Getenv("TELEGRAM_BOT_TOKEN")
@@ -132,9 +243,9 @@ func main() {
 	}
 	log.Printf("✅ [INIT] SMTP configured: host=%s, port=%s, user=%s", smtpHost, smtpPort, smtpUser)
 
-	// Initialize card provider (MockProvider by default, ArmeniaProvider if configured)
+	// Initialize card providers (MockProvider always; Armenia/Wallester if configured; routing via card_provider_routes)
 	service.InitCardProvider(repository.GlobalDB)
-	log.Printf("✅ [INIT] Card provider: %s", service.GetCardProvider().GetProviderName())
+	log.Printf("✅ [INIT] Default card provider: %s", service.GetCardProvider().GetProviderName())
 
 	// 1.5. Запуск фонового процесса автопополнения карт
 	go usecase.StartAutoReplenishmentWorker()
@@ -142,6 +253,24 @@ func main() {
 	// 1.7. Запуск cron-задачи: возврат остатков истёкших карт в Кошелёк
 	go usecase.StartExpiryReclaimWorker()
 
+	// 1.8. Ночная сверка балансов Кошельков и карт с таблицей transactions
+	go usecase.StartReconciliationWorker()
+
+	// 1.9. Воркер заданий массового выпуска карт (подхватывает незапущенные и прерванные)
+	go usecase.StartCardIssueJobWorker()
+
+	// 1.10. Освобождение холдов, не подтверждённых эмитентом за card_hold_expiry_days
+	go usecase.StartCardHoldExpiryWorker()
+
+	// 1.11. Переводы из Кошелька на карты по расписанию (cron-правила, повторы при сбоях)
+	go usecase.StartScheduledTransferWorker()
+
+	// 1.12. Отправка уведомлений из notification_outbox (повторы с экспоненциальной паузой, лимиты каналов)
+	go usecase.StartNotificationDispatcher()
+
+	// 1.13. Исходящие вебхуки пользователей (подпись HMAC-SHA256, повторы с экспоненциальной паузой)
+	go usecase.StartWebhookDispatcher()
+
 	// REMOVED: Wallester balance sync - provider interface will handle this
 	// go func() {
 	// 	ticker := time.NewTicker(5 * time.Minute)
@@ -165,6 +294,7 @@ func main() {
 	router.HandleFunc("/api/v1/auth/register", handler.RegisterHandler).Methods("POST")
 	router.HandleFunc("/api/v1/auth/login", handler.LoginHandler).Methods("POST")
 	router.HandleFunc("/api/v1/auth/verify-email", handler.VerifyEmailHandler).Methods("GET")
+	router.HandleFunc("/api/v1/auth/team-invitation", handler.GetTeamInvitationHandler).Methods("GET")
 	router.HandleFunc("/api/v1/auth/resend-verification", handler.ResendVerificationHandler).Methods("POST")
 	// Rate limiter: 5 запросов сброса пароля за 15 минут с одного IP
 	resetLimiter := middleware.NewRateLimiter(5, 15*time.Minute)
@@ -176,6 +306,9 @@ func main() {
 	// Webhooks (public)
 	router.HandleFunc("/api/v1/webhooks/wallester", handler.WallesterWebhookHandler).Methods("POST")
 	router.HandleFunc("/api/v1/webhooks/external-topup", handler.ExternalTopUpWebhookHandler).Methods("POST")
+
+	// Public exchange rate history (daily OHLC)
+	router.HandleFunc("/api/v1/rates/history", handler.PublicGetRateHistoryHandler).Methods("GET")
 	router.HandleFunc("/api/v1/webhooks/sms-receiver", handler.SMSReceiverWebhookHandler).Methods("POST")
 
 	// Telegram Bot Webhook (публичный — Telegram вызывает напрямую)
@@ -211,39 +344,81 @@ func main() {
 	verifiedCards := protectedRouter.PathPrefix("/cards").Subrouter()
 	verifiedCards.Use(middleware.RequireVerifiedEmail)
 	verifiedCards.HandleFunc("", handler.GetUserCardsHandler).Methods("GET")
-	verifiedCards.HandleFunc("/issue", handler.MassIssueCardsHandler).Methods("POST")
-	verifiedCards.HandleFunc("/{id}/status", handler.PatchCardStatusHandler).Methods("PATCH")
+	verifiedCards.HandleFunc("/issue", middleware.RequireBodyTeamPermission(domain.PermCardsIssue, middleware.Idempotent(handler.MassIssueCardsHandler))).Methods("POST")
+	verifiedCards.HandleFunc("/issue-jobs/{id}", handler.GetCardIssueJobHandler).Methods("GET")
+	verifiedCards.HandleFunc("/{id}/status", middleware.RequireCardPermission(domain.PermCardsFreeze, handler.PatchCardStatusHandler)).Methods("PATCH")
 	verifiedCards.HandleFunc("/{id}/auto-replenishment", handler.SetCardAutoReplenishmentHandler).Methods("POST")
 	verifiedCards.HandleFunc("/{id}/auto-replenishment", handler.UnsetCardAutoReplenishmentHandler).Methods("DELETE")
-	verifiedCards.HandleFunc("/{id}/details", handler.GetCardDetailsHandler).Methods("GET")
+	verifiedCards.HandleFunc("/{id}/details", middleware.RequireCardPermission(domain.PermCardsViewPAN, handler.GetCardDetailsHandler)).Methods("GET")
 	verifiedCards.HandleFunc("/{id}/auto-pay", handler.ToggleAutoPayHandler).Methods("PATCH")
 	verifiedCards.HandleFunc("/{id}/subscriptions", handler.CardSubscriptionsHandler).Methods("GET")
 	verifiedCards.HandleFunc("/{id}/subscriptions/{subId}", handler.ToggleSubscriptionHandler).Methods("PATCH")
-	verifiedCards.HandleFunc("/{id}/freeze-all-subscriptions", handler.FreezeAllSubscriptionsHandler).Methods("POST")
+	verifiedCards.HandleFunc("/{id}/freeze-all-subscriptions", middleware.RequireCardPermission(domain.PermCardsFreeze, handler.FreezeAllSubscriptionsHandler)).Methods("POST")
 	verifiedCards.HandleFunc("/{id}/sync-balance", handler.SyncCardBalanceHandler).Methods("POST")
 	verifiedCards.HandleFunc("/{id}/spending-limit", handler.SetSpendingLimitHandler).Methods("PATCH")
+	verifiedCards.HandleFunc("/{id}/spending-rules", handler.GetCardSpendingRulesHandler).Methods("GET")
+	verifiedCards.HandleFunc("/{id}/spending-rules", handler.CreateCardSpendingRuleHandler).Methods("POST")
+	verifiedCards.HandleFunc("/{id}/spending-rules/{ruleId}", handler.DeleteCardSpendingRuleHandler).Methods("DELETE")
+	verifiedCards.HandleFunc("/{id}/declines", handler.GetCardAuthDeclinesHandler).Methods("GET")
+	verifiedCards.HandleFunc("/{id}/velocity-limits", handler.GetCardVelocityLimitsHandler).Methods("GET")
+	verifiedCards.HandleFunc("/{id}/velocity-limits", handler.SetCardVelocityLimitsHandler).Methods("PATCH")
 
 	verifiedWallet := protectedRouter.PathPrefix("/wallet").Subrouter()
 	verifiedWallet.Use(middleware.RequireVerifiedEmail)
 	verifiedWallet.HandleFunc("", handler.GetWalletHandler).Methods("GET")
-	verifiedWallet.HandleFunc("/topup", handler.TopUpWalletHandler).Methods("POST")
+	verifiedWallet.HandleFunc("/topup", middleware.Idempotent(handler.TopUpWalletHandler)).Methods("POST")
 	verifiedWallet.HandleFunc("/auto-topup", handler.SetAutoTopupHandler).Methods("PATCH")
+	verifiedWallet.HandleFunc("/convert", middleware.Idempotent(handler.ConvertWalletHandler)).Methods("POST")
+	protectedRouter.HandleFunc("/wallets", handler.GetWalletsHandler).Methods("GET")
+	protectedRouter.HandleFunc("/fx/quote", handler.CreateFXQuoteHandler).Methods("POST")
+	protectedRouter.HandleFunc("/scheduled-transfers", handler.GetScheduledTransfersHandler).Methods("GET")
+	protectedRouter.HandleFunc("/scheduled-transfers", handler.CreateScheduledTransferHandler).Methods("POST")
+	protectedRouter.HandleFunc("/scheduled-transfers/{id}", handler.UpdateScheduledTransferHandler).Methods("PATCH")
+	protectedRouter.HandleFunc("/scheduled-transfers/{id}", handler.DeleteScheduledTransferHandler).Methods("DELETE")
+	protectedRouter.HandleFunc("/scheduled-transfers/{id}/runs", handler.GetScheduledTransferRunsHandler).Methods("GET")
+	protectedRouter.HandleFunc("/webhooks", handler.GetWebhooksHandler).Methods("GET")
+	protectedRouter.HandleFunc("/webhooks", handler.CreateWebhookHandler).Methods("POST")
+	protectedRouter.HandleFunc("/webhooks/{id}", handler.UpdateWebhookHandler).Methods("PATCH")
+	protectedRouter.HandleFunc("/webhooks/{id}", handler.DeleteWebhookHandler).Methods("DELETE")
+	protectedRouter.HandleFunc("/webhooks/{id}/rotate-secret", handler.RotateWebhookSecretHandler).Methods("POST")
+	protectedRouter.HandleFunc("/webhooks/{id}/test", handler.TestWebhookHandler).Methods("POST")
+	protectedRouter.HandleFunc("/webhooks/{id}/deliveries", handler.GetWebhookDeliveriesHandler).Methods("GET")
+	protectedRouter.HandleFunc("/webhooks/{id}/deliveries/{deliveryId}/attempts", handler.GetWebhookDeliveryAttemptsHandler).Methods("GET")
+	protectedRouter.HandleFunc("/webhooks/{id}/deliveries/{deliveryId}/redeliver", handler.RedeliverWebhookHandler).Methods("POST")
 	protectedRouter.HandleFunc("/settings/auto-replenish", handler.SetAutoTopupHandler).Methods("PATCH")
 	protectedRouter.HandleFunc("/report", handler.GetUserTransactionReportHandler).Methods("GET")
 	protectedRouter.HandleFunc("/transactions", handler.GetUnifiedTransactionsHandler).Methods("GET")
 	protectedRouter.HandleFunc("/transactions/export", handler.ExportTransactionsHandler).Methods("GET")
+	protectedRouter.HandleFunc("/transactions/{id}/dispute", handler.CreateDisputeHandler).Methods("POST")
+	protectedRouter.HandleFunc("/disputes", handler.GetUserDisputesHandler).Methods("GET")
 	protectedRouter.HandleFunc("/dashboard-stats", handler.GetDashboardStatsHandler).Methods("GET")
 	protectedRouter.HandleFunc("/api-key", handler.CreateAPIKeyHandler).Methods("POST")
-	protectedRouter.HandleFunc("/upgrade-tier", handler.UpgradeTierHandler).Methods("POST")
+	protectedRouter.HandleFunc("/upgrade-tier", middleware.Idempotent(handler.UpgradeTierHandler)).Methods("POST")
 	protectedRouter.HandleFunc("/tier-info", handler.GetTierInfoHandler).Methods("GET")
 
 	// Команды (Teams)
 	protectedRouter.HandleFunc("/teams", handler.GetUserTeamsHandler).Methods("GET")
 	protectedRouter.HandleFunc("/teams", handler.CreateTeamHandler).Methods("POST")
 	protectedRouter.HandleFunc("/teams/{id}", handler.GetTeamHandler).Methods("GET")
-	protectedRouter.HandleFunc("/teams/{id}/members", handler.InviteTeamMemberHandler).Methods("POST")
-	protectedRouter.HandleFunc("/teams/{id}/members/{userId}", handler.RemoveTeamMemberHandler).Methods("DELETE")
-	protectedRouter.HandleFunc("/teams/{id}/members/{userId}/role", handler.UpdateTeamMemberRoleHandler).Methods("PATCH")
+	protectedRouter.HandleFunc("/teams/{id}/members", middleware.RequireTeamPermission(domain.PermMembersInvite, handler.InviteTeamMemberHandler)).Methods("POST")
+	protectedRouter.HandleFunc("/teams/{id}/members/{userId}", middleware.RequireTeamPermission(domain.PermMembersInvite, handler.RemoveTeamMemberHandler)).Methods("DELETE")
+	protectedRouter.HandleFunc("/teams/{id}/invitations/{inviteId}", middleware.RequireTeamPermission(domain.PermMembersInvite, handler.RevokeTeamInvitationHandler)).Methods("DELETE")
+	protectedRouter.HandleFunc("/team-invitations/accept", handler.AcceptTeamInvitationHandler).Methods("POST")
+	protectedRouter.HandleFunc("/teams/{id}/members/{userId}/role", middleware.RequireTeamPermission(domain.PermRolesManage, handler.UpdateTeamMemberRoleHandler)).Methods("PATCH")
+	protectedRouter.HandleFunc("/teams/{id}/members/{userId}/allowance", middleware.RequireTeamPermission(domain.PermTeamManage, handler.SetTeamMemberAllowanceHandler)).Methods("PUT")
+	protectedRouter.HandleFunc("/teams/{id}/wallet", middleware.RequireTeamPermission(domain.PermWalletFund, handler.GetTeamWalletHandler)).Methods("GET")
+	protectedRouter.HandleFunc("/teams/{id}/wallet/topup", middleware.RequireTeamPermission(domain.PermWalletFund, middleware.Idempotent(handler.TopUpTeamWalletHandler))).Methods("POST")
+	protectedRouter.HandleFunc("/teams/{id}/wallet/fund-card", middleware.RequireTeamPermission(domain.PermWalletFund, middleware.Idempotent(handler.FundTeamCardHandler))).Methods("POST")
+	protectedRouter.HandleFunc("/teams/{id}/wallet/spend", middleware.RequireTeamPermission(domain.PermReportsExport, handler.GetTeamSpendBreakdownHandler)).Methods("GET")
+	protectedRouter.HandleFunc("/teams/{id}/cards/reassign", middleware.RequireTeamPermission(domain.PermTeamManage, middleware.Idempotent(handler.ReassignTeamCardsHandler))).Methods("POST")
+	protectedRouter.HandleFunc("/teams/{id}/cards/transfers", middleware.RequireTeamPermission(domain.PermTeamManage, handler.GetTeamCardTransfersHandler)).Methods("GET")
+	protectedRouter.HandleFunc("/teams/{id}/roles", middleware.RequireTeamPermission(domain.PermMembersInvite, handler.GetTeamRolesHandler)).Methods("GET")
+	protectedRouter.HandleFunc("/teams/{id}/roles", middleware.RequireTeamPermission(domain.PermRolesManage, handler.CreateTeamRoleHandler)).Methods("POST")
+	protectedRouter.HandleFunc("/teams/{id}/roles/{roleId}", middleware.RequireTeamPermission(domain.PermRolesManage, handler.UpdateTeamRoleHandler)).Methods("PATCH")
+	protectedRouter.HandleFunc("/teams/{id}/roles/{roleId}", middleware.RequireTeamPermission(domain.PermRolesManage, handler.DeleteTeamRoleHandler)).Methods("DELETE")
+	protectedRouter.HandleFunc("/teams/{id}/spending-rules", middleware.RequireTeamPermission(domain.PermTeamManage, handler.GetTeamSpendingRulesHandler)).Methods("GET")
+	protectedRouter.HandleFunc("/teams/{id}/spending-rules", middleware.RequireTeamPermission(domain.PermTeamManage, handler.CreateTeamSpendingRuleHandler)).Methods("POST")
+	protectedRouter.HandleFunc("/teams/{id}/spending-rules/{ruleId}", middleware.RequireTeamPermission(domain.PermTeamManage, handler.DeleteTeamSpendingRuleHandler)).Methods("DELETE")
 
 	// Реферальная программа
 	protectedRouter.HandleFunc("/referrals", handler.GetReferralStatsHandler).Methods("GET")
@@ -296,6 +471,8 @@ func main() {
 	adminRouter.HandleFunc("/dashboard", handler.AdminDashboardStatsHandler).Methods("GET")
 	adminRouter.HandleFunc("/rates", handler.AdminGetExchangeRatesHandler).Methods("GET")
 	adminRouter.HandleFunc("/rates/{id}/markup", handler.AdminUpdateMarkupHandler).Methods("PATCH")
+	adminRouter.HandleFunc("/rates/snapshots/{id}", handler.AdminGetRateSnapshotHandler).Methods("GET")
+	adminRouter.HandleFunc("/fx/quotes", handler.AdminGetFXQuotesHandler).Methods("GET")
 	adminRouter.HandleFunc("/report", handler.GetAdminTransactionReportHandler).Methods("GET")
 	adminRouter.HandleFunc("/users/search", handler.AdminSearchUsersHandler).Methods("GET")
 	adminRouter.HandleFunc("/users/{id}/grade", handler.AdminUpdateUserGradeHandler).Methods("PATCH")
@@ -303,6 +480,8 @@ func main() {
 	adminRouter.HandleFunc("/commissions/{id}", handler.AdminUpdateCommissionConfigHandler).Methods("PATCH")
 	adminRouter.HandleFunc("/tickets", handler.AdminGetSupportTicketsHandler).Methods("GET")
 	adminRouter.HandleFunc("/tickets/{id}", handler.AdminUpdateTicketStatusHandler).Methods("PATCH")
+	adminRouter.HandleFunc("/disputes", handler.AdminGetDisputesHandler).Methods("GET")
+	adminRouter.HandleFunc("/disputes/{id}", handler.AdminUpdateDisputeHandler).Methods("PATCH")
 	adminRouter.HandleFunc("/users/{id}/full-details", handler.AdminUserFullDetailsHandler).Methods("GET")
 	adminRouter.HandleFunc("/users/{id}/emergency-freeze", handler.AdminEmergencyFreezeHandler).Methods("POST")
 	adminRouter.HandleFunc("/users/{id}/toggle-block", handler.AdminToggleBlockHandler).Methods("POST")
@@ -314,7 +493,17 @@ func main() {
 	adminRouter.HandleFunc("/translations", handler.AdminUpsertTranslationHandler).Methods("PUT")
 	adminRouter.HandleFunc("/translations/{id}", handler.AdminDeleteTranslationHandler).Methods("DELETE")
 	adminRouter.HandleFunc("/logs", handler.AdminGetLogsHandler).Methods("GET")
+	adminRouter.HandleFunc("/reconciliation", handler.AdminGetReconciliationHandler).Methods("GET")
+	adminRouter.HandleFunc("/reconciliation/run", handler.AdminRunReconciliationHandler).Methods("POST")
+	adminRouter.HandleFunc("/card-provider-routes", handler.AdminGetCardProviderRoutesHandler).Methods("GET")
+	adminRouter.HandleFunc("/card-provider-routes", handler.AdminCreateCardProviderRouteHandler).Methods("POST")
+	adminRouter.HandleFunc("/card-provider-routes/{id}", handler.AdminUpdateCardProviderRouteHandler).Methods("PATCH")
+	adminRouter.HandleFunc("/card-provider-routes/{id}", handler.AdminDeleteCardProviderRouteHandler).Methods("DELETE")
 	adminRouter.HandleFunc("/test-notify", handler.AdminTestNotifyHandler).Methods("GET")
+	adminRouter.HandleFunc("/notifications", handler.AdminGetNotificationsHandler).Methods("GET")
+	adminRouter.HandleFunc("/notifications/resend-failed", handler.AdminResendFailedNotificationsHandler).Methods("POST")
+	adminRouter.HandleFunc("/notifications/{id}/resend", handler.AdminResendNotificationHandler).Methods("POST")
+	adminRouter.HandleFunc("/users/{id}/notifications", handler.AdminGetUserNotificationDeliveriesHandler).Methods("GET")
 	adminRouter.HandleFunc("/system-settings", handler.GetSystemSettingsHandler).Methods("GET")
 	adminRouter.HandleFunc("/system-settings/{key}", handler.UpdateSystemSettingHandler).Methods("PATCH")
 	adminRouter.HandleFunc("/infra/balance", handler.GetAezaBalanceHandler).Methods("GET")
@@ -353,7 +542,7 @@ func main() {
 	corsHandler := cors.New(cors.Options{
 		AllowedOrigins:   origins,
 		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Authorization", "Content-Type", "Accept"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type", "Accept", "Idempotency-Key"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}).Handler(router)
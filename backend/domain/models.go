@@ -14,6 +14,7 @@ type RegisterRequest struct {
 	Email        string `json:"email"`
 	Password     string `json:"password"`
 	ReferralCode string `json:"referral_code,omitempty"` // Опционально
+	InviteToken  string `json:"invite_token,omitempty"`  // Токен приглашения в команду (регистрация по приглашению)
 }
 
 // LoginRequest - Запрос на вход пользователя
@@ -32,31 +33,30 @@ type AuthRequest struct {
 	CardID       int             `json:"card_id"`
 	Amount       decimal.Decimal `json:"amount"`
 	MerchantName string          `json:"merchant_name"`
+	MCC          string          `json:"mcc,omitempty"`
+	Country      string          `json:"merchant_country,omitempty"`
 }
 
 // --- СТРУКТУРЫ ПОЛЬЗОВАТЕЛЕЙ И АУТЕНТИФИКАЦИИ ---
 
 // User - Структура для пользователя
-// В Supabase: id (UUID), email, password_hash, balance_rub (numeric), active_mode
+// В Supabase: id (UUID), email, password_hash, active_mode
 // Для совместимости с кодом используем int для ID (конвертация UUID -> int при необходимости)
+// Деньги пользователя хранятся в Кошельках (InternalBalance, WalletBalance), а не в users.
 type User struct {
-	ID               int             `json:"id"` // В Supabase: UUID (конвертируется)
-	Email            string          `json:"email"`
-	PasswordHash     string          `json:"-"`                 // password_hash в Supabase
-	Balance          decimal.Decimal `json:"balance"`           // Legacy поле (суммарный USD)
-	BalanceRub       decimal.Decimal `json:"balance_rub"`       // Основной баланс в рублях
-	BalanceArbitrage decimal.Decimal `json:"balance_arbitrage"` // Арбитражный кошелёк (USD)
-	BalancePersonal  decimal.Decimal `json:"balance_personal"`  // Личный кошелёк (USD)
-	KYCStatus        string          `json:"kyc_status"`        // Статус верификации
-	ActiveMode       string          `json:"active_mode"`       // Режим работы: 'personal' | 'professional'
-	CreatedAt        time.Time       `json:"created_at"`
-	Status           string          `json:"status"`
-	TeamID           sql.NullInt64   `json:"team_id"`
-	TelegramChatID   sql.NullInt64   `json:"telegram_chat_id"`
-	IsAdmin          bool            `json:"is_admin"`
-	IsVerified       bool            `json:"is_verified"`
-	Role             string          `json:"role"`
-	DisplayName      string          `json:"display_name"`
+	ID             int           `json:"id"` // В Supabase: UUID (конвертируется)
+	Email          string        `json:"email"`
+	PasswordHash   string        `json:"-"`           // password_hash в Supabase
+	KYCStatus      string        `json:"kyc_status"`  // Статус верификации
+	ActiveMode     string        `json:"active_mode"` // Режим работы: 'personal' | 'professional'
+	CreatedAt      time.Time     `json:"created_at"`
+	Status         string        `json:"status"`
+	TeamID         sql.NullInt64 `json:"team_id"`
+	TelegramChatID sql.NullInt64 `json:"telegram_chat_id"`
+	IsAdmin        bool          `json:"is_admin"`
+	IsVerified     bool          `json:"is_verified"`
+	Role           string        `json:"role"`
+	DisplayName    string        `json:"display_name"`
 }
 
 // VerificationToken — токен подтверждения email
@@ -109,10 +109,12 @@ type Transaction struct {
 
 // AuthResponse - Ответ от логики authorizeCard
 type AuthResponse struct {
-	Success bool            `json:"success"`
-	Status  string          `json:"status"`
-	Message string          `json:"message"`
-	Fee     decimal.Decimal `json:"fee"`
+	Success       bool            `json:"success"`
+	Status        string          `json:"status"`
+	Message       string          `json:"message"`
+	Fee           decimal.Decimal `json:"fee"`
+	DeclineCode   string          `json:"decline_code,omitempty"`   // 'velocity_daily', 'velocity_hourly_count', 'spending_rule', ...
+	DeclineReason string          `json:"decline_reason,omitempty"` // Причина отказа для пользователя
 }
 
 // --- СТРУКТУРЫ УПРАВЛЕНИЯ КАРТАМИ ---
@@ -142,7 +144,7 @@ type Card struct {
 	AutoReplenishEnabled   bool            `json:"auto_replenish_enabled"`
 	AutoReplenishThreshold decimal.Decimal `json:"auto_replenish_threshold"`
 	AutoReplenishAmount    decimal.Decimal `json:"auto_replenish_amount"`
-	CardBalance            decimal.Decimal `json:"card_balance"`          // Виртуальный баланс: при списке карт = BalanceRub пользователя
+	CardBalance            decimal.Decimal `json:"card_balance"`          // Баланс карты (cards.card_balance), в валюте карты
 	SpendingLimit          decimal.Decimal `json:"spending_limit"`        // Макс. сумма, которую карта может потратить из Кошелька
 	SpentFromWallet        decimal.Decimal `json:"spent_from_wallet"`     // Сколько карта реально потратила из Кошелька
 	ExpiryDate             *time.Time      `json:"expiry_date,omitempty"` // Дата истечения срока карты
@@ -156,21 +158,130 @@ type Card struct {
 type InternalBalance struct {
 	ID               int             `json:"id"`
 	UserID           int             `json:"user_id"`
-	MasterBalance    decimal.Decimal `json:"master_balance"`
+	MasterBalance    decimal.Decimal `json:"master_balance"`    // Проведённый (ledger) баланс
+	HeldBalance      decimal.Decimal `json:"held_balance"`      // Заблокировано активными холдами по картам
+	AvailableBalance decimal.Decimal `json:"available_balance"` // master_balance − held_balance
 	AutoTopupEnabled bool            `json:"auto_topup_enabled"`
 	UpdatedAt        time.Time       `json:"updated_at"`
 }
 
+// WalletBalance - Кошелёк пользователя в одной валюте (USD, EUR, RUB, USDT).
+// USD — основной Кошелёк (internal_balances), остальные — wallet_balances.
+type WalletBalance struct {
+	Currency         string          `json:"currency"`
+	Balance          decimal.Decimal `json:"balance"`
+	HeldBalance      decimal.Decimal `json:"held_balance"`      // Холды по картам есть только у USD
+	AvailableBalance decimal.Decimal `json:"available_balance"` // balance − held_balance
+	UpdatedAt        *time.Time      `json:"updated_at,omitempty"`
+}
+
+// FXConversion - Конвертация между Кошельками пользователя (пара транзакций FX_SELL / FX_BUY)
+type FXConversion struct {
+	SellTxID       int             `json:"sell_tx_id"`
+	BuyTxID        int             `json:"buy_tx_id"`
+	FromCurrency   string          `json:"from_currency"`
+	ToCurrency     string          `json:"to_currency"`
+	FromAmount     decimal.Decimal `json:"from_amount"`
+	ToAmount       decimal.Decimal `json:"to_amount"`
+	Rate           decimal.Decimal `json:"rate"` // Сколько to_currency за 1 from_currency
+	QuoteID        *string         `json:"quote_id,omitempty"`
+	RateSnapshotID *int64          `json:"rate_snapshot_id,omitempty"` // exchange_rate_history.id — состояние курсов на момент конвертации
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// FXQuote - Зафиксированный курс конвертации from → to, действует до ExpiresAt и используется один раз
+type FXQuote struct {
+	ID             string          `json:"quote_id"`
+	UserID         int             `json:"user_id"`
+	FromCurrency   string          `json:"from_currency"`
+	ToCurrency     string          `json:"to_currency"`
+	BaseRate       decimal.Decimal `json:"base_rate"`      // Кросс-курс по exchange_rates.base_rate (без наценки)
+	MarkupPercent  decimal.Decimal `json:"markup_percent"` // Маржа платформы: (base_rate - rate) / base_rate * 100
+	Rate           decimal.Decimal `json:"rate"`           // Сколько to_currency за 1 from_currency для клиента
+	ExpiresAt      time.Time       `json:"expires_at"`
+	UsedAt         *time.Time      `json:"used_at,omitempty"`
+	TransactionID  *int            `json:"transaction_id,omitempty"`
+	RateSnapshotID *int64          `json:"rate_snapshot_id,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// RatePoint - Запись exchange_rate_history: состояние курса пары после одного обновления
+type RatePoint struct {
+	ID            int64           `json:"id"`
+	CurrencyFrom  string          `json:"currency_from"`
+	CurrencyTo    string          `json:"currency_to"`
+	Source        string          `json:"source"` // "fetcher:cbr,ecb", "admin:12", "seed", "baseline"
+	BaseRate      decimal.Decimal `json:"base_rate"`
+	MarkupPercent decimal.Decimal `json:"markup_percent"`
+	FinalRate     decimal.Decimal `json:"final_rate"`
+	RecordedAt    time.Time       `json:"recorded_at"`
+}
+
+// RateCandle - Дневная OHLC-свеча final_rate пары (Updates = 0 — курс за день не менялся)
+type RateCandle struct {
+	Date    string          `json:"date"`
+	Open    decimal.Decimal `json:"open"`
+	High    decimal.Decimal `json:"high"`
+	Low     decimal.Decimal `json:"low"`
+	Close   decimal.Decimal `json:"close"`
+	Updates int             `json:"updates"`
+}
+
+// Статусы холда (CardHold.Status)
+const (
+	HoldActive   = "ACTIVE"   // Средства зарезервированы
+	HoldCaptured = "CAPTURED" // Списание проведено (полностью или частично, остаток освобождён)
+	HoldReleased = "RELEASED" // Отменено эмитентом (reversal)
+	HoldExpired  = "EXPIRED"  // Освобождено автоматически по сроку
+)
+
+// CardHold - Резерв средств Кошелька по авторизации карты до её списания (capture) или отмены
+type CardHold struct {
+	ID             int             `json:"id"`
+	UserID         int             `json:"user_id"`
+	CardID         int             `json:"card_id"`
+	Amount         decimal.Decimal `json:"amount"` // Текущая сумма резерва (уменьшается частичным reversal)
+	MerchantName   string          `json:"merchant_name"`
+	ProviderTxID   string          `json:"provider_tx_id"` // ID авторизации у эмитента
+	Status         string          `json:"status"`
+	CapturedAmount decimal.Decimal `json:"captured_amount"`
+	TransactionID  *int            `json:"transaction_id,omitempty"` // CAPTURE-транзакция
+	CreatedAt      time.Time       `json:"created_at"`
+	SettledAt      *time.Time      `json:"settled_at,omitempty"`
+}
+
 // SpendingLimitRequest - Запрос на установку лимита списания карты
 type SpendingLimitRequest struct {
 	SpendingLimit decimal.Decimal `json:"spending_limit"`
 }
 
-// TopUpWalletRequest - Запрос на пополнение Кошелька
+// VelocityLimits - Скользящие лимиты карты. Ноль означает «без лимита».
+// Daily хранится в cards.daily_spend_limit, остальные — в отдельных колонках cards.
+type VelocityLimits struct {
+	Daily        decimal.Decimal `json:"daily"`   // Сумма списаний за последние 24 часа
+	Weekly       decimal.Decimal `json:"weekly"`  // ... за последние 7 дней
+	Monthly      decimal.Decimal `json:"monthly"` // ... за последние 30 дней
+	MaxTxPerHour int             `json:"max_tx_per_hour"`
+}
+
+// VelocityUsage - Фактические списания по карте в тех же окнах
+type VelocityUsage struct {
+	Day        decimal.Decimal `json:"day"`
+	Week       decimal.Decimal `json:"week"`
+	Month      decimal.Decimal `json:"month"`
+	TxLastHour int             `json:"tx_last_hour"`
+}
+
+// TopUpWalletRequest - Запрос на пополнение Кошелька (сумма в рублях)
 type TopUpWalletRequest struct {
-	Amount decimal.Decimal `json:"amount"`
+	Amount   decimal.Decimal `json:"amount"`
+	Currency string          `json:"currency"` // Кошелёк зачисления: "USD" (по умолчанию, с конвертацией) или "RUB"
+	QuoteID  string          `json:"quote_id"` // Котировка RUB → USD из POST /user/fx/quote (необязательно)
 }
 
+// MassIssueMaxCount - Сколько карт можно выпустить одним заданием массового выпуска
+const MassIssueMaxCount = 200
+
 // MassIssueRequest - Запрос на массовый выпуск карт
 type MassIssueRequest struct {
 	Count        int             `json:"count"`
@@ -195,6 +306,110 @@ type CardIssueResult struct {
 	Nickname  string `json:"nickname"`
 }
 
+// CardIssueJob - Асинхронное задание на массовый выпуск карт
+type CardIssueJob struct {
+	ID         int               `json:"id"`
+	UserID     int               `json:"user_id"`
+	Status     string            `json:"status"` // 'PENDING', 'RUNNING', 'COMPLETED', 'FAILED'
+	Request    MassIssueRequest  `json:"request"`
+	FeePerCard decimal.Decimal   `json:"fee_per_card"`
+	FeeTeamID  *int              `json:"fee_team_id,omitempty"` // Комиссия списана с Кошелька этой команды (nil — с Кошелька пользователя)
+	Total      int               `json:"total"`
+	Succeeded  int               `json:"succeeded"`
+	Failed     int               `json:"failed"`
+	Refunded   decimal.Decimal   `json:"refunded"` // Возвращённая комиссия за неудачные карты
+	Results    []CardIssueResult `json:"results"`  // По одной записи на обработанную карту, в порядке выпуска
+	CreatedAt  time.Time         `json:"created_at"`
+	StartedAt  *time.Time        `json:"started_at,omitempty"`
+	FinishedAt *time.Time        `json:"finished_at,omitempty"`
+}
+
+// CardProviderRoute - Правило выбора эмитента при выпуске карты.
+// Пустое значение или "*" в Category/Currency/CardType совпадает с любым.
+type CardProviderRoute struct {
+	ID        int       `json:"id"`
+	Category  string    `json:"category"`  // 'arbitrage', 'travel', 'services' или '*'
+	Currency  string    `json:"currency"`  // 'USD', 'EUR' или '*'
+	CardType  string    `json:"card_type"` // 'VISA', 'MasterCard' или '*'
+	Provider  string    `json:"provider"`  // 'mock', 'wallester', 'armenia'
+	Priority  int       `json:"priority"`  // При равной точности побеждает больший приоритет
+	IsActive  bool      `json:"is_active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Типы правил расходов (SpendingRule.RuleType)
+const (
+	RuleMerchantAllow = "merchant_allow" // Value — шаблон названия мерчанта ('*' — любые символы)
+	RuleMerchantDeny  = "merchant_deny"
+	RuleMCCAllow      = "mcc_allow" // Value — список MCC и диапазонов: "5411, 5960-5969"
+	RuleMCCDeny       = "mcc_deny"
+	RuleMerchantCap   = "merchant_cap"  // Value — шаблон мерчанта, MonthlyCap — лимит за календарный месяц
+	RuleCountryAllow  = "country_allow" // Value — ISO-коды стран: "DE, FR"
+	RuleCountryDeny   = "country_deny"
+	RuleTimeWindow    = "time_window" // Value — "09:00-18:00" в часовом поясе Timezone
+)
+
+// SpendingRule - Правило расходов для карты (CardID) или для всех карт команды (TeamID)
+type SpendingRule struct {
+	ID         int             `json:"id"`
+	CardID     *int            `json:"card_id,omitempty"`
+	TeamID     *int            `json:"team_id,omitempty"`
+	RuleType   string          `json:"rule_type"`
+	Value      string          `json:"value"`
+	MonthlyCap decimal.Decimal `json:"monthly_cap"`
+	Timezone   string          `json:"timezone,omitempty"`
+	IsActive   bool            `json:"is_active"`
+	CreatedBy  int             `json:"created_by"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// AuthorizationDecline - Запись об отклонённой авторизации и правиле, которое её отклонило
+type AuthorizationDecline struct {
+	ID           int             `json:"id"`
+	CardID       int             `json:"card_id"`
+	UserID       int             `json:"user_id"`
+	RuleID       *int            `json:"rule_id,omitempty"`
+	Reason       string          `json:"reason"`
+	MerchantName string          `json:"merchant_name"`
+	MCC          string          `json:"mcc,omitempty"`
+	Country      string          `json:"country,omitempty"`
+	Amount       decimal.Decimal `json:"amount"`
+	Source       string          `json:"source"` // 'authorize' или 'wallester'
+	CreatedAt    time.Time       `json:"created_at"`
+}
+
+// Статусы спора по транзакции (Dispute.Status)
+const (
+	DisputeOpen      = "OPEN"      // Открыт пользователем, ждёт администратора
+	DisputeInReview  = "IN_REVIEW" // Администратор или эмитент разбирает спор
+	DisputeWon       = "WON"       // Средства возвращены (chargeback, возврат мерчанта или решение администратора)
+	DisputeLost      = "LOST"      // Отказано
+	DisputeCancelled = "CANCELLED" // Отозван пользователем
+)
+
+// Dispute - Спор пользователя по списанию с карты
+type Dispute struct {
+	ID            int             `json:"id"`
+	UserID        int             `json:"user_id"`
+	UserEmail     string          `json:"user_email,omitempty"`
+	CardID        *int            `json:"card_id,omitempty"`
+	CardLast4     string          `json:"card_last_4_digits,omitempty"`
+	TransactionID int             `json:"transaction_id"` // Оспариваемое списание
+	ProviderTxID  string          `json:"provider_tx_id,omitempty"`
+	MerchantName  string          `json:"merchant_name"`
+	Amount        decimal.Decimal `json:"amount"` // Оспариваемая сумма (может быть меньше списания)
+	Reason        string          `json:"reason"`
+	Description   string          `json:"description"`
+	Status        string          `json:"status"`
+	AdminComment  string          `json:"admin_comment,omitempty"`
+	ResolvedBy    *int            `json:"resolved_by,omitempty"`
+	RefundTxID    *int            `json:"refund_tx_id,omitempty"` // Транзакция возврата средств
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+	ResolvedAt    *time.Time      `json:"resolved_at,omitempty"`
+}
+
 // MassIssueResponse - Ответ на массовый выпуск карт
 type MassIssueResponse struct {
 	Successful int               `json:"successful_count"`
@@ -224,13 +439,15 @@ type Team struct {
 
 // TeamMember - Участник команды
 type TeamMember struct {
-	ID        int       `json:"id"`
-	TeamID    int       `json:"team_id"`
-	UserID    int       `json:"user_id"`
-	Role      string    `json:"role"` // 'owner', 'admin', 'member'
-	InvitedBy *int      `json:"invited_by,omitempty"`
-	JoinedAt  time.Time `json:"joined_at"`
-	User      *User     `json:"user,omitempty"` // Для деталей пользователя
+	ID          int       `json:"id"`
+	TeamID      int       `json:"team_id"`
+	UserID      int       `json:"user_id"`
+	Role        string    `json:"role"`              // Шаблон роли: 'owner', 'admin', 'member', 'buyer', 'finance'
+	RoleID      *int      `json:"role_id,omitempty"` // Кастомная роль команды (team_roles), если назначена
+	Permissions []string  `json:"permissions"`       // Итоговые права участника
+	InvitedBy   *int      `json:"invited_by,omitempty"`
+	JoinedAt    time.Time `json:"joined_at"`
+	User        *User     `json:"user,omitempty"` // Для деталей пользователя
 }
 
 // CreateTeamRequest - Запрос на создание команды
@@ -240,13 +457,150 @@ type CreateTeamRequest struct {
 
 // InviteTeamMemberRequest - Запрос на приглашение участника
 type InviteTeamMemberRequest struct {
-	Email string `json:"email"`
-	Role  string `json:"role"` // 'admin' или 'member'
+	Email  string `json:"email"`
+	Role   string `json:"role"`              // Шаблон роли: 'admin', 'member', 'buyer', 'finance'
+	RoleID *int   `json:"role_id,omitempty"` // Или кастомная роль команды
 }
 
 // UpdateTeamMemberRoleRequest - Запрос на изменение роли
 type UpdateTeamMemberRoleRequest struct {
-	Role string `json:"role"`
+	Role   string `json:"role"`              // Шаблон роли
+	RoleID *int   `json:"role_id,omitempty"` // Или кастомная роль команды
+}
+
+// Права участника команды
+const (
+	PermCardsIssue    = "cards.issue"    // Выпуск карт команды
+	PermCardsFreeze   = "cards.freeze"   // Заморозка, блокировка и закрытие карт
+	PermCardsViewPAN  = "cards.view_pan" // Просмотр полного номера карты и CVV
+	PermWalletFund    = "wallet.fund"    // Пополнение Кошелька команды и карт из него
+	PermMembersInvite = "members.invite" // Приглашение и удаление участников
+	PermReportsExport = "reports.export" // Финансовые отчёты и выгрузки команды
+	PermTeamManage    = "team.manage"    // Лимиты участников, правила трат, передача и пополнение чужих карт
+	PermRolesManage   = "roles.manage"   // Кастомные роли и смена ролей участников
+)
+
+// TeamPermissions - Все права, которые можно выдать роли
+var TeamPermissions = []string{
+	PermCardsIssue, PermCardsFreeze, PermCardsViewPAN, PermWalletFund, PermMembersInvite, PermReportsExport,
+	PermTeamManage, PermRolesManage,
+}
+
+// TeamRoleTemplates - Встроенные шаблоны ролей. buyer выпускает и пополняет карты,
+// но не видит полные номера и не выгружает финансы. admin управляет командой, но не ролями.
+var TeamRoleTemplates = map[string][]string{
+	"owner": TeamPermissions,
+	"admin": {PermCardsIssue, PermCardsFreeze, PermCardsViewPAN, PermWalletFund, PermMembersInvite,
+		PermReportsExport, PermTeamManage},
+	"member":  {PermCardsIssue, PermCardsFreeze, PermCardsViewPAN, PermWalletFund},
+	"buyer":   {PermCardsIssue, PermCardsFreeze, PermWalletFund},
+	"finance": {PermWalletFund, PermReportsExport},
+}
+
+// Что делать с остатком карты при передаче владения (CardOwnershipTransfer.BalanceMode)
+const (
+	CardTransferKeepBalance         = "keep"        // Остаток остаётся на карте и переходит новому владельцу
+	CardTransferBalanceToTeamWallet = "team_wallet" // Остаток возвращается в Кошелёк команды
+)
+
+// CardOwnershipTransfer - Запись журнала передачи карты команды другому участнику
+type CardOwnershipTransfer struct {
+	ID            int             `json:"id"`
+	CardID        int             `json:"card_id"`
+	Last4Digits   string          `json:"last_4_digits"`
+	TeamID        int             `json:"team_id"`
+	FromUserID    int             `json:"from_user_id"`
+	ToUserID      int             `json:"to_user_id"`
+	PerformedBy   int             `json:"performed_by"`
+	Balance       decimal.Decimal `json:"balance"` // Остаток карты на момент передачи
+	Currency      string          `json:"currency"`
+	BalanceMode   string          `json:"balance_mode"`
+	TransactionID *int            `json:"transaction_id,omitempty"` // Возврат остатка в Кошелёк команды
+	Reason        string          `json:"reason,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+// Статусы приглашения в команду (TeamInvitation.Status)
+const (
+	TeamInvitationPending  = "PENDING"
+	TeamInvitationAccepted = "ACCEPTED"
+	TeamInvitationRevoked  = "REVOKED"
+	TeamInvitationExpired  = "EXPIRED"
+)
+
+// TeamInvitation - Приглашение в команду по email. Токен из письма в БД не хранится — только его хеш.
+type TeamInvitation struct {
+	ID         int        `json:"id"`
+	TeamID     int        `json:"team_id"`
+	TeamName   string     `json:"team_name,omitempty"`
+	Email      string     `json:"email"`
+	Role       string     `json:"role"`
+	RoleID     *int       `json:"role_id,omitempty"`
+	InvitedBy  int        `json:"invited_by"`
+	Status     string     `json:"status"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	AcceptedBy *int       `json:"accepted_by,omitempty"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// TeamRole - Кастомная роль команды с набором прав
+type TeamRole struct {
+	ID          int       `json:"id"`
+	TeamID      int       `json:"team_id"`
+	Name        string    `json:"name"`
+	Permissions []string  `json:"permissions"`
+	CreatedBy   int       `json:"created_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Операции общего Кошелька команды (TeamWalletOperation.Operation)
+const (
+	TeamWalletTopUp        = "TOPUP"          // Участник перевёл деньги из своего Кошелька
+	TeamWalletCardFund     = "CARD_FUND"      // Пополнение карты команды
+	TeamWalletCardIssueFee = "CARD_ISSUE_FEE" // Комиссия за выпуск карты команды
+	TeamWalletFeeRefund    = "FEE_REFUND"     // Возврат комиссии за выпуск
+	TeamWalletCardReclaim  = "CARD_RECLAIM"   // Остаток карты возвращён в Кошелёк команды при передаче карты
+)
+
+// TeamMemberAllowance - Лимиты участника на траты из Кошелька команды.
+// MonthlyLimit — сколько участник может потратить за календарный месяц (UTC),
+// PerCardLimit — сколько всего можно перевести из Кошелька команды на одну карту. nil — без лимита.
+type TeamMemberAllowance struct {
+	TeamID       int              `json:"team_id"`
+	UserID       int              `json:"user_id"`
+	Email        string           `json:"email,omitempty"`
+	Currency     string           `json:"currency"` // Валюта лимитов; траты в других валютах пересчитываются по CrossRate
+	MonthlyLimit *decimal.Decimal `json:"monthly_limit"`
+	PerCardLimit *decimal.Decimal `json:"per_card_limit"`
+	MonthSpent   decimal.Decimal  `json:"month_spent"` // Потрачено в текущем месяце, в Currency
+	UpdatedBy    int              `json:"updated_by,omitempty"`
+	UpdatedAt    time.Time        `json:"updated_at"`
+}
+
+// TeamWalletOperation - Движение по Кошельку команды с указанием участника
+type TeamWalletOperation struct {
+	ID            int             `json:"id"`
+	TeamID        int             `json:"team_id"`
+	UserID        int             `json:"user_id"`
+	CardID        *int            `json:"card_id,omitempty"`
+	Operation     string          `json:"operation"`
+	Amount        decimal.Decimal `json:"amount"`
+	Currency      string          `json:"currency"`
+	TransactionID int             `json:"transaction_id"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+// TeamMemberSpend - Траты участника из Кошелька команды за период в одной валюте
+type TeamMemberSpend struct {
+	UserID      int             `json:"user_id"`
+	Email       string          `json:"email"`
+	Currency    string          `json:"currency"`
+	CardFunding decimal.Decimal `json:"card_funding"` // Пополнения карт
+	IssueFees   decimal.Decimal `json:"issue_fees"`   // Комиссии за выпуск за вычетом возвратов
+	Total       decimal.Decimal `json:"total"`
+	TopUps      decimal.Decimal `json:"top_ups"` // Сколько участник внёс в Кошелёк команды
+	CardsFunded int             `json:"cards_funded"`
 }
 
 // --- СТРУКТУРЫ GRADE СИСТЕМЫ ---
@@ -290,3 +644,310 @@ type ReferralStats struct {
 	TotalCommission decimal.Decimal `json:"total_commission"`
 	ReferralCode    string          `json:"referral_code"`
 }
+
+// --- СВЕРКА БАЛАНСОВ ---
+
+// ReconciliationRun - Один прогон сверки балансов с таблицей transactions
+type ReconciliationRun struct {
+	ID             int        `json:"id"`
+	StartedAt      time.Time  `json:"started_at"`
+	FinishedAt     *time.Time `json:"finished_at,omitempty"`
+	WalletsChecked int        `json:"wallets_checked"`
+	CardsChecked   int        `json:"cards_checked"`
+	DriftCount     int        `json:"drift_count"`
+}
+
+// ReconciliationDrift - Расхождение между сохранённым и пересчитанным балансом
+type ReconciliationDrift struct {
+	ID       int             `json:"id"`
+	RunID    int             `json:"run_id"`
+	Kind     string          `json:"kind"` // 'wallet' (USD), 'wallet_eur' и т.п., 'card_balance', 'card_spent'; 'ledger_*' — против журнала
+	UserID   int             `json:"user_id"`
+	CardID   int             `json:"card_id,omitempty"`
+	Expected decimal.Decimal `json:"expected"`
+	Actual   decimal.Decimal `json:"actual"`
+	Drift    decimal.Decimal `json:"drift"` // actual - expected
+}
+
+// --- ПЕРЕВОДЫ ПО РАСПИСАНИЮ ---
+
+// Статусы выполнения перевода по расписанию (ScheduledTransfer.LastStatus, ScheduledTransferRun.Status)
+const (
+	ScheduledTransferSuccess = "SUCCESS"
+	ScheduledTransferPartial = "PARTIAL" // Часть карт команды не пополнена, будет повтор
+	ScheduledTransferRetry   = "RETRY"   // Ошибка, повтор запланирован
+	ScheduledTransferFailed  = "FAILED"  // Попытки исчерпаны, ждём следующего срока
+)
+
+// ScheduledTransfer - Правило перевода из Кошелька на карту (CardID) или поровну на карты команды (TeamID)
+// по расписанию cron: "0 9 * * 1" — каждый понедельник в 9:00, "0 9 1 * *" — 1-го числа.
+type ScheduledTransfer struct {
+	ID            int             `json:"id"`
+	UserID        int             `json:"user_id"` // Чей Кошелёк списывается
+	CardID        *int            `json:"card_id,omitempty"`
+	TeamID        *int            `json:"team_id,omitempty"`
+	Amount        decimal.Decimal `json:"amount"`   // На карту; для команды — общая сумма, делится поровну
+	Currency      string          `json:"currency"` // Валюта карты; для команды пополняются только карты в этой валюте
+	FromCurrency  string          `json:"from_currency,omitempty"`
+	Schedule      string          `json:"schedule"`
+	Timezone      string          `json:"timezone"`
+	IsActive      bool            `json:"is_active"`
+	NextRunAt     time.Time       `json:"next_run_at"`
+	PendingRunFor *time.Time      `json:"pending_run_for,omitempty"` // Срок, по которому идут повторы
+	Attempt       int             `json:"attempt"`
+	LastRunAt     *time.Time      `json:"last_run_at,omitempty"`
+	LastStatus    string          `json:"last_status,omitempty"`
+	LastError     string          `json:"last_error,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+// ScheduledTransferRun - Попытка пополнить одну карту по правилу ScheduledTransfer
+type ScheduledTransferRun struct {
+	ID           int             `json:"id"`
+	RuleID       int             `json:"rule_id"`
+	ScheduledFor time.Time       `json:"scheduled_for"`
+	Attempt      int             `json:"attempt"`
+	CardID       int             `json:"card_id"`
+	Amount       decimal.Decimal `json:"amount"`
+	Currency     string          `json:"currency"`
+	Status       string          `json:"status"`
+	Error        string          `json:"error,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+}
+
+// --- ОЧЕРЕДЬ УВЕДОМЛЕНИЙ ---
+
+// Каналы доставки уведомлений
+const (
+	NotificationChannelEmail    = "email"
+	NotificationChannelTelegram = "telegram"
+)
+
+// Статусы уведомления в notification_outbox и попытки доставки в notification_deliveries
+const (
+	NotificationPending = "PENDING" // Ждёт отправки или повтора
+	NotificationSent    = "SENT"
+	NotificationFailed  = "FAILED"  // Попытки исчерпаны (для попытки — неудачная попытка)
+	NotificationSkipped = "SKIPPED" // Канал не подключён: нет email или Telegram не привязан
+)
+
+// Шаблоны уведомлений. Тексты — в translations под ключами notify.<id>.subject и notify.<id>.body
+// (notify.<id>.email / notify.<id>.telegram — отдельный текст для канала), по умолчанию — встроенные.
+const (
+	NotifyCardDeclined            = "card.declined"
+	NotifyCardBlocked             = "card.blocked"
+	NotifyCardAutoReplenished     = "card.auto_replenished"
+	NotifyCardAutoReplenishFailed = "card.auto_replenish_failed"
+	NotifyTeamCardsReassigned     = "team.cards_reassigned"
+	NotifyWalletToppedUp          = "wallet.topped_up"
+	NotifyOrderReady              = "order.ready"
+	NotifyTierUpgraded            = "tier.upgraded"
+	NotifyCardHoldPlaced          = "card.hold_placed"
+	NotifyCardCharged             = "card.charged"
+	NotifyCardHoldReleased        = "card.hold_released"
+	NotifyCardHoldExpired         = "card.hold_expired"
+	NotifyCardRefunded            = "card.refunded"
+	NotifyCardChargebackOpened    = "card.chargeback_opened"
+	NotifyCardChargebackWon       = "card.chargeback_won"
+	NotifyCardChargebackLost      = "card.chargeback_lost"
+	NotifyCardFunded              = "card.funded"
+	NotifyCardsIssued             = "cards.issued"
+	NotifyCardsIssueFailed        = "cards.issue_failed"
+	NotifyDisputeOpened           = "dispute.opened"
+	NotifyDisputeInReview         = "dispute.in_review"
+	NotifyDisputeWon              = "dispute.won"
+	NotifyDisputeLost             = "dispute.lost"
+	NotifyWalletConverted         = "wallet.converted"
+	NotifyAutoTopUpChanged        = "wallet.auto_topup_changed"
+	NotifyScheduledTransferDone   = "scheduled_transfer.completed"
+	NotifyScheduledTransferFailed = "scheduled_transfer.failed"
+	NotifyScheduledTransferRetry  = "scheduled_transfer.retrying"
+	NotifyScheduledTransferPaused = "scheduled_transfer.paused"
+	NotifyDigest                  = "digest" // Суточная сводка мелких операций (заголовок)
+)
+
+// Типы событий уведомлений — для каждого пользователь выбирает каналы (NotificationEventPref).
+// Уведомление без события (служебное, тестовое) отправляется по общему notification_pref.
+const (
+	NotificationEventTransactions = "transactions" // Списания, холды и возвраты по картам
+	NotificationEventDeclines     = "declines"     // Отклонённые транзакции, блокировка карты
+	NotificationEventTopUps       = "topups"       // Пополнения Кошелька и карт, автопополнение, конвертация
+	NotificationEventCards        = "cards"        // Выпуск, статус и передача карт, споры
+	NotificationEventSecurity     = "security"     // Вход с нового устройства, блокировка аккаунта
+	NotificationEventPurchases    = "purchases"    // Покупки в XPLR Store, тариф
+	NotificationEventNews         = "news"         // Новости и акции
+)
+
+var NotificationEvents = []string{
+	NotificationEventTransactions, NotificationEventDeclines, NotificationEventTopUps, NotificationEventCards,
+	NotificationEventSecurity, NotificationEventPurchases, NotificationEventNews,
+}
+
+// NotificationTemplateEvents - Событие шаблонного уведомления
+var NotificationTemplateEvents = map[string]string{
+	NotifyCardDeclined:            NotificationEventDeclines,
+	NotifyCardBlocked:             NotificationEventDeclines,
+	NotifyCardAutoReplenished:     NotificationEventTopUps,
+	NotifyCardAutoReplenishFailed: NotificationEventTopUps,
+	NotifyTeamCardsReassigned:     NotificationEventCards,
+	NotifyWalletToppedUp:          NotificationEventTopUps,
+	NotifyOrderReady:              NotificationEventPurchases,
+	NotifyTierUpgraded:            NotificationEventPurchases,
+	NotifyCardHoldPlaced:          NotificationEventTransactions,
+	NotifyCardCharged:             NotificationEventTransactions,
+	NotifyCardHoldReleased:        NotificationEventTransactions,
+	NotifyCardHoldExpired:         NotificationEventTransactions,
+	NotifyCardRefunded:            NotificationEventTransactions,
+	NotifyCardChargebackOpened:    NotificationEventCards,
+	NotifyCardChargebackWon:       NotificationEventCards,
+	NotifyCardChargebackLost:      NotificationEventCards,
+	NotifyCardFunded:              NotificationEventTopUps,
+	NotifyCardsIssued:             NotificationEventCards,
+	NotifyCardsIssueFailed:        NotificationEventCards,
+	NotifyDisputeOpened:           NotificationEventCards,
+	NotifyDisputeInReview:         NotificationEventCards,
+	NotifyDisputeWon:              NotificationEventCards,
+	NotifyDisputeLost:             NotificationEventCards,
+	NotifyWalletConverted:         NotificationEventTopUps,
+	NotifyAutoTopUpChanged:        NotificationEventTopUps,
+	NotifyScheduledTransferDone:   NotificationEventTopUps,
+	NotifyScheduledTransferFailed: NotificationEventTopUps,
+	NotifyScheduledTransferRetry:  NotificationEventTopUps,
+	NotifyScheduledTransferPaused: NotificationEventTopUps,
+}
+
+// Каналы события в NotificationEventPref.Channels
+const (
+	NotificationPrefDefault = "default" // Как общий notification_pref
+	NotificationPrefOff     = "off"     // Не уведомлять
+)
+
+// NotificationEventPref - Настройка уведомлений пользователя для типа события.
+// Channels: default, email, telegram, both или off. DigestBelow > 0 — операции на сумму меньше
+// приходят не сразу, а раз в сутки одной сводкой.
+type NotificationEventPref struct {
+	Event       string          `json:"event"`
+	Channels    string          `json:"channels"`
+	DigestBelow decimal.Decimal `json:"digest_below"`
+}
+
+// Языки уведомлений (users.language)
+const DefaultNotificationLanguage = "ru"
+
+var NotificationLanguages = []string{"ru", "en"}
+
+// UserNotification - Уведомление пользователю для постановки в очередь.
+// Каналы выбираются по users.notification_pref в момент постановки. Template задан — текст
+// собирается из шаблона и Data на языке пользователя при отправке; иначе используется готовый HTML.
+type UserNotification struct {
+	UserID       int
+	Event        string          // domain.NotificationEvent*; пусто — по событию шаблона
+	Amount       decimal.Decimal // Сумма операции — для сводки мелких операций
+	Template     string
+	Data         map[string]string
+	Subject      string // Тема письма
+	EmailHTML    string
+	TelegramHTML string // Пусто — используется EmailHTML
+	ImageURL     string // Для Telegram: отправляется фото с подписью TelegramHTML
+}
+
+// OutboxNotification - Уведомление в одном канале из notification_outbox
+type OutboxNotification struct {
+	ID            int               `json:"id"`
+	UserID        int               `json:"user_id"`
+	Channel       string            `json:"channel"`
+	Event         string            `json:"event,omitempty"`
+	Digest        bool              `json:"digest,omitempty"` // Уйдёт в суточной сводке
+	Template      string            `json:"template,omitempty"`
+	Data          map[string]string `json:"data,omitempty"`
+	Subject       string            `json:"subject"`
+	Body          string            `json:"body"`
+	ImageURL      string            `json:"image_url,omitempty"`
+	Status        string            `json:"status"`
+	Attempts      int               `json:"attempts"`
+	NextAttemptAt time.Time         `json:"next_attempt_at"`
+	LastError     string            `json:"last_error,omitempty"`
+	CreatedAt     time.Time         `json:"created_at"`
+	SentAt        *time.Time        `json:"sent_at,omitempty"`
+}
+
+// NotificationDelivery - Попытка доставки уведомления (журнал доставки пользователя)
+type NotificationDelivery struct {
+	ID             int       `json:"id"`
+	NotificationID int       `json:"notification_id"`
+	UserID         int       `json:"user_id"`
+	Channel        string    `json:"channel"`
+	Subject        string    `json:"subject"`
+	Attempt        int       `json:"attempt"`
+	Status         string    `json:"status"`
+	Recipient      string    `json:"recipient,omitempty"` // Email или chat_id Telegram
+	Error          string    `json:"error,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// События исходящих вебхуков (интеграции пользователя: трекеры, CRM)
+const (
+	WebhookTransactionApproved = "transaction.approved" // Авторизация или списание по карте
+	WebhookTransactionDeclined = "transaction.declined" // Транзакция отклонена
+	WebhookCardFrozen          = "card.frozen"          // Карта заморожена
+	WebhookWalletCredited      = "wallet.credited"      // Зачисление в Кошелёк
+	WebhookOrderReady          = "order.ready"          // Заказ в XPLR Store выполнен
+	WebhookTest                = "webhook.test"         // Тестовое событие (кнопка «Отправить тест»), без подписки
+)
+
+// WebhookEvents — события, на которые можно подписать endpoint.
+var WebhookEvents = []string{
+	WebhookTransactionApproved, WebhookTransactionDeclined, WebhookCardFrozen, WebhookWalletCredited, WebhookOrderReady,
+}
+
+// Статусы доставки вебхука
+const (
+	WebhookDeliveryPending   = "PENDING"   // Ждёт отправки или повтора
+	WebhookDeliveryDelivered = "DELIVERED" // Endpoint ответил 2xx
+	WebhookDeliveryFailed    = "FAILED"    // Попытки исчерпаны
+)
+
+// WebhookEndpoint - Адрес, на который отправляются события пользователя.
+// Secret возвращается только при создании и смене секрета.
+type WebhookEndpoint struct {
+	ID          int       `json:"id"`
+	UserID      int       `json:"user_id"`
+	URL         string    `json:"url"`
+	Description string    `json:"description,omitempty"`
+	Events      []string  `json:"events"`
+	IsActive    bool      `json:"is_active"`
+	Secret      string    `json:"secret,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// WebhookDelivery - Событие в очереди доставки одному endpoint.
+// Payload — тело запроса как есть: подпись считается от него при каждой попытке.
+type WebhookDelivery struct {
+	ID             int        `json:"id"`
+	EndpointID     int        `json:"endpoint_id"`
+	UserID         int        `json:"user_id"`
+	EventID        string     `json:"event_id"`
+	Event          string     `json:"event"`
+	Payload        string     `json:"payload"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at"`
+	LastStatusCode int        `json:"last_status_code,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+	URL            string     `json:"-"` // Адрес и секрет endpoint — для отправки
+	Secret         string     `json:"-"`
+}
+
+// WebhookDeliveryAttempt - Попытка доставки вебхука (журнал попыток)
+type WebhookDeliveryAttempt struct {
+	ID         int       `json:"id"`
+	DeliveryID int       `json:"delivery_id"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code,omitempty"` // 0 — ответа не было (таймаут, DNS, TLS)
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
@@ -2,6 +2,7 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -25,7 +26,8 @@ func AdminAdjustBalanceHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var req struct {
-		Amount decimal.Decimal `json:"amount"`
+		Amount   decimal.Decimal `json:"amount"`
+		Currency string          `json:"currency"` // Кошелёк: USD (по умолчанию), EUR, RUB, USDT
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "invalid request body", http.StatusBadRequest)
@@ -35,15 +37,23 @@ func AdminAdjustBalanceHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "amount must not be zero", http.StatusBadRequest)
 		return
 	}
-	newBal, err := repository.AdminAdjustBalance(targetID, req.Amount)
+	newBal, err := repository.AdminAdjustBalance(targetID, req.Amount, req.Currency)
+	if errors.Is(err, repository.ErrUnsupportedCurrency) || errors.Is(err, repository.ErrInsufficientWalletFunds) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	currency, _ := repository.NormalizeWalletCurrency(req.Currency)
+	adminID, _ := r.Context().Value(middleware.UserIDKey).(int)
+	repository.WriteAdminLog(adminID, fmt.Sprintf("Корректировка баланса пользователя #%d: %s %s", targetID, req.Amount.String(), currency))
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"user_id":     targetID,
 		"adjustment":  req.Amount.String(),
+		"currency":    currency,
 		"new_balance": newBal,
 	})
 }
@@ -148,6 +158,8 @@ func AdminUpdateMarkupHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "nothing to update: all fields are null", http.StatusBadRequest)
 		return
 	}
+	adminID, _ := r.Context().Value(middleware.UserIDKey).(int)
+	source := fmt.Sprintf("admin:%d", adminID)
 
 	// Update base_rate (if provided) — also recalculates final_rate
 	if req.BaseRate != nil {
@@ -155,7 +167,7 @@ func AdminUpdateMarkupHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "base_rate must be positive", http.StatusBadRequest)
 			return
 		}
-		if err := repository.UpdateBaseRateByID(rateID, *req.BaseRate); err != nil {
+		if err := repository.UpdateBaseRateByID(rateID, *req.BaseRate, source); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -167,7 +179,7 @@ func AdminUpdateMarkupHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "markup_percent cannot be negative", http.StatusBadRequest)
 			return
 		}
-		if err := repository.UpdateMarkupPercent(rateID, *req.MarkupPercent); err != nil {
+		if err := repository.UpdateMarkupPercent(rateID, *req.MarkupPercent, source); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -179,7 +191,7 @@ func AdminUpdateMarkupHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "final_rate must be positive", http.StatusBadRequest)
 			return
 		}
-		if err := repository.UpdateFinalRateByID(rateID, *req.FinalRate); err != nil {
+		if err := repository.UpdateFinalRateByID(rateID, *req.FinalRate, source); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -9,6 +9,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/djalben/xplr-core/backend/domain"
 	"github.com/djalben/xplr-core/backend/middleware"
 	"github.com/djalben/xplr-core/backend/repository"
 	"github.com/djalben/xplr-core/backend/service"
@@ -131,7 +132,7 @@ func AdminToggleBlockHandler(w http.ResponseWriter, r *http.Request) {
 		}()
 	} else if !newBlocked {
 		log.Printf("[EVENT] Admin %d performed user_unblock (target=%d, email=%s). Triggering notifications...", adminID, targetID, email)
-		go service.NotifyUser(targetID, "Аккаунт разблокирован",
+		go service.NotifyUserEvent(targetID, domain.NotificationEventSecurity, "Аккаунт разблокирован",
 			"✅ <b>Ваш аккаунт был разблокирован.</b>\n\nВы снова можете пользоваться всеми сервисами XPLR.\n\n"+
 				"<a href=\"https://xplr.pro\">Открыть XPLR</a>")
 	}
@@ -238,8 +239,8 @@ func AdminEmergencyFreezeHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}(targetID, frozenCards)
 
-	// Notify user via NotifyUser (respects user's channel pref)
-	go service.NotifyUser(targetID, "Аккаунт заблокирован",
+	// Notify user via NotifyUserEvent (respects user's security notification pref)
+	go service.NotifyUserEvent(targetID, domain.NotificationEventSecurity, "Аккаунт заблокирован",
 		fmt.Sprintf("🚨 <b>Ваш аккаунт заблокирован</b>\n\n"+
 			"Заморожено карт: <b>%d</b>\n"+
 			"Статус: <b>BANNED</b>\n"+
@@ -13,7 +13,6 @@ import (
 	"github.com/djalben/xplr-core/backend/service"
 	"github.com/djalben/xplr-core/backend/telegram"
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/shopspring/decimal"
 )
 
 // RegisterHandler - Регистрация нового пользователя
@@ -47,7 +46,6 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	user := domain.User{
 		Email:        req.Email,
 		PasswordHash: hashedPassword,
-		Balance:      decimal.NewFromInt(0), // Инициализация баланса
 		Status:       "ACTIVE",
 	}
 
@@ -124,6 +122,16 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		// Не блокируем регистрацию
 	}
 
+	// Регистрация по приглашению в команду: сразу добавляем в команду (email должен совпадать с приглашением)
+	var joinedTeamID int
+	if req.InviteToken != "" {
+		if invitation, err := repository.AcceptTeamInvitation(req.InviteToken, createdUser.ID); err != nil {
+			log.Printf("[TEAM-INVITE] User %d registered with invite token but could not join: %v", createdUser.ID, err)
+		} else {
+			joinedTeamID = invitation.TeamID
+		}
+	}
+
 	// Ensure tables exist (prod may have missed migrations)
 	repository.RunSchemaGuard()
 
@@ -170,9 +178,10 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"email":        createdUser.Email,
-		"is_verified":  false,
-		"message":      "Регистрация успешна. Подтвердите email по ссылке из письма.",
+		"email":          createdUser.Email,
+		"is_verified":    false,
+		"message":        "Регистрация успешна. Подтвердите email по ссылке из письма.",
+		"joined_team_id": joinedTeamID,
 	})
 }
 
@@ -515,7 +524,7 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	// ── Шаг 7b: Проверка нового IP — уведомление о входе с нового устройства ──
 	if repository.IsNewIPForUser(user.ID, ip) {
 		log.Printf("[SECURITY] New IP detected for user %d (%s): ip=%s", user.ID, user.Email, ip)
-		go service.NotifyUser(user.ID, "Вход с нового устройства",
+		go service.NotifyUserEvent(user.ID, domain.NotificationEventSecurity, "Вход с нового устройства",
 			fmt.Sprintf("⚠️ <b>Вход в аккаунт с нового устройства/IP</b>\n\n"+
 				"IP: <b>%s</b>\n"+
 				"Устройство: <b>%s</b>\n\n"+
@@ -534,7 +543,7 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		"user": map[string]interface{}{
 			"id":                 user.ID,
 			"email":              user.Email,
-			"balance":            user.BalanceRub.String(),
+			"balance":            walletBalanceString(user.ID),
 			"status":             user.Status,
 			"is_verified":        user.IsVerified,
 			"is_admin":           isAdmin,
@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/middleware"
+	"github.com/djalben/xplr-core/backend/repository"
+	"github.com/djalben/xplr-core/backend/service"
+	"github.com/gorilla/mux"
+)
+
+// cardProviderRouteRequest — тело POST/PATCH правила маршрутизации.
+// В PATCH изменяются только переданные поля.
+type cardProviderRouteRequest struct {
+	Category *string `json:"category"`
+	Currency *string `json:"currency"`
+	CardType *string `json:"card_type"`
+	Provider *string `json:"provider"`
+	Priority *int    `json:"priority"`
+	IsActive *bool   `json:"is_active"`
+}
+
+func (req cardProviderRouteRequest) applyTo(rt *domain.CardProviderRoute) {
+	if req.Category != nil {
+		rt.Category = *req.Category
+	}
+	if req.Currency != nil {
+		rt.Currency = *req.Currency
+	}
+	if req.CardType != nil {
+		rt.CardType = *req.CardType
+	}
+	if req.Provider != nil {
+		rt.Provider = *req.Provider
+	}
+	if req.Priority != nil {
+		rt.Priority = *req.Priority
+	}
+	if req.IsActive != nil {
+		rt.IsActive = *req.IsActive
+	}
+}
+
+// validateRouteProvider — правило может ссылаться только на зарегистрированного эмитента.
+func validateRouteProvider(name string) error {
+	if _, err := service.GetCardProviderByName(name); err != nil || name == "" {
+		return fmt.Errorf("unknown provider %q", name)
+	}
+	return nil
+}
+
+// AdminGetCardProviderRoutesHandler - GET /api/v1/admin/card-provider-routes
+// Возвращает правила маршрутизации выпуска, доступных эмитентов и эмитента по умолчанию.
+func AdminGetCardProviderRoutesHandler(w http.ResponseWriter, r *http.Request) {
+	routes, err := repository.ListCardProviderRoutes(false)
+	if err != nil {
+		log.Printf("[ADMIN] Failed to list provider routes: %v", err)
+		http.Error(w, "Failed to load provider routes", http.StatusInternalServerError)
+		return
+	}
+	providers := service.CardProviderNames()
+	sort.Strings(providers)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"routes":           routes,
+		"providers":        providers,
+		"default_provider": service.DefaultCardProviderName(),
+	})
+}
+
+// AdminCreateCardProviderRouteHandler - POST /api/v1/admin/card-provider-routes
+func AdminCreateCardProviderRouteHandler(w http.ResponseWriter, r *http.Request) {
+	var req cardProviderRouteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	rt := domain.CardProviderRoute{IsActive: true}
+	req.applyTo(&rt)
+	if err := validateRouteProvider(rt.Provider); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := repository.SaveCardProviderRoute(&rt); err != nil {
+		log.Printf("[ADMIN] Failed to create provider route: %v", err)
+		http.Error(w, "Failed to save provider route", http.StatusInternalServerError)
+		return
+	}
+	adminID, _ := r.Context().Value(middleware.UserIDKey).(int)
+	repository.WriteAdminLog(adminID, fmt.Sprintf("Маршрут эмитента создан: #%d %s/%s/%s → %s",
+		rt.ID, rt.Category, rt.Currency, rt.CardType, rt.Provider))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rt)
+}
+
+// AdminUpdateCardProviderRouteHandler - PATCH /api/v1/admin/card-provider-routes/{id}
+func AdminUpdateCardProviderRouteHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	var req cardProviderRouteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	rt, err := repository.GetCardProviderRoute(id)
+	if err != nil {
+		http.Error(w, "Failed to load provider route", http.StatusInternalServerError)
+		return
+	}
+	if rt == nil {
+		http.Error(w, "route not found", http.StatusNotFound)
+		return
+	}
+	req.applyTo(rt)
+	if err := validateRouteProvider(rt.Provider); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := repository.SaveCardProviderRoute(rt); err != nil {
+		log.Printf("[ADMIN] Failed to update provider route %d: %v", id, err)
+		http.Error(w, "Failed to save provider route", http.StatusInternalServerError)
+		return
+	}
+	adminID, _ := r.Context().Value(middleware.UserIDKey).(int)
+	repository.WriteAdminLog(adminID, fmt.Sprintf("Маршрут эмитента изменён: #%d %s/%s/%s → %s (active=%v)",
+		rt.ID, rt.Category, rt.Currency, rt.CardType, rt.Provider, rt.IsActive))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rt)
+}
+
+// AdminDeleteCardProviderRouteHandler - DELETE /api/v1/admin/card-provider-routes/{id}
+func AdminDeleteCardProviderRouteHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	if err := repository.DeleteCardProviderRoute(id); err != nil {
+		if err.Error() == "route not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to delete provider route", http.StatusInternalServerError)
+		return
+	}
+	adminID, _ := r.Context().Value(middleware.UserIDKey).(int)
+	repository.WriteAdminLog(adminID, fmt.Sprintf("Маршрут эмитента удалён: id=%d", id))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+}
@@ -3,9 +3,11 @@ package handler
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -14,6 +16,7 @@ import (
 	"github.com/djalben/xplr-core/backend/middleware"
 	"github.com/djalben/xplr-core/backend/repository"
 	"github.com/djalben/xplr-core/backend/service"
+	"github.com/djalben/xplr-core/backend/usecase"
 	"github.com/gorilla/mux"
 	"github.com/shopspring/decimal"
 )
@@ -237,6 +240,15 @@ func PatchCardStatusHandler(w http.ResponseWriter, r *http.Request) {
 	if card, err := repository.GetCardByID(cardID); err == nil {
 		cardLast4 = card.Last4Digits
 		cardBalanceBefore = card.CardBalance
+
+		// Блокировка/разблокировка у эмитента, выпустившего карту (cards.provider)
+		if card.UserID == userID && card.CardStatus != status {
+			if err := setCardStateAtProvider(cardID, status); err != nil {
+				log.Printf("[CARD-STATUS] Provider rejected status %s for card %d: %v", status, cardID, err)
+				http.Error(w, "Card issuer rejected the status change: "+err.Error(), http.StatusBadGateway)
+				return
+			}
+		}
 	}
 
 	if err := repository.UpdateCardStatus(cardID, userID, status); err != nil {
@@ -275,18 +287,31 @@ func PatchCardStatusHandler(w http.ResponseWriter, r *http.Request) {
 	default:
 		msg = fmt.Sprintf("%s\n\nКарта *%s\nСтатус: <b>%s</b>\n\n<a href=\"https://xplr.pro/cards\">Открыть карты</a>", label, cardLast4, status)
 	}
-	go service.NotifyUser(userID, label, msg)
+	go service.NotifyUserEvent(userID, domain.NotificationEventCards, label, msg)
+	if status == "FROZEN" {
+		go service.EmitWebhookEvent(userID, domain.WebhookCardFrozen, map[string]interface{}{
+			"card_id": cardID,
+			"last4":   cardLast4,
+		})
+	}
 
 	// Refund notification: if card had balance and was closed/blocked, the balance was returned to wallet
 	if (status == "CLOSED" || status == "BLOCKED") && cardBalanceBefore.GreaterThan(decimal.Zero) {
 		log.Printf("[EVENT] User %d performed card_refund_to_wallet (card=%d, last4=%s, refunded=$%s). Triggering notifications...",
 			userID, cardID, cardLast4, cardBalanceBefore.StringFixed(2))
-		go service.NotifyUser(userID, "Возврат средств с карты",
+		go service.NotifyUserOperation(userID, domain.NotificationEventTopUps, cardBalanceBefore, "Возврат средств с карты",
 			fmt.Sprintf("💰 <b>Возврат средств</b>\n\n"+
 				"Списание с карты *%s произведено.\n"+
 				"Средства <b>$%s</b> возвращены на основной баланс.\n\n"+
 				"<a href=\"https://xplr.pro/wallet\">Открыть кошелёк</a>",
 				cardLast4, cardBalanceBefore.StringFixed(2)))
+		go service.EmitWebhookEvent(userID, domain.WebhookWalletCredited, map[string]interface{}{
+			"amount":   cardBalanceBefore.StringFixed(2),
+			"currency": "USD",
+			"source":   "card_refund",
+			"card_id":  cardID,
+			"last4":    cardLast4,
+		})
 	}
 
 	// Return updated wallet balance so frontend can refresh instantly
@@ -303,6 +328,46 @@ func PatchCardStatusHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
+// setCardStateAtProvider замораживает карту у эмитента (FROZEN, BLOCKED, CLOSED) или размораживает (ACTIVE).
+func setCardStateAtProvider(cardID int, status string) error {
+	provider, err := service.CardProviderForCard(cardID)
+	if err != nil {
+		return err
+	}
+	if status == "ACTIVE" {
+		return provider.UnfreezeCard(cardID)
+	}
+	return provider.FreezeCard(cardID)
+}
+
+// massIssueCount — размер задания массового выпуска: не меньше 1 и не больше domain.MassIssueMaxCount.
+func massIssueCount(count int) (int, error) {
+	if count < 1 {
+		return 1, nil
+	}
+	if count > domain.MassIssueMaxCount {
+		return 0, fmt.Errorf("Максимум %d карт за раз", domain.MassIssueMaxCount)
+	}
+	return count, nil
+}
+
+// checkMassIssueLimit — лимит личных карт по уровню: standard — 3, gold — 15 (с учётом карт в
+// незавершённых заданиях). Карты команды в этот лимит не входят: их выпуск ограничен размером
+// задания, Кошельком команды и лимитами участника.
+func checkMassIssueLimit(count, personalCards int, goldActive, teamIssue bool) error {
+	if teamIssue {
+		return nil
+	}
+	limit := 3 // standard tier
+	if goldActive {
+		limit = 15 // gold tier
+	}
+	if personalCards+count > limit {
+		return fmt.Errorf("Превышен лимит карт. Ваш лимит: %d карт (текущих: %d). Обновитесь до Gold tier для лимита 15 карт.", limit, personalCards)
+	}
+	return nil
+}
+
 func MassIssueCardsHandler(w http.ResponseWriter, r *http.Request) {
 	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
 	if !ok {
@@ -316,36 +381,33 @@ func MassIssueCardsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Count < 1 {
-		req.Count = 1
-	}
-	if req.Count > 100 {
-		http.Error(w, "Максимум 100 карт за раз", http.StatusBadRequest)
+	count, err := massIssueCount(req.Count)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	req.Count = count
 
 	// Check tier-based card limit
 	var tier string
 	var tierExpiresAt sql.NullTime
-	var currentCardCount int
-	err := GlobalDB.QueryRow(`
+	var personalCardCount int
+	// Карты из ещё не завершённых заданий выпуска тоже считаются
+	err = GlobalDB.QueryRow(`
 		SELECT COALESCE(tier, 'standard'), tier_expires_at, 
-		       (SELECT COUNT(*) FROM cards WHERE user_id = $1)
+		       (SELECT COUNT(*) FROM cards WHERE user_id = $1 AND team_id IS NULL) +
+		       (SELECT COALESCE(SUM(total - succeeded - failed), 0) FROM card_issue_jobs
+		        WHERE user_id = $1 AND fee_team_id IS NULL AND status IN ('PENDING', 'RUNNING'))
 		FROM users WHERE id = $1
-	`, userID).Scan(&tier, &tierExpiresAt, &currentCardCount)
+	`, userID).Scan(&tier, &tierExpiresAt, &personalCardCount)
 	if err != nil {
 		http.Error(w, "Ошибка проверки лимита", http.StatusInternalServerError)
 		return
 	}
-
-	// Determine card limit based on tier
-	cardLimit := 3 // standard tier
-	if tier == "gold" && tierExpiresAt.Valid && tierExpiresAt.Time.After(time.Now()) {
-		cardLimit = 15 // gold tier
-	}
-
-	if currentCardCount+req.Count > cardLimit {
-		http.Error(w, fmt.Sprintf("Превышен лимит карт. Ваш лимит: %d карт (текущих: %d). Обновитесь до Gold tier для лимита 15 карт.", cardLimit, currentCardCount), http.StatusForbidden)
+	goldActive := tier == "gold" && tierExpiresAt.Valid && tierExpiresAt.Time.After(time.Now())
+	isTeamCard := req.TeamID != nil && *req.TeamID > 0
+	if err := checkMassIssueLimit(req.Count, personalCardCount, goldActive, isTeamCard); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
 		return
 	}
 
@@ -357,7 +419,7 @@ func MassIssueCardsHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Determine effective tier for fee lookup
 	effectiveTier := "standard"
-	if tier == "gold" && tierExpiresAt.Valid && tierExpiresAt.Time.After(time.Now()) {
+	if goldActive {
 		effectiveTier = "gold"
 	}
 
@@ -384,10 +446,22 @@ func MassIssueCardsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	totalFeeUSD := feeUSD.Mul(decimal.NewFromInt(int64(req.Count)))
 
-	// Deduct from wallet (internal_balances.master_balance, USD)
+	// Deduct from wallet (internal_balances.master_balance, USD); карты команды оплачиваются из Кошелька команды
 	if totalFeeUSD.GreaterThan(decimal.Zero) {
 		details := "Card issue fee: " + strconv.Itoa(req.Count) + "x " + cat + " — $" + totalFeeUSD.StringFixed(2)
-		if err := repository.DeductWalletBalance(userID, totalFeeUSD, details); err != nil {
+		if isTeamCard {
+			if err := repository.DeductTeamWalletFee(*req.TeamID, userID, totalFeeUSD, details); err != nil {
+				switch {
+				case errors.Is(err, repository.ErrTeamWalletInsufficientFunds):
+					http.Error(w, err.Error(), http.StatusPaymentRequired)
+				case errors.Is(err, repository.ErrTeamAllowanceExceeded), errors.Is(err, repository.ErrTeamAccessDenied):
+					http.Error(w, err.Error(), http.StatusForbidden)
+				default:
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+				}
+				return
+			}
+		} else if err := repository.DeductWalletBalance(userID, totalFeeUSD, details); err != nil {
 			if strings.Contains(err.Error(), "недостаточно средств") || strings.Contains(err.Error(), "кошелёк не найден") {
 				http.Error(w, err.Error(), http.StatusPaymentRequired)
 			} else {
@@ -397,30 +471,108 @@ func MassIssueCardsHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	response, err := repository.IssueCards(userID, req)
+	// Выпуск идёт асинхронно: задание обрабатывает usecase.RunCardIssueJob
+	feeTeamID := 0
+	if isTeamCard {
+		feeTeamID = *req.TeamID
+	}
+	job, err := repository.CreateCardIssueJob(userID, req, feeUSD, feeTeamID)
 	if err != nil {
+		if totalFeeUSD.GreaterThan(decimal.Zero) && isTeamCard {
+			if rerr := repository.RefundTeamWalletFee(*req.TeamID, userID, totalFeeUSD, "Refund: card issue fee — job was not created"); rerr != nil {
+				log.Printf("[CARD-FEE] ❌ Failed to refund $%s to team %d wallet: %v", totalFeeUSD.StringFixed(2), *req.TeamID, rerr)
+			}
+		} else if totalFeeUSD.GreaterThan(decimal.Zero) {
+			if rerr := repository.RefundWalletFee(userID, totalFeeUSD, "Refund: card issue fee — job was not created"); rerr != nil {
+				log.Printf("[CARD-FEE] ❌ Failed to refund $%s to user %d: %v", totalFeeUSD.StringFixed(2), userID, rerr)
+			}
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	go usecase.StartCardIssueJob(job.ID)
+
+	log.Printf("[EVENT] User %d queued card_issue job #%d (count=%d, category=%s, fee=$%s)", userID, job.ID, req.Count, cat, totalFeeUSD.StringFixed(2))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"job_id":       job.ID,
+		"status":       job.Status,
+		"total":        job.Total,
+		"fee_per_card": job.FeePerCard,
+		"status_url":   fmt.Sprintf("/api/v1/user/cards/issue-jobs/%d", job.ID),
+	})
+}
+
+// GetCardIssueJobHandler - GET /api/v1/user/cards/issue-jobs/{id}
+// Прогресс задания массового выпуска: счётчики и результат по каждой обработанной карте.
+func GetCardIssueJobHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok || userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	jobID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || jobID <= 0 {
+		http.Error(w, "invalid job id", http.StatusBadRequest)
+		return
+	}
+
+	job, err := repository.GetCardIssueJob(jobID, userID)
+	if err != nil {
+		log.Printf("[CARD-JOBS] Failed to load job #%d: %v", jobID, err)
+		http.Error(w, "Failed to load job", http.StatusInternalServerError)
+		return
+	}
+	if job == nil {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// CardIssueJobsCronHandler - GET /api/v1/cron/card-issue-jobs
+// Дообрабатывает задания выпуска, которые не запустились или были прерваны (protected by CRON_SECRET).
+func CardIssueJobsCronHandler(w http.ResponseWriter, r *http.Request) {
+	cronSecret := os.Getenv("CRON_SECRET")
+	if cronSecret != "" && r.Header.Get("Authorization") != "Bearer "+cronSecret {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
 
-	log.Printf("[EVENT] User %d performed card_issue (count=%d, category=%s, fee=$%s). Triggering notifications...", userID, req.Count, cat, totalFeeUSD.StringFixed(2))
+	processed, err := usecase.ProcessCardIssueJobs()
+	if err != nil {
+		log.Printf("[CARD-JOBS] Cron failed: %v", err)
+		http.Error(w, "Failed to process card issue jobs", http.StatusInternalServerError)
+		return
+	}
 
-	// Check referral bonus eligibility (condition 3: first card purchase)
-	go repository.CheckAndCreditReferralBonus(userID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"processed": processed})
+}
+
+// CardHoldsCronHandler - GET /api/v1/cron/card-holds
+// Освобождает холды старше card_hold_expiry_days (Vercel cron, защищён CRON_SECRET).
+func CardHoldsCronHandler(w http.ResponseWriter, r *http.Request) {
+	cronSecret := os.Getenv("CRON_SECRET")
+	if cronSecret != "" && r.Header.Get("Authorization") != "Bearer "+cronSecret {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
 
-	// Notify user about card issue (single unified notification — TG + Email)
-	go service.NotifyUser(userID, "Карта выпущена",
-		fmt.Sprintf("💳 <b>Карта успешно выпущена!</b>\n\n"+
-			"📦 <b>Количество:</b> %d\n"+
-			"🏷 <b>Категория:</b> %s\n"+
-			"💰 <b>Комиссия:</b> $%s\n"+
-			"� <b>Дневной лимит:</b> $%s\n\n"+
-			"Карта уже доступна в <a href=\"https://xplr.pro/cards\">личном кабинете</a>.",
-			req.Count, cat, totalFeeUSD.StringFixed(2), req.DailyLimit.StringFixed(2)))
+	expired, err := usecase.ExpireCardHolds()
+	if err != nil {
+		log.Printf("[CARD-HOLDS] Cron failed: %v", err)
+		http.Error(w, "Failed to expire card holds", http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(map[string]interface{}{"expired": expired})
 }
 
 // SetCardAutoReplenishmentHandler - POST /api/v1/user/cards/{id}/auto-replenishment
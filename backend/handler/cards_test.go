@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/djalben/xplr-core/backend/domain"
+)
+
+func TestMassIssueCount(t *testing.T) {
+	tests := []struct {
+		count   int
+		want    int
+		wantErr bool
+	}{
+		{0, 1, false},
+		{-5, 1, false},
+		{50, 50, false},
+		{domain.MassIssueMaxCount, domain.MassIssueMaxCount, false},
+		{domain.MassIssueMaxCount + 1, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := massIssueCount(tt.count)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("massIssueCount(%d) = %d, %v; want %d, err=%v", tt.count, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestMassIssueAcceptsTeamBatch(t *testing.T) {
+	// Пользователь standard с исчерпанным личным лимитом выпускает 200 карт команды
+	count, err := massIssueCount(200)
+	if err != nil {
+		t.Fatalf("massIssueCount(200): %v", err)
+	}
+	if err := checkMassIssueLimit(count, 3, false, true); err != nil {
+		t.Errorf("задание на %d карт команды отклонено: %v", count, err)
+	}
+
+	tests := []struct {
+		name          string
+		count, cards  int
+		gold, allowed bool
+	}{
+		{"standard within limit", 2, 1, false, true},
+		{"standard over limit", 2, 2, false, false},
+		{"gold within limit", 10, 5, true, true},
+		{"gold over limit", 50, 0, true, false},
+	}
+	for _, tt := range tests {
+		if err := checkMassIssueLimit(tt.count, tt.cards, tt.gold, false); (err == nil) != tt.allowed {
+			t.Errorf("%s: checkMassIssueLimit = %v", tt.name, err)
+		}
+	}
+}
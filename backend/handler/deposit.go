@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/djalben/xplr-core/backend/domain"
 	"github.com/djalben/xplr-core/backend/middleware"
 	"github.com/djalben/xplr-core/backend/repository"
 	"github.com/djalben/xplr-core/backend/service"
@@ -36,14 +37,16 @@ func ProcessDepositHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("[EVENT] User %d performed deposit (amount=%s ₽). Triggering notifications...", userID, amount.StringFixed(2))
-	go service.NotifyUser(userID, "Пополнение баланса",
+	log.Printf("[EVENT] User %d performed deposit (amount=$%s). Triggering notifications...", userID, amount.StringFixed(2))
+	go service.NotifyUserOperation(userID, domain.NotificationEventTopUps, amount, "Пополнение баланса",
 		fmt.Sprintf("💰 <b>Баланс пополнен</b>\n\n"+
-			"Сумма: <b>%s ₽</b>\n\n"+
+			"Сумма: <b>$%s</b>\n\n"+
 			"<a href=\"https://xplr.pro/wallet\">Открыть кошелёк</a>",
 			amount.StringFixed(2)))
+	go service.EmitWebhookEvent(userID, domain.WebhookWalletCredited, map[string]interface{}{
+		"amount": amount.StringFixed(2), "currency": "USD", "source": "deposit",
+	})
 
-	user, _ := repository.GetUserByID(userID)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"new_balance": user.BalanceRub.String()})
+	json.NewEncoder(w).Encode(map[string]string{"new_balance": walletBalanceString(userID)})
 }
@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/djalben/xplr-core/backend/middleware"
+	"github.com/djalben/xplr-core/backend/repository"
+	"github.com/djalben/xplr-core/backend/usecase"
+	"github.com/gorilla/mux"
+	"github.com/shopspring/decimal"
+)
+
+// CreateDisputeHandler - POST /api/v1/user/transactions/{id}/dispute
+// Тело: {"reason": "not_received", "description": "...", "amount": "10.00"} (amount необязателен — весь остаток)
+func CreateDisputeHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok || userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	txID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || txID <= 0 {
+		http.Error(w, "invalid transaction id", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Reason      string          `json:"reason"`
+		Description string          `json:"description"`
+		Amount      decimal.Decimal `json:"amount"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	dispute, err := usecase.OpenDispute(userID, txID, req.Amount, req.Reason, req.Description)
+	switch {
+	case errors.Is(err, repository.ErrDisputeExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, repository.ErrNotDisputable):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Printf("[DISPUTES] Failed to open dispute on tx %d for user %d: %v", txID, userID, err)
+		http.Error(w, "Failed to open dispute", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(dispute)
+}
+
+// GetUserDisputesHandler - GET /api/v1/user/disputes
+func GetUserDisputesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok || userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	disputes, err := repository.ListUserDisputes(userID)
+	if err != nil {
+		log.Printf("[DISPUTES] Failed to list disputes for user %d: %v", userID, err)
+		http.Error(w, "Failed to fetch disputes", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(disputes)
+}
+
+// AdminGetDisputesHandler - GET /api/v1/admin/disputes?status=OPEN
+func AdminGetDisputesHandler(w http.ResponseWriter, r *http.Request) {
+	status := strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("status")))
+	disputes, err := repository.ListDisputes(status)
+	if err != nil {
+		http.Error(w, "Failed to fetch disputes", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(disputes)
+}
+
+// AdminUpdateDisputeHandler - PATCH /api/v1/admin/disputes/{id}
+// Тело: {"status": "WON"|"LOST"|"IN_REVIEW", "admin_comment": "...", "refund": true}
+// refund=true при WON возвращает оспоренную сумму пользователю.
+func AdminUpdateDisputeHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, _ := r.Context().Value(middleware.UserIDKey).(int)
+	disputeID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || disputeID <= 0 {
+		http.Error(w, "invalid dispute id", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Status       string `json:"status"`
+		AdminComment string `json:"admin_comment"`
+		Refund       bool   `json:"refund"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+
+	dispute, err := usecase.ResolveDispute(disputeID, adminID, req.Status, req.AdminComment, req.Refund)
+	if errors.Is(err, repository.ErrDisputeNotFound) {
+		http.Error(w, "Dispute not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	repository.WriteAdminLog(adminID, fmt.Sprintf("Спор #%d: статус %s (возврат: %v)", disputeID, dispute.Status, req.Refund))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dispute)
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/repository"
+	"github.com/djalben/xplr-core/backend/usecase"
+	"github.com/gorilla/mux"
+)
+
+// PublicGetRateHistoryHandler - GET /api/v1/rates/history?pair=RUB/USD&from=2026-10-01&to=2026-10-17 (no auth needed)
+// Дневные OHLC-свечи final_rate пары; по умолчанию — последние 30 дней.
+func PublicGetRateHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	pair := q.Get("pair")
+	if pair == "" {
+		pair = "RUB/USD"
+	}
+	currencyFrom, currencyTo, err := usecase.ParseRatePair(pair)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !repository.ExchangeRatePairExists(currencyFrom, currencyTo) {
+		http.Error(w, "Unknown currency pair", http.StatusNotFound)
+		return
+	}
+
+	to := time.Now().UTC()
+	if s := q.Get("to"); s != "" {
+		if to, err = time.Parse("2006-01-02", s); err != nil {
+			http.Error(w, "invalid 'to' date, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+	from := to.AddDate(0, 0, -30)
+	if s := q.Get("from"); s != "" {
+		if from, err = time.Parse("2006-01-02", s); err != nil {
+			http.Error(w, "invalid 'from' date, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+
+	candles, err := usecase.GetDailyRateCandles(currencyFrom, currencyTo, from, to)
+	if err != nil {
+		log.Printf("[EXCHANGE] Rate history %s/%s failed: %v", currencyFrom, currencyTo, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"pair":    currencyFrom + "/" + currencyTo,
+		"from":    from.Format("2006-01-02"),
+		"to":      to.Format("2006-01-02"),
+		"candles": candles,
+	})
+}
+
+// AdminGetRateSnapshotHandler - GET /api/v1/admin/rates/snapshots/{id}
+// Курсы всех пар на момент снимка (transactions.rate_snapshot_id) — с источником, базой и наценкой.
+func AdminGetRateSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	snapshotID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil || snapshotID <= 0 {
+		http.Error(w, "invalid snapshot id", http.StatusBadRequest)
+		return
+	}
+	rates, err := repository.GetRatesAtSnapshot(snapshotID)
+	if err != nil {
+		http.Error(w, "Failed to fetch rate snapshot", http.StatusInternalServerError)
+		return
+	}
+	if len(rates) == 0 {
+		http.Error(w, "Snapshot not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"snapshot_id": snapshotID,
+		"rates":       rates,
+	})
+}
@@ -24,6 +24,14 @@ func txTypeLabelExport(txType string) string {
 		return "Выпуск карты"
 	case "CARD_TOPUP":
 		return "Перевод на карту"
+	case "TEAM_WALLET_TOPUP":
+		return "В Кошелёк команды"
+	case "TEAM_CARD_TOPUP":
+		return "Перевод на карту (команда)"
+	case "TEAM_CARD_ISSUE_FEE":
+		return "Выпуск карты (команда)"
+	case "TEAM_CARD_RECLAIM":
+		return "Возврат в Кошелёк команды"
 	case "CARD_REFUND":
 		return "Возврат"
 	case "WALLET_RECLAIM":
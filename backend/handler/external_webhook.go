@@ -5,10 +5,8 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"time"
 
 	"github.com/djalben/xplr-core/backend/repository"
-	"github.com/djalben/xplr-core/backend/service"
 	"github.com/shopspring/decimal"
 )
 
@@ -74,67 +72,22 @@ func ExternalTopUpWebhookHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Atomic: credit Wallet + record transaction
-	tx, err := repository.GlobalDB.Begin()
-	if err != nil {
-		http.Error(w, "Transaction begin failed", http.StatusInternalServerError)
-		return
-	}
-	defer tx.Rollback()
-
-	// Upsert into internal_balances
-	_, err = tx.Exec(
-		`INSERT INTO internal_balances (user_id, master_balance, updated_at)
-		 VALUES ($1, $2, NOW())
-		 ON CONFLICT (user_id) DO UPDATE SET master_balance = internal_balances.master_balance + $2, updated_at = NOW()`,
-		payload.UserID, amount,
-	)
-	if err != nil {
-		log.Printf("[EXT-WEBHOOK] Failed to credit wallet: %v", err)
-		http.Error(w, "Failed to credit wallet", http.StatusInternalServerError)
-		return
-	}
-
-	// Record transaction with provider_tx_id for idempotency
+	// Atomic: credit Wallet + record transaction with provider_tx_id for idempotency
 	providerName := payload.ProviderName
 	if providerName == "" {
 		providerName = "external"
 	}
-	_, err = tx.Exec(
-		`INSERT INTO transactions (user_id, amount, fee, transaction_type, status, details, provider_tx_id, executed_at)
-		 VALUES ($1, $2, 0, 'DEPOSIT', 'APPROVED', $3, $4, $5)`,
-		payload.UserID, amount,
-		fmt.Sprintf("External top-up via %s: +%s %s", providerName, amount.String(), payload.Currency),
-		payload.ExternalTxID,
-		time.Now(),
-	)
-	if err != nil {
-		log.Printf("[EXT-WEBHOOK] Failed to record tx: %v", err)
-		http.Error(w, "Failed to record transaction", http.StatusInternalServerError)
-		return
-	}
-
-	if err := tx.Commit(); err != nil {
-		http.Error(w, "Commit failed", http.StatusInternalServerError)
+	if err := repository.CreditExternalTopUp(payload.UserID, amount, payload.Currency, providerName, payload.ExternalTxID); err != nil {
+		log.Printf("[EXT-WEBHOOK] Failed to credit wallet: %v", err)
+		http.Error(w, "Failed to credit wallet", http.StatusInternalServerError)
 		return
 	}
 
 	log.Printf("✅ [EXT-WEBHOOK] Credited %s %s to wallet (user %d, tx=%s)",
 		amount.String(), payload.Currency, payload.UserID, payload.ExternalTxID)
-	log.Printf("[EVENT] User %d performed external_topup (amount=%s %s, tx=%s). Triggering notifications...",
+	log.Printf("[EVENT] User %d performed external_topup (amount=%s %s, tx=%s). Notification queued",
 		payload.UserID, amount.String(), payload.Currency, payload.ExternalTxID)
 
-	// Notify user about successful top-up
-	go func() {
-		service.NotifyUser(payload.UserID, "Пополнение баланса",
-			fmt.Sprintf("💰 <b>Кошелёк пополнен</b>\n\n"+
-				"Сумма: <b>%s %s</b>\n"+
-				"Источник: <b>%s</b>\n\n"+
-				"<a href=\"https://xplr.pro/wallet\">Открыть кошелёк</a>",
-				amount.String(), payload.Currency, providerName))
-		log.Printf("[NOTIFY] Message sent to UserID: %d via NotifyUser (external topup)", payload.UserID)
-	}()
-
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{
@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/djalben/xplr-core/backend/middleware"
+	"github.com/djalben/xplr-core/backend/repository"
+)
+
+// CreateFXQuoteHandler - POST /api/v1/user/fx/quote
+// Тело: {"from": "RUB", "to": "USD"}. Возвращает quote_id и курс (сколько to за 1 from),
+// зафиксированный до expires_at. quote_id передаётся в /wallet/topup, /wallet/transfer-to-card
+// и /wallet/convert; котировка исполняется один раз.
+func CreateFXQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok || userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	q, err := repository.CreateFXQuote(userID, req.From, req.To)
+	if errors.Is(err, repository.ErrUnsupportedCurrency) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("[FX-QUOTES] Failed to quote %s→%s for user %d: %v", req.From, req.To, userID, err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"quote_id":      q.ID,
+		"from_currency": q.FromCurrency,
+		"to_currency":   q.ToCurrency,
+		"rate":          q.Rate,
+		"expires_at":    q.ExpiresAt,
+		"ttl_seconds":   int(q.ExpiresAt.Sub(q.CreatedAt).Seconds()),
+	})
+}
+
+// writeFXQuoteError отвечает клиенту, если err — ошибка котировки. Возвращает true, если ответ отправлен.
+func writeFXQuoteError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, repository.ErrFXQuoteNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, repository.ErrFXQuoteExpired):
+		http.Error(w, err.Error(), http.StatusGone)
+	case errors.Is(err, repository.ErrFXQuoteUsed):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, repository.ErrFXQuoteMismatch):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		return false
+	}
+	return true
+}
+
+// AdminGetFXQuotesHandler - GET /api/v1/admin/fx/quotes?used=true&limit=100
+// Аудит FX-маржи: котировки с базовым курсом, наценкой и транзакцией, в которой они исполнены.
+func AdminGetFXQuotesHandler(w http.ResponseWriter, r *http.Request) {
+	usedOnly := r.URL.Query().Get("used") == "true"
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	quotes, err := repository.ListFXQuotes(usedOnly, limit)
+	if err != nil {
+		log.Printf("[FX-QUOTES] Failed to list quotes: %v", err)
+		http.Error(w, "Failed to fetch fx quotes", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(quotes)
+}
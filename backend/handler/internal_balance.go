@@ -2,10 +2,11 @@ package handler
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/djalben/xplr-core/backend/middleware"
 	"github.com/djalben/xplr-core/backend/domain"
@@ -34,8 +35,94 @@ func GetWalletHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(ib)
 }
 
+// walletBalanceString — баланс USD Кошелька для полей "balance" в ответах профиля.
+func walletBalanceString(userID int) string {
+	ib, err := repository.GetInternalBalance(userID)
+	if err != nil {
+		log.Printf("Warning: failed to get wallet for user %d: %v", userID, err)
+		return "0"
+	}
+	return ib.MasterBalance.String()
+}
+
+// GetWalletsHandler — GET /api/v1/user/wallets
+// Возвращает Кошельки пользователя во всех валютах (USD, EUR, RUB, USDT)
+func GetWalletsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok || userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	wallets, err := repository.GetWalletBalances(userID)
+	if err != nil {
+		http.Error(w, "Failed to get wallets: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"wallets": wallets,
+	})
+}
+
+// ConvertWalletHandler — POST /api/v1/user/wallet/convert
+// Тело: {"from": "USD", "to": "EUR", "amount": "100.00", "quote_id": "fxq_..."} — сумма в валюте from.
+// Конвертирует между Кошельками пользователя по курсу exchange_rates или по котировке quote_id.
+func ConvertWalletHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok || userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		From   string          `json:"from"`
+		To     string          `json:"to"`
+		Amount  decimal.Decimal `json:"amount"`
+		QuoteID string          `json:"quote_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Amount.LessThanOrEqual(decimal.Zero) {
+		http.Error(w, "Amount must be positive", http.StatusBadRequest)
+		return
+	}
+	from, err := repository.NormalizeWalletCurrency(req.From)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := repository.NormalizeWalletCurrency(req.To)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	conv, err := repository.ConvertWalletCurrency(userID, from, to, req.Amount, req.QuoteID)
+	if writeFXQuoteError(w, err) {
+		return
+	}
+	if errors.Is(err, repository.ErrInsufficientWalletFunds) {
+		http.Error(w, err.Error(), http.StatusPaymentRequired)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("[EVENT] User %d performed wallet_convert (%s %s → %s %s)", userID,
+		conv.FromAmount.StringFixed(2), conv.FromCurrency, conv.ToAmount.StringFixed(2), conv.ToCurrency)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conv)
+}
+
 // TopUpWalletHandler — POST /api/v1/user/wallet/topup
-// Пополняет Кошелёк из balance_rub пользователя
+// Пополняет Кошелёк рублями (СБП): в USD с конвертацией или в RUB Кошелёк (currency: "RUB")
 func TopUpWalletHandler(w http.ResponseWriter, r *http.Request) {
 	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
 	if !ok || userID == 0 {
@@ -62,31 +149,30 @@ func TopUpWalletHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ib, err := repository.TopUpInternalBalance(userID, req.Amount)
+	ib, err := repository.TopUpInternalBalance(userID, req.Amount, req.Currency, req.QuoteID)
+	if writeFXQuoteError(w, err) {
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("[EVENT] User %d performed wallet_topup (amount=%s RUB, new_balance=$%s). Triggering notifications...", userID, req.Amount.StringFixed(0), ib.MasterBalance.StringFixed(2))
+	log.Printf("[EVENT] User %d performed wallet_topup (amount=%s RUB, wallet=%s, usd_balance=$%s). Triggering notifications...", userID, req.Amount.StringFixed(0), req.Currency, ib.MasterBalance.StringFixed(2))
 
-	// Notify user about successful topup
-	go func() {
-		service.NotifyUser(userID, "Кошелёк пополнен",
-			fmt.Sprintf("💰 <b>Кошелёк пополнен</b>\n\n"+
-				"Сумма: <b>%s ₽</b>\n"+
-				"Баланс: <b>$%s</b>\n\n"+
-				"<a href=\"https://xplr.pro/wallet\">Открыть кошелёк</a>",
-				req.Amount.StringFixed(0), ib.MasterBalance.StringFixed(2)))
-		log.Printf("[NOTIFY] Message sent to UserID: %d via NotifyUser (wallet topup %s RUB)", userID, req.Amount.StringFixed(0))
-	}()
+	// Уведомление пользователю поставлено в очередь вместе с зачислением (TopUpInternalBalance)
+	go service.EmitWebhookEvent(userID, domain.WebhookWalletCredited, map[string]interface{}{
+		"amount": req.Amount.StringFixed(2), "currency": "RUB", "wallet": req.Currency, "source": "sbp",
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(ib)
 }
 
 // TransferWalletToCardHandler — POST /api/v1/user/wallet/transfer-to-card
-// Переводит средства из Кошелька (USD) на баланс карты. Если карта в EUR, конвертирует.
+// Переводит средства на баланс карты из Кошелька в валюте карты. amount — в валюте карты;
+// from_currency (необязательно) — списать из другого Кошелька с конвертацией;
+// quote_id (необязательно) — котировка from_currency → валюта карты из POST /user/fx/quote.
 func TransferWalletToCardHandler(w http.ResponseWriter, r *http.Request) {
 	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
 	if !ok || userID == 0 {
@@ -95,9 +181,11 @@ func TransferWalletToCardHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		CardID   json.Number `json:"card_id"`
-		Amount   float64     `json:"amount"`
-		Currency string      `json:"currency"`
+		CardID       json.Number `json:"card_id"`
+		Amount       float64     `json:"amount"`
+		Currency     string      `json:"currency"` // Валюта карты (для уведомления); списание определяется cards.currency
+		FromCurrency string      `json:"from_currency"`
+		QuoteID      string      `json:"quote_id"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
@@ -117,10 +205,36 @@ func TransferWalletToCardHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ib, err := repository.TransferWalletToCard(userID, cardID, amount, req.Currency)
+	// Уведомление ставится в очередь вместе с подтверждением пополнения
+	var cardLast4 string
+	curr := req.Currency
+	if card, err := repository.GetCardByID(cardID); err == nil {
+		cardLast4 = card.Last4Digits
+		curr = card.Currency
+	}
+	if curr == "" {
+		curr = "USD"
+	}
+	funded := &domain.UserNotification{
+		UserID:   userID,
+		Template: domain.NotifyCardFunded,
+		Amount:   amount,
+		Data:     map[string]string{"last4": cardLast4, "amount": amount.StringFixed(2), "currency": curr},
+	}
+
+	// Пополнение уходит эмитенту, выпустившему карту (cards.provider)
+	ib, err := repository.TransferWalletToCard(userID, cardID, amount, req.FromCurrency, req.QuoteID, service.CardFundFunc(cardID), funded)
+	if writeFXQuoteError(w, err) {
+		return
+	}
 	if err != nil {
-		if err.Error() == "нет доступа к этой карте" || err.Error() == "карта не найдена" {
+		if strings.HasPrefix(err.Error(), "эмитент отклонил") {
+			log.Printf("[FUND-CARD] Provider rejected top-up of card %d for user %d: %v", cardID, userID, err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		} else if err.Error() == "нет доступа к этой карте" || err.Error() == "карта не найдена" {
 			http.Error(w, err.Error(), http.StatusForbidden)
+		} else if errors.Is(err, repository.ErrUnsupportedCurrency) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 		} else if err.Error() == "кошелёк не найден — пополните баланс" {
 			http.Error(w, err.Error(), http.StatusPaymentRequired)
 		} else {
@@ -129,25 +243,7 @@ func TransferWalletToCardHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("[EVENT] User %d performed fund_card (card=%d, amount=%s %s). Triggering notifications...", userID, cardID, amount.StringFixed(2), req.Currency)
-
-	// Notify user about card funding
-	go func() {
-		var cardLast4 string
-		if card, err := repository.GetCardByID(cardID); err == nil {
-			cardLast4 = card.Last4Digits
-		}
-		curr := req.Currency
-		if curr == "" {
-			curr = "USD"
-		}
-		service.NotifyUser(userID, "Карта пополнена",
-			fmt.Sprintf("💳 <b>Карта пополнена</b>\n\n"+
-				"Карта: *%s\n"+
-				"Сумма: <b>%s %s</b>\n\n"+
-				"<a href=\"https://xplr.pro/cards\">Открыть карты</a>",
-				cardLast4, amount.StringFixed(2), curr))
-	}()
+	log.Printf("[EVENT] User %d performed fund_card (card=%d, amount=%s %s)", userID, cardID, amount.StringFixed(2), curr)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(ib)
@@ -179,7 +275,14 @@ func SetAutoTopupHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := repository.GlobalDB.Exec(
+	tx, err := repository.GlobalDB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to update auto-topup: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
 		`INSERT INTO internal_balances (user_id, auto_topup_enabled) VALUES ($1, $2)
 		 ON CONFLICT (user_id) DO UPDATE SET auto_topup_enabled = $2, updated_at = NOW()`,
 		userID, req.Enabled,
@@ -189,23 +292,25 @@ func SetAutoTopupHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("[AUTO-TOPUP] User %d set auto_topup_enabled = %v", userID, req.Enabled)
-
-	// Уведомление пользователя (Telegram + Email) об изменении настройки
-	statusText := "выключено ❌"
+	// Уведомление пользователя (Telegram + Email) фиксируется вместе с настройкой
+	enabled := ""
 	if req.Enabled {
-		statusText = "включено ✅"
-	}
-	go service.NotifyUser(userID, "Автопополнение "+statusText,
-		fmt.Sprintf("⚙️ <b>Настройка изменена</b>\n\n"+
-			"Автопополнение карт: <b>%s</b>\n\n"+
-			"При нехватке средств на карте система %s переводить средства из Кошелька автоматически.",
-			statusText, func() string {
-				if req.Enabled {
-					return "будет"
-				}
-				return "не будет"
-			}()))
+		enabled = "1"
+	}
+	err = repository.EnqueueNotification(tx, domain.UserNotification{
+		UserID:   userID,
+		Template: domain.NotifyAutoTopUpChanged,
+		Data:     map[string]string{"enabled": enabled},
+	})
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		http.Error(w, "Failed to update auto-topup: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("[AUTO-TOPUP] User %d set auto_topup_enabled = %v", userID, req.Enabled)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
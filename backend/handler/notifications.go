@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/djalben/xplr-core/backend/middleware"
+	"github.com/djalben/xplr-core/backend/repository"
+	"github.com/djalben/xplr-core/backend/usecase"
+	"github.com/gorilla/mux"
+)
+
+// AdminGetNotificationsHandler - GET /api/v1/admin/notifications?status=FAILED&channel=email&user_id=5&limit=100
+// Очередь уведомлений (notification_outbox), новые сверху.
+func AdminGetNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	userID, _ := strconv.Atoi(q.Get("user_id"))
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	list, err := repository.ListNotifications(q.Get("status"), q.Get("channel"), userID, limit)
+	if err != nil {
+		log.Printf("[NOTIFY-OUTBOX] Failed to list notifications: %v", err)
+		http.Error(w, "Failed to fetch notifications", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// AdminGetUserNotificationDeliveriesHandler - GET /api/v1/admin/users/{id}/notifications?limit=100
+// Журнал доставки уведомлений пользователя: каждая попытка с каналом, получателем и ошибкой.
+func AdminGetUserNotificationDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || userID <= 0 {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	deliveries, err := repository.ListUserNotificationDeliveries(userID, limit)
+	if err != nil {
+		log.Printf("[NOTIFY-OUTBOX] Failed to list deliveries of user %d: %v", userID, err)
+		http.Error(w, "Failed to fetch notification deliveries", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// AdminResendNotificationHandler - POST /api/v1/admin/notifications/{id}/resend
+// Возвращает проваленное уведомление в очередь.
+func AdminResendNotificationHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, _ := r.Context().Value(middleware.UserIDKey).(int)
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid notification ID", http.StatusBadRequest)
+		return
+	}
+	if err := repository.ResendNotification(id); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrNotificationNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, repository.ErrNotificationNotFailed):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			log.Printf("[NOTIFY-OUTBOX] Failed to resend notification #%d: %v", id, err)
+			http.Error(w, "Failed to resend notification", http.StatusInternalServerError)
+		}
+		return
+	}
+	repository.WriteAdminLog(adminID, fmt.Sprintf("Повторная отправка уведомления #%d", id))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "status": "PENDING"})
+}
+
+// AdminResendFailedNotificationsHandler - POST /api/v1/admin/notifications/resend-failed?user_id=5
+// Возвращает в очередь все проваленные уведомления (или только уведомления пользователя).
+func AdminResendFailedNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, _ := r.Context().Value(middleware.UserIDKey).(int)
+	userID, _ := strconv.Atoi(r.URL.Query().Get("user_id"))
+	count, err := repository.ResendFailedNotifications(userID)
+	if err != nil {
+		log.Printf("[NOTIFY-OUTBOX] Failed to resend failed notifications: %v", err)
+		http.Error(w, "Failed to resend notifications", http.StatusInternalServerError)
+		return
+	}
+	action := fmt.Sprintf("Повторная отправка %d проваленных уведомлений", count)
+	if userID > 0 {
+		action += fmt.Sprintf(" пользователя %d", userID)
+	}
+	repository.WriteAdminLog(adminID, action)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"requeued": count})
+}
+
+// NotificationOutboxCronHandler - GET /api/v1/cron/notifications
+// Отправляет уведомления из очереди (Vercel cron, защищён CRON_SECRET: в serverless нет фоновых воркеров).
+func NotificationOutboxCronHandler(w http.ResponseWriter, r *http.Request) {
+	cronSecret := os.Getenv("CRON_SECRET")
+	if cronSecret != "" && r.Header.Get("Authorization") != "Bearer "+cronSecret {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	processed, err := usecase.ProcessNotificationOutbox()
+	if err != nil {
+		log.Printf("[NOTIFY-OUTBOX] Cron failed: %v", err)
+		http.Error(w, "Failed to dispatch notifications", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"processed": processed})
+}
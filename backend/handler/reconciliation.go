@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/djalben/xplr-core/backend/repository"
+	"github.com/djalben/xplr-core/backend/usecase"
+)
+
+// AdminGetReconciliationHandler - GET /api/v1/admin/reconciliation
+// Returns the latest reconciliation run (or ?run_id=N) with its drift rows, plus recent run history.
+func AdminGetReconciliationHandler(w http.ResponseWriter, r *http.Request) {
+	runID, _ := strconv.Atoi(r.URL.Query().Get("run_id"))
+
+	run, drifts, err := repository.GetReconciliationRun(runID)
+	if err != nil {
+		log.Printf("[RECONCILE] Failed to load run: %v", err)
+		http.Error(w, "Failed to load reconciliation report", http.StatusInternalServerError)
+		return
+	}
+	if run == nil && runID > 0 {
+		http.Error(w, "Reconciliation run not found", http.StatusNotFound)
+		return
+	}
+
+	history, err := repository.ListReconciliationRuns(30)
+	if err != nil {
+		log.Printf("[RECONCILE] Failed to list runs: %v", err)
+		http.Error(w, "Failed to load reconciliation report", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"run":     run,
+		"drifts":  drifts,
+		"history": history,
+	})
+}
+
+// AdminRunReconciliationHandler - POST /api/v1/admin/reconciliation/run
+// Runs reconciliation immediately (manual trigger).
+func AdminRunReconciliationHandler(w http.ResponseWriter, r *http.Request) {
+	run, drifts, err := usecase.RunReconciliation()
+	if err != nil {
+		log.Printf("[RECONCILE] Manual run failed: %v", err)
+		http.Error(w, "Reconciliation failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"run":    run,
+		"drifts": drifts,
+	})
+}
+
+// ReconciliationCronHandler is called by Vercel Cron nightly (serverless has no background workers).
+// Protected by CRON_SECRET header check.
+func ReconciliationCronHandler(w http.ResponseWriter, r *http.Request) {
+	cronSecret := os.Getenv("CRON_SECRET")
+	if cronSecret != "" && r.Header.Get("Authorization") != "Bearer "+cronSecret {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	AdminRunReconciliationHandler(w, r)
+}
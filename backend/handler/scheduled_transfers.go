@@ -0,0 +1,168 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/middleware"
+	"github.com/djalben/xplr-core/backend/repository"
+	"github.com/djalben/xplr-core/backend/usecase"
+	"github.com/gorilla/mux"
+	"github.com/shopspring/decimal"
+)
+
+// GetScheduledTransfersHandler - GET /api/v1/user/scheduled-transfers
+// Правила переводов из Кошелька на карты по расписанию.
+func GetScheduledTransfersHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok || userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	rules, err := repository.ListUserScheduledTransfers(userID)
+	if err != nil {
+		log.Printf("[SCHEDULED-TRANSFERS] Failed to list rules of user %d: %v", userID, err)
+		http.Error(w, "Failed to fetch scheduled transfers", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+// CreateScheduledTransferHandler - POST /api/v1/user/scheduled-transfers
+// Тело: {"card_id": 12, "amount": 200, "schedule": "0 9 * * 1", "timezone": "Europe/Moscow"}
+// или {"team_id": 3, "amount": 1000, "currency": "USD", "schedule": "0 9 1 * *"} — сумма делится
+// поровну между активными картами команды в этой валюте. from_currency — списывать из другого Кошелька.
+func CreateScheduledTransferHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok || userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		CardID       *int            `json:"card_id"`
+		TeamID       *int            `json:"team_id"`
+		Amount       decimal.Decimal `json:"amount"`
+		Currency     string          `json:"currency"`
+		FromCurrency string          `json:"from_currency"`
+		Schedule     string          `json:"schedule"`
+		Timezone     string          `json:"timezone"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	rule := &domain.ScheduledTransfer{
+		UserID:       userID,
+		CardID:       req.CardID,
+		TeamID:       req.TeamID,
+		Amount:       req.Amount,
+		Currency:     req.Currency,
+		FromCurrency: req.FromCurrency,
+		Schedule:     req.Schedule,
+		Timezone:     req.Timezone,
+	}
+	if err := usecase.CreateScheduledTransfer(rule); err != nil {
+		writeScheduledTransferError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rule)
+}
+
+// UpdateScheduledTransferHandler - PATCH /api/v1/user/scheduled-transfers/{id}
+// Тело: {"is_active": false} — пауза; {"is_active": true} — возобновить со следующего срока.
+func UpdateScheduledTransferHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok || userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	ruleID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid rule ID", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		IsActive *bool `json:"is_active"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.IsActive == nil {
+		http.Error(w, "is_active is required", http.StatusBadRequest)
+		return
+	}
+	rule, err := usecase.SetScheduledTransferActive(ruleID, userID, *req.IsActive)
+	if err != nil {
+		writeScheduledTransferError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rule)
+}
+
+// DeleteScheduledTransferHandler - DELETE /api/v1/user/scheduled-transfers/{id}
+func DeleteScheduledTransferHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok || userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	ruleID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid rule ID", http.StatusBadRequest)
+		return
+	}
+	if err := repository.DeleteScheduledTransfer(ruleID, userID); err != nil {
+		writeScheduledTransferError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetScheduledTransferRunsHandler - GET /api/v1/user/scheduled-transfers/{id}/runs?limit=50
+// Журнал попыток правила: по строке на каждую карту и попытку.
+func GetScheduledTransferRunsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok || userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	ruleID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid rule ID", http.StatusBadRequest)
+		return
+	}
+	if _, err := repository.GetScheduledTransfer(ruleID, userID); err != nil {
+		writeScheduledTransferError(w, err)
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	runs, err := repository.ListScheduledTransferRuns(ruleID, limit)
+	if err != nil {
+		log.Printf("[SCHEDULED-TRANSFERS] Failed to list runs of rule %d: %v", ruleID, err)
+		http.Error(w, "Failed to fetch runs", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(runs)
+}
+
+func writeScheduledTransferError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Scheduled transfer not found", http.StatusNotFound)
+	case errors.Is(err, usecase.ErrScheduledTransferInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, usecase.ErrScheduledTransferForbidden):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		log.Printf("[SCHEDULED-TRANSFERS] Request failed: %v", err)
+		http.Error(w, "Failed to process scheduled transfer", http.StatusInternalServerError)
+	}
+}
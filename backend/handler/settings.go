@@ -5,11 +5,13 @@ import (
 	"encoding/base32"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strings"
 
+	"github.com/djalben/xplr-core/backend/domain"
 	"github.com/djalben/xplr-core/backend/middleware"
 	"github.com/djalben/xplr-core/backend/repository"
 	"github.com/djalben/xplr-core/backend/service"
@@ -173,12 +175,19 @@ func GetNotificationPrefsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	notifPref := repository.GetNotificationPref(userID)
+	events, err := repository.GetNotificationEventPrefs(userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch notifications", http.StatusInternalServerError)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"notify_transactions": prefs.NotifyTransactions,
 		"notify_balance":      prefs.NotifyBalance,
 		"notify_security":     prefs.NotifySecurity,
 		"notification_pref":   notifPref,
+		"language":            repository.GetUserLanguage(userID),
+		"events":              events,
 	})
 }
 
@@ -194,12 +203,27 @@ func UpdateNotificationPrefsHandler(w http.ResponseWriter, r *http.Request) {
 		NotifyBalance      *bool   `json:"notify_balance,omitempty"`
 		NotifySecurity     *bool   `json:"notify_security,omitempty"`
 		NotificationPref   *string `json:"notification_pref,omitempty"`
+		Language           *string `json:"language,omitempty"` // Язык уведомлений: ru, en
+		// Настройки по типам событий: каналы и порог суточной сводки; не перечисленные не меняются
+		Events []domain.NotificationEventPref `json:"events,omitempty"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid body", http.StatusBadRequest)
 		return
 	}
 
+	if len(req.Events) > 0 {
+		err := repository.SetNotificationEventPrefs(userID, req.Events)
+		if errors.Is(err, repository.ErrInvalidNotificationPref) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to update notifications", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	// Update notification_pref channel if provided
 	if req.NotificationPref != nil {
 		pref := *req.NotificationPref
@@ -209,6 +233,13 @@ func UpdateNotificationPrefsHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if req.Language != nil {
+		if err := repository.SetUserLanguage(userID, *req.Language); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	// Update toggle prefs
 	prefs, _ := repository.GetNotificationPrefs(userID)
 	if req.NotifyTransactions != nil {
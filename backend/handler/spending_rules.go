@@ -0,0 +1,240 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/middleware"
+	"github.com/djalben/xplr-core/backend/repository"
+	"github.com/djalben/xplr-core/backend/usecase"
+	"github.com/gorilla/mux"
+	"github.com/shopspring/decimal"
+)
+
+// spendingRuleRequest — тело POST правила расходов.
+type spendingRuleRequest struct {
+	RuleType   string          `json:"rule_type"`
+	Value      string          `json:"value"`
+	MonthlyCap decimal.Decimal `json:"monthly_cap"`
+	Timezone   string          `json:"timezone"`
+}
+
+// canManageCardRules — правилами карты управляет её владелец, а для командной карты также
+// участник с правом team.manage.
+func canManageCardRules(card domain.Card, userID int) bool {
+	if card.UserID == userID {
+		return true
+	}
+	if card.TeamID == nil {
+		return false
+	}
+	allowed, err := repository.HasTeamPermission(*card.TeamID, userID, domain.PermTeamManage)
+	return err == nil && allowed
+}
+
+// loadRuleCard разбирает {id} и проверяет, что пользователь может управлять правилами карты.
+func loadRuleCard(w http.ResponseWriter, r *http.Request) (domain.Card, int, bool) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok || userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return domain.Card{}, 0, false
+	}
+	cardID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || cardID <= 0 {
+		http.Error(w, "invalid card id", http.StatusBadRequest)
+		return domain.Card{}, 0, false
+	}
+	card, err := repository.GetCardByID(cardID)
+	if err != nil || !canManageCardRules(card, userID) {
+		http.Error(w, "Card not found", http.StatusNotFound)
+		return domain.Card{}, 0, false
+	}
+	return card, userID, true
+}
+
+// decodeSpendingRule читает и проверяет правило из тела запроса.
+func decodeSpendingRule(w http.ResponseWriter, r *http.Request, userID int) (*domain.SpendingRule, bool) {
+	var req spendingRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return nil, false
+	}
+	rule := &domain.SpendingRule{
+		RuleType:   strings.ToLower(strings.TrimSpace(req.RuleType)),
+		Value:      strings.TrimSpace(req.Value),
+		MonthlyCap: req.MonthlyCap,
+		Timezone:   strings.TrimSpace(req.Timezone),
+		IsActive:   true,
+		CreatedBy:  userID,
+	}
+	if err := usecase.ValidateSpendingRule(*rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return rule, true
+}
+
+// GetCardSpendingRulesHandler - GET /api/v1/user/cards/{id}/spending-rules
+// Возвращает правила карты и правила её команды (применяются вместе).
+func GetCardSpendingRulesHandler(w http.ResponseWriter, r *http.Request) {
+	card, _, ok := loadRuleCard(w, r)
+	if !ok {
+		return
+	}
+	rules, err := repository.ListCardSpendingRules(card.ID)
+	if err != nil {
+		log.Printf("[SPENDING-RULES] Failed to list rules for card %d: %v", card.ID, err)
+		http.Error(w, "Failed to load rules", http.StatusInternalServerError)
+		return
+	}
+	teamRules := []domain.SpendingRule{}
+	if card.TeamID != nil {
+		if teamRules, err = repository.ListTeamSpendingRules(*card.TeamID); err != nil {
+			log.Printf("[SPENDING-RULES] Failed to list team rules for card %d: %v", card.ID, err)
+			http.Error(w, "Failed to load rules", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"card_rules": rules,
+		"team_rules": teamRules,
+	})
+}
+
+// CreateCardSpendingRuleHandler - POST /api/v1/user/cards/{id}/spending-rules
+func CreateCardSpendingRuleHandler(w http.ResponseWriter, r *http.Request) {
+	card, userID, ok := loadRuleCard(w, r)
+	if !ok {
+		return
+	}
+	rule, ok := decodeSpendingRule(w, r, userID)
+	if !ok {
+		return
+	}
+	rule.CardID = &card.ID
+	if err := repository.CreateSpendingRule(rule); err != nil {
+		log.Printf("[SPENDING-RULES] Failed to create rule for card %d: %v", card.ID, err)
+		http.Error(w, "Failed to save rule", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[SPENDING-RULES] Rule #%d %s=%q added to card %d by user %d", rule.ID, rule.RuleType, rule.Value, card.ID, userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rule)
+}
+
+// DeleteCardSpendingRuleHandler - DELETE /api/v1/user/cards/{id}/spending-rules/{ruleId}
+func DeleteCardSpendingRuleHandler(w http.ResponseWriter, r *http.Request) {
+	card, userID, ok := loadRuleCard(w, r)
+	if !ok {
+		return
+	}
+	ruleID, _ := strconv.Atoi(mux.Vars(r)["ruleId"])
+	rule, err := repository.GetSpendingRule(ruleID)
+	if err != nil || rule == nil || rule.CardID == nil || *rule.CardID != card.ID {
+		http.Error(w, "rule not found", http.StatusNotFound)
+		return
+	}
+	if err := repository.DeleteSpendingRule(ruleID); err != nil {
+		http.Error(w, "Failed to delete rule", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[SPENDING-RULES] Rule #%d removed from card %d by user %d", ruleID, card.ID, userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+}
+
+// GetCardAuthDeclinesHandler - GET /api/v1/user/cards/{id}/declines
+// Последние отклонённые авторизации по карте с правилом, которое их отклонило.
+func GetCardAuthDeclinesHandler(w http.ResponseWriter, r *http.Request) {
+	card, _, ok := loadRuleCard(w, r)
+	if !ok {
+		return
+	}
+	declines, err := repository.ListAuthorizationDeclines(card.ID, 100)
+	if err != nil {
+		log.Printf("[SPENDING-RULES] Failed to list declines for card %d: %v", card.ID, err)
+		http.Error(w, "Failed to load declines", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(declines)
+}
+
+// teamRulesAccess разбирает {id} команды. Право team.manage проверяет RequireTeamPermission на маршруте.
+func teamRulesAccess(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	userID, teamID, ok := teamRequestContext(w, r)
+	return teamID, userID, ok
+}
+
+// GetTeamSpendingRulesHandler - GET /api/v1/user/teams/{id}/spending-rules
+func GetTeamSpendingRulesHandler(w http.ResponseWriter, r *http.Request) {
+	teamID, _, ok := teamRulesAccess(w, r)
+	if !ok {
+		return
+	}
+	rules, err := repository.ListTeamSpendingRules(teamID)
+	if err != nil {
+		log.Printf("[SPENDING-RULES] Failed to list rules for team %d: %v", teamID, err)
+		http.Error(w, "Failed to load rules", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+// CreateTeamSpendingRuleHandler - POST /api/v1/user/teams/{id}/spending-rules
+// Правило применяется ко всем картам команды.
+func CreateTeamSpendingRuleHandler(w http.ResponseWriter, r *http.Request) {
+	teamID, userID, ok := teamRulesAccess(w, r)
+	if !ok {
+		return
+	}
+	rule, ok := decodeSpendingRule(w, r, userID)
+	if !ok {
+		return
+	}
+	rule.TeamID = &teamID
+	if err := repository.CreateSpendingRule(rule); err != nil {
+		log.Printf("[SPENDING-RULES] Failed to create rule for team %d: %v", teamID, err)
+		http.Error(w, "Failed to save rule", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[SPENDING-RULES] Rule #%d %s=%q added to team %d by user %d", rule.ID, rule.RuleType, rule.Value, teamID, userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rule)
+}
+
+// DeleteTeamSpendingRuleHandler - DELETE /api/v1/user/teams/{id}/spending-rules/{ruleId}
+func DeleteTeamSpendingRuleHandler(w http.ResponseWriter, r *http.Request) {
+	teamID, userID, ok := teamRulesAccess(w, r)
+	if !ok {
+		return
+	}
+	ruleID, _ := strconv.Atoi(mux.Vars(r)["ruleId"])
+	rule, err := repository.GetSpendingRule(ruleID)
+	if err != nil || rule == nil || rule.TeamID == nil || *rule.TeamID != teamID {
+		http.Error(w, "rule not found", http.StatusNotFound)
+		return
+	}
+	if err := repository.DeleteSpendingRule(ruleID); err != nil {
+		http.Error(w, "Failed to delete rule", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[SPENDING-RULES] Rule #%d removed from team %d by user %d", ruleID, teamID, userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+}
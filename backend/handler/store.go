@@ -10,6 +10,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/djalben/xplr-core/backend/domain"
 	"github.com/djalben/xplr-core/backend/middleware"
 	"github.com/djalben/xplr-core/backend/providers"
 	"github.com/djalben/xplr-core/backend/providers/vless"
@@ -72,10 +73,10 @@ func InitShopInfrastructure() {
 	shopFulfillment = shop.NewFulfillmentEngine(
 		GlobalDB,
 		registry,
-		// UserNotifier — wraps service.NotifyUser
-		func(userID int, subject, tgMsg, _ string) {
-			service.NotifyUser(userID, subject, tgMsg)
-		},
+		// UserNotifier — service.NotifyUserTemplate
+		service.NotifyUserTemplate,
+		// EventEmitter — service.EmitWebhookEvent (order.ready)
+		service.EmitWebhookEvent,
 		// AdminNotifier — wraps service.NotifyAdmins
 		service.NotifyAdmins,
 		// PremiumEmailSender — wraps service.SendPurchaseReceipt
@@ -414,7 +415,7 @@ func StorePurchaseHandler(w http.ResponseWriter, r *http.Request) {
 		userID, product.Name, product.PriceUSD.StringFixed(2), cardID, orderID)
 
 	// 6. Notify user
-	go notifyStorePurchase(userID, product, activationKey, qrData)
+	go notifyStorePurchase(userID, orderID, product, activationKey, qrData)
 
 	// 7. Return result
 	w.Header().Set("Content-Type", "application/json")
@@ -595,7 +596,14 @@ func callRazerGold(product StoreProduct) (string, string, string, error) {
 // Notification after purchase
 // ══════════════════════════════════════════════════════════════
 
-func notifyStorePurchase(userID int, product StoreProduct, activationKey, qrData string) {
+func notifyStorePurchase(userID, orderID int, product StoreProduct, activationKey, qrData string) {
+	service.EmitWebhookEvent(userID, domain.WebhookOrderReady, map[string]interface{}{
+		"order_id":     orderID,
+		"product":      product.Name,
+		"product_type": product.ProductType,
+		"price":        product.PriceUSD.StringFixed(2),
+	})
+
 	// ── VPN-specific notifications ──
 	if product.Provider == "vless" || product.ProductType == "vpn" {
 		go notifyVPNPurchase(userID, product, activationKey)
@@ -651,11 +659,11 @@ func notifyStorePurchase(userID int, product StoreProduct, activationKey, qrData
 			<a href="https://xplr.pro/store" style="display:inline-block;padding:14px 40px;background:linear-gradient(135deg,#3b82f6,#8b5cf6);color:#fff;text-decoration:none;border-radius:12px;font-size:14px;font-weight:600;">Открыть магазин</a>
 		</div>`
 
-	// If product has an image, use NotifyUserNews (sends photo in TG + image in email)
+	// If product has an image, use NotifyUserEventWithImage (sends photo in TG + image in email)
 	if product.ImageURL != "" {
-		service.NotifyUserNews(userID, "Покупка в XPLR Store", tgMsg, emailBody, product.ImageURL)
+		service.NotifyUserEventWithImage(userID, domain.NotificationEventPurchases, "Покупка в XPLR Store", tgMsg, emailBody, product.ImageURL)
 	} else {
-		service.NotifyUser(userID, "Покупка в XPLR Store", tgMsg)
+		service.NotifyUserEvent(userID, domain.NotificationEventPurchases, "Покупка в XPLR Store", tgMsg)
 		go func() {
 			user, err := repository.GetUserByID(userID)
 			if err != nil || user.Email == "" {
@@ -701,7 +709,7 @@ func notifyVPNPurchase(userID int, product StoreProduct, activationKey string) {
 		"• <a href=\"https://apps.apple.com/app/happ-proxy-utility/id6504287215\">Happ Proxy (iOS)</a>\n\n"+
 		"<a href=\"https://xplr.pro/purchases\">Мои покупки</a>",
 		product.Name, product.PriceUSD.StringFixed(2))
-	service.NotifyUser(userID, "VPN подключен — XPLR", tgMsg)
+	service.NotifyUserEvent(userID, domain.NotificationEventPurchases, "VPN подключен — XPLR", tgMsg)
 
 	// 3. Email with VLESS link + download buttons
 	if user.Email == "" {
@@ -949,7 +957,7 @@ func ESIMOrderHandler(w http.ResponseWriter, r *http.Request) {
 			Name:     productName,
 			PriceUSD: price,
 		}
-		notifyStorePurchase(userID, product, "", result.QRData)
+		notifyStorePurchase(userID, orderID, product, "", result.QRData)
 	}()
 
 	// 6. Return full result
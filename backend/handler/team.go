@@ -1,15 +1,20 @@
 package handler
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/djalben/xplr-core/backend/middleware"
 	"github.com/djalben/xplr-core/backend/domain"
 	"github.com/djalben/xplr-core/backend/repository"
+	"github.com/djalben/xplr-core/backend/service"
+	"github.com/djalben/xplr-core/backend/usecase"
 )
 
 // CreateTeamHandler - POST /api/v1/user/teams
@@ -111,11 +116,23 @@ func GetTeamHandler(w http.ResponseWriter, r *http.Request) {
 		"members": members,
 	}
 
+	// Приглашения видят участники с правом members.invite
+	if canInvite, _ := repository.HasTeamPermission(teamID, userID, domain.PermMembersInvite); canInvite {
+		invitations, err := repository.ListTeamInvitations(teamID)
+		if err != nil {
+			log.Printf("Error fetching team invitations: %v", err)
+			invitations = []domain.TeamInvitation{}
+		}
+		response["invitations"] = invitations
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
 // InviteTeamMemberHandler - POST /api/v1/user/teams/{id}/members
+// Тело: {"email": "buyer@example.com", "role": "buyer"} или {"email": ..., "role_id": 5}.
+// Создаёт приглашение и отправляет ссылку на email; участник добавляется после принятия.
 func InviteTeamMemberHandler(w http.ResponseWriter, r *http.Request) {
 	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
 	if !ok || userID == 0 {
@@ -146,9 +163,9 @@ func InviteTeamMemberHandler(w http.ResponseWriter, r *http.Request) {
 		req.Role = "member" // По умолчанию
 	}
 
-	err = repository.InviteTeamMember(teamID, userID, req.Email, req.Role)
+	invitation, token, err := repository.CreateTeamInvitation(teamID, userID, req.Email, req.Role, req.RoleID)
 	if err != nil {
-		if err.Error() == "access denied" || err.Error() == "insufficient permissions" {
+		if err.Error() == "access denied" || strings.HasPrefix(err.Error(), "insufficient permissions") {
 			http.Error(w, err.Error(), http.StatusForbidden)
 			return
 		}
@@ -157,10 +174,107 @@ func InviteTeamMemberHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	inviterEmail := ""
+	if inviter, err := repository.GetUserByID(userID); err == nil {
+		inviterEmail = inviter.Email
+	}
+	if err := service.SendTeamInvitationEmail(invitation.Email, invitation.TeamName, inviterEmail, token, invitation.ExpiresAt); err != nil {
+		log.Printf("[TEAM-INVITE] Failed to email invitation #%d to %s: %v", invitation.ID, invitation.Email, err)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Team member invited successfully",
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":    "Invitation sent",
+		"invitation": invitation,
+	})
+}
+
+// RevokeTeamInvitationHandler - DELETE /api/v1/user/teams/{id}/invitations/{inviteId}
+func RevokeTeamInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	teamID, err := strconv.Atoi(vars["id"])
+	if err != nil || teamID <= 0 {
+		http.Error(w, "Invalid team ID", http.StatusBadRequest)
+		return
+	}
+	inviteID, err := strconv.Atoi(vars["inviteId"])
+	if err != nil || inviteID <= 0 {
+		http.Error(w, "Invalid invitation ID", http.StatusBadRequest)
+		return
+	}
+	if err := repository.RevokeTeamInvitation(teamID, inviteID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Pending invitation not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("[TEAM-INVITE] Failed to revoke invitation #%d: %v", inviteID, err)
+		http.Error(w, "Failed to revoke invitation", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeTeamInvitationError отвечает на ошибку проверки или принятия приглашения.
+func writeTeamInvitationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repository.ErrTeamInvitationInvalid):
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+	case errors.Is(err, repository.ErrTeamInvitationExpired), errors.Is(err, repository.ErrTeamInvitationClosed):
+		http.Error(w, err.Error(), http.StatusGone)
+	case errors.Is(err, repository.ErrTeamInvitationEmailMismatch):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		log.Printf("[TEAM-INVITE] Request failed: %v", err)
+		http.Error(w, "Failed to process invitation", http.StatusInternalServerError)
+	}
+}
+
+// GetTeamInvitationHandler - GET /api/v1/auth/team-invitation?token=...
+// Публичный просмотр приглашения для страницы принятия: команда, роль, срок и есть ли уже аккаунт
+// (account_exists=false — фронтенд предлагает регистрацию с invite_token).
+func GetTeamInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	invitation, err := repository.GetTeamInvitationByToken(r.URL.Query().Get("token"))
+	if err != nil {
+		writeTeamInvitationError(w, err)
+		return
+	}
+	_, lookupErr := repository.GetUserByEmail(invitation.Email)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"team_id":        invitation.TeamID,
+		"team_name":      invitation.TeamName,
+		"email":          invitation.Email,
+		"role":           invitation.Role,
+		"expires_at":     invitation.ExpiresAt,
+		"account_exists": lookupErr == nil,
+	})
+}
+
+// AcceptTeamInvitationHandler - POST /api/v1/user/team-invitations/accept
+// Тело: {"token": "..."} — принять приглашение текущим пользователем (email должен совпадать).
+func AcceptTeamInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok || userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "token is required", http.StatusBadRequest)
+		return
+	}
+	invitation, err := repository.AcceptTeamInvitation(req.Token, userID)
+	if err != nil {
+		writeTeamInvitationError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":    "Invitation accepted",
+		"invitation": invitation,
 	})
 }
 
@@ -187,9 +301,13 @@ func RemoveTeamMemberHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = repository.RemoveTeamMember(teamID, memberID, userID)
+	// ?cards=reassign&to=5&balance=team_wallet — передать карты участника (to=0 или без to — владельцу команды);
+	// ?cards=freeze — заморозить их; без параметра карты остаются за участником
+	q := r.URL.Query()
+	toUserID, _ := strconv.Atoi(q.Get("to"))
+	result, err := usecase.RemoveTeamMember(teamID, memberID, userID, q.Get("cards"), toUserID, q.Get("balance"))
 	if err != nil {
-		if err.Error() == "access denied" || err.Error() == "insufficient permissions" {
+		if err.Error() == "access denied" || strings.HasPrefix(err.Error(), "insufficient permissions") || errors.Is(err, repository.ErrTeamAccessDenied) {
 			http.Error(w, err.Error(), http.StatusForbidden)
 			return
 		}
@@ -198,11 +316,10 @@ func RemoveTeamMemberHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	result["message"] = "Team member removed successfully"
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Team member removed successfully",
-	})
+	json.NewEncoder(w).Encode(result)
 }
 
 // UpdateTeamMemberRoleHandler - PATCH /api/v1/user/teams/{id}/members/{userId}/role
@@ -234,9 +351,14 @@ func UpdateTeamMemberRoleHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = repository.UpdateTeamMemberRole(teamID, memberID, req.Role, userID)
+	if req.Role == "" && req.RoleID == nil {
+		http.Error(w, "role or role_id is required", http.StatusBadRequest)
+		return
+	}
+
+	err = repository.UpdateTeamMemberRole(teamID, memberID, req.Role, req.RoleID, userID)
 	if err != nil {
-		if err.Error() == "access denied" || err.Error() == "insufficient permissions" {
+		if err.Error() == "access denied" || strings.HasPrefix(err.Error(), "insufficient permissions") {
 			http.Error(w, err.Error(), http.StatusForbidden)
 			return
 		}
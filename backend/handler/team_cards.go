@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/djalben/xplr-core/backend/repository"
+	"github.com/djalben/xplr-core/backend/usecase"
+)
+
+// ReassignTeamCardsHandler - POST /api/v1/user/teams/{id}/cards/reassign
+// Тело: {"card_ids": [12, 13], "to_user_id": 7, "balance": "keep", "reason": "..."} или
+// {"from_user_id": 5, "to_user_id": 0, "balance": "team_wallet"} — все карты участника владельцу команды.
+// balance: keep — остаток переходит с картой, team_wallet — возвращается в Кошелёк команды (право team.manage).
+func ReassignTeamCardsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, teamID, ok := teamRequestContext(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		CardIDs    []int  `json:"card_ids"`
+		FromUserID int    `json:"from_user_id"`
+		ToUserID   int    `json:"to_user_id"`
+		Balance    string `json:"balance"`
+		Reason     string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	transfers, err := usecase.ReassignTeamCards(teamID, userID, req.CardIDs, req.FromUserID, req.ToUserID, req.Balance, req.Reason)
+	if err != nil {
+		if errors.Is(err, repository.ErrTeamAccessDenied) {
+			http.Error(w, "insufficient permissions: team.manage permission required to reassign cards", http.StatusForbidden)
+			return
+		}
+		log.Printf("[CARD-TRANSFER] User %d failed to reassign cards of team %d: %v", userID, teamID, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"team_id":   teamID,
+		"transfers": transfers,
+	})
+}
+
+// GetTeamCardTransfersHandler - GET /api/v1/user/teams/{id}/cards/transfers?limit=100
+// Журнал передач карт команды (право team.manage).
+func GetTeamCardTransfersHandler(w http.ResponseWriter, r *http.Request) {
+	_, teamID, ok := teamRequestContext(w, r)
+	if !ok {
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	transfers, err := repository.ListCardOwnershipTransfers(teamID, limit)
+	if err != nil {
+		log.Printf("[CARD-TRANSFER] Failed to list transfers of team %d: %v", teamID, err)
+		http.Error(w, "Failed to fetch card transfers", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(transfers)
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/repository"
+	"github.com/gorilla/mux"
+)
+
+// writeTeamRoleError отвечает на ошибку операции с ролями команды.
+func writeTeamRoleError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Role not found", http.StatusNotFound)
+	case errors.Is(err, repository.ErrInvalidTeamPermission), errors.Is(err, repository.ErrInvalidTeamRole):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("[TEAM-RBAC] Request failed: %v", err)
+		http.Error(w, "Failed to process team role", http.StatusInternalServerError)
+	}
+}
+
+// GetTeamRolesHandler - GET /api/v1/user/teams/{id}/roles
+// Шаблоны ролей, кастомные роли команды и список всех прав.
+func GetTeamRolesHandler(w http.ResponseWriter, r *http.Request) {
+	_, teamID, ok := teamRequestContext(w, r)
+	if !ok {
+		return
+	}
+	roles, err := repository.ListTeamRoles(teamID)
+	if err != nil {
+		writeTeamRoleError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"permissions": domain.TeamPermissions,
+		"templates":   domain.TeamRoleTemplates,
+		"roles":       roles,
+	})
+}
+
+// CreateTeamRoleHandler - POST /api/v1/user/teams/{id}/roles
+// Тело: {"name": "Senior buyer", "permissions": ["cards.issue", "cards.freeze", "wallet.fund"]} (право roles.manage).
+func CreateTeamRoleHandler(w http.ResponseWriter, r *http.Request) {
+	userID, teamID, ok := teamRequestContext(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Name        string   `json:"name"`
+		Permissions []string `json:"permissions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	created, err := repository.CreateTeamRole(teamID, userID, req.Name, req.Permissions)
+	if err != nil {
+		writeTeamRoleError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// UpdateTeamRoleHandler - PATCH /api/v1/user/teams/{id}/roles/{roleId}
+// Тело: {"permissions": [...]} — заменить права кастомной роли (право roles.manage).
+func UpdateTeamRoleHandler(w http.ResponseWriter, r *http.Request) {
+	_, teamID, ok := teamRequestContext(w, r)
+	if !ok {
+		return
+	}
+	roleID, err := strconv.Atoi(mux.Vars(r)["roleId"])
+	if err != nil || roleID <= 0 {
+		http.Error(w, "Invalid role ID", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Permissions []string `json:"permissions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	updated, err := repository.UpdateTeamRolePermissions(teamID, roleID, req.Permissions)
+	if err != nil {
+		writeTeamRoleError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// DeleteTeamRoleHandler - DELETE /api/v1/user/teams/{id}/roles/{roleId}
+// Участники удалённой роли возвращаются к своему шаблону (право roles.manage).
+func DeleteTeamRoleHandler(w http.ResponseWriter, r *http.Request) {
+	_, teamID, ok := teamRequestContext(w, r)
+	if !ok {
+		return
+	}
+	roleID, err := strconv.Atoi(mux.Vars(r)["roleId"])
+	if err != nil || roleID <= 0 {
+		http.Error(w, "Invalid role ID", http.StatusBadRequest)
+		return
+	}
+	if err := repository.DeleteTeamRole(teamID, roleID); err != nil {
+		writeTeamRoleError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,275 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/middleware"
+	"github.com/djalben/xplr-core/backend/repository"
+	"github.com/djalben/xplr-core/backend/service"
+	"github.com/gorilla/mux"
+	"github.com/shopspring/decimal"
+)
+
+// teamRequestContext разбирает userID и {id} команды и проверяет членство.
+// Возвращает ok=false, если ответ с ошибкой уже отправлен.
+func teamRequestContext(w http.ResponseWriter, r *http.Request) (userID, teamID int, ok bool) {
+	userID, authOK := r.Context().Value(middleware.UserIDKey).(int)
+	if !authOK || userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return 0, 0, false
+	}
+	teamID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || teamID <= 0 {
+		http.Error(w, "Invalid team ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	hasAccess, _, err := repository.CheckTeamAccess(teamID, userID)
+	if err != nil {
+		log.Printf("Error checking team access: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return 0, 0, false
+	}
+	if !hasAccess {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return 0, 0, false
+	}
+	return userID, teamID, true
+}
+
+// writeTeamWalletError отвечает на ошибку операции с Кошельком команды.
+func writeTeamWalletError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repository.ErrTeamAccessDenied), errors.Is(err, repository.ErrTeamAllowanceExceeded):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, repository.ErrTeamWalletInsufficientFunds):
+		http.Error(w, err.Error(), http.StatusPaymentRequired)
+	case errors.Is(err, repository.ErrUnsupportedCurrency):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case strings.HasPrefix(err.Error(), "эмитент отклонил"):
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	case err.Error() == "кошелёк не найден — пополните баланс":
+		http.Error(w, err.Error(), http.StatusPaymentRequired)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// GetTeamWalletHandler - GET /api/v1/user/teams/{id}/wallet
+// Балансы Кошелька команды и лимиты: участники с правом team.manage видят лимиты всех, остальные — только свои.
+func GetTeamWalletHandler(w http.ResponseWriter, r *http.Request) {
+	userID, teamID, ok := teamRequestContext(w, r)
+	if !ok {
+		return
+	}
+	balances, err := repository.GetTeamWalletBalances(teamID)
+	if err != nil {
+		log.Printf("[TEAM-WALLET] Failed to get team %d balances: %v", teamID, err)
+		http.Error(w, "Failed to fetch team wallet", http.StatusInternalServerError)
+		return
+	}
+	canManage, err := repository.HasTeamPermission(teamID, userID, domain.PermTeamManage)
+	if err != nil {
+		log.Printf("[TEAM-WALLET] Failed to check team %d permissions: %v", teamID, err)
+		http.Error(w, "Failed to fetch team wallet", http.StatusInternalServerError)
+		return
+	}
+	allowanceOf := userID
+	if canManage {
+		allowanceOf = 0
+	}
+	allowances, err := repository.GetTeamMemberAllowances(teamID, allowanceOf)
+	if err != nil {
+		log.Printf("[TEAM-WALLET] Failed to get team %d allowances: %v", teamID, err)
+		http.Error(w, "Failed to fetch team wallet", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"team_id":    teamID,
+		"wallets":    balances,
+		"allowances": allowances,
+	})
+}
+
+// TopUpTeamWalletHandler - POST /api/v1/user/teams/{id}/wallet/topup
+// Тело: {"amount": 500, "currency": "USD"} — перевод из своего Кошелька в Кошелёк команды (право wallet.fund).
+func TopUpTeamWalletHandler(w http.ResponseWriter, r *http.Request) {
+	userID, teamID, ok := teamRequestContext(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Amount   decimal.Decimal `json:"amount"`
+		Currency string          `json:"currency"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Currency == "" {
+		req.Currency = repository.WalletCurrency
+	}
+	if err := repository.TopUpTeamWallet(teamID, userID, req.Amount, req.Currency); err != nil {
+		writeTeamWalletError(w, err)
+		return
+	}
+	balances, err := repository.GetTeamWalletBalances(teamID)
+	if err != nil {
+		http.Error(w, "Failed to fetch team wallet", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"team_id": teamID,
+		"wallets": balances,
+	})
+}
+
+// FundTeamCardHandler - POST /api/v1/user/teams/{id}/wallet/fund-card
+// Тело: {"card_id": 12, "amount": 100} — пополнение карты команды из Кошелька команды в валюте карты.
+// Учитываются лимиты участника: месячный бюджет и лимит на карту.
+func FundTeamCardHandler(w http.ResponseWriter, r *http.Request) {
+	userID, teamID, ok := teamRequestContext(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		CardID int             `json:"card_id"`
+		Amount decimal.Decimal `json:"amount"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.CardID <= 0 {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	balance, err := repository.FundTeamCard(teamID, userID, req.CardID, req.Amount, service.CardFundFunc(req.CardID))
+	if err != nil {
+		log.Printf("[TEAM-WALLET] User %d failed to fund card %d of team %d: %v", userID, req.CardID, teamID, err)
+		writeTeamWalletError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"team_id":             teamID,
+		"card_id":             req.CardID,
+		"amount":              req.Amount.Round(2),
+		"team_wallet_balance": balance,
+	})
+}
+
+// SetTeamMemberAllowanceHandler - PUT /api/v1/user/teams/{id}/members/{userId}/allowance
+// Тело: {"currency": "USD", "monthly_limit": 2000, "per_card_limit": 500}; null — без лимита (право team.manage).
+func SetTeamMemberAllowanceHandler(w http.ResponseWriter, r *http.Request) {
+	userID, teamID, ok := teamRequestContext(w, r)
+	if !ok {
+		return
+	}
+	memberID, err := strconv.Atoi(mux.Vars(r)["userId"])
+	if err != nil || memberID <= 0 {
+		http.Error(w, "Invalid member ID", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Currency     string           `json:"currency"`
+		MonthlyLimit *decimal.Decimal `json:"monthly_limit"`
+		PerCardLimit *decimal.Decimal `json:"per_card_limit"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Currency == "" {
+		req.Currency = repository.WalletCurrency
+	}
+	if req.MonthlyLimit == nil && req.PerCardLimit == nil {
+		err = repository.DeleteTeamMemberAllowance(teamID, memberID)
+	} else {
+		err = repository.SetTeamMemberAllowance(teamID, memberID, req.Currency, req.MonthlyLimit, req.PerCardLimit, userID)
+	}
+	if err != nil {
+		writeTeamWalletError(w, err)
+		return
+	}
+	allowances, err := repository.GetTeamMemberAllowances(teamID, memberID)
+	if err != nil {
+		http.Error(w, "Failed to fetch allowance", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(allowances)
+}
+
+// GetTeamSpendBreakdownHandler - GET /api/v1/user/teams/{id}/wallet/spend?from=2026-10-01&to=2026-10-31&format=csv
+// Траты из Кошелька команды по участникам (право reports.export); по умолчанию — текущий месяц.
+func GetTeamSpendBreakdownHandler(w http.ResponseWriter, r *http.Request) {
+	_, teamID, ok := teamRequestContext(w, r)
+	if !ok {
+		return
+	}
+	now := time.Now().UTC()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, -1)
+	var err error
+	if s := r.URL.Query().Get("from"); s != "" {
+		if from, err = time.Parse("2006-01-02", s); err != nil {
+			http.Error(w, "invalid 'from' date, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+	if s := r.URL.Query().Get("to"); s != "" {
+		if to, err = time.Parse("2006-01-02", s); err != nil {
+			http.Error(w, "invalid 'to' date, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+	spend, err := repository.GetTeamSpendBreakdown(teamID, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		log.Printf("[TEAM-WALLET] Spend breakdown of team %d failed: %v", teamID, err)
+		http.Error(w, "Failed to build spend breakdown", http.StatusInternalServerError)
+		return
+	}
+	if r.URL.Query().Get("format") == "csv" {
+		writeTeamSpendCSV(w, teamID, from, to, spend)
+		return
+	}
+	operations, err := repository.ListTeamWalletOperations(teamID, 100)
+	if err != nil {
+		log.Printf("[TEAM-WALLET] Operations of team %d failed: %v", teamID, err)
+		http.Error(w, "Failed to build spend breakdown", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"team_id":    teamID,
+		"from":       from.Format("2006-01-02"),
+		"to":         to.Format("2006-01-02"),
+		"members":    spend,
+		"operations": operations,
+	})
+}
+
+// writeTeamSpendCSV отдаёт траты по участникам файлом CSV.
+func writeTeamSpendCSV(w http.ResponseWriter, teamID int, from, to time.Time, spend []domain.TeamMemberSpend) {
+	filename := fmt.Sprintf("team_%d_spend_%s_%s.csv", teamID, from.Format("20060102"), to.Format("20060102"))
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"user_id", "email", "currency", "card_funding", "issue_fees", "total", "top_ups", "cards_funded"})
+	for _, m := range spend {
+		cw.Write([]string{
+			strconv.Itoa(m.UserID), m.Email, m.Currency,
+			m.CardFunding.StringFixed(2), m.IssueFees.StringFixed(2), m.Total.StringFixed(2), m.TopUps.StringFixed(2),
+			strconv.Itoa(m.CardsFunded),
+		})
+	}
+	cw.Flush()
+}
@@ -26,6 +26,8 @@ func TestAuthorizeCardHandler(w http.ResponseWriter, r *http.Request) {
         CardID: req.CardID,
         Amount: req.Amount,
         MerchantName: req.MerchantName, 
+        MCC: req.MCC,
+        Country: req.Country,
     }
 
 	// 2. Вызов функции Core с правильной структурой-аргументом
@@ -3,12 +3,12 @@ package handler
 import (
 	"database/sql"
 	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/djalben/xplr-core/backend/domain"
 	"github.com/djalben/xplr-core/backend/middleware"
 	"github.com/djalben/xplr-core/backend/repository"
 	"github.com/djalben/xplr-core/backend/service"
@@ -82,8 +82,10 @@ func UpgradeTierHandler(w http.ResponseWriter, r *http.Request) {
 	_, err = GlobalDB.Exec(`UPDATE users SET tier = 'gold', tier_expires_at = $1 WHERE id = $2`, expiresAt, userID)
 	if err != nil {
 		log.Printf("[TIER-UPGRADE] Failed to update tier: %v", err)
-		// Refund wallet
-		_, _ = repository.TopUpInternalBalance(userID, goldPrice)
+		// Refund wallet (USD fee back, not a RUB top-up)
+		if rErr := repository.RefundWalletFee(userID, goldPrice, "Refund: "+details); rErr != nil {
+			log.Printf("[TIER-UPGRADE] ❌ Refund failed for user %d: %v", userID, rErr)
+		}
 		http.Error(w, "Failed to upgrade tier", http.StatusInternalServerError)
 		return
 	}
@@ -105,13 +107,15 @@ func UpgradeTierHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Notify user
-	go service.NotifyUser(userID, "Gold "+actionLabel,
-		fmt.Sprintf("🏆 <b>Gold %s!</b>\n\n"+
-			"Срок: до <b>%s</b>\n"+
-			"Лимит карт: <b>15</b>\n"+
-			"Стоимость: <b>$%s</b>\n\n"+
-			"<a href=\"https://xplr.pro/dashboard\">Открыть дашборд</a>",
-			actionLabel, expiresAt.Format("02.01.2006"), goldPrice.StringFixed(2)))
+	notifyData := map[string]string{
+		"expires_at": expiresAt.Format("02.01.2006"),
+		"card_limit": "15",
+		"price":      goldPrice.StringFixed(2),
+	}
+	if isExtension {
+		notifyData["extended"] = "1"
+	}
+	go service.NotifyUserTemplate(userID, domain.NotifyTierUpgraded, notifyData)
 
 	log.Printf("[TIER-UPGRADE] ✅ User %d Gold %s (expires: %s, paid: $%s)", userID, actionLabel, expiresAt.Format("2006-01-02"), goldPrice.StringFixed(2))
 
@@ -163,9 +167,9 @@ func GetTierInfoHandler(w http.ResponseWriter, r *http.Request) {
 		tierExpiresAt = sql.NullTime{Time: fixedExpiry, Valid: true}
 	}
 
-	// Count user's cards
+	// Count user's personal cards (team cards are not limited by tier)
 	var cardCount int
-	GlobalDB.QueryRow(`SELECT COUNT(*) FROM cards WHERE user_id = $1`, userID).Scan(&cardCount)
+	GlobalDB.QueryRow(`SELECT COUNT(*) FROM cards WHERE user_id = $1 AND team_id IS NULL`, userID).Scan(&cardCount)
 
 	// Determine limits
 	cardLimit := 3
@@ -71,44 +71,6 @@ func ToggleAutoPayHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// CheckRecurringAllowed checks if a recurring/subscription transaction should be declined.
-// Returns true if allowed, false if should be declined.
-func CheckRecurringAllowed(cardID int, merchantName string, isRecurring bool) bool {
-	if !isRecurring {
-		return true // Not a subscription — always allow
-	}
-
-	// 1. Check global auto-pay toggle on card
-	var autoPayEnabled bool
-	err := GlobalDB.QueryRow(
-		`SELECT COALESCE(is_auto_pay_enabled, TRUE) FROM cards WHERE id = $1`, cardID,
-	).Scan(&autoPayEnabled)
-	if err != nil {
-		log.Printf("[RECURRING-CHECK] ❌ DB error for card %d: %v", cardID, err)
-		return true // On error, allow (fail-open)
-	}
-
-	if !autoPayEnabled {
-		log.Printf("[RECURRING-CHECK] 🚫 Card %d has auto-pay DISABLED — declining recurring tx from %q", cardID, merchantName)
-		return false
-	}
-
-	// 2. Check per-merchant block
-	if merchantName != "" {
-		var isBlocked bool
-		err := GlobalDB.QueryRow(
-			`SELECT COALESCE(is_blocked, FALSE) FROM merchant_blocks WHERE card_id = $1 AND LOWER(merchant_name) = LOWER($2)`,
-			cardID, merchantName,
-		).Scan(&isBlocked)
-		if err == nil && isBlocked {
-			log.Printf("[RECURRING-CHECK] 🚫 Merchant %q blocked on card %d", merchantName, cardID)
-			return false
-		}
-	}
-
-	return true
-}
-
 // ══════════════════════════════════════════════════════════════
 // 2. Subscription Dashboard
 // ══════════════════════════════════════════════════════════════
@@ -138,7 +138,7 @@ func LoginVerify2FAHandler(w http.ResponseWriter, r *http.Request) {
 		"user": map[string]interface{}{
 			"id":                 user.ID,
 			"email":              user.Email,
-			"balance":            user.BalanceRub.String(),
+			"balance":            walletBalanceString(user.ID),
 			"status":             user.Status,
 			"is_verified":        user.IsVerified,
 			"is_admin":           isAdmin,
@@ -35,7 +35,7 @@ func GetUserProfileHandler(w http.ResponseWriter, r *http.Request) {
 	}{
 		UserID:  user.ID,
 		Email:   user.Email,
-		Balance: user.BalanceRub.String(),
+		Balance: walletBalanceString(user.ID),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -54,12 +54,8 @@ func TopUpBalanceHandler(w http.ResponseWriter, r *http.Request) {
 
 	var req struct {
 		AmountRub *decimal.Decimal `json:"amount_rub"`
-		Wallet    string           `json:"wallet"` // "arbitrage" or "personal"
 	}
 	json.NewDecoder(r.Body).Decode(&req) // best-effort; if empty body, defaults apply
-	if req.Wallet == "" {
-		req.Wallet = "arbitrage"
-	}
 
 	// Get current RUB/USD exchange rate
 	rate, err := repository.GetFinalRate("RUB", "USD")
@@ -72,17 +68,19 @@ func TopUpBalanceHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		log.Printf("[EVENT] User %d performed topup (flat $%s). Triggering notifications...", userID, amount.StringFixed(2))
-		go service.NotifyUser(userID, "Пополнение баланса",
+		go service.NotifyUserOperation(userID, domain.NotificationEventTopUps, amount, "Пополнение баланса",
 			fmt.Sprintf("💰 <b>Баланс пополнен</b>\n\n"+
 				"Сумма: <b>$%s</b>\n\n"+
 				"<a href=\"https://xplr.pro/wallet\">Открыть кошелёк</a>",
 				amount.StringFixed(2)))
-		user, _ := repository.GetUserByID(userID)
+		go service.EmitWebhookEvent(userID, domain.WebhookWalletCredited, map[string]interface{}{
+			"amount": amount.StringFixed(2), "currency": "USD", "source": "topup",
+		})
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"message":     "Balance topped up successfully (flat rate)",
 			"amount_usd":  amount.String(),
-			"new_balance": user.BalanceRub.String(),
+			"new_balance": walletBalanceString(userID),
 		})
 		return
 	}
@@ -116,41 +114,23 @@ func TopUpBalanceHandler(w http.ResponseWriter, r *http.Request) {
 	// Check referral bonus eligibility (condition 2: wallet top-up)
 	go repository.CheckAndCreditReferralBonus(userID)
 
-	go service.NotifyUser(userID, "Пополнение баланса",
+	go service.NotifyUserOperation(userID, domain.NotificationEventTopUps, amountUsd, "Пополнение баланса",
 		fmt.Sprintf("💰 <b>Баланс пополнен</b>\n\n"+
 			"Сумма: <b>%s ₽</b> → <b>$%s</b>\n\n"+
 			"<a href=\"https://xplr.pro/wallet\">Открыть кошелёк</a>",
 			amountRub.StringFixed(2), amountUsd.StringFixed(2)))
-
-	// Credit the specific wallet
-	walletCol := "balance_arbitrage"
-	if req.Wallet == "personal" {
-		walletCol = "balance_personal"
-	}
-	if repository.GlobalDB != nil {
-		_, _ = repository.GlobalDB.Exec(
-			"UPDATE users SET "+walletCol+" = COALESCE("+walletCol+", 0) + $1 WHERE id = $2",
-			amountUsd, userID,
-		)
-	}
-
-	user, err := repository.GetUserByID(userID)
-	if err != nil {
-		log.Printf("Error fetching user %d after topup: %v", userID, err)
-		http.Error(w, "Top up succeeded but failed to fetch updated balance", http.StatusInternalServerError)
-		return
-	}
+	go service.EmitWebhookEvent(userID, domain.WebhookWalletCredited, map[string]interface{}{
+		"amount": amountUsd.StringFixed(2), "currency": "USD", "source": "topup",
+		"original_amount": amountRub.StringFixed(2), "original_currency": "RUB",
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"message":           "Balance topped up successfully",
-		"wallet":            req.Wallet,
-		"amount_usd":        amountUsd.StringFixed(2),
-		"amount_rub":        amountRub.StringFixed(2),
-		"rate":              rate.StringFixed(4),
-		"new_balance":       user.BalanceRub.String(),
-		"balance_arbitrage": user.BalanceArbitrage.String(),
-		"balance_personal":  user.BalancePersonal.String(),
+		"message":     "Balance topped up successfully",
+		"amount_usd":  amountUsd.StringFixed(2),
+		"amount_rub":  amountRub.StringFixed(2),
+		"rate":        rate.StringFixed(4),
+		"new_balance": walletBalanceString(userID),
 	})
 }
 
@@ -240,7 +220,7 @@ func GetMeHandler(w http.ResponseWriter, r *http.Request) {
 		ID:               user.ID,
 		Email:            user.Email,
 		DisplayName:      user.DisplayName,
-		Balance:          user.BalanceRub.String(),
+		Balance:          walletBalanceString(user.ID),
 		Status:           user.Status,
 		APIKey:           apiKey,
 		Grade:            gradeInfo.Grade,
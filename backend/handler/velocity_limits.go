@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/repository"
+	"github.com/shopspring/decimal"
+)
+
+// velocityLimitsRequest — тело PATCH скользящих лимитов. Изменяются только переданные поля, 0 — без лимита.
+type velocityLimitsRequest struct {
+	Daily        *decimal.Decimal `json:"daily"`
+	Weekly       *decimal.Decimal `json:"weekly"`
+	Monthly      *decimal.Decimal `json:"monthly"`
+	MaxTxPerHour *int             `json:"max_tx_per_hour"`
+}
+
+// writeVelocityLimits отдаёт лимиты карты вместе с текущим расходом в тех же окнах.
+func writeVelocityLimits(w http.ResponseWriter, cardID int, limits domain.VelocityLimits) {
+	usage, err := repository.GetCardVelocityUsage(cardID)
+	if err != nil {
+		log.Printf("[VELOCITY] Failed to load usage for card %d: %v", cardID, err)
+		http.Error(w, "Failed to load card usage", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"card_id": cardID,
+		"limits":  limits,
+		"usage":   usage,
+	})
+}
+
+// GetCardVelocityLimitsHandler - GET /api/v1/user/cards/{id}/velocity-limits
+func GetCardVelocityLimitsHandler(w http.ResponseWriter, r *http.Request) {
+	card, _, ok := loadRuleCard(w, r)
+	if !ok {
+		return
+	}
+	limits, err := repository.GetCardVelocityLimits(card.ID)
+	if err != nil {
+		log.Printf("[VELOCITY] Failed to load limits for card %d: %v", card.ID, err)
+		http.Error(w, "Failed to load limits", http.StatusInternalServerError)
+		return
+	}
+	writeVelocityLimits(w, card.ID, limits)
+}
+
+// SetCardVelocityLimitsHandler - PATCH /api/v1/user/cards/{id}/velocity-limits
+func SetCardVelocityLimitsHandler(w http.ResponseWriter, r *http.Request) {
+	card, userID, ok := loadRuleCard(w, r)
+	if !ok {
+		return
+	}
+	var req velocityLimitsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	limits, err := repository.GetCardVelocityLimits(card.ID)
+	if err != nil {
+		http.Error(w, "Failed to load limits", http.StatusInternalServerError)
+		return
+	}
+	for _, f := range []struct {
+		in  *decimal.Decimal
+		out *decimal.Decimal
+	}{{req.Daily, &limits.Daily}, {req.Weekly, &limits.Weekly}, {req.Monthly, &limits.Monthly}} {
+		if f.in == nil {
+			continue
+		}
+		if f.in.IsNegative() {
+			http.Error(w, "Limits cannot be negative", http.StatusBadRequest)
+			return
+		}
+		*f.out = *f.in
+	}
+	if req.MaxTxPerHour != nil {
+		if *req.MaxTxPerHour < 0 {
+			http.Error(w, "Limits cannot be negative", http.StatusBadRequest)
+			return
+		}
+		limits.MaxTxPerHour = *req.MaxTxPerHour
+	}
+
+	if err := repository.SetCardVelocityLimits(card.ID, limits); err != nil {
+		log.Printf("[VELOCITY] Failed to update limits for card %d: %v", card.ID, err)
+		http.Error(w, "Failed to update limits", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[VELOCITY] Card %d limits set by user %d: day=%s week=%s month=%s tx/h=%d",
+		card.ID, userID, limits.Daily, limits.Weekly, limits.Monthly, limits.MaxTxPerHour)
+	writeVelocityLimits(w, card.ID, limits)
+}
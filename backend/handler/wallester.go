@@ -2,18 +2,23 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/djalben/xplr-core/backend/domain"
 	"github.com/djalben/xplr-core/backend/middleware"
 	"github.com/djalben/xplr-core/backend/notification"
 	"github.com/djalben/xplr-core/backend/repository"
 	"github.com/djalben/xplr-core/backend/service"
+	"github.com/djalben/xplr-core/backend/usecase"
 	"github.com/gorilla/mux"
+	"github.com/shopspring/decimal"
 )
 
 var wallesterRepo *repository.WallesterRepository
@@ -103,58 +108,52 @@ func WallesterWebhookHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("[WEBHOOK] Received: event_type=%s card=%s merchant=%s amount=%s",
 		payload.EventType, payload.CardID, payload.MerchantName, payload.Amount)
 
-	// ── Anti-Drain: Check recurring/subscription authorization ──
+	// ── Authorization engine: anti-drain (recurring), spending rules, velocity, card limit, wallet ──
+	// Те же проверки, что и в AuthorizeCard (usecase.EvaluateAuthorization)
 	if payload.EventType == "authorization" || payload.EventType == "transaction" || payload.EventType == "capture" {
-		isRecurring := false
-		if meta, ok := payload.Metadata["recurring"]; ok {
-			if b, ok := meta.(bool); ok && b {
-				isRecurring = true
-			}
-		}
-		if meta, ok := payload.Metadata["is_recurring"]; ok {
-			if b, ok := meta.(bool); ok && b {
-				isRecurring = true
-			}
-		}
-		if meta, ok := payload.Metadata["transaction_type"]; ok {
-			if s, ok := meta.(string); ok && (s == "recurring" || s == "subscription") {
-				isRecurring = true
-			}
+		if decline := authorizeWebhookCharge(payload); decline != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{
+				"status":  "declined",
+				"reason":  decline.DeclineCode,
+				"message": decline.DeclineReason,
+			})
+			return
 		}
+	}
 
-		if isRecurring {
-			// Look up card ID
-			var cardID int
-			_ = repository.GlobalDB.QueryRow(
-				`SELECT id FROM cards WHERE external_id = $1 OR provider_card_id = $1 LIMIT 1`,
-				payload.CardID,
-			).Scan(&cardID)
-
-			if cardID > 0 && !CheckRecurringAllowed(cardID, payload.MerchantName, true) {
-				log.Printf("[WEBHOOK] 🚫 RECURRING DECLINED: card=%s merchant=%q", payload.CardID, payload.MerchantName)
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusForbidden)
-				json.NewEncoder(w).Encode(map[string]string{
-					"status":  "declined",
-					"reason":  "recurring_blocked",
-					"message": "Автосписание заблокировано пользователем",
-				})
-				return
-			}
-		}
+	// Reversal по незавершённой авторизации снимает холд, а не возвращает деньги — уведомление другое
+	holdReleased := false
+	if payload.EventType == "reversal" {
+		hold, _ := repository.GetActiveCardHoldByRef(payload.AuthorizationRef())
+		holdReleased = hold != nil
 	}
 
-	// ── Process webhook through repository (wallet deduction, transaction recording) ──
+	// ── Process webhook through repository (hold, wallet deduction, transaction recording) ──
 	wallesterRepo := repository.NewWallesterRepository()
 	if err := wallesterRepo.ProcessWebhook(payload); err != nil {
+		// Скользящий лимит, превышенный параллельной авторизацией, — отказ, а не сбой
+		var velocity *repository.VelocityDecline
+		if errors.As(err, &velocity) {
+			log.Printf("[WEBHOOK] 🚫 DECLINED under card lock: card=%s %s: %s", payload.CardID, velocity.Code, velocity.Reason)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{
+				"status":  "declined",
+				"reason":  velocity.Code,
+				"message": velocity.Reason,
+			})
+			return
+		}
 		log.Printf("[WEBHOOK] ❌ ProcessWebhook error: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	// ── Track merchant subscription on successful charge ──
-	if payload.EventType == "transaction" || payload.EventType == "capture" ||
-		payload.EventType == "authorization" || payload.EventType == "payment_success" {
+	// (авторизация только резервирует средства — подписку учитываем по списанию)
+	if payload.EventType == "transaction" || payload.EventType == "capture" || payload.EventType == "payment_success" {
 		if payload.MerchantName != "" {
 			var cardID, userID int
 			_ = repository.GlobalDB.QueryRow(
@@ -168,7 +167,7 @@ func WallesterWebhookHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// ── Send notifications async ──
-	go sendWallesterNotification(payload)
+	go sendWallesterNotification(payload, holdReleased)
 
 	// Успешный ответ
 	w.Header().Set("Content-Type", "application/json")
@@ -179,9 +178,81 @@ func WallesterWebhookHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// sendWallesterNotification отправляет уведомления (TG + Email) для событий Wallester
-// Вызывается из хендлера в горутине после успешного ProcessWebhook
-func sendWallesterNotification(payload repository.WallesterWebhookPayload) {
+// isRecurringPayload определяет автосписание (подписку) по metadata вебхука.
+func isRecurringPayload(payload repository.WallesterWebhookPayload) bool {
+	if b, ok := payload.Metadata["recurring"].(bool); ok && b {
+		return true
+	}
+	if b, ok := payload.Metadata["is_recurring"].(bool); ok && b {
+		return true
+	}
+	if s, ok := payload.Metadata["transaction_type"].(string); ok && (s == "recurring" || s == "subscription") {
+		return true
+	}
+	return false
+}
+
+// authorizeWebhookCharge прогоняет списание Wallester через общий движок авторизации.
+// Возвращает ответ с причиной отказа или nil, если списание можно проводить.
+func authorizeWebhookCharge(payload repository.WallesterWebhookPayload) *domain.AuthResponse {
+	// Повтор уже проведённого вебхука не должен отклоняться из-за собственного списания
+	if done, _ := repository.CheckTransactionIdempotency(payload.TransactionID); done {
+		return nil
+	}
+	// Списание по уже одобренной авторизации (или её повтор): средства зарезервированы холдом
+	if hold, _ := repository.GetActiveCardHoldByRef(payload.AuthorizationRef()); hold != nil {
+		return nil
+	}
+
+	var cardID int
+	_ = repository.GlobalDB.QueryRow(
+		`SELECT id FROM cards WHERE external_id = $1 OR provider_card_id = $1 LIMIT 1`,
+		payload.CardID,
+	).Scan(&cardID)
+	if cardID == 0 {
+		return nil // карту не нашли — ProcessWebhook вернёт ошибку
+	}
+
+	amount, _ := decimal.NewFromString(payload.Amount)
+	decision, _ := usecase.EvaluateAuthorization(usecase.AuthorizationRequest{
+		CardID:       cardID,
+		Amount:       amount,
+		MerchantName: payload.MerchantName,
+		MCC:          payload.MCC,
+		Country:      payload.Country,
+		IsRecurring:  isRecurringPayload(payload),
+		Source:       "wallester",
+		At:           time.Now(),
+	})
+	if decision.Approved {
+		return nil
+	}
+	log.Printf("[WEBHOOK] 🚫 DECLINED: card=%s merchant=%q %s: %s",
+		payload.CardID, payload.MerchantName, decision.Code, decision.Reason)
+	resp := usecase.AuthResponseFor(decision, decimal.Zero)
+	return &resp
+}
+
+// wallesterWebhookData — данные исходящего вебхука пользователя о транзакции Wallester.
+func wallesterWebhookData(payload repository.WallesterWebhookPayload, cardID int, last4 string, stage string) map[string]interface{} {
+	return map[string]interface{}{
+		"card_id":        cardID,
+		"last4":          last4,
+		"stage":          stage,
+		"transaction_id": payload.TransactionID,
+		"amount":         payload.Amount,
+		"currency":       payload.Currency,
+		"merchant":       payload.MerchantName,
+		"mcc":            payload.MCC,
+		"country":        payload.Country,
+	}
+}
+
+// sendWallesterNotification отправляет код 3DS в Telegram и исходящие вебхуки пользователя
+// для событий Wallester. Уведомления об операциях ставит в очередь ProcessWebhook в транзакции события.
+// Вызывается из хендлера в горутине после успешного ProcessWebhook.
+// holdReleased — reversal снял холд по авторизации, а не вернул списанные средства.
+func sendWallesterNotification(payload repository.WallesterWebhookPayload, holdReleased bool) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("⚠️  Panic in sendWallesterNotification: %v", r)
@@ -201,20 +272,11 @@ func sendWallesterNotification(payload repository.WallesterWebhookPayload) {
 	log.Printf("[EVENT] Wallester webhook %s for card %d (user %d, last4=%s). Triggering notifications...",
 		payload.EventType, cardID, userID, last4Digits)
 
-	amount := payload.Amount
-	if amount == "" {
-		amount = "0"
-	}
-	currency := payload.Currency
-	if currency == "" {
-		currency = "USD"
-	}
 	merchantName := payload.MerchantName
 	if merchantName == "" {
 		merchantName = "Unknown"
 	}
 
-	// Формируем и отправляем уведомление в зависимости от типа события
 	switch payload.EventType {
 	case "3ds_authentication":
 		if payload.AuthCode != "" {
@@ -228,23 +290,19 @@ func sendWallesterNotification(payload repository.WallesterWebhookPayload) {
 			log.Printf("✅ 3DS notification sent to user %d", userID)
 		}
 
-	case "payment_success", "transaction", "capture", "authorization":
-		service.NotifyUser(userID, "Списание с карты",
-			fmt.Sprintf("💸 <b>Списание с карты *%s</b>\n\n"+
-				"Сумма: <b>%s %s</b>\n"+
-				"Магазин: %s\n\n"+
-				"<a href=\"https://xplr.pro/cards\">Открыть карты</a>",
-				last4Digits, amount, currency, merchantName))
-		log.Printf("✅ Payment notification sent to user %d (card=%d)", userID, cardID)
+	case "authorization":
+		service.EmitWebhookEvent(userID, domain.WebhookTransactionApproved, wallesterWebhookData(payload, cardID, last4Digits, "authorization"))
+
+	case "payment_success", "transaction", "capture":
+		service.EmitWebhookEvent(userID, domain.WebhookTransactionApproved, wallesterWebhookData(payload, cardID, last4Digits, "capture"))
 
 	case "refund", "reversal":
-		service.NotifyUser(userID, "Возврат средств",
-			fmt.Sprintf("💰 <b>Возврат средств на кошелёк</b>\n\n"+
-				"Карта: *%s\n"+
-				"Сумма возврата: <b>%s %s</b>\n\n"+
-				"<a href=\"https://xplr.pro/wallet\">Открыть кошелёк</a>",
-				last4Digits, amount, currency))
-		log.Printf("✅ Refund notification sent to user %d (card=%d)", userID, cardID)
+		if holdReleased {
+			return
+		}
+		webhookData := wallesterWebhookData(payload, cardID, last4Digits, "refund")
+		webhookData["source"] = "card_refund"
+		service.EmitWebhookEvent(userID, domain.WebhookWalletCredited, webhookData)
 	}
 }
 
@@ -282,8 +340,13 @@ func GetCardDetailsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Получаем детали через провайдерский интерфейс (MockProvider или ArmeniaProvider)
-	provider := service.GetCardProvider()
+	// Получаем детали у эмитента, выпустившего карту (cards.provider)
+	provider, err := service.CardProviderForCard(cardID)
+	if err != nil {
+		log.Printf("[CARD-DETAILS] No provider for card %d: %v", cardID, err)
+		http.Error(w, "Failed to get card details: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 	details, err := provider.GetCardDetails(cardID)
 	if err != nil {
 		log.Printf("[CARD-DETAILS] Error getting card details from %s: %v", provider.GetProviderName(), err)
@@ -0,0 +1,294 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/middleware"
+	"github.com/djalben/xplr-core/backend/repository"
+	"github.com/djalben/xplr-core/backend/usecase"
+	"github.com/gorilla/mux"
+)
+
+// writeWebhookError отвечает на ошибку репозитория вебхуков; false — ошибки нет.
+func writeWebhookError(w http.ResponseWriter, err error, action string) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, repository.ErrWebhookNotFound), errors.Is(err, repository.ErrWebhookDeliveryNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, repository.ErrInvalidWebhook):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, repository.ErrWebhookLimitReached), errors.Is(err, repository.ErrWebhookDeliveryScheduled):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("[WEBHOOKS] Failed to %s: %v", action, err)
+		http.Error(w, "Failed to "+action, http.StatusInternalServerError)
+	}
+	return true
+}
+
+// webhookRouteIDs — ID endpoint (и доставки, если есть в пути) из URL.
+func webhookRouteIDs(r *http.Request) (endpointID, deliveryID int, ok bool) {
+	vars := mux.Vars(r)
+	endpointID, err := strconv.Atoi(vars["id"])
+	if err != nil || endpointID <= 0 {
+		return 0, 0, false
+	}
+	if raw, has := vars["deliveryId"]; has {
+		if deliveryID, err = strconv.Atoi(raw); err != nil || deliveryID <= 0 {
+			return 0, 0, false
+		}
+	}
+	return endpointID, deliveryID, true
+}
+
+// GetWebhooksHandler - GET /api/v1/user/webhooks
+// Endpoint пользователя и события, на которые можно подписаться.
+func GetWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok || userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	endpoints, err := repository.ListWebhookEndpoints(userID)
+	if writeWebhookError(w, err, "fetch webhooks") {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"endpoints":        endpoints,
+		"available_events": domain.WebhookEvents,
+	})
+}
+
+// CreateWebhookHandler - POST /api/v1/user/webhooks
+// Тело: {"url": "https://tracker.example.com/xplr", "events": ["transaction.approved"], "description": "Keitaro"}.
+// Секрет подписи возвращается только в этом ответе.
+func CreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok || userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		URL         string   `json:"url"`
+		Events      []string `json:"events"`
+		Description string   `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	endpoint := &domain.WebhookEndpoint{UserID: userID, URL: req.URL, Events: req.Events, Description: req.Description}
+	if writeWebhookError(w, repository.CreateWebhookEndpoint(endpoint), "create webhook") {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(endpoint)
+}
+
+// UpdateWebhookHandler - PATCH /api/v1/user/webhooks/{id}
+// Тело: любые из {"url", "events", "description", "is_active"}.
+func UpdateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok || userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, _, ok := webhookRouteIDs(r)
+	if !ok {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		URL         *string  `json:"url"`
+		Events      []string `json:"events"`
+		Description *string  `json:"description"`
+		IsActive    *bool    `json:"is_active"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	endpoint, err := repository.GetWebhookEndpoint(userID, id)
+	if writeWebhookError(w, err, "update webhook") {
+		return
+	}
+	if req.URL != nil {
+		endpoint.URL = *req.URL
+	}
+	if req.Events != nil {
+		endpoint.Events = req.Events
+	}
+	if req.Description != nil {
+		endpoint.Description = *req.Description
+	}
+	if req.IsActive != nil {
+		endpoint.IsActive = *req.IsActive
+	}
+	if writeWebhookError(w, repository.UpdateWebhookEndpoint(endpoint), "update webhook") {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(endpoint)
+}
+
+// DeleteWebhookHandler - DELETE /api/v1/user/webhooks/{id}
+func DeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok || userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, _, ok := webhookRouteIDs(r)
+	if !ok {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+	if writeWebhookError(w, repository.DeleteWebhookEndpoint(userID, id), "delete webhook") {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+}
+
+// RotateWebhookSecretHandler - POST /api/v1/user/webhooks/{id}/rotate-secret
+// Новый секрет подписи; старый перестаёт действовать сразу.
+func RotateWebhookSecretHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok || userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, _, ok := webhookRouteIDs(r)
+	if !ok {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+	secret, err := repository.RotateWebhookSecret(userID, id)
+	if writeWebhookError(w, err, "rotate webhook secret") {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "secret": secret})
+}
+
+// TestWebhookHandler - POST /api/v1/user/webhooks/{id}/test
+// Отправляет endpoint событие webhook.test сразу и возвращает результат попытки.
+func TestWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok || userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, _, ok := webhookRouteIDs(r)
+	if !ok {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+	delivery, err := repository.CreateWebhookTestDelivery(userID, id)
+	if writeWebhookError(w, err, "send test webhook") {
+		return
+	}
+	attempt := usecase.DeliverWebhook(*delivery)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"delivered":   attempt.Error == "",
+		"delivery_id": delivery.ID,
+		"payload":     delivery.Payload,
+		"attempt":     attempt,
+	})
+}
+
+// GetWebhookDeliveriesHandler - GET /api/v1/user/webhooks/{id}/deliveries?limit=100
+// Очередь и история доставок endpoint, новые сверху.
+func GetWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok || userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, _, ok := webhookRouteIDs(r)
+	if !ok {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+	if _, err := repository.GetWebhookEndpoint(userID, id); writeWebhookError(w, err, "fetch webhook deliveries") {
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	deliveries, err := repository.ListWebhookDeliveries(userID, id, limit)
+	if writeWebhookError(w, err, "fetch webhook deliveries") {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// GetWebhookDeliveryAttemptsHandler - GET /api/v1/user/webhooks/{id}/deliveries/{deliveryId}/attempts
+// Журнал попыток доставки: HTTP-код, ошибка и время ответа каждой.
+func GetWebhookDeliveryAttemptsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok || userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, deliveryID, ok := webhookRouteIDs(r)
+	if !ok {
+		http.Error(w, "Invalid webhook delivery ID", http.StatusBadRequest)
+		return
+	}
+	attempts, err := repository.ListWebhookDeliveryAttempts(userID, id, deliveryID)
+	if writeWebhookError(w, err, "fetch webhook attempts") {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(attempts)
+}
+
+// RedeliverWebhookHandler - POST /api/v1/user/webhooks/{id}/deliveries/{deliveryId}/redeliver
+// Ставит доставку в очередь заново (с тем же event_id — получатель может отбросить дубль).
+func RedeliverWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok || userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, deliveryID, ok := webhookRouteIDs(r)
+	if !ok {
+		http.Error(w, "Invalid webhook delivery ID", http.StatusBadRequest)
+		return
+	}
+	if writeWebhookError(w, repository.RedeliverWebhook(userID, id, deliveryID), "redeliver webhook") {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": deliveryID, "status": domain.WebhookDeliveryPending})
+}
+
+// WebhookDeliveriesCronHandler - GET /api/v1/cron/webhooks
+// Отправляет исходящие вебхуки из очереди (Vercel cron, защищён CRON_SECRET).
+func WebhookDeliveriesCronHandler(w http.ResponseWriter, r *http.Request) {
+	cronSecret := os.Getenv("CRON_SECRET")
+	if cronSecret != "" && r.Header.Get("Authorization") != "Bearer "+cronSecret {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	processed, err := usecase.ProcessWebhookDeliveries()
+	if err != nil {
+		log.Printf("[WEBHOOKS] Cron failed: %v", err)
+		http.Error(w, "Failed to dispatch webhooks", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"processed": processed})
+}
@@ -0,0 +1,369 @@
+// Package ledger — двойная запись (double-entry) для всех движений денег XPLR.
+//
+// Каждое движение денег — это проводка (Entry) из двух и более строк (Line).
+// Сумма дебетов равна сумме кредитов в каждой валюте, иначе проводка отклоняется.
+// Проводка пишется в ту же транзакцию БД, что и бизнес-операция, поэтому
+// журнал и балансы (internal_balances.master_balance, wallet_balances, cards.card_balance)
+// либо меняются вместе, либо не меняются вовсе.
+package ledger
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// AccountType — тип счёта в плане счетов.
+type AccountType string
+
+const (
+	// AccountUserWallet — Кошелёк пользователя: USD в internal_balances.master_balance,
+	// остальные валюты — в wallet_balances. Обязательство перед клиентом.
+	AccountUserWallet AccountType = "user_wallet"
+	// AccountCard — баланс карты (cards.card_balance). Обязательство перед клиентом.
+	AccountCard AccountType = "card"
+	// AccountTeamWallet — общий Кошелёк команды (team_wallet_balances). Обязательство перед клиентом.
+	AccountTeamWallet AccountType = "team_wallet"
+	// AccountFeeRevenue — доход платформы: комиссии за выпуск карт, Gold, наценка.
+	AccountFeeRevenue AccountType = "fee_revenue"
+	// AccountSupplierPayable — задолженность перед поставщиками (эмитент карт, магазин, eSIM).
+	AccountSupplierPayable AccountType = "supplier_payable"
+	// AccountReferralPayable — начисленные, но не выплаченные реферальные вознаграждения.
+	AccountReferralPayable AccountType = "referral_payable"
+	// AccountExternalSettlement — деньги у внешних платёжных провайдеров (СБП, зарубежные пополнения).
+	AccountExternalSettlement AccountType = "external_settlement"
+	// AccountFX — позиция конвертации валют (USD→EUR и т.д.).
+	AccountFX AccountType = "fx_position"
+	// AccountSuspense — изъятые/замороженные средства и системные корректировки.
+	AccountSuspense AccountType = "suspense"
+)
+
+// creditNormal — счета, баланс которых растёт по кредиту (обязательства и доходы).
+var creditNormal = map[AccountType]bool{
+	AccountUserWallet:      true,
+	AccountCard:            true,
+	AccountTeamWallet:      true,
+	AccountFeeRevenue:      true,
+	AccountSupplierPayable: true,
+	AccountReferralPayable: true,
+	AccountSuspense:        true,
+}
+
+// Account — конкретный счёт. Для Кошелька заполняется UserID, для карты — CardID, для Кошелька команды — TeamID.
+type Account struct {
+	Type   AccountType
+	UserID int
+	CardID int
+	TeamID int
+}
+
+// UserWallet — счёт Кошелька пользователя.
+func UserWallet(userID int) Account { return Account{Type: AccountUserWallet, UserID: userID} }
+
+// Card — счёт баланса карты.
+func Card(cardID int) Account { return Account{Type: AccountCard, CardID: cardID} }
+
+// TeamWallet — счёт общего Кошелька команды.
+func TeamWallet(teamID int) Account { return Account{Type: AccountTeamWallet, TeamID: teamID} }
+
+// Системные счета платформы.
+var (
+	FeeRevenue         = Account{Type: AccountFeeRevenue}
+	SupplierPayable    = Account{Type: AccountSupplierPayable}
+	ReferralPayable    = Account{Type: AccountReferralPayable}
+	ExternalSettlement = Account{Type: AccountExternalSettlement}
+	FX                 = Account{Type: AccountFX}
+	Suspense           = Account{Type: AccountSuspense}
+)
+
+// Code — уникальный код счёта в журнале: "user_wallet:42", "card:7", "team_wallet:3", "fee_revenue".
+func (a Account) Code() string {
+	switch a.Type {
+	case AccountUserWallet:
+		return fmt.Sprintf("%s:%d", a.Type, a.UserID)
+	case AccountCard:
+		return fmt.Sprintf("%s:%d", a.Type, a.CardID)
+	case AccountTeamWallet:
+		return fmt.Sprintf("%s:%d", a.Type, a.TeamID)
+	default:
+		return string(a.Type)
+	}
+}
+
+// Side — сторона проводки.
+type Side string
+
+const (
+	SideDebit  Side = "D"
+	SideCredit Side = "C"
+)
+
+// Line — одна строка проводки.
+type Line struct {
+	Account  Account
+	Side     Side
+	Amount   decimal.Decimal
+	Currency string
+}
+
+// Debit — строка по дебету счёта.
+func Debit(a Account, amount decimal.Decimal, currency string) Line {
+	return Line{Account: a, Side: SideDebit, Amount: amount, Currency: currency}
+}
+
+// Credit — строка по кредиту счёта.
+func Credit(a Account, amount decimal.Decimal, currency string) Line {
+	return Line{Account: a, Side: SideCredit, Amount: amount, Currency: currency}
+}
+
+// Move — перемещение суммы со счёта from на счёт to (уменьшение from, увеличение to
+// для счетов с кредитовым остатком).
+func Move(from, to Account, amount decimal.Decimal, currency string) []Line {
+	return []Line{Debit(from, amount, currency), Credit(to, amount, currency)}
+}
+
+// Entry — проводка журнала.
+type Entry struct {
+	Type          string // совпадает с transactions.transaction_type, если есть
+	Reference     string // внешний идентификатор (provider_tx_id и т.п.)
+	TransactionID int    // transactions.id, 0 — без связи
+	Description   string
+	Lines         []Line
+}
+
+// Validate проверяет, что проводка сбалансирована в каждой валюте.
+func (e Entry) Validate() error {
+	if strings.TrimSpace(e.Type) == "" {
+		return fmt.Errorf("ledger: entry type is required")
+	}
+	if len(e.Lines) < 2 {
+		return fmt.Errorf("ledger: entry %s needs at least 2 lines", e.Type)
+	}
+	net := make(map[string]decimal.Decimal)
+	for i, l := range e.Lines {
+		if l.Account.Type == "" {
+			return fmt.Errorf("ledger: line %d has no account", i)
+		}
+		if l.Account.Type == AccountUserWallet && l.Account.UserID <= 0 {
+			return fmt.Errorf("ledger: line %d: wallet account without user", i)
+		}
+		if l.Account.Type == AccountCard && l.Account.CardID <= 0 {
+			return fmt.Errorf("ledger: line %d: card account without card", i)
+		}
+		if l.Account.Type == AccountTeamWallet && l.Account.TeamID <= 0 {
+			return fmt.Errorf("ledger: line %d: team wallet account without team", i)
+		}
+		if !l.Amount.IsPositive() {
+			return fmt.Errorf("ledger: line %d amount must be positive, got %s", i, l.Amount.String())
+		}
+		if l.Currency == "" {
+			return fmt.Errorf("ledger: line %d has no currency", i)
+		}
+		switch l.Side {
+		case SideDebit:
+			net[l.Currency] = net[l.Currency].Add(l.Amount)
+		case SideCredit:
+			net[l.Currency] = net[l.Currency].Sub(l.Amount)
+		default:
+			return fmt.Errorf("ledger: line %d has invalid side %q", i, l.Side)
+		}
+	}
+	for cur, diff := range net {
+		if !diff.IsZero() {
+			return fmt.Errorf("ledger: entry %s is unbalanced in %s by %s", e.Type, cur, diff.String())
+		}
+	}
+	return nil
+}
+
+// delta — изменение остатка счёта от строки в его «естественном» знаке.
+func (l Line) delta() decimal.Decimal {
+	d := l.Amount
+	if (l.Side == SideDebit) == creditNormal[l.Account.Type] {
+		d = d.Neg()
+	}
+	return d
+}
+
+// Execer — *sql.Tx (или *sql.DB для чтения). Проводки пишутся только внутри транзакции вызывающего.
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Post проверяет и записывает проводку в журнал, затем применяет её к
+// балансам Кошелька и карт. Вызывающий отвечает за Commit/Rollback.
+func Post(tx Execer, e Entry) (int64, error) {
+	return post(tx, e, true)
+}
+
+// Record записывает проводку в журнал, не меняя балансы. Только для остатков, которые уже
+// отражены в internal_balances / wallet_balances / cards (начальные остатки OPENING_BALANCE).
+func Record(tx Execer, e Entry) (int64, error) {
+	return post(tx, e, false)
+}
+
+func post(tx Execer, e Entry, apply bool) (int64, error) {
+	if err := e.Validate(); err != nil {
+		return 0, err
+	}
+
+	var txRef interface{}
+	if e.TransactionID > 0 {
+		txRef = e.TransactionID
+	}
+
+	var entryID int64
+	err := tx.QueryRow(
+		`INSERT INTO ledger_entries (entry_type, reference, transaction_id, description, created_at)
+		 VALUES ($1, $2, $3, $4, NOW()) RETURNING id`,
+		e.Type, e.Reference, txRef, e.Description,
+	).Scan(&entryID)
+	if err != nil {
+		return 0, fmt.Errorf("ledger: insert entry: %w", err)
+	}
+
+	for _, l := range e.Lines {
+		var userID, cardID, teamID interface{}
+		if l.Account.UserID > 0 {
+			userID = l.Account.UserID
+		}
+		if l.Account.CardID > 0 {
+			cardID = l.Account.CardID
+		}
+		if l.Account.TeamID > 0 {
+			teamID = l.Account.TeamID
+		}
+		_, err := tx.Exec(
+			`INSERT INTO ledger_postings (entry_id, account_type, account_code, user_id, card_id, team_id, side, amount, currency)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			entryID, string(l.Account.Type), l.Account.Code(), userID, cardID, teamID, string(l.Side), l.Amount, l.Currency,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("ledger: insert posting: %w", err)
+		}
+
+		if !apply {
+			continue
+		}
+		if err := applyBalance(tx, l); err != nil {
+			return 0, err
+		}
+	}
+
+	return entryID, nil
+}
+
+// walletBaseCurrency — валюта основного Кошелька (internal_balances.master_balance).
+const walletBaseCurrency = "USD"
+
+// applyBalance обновляет денормализованные балансы, которые читает остальной код.
+func applyBalance(tx Execer, l Line) error {
+	d := l.delta()
+	switch l.Account.Type {
+	case AccountUserWallet:
+		if l.Currency != walletBaseCurrency {
+			_, err := tx.Exec(
+				`INSERT INTO wallet_balances (user_id, currency, balance, updated_at)
+				 VALUES ($1, $2, $3, NOW())
+				 ON CONFLICT (user_id, currency) DO UPDATE SET balance = wallet_balances.balance + $3, updated_at = NOW()`,
+				l.Account.UserID, l.Currency, d,
+			)
+			if err != nil {
+				return fmt.Errorf("ledger: update %s wallet %d: %w", l.Currency, l.Account.UserID, err)
+			}
+			return nil
+		}
+		_, err := tx.Exec(
+			`INSERT INTO internal_balances (user_id, master_balance, updated_at)
+			 VALUES ($1, $2, NOW())
+			 ON CONFLICT (user_id) DO UPDATE SET master_balance = internal_balances.master_balance + $2, updated_at = NOW()`,
+			l.Account.UserID, d,
+		)
+		if err != nil {
+			return fmt.Errorf("ledger: update wallet %d: %w", l.Account.UserID, err)
+		}
+	case AccountCard:
+		res, err := tx.Exec(
+			`UPDATE cards SET card_balance = COALESCE(card_balance, 0) + $1 WHERE id = $2`,
+			d, l.Account.CardID,
+		)
+		if err != nil {
+			return fmt.Errorf("ledger: update card %d: %w", l.Account.CardID, err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return fmt.Errorf("ledger: card %d not found", l.Account.CardID)
+		}
+	case AccountTeamWallet:
+		_, err := tx.Exec(
+			`INSERT INTO team_wallet_balances (team_id, currency, balance, updated_at)
+			 VALUES ($1, $2, $3, NOW())
+			 ON CONFLICT (team_id, currency) DO UPDATE SET balance = team_wallet_balances.balance + $3, updated_at = NOW()`,
+			l.Account.TeamID, l.Currency, d,
+		)
+		if err != nil {
+			return fmt.Errorf("ledger: update team %d %s wallet: %w", l.Account.TeamID, l.Currency, err)
+		}
+	}
+	return nil
+}
+
+// Balance — остаток счёта по журналу в указанной валюте.
+// Используется для сверки с internal_balances / cards.
+func Balance(q Execer, a Account, currency string) (decimal.Decimal, error) {
+	var debits, credits decimal.Decimal
+	err := q.QueryRow(
+		`SELECT COALESCE(SUM(amount) FILTER (WHERE side = 'D'), 0),
+		        COALESCE(SUM(amount) FILTER (WHERE side = 'C'), 0)
+		 FROM ledger_postings WHERE account_code = $1 AND currency = $2`,
+		a.Code(), currency,
+	).Scan(&debits, &credits)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("ledger: balance %s: %w", a.Code(), err)
+	}
+	if creditNormal[a.Type] {
+		return credits.Sub(debits), nil
+	}
+	return debits.Sub(credits), nil
+}
+
+// EnsureTables создаёт таблицы журнала, если их нет.
+func EnsureTables(db *sql.DB) error {
+	if db == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS ledger_entries (
+			id             BIGSERIAL PRIMARY KEY,
+			entry_type     TEXT NOT NULL,
+			reference      TEXT NOT NULL DEFAULT '',
+			transaction_id INTEGER,
+			description    TEXT NOT NULL DEFAULT '',
+			created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_ledger_entries_tx ON ledger_entries(transaction_id);
+		CREATE INDEX IF NOT EXISTS idx_ledger_entries_ref ON ledger_entries(reference);
+
+		CREATE TABLE IF NOT EXISTS ledger_postings (
+			id           BIGSERIAL PRIMARY KEY,
+			entry_id     BIGINT NOT NULL REFERENCES ledger_entries(id),
+			account_type TEXT NOT NULL,
+			account_code TEXT NOT NULL,
+			user_id      INTEGER,
+			card_id      INTEGER,
+			team_id      INTEGER,
+			side         CHAR(1) NOT NULL CHECK (side IN ('D', 'C')),
+			amount       NUMERIC(20,4) NOT NULL CHECK (amount > 0),
+			currency     VARCHAR(10) NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_ledger_postings_account ON ledger_postings(account_code, currency);
+		CREATE INDEX IF NOT EXISTS idx_ledger_postings_entry ON ledger_postings(entry_id);
+		ALTER TABLE ledger_postings ADD COLUMN IF NOT EXISTS team_id INTEGER;
+
+		ALTER TABLE IF EXISTS ledger_entries DISABLE ROW LEVEL SECURITY;
+		ALTER TABLE IF EXISTS ledger_postings DISABLE ROW LEVEL SECURITY;
+	`)
+	return err
+}
@@ -0,0 +1,79 @@
+package ledger
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func d(s string) decimal.Decimal { return decimal.RequireFromString(s) }
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name  string
+		entry Entry
+		ok    bool
+	}{
+		{"выпуск карты", Entry{Type: "CARD_ISSUE_FEE", Lines: Move(UserWallet(1), FeeRevenue, d("6.70"), "USD")}, true},
+		{"конвертация USD→EUR", Entry{Type: "CARD_TOPUP", Lines: append(
+			Move(UserWallet(1), FX, d("10.80"), "USD"),
+			Move(FX, Card(5), d("10.00"), "EUR")...,
+		)}, true},
+		{"несбалансирована", Entry{Type: "X", Lines: []Line{
+			Debit(UserWallet(1), d("10"), "USD"),
+			Credit(FeeRevenue, d("9.99"), "USD"),
+		}}, false},
+		{"разные валюты", Entry{Type: "X", Lines: []Line{
+			Debit(UserWallet(1), d("10"), "USD"),
+			Credit(Card(2), d("10"), "EUR"),
+		}}, false},
+		{"нулевая сумма", Entry{Type: "X", Lines: Move(UserWallet(1), FeeRevenue, decimal.Zero, "USD")}, false},
+		{"одна строка", Entry{Type: "X", Lines: []Line{Debit(UserWallet(1), d("1"), "USD")}}, false},
+		{"без типа", Entry{Lines: Move(UserWallet(1), FeeRevenue, d("1"), "USD")}, false},
+		{"кошелёк без пользователя", Entry{Type: "X", Lines: Move(UserWallet(0), FeeRevenue, d("1"), "USD")}, false},
+		{"Кошелёк команды → карта", Entry{Type: "TEAM_CARD_TOPUP", Lines: Move(TeamWallet(3), Card(5), d("50"), "USD")}, true},
+		{"Кошелёк команды без команды", Entry{Type: "X", Lines: Move(TeamWallet(0), Card(5), d("50"), "USD")}, false},
+	}
+	for _, c := range cases {
+		err := c.entry.Validate()
+		if c.ok && err != nil {
+			t.Errorf("%s: неожиданная ошибка: %v", c.name, err)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("%s: ожидалась ошибка", c.name)
+		}
+	}
+}
+
+func TestLineDelta(t *testing.T) {
+	cases := []struct {
+		line Line
+		want string
+	}{
+		{Credit(UserWallet(1), d("5"), "USD"), "5"},
+		{Debit(UserWallet(1), d("5"), "USD"), "-5"},
+		{Credit(Card(1), d("5"), "USD"), "5"},
+		{Debit(ExternalSettlement, d("5"), "USD"), "5"},
+		{Credit(ExternalSettlement, d("5"), "USD"), "-5"},
+	}
+	for _, c := range cases {
+		if got := c.line.delta(); !got.Equal(d(c.want)) {
+			t.Errorf("%s %s: delta = %s, want %s", c.line.Account.Code(), c.line.Side, got, c.want)
+		}
+	}
+}
+
+func TestAccountCode(t *testing.T) {
+	if got := UserWallet(42).Code(); got != "user_wallet:42" {
+		t.Errorf("UserWallet code = %s", got)
+	}
+	if got := Card(7).Code(); got != "card:7" {
+		t.Errorf("Card code = %s", got)
+	}
+	if got := TeamWallet(3).Code(); got != "team_wallet:3" {
+		t.Errorf("TeamWallet code = %s", got)
+	}
+	if got := FeeRevenue.Code(); got != "fee_revenue" {
+		t.Errorf("FeeRevenue code = %s", got)
+	}
+}
@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/repository"
+)
+
+// IdempotencyKeyHeader — заголовок, которым клиент помечает повторяемый запрос.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// idempotencyTTL — сколько хранится ответ по ключу.
+const idempotencyTTL = 24 * time.Hour
+
+// maxIdempotencyKeyLen — ограничение длины ключа (UUID с запасом).
+const maxIdempotencyKeyLen = 255
+
+// IdempotencyStore — хранилище ключей. По умолчанию — таблица idempotency_keys.
+type IdempotencyStore interface {
+	Reserve(userID int, key, requestHash string, ttl time.Duration) (*repository.IdempotencyRecord, error)
+	Complete(userID int, key string, statusCode int, contentType string, body []byte) error
+}
+
+type dbIdempotencyStore struct{}
+
+func (dbIdempotencyStore) Reserve(userID int, key, requestHash string, ttl time.Duration) (*repository.IdempotencyRecord, error) {
+	return repository.ReserveIdempotencyKey(userID, key, requestHash, ttl)
+}
+
+func (dbIdempotencyStore) Complete(userID int, key string, statusCode int, contentType string, body []byte) error {
+	return repository.CompleteIdempotencyKey(userID, key, statusCode, contentType, body)
+}
+
+// Idempotency — текущее хранилище ключей (подменяется в тестах).
+var Idempotency IdempotencyStore = dbIdempotencyStore{}
+
+// recordingWriter пишет ответ клиенту и одновременно копирует его для сохранения.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+// Idempotent — opt-in middleware для денежных эндпоинтов (оборачивает http.HandlerFunc).
+// Если клиент прислал Idempotency-Key:
+//   - первый запрос выполняется, его ответ сохраняется на 24 часа;
+//   - повтор с тем же ключом и тем же телом получает сохранённый ответ без повторного списания;
+//   - повтор с тем же ключом, но другим телом — 422;
+//   - повтор, пока первый запрос ещё выполняется — 409.
+//
+// Ответы 5xx тоже сохраняются: обработчик мог упасть уже после фиксации списания,
+// поэтому повтор с тем же ключом не выполняет запрос заново — для новой попытки нужен новый ключ.
+// Паника обработчика сохраняется как 500, чтобы ключ не оставался «в процессе» до истечения TTL.
+// Без заголовка запрос проходит как обычно. Должен стоять после JWTAuthMiddleware.
+func Idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+			return
+		}
+
+		userID, ok := r.Context().Value(UserIDKey).(int)
+		if !ok || userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		sum := sha256.New()
+		sum.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+		sum.Write(body)
+		requestHash := hex.EncodeToString(sum.Sum(nil))
+
+		existing, err := Idempotency.Reserve(userID, key, requestHash, idempotencyTTL)
+		if err != nil {
+			log.Printf("[IDEMPOTENCY] Reserve failed (user %d, key %s): %v", userID, key, err)
+			http.Error(w, "Failed to process Idempotency-Key", http.StatusInternalServerError)
+			return
+		}
+
+		if existing != nil {
+			if existing.RequestHash != requestHash {
+				http.Error(w, "Idempotency-Key was already used with a different request", http.StatusUnprocessableEntity)
+				return
+			}
+			if !existing.Completed {
+				http.Error(w, "A request with this Idempotency-Key is still in progress", http.StatusConflict)
+				return
+			}
+			log.Printf("[IDEMPOTENCY] Replay for user %d, key %s → %d", userID, key, existing.StatusCode)
+			if existing.ContentType != "" {
+				w.Header().Set("Content-Type", existing.ContentType)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(existing.StatusCode)
+			w.Write(existing.Body)
+			return
+		}
+
+		rw := &recordingWriter{ResponseWriter: w}
+		defer func() {
+			if p := recover(); p != nil {
+				log.Printf("[IDEMPOTENCY] Handler panicked (user %d, key %s): %v\n%s", userID, key, p, debug.Stack())
+				if rw.status == 0 {
+					http.Error(rw, "Internal server error", http.StatusInternalServerError)
+				}
+			}
+			status := rw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			if err := Idempotency.Complete(userID, key, status, rw.Header().Get("Content-Type"), rw.body.Bytes()); err != nil {
+				log.Printf("[IDEMPOTENCY] Complete failed (user %d, key %s): %v", userID, key, err)
+			}
+		}()
+		next(rw, r)
+	}
+}
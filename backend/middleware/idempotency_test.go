@@ -0,0 +1,174 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/repository"
+)
+
+type memIdempotencyStore struct {
+	mu   sync.Mutex
+	recs map[string]*repository.IdempotencyRecord
+}
+
+func (m *memIdempotencyStore) id(userID int, key string) string { return fmt.Sprintf("%d/%s", userID, key) }
+
+func (m *memIdempotencyStore) Reserve(userID int, key, hash string, _ time.Duration) (*repository.IdempotencyRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if rec, ok := m.recs[m.id(userID, key)]; ok {
+		cp := *rec
+		return &cp, nil
+	}
+	m.recs[m.id(userID, key)] = &repository.IdempotencyRecord{RequestHash: hash}
+	return nil, nil
+}
+
+func (m *memIdempotencyStore) Complete(userID int, key string, status int, ct string, body []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rec := m.recs[m.id(userID, key)]
+	rec.Completed, rec.StatusCode, rec.ContentType, rec.Body = true, status, ct, append([]byte(nil), body...)
+	return nil
+}
+
+func withMemStore(t *testing.T) *memIdempotencyStore {
+	store := &memIdempotencyStore{recs: map[string]*repository.IdempotencyRecord{}}
+	prev := Idempotency
+	Idempotency = store
+	t.Cleanup(func() { Idempotency = prev })
+	return store
+}
+
+func doRequest(h http.HandlerFunc, userID int, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/user/wallet/topup", strings.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), UserIDKey, userID))
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+func TestIdempotentReplaysOriginalResponse(t *testing.T) {
+	withMemStore(t)
+	charges := 0
+	h := Idempotent(func(w http.ResponseWriter, r *http.Request) {
+		charges++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"charge":%d}`, charges)
+	})
+
+	first := doRequest(h, 1, "k1", `{"amount":"100"}`)
+	second := doRequest(h, 1, "k1", `{"amount":"100"}`)
+
+	if charges != 1 {
+		t.Fatalf("обработчик вызван %d раз, ожидался 1", charges)
+	}
+	if second.Code != http.StatusCreated || second.Body.String() != first.Body.String() {
+		t.Errorf("повтор: %d %q, оригинал: %d %q", second.Code, second.Body.String(), first.Code, first.Body.String())
+	}
+	if second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("повтор должен быть помечен заголовком Idempotent-Replayed")
+	}
+	if ct := second.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type повтора = %q", ct)
+	}
+}
+
+func TestIdempotentRejectsDifferentBody(t *testing.T) {
+	withMemStore(t)
+	h := Idempotent(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) })
+
+	doRequest(h, 1, "k2", `{"amount":"100"}`)
+	rec := doRequest(h, 1, "k2", `{"amount":"200"}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("ожидался 422, получен %d", rec.Code)
+	}
+}
+
+func TestIdempotentKeysAreScopedPerUser(t *testing.T) {
+	withMemStore(t)
+	calls := 0
+	h := Idempotent(func(w http.ResponseWriter, r *http.Request) { calls++ })
+
+	doRequest(h, 1, "shared", `{}`)
+	doRequest(h, 2, "shared", `{}`)
+	if calls != 2 {
+		t.Fatalf("ключи разных пользователей не должны пересекаться, вызовов: %d", calls)
+	}
+}
+
+func TestIdempotentStoresServerError(t *testing.T) {
+	withMemStore(t)
+	charges := 0
+	h := Idempotent(func(w http.ResponseWriter, r *http.Request) {
+		// Списание зафиксировано, но ответ собрать не удалось
+		charges++
+		http.Error(w, "failed to load balance", http.StatusInternalServerError)
+	})
+
+	if rec := doRequest(h, 1, "k3", `{}`); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("ожидался 500, получен %d", rec.Code)
+	}
+	rec := doRequest(h, 1, "k3", `{}`)
+	if charges != 1 {
+		t.Fatalf("повтор после 5xx не должен списывать снова, вызовов: %d", charges)
+	}
+	if rec.Code != http.StatusInternalServerError || rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("повтор после 5xx: %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestIdempotentRecoversPanic(t *testing.T) {
+	store := withMemStore(t)
+	h := Idempotent(func(w http.ResponseWriter, r *http.Request) { panic("boom") })
+
+	if rec := doRequest(h, 1, "k5", `{}`); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("ожидался 500 после паники, получен %d", rec.Code)
+	}
+	if rec := store.recs[store.id(1, "k5")]; rec == nil || !rec.Completed {
+		t.Fatal("ключ после паники не должен оставаться «в процессе»")
+	}
+	if rec := doRequest(h, 1, "k5", `{}`); rec.Code != http.StatusInternalServerError || rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("повтор после паники: %d", rec.Code)
+	}
+}
+
+func TestIdempotentInProgressConflict(t *testing.T) {
+	withMemStore(t)
+	var nested *httptest.ResponseRecorder
+	var h http.HandlerFunc
+	h = Idempotent(func(w http.ResponseWriter, r *http.Request) {
+		if nested == nil {
+			// Двойной клик: второй запрос приходит, пока первый ещё выполняется
+			nested = doRequest(h, 1, "k4", `{}`)
+		}
+		w.Write([]byte("ok"))
+	})
+
+	doRequest(h, 1, "k4", `{}`)
+	if nested == nil || nested.Code != http.StatusConflict {
+		t.Fatalf("ожидался 409 для параллельного запроса, получен %v", nested)
+	}
+}
+
+func TestIdempotentWithoutHeaderPassesThrough(t *testing.T) {
+	withMemStore(t)
+	calls := 0
+	h := Idempotent(func(w http.ResponseWriter, r *http.Request) { calls++ })
+	doRequest(h, 1, "", `{}`)
+	doRequest(h, 1, "", `{}`)
+	if calls != 2 {
+		t.Fatalf("без Idempotency-Key запросы не дедуплицируются, вызовов: %d", calls)
+	}
+}
@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/djalben/xplr-core/backend/repository"
+	"github.com/gorilla/mux"
+)
+
+// TeamPermissionSource — откуда берутся права участника и команда карты (подменяется в тестах).
+type TeamPermissionSource interface {
+	// MemberPermissions — итоговые права участника; ok=false, если пользователь не в команде.
+	MemberPermissions(teamID, userID int) (perms []string, ok bool, err error)
+	// CardTeamID — команда, для которой выпущена карта; 0 — личная карта.
+	CardTeamID(cardID int) (int, error)
+}
+
+type dbTeamPermissionSource struct{}
+
+func (dbTeamPermissionSource) MemberPermissions(teamID, userID int) ([]string, bool, error) {
+	_, perms, ok, err := repository.GetTeamMemberPermissions(teamID, userID)
+	return perms, ok, err
+}
+
+func (dbTeamPermissionSource) CardTeamID(cardID int) (int, error) {
+	return repository.GetCardTeamID(cardID)
+}
+
+// TeamPermissions — текущий источник прав.
+var TeamPermissions TeamPermissionSource = dbTeamPermissionSource{}
+
+// RequireTeamPermission — middleware для маршрутов /teams/{id}/...: пропускает только участника
+// команды {id} с правом perm. Должен стоять после JWTAuthMiddleware.
+func RequireTeamPermission(perm string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value(UserIDKey).(int)
+		if !ok || userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		teamID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil || teamID <= 0 {
+			http.Error(w, "Invalid team ID", http.StatusBadRequest)
+			return
+		}
+		if !checkTeamPermission(w, r, teamID, userID, perm) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// RequireCardPermission — middleware для маршрутов /cards/{id}/...: для карты команды требует
+// у пользователя право perm в этой команде. Личные карты пропускаются — владение проверяет обработчик.
+func RequireCardPermission(perm string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value(UserIDKey).(int)
+		if !ok || userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		cardID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil || cardID <= 0 {
+			http.Error(w, "invalid card id", http.StatusBadRequest)
+			return
+		}
+		teamID, err := TeamPermissions.CardTeamID(cardID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Card not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("[TEAM-RBAC] Failed to resolve team of card %d: %v", cardID, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if teamID > 0 && !checkTeamPermission(w, r, teamID, userID, perm) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// RequireBodyTeamPermission — middleware для запросов с team_id в JSON-теле (выпуск карт команды):
+// если team_id указан, требует у пользователя право perm в этой команде. Тело восстанавливается для next;
+// разбор остальных полей и ошибки формата остаются обработчику.
+func RequireBodyTeamPermission(perm string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value(UserIDKey).(int)
+		if !ok || userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var req struct {
+			TeamID *int `json:"team_id"`
+		}
+		if json.Unmarshal(body, &req) == nil && req.TeamID != nil && *req.TeamID > 0 &&
+			!checkTeamPermission(w, r, *req.TeamID, userID, perm) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// checkTeamPermission отвечает 403, если у пользователя нет права; возвращает true, если можно продолжать.
+func checkTeamPermission(w http.ResponseWriter, r *http.Request, teamID, userID int, perm string) bool {
+	perms, member, err := TeamPermissions.MemberPermissions(teamID, userID)
+	if err != nil {
+		log.Printf("[TEAM-RBAC] Failed to load permissions of user %d in team %d: %v", userID, teamID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	if !member {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return false
+	}
+	if !repository.HasPermission(perms, perm) {
+		log.Printf("[TEAM-RBAC] ⛔ User %d lacks %s in team %d (%s %s)", userID, perm, teamID, r.Method, r.URL.Path)
+		http.Error(w, "insufficient permissions: "+perm+" required", http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/repository"
+	"github.com/gorilla/mux"
+)
+
+// memTeamPermissions: команда 1 — owner 1, buyer 2, finance 3, admin 5; карта 10 — команды 1, карта 20 — личная.
+type memTeamPermissions struct{}
+
+func (memTeamPermissions) MemberPermissions(teamID, userID int) ([]string, bool, error) {
+	roles := map[int]string{1: "owner", 2: "buyer", 3: "finance", 5: "admin"}
+	role, ok := roles[userID]
+	if teamID != 1 || !ok {
+		return nil, false, nil
+	}
+	return repository.ResolveTeamPermissions(role, nil), true, nil
+}
+
+func (memTeamPermissions) CardTeamID(cardID int) (int, error) {
+	switch cardID {
+	case 10:
+		return 1, nil
+	case 20:
+		return 0, nil
+	}
+	return 0, sql.ErrNoRows
+}
+
+func doPermissionRequest(h http.HandlerFunc, userID int, id string) int {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req = req.WithContext(context.WithValue(req.Context(), UserIDKey, userID))
+	req = mux.SetURLVars(req, map[string]string{"id": id})
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec.Code
+}
+
+func TestRequireTeamAndCardPermission(t *testing.T) {
+	prev := TeamPermissions
+	TeamPermissions = memTeamPermissions{}
+	t.Cleanup(func() { TeamPermissions = prev })
+
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	cases := []struct {
+		name string
+		h    http.HandlerFunc
+		user int
+		id   string
+		want int
+	}{
+		{"owner exports", RequireTeamPermission(domain.PermReportsExport, ok), 1, "1", http.StatusOK},
+		{"buyer cannot export", RequireTeamPermission(domain.PermReportsExport, ok), 2, "1", http.StatusForbidden},
+		{"finance exports", RequireTeamPermission(domain.PermReportsExport, ok), 3, "1", http.StatusOK},
+		{"buyer cannot invite", RequireTeamPermission(domain.PermMembersInvite, ok), 2, "1", http.StatusForbidden},
+		{"admin manages team", RequireTeamPermission(domain.PermTeamManage, ok), 5, "1", http.StatusOK},
+		{"buyer cannot manage team", RequireTeamPermission(domain.PermTeamManage, ok), 2, "1", http.StatusForbidden},
+		{"admin cannot manage roles", RequireTeamPermission(domain.PermRolesManage, ok), 5, "1", http.StatusForbidden},
+		{"owner manages roles", RequireTeamPermission(domain.PermRolesManage, ok), 1, "1", http.StatusOK},
+		{"outsider", RequireTeamPermission(domain.PermWalletFund, ok), 4, "1", http.StatusForbidden},
+		{"bad team id", RequireTeamPermission(domain.PermWalletFund, ok), 1, "x", http.StatusBadRequest},
+		{"buyer cannot view PAN", RequireCardPermission(domain.PermCardsViewPAN, ok), 2, "10", http.StatusForbidden},
+		{"buyer freezes team card", RequireCardPermission(domain.PermCardsFreeze, ok), 2, "10", http.StatusOK},
+		{"owner views PAN", RequireCardPermission(domain.PermCardsViewPAN, ok), 1, "10", http.StatusOK},
+		{"personal card passes", RequireCardPermission(domain.PermCardsViewPAN, ok), 2, "20", http.StatusOK},
+		{"unknown card", RequireCardPermission(domain.PermCardsViewPAN, ok), 2, "30", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		if got := doPermissionRequest(c.h, c.user, c.id); got != c.want {
+			t.Errorf("%s: got %d, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+func TestRequireBodyTeamPermission(t *testing.T) {
+	prev := TeamPermissions
+	TeamPermissions = memTeamPermissions{}
+	t.Cleanup(func() { TeamPermissions = prev })
+
+	var seen string
+	h := RequireBodyTeamPermission(domain.PermCardsIssue, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		seen = string(body)
+	})
+	cases := []struct {
+		name string
+		user int
+		body string
+		want int
+	}{
+		{"buyer issues team cards", 2, `{"count":3,"team_id":1}`, http.StatusOK},
+		{"finance cannot issue", 3, `{"count":3,"team_id":1}`, http.StatusForbidden},
+		{"outsider", 4, `{"team_id":1}`, http.StatusForbidden},
+		{"personal issue passes", 3, `{"count":3}`, http.StatusOK},
+		{"malformed body left to handler", 3, `{"count":`, http.StatusOK},
+	}
+	for _, c := range cases {
+		seen = ""
+		req := httptest.NewRequest(http.MethodPost, "/cards/issue", strings.NewReader(c.body))
+		req = req.WithContext(context.WithValue(req.Context(), UserIDKey, c.user))
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		if rec.Code != c.want {
+			t.Errorf("%s: got %d, want %d", c.name, rec.Code, c.want)
+		}
+		if c.want == http.StatusOK && seen != c.body {
+			t.Errorf("%s: обработчик получил тело %q", c.name, seen)
+		}
+	}
+}
+
+func TestResolveTeamPermissions(t *testing.T) {
+	if got := repository.ResolveTeamPermissions("owner", []string{}); len(got) != len(domain.TeamPermissions) {
+		t.Errorf("owner должен иметь все права, получено %v", got)
+	}
+	if got := repository.ResolveTeamPermissions("member", []string{domain.PermReportsExport}); len(got) != 1 || got[0] != domain.PermReportsExport {
+		t.Errorf("кастомная роль должна заменять шаблон, получено %v", got)
+	}
+	if got := repository.ResolveTeamPermissions("unknown", nil); len(got) != 0 {
+		t.Errorf("неизвестная роль без прав, получено %v", got)
+	}
+	if _, err := repository.NormalizeTeamPermissions([]string{"cards.issue", "cards.delete"}); err == nil {
+		t.Error("ожидалась ошибка для неизвестного права")
+	}
+	got, _ := repository.NormalizeTeamPermissions([]string{"reports.export", "cards.issue", "cards.issue"})
+	if len(got) != 2 || got[0] != domain.PermCardsIssue || got[1] != domain.PermReportsExport {
+		t.Errorf("нормализация: %v", got)
+	}
+}
+
+func TestInvitedRoleWithinInviterPermissions(t *testing.T) {
+	recruiter := repository.ResolveTeamPermissions("member", []string{domain.PermMembersInvite, domain.PermCardsIssue})
+	admin := repository.ResolveTeamPermissions("admin", nil)
+	owner := repository.ResolveTeamPermissions("owner", nil)
+	cases := []struct {
+		name    string
+		inviter []string
+		role    string
+		custom  []string
+		missing []string
+	}{
+		{"recruiter cannot invite member", recruiter, "member", nil,
+			[]string{domain.PermCardsFreeze, domain.PermCardsViewPAN, domain.PermWalletFund}},
+		{"recruiter cannot invite finance", recruiter, "finance", nil,
+			[]string{domain.PermWalletFund, domain.PermReportsExport}},
+		{"recruiter invites narrower custom role", recruiter, "member", []string{domain.PermCardsIssue}, nil},
+		{"admin invites admin", admin, "admin", nil, nil},
+		{"admin cannot grant roles.manage", admin, "member", []string{domain.PermRolesManage}, []string{domain.PermRolesManage}},
+		{"owner invites anything", owner, "member", domain.TeamPermissions, nil},
+	}
+	for _, c := range cases {
+		got := repository.MissingPermissions(repository.ResolveTeamPermissions(c.role, c.custom), c.inviter)
+		if !reflect.DeepEqual(got, c.missing) {
+			t.Errorf("%s: missing %v, want %v", c.name, got, c.missing)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+)
+
+// SignToken appends an HMAC-SHA256 signature (keyed with the JWT secret) to payload:
+// "<payload>.<base64url signature>". The payload must be URL-safe.
+func SignToken(payload string) string {
+	mac := hmac.New(sha256.New, jwtKey)
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignedToken checks a token produced by SignToken and returns its payload.
+func VerifySignedToken(token string) (string, bool) {
+	i := strings.LastIndex(token, ".")
+	if i <= 0 {
+		return "", false
+	}
+	payload := token[:i]
+	sig, err := base64.RawURLEncoding.DecodeString(token[i+1:])
+	if err != nil {
+		return "", false
+	}
+	mac := hmac.New(sha256.New, jwtKey)
+	mac.Write([]byte(payload))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return "", false
+	}
+	return payload, true
+}
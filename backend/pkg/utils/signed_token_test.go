@@ -0,0 +1,16 @@
+package utils
+
+import "testing"
+
+func TestSignedToken(t *testing.T) {
+	token := SignToken("42.abcdef")
+	payload, ok := VerifySignedToken(token)
+	if !ok || payload != "42.abcdef" {
+		t.Fatalf("VerifySignedToken(%q) = %q, %v", token, payload, ok)
+	}
+	for _, bad := range []string{"", "42.abcdef", token[:len(token)-1], "43.abcdef" + token[len("42.abcdef"):]} {
+		if _, ok := VerifySignedToken(bad); ok {
+			t.Errorf("токен %q не должен проходить проверку", bad)
+		}
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"log"
 	"time"
 
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/ledger"
 	"github.com/shopspring/decimal"
 )
 
@@ -25,7 +27,6 @@ type AdminUserRow struct {
 	ID               int    `json:"id"`
 	DisplayName      string `json:"display_name"`
 	Email            string `json:"email"`
-	BalanceRub       string `json:"balance_rub"`
 	Status           string `json:"status"`
 	IsAdmin          bool   `json:"is_admin"`
 	Role             string `json:"role"`
@@ -55,9 +56,9 @@ func GetAdminStats() (*AdminStats, error) {
 		return nil, err
 	}
 
-	// Total balance
+	// Total balance — USD Кошельки (internal_balances)
 	var totalBal decimal.Decimal
-	err = GlobalDB.QueryRow("SELECT COALESCE(SUM(balance_rub), 0) FROM users").Scan(&totalBal)
+	err = GlobalDB.QueryRow("SELECT COALESCE(SUM(master_balance), 0) FROM internal_balances").Scan(&totalBal)
 	if err != nil {
 		log.Printf("AdminStats: error summing balances: %v", err)
 		return nil, err
@@ -86,7 +87,7 @@ func GetAllUsersForAdmin() ([]AdminUserRow, error) {
 	}
 
 	query := `
-		SELECT u.id, COALESCE(u.display_name, ''), u.email, COALESCE(u.balance_rub, 0), u.status, COALESCE(u.is_admin, FALSE),
+		SELECT u.id, COALESCE(u.display_name, ''), u.email, u.status, COALESCE(u.is_admin, FALSE),
 		       COALESCE(u.role, 'user'), COALESCE(u.is_verified, FALSE),
 		       (SELECT COUNT(*) FROM cards c WHERE c.user_id = u.id) as card_count,
 		       COALESCE((SELECT ib.master_balance FROM internal_balances ib WHERE ib.user_id = u.id), 0) as wallet_balance,
@@ -108,15 +109,13 @@ func GetAllUsersForAdmin() ([]AdminUserRow, error) {
 	var users []AdminUserRow
 	for rows.Next() {
 		var u AdminUserRow
-		var bal decimal.Decimal
 		var walletBal decimal.Decimal
 		var createdAt interface{}
 		var tierExpiresAt sql.NullTime
-		if err := rows.Scan(&u.ID, &u.DisplayName, &u.Email, &bal, &u.Status, &u.IsAdmin, &u.Role, &u.IsVerified, &u.CardCount, &walletBal, &u.IsTelegramLinked, &u.NotificationPref, &createdAt, &u.Tier, &tierExpiresAt); err != nil {
+		if err := rows.Scan(&u.ID, &u.DisplayName, &u.Email, &u.Status, &u.IsAdmin, &u.Role, &u.IsVerified, &u.CardCount, &walletBal, &u.IsTelegramLinked, &u.NotificationPref, &createdAt, &u.Tier, &tierExpiresAt); err != nil {
 			log.Printf("AdminUsers: error scanning row: %v", err)
 			continue
 		}
-		u.BalanceRub = bal.String()
 		u.WalletBalance = walletBal.StringFixed(2)
 		u.CreatedAt = fmt.Sprintf("%v", createdAt)
 		if tierExpiresAt.Valid {
@@ -130,67 +129,84 @@ func GetAllUsersForAdmin() ([]AdminUserRow, error) {
 	return users, nil
 }
 
-// AdminAdjustBalance adds (or subtracts if negative) amount to a user's balance.
-func AdminAdjustBalance(targetUserID int, amount decimal.Decimal) (string, error) {
+// AdminAdjustBalance adds (or subtracts if negative) amount to the user's wallet in the given currency.
+// The adjustment is posted against suspense as ADMIN_CREDIT / ADMIN_DEBIT; a debit cannot exceed
+// the available balance.
+func AdminAdjustBalance(targetUserID int, amount decimal.Decimal, currency string) (string, error) {
 	if GlobalDB == nil {
 		return "", fmt.Errorf("database connection not initialized")
 	}
+	currency, err := NormalizeWalletCurrency(currency)
+	if err != nil {
+		return "", err
+	}
 	tx, err := GlobalDB.Begin()
 	if err != nil {
 		return "", fmt.Errorf("failed to begin transaction")
 	}
 	defer tx.Rollback()
 
-	_, err = tx.Exec(
-		"UPDATE users SET balance_rub = COALESCE(balance_rub, 0) + $1, balance = COALESCE(balance, 0) + $1 WHERE id = $2",
-		amount, targetUserID,
-	)
-	if err != nil {
+	available, err := LockWalletBalance(tx, targetUserID, currency)
+	if err != nil && err != sql.ErrNoRows {
 		log.Printf("AdminAdjustBalance: DB error: %v", err)
 		return "", fmt.Errorf("failed to adjust balance")
 	}
-
-	details := fmt.Sprintf("Admin balance adjustment: %s", amount.String())
-	txType := "FUND"
+	txType := "ADMIN_CREDIT"
+	lines := ledger.Move(ledger.Suspense, ledger.UserWallet(targetUserID), amount.Abs(), currency)
 	if amount.LessThan(decimal.Zero) {
-		txType = "CAPTURE"
+		if available.LessThan(amount.Abs()) {
+			return "", fmt.Errorf("%w: %s wallet has %s", ErrInsufficientWalletFunds, currency, available.StringFixed(2))
+		}
+		txType = "ADMIN_DEBIT"
+		lines = ledger.Move(ledger.UserWallet(targetUserID), ledger.Suspense, amount.Abs(), currency)
 	}
-	_, err = tx.Exec(
-		`INSERT INTO transactions (user_id, amount, fee, transaction_type, status, details, executed_at)
-		 VALUES ($1, $2, 0, $3, 'APPROVED', $4, $5)`,
-		targetUserID, amount.Abs(), txType, details, time.Now(),
-	)
+
+	details := fmt.Sprintf("Admin balance adjustment: %s %s", amount.String(), currency)
+	var txID int
+	err = tx.QueryRow(
+		`INSERT INTO transactions (user_id, amount, fee, transaction_type, status, details, currency, wallet_currency, executed_at)
+		 VALUES ($1, $2, 0, $3, 'APPROVED', $4, $5, $5, $6) RETURNING id`,
+		targetUserID, amount.Abs(), txType, details, currency, time.Now(),
+	).Scan(&txID)
 	if err != nil {
 		log.Printf("AdminAdjustBalance: failed to log transaction: %v", err)
+		return "", fmt.Errorf("failed to adjust balance")
+	}
+	if _, err := ledger.Post(tx, ledger.Entry{
+		Type:          txType,
+		TransactionID: txID,
+		Description:   details,
+		Lines:         lines,
+	}); err != nil {
+		log.Printf("AdminAdjustBalance: ledger error: %v", err)
+		return "", fmt.Errorf("failed to adjust balance")
 	}
 
+	newBal := available.Add(amount)
 	if err := tx.Commit(); err != nil {
 		return "", fmt.Errorf("failed to commit")
 	}
 
-	// Fetch new balance
-	var newBal decimal.Decimal
-	GlobalDB.QueryRow("SELECT COALESCE(balance_rub, 0) FROM users WHERE id = $1", targetUserID).Scan(&newBal)
-	log.Printf("✅ Admin adjusted user %d balance by %s. New balance: %s", targetUserID, amount.String(), newBal.String())
+	log.Printf("✅ Admin adjusted user %d %s wallet by %s. New available balance: %s", targetUserID, currency, amount.String(), newBal.String())
 	return newBal.String(), nil
 }
 
 // UserFullDetails contains comprehensive user data for admin inspection.
 type UserFullDetails struct {
-	ID               int               `json:"id"`
-	Email            string            `json:"email"`
-	Status           string            `json:"status"`
-	WalletBalance    string            `json:"wallet_balance"`
-	BalanceRub       string            `json:"balance_rub"`
-	IsAdmin          bool              `json:"is_admin"`
-	IsVerified       bool              `json:"is_verified"`
-	IsBlocked        bool              `json:"is_blocked"`
-	TelegramChatID   int64             `json:"telegram_chat_id"`
-	IsTelegramLinked bool              `json:"is_telegram_linked"`
-	NotificationPref string            `json:"notification_pref"`
-	CreatedAt        string            `json:"created_at"`
-	Cards            []UserCardSummary `json:"cards"`
-	Transactions     []UserTxSummary   `json:"transactions"`
+	ID               int                    `json:"id"`
+	Email            string                 `json:"email"`
+	Status           string                 `json:"status"`
+	WalletBalance    string                 `json:"wallet_balance"`
+	Wallets          []domain.WalletBalance `json:"wallets"`
+	IsAdmin          bool                   `json:"is_admin"`
+	IsVerified       bool                   `json:"is_verified"`
+	IsBlocked        bool                   `json:"is_blocked"`
+	TelegramChatID   int64                  `json:"telegram_chat_id"`
+	IsTelegramLinked bool                   `json:"is_telegram_linked"`
+	NotificationPref string                 `json:"notification_pref"`
+	CreatedAt        string                 `json:"created_at"`
+	Cards            []UserCardSummary      `json:"cards"`
+	Transactions     []UserTxSummary        `json:"transactions"`
 }
 
 type UserCardSummary struct {
@@ -224,12 +240,12 @@ func GetUserFullDetails(userID int) (*UserFullDetails, error) {
 	}
 
 	d := &UserFullDetails{}
-	var balRub, walletBal decimal.Decimal
+	var walletBal decimal.Decimal
 	var tgChatID int64
 	var createdAt time.Time
 
 	err := GlobalDB.QueryRow(`
-		SELECT u.id, u.email, u.status, COALESCE(u.balance_rub, 0),
+		SELECT u.id, u.email, u.status,
 		       COALESCE((SELECT ib.master_balance FROM internal_balances ib WHERE ib.user_id = u.id), 0),
 		       COALESCE(u.is_admin, FALSE), COALESCE(u.is_verified, FALSE),
 		       COALESCE(u.is_blocked, FALSE),
@@ -237,14 +253,18 @@ func GetUserFullDetails(userID int) (*UserFullDetails, error) {
 		       COALESCE(u.notification_pref, 'email'),
 		       u.created_at
 		FROM users u WHERE u.id = $1
-	`, userID).Scan(&d.ID, &d.Email, &d.Status, &balRub, &walletBal,
+	`, userID).Scan(&d.ID, &d.Email, &d.Status, &walletBal,
 		&d.IsAdmin, &d.IsVerified, &d.IsBlocked,
 		&tgChatID, &d.NotificationPref, &createdAt)
 	if err != nil {
 		return nil, fmt.Errorf("user not found: %w", err)
 	}
-	d.BalanceRub = balRub.String()
 	d.WalletBalance = walletBal.StringFixed(2)
+	if wallets, err := GetWalletBalances(userID); err == nil {
+		d.Wallets = wallets
+	} else {
+		log.Printf("UserFullDetails: failed to load wallets for user %d: %v", userID, err)
+	}
 	d.TelegramChatID = tgChatID
 	d.IsTelegramLinked = tgChatID != 0
 	d.CreatedAt = createdAt.Format(time.RFC3339)
@@ -354,7 +374,8 @@ func SearchUsersByEmail(query string, limit int) ([]AdminUserRow, error) {
 		limit = 50
 	}
 	sql := `
-		SELECT u.id, u.email, COALESCE(u.balance_rub, 0), u.status, COALESCE(u.is_admin, FALSE),
+		SELECT u.id, u.email, COALESCE((SELECT ib.master_balance FROM internal_balances ib WHERE ib.user_id = u.id), 0),
+		       u.status, COALESCE(u.is_admin, FALSE),
 		       COALESCE(u.role, 'user'), COALESCE(u.is_verified, FALSE), COALESCE(u.is_blocked, FALSE),
 		       (SELECT COUNT(*) FROM cards c WHERE c.user_id = u.id) as card_count,
 		       u.created_at
@@ -376,7 +397,7 @@ func SearchUsersByEmail(query string, limit int) ([]AdminUserRow, error) {
 		if err := rows.Scan(&u.ID, &u.Email, &bal, &u.Status, &u.IsAdmin, &u.Role, &u.IsVerified, &u.IsBlocked, &u.CardCount, &createdAt); err != nil {
 			continue
 		}
-		u.BalanceRub = bal.String()
+		u.WalletBalance = bal.StringFixed(2)
 		u.CreatedAt = fmt.Sprintf("%v", createdAt)
 		users = append(users, u)
 	}
@@ -634,13 +655,74 @@ func EmergencyFreezeUser(userID int) (int, error) {
 	}
 
 	// 3. Zero out wallet balance
-	_, _ = GlobalDB.Exec(`UPDATE internal_balances SET master_balance = 0, updated_at = NOW() WHERE user_id = $1`, userID)
-	_, _ = GlobalDB.Exec(`UPDATE users SET balance_rub = 0 WHERE id = $1`, userID)
+	if err := seizeWalletBalance(userID); err != nil {
+		log.Printf("🚨 EMERGENCY FREEZE: user %d — failed to move wallet to suspense: %v", userID, err)
+	}
 
 	log.Printf("🚨 EMERGENCY FREEZE: user %d — %d cards frozen, status=BANNED, balance zeroed", userID, frozenCount)
 	return int(frozenCount), nil
 }
 
+// seizeWalletBalance — переносит остатки всех Кошельков пользователя на счёт suspense (изъятые средства).
+func seizeWalletBalance(userID int) error {
+	tx, err := GlobalDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	balances := make(map[string]decimal.Decimal)
+	var usd decimal.Decimal
+	err = tx.QueryRow(
+		`SELECT COALESCE(master_balance, 0) FROM internal_balances WHERE user_id = $1 FOR UPDATE`, userID,
+	).Scan(&usd)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	balances[WalletCurrency] = usd
+
+	rows, err := tx.Query(`SELECT currency, balance FROM wallet_balances WHERE user_id = $1 FOR UPDATE`, userID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var cur string
+		var bal decimal.Decimal
+		if err := rows.Scan(&cur, &bal); err != nil {
+			rows.Close()
+			return err
+		}
+		balances[cur] = bal
+	}
+	rows.Close()
+
+	for _, cur := range WalletCurrencies {
+		balance := balances[cur]
+		if !balance.IsPositive() {
+			continue
+		}
+		var txID int
+		err = tx.QueryRow(
+			`INSERT INTO transactions (user_id, amount, fee, transaction_type, status, details, currency, wallet_currency, executed_at)
+			 VALUES ($1, $2, 0, 'EMERGENCY_FREEZE', 'APPROVED', $3, $4, $4, NOW()) RETURNING id`,
+			userID, balance, "Emergency freeze: wallet balance moved to suspense", cur,
+		).Scan(&txID)
+		if err != nil {
+			return err
+		}
+
+		if _, err := ledger.Post(tx, ledger.Entry{
+			Type:          "EMERGENCY_FREEZE",
+			TransactionID: txID,
+			Description:   "Emergency freeze",
+			Lines:         ledger.Move(ledger.UserWallet(userID), ledger.Suspense, balance, cur),
+		}); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 // --- Enhanced Admin Stats ---
 
 type AdminDashboardStats struct {
@@ -0,0 +1,208 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/ledger"
+	"github.com/shopspring/decimal"
+)
+
+// Ошибки повторной проверки при списании под блокировкой строк.
+var (
+	ErrInsufficientWallet = errors.New("insufficient wallet balance")
+	ErrCardSpendingLimit  = errors.New("card spending limit exceeded")
+)
+
+// CardAuthBalances — состояние Кошелька и лимита карты, по которому принимается решение об авторизации.
+type CardAuthBalances struct {
+	Wallet          decimal.Decimal // доступный баланс Кошелька владельца: master_balance − активные холды
+	SpendingLimit   decimal.Decimal // cards.spending_limit, 0 — без лимита
+	SpentFromWallet decimal.Decimal // cards.spent_from_wallet + активные холды по карте
+}
+
+// GetCardAuthBalances reads the available wallet balance of userID and the spending limit of cardID.
+// Active holds count as spent on both sides. A missing wallet is reported as a zero balance.
+func GetCardAuthBalances(cardID, userID int) (CardAuthBalances, error) {
+	var b CardAuthBalances
+	if GlobalDB == nil {
+		return b, fmt.Errorf("database connection not initialized")
+	}
+	err := GlobalDB.QueryRow(`
+		SELECT COALESCE((SELECT master_balance FROM internal_balances WHERE user_id = $2), 0)
+		         - (SELECT COALESCE(SUM(amount), 0) FROM card_holds WHERE user_id = $2 AND status = 'ACTIVE'),
+		       COALESCE(spending_limit, 0),
+		       COALESCE(spent_from_wallet, 0)
+		         + (SELECT COALESCE(SUM(amount), 0) FROM card_holds WHERE card_id = $1 AND status = 'ACTIVE')
+		FROM cards WHERE id = $1`, cardID, userID,
+	).Scan(&b.Wallet, &b.SpendingLimit, &b.SpentFromWallet)
+	if err == sql.ErrNoRows {
+		return b, fmt.Errorf("card not found")
+	}
+	return b, err
+}
+
+// GetRecurringPolicy returns the card's auto-pay toggle and whether the merchant is blocked on it.
+func GetRecurringPolicy(cardID int, merchantName string) (autoPayEnabled bool, merchantBlocked bool, err error) {
+	if GlobalDB == nil {
+		return true, false, fmt.Errorf("database connection not initialized")
+	}
+	err = GlobalDB.QueryRow(
+		`SELECT COALESCE(is_auto_pay_enabled, TRUE) FROM cards WHERE id = $1`, cardID,
+	).Scan(&autoPayEnabled)
+	if err != nil {
+		return true, false, err
+	}
+	if merchantName != "" {
+		err = GlobalDB.QueryRow(
+			`SELECT COALESCE(is_blocked, FALSE) FROM merchant_blocks WHERE card_id = $1 AND LOWER(merchant_name) = LOWER($2)`,
+			cardID, merchantName,
+		).Scan(&merchantBlocked)
+		if err == sql.ErrNoRows {
+			err = nil
+		}
+	}
+	return autoPayEnabled, merchantBlocked, err
+}
+
+// CardCapture — одобренное списание по карте из Кошелька.
+type CardCapture struct {
+	UserID       int
+	CardID       int
+	Amount       decimal.Decimal
+	Fee          decimal.Decimal // записывается в transactions.fee
+	MerchantName string
+	ProviderTxID string // ссылка эмитента; по ней сверка относит CAPTURE к Кошельку
+	Details      string
+	Description  string // описание проводки в журнале
+	HoldID       int    // холд, который подтверждает это списание (0 — списание без холда)
+	// Notification ставится в очередь в транзакции списания; nil — без уведомления
+	Notification *domain.UserNotification
+}
+
+// CaptureCardPayment debits an approved card payment from the wallet in one DB transaction:
+// wallet and card rows are locked and re-checked, the CAPTURE is recorded, the ledger entry
+// Wallet → SupplierPayable is posted, spent_from_wallet grows and failed_auth_count resets.
+// Both authorization paths (AuthorizeCard and the Wallester webhook) end here. With HoldID set
+// the capture settles that hold: its reservation is not counted against the wallet, and the hold
+// is marked CAPTURED in the same transaction (a smaller capture releases the rest).
+// c.Notification is enqueued in the same transaction as well.
+func CaptureCardPayment(c CardCapture) (int, error) {
+	if GlobalDB == nil {
+		return 0, fmt.Errorf("database connection not initialized")
+	}
+	tx, err := GlobalDB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Доступный баланс: свой холд (если списание его подтверждает) уже зарезервирован под эту сумму
+	walletBalance, err := LockAvailableWalletBalance(tx, c.UserID, c.HoldID)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("%w: wallet not found for user %d", ErrInsufficientWallet, c.UserID)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to lock wallet: %w", err)
+	}
+	if walletBalance.LessThan(c.Amount) {
+		return 0, fmt.Errorf("%w: required %s, available %s", ErrInsufficientWallet, c.Amount.String(), walletBalance.String())
+	}
+
+	var spendingLimit, spentFromWallet decimal.Decimal
+	err = tx.QueryRow(
+		`SELECT COALESCE(spending_limit, 0), COALESCE(spent_from_wallet, 0) FROM cards WHERE id = $1 FOR UPDATE`,
+		c.CardID,
+	).Scan(&spendingLimit, &spentFromWallet)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get card limits: %w", err)
+	}
+	// spending_limit = 0 означает «без лимита» (unlimited); остальные холды по карте уже заняли часть лимита
+	if spendingLimit.GreaterThan(decimal.Zero) {
+		held, err := cardHeldAmount(tx, c.CardID, c.HoldID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to sum card holds: %w", err)
+		}
+		if remaining := spendingLimit.Sub(spentFromWallet).Sub(held); c.Amount.GreaterThan(remaining) {
+			return 0, fmt.Errorf("%w: limit remaining %s, tx amount %s", ErrCardSpendingLimit,
+				remaining.String(), c.Amount.String())
+		}
+	}
+	// Списание по холду уже прошло скользящие лимиты в PlaceCardHold; без холда — проверяем здесь,
+	// под блокировкой карты, чтобы параллельные авторизации не превысили лимит вместе
+	if c.HoldID == 0 {
+		if err := checkCardVelocity(tx, c.CardID, 0, c.Amount); err != nil {
+			return 0, err
+		}
+	}
+
+	merchantName := c.MerchantName
+	if merchantName == "" {
+		merchantName = "Unknown"
+	}
+	var txID int
+	err = tx.QueryRow(
+		`INSERT INTO transactions (user_id, card_id, amount, fee, transaction_type, status, details, provider_tx_id, merchant_name, executed_at)
+		 VALUES ($1, $2, $3, $4, 'CAPTURE', 'APPROVED', $5, $6, $7, $8) RETURNING id`,
+		c.UserID, c.CardID, c.Amount, c.Fee, c.Details, c.ProviderTxID, merchantName, time.Now(),
+	).Scan(&txID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record transaction: %w", err)
+	}
+
+	_, err = ledger.Post(tx, ledger.Entry{
+		Type:          "CAPTURE",
+		Reference:     c.ProviderTxID,
+		TransactionID: txID,
+		Description:   c.Description,
+		Lines:         ledger.Move(ledger.UserWallet(c.UserID), ledger.SupplierPayable, c.Amount, WalletCurrency),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to deduct wallet: %w", err)
+	}
+
+	_, err = tx.Exec(
+		`UPDATE cards SET spent_from_wallet = COALESCE(spent_from_wallet, 0) + $1, failed_auth_count = 0 WHERE id = $2`,
+		c.Amount, c.CardID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update card spent: %w", err)
+	}
+
+	// Холд закрывается в той же транзакции; при частичном списании остаток освобождается
+	if c.HoldID > 0 {
+		res, err := tx.Exec(
+			`UPDATE card_holds SET status = 'CAPTURED', captured_amount = $2, transaction_id = $3, settled_at = NOW()
+			 WHERE id = $1 AND status = 'ACTIVE'`,
+			c.HoldID, c.Amount, txID,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed to settle card hold: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			log.Printf("⚠️  Capture ref=%s: hold #%d is no longer active, debiting without it", c.ProviderTxID, c.HoldID)
+		}
+	}
+	if c.Notification != nil {
+		if err := EnqueueNotification(tx, *c.Notification); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	log.Printf("✅ Capture: %s from wallet (user %d, card %d, merchant %q, ref=%s)",
+		c.Amount.String(), c.UserID, c.CardID, merchantName, c.ProviderTxID)
+
+	go func() {
+		if err := UpdateUserGrade(c.UserID); err != nil {
+			log.Printf("Warning: Failed to update user grade for user %d: %v", c.UserID, err)
+		}
+	}()
+	return txID, nil
+}
@@ -0,0 +1,383 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/shopspring/decimal"
+)
+
+// DefaultCardHoldExpiryDays — срок жизни холда, если card_hold_expiry_days не задан в system_settings.
+const DefaultCardHoldExpiryDays = 7
+
+// ErrHoldNotActive — холд уже списан, отменён или истёк.
+var ErrHoldNotActive = errors.New("card hold is not active")
+
+// EnsureCardHoldTables creates card_holds and seeds the card_hold_expiry_days setting.
+// A hold reserves wallet funds between an authorization and its capture; master_balance
+// stays the ledger balance and the available balance is master_balance minus active holds.
+func EnsureCardHoldTables() error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	_, err := GlobalDB.Exec(`
+		CREATE TABLE IF NOT EXISTS card_holds (
+			id              SERIAL PRIMARY KEY,
+			user_id         INTEGER NOT NULL,
+			card_id         INTEGER NOT NULL REFERENCES cards(id) ON DELETE CASCADE,
+			amount          NUMERIC(20,4) NOT NULL,
+			merchant_name   TEXT NOT NULL DEFAULT '',
+			provider_tx_id  TEXT NOT NULL UNIQUE,
+			status          TEXT NOT NULL DEFAULT 'ACTIVE',
+			captured_amount NUMERIC(20,4) NOT NULL DEFAULT 0,
+			transaction_id  INTEGER,
+			created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			settled_at      TIMESTAMPTZ
+		);
+		CREATE INDEX IF NOT EXISTS idx_card_holds_user_active ON card_holds(user_id) WHERE status = 'ACTIVE';
+		CREATE INDEX IF NOT EXISTS idx_card_holds_card_active ON card_holds(card_id) WHERE status = 'ACTIVE';
+		ALTER TABLE IF EXISTS card_holds DISABLE ROW LEVEL SECURITY;
+
+		INSERT INTO system_settings (setting_key, setting_value, description)
+		VALUES ('card_hold_expiry_days', '7', 'Через сколько дней неподтверждённый холд по карте освобождается автоматически')
+		ON CONFLICT (setting_key) DO NOTHING;
+	`)
+	if err != nil {
+		log.Printf("[CARD-HOLDS] Error ensuring card_holds table: %v", err)
+		return err
+	}
+	log.Println("[CARD-HOLDS] ✅ card_holds table ensured")
+	return nil
+}
+
+// GetCardHoldExpiryDays reads card_hold_expiry_days from system_settings.
+func GetCardHoldExpiryDays() int {
+	if GlobalDB == nil {
+		return DefaultCardHoldExpiryDays
+	}
+	var value string
+	if err := GlobalDB.QueryRow(
+		`SELECT setting_value FROM system_settings WHERE setting_key = 'card_hold_expiry_days'`,
+	).Scan(&value); err != nil {
+		return DefaultCardHoldExpiryDays
+	}
+	days, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || days <= 0 {
+		return DefaultCardHoldExpiryDays
+	}
+	return days
+}
+
+// LockAvailableWalletBalance locks the user's wallet row and returns the available balance:
+// master_balance minus active holds. excludeHoldID leaves one hold out of the sum (the hold
+// being captured). sql.ErrNoRows is returned unchanged when the user has no wallet.
+func LockAvailableWalletBalance(tx *sql.Tx, userID, excludeHoldID int) (decimal.Decimal, error) {
+	var balance decimal.Decimal
+	err := tx.QueryRow(
+		`SELECT COALESCE(master_balance, 0) FROM internal_balances WHERE user_id = $1 FOR UPDATE`,
+		userID,
+	).Scan(&balance)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	var held decimal.Decimal
+	err = tx.QueryRow(
+		`SELECT COALESCE(SUM(amount), 0) FROM card_holds WHERE user_id = $1 AND status = 'ACTIVE' AND id <> $2`,
+		userID, excludeHoldID,
+	).Scan(&held)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("failed to sum card holds: %w", err)
+	}
+	return balance.Sub(held), nil
+}
+
+// cardHeldAmount sums active holds on a card, leaving excludeHoldID out.
+func cardHeldAmount(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, cardID, excludeHoldID int) (decimal.Decimal, error) {
+	var held decimal.Decimal
+	err := q.QueryRow(
+		`SELECT COALESCE(SUM(amount), 0) FROM card_holds WHERE card_id = $1 AND status = 'ACTIVE' AND id <> $2`,
+		cardID, excludeHoldID,
+	).Scan(&held)
+	return held, err
+}
+
+// GetHeldBalance returns the sum of the user's active card holds.
+func GetHeldBalance(userID int) (decimal.Decimal, error) {
+	if GlobalDB == nil {
+		return decimal.Zero, fmt.Errorf("database connection not initialized")
+	}
+	var held decimal.Decimal
+	err := GlobalDB.QueryRow(
+		`SELECT COALESCE(SUM(amount), 0) FROM card_holds WHERE user_id = $1 AND status = 'ACTIVE'`, userID,
+	).Scan(&held)
+	return held, err
+}
+
+const cardHoldColumns = `id, user_id, card_id, amount, merchant_name, provider_tx_id, status,
+	captured_amount, transaction_id, created_at, settled_at`
+
+func scanCardHold(row interface{ Scan(...interface{}) error }) (*domain.CardHold, error) {
+	var h domain.CardHold
+	var txID sql.NullInt64
+	var settledAt sql.NullTime
+	err := row.Scan(&h.ID, &h.UserID, &h.CardID, &h.Amount, &h.MerchantName, &h.ProviderTxID, &h.Status,
+		&h.CapturedAmount, &txID, &h.CreatedAt, &settledAt)
+	if err != nil {
+		return nil, err
+	}
+	if txID.Valid {
+		id := int(txID.Int64)
+		h.TransactionID = &id
+	}
+	if settledAt.Valid {
+		h.SettledAt = &settledAt.Time
+	}
+	return &h, nil
+}
+
+// PlaceCardHold reserves h.Amount of the card owner's wallet for an approved authorization.
+// Available balance and the card's spending limit (spent + other holds) are re-checked under
+// row locks. Replaying the same provider_tx_id returns the existing hold. n, if not nil, is
+// enqueued in the hold's transaction, so a replay does not notify twice.
+func PlaceCardHold(h *domain.CardHold, n *domain.UserNotification) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	if h.ProviderTxID == "" {
+		return fmt.Errorf("provider_tx_id is required for a card hold")
+	}
+	tx, err := GlobalDB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	available, err := LockAvailableWalletBalance(tx, h.UserID, 0)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: wallet not found for user %d", ErrInsufficientWallet, h.UserID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock wallet: %w", err)
+	}
+
+	existing, err := scanCardHold(tx.QueryRow(`SELECT `+cardHoldColumns+` FROM card_holds WHERE provider_tx_id = $1`, h.ProviderTxID))
+	if err == nil {
+		*h = *existing
+		log.Printf("⚠️  Card hold %s already exists (hold %d, status %s), skipping", h.ProviderTxID, h.ID, h.Status)
+		return nil
+	}
+	if err != sql.ErrNoRows {
+		return fmt.Errorf("failed to check card hold: %w", err)
+	}
+
+	if available.LessThan(h.Amount) {
+		return fmt.Errorf("%w: required %s, available %s", ErrInsufficientWallet, h.Amount.String(), available.String())
+	}
+
+	var spendingLimit, spentFromWallet decimal.Decimal
+	err = tx.QueryRow(
+		`SELECT COALESCE(spending_limit, 0), COALESCE(spent_from_wallet, 0) FROM cards WHERE id = $1 FOR UPDATE`,
+		h.CardID,
+	).Scan(&spendingLimit, &spentFromWallet)
+	if err != nil {
+		return fmt.Errorf("failed to get card limits: %w", err)
+	}
+	if spendingLimit.GreaterThan(decimal.Zero) {
+		held, err := cardHeldAmount(tx, h.CardID, 0)
+		if err != nil {
+			return fmt.Errorf("failed to sum card holds: %w", err)
+		}
+		if remaining := spendingLimit.Sub(spentFromWallet).Sub(held); h.Amount.GreaterThan(remaining) {
+			return fmt.Errorf("%w: limit remaining %s, hold amount %s", ErrCardSpendingLimit, remaining.String(), h.Amount.String())
+		}
+	}
+	// Скользящие лимиты — повторно под блокировкой карты: параллельные холды видят друг друга
+	if err := checkCardVelocity(tx, h.CardID, 0, h.Amount); err != nil {
+		return err
+	}
+
+	if h.MerchantName == "" {
+		h.MerchantName = "Unknown"
+	}
+	h.Status = domain.HoldActive
+	err = tx.QueryRow(
+		`INSERT INTO card_holds (user_id, card_id, amount, merchant_name, provider_tx_id, status)
+		 VALUES ($1, $2, $3, $4, $5, 'ACTIVE') RETURNING id, created_at`,
+		h.UserID, h.CardID, h.Amount, h.MerchantName, h.ProviderTxID,
+	).Scan(&h.ID, &h.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create card hold: %w", err)
+	}
+	if n != nil {
+		if err := EnqueueNotification(tx, *n); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit card hold: %w", err)
+	}
+	log.Printf("✅ Hold #%d: %s reserved (user %d, card %d, merchant %q, ref=%s)",
+		h.ID, h.Amount.String(), h.UserID, h.CardID, h.MerchantName, h.ProviderTxID)
+	return nil
+}
+
+// GetActiveCardHoldByRef finds an active hold by the issuer's authorization ID.
+// Returns nil without an error when there is none.
+func GetActiveCardHoldByRef(providerTxID string) (*domain.CardHold, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	if providerTxID == "" {
+		return nil, nil
+	}
+	h, err := scanCardHold(GlobalDB.QueryRow(
+		`SELECT `+cardHoldColumns+` FROM card_holds WHERE provider_tx_id = $1 AND status = 'ACTIVE'`, providerTxID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return h, err
+}
+
+// GetCardHoldByRef finds a hold in any status by the issuer's authorization ID.
+// Returns nil without an error when there is none.
+func GetCardHoldByRef(providerTxID string) (*domain.CardHold, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	if providerTxID == "" {
+		return nil, nil
+	}
+	h, err := scanCardHold(GlobalDB.QueryRow(
+		`SELECT `+cardHoldColumns+` FROM card_holds WHERE provider_tx_id = $1`, providerTxID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return h, err
+}
+
+// ReleaseCardHold releases amount of an active hold. A zero amount or an amount that covers
+// the whole hold closes it with the given status; a smaller amount only shrinks the reservation.
+// n, if not nil, is enqueued in the same transaction.
+func ReleaseCardHold(holdID int, amount decimal.Decimal, status string, n *domain.UserNotification) (*domain.CardHold, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	tx, err := GlobalDB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	h, err := scanCardHold(tx.QueryRow(`
+		UPDATE card_holds SET
+			amount     = CASE WHEN $2::numeric > 0 AND $2::numeric < amount THEN amount - $2::numeric ELSE amount END,
+			status     = CASE WHEN $2::numeric > 0 AND $2::numeric < amount THEN status ELSE $3::text END,
+			settled_at = CASE WHEN $2::numeric > 0 AND $2::numeric < amount THEN settled_at ELSE NOW() END
+		WHERE id = $1 AND status = 'ACTIVE'
+		RETURNING `+cardHoldColumns, holdID, amount, status))
+	if err == sql.ErrNoRows {
+		return nil, ErrHoldNotActive
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to release card hold: %w", err)
+	}
+	if n != nil {
+		if err := EnqueueNotification(tx, *n); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit card hold release: %w", err)
+	}
+	log.Printf("✅ Hold #%d: released %s (status %s, remaining %s)", h.ID, amount.String(), h.Status, h.Amount.String())
+	return h, nil
+}
+
+// ExpireStaleCardHolds releases active holds older than days and returns them.
+func ExpireStaleCardHolds(days int) ([]domain.CardHold, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	rows, err := GlobalDB.Query(`
+		UPDATE card_holds SET status = 'EXPIRED', settled_at = NOW()
+		WHERE status = 'ACTIVE' AND created_at < NOW() - make_interval(days => $1)
+		RETURNING `+cardHoldColumns, days)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expire card holds: %w", err)
+	}
+	defer rows.Close()
+
+	var holds []domain.CardHold
+	for rows.Next() {
+		h, err := scanCardHold(rows)
+		if err != nil {
+			return nil, err
+		}
+		holds = append(holds, *h)
+	}
+	return holds, rows.Err()
+}
+
+// pendingHoldTransactions returns the user's active holds as PENDING HOLD rows for the
+// unified history. Only the card_id, card_id_wallet, source_type and search filters apply.
+func pendingHoldTransactions(userID int, filters map[string]interface{}) ([]domain.Transaction, error) {
+	if _, ok := filters["card_id_wallet"].(bool); ok {
+		return nil, nil
+	}
+	if v, ok := filters["source_type"].(string); ok && v != "" && v != "card_hold" && v != "card_charge" {
+		return nil, nil
+	}
+	query := `
+		SELECT h.id, h.card_id, h.amount, h.merchant_name, h.provider_tx_id, h.created_at, COALESCE(c.last_4_digits, '')
+		FROM card_holds h
+		LEFT JOIN cards c ON c.id = h.card_id
+		WHERE h.user_id = $1 AND h.status = 'ACTIVE'`
+	args := []interface{}{userID}
+	if v, ok := filters["card_id"].(int); ok && v > 0 {
+		args = append(args, v)
+		query += fmt.Sprintf(" AND h.card_id = $%d", len(args))
+	}
+	if v, ok := filters["search"].(string); ok && v != "" {
+		args = append(args, "%"+v+"%")
+		query += fmt.Sprintf(" AND h.merchant_name ILIKE $%d", len(args))
+	}
+	query += " ORDER BY h.created_at DESC"
+
+	rows, err := GlobalDB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query card holds: %w", err)
+	}
+	defer rows.Close()
+
+	var txs []domain.Transaction
+	for rows.Next() {
+		var holdID, cardID int
+		var merchant string
+		var createdAt time.Time
+		tx := domain.Transaction{
+			UserID:          userID,
+			Fee:             decimal.Zero,
+			TransactionType: "HOLD",
+			Status:          "PENDING",
+			SourceType:      "card_hold",
+			Currency:        WalletCurrency,
+		}
+		if err := rows.Scan(&holdID, &cardID, &tx.Amount, &merchant, &tx.ProviderTxID, &createdAt, &tx.CardLast4Digits); err != nil {
+			return nil, err
+		}
+		tx.CardID = &cardID
+		tx.SourceID = &holdID
+		tx.Details = "Hold: " + merchant
+		tx.ExecutedAt = createdAt
+		txs = append(txs, tx)
+	}
+	return txs, rows.Err()
+}
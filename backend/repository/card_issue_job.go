@@ -0,0 +1,546 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/ledger"
+	"github.com/djalben/xplr-core/backend/telegram"
+	"github.com/shopspring/decimal"
+)
+
+// IssuedProviderCard — карта, выпущенная провайдером (service.CardProvider).
+type IssuedProviderCard struct {
+	Provider       string // Имя эмитента, сохраняется в cards.provider
+	ProviderCardID string
+	BIN            string
+	Last4          string
+	Status         string
+}
+
+// ProviderIssueFunc выпускает одну карту у провайдера.
+// Передаётся из usecase, чтобы repository не зависел от пакета service.
+type ProviderIssueFunc func(currency, cardType string) (*IssuedProviderCard, error)
+
+// EnsureCardIssueJobTables creates card_issue_jobs and card_issue_job_items if they don't exist.
+func EnsureCardIssueJobTables() error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	_, err := GlobalDB.Exec(`
+		CREATE TABLE IF NOT EXISTS card_issue_jobs (
+			id           SERIAL PRIMARY KEY,
+			user_id      INTEGER NOT NULL,
+			status       TEXT NOT NULL DEFAULT 'PENDING',
+			request      JSONB NOT NULL,
+			fee_per_card NUMERIC(20,4) NOT NULL DEFAULT 0,
+			total        INTEGER NOT NULL,
+			succeeded    INTEGER NOT NULL DEFAULT 0,
+			failed       INTEGER NOT NULL DEFAULT 0,
+			refunded     NUMERIC(20,4) NOT NULL DEFAULT 0,
+			created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			started_at   TIMESTAMPTZ,
+			finished_at  TIMESTAMPTZ,
+			updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		ALTER TABLE card_issue_jobs ADD COLUMN IF NOT EXISTS fee_team_id INTEGER;
+		-- Задания, созданные до fee_team_id: комиссия за карты команды списывалась с Кошелька команды
+		UPDATE card_issue_jobs SET fee_team_id = (request->>'team_id')::int
+		WHERE fee_team_id IS NULL AND COALESCE((request->>'team_id')::int, 0) > 0;
+		CREATE INDEX IF NOT EXISTS idx_card_issue_jobs_user ON card_issue_jobs(user_id, id DESC);
+		CREATE INDEX IF NOT EXISTS idx_card_issue_jobs_status ON card_issue_jobs(status) WHERE status IN ('PENDING', 'RUNNING');
+
+		CREATE TABLE IF NOT EXISTS card_issue_job_items (
+			job_id     INTEGER NOT NULL REFERENCES card_issue_jobs(id),
+			idx        INTEGER NOT NULL,
+			success    BOOLEAN NOT NULL,
+			status     TEXT NOT NULL,
+			message    TEXT NOT NULL DEFAULT '',
+			card_id    INTEGER,
+			card_last4 TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (job_id, idx)
+		);
+
+		ALTER TABLE IF EXISTS card_issue_jobs DISABLE ROW LEVEL SECURITY;
+		ALTER TABLE IF EXISTS card_issue_job_items DISABLE ROW LEVEL SECURITY;
+	`)
+	if err != nil {
+		log.Printf("[CARD-JOBS] Error creating card issue job tables: %v", err)
+		return err
+	}
+	log.Println("[CARD-JOBS] ✅ card issue job tables ensured")
+	return nil
+}
+
+// normalizeMassIssueRequest подставляет значения по умолчанию (тип, сервис, категория, валюта).
+func normalizeMassIssueRequest(req domain.MassIssueRequest) domain.MassIssueRequest {
+	if req.CardType == "" {
+		req.CardType = "VISA"
+	}
+	if req.ServiceSlug == "" {
+		req.ServiceSlug = "arbitrage"
+	}
+	req.Category = strings.ToLower(req.Category)
+	if req.Category == "" {
+		req.Category = "arbitrage"
+	}
+	req.Currency = strings.ToUpper(req.Currency)
+	if req.Currency != "EUR" {
+		req.Currency = "USD" // Default to USD unless explicitly EUR
+	}
+	return req
+}
+
+// CreateCardIssueJob ставит в очередь выпуск req.Count карт. Комиссия (feePerCard за карту)
+// к этому моменту уже списана — с Кошелька команды feeTeamID или, если он 0, с Кошелька пользователя;
+// за неудачные карты она возвращается туда же при обработке.
+func CreateCardIssueJob(userID int, req domain.MassIssueRequest, feePerCard decimal.Decimal, feeTeamID int) (*domain.CardIssueJob, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+
+	req = normalizeMassIssueRequest(req)
+	reqJSON, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	job := &domain.CardIssueJob{
+		UserID:     userID,
+		Status:     "PENDING",
+		Request:    req,
+		FeePerCard: feePerCard,
+		Total:      req.Count,
+		Results:    []domain.CardIssueResult{},
+	}
+	var feeTeamRef interface{}
+	if feeTeamID > 0 {
+		job.FeeTeamID = &feeTeamID
+		feeTeamRef = feeTeamID
+	}
+	err = GlobalDB.QueryRow(
+		`INSERT INTO card_issue_jobs (user_id, status, request, fee_per_card, fee_team_id, total)
+		 VALUES ($1, 'PENDING', $2, $3, $4, $5) RETURNING id, created_at`,
+		userID, reqJSON, feePerCard, feeTeamRef, req.Count,
+	).Scan(&job.ID, &job.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create card issue job: %w", err)
+	}
+
+	log.Printf("[CARD-JOBS] Job #%d created: user %d, %d cards, fee $%s/card", job.ID, userID, req.Count, feePerCard.StringFixed(2))
+	return job, nil
+}
+
+// ClaimCardIssueJob переводит задание из PENDING в RUNNING. Возвращает nil, если задание уже взято.
+func ClaimCardIssueJob(jobID int) (*domain.CardIssueJob, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	var id int
+	err := GlobalDB.QueryRow(
+		`UPDATE card_issue_jobs SET status = 'RUNNING', started_at = NOW(), updated_at = NOW()
+		 WHERE id = $1 AND status = 'PENDING' RETURNING id`, jobID,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim card issue job: %w", err)
+	}
+	return GetCardIssueJob(id, 0)
+}
+
+// ClaimNextCardIssueJob берёт следующее задание: PENDING или RUNNING без прогресса дольше staleAfter
+// (процесс, который его выполнял, упал). Возвращает nil, если заданий нет.
+func ClaimNextCardIssueJob(staleAfter time.Duration) (*domain.CardIssueJob, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	var id int
+	err := GlobalDB.QueryRow(`
+		UPDATE card_issue_jobs SET status = 'RUNNING', started_at = COALESCE(started_at, NOW()), updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM card_issue_jobs
+			WHERE status = 'PENDING' OR (status = 'RUNNING' AND updated_at < $1)
+			ORDER BY id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id`, time.Now().Add(-staleAfter),
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim card issue job: %w", err)
+	}
+	return GetCardIssueJob(id, 0)
+}
+
+// GetCardIssueJob returns a job with per-card results. userID = 0 skips the ownership check.
+// Returns nil (no error) when the job does not exist or belongs to another user.
+func GetCardIssueJob(jobID, userID int) (*domain.CardIssueJob, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	return getCardIssueJob(GlobalDB, jobID, userID)
+}
+
+func getCardIssueJob(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}, jobID, userID int) (*domain.CardIssueJob, error) {
+	var job domain.CardIssueJob
+	var reqJSON []byte
+	var feeTeamID sql.NullInt64
+	var startedAt, finishedAt sql.NullTime
+	err := q.QueryRow(
+		`SELECT id, user_id, status, request, fee_per_card, fee_team_id, total, succeeded, failed, refunded,
+		        created_at, started_at, finished_at
+		 FROM card_issue_jobs WHERE id = $1 AND ($2 = 0 OR user_id = $2)`, jobID, userID,
+	).Scan(&job.ID, &job.UserID, &job.Status, &reqJSON, &job.FeePerCard, &feeTeamID, &job.Total,
+		&job.Succeeded, &job.Failed, &job.Refunded, &job.CreatedAt, &startedAt, &finishedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load card issue job: %w", err)
+	}
+	if err := json.Unmarshal(reqJSON, &job.Request); err != nil {
+		return nil, fmt.Errorf("failed to parse card issue job request: %w", err)
+	}
+	if feeTeamID.Valid {
+		id := int(feeTeamID.Int64)
+		job.FeeTeamID = &id
+	}
+	if startedAt.Valid {
+		t := startedAt.Time
+		job.StartedAt = &t
+	}
+	if finishedAt.Valid {
+		t := finishedAt.Time
+		job.FinishedAt = &t
+	}
+
+	rows, err := q.Query(`
+		SELECT i.success, i.status, i.message, i.card_last4,
+		       COALESCE(c.id, 0), COALESCE(c.provider_card_id, ''), COALESCE(c.bin, ''),
+		       COALESCE(c.card_status, ''), COALESCE(c.currency, ''), c.created_at
+		FROM card_issue_job_items i
+		LEFT JOIN cards c ON c.id = i.card_id
+		WHERE i.job_id = $1
+		ORDER BY i.idx`, job.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load card issue job items: %w", err)
+	}
+	defer rows.Close()
+
+	job.Results = []domain.CardIssueResult{}
+	for rows.Next() {
+		var res domain.CardIssueResult
+		var cardID int
+		var providerCardID, bin, cardStatus, currency string
+		var createdAt sql.NullTime
+		if err := rows.Scan(&res.Success, &res.Status, &res.Message, &res.CardLast4,
+			&cardID, &providerCardID, &bin, &cardStatus, &currency, &createdAt); err != nil {
+			return nil, err
+		}
+		res.Nickname = job.Request.CardNickname
+		if cardID > 0 {
+			res.Card = &domain.Card{
+				ID:             cardID,
+				UserID:         job.UserID,
+				TeamID:         job.Request.TeamID,
+				ProviderCardID: providerCardID,
+				BIN:            bin,
+				Last4Digits:    res.CardLast4,
+				CardStatus:     cardStatus,
+				Nickname:       job.Request.CardNickname,
+				ServiceSlug:    job.Request.ServiceSlug,
+				Category:       job.Request.Category,
+				Currency:       currency,
+				CardType:       job.Request.CardType,
+				CreatedAt:      createdAt.Time,
+			}
+		}
+		job.Results = append(job.Results, res)
+	}
+	return &job, rows.Err()
+}
+
+// GetCardIssueJobDoneIndexes returns the card indexes of a job that are already processed.
+func GetCardIssueJobDoneIndexes(jobID int) (map[int]bool, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	rows, err := GlobalDB.Query(`SELECT idx FROM card_issue_job_items WHERE job_id = $1`, jobID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load card issue job items: %w", err)
+	}
+	defer rows.Close()
+
+	done := make(map[int]bool)
+	for rows.Next() {
+		var idx int
+		if err := rows.Scan(&idx); err != nil {
+			return nil, err
+		}
+		done[idx] = true
+	}
+	return done, rows.Err()
+}
+
+// RecordIssuedCard сохраняет выпущенную провайдером карту в cards и отмечает карту idx задания как выпущенную.
+// Всё в одной транзакции: при повторной обработке того же idx карта не задваивается.
+func RecordIssuedCard(job *domain.CardIssueJob, idx int, issued *IssuedProviderCard) (domain.CardIssueResult, error) {
+	if GlobalDB == nil {
+		return domain.CardIssueResult{}, fmt.Errorf("database connection not initialized")
+	}
+	req := job.Request
+	cardStatus := strings.ToUpper(issued.Status)
+	if cardStatus == "" {
+		cardStatus = "ACTIVE"
+	}
+
+	tx, err := GlobalDB.Begin()
+	if err != nil {
+		return domain.CardIssueResult{}, err
+	}
+	defer tx.Rollback()
+
+	var cardID int
+	var createdAt time.Time
+	err = tx.QueryRow(`
+		INSERT INTO cards (user_id, provider_card_id, bin, last_4_digits, card_status, nickname, service_slug, daily_spend_limit, failed_auth_count, card_type, card_balance, team_id, category, currency, provider)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		RETURNING id, created_at
+	`,
+		job.UserID, issued.ProviderCardID, issued.BIN, issued.Last4, cardStatus,
+		req.CardNickname, req.ServiceSlug, req.DailyLimit, 0, req.CardType,
+		decimal.Zero, req.TeamID, req.Category, req.Currency, issued.Provider,
+	).Scan(&cardID, &createdAt)
+	if err != nil {
+		return domain.CardIssueResult{}, fmt.Errorf("failed to save card (provider card %s): %w", issued.ProviderCardID, err)
+	}
+
+	// Информационная запись о выпуске (комиссия проведена отдельно как CARD_ISSUE_FEE)
+	_, err = tx.Exec(
+		`INSERT INTO transactions (user_id, card_id, amount, fee, transaction_type, status, details, executed_at)
+		 VALUES ($1, $2, $3, $4, 'CARD_ISSUE', 'SUCCESS', $5, $6)`,
+		job.UserID, cardID, job.FeePerCard, decimal.Zero,
+		fmt.Sprintf("Card issued: %s •••• %s (%s) — fee $%s", req.CardType, issued.Last4, req.Category, job.FeePerCard.StringFixed(2)),
+		time.Now(),
+	)
+	if err != nil {
+		return domain.CardIssueResult{}, fmt.Errorf("failed to record issue transaction: %w", err)
+	}
+
+	res := domain.CardIssueResult{
+		Success:   true,
+		Status:    cardStatus,
+		CardLast4: issued.Last4,
+		Nickname:  req.CardNickname,
+		Message:   "Card issued successfully",
+	}
+	if err := insertCardIssueJobItem(tx, job.ID, idx, res, cardID); err != nil {
+		return domain.CardIssueResult{}, err
+	}
+	if _, err := tx.Exec(
+		`UPDATE card_issue_jobs SET succeeded = succeeded + 1, updated_at = NOW() WHERE id = $1`, job.ID,
+	); err != nil {
+		return domain.CardIssueResult{}, fmt.Errorf("failed to update card issue job: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return domain.CardIssueResult{}, err
+	}
+
+	res.Card = &domain.Card{
+		ID:              cardID,
+		UserID:          job.UserID,
+		TeamID:          req.TeamID,
+		ProviderCardID:  issued.ProviderCardID,
+		BIN:             issued.BIN,
+		Last4Digits:     issued.Last4,
+		CardStatus:      cardStatus,
+		Nickname:        req.CardNickname,
+		ServiceSlug:     req.ServiceSlug,
+		Category:        req.Category,
+		Currency:        req.Currency,
+		DailySpendLimit: req.DailyLimit,
+		CardType:        req.CardType,
+		CardBalance:     decimal.Zero,
+		CreatedAt:       createdAt,
+	}
+	return res, nil
+}
+
+// CardIssueFeeAccount — счёт, с которого списана комиссия задания и куда она возвращается
+// за невыпущенные карты: Кошелёк команды job.FeeTeamID или Кошелёк пользователя.
+func CardIssueFeeAccount(job *domain.CardIssueJob) ledger.Account {
+	if job.FeeTeamID != nil && *job.FeeTeamID > 0 {
+		return ledger.TeamWallet(*job.FeeTeamID)
+	}
+	return ledger.UserWallet(job.UserID)
+}
+
+// RecordFailedCard отмечает карту idx задания как невыпущенную и в той же транзакции
+// возвращает её комиссию туда, откуда она списана: в Кошелёк команды (TEAM_FEE_REFUND)
+// или в Кошелёк пользователя (FEE_REFUND).
+func RecordFailedCard(job *domain.CardIssueJob, idx int, message string) (domain.CardIssueResult, error) {
+	if GlobalDB == nil {
+		return domain.CardIssueResult{}, fmt.Errorf("database connection not initialized")
+	}
+
+	tx, err := GlobalDB.Begin()
+	if err != nil {
+		return domain.CardIssueResult{}, err
+	}
+	defer tx.Rollback()
+
+	res := domain.CardIssueResult{
+		Success:  false,
+		Status:   "FAILED",
+		Nickname: job.Request.CardNickname,
+		Message:  message,
+	}
+	if err := insertCardIssueJobItem(tx, job.ID, idx, res, 0); err != nil {
+		return domain.CardIssueResult{}, err
+	}
+
+	if job.FeePerCard.GreaterThan(decimal.Zero) {
+		details := fmt.Sprintf("Refund: card issue fee (job #%d, card %d/%d) — $%s",
+			job.ID, idx+1, job.Total, job.FeePerCard.StringFixed(2))
+		if feeAccount := CardIssueFeeAccount(job); feeAccount.Type == ledger.AccountTeamWallet {
+			err = refundTeamWalletFeeTx(tx, feeAccount.TeamID, job.UserID, job.FeePerCard, details)
+		} else {
+			err = refundWalletFeeTx(tx, job.UserID, job.FeePerCard, details)
+		}
+		if err != nil {
+			return domain.CardIssueResult{}, err
+		}
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE card_issue_jobs SET failed = failed + 1, refunded = refunded + $2, updated_at = NOW() WHERE id = $1`,
+		job.ID, job.FeePerCard,
+	); err != nil {
+		return domain.CardIssueResult{}, fmt.Errorf("failed to update card issue job: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return domain.CardIssueResult{}, err
+	}
+	return res, nil
+}
+
+func insertCardIssueJobItem(tx *sql.Tx, jobID, idx int, res domain.CardIssueResult, cardID int) error {
+	var cardRef interface{}
+	if cardID > 0 {
+		cardRef = cardID
+	}
+	r, err := tx.Exec(
+		`INSERT INTO card_issue_job_items (job_id, idx, success, status, message, card_id, card_last4)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)
+		 ON CONFLICT (job_id, idx) DO NOTHING`,
+		jobID, idx, res.Success, res.Status, res.Message, cardRef, res.CardLast4,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save card issue job item: %w", err)
+	}
+	if n, _ := r.RowsAffected(); n == 0 {
+		return fmt.Errorf("card %d of job #%d is already processed", idx, jobID)
+	}
+	return nil
+}
+
+// FinishCardIssueJob закрывает задание: COMPLETED, если выпущена хотя бы одна карта, иначе FAILED.
+// Уведомление пользователю, которое строит notification по закрытому заданию, ставится в очередь
+// в той же транзакции. Возвращает обновлённое задание или nil, если его уже закрыл другой обработчик.
+func FinishCardIssueJob(jobID int, notification func(*domain.CardIssueJob) *domain.UserNotification) (*domain.CardIssueJob, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	tx, err := GlobalDB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow(`
+		UPDATE card_issue_jobs
+		SET status = CASE WHEN succeeded > 0 THEN 'COMPLETED' ELSE 'FAILED' END,
+		    finished_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND status = 'RUNNING'
+		RETURNING id`, jobID,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to finish card issue job: %w", err)
+	}
+	job, err := getCardIssueJob(tx, id, 0)
+	if err != nil {
+		return nil, err
+	}
+	if n := notification(job); n != nil {
+		if err := EnqueueNotification(tx, *n); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit card issue job: %w", err)
+	}
+	return job, nil
+}
+
+// NotifyCardsIssued — уведомление админам и RevShare рефереру после выпуска карт.
+// Уведомление пользователю ставится в очередь в FinishCardIssueJob.
+func NotifyCardsIssued(job *domain.CardIssueJob) {
+	if job.Succeeded == 0 {
+		return
+	}
+	userID := job.UserID
+	req := job.Request
+
+	go func() {
+		email := ""
+		if u, uErr := GetUserByID(userID); uErr == nil {
+			email = u.Email
+		}
+		if email == "" {
+			email = fmt.Sprintf("User #%d", userID)
+		}
+		adminMsg := fmt.Sprintf(
+			"💳 <b>Выпуск карт</b>\n\n"+
+				"👤 <b>Пользователь:</b> %s\n"+
+				"📦 <b>Количество:</b> %d\n"+
+				"🏷 <b>Категория:</b> %s\n"+
+				"💰 <b>Комиссия:</b> $%s",
+			email, job.Succeeded, req.Category, job.FeePerCard.Mul(decimal.NewFromInt(int64(job.Succeeded))).StringFixed(2),
+		)
+		telegram.NotifyAdmins(adminMsg, "💳 Карты", "https://xplr.pro/admin/users")
+	}()
+
+	// RevShare: 5% commission to referrer on card issuance
+	go func() {
+		referrerID := GetReferrerID(userID)
+		if referrerID <= 0 {
+			return
+		}
+		issueAmount := decimal.NewFromInt(int64(job.Succeeded)).Mul(req.DailyLimit)
+		if issueAmount.LessThanOrEqual(decimal.Zero) {
+			issueAmount = decimal.NewFromInt(int64(job.Succeeded))
+		}
+		desc := fmt.Sprintf("%d cards issued (limit $%s each)", job.Succeeded, req.DailyLimit.StringFixed(2))
+		if err := CreditRevShare(referrerID, userID, issueAmount, desc); err != nil {
+			log.Printf("Warning: RevShare failed for user %d -> referrer %d: %v", userID, referrerID, err)
+		}
+	}()
+}
@@ -0,0 +1,273 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/ledger"
+	"github.com/shopspring/decimal"
+)
+
+// EnsureCardOwnershipTables creates card_ownership_transfers — the audit log of team cards
+// handed over from one member to another.
+func EnsureCardOwnershipTables() error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	_, err := GlobalDB.Exec(`
+		CREATE TABLE IF NOT EXISTS card_ownership_transfers (
+			id             SERIAL PRIMARY KEY,
+			card_id        INTEGER NOT NULL REFERENCES cards(id) ON DELETE CASCADE,
+			team_id        INTEGER NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+			from_user_id   INTEGER NOT NULL REFERENCES users(id),
+			to_user_id     INTEGER NOT NULL REFERENCES users(id),
+			performed_by   INTEGER NOT NULL REFERENCES users(id),
+			balance        NUMERIC(20,4) NOT NULL DEFAULT 0,
+			currency       VARCHAR(10) NOT NULL,
+			balance_mode   VARCHAR(20) NOT NULL,
+			transaction_id INTEGER,
+			reason         TEXT NOT NULL DEFAULT '',
+			created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_card_ownership_transfers_team ON card_ownership_transfers(team_id, created_at);
+		CREATE INDEX IF NOT EXISTS idx_card_ownership_transfers_card ON card_ownership_transfers(card_id);
+		ALTER TABLE IF EXISTS card_ownership_transfers DISABLE ROW LEVEL SECURITY;
+	`)
+	if err != nil {
+		log.Printf("[CARD-TRANSFER] Error ensuring tables: %v", err)
+		return err
+	}
+	log.Println("[CARD-TRANSFER] ✅ card_ownership_transfers table ensured")
+	return nil
+}
+
+// TransferTeamCards — передать карты команды участнику toUserID (0 — владельцу команды).
+// Передавать может участник с правом team.manage. Остаток карты по balanceMode либо переходит вместе с картой
+// (счёт карты в леджере не зависит от владельца, проводка не нужна), либо возвращается в Кошелёк
+// команды проводкой карта → Кошелёк команды. Автопополнение карты из Кошелька прежнего владельца
+// и его правила переводов по расписанию на эту карту отключаются. Всё, включая уведомление
+// новому владельцу в notification_outbox, — в одной транзакции.
+func TransferTeamCards(teamID, actorID int, cardIDs []int, toUserID int, balanceMode, reason string) ([]domain.CardOwnershipTransfer, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	if len(cardIDs) == 0 {
+		return nil, fmt.Errorf("не выбраны карты")
+	}
+	if balanceMode == "" {
+		balanceMode = domain.CardTransferKeepBalance
+	}
+	if balanceMode != domain.CardTransferKeepBalance && balanceMode != domain.CardTransferBalanceToTeamWallet {
+		return nil, fmt.Errorf("balance: допустимо 'keep' или 'team_wallet'")
+	}
+	reason = strings.TrimSpace(reason)
+
+	tx, err := GlobalDB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("не удалось начать транзакцию: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := requireTeamPermission(tx, teamID, actorID, domain.PermTeamManage); err != nil {
+		return nil, err
+	}
+	if toUserID == 0 {
+		if err := tx.QueryRow(`SELECT owner_id FROM teams WHERE id = $1`, teamID).Scan(&toUserID); err != nil {
+			return nil, fmt.Errorf("команда не найдена")
+		}
+	}
+	if _, _, ok, err := teamMemberPermissions(tx, teamID, toUserID); err != nil || !ok {
+		return nil, fmt.Errorf("получатель не участник команды")
+	}
+
+	// Блокируем карты в порядке id, чтобы параллельные передачи не взаимоблокировались
+	ids := append([]int(nil), cardIDs...)
+	sort.Ints(ids)
+	var transfers []domain.CardOwnershipTransfer
+	for i, cardID := range ids {
+		if i > 0 && ids[i-1] == cardID {
+			continue
+		}
+		t := domain.CardOwnershipTransfer{CardID: cardID, TeamID: teamID, ToUserID: toUserID, PerformedBy: actorID, BalanceMode: balanceMode, Reason: reason}
+		var cardTeamID sql.NullInt64
+		var status string
+		err := tx.QueryRow(
+			`SELECT user_id, team_id, COALESCE(card_balance, 0), COALESCE(currency, 'USD'), last_4_digits, card_status
+			 FROM cards WHERE id = $1 FOR UPDATE`, cardID,
+		).Scan(&t.FromUserID, &cardTeamID, &t.Balance, &t.Currency, &t.Last4Digits, &status)
+		if err != nil || !cardTeamID.Valid || int(cardTeamID.Int64) != teamID {
+			return nil, fmt.Errorf("карта #%d не найдена в команде", cardID)
+		}
+		if status == "CLOSED" {
+			return nil, fmt.Errorf("карта •••• %s закрыта", t.Last4Digits)
+		}
+		if t.FromUserID == toUserID {
+			continue
+		}
+		if t.Currency, err = NormalizeWalletCurrency(t.Currency); err != nil {
+			return nil, err
+		}
+
+		if balanceMode == domain.CardTransferBalanceToTeamWallet && t.Balance.IsPositive() {
+			txID, err := reclaimCardBalanceToTeamWallet(tx, teamID, t.FromUserID, cardID, t.Last4Digits, t.Balance, t.Currency)
+			if err != nil {
+				return nil, err
+			}
+			t.TransactionID = &txID
+		}
+
+		if _, err := tx.Exec(
+			`UPDATE cards SET user_id = $1, auto_replenish_enabled = FALSE WHERE id = $2`, toUserID, cardID,
+		); err != nil {
+			return nil, fmt.Errorf("не удалось передать карту: %v", err)
+		}
+		if _, err := tx.Exec(
+			`UPDATE scheduled_transfers SET is_active = FALSE WHERE card_id = $1 AND user_id = $2 AND is_active`,
+			cardID, t.FromUserID,
+		); err != nil {
+			return nil, fmt.Errorf("не удалось отключить переводы по расписанию: %v", err)
+		}
+		err = tx.QueryRow(
+			`INSERT INTO card_ownership_transfers
+			   (card_id, team_id, from_user_id, to_user_id, performed_by, balance, currency, balance_mode, transaction_id, reason)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id, created_at`,
+			cardID, teamID, t.FromUserID, toUserID, actorID, t.Balance, t.Currency, balanceMode, t.TransactionID, reason,
+		).Scan(&t.ID, &t.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось записать журнал передачи: %v", err)
+		}
+		transfers = append(transfers, t)
+	}
+
+	if len(transfers) > 0 {
+		if err := EnqueueNotification(tx, cardsReassignedNotification(transfers)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("ошибка фиксации: %v", err)
+	}
+	for _, t := range transfers {
+		log.Printf("[CARD-TRANSFER] Card %d of team %d: user %d → user %d by user %d (balance %s %s, %s)",
+			t.CardID, teamID, t.FromUserID, t.ToUserID, actorID, t.Balance.StringFixed(2), t.Currency, balanceMode)
+	}
+	return transfers, nil
+}
+
+// cardsReassignedNotification — уведомление новому владельцу о переданных ему картах.
+func cardsReassignedNotification(transfers []domain.CardOwnershipTransfer) domain.UserNotification {
+	data := map[string]string{}
+	var lines []string
+	for _, tr := range transfers {
+		balance := tr.Balance
+		if tr.TransactionID != nil {
+			balance = decimal.Zero // Остаток возвращён в Кошелёк команды
+			data["reclaimed"] = "1"
+		}
+		lines = append(lines, fmt.Sprintf("•••• %s — %s %s", tr.Last4Digits, balance.StringFixed(2), tr.Currency))
+	}
+	data["cards"] = strings.Join(lines, "\n")
+	return domain.UserNotification{
+		UserID:   transfers[0].ToUserID,
+		Template: domain.NotifyTeamCardsReassigned,
+		Data:     data,
+	}
+}
+
+// reclaimCardBalanceToTeamWallet переносит остаток карты в Кошелёк команды (в валюте карты)
+// и возвращает id транзакции TEAM_CARD_RECLAIM, записанной на прежнего владельца.
+func reclaimCardBalanceToTeamWallet(tx *sql.Tx, teamID, fromUserID, cardID int, last4 string, amount decimal.Decimal, currency string) (int, error) {
+	details := fmt.Sprintf("Остаток %s %s с карты •••• %s возвращён в Кошелёк команды #%d при передаче карты",
+		amount.StringFixed(2), currency, last4, teamID)
+	var txID int
+	err := tx.QueryRow(
+		`INSERT INTO transactions (user_id, card_id, amount, fee, transaction_type, status, details, currency, wallet_currency, executed_at)
+		 VALUES ($1, $2, $3, 0, 'TEAM_CARD_RECLAIM', 'APPROVED', $4, $5, $5, $6) RETURNING id`,
+		fromUserID, cardID, amount, details, currency, time.Now(),
+	).Scan(&txID)
+	if err != nil {
+		return 0, fmt.Errorf("не удалось записать транзакцию: %v", err)
+	}
+	if _, err := lockTeamWalletBalance(tx, teamID, currency); err != nil {
+		return 0, fmt.Errorf("не удалось заблокировать Кошелёк команды: %v", err)
+	}
+	_, err = ledger.Post(tx, ledger.Entry{
+		Type:          "TEAM_CARD_RECLAIM",
+		TransactionID: txID,
+		Description:   details,
+		Lines:         ledger.Move(ledger.Card(cardID), ledger.TeamWallet(teamID), amount, currency),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("не удалось вернуть остаток карты: %v", err)
+	}
+	if err := insertTeamWalletOperation(tx, teamID, fromUserID, &cardID, domain.TeamWalletCardReclaim, amount, currency, txID); err != nil {
+		return 0, err
+	}
+	return txID, nil
+}
+
+// GetMemberTeamCardIDs — незакрытые карты команды, которыми владеет участник.
+func GetMemberTeamCardIDs(teamID, userID int) ([]int, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	rows, err := GlobalDB.Query(
+		`SELECT id FROM cards WHERE team_id = $1 AND user_id = $2 AND card_status <> 'CLOSED' ORDER BY id`,
+		teamID, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ListCardOwnershipTransfers — журнал передач карт команды, новые сверху.
+func ListCardOwnershipTransfers(teamID, limit int) ([]domain.CardOwnershipTransfer, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	rows, err := GlobalDB.Query(`
+		SELECT t.id, t.card_id, COALESCE(c.last_4_digits, ''), t.team_id, t.from_user_id, t.to_user_id, t.performed_by,
+		       t.balance, t.currency, t.balance_mode, t.transaction_id, t.reason, t.created_at
+		FROM card_ownership_transfers t
+		LEFT JOIN cards c ON c.id = t.card_id
+		WHERE t.team_id = $1
+		ORDER BY t.id DESC LIMIT $2`, teamID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []domain.CardOwnershipTransfer{}
+	for rows.Next() {
+		var t domain.CardOwnershipTransfer
+		var txID sql.NullInt64
+		if err := rows.Scan(&t.ID, &t.CardID, &t.Last4Digits, &t.TeamID, &t.FromUserID, &t.ToUserID, &t.PerformedBy,
+			&t.Balance, &t.Currency, &t.BalanceMode, &txID, &t.Reason, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		if txID.Valid {
+			v := int(txID.Int64)
+			t.TransactionID = &v
+		}
+		list = append(list, t)
+	}
+	return list, rows.Err()
+}
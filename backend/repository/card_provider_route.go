@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/djalben/xplr-core/backend/domain"
+)
+
+// EnsureCardProviderRouting creates card_provider_routes and the cards.provider column.
+// Existing cards are attributed to 'wallester' if they have an external_id, otherwise to 'mock'.
+func EnsureCardProviderRouting() error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	_, err := GlobalDB.Exec(`
+		CREATE TABLE IF NOT EXISTS card_provider_routes (
+			id         SERIAL PRIMARY KEY,
+			category   TEXT NOT NULL DEFAULT '*',
+			currency   TEXT NOT NULL DEFAULT '*',
+			card_type  TEXT NOT NULL DEFAULT '*',
+			provider   TEXT NOT NULL,
+			priority   INTEGER NOT NULL DEFAULT 0,
+			is_active  BOOLEAN NOT NULL DEFAULT TRUE,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		ALTER TABLE IF EXISTS card_provider_routes DISABLE ROW LEVEL SECURITY;
+
+		ALTER TABLE cards ADD COLUMN IF NOT EXISTS provider TEXT;
+		UPDATE cards SET provider = CASE WHEN COALESCE(external_id, '') <> '' THEN 'wallester' ELSE 'mock' END
+		WHERE provider IS NULL;
+	`)
+	if err != nil {
+		log.Printf("[PROVIDER-ROUTING] Error ensuring provider routing: %v", err)
+		return err
+	}
+	log.Println("[PROVIDER-ROUTING] ✅ card_provider_routes table and cards.provider column ensured")
+	return nil
+}
+
+// normalizeRouteValue приводит значение правила к виду, в котором оно хранится ('*' — любое).
+func normalizeRouteValue(v string, upper bool) string {
+	v = strings.TrimSpace(v)
+	if v == "" || v == "*" {
+		return "*"
+	}
+	if upper {
+		return strings.ToUpper(v)
+	}
+	return strings.ToLower(v)
+}
+
+// ListCardProviderRoutes returns routing rules; activeOnly skips disabled ones.
+func ListCardProviderRoutes(activeOnly bool) ([]domain.CardProviderRoute, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	rows, err := GlobalDB.Query(`
+		SELECT id, category, currency, card_type, provider, priority, is_active, created_at, updated_at
+		FROM card_provider_routes
+		WHERE NOT $1 OR is_active
+		ORDER BY priority DESC, id`, activeOnly)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list provider routes: %w", err)
+	}
+	defer rows.Close()
+
+	routes := []domain.CardProviderRoute{}
+	for rows.Next() {
+		var rt domain.CardProviderRoute
+		if err := rows.Scan(&rt.ID, &rt.Category, &rt.Currency, &rt.CardType, &rt.Provider,
+			&rt.Priority, &rt.IsActive, &rt.CreatedAt, &rt.UpdatedAt); err != nil {
+			return nil, err
+		}
+		routes = append(routes, rt)
+	}
+	return routes, rows.Err()
+}
+
+// SaveCardProviderRoute inserts (ID = 0) or updates a routing rule and fills in ID and timestamps.
+func SaveCardProviderRoute(rt *domain.CardProviderRoute) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	rt.Category = normalizeRouteValue(rt.Category, false)
+	rt.Currency = normalizeRouteValue(rt.Currency, true)
+	if ct := normalizeRouteValue(rt.CardType, true); ct == "MASTERCARD" {
+		rt.CardType = "MasterCard"
+	} else {
+		rt.CardType = ct
+	}
+	rt.Provider = strings.ToLower(strings.TrimSpace(rt.Provider))
+
+	if rt.ID == 0 {
+		return GlobalDB.QueryRow(`
+			INSERT INTO card_provider_routes (category, currency, card_type, provider, priority, is_active)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id, created_at, updated_at`,
+			rt.Category, rt.Currency, rt.CardType, rt.Provider, rt.Priority, rt.IsActive,
+		).Scan(&rt.ID, &rt.CreatedAt, &rt.UpdatedAt)
+	}
+
+	err := GlobalDB.QueryRow(`
+		UPDATE card_provider_routes
+		SET category = $2, currency = $3, card_type = $4, provider = $5, priority = $6, is_active = $7, updated_at = NOW()
+		WHERE id = $1
+		RETURNING created_at, updated_at`,
+		rt.ID, rt.Category, rt.Currency, rt.CardType, rt.Provider, rt.Priority, rt.IsActive,
+	).Scan(&rt.CreatedAt, &rt.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("route not found")
+	}
+	return err
+}
+
+// GetCardProviderRoute returns a single routing rule or nil if it does not exist.
+func GetCardProviderRoute(id int) (*domain.CardProviderRoute, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	var rt domain.CardProviderRoute
+	err := GlobalDB.QueryRow(`
+		SELECT id, category, currency, card_type, provider, priority, is_active, created_at, updated_at
+		FROM card_provider_routes WHERE id = $1`, id,
+	).Scan(&rt.ID, &rt.Category, &rt.Currency, &rt.CardType, &rt.Provider,
+		&rt.Priority, &rt.IsActive, &rt.CreatedAt, &rt.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rt, nil
+}
+
+// DeleteCardProviderRoute removes a routing rule.
+func DeleteCardProviderRoute(id int) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	res, err := GlobalDB.Exec(`DELETE FROM card_provider_routes WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("route not found")
+	}
+	return nil
+}
+
+// GetCardProviderName returns the issuer that owns the card (empty if not set).
+func GetCardProviderName(cardID int) (string, error) {
+	if GlobalDB == nil {
+		return "", fmt.Errorf("database connection not initialized")
+	}
+	var provider string
+	err := GlobalDB.QueryRow(`SELECT COALESCE(provider, '') FROM cards WHERE id = $1`, cardID).Scan(&provider)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("card not found")
+	}
+	return provider, err
+}
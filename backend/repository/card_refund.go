@@ -0,0 +1,207 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/ledger"
+	"github.com/shopspring/decimal"
+)
+
+var (
+	// ErrNothingToRefund — по исходному списанию уже возвращена вся сумма.
+	ErrNothingToRefund = errors.New("original transaction is already fully refunded")
+	// ErrRefundAlreadyRecorded — возврат с этим provider_tx_id уже зачислен.
+	ErrRefundAlreadyRecorded = errors.New("refund is already recorded")
+)
+
+// refundRefPredicate — строки возвратов, уникальные по (provider_tx_id, transaction_type):
+// предикат индекса idx_transactions_refund_ref и ON CONFLICT в CreditCardRefund.
+const refundRefPredicate = `transaction_type IN ('REFUND', 'CHARGEBACK', 'REFUND_CARD', 'CHARGEBACK_CARD') AND provider_tx_id IS NOT NULL`
+
+// Виды возврата по карте (CardRefund.Kind). Возврат на баланс карты записывается с суффиксом _CARD.
+const (
+	RefundKindMerchant   = "REFUND"
+	RefundKindChargeback = "CHARGEBACK"
+)
+
+// CardRefund — возврат по списанию с карты: от мерчанта (REFUND) или по chargeback (CHARGEBACK).
+type CardRefund struct {
+	UserID               int
+	CardID               int
+	Kind                 string
+	Amount               decimal.Decimal // 0 — весь невозвращённый остаток исходного списания
+	ProviderTxID         string          // ID возврата у эмитента; пустой — генерируется xplr-<kind>-…
+	OriginalProviderTxID string          // provider_tx_id исходного списания
+	OriginalTxID         int             // id исходного списания, если он известен (спор)
+	Details              string
+	// Notification ставится в очередь в транзакции возврата; nil — без уведомления
+	Notification *domain.UserNotification
+}
+
+// RefundResult — что сделал CreditCardRefund.
+type RefundResult struct {
+	TransactionID int
+	OriginalTxID  int // 0 — исходное списание не найдено, средства вернулись в Кошелёк
+	Amount        decimal.Decimal
+	ToCard        bool // возврат на баланс карты (исходное списание было с карты), иначе — в Кошелёк
+	DisputeID     int  // открытый спор, закрытый этим возвратом
+}
+
+// CreditCardRefund credits a card refund or chargeback back in one DB transaction.
+// The original charge is located by OriginalTxID or OriginalProviderTxID and locked; the refund
+// is capped at what has not been refunded yet. Wallet-funded captures are credited to the wallet
+// and reduce spent_from_wallet; purchases paid from the card balance are credited to the card.
+// An open dispute on the original charge is marked WON. Without a known original the amount is
+// credited to the wallet, as unlinked refunds always were. r.Notification is enqueued in the
+// same transaction.
+func CreditCardRefund(r CardRefund) (*RefundResult, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	if r.Kind != RefundKindMerchant && r.Kind != RefundKindChargeback {
+		return nil, fmt.Errorf("unknown refund kind %q", r.Kind)
+	}
+	tx, err := GlobalDB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res := &RefundResult{Amount: r.Amount}
+	var origAmount decimal.Decimal
+	var origType, merchantName string
+	var origCardID sql.NullInt64
+	origQuery := `SELECT id, amount, transaction_type, card_id, COALESCE(merchant_name, '') FROM transactions `
+	switch {
+	case r.OriginalTxID > 0:
+		err = tx.QueryRow(origQuery+`WHERE id = $1 AND user_id = $2 FOR UPDATE`, r.OriginalTxID, r.UserID).
+			Scan(&res.OriginalTxID, &origAmount, &origType, &origCardID, &merchantName)
+	case r.OriginalProviderTxID != "":
+		err = tx.QueryRow(origQuery+`WHERE provider_tx_id = $1 AND user_id = $2
+			AND transaction_type IN ('CAPTURE', 'STORE_PURCHASE') AND status = 'APPROVED'
+			ORDER BY id LIMIT 1 FOR UPDATE`, r.OriginalProviderTxID, r.UserID).
+			Scan(&res.OriginalTxID, &origAmount, &origType, &origCardID, &merchantName)
+	default:
+		err = sql.ErrNoRows
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to find original transaction: %w", err)
+	}
+	if r.OriginalTxID > 0 && res.OriginalTxID == 0 {
+		return nil, fmt.Errorf("original transaction %d not found", r.OriginalTxID)
+	}
+
+	cardID := r.CardID
+	if res.OriginalTxID > 0 {
+		var refunded decimal.Decimal
+		err = tx.QueryRow(
+			`SELECT COALESCE(SUM(amount), 0) FROM transactions WHERE original_tx_id = $1 AND status = 'APPROVED'`,
+			res.OriginalTxID,
+		).Scan(&refunded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sum previous refunds: %w", err)
+		}
+		remaining := origAmount.Sub(refunded)
+		if remaining.LessThanOrEqual(decimal.Zero) {
+			return nil, fmt.Errorf("%w: transaction %d", ErrNothingToRefund, res.OriginalTxID)
+		}
+		if res.Amount.LessThanOrEqual(decimal.Zero) || res.Amount.GreaterThan(remaining) {
+			if res.Amount.GreaterThan(remaining) {
+				log.Printf("⚠️  Refund %s for tx %d capped at remaining %s", res.Amount.String(), res.OriginalTxID, remaining.String())
+			}
+			res.Amount = remaining
+		}
+		res.ToCard = origType == "STORE_PURCHASE"
+		if origCardID.Valid {
+			cardID = int(origCardID.Int64)
+		}
+	}
+	if res.Amount.LessThanOrEqual(decimal.Zero) {
+		return nil, fmt.Errorf("refund amount must be positive")
+	}
+
+	txType := r.Kind
+	if res.ToCard {
+		txType = r.Kind + "_CARD"
+	}
+	var cardRef, origRef interface{}
+	if cardID > 0 {
+		cardRef = cardID
+	}
+	if res.OriginalTxID > 0 {
+		origRef = res.OriginalTxID
+	}
+	// Без ссылки сверка не отнесла бы возврат к Кошельку (см. usecase.walletEffect)
+	if r.ProviderTxID == "" {
+		r.ProviderTxID = fmt.Sprintf("xplr-%s-%d", strings.ToLower(r.Kind), time.Now().UnixNano())
+	}
+	// Повтор того же возврата (параллельный вебхук) упирается в уникальный индекс и ждёт первую транзакцию
+	err = tx.QueryRow(
+		`INSERT INTO transactions (user_id, card_id, amount, fee, transaction_type, status, details, provider_tx_id, merchant_name, original_tx_id, executed_at)
+		 VALUES ($1, $2, $3, 0, $4, 'APPROVED', $5, $6, NULLIF($7, ''), $8, $9)
+		 ON CONFLICT (provider_tx_id, transaction_type) WHERE `+refundRefPredicate+` DO NOTHING
+		 RETURNING id`,
+		r.UserID, cardRef, res.Amount, txType, r.Details, r.ProviderTxID, merchantName, origRef, time.Now(),
+	).Scan(&res.TransactionID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %s %s", ErrRefundAlreadyRecorded, txType, r.ProviderTxID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to record %s transaction: %w", txType, err)
+	}
+
+	// Расчёты с эмитентом → туда, откуда было списание
+	to := ledger.UserWallet(r.UserID)
+	if res.ToCard {
+		to = ledger.Card(cardID)
+	}
+	_, err = ledger.Post(tx, ledger.Entry{
+		Type:          txType,
+		Reference:     r.ProviderTxID,
+		TransactionID: res.TransactionID,
+		Description:   r.Details,
+		Lines:         ledger.Move(ledger.SupplierPayable, to, res.Amount, WalletCurrency),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to credit %s: %w", txType, err)
+	}
+
+	if !res.ToCard && cardID > 0 {
+		_, err = tx.Exec(
+			`UPDATE cards SET spent_from_wallet = GREATEST(COALESCE(spent_from_wallet, 0) - $1, 0) WHERE id = $2`,
+			res.Amount, cardID,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update card spent_from_wallet: %w", err)
+		}
+	}
+
+	if res.OriginalTxID > 0 {
+		err = tx.QueryRow(
+			`UPDATE card_disputes SET status = 'WON', refund_tx_id = $2, resolved_at = NOW(), updated_at = NOW()
+			 WHERE transaction_id = $1 AND status IN ('OPEN', 'IN_REVIEW') RETURNING id`,
+			res.OriginalTxID, res.TransactionID,
+		).Scan(&res.DisputeID)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, fmt.Errorf("failed to close dispute: %w", err)
+		}
+	}
+	if r.Notification != nil {
+		if err := EnqueueNotification(tx, *r.Notification); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit %s: %w", txType, err)
+	}
+	log.Printf("✅ %s: %s credited (user %d, card %d, to card: %v, original tx %d, ref=%s)",
+		txType, res.Amount.String(), r.UserID, cardID, res.ToCard, res.OriginalTxID, r.ProviderTxID)
+	return res, nil
+}
@@ -9,9 +9,6 @@ import (
 	"github.com/shopspring/decimal"
 )
 
-// fallbackRubUsdRate is the hardcoded RUB→USD rate used when the DB rate is unavailable.
-var fallbackRubUsdRate = decimal.NewFromFloat(89.45)
-
 // DashboardStatsResult holds all data for the user dashboard.
 type DashboardStatsResult struct {
 	TodayTotal         string              `json:"today_total"`
@@ -48,6 +45,14 @@ func txTypeLabel(txType string) string {
 		return "Выпуск карты"
 	case "CARD_TOPUP":
 		return "Перевод на карту"
+	case "TEAM_WALLET_TOPUP":
+		return "В Кошелёк команды"
+	case "TEAM_CARD_TOPUP":
+		return "Перевод на карту (команда)"
+	case "TEAM_CARD_ISSUE_FEE":
+		return "Выпуск карты (команда)"
+	case "TEAM_CARD_RECLAIM":
+		return "Возврат в Кошелёк команды"
 	case "CARD_REFUND":
 		return "Возврат"
 	case "WALLET_RECLAIM":
@@ -76,7 +81,7 @@ func GetDashboardStats(userID int) (*DashboardStatsResult, error) {
 	result := &DashboardStatsResult{}
 
 	// Expense-only transaction types (excludes top-ups, refunds, bonuses)
-	expenseFilter := `AND transaction_type NOT IN ('WALLET_TOPUP', 'CARD_REFUND', 'WALLET_RECLAIM', 'REFERRAL_BONUS')`
+	expenseFilter := `AND transaction_type NOT IN ('WALLET_TOPUP', 'CARD_REFUND', 'WALLET_RECLAIM', 'TEAM_CARD_RECLAIM', 'REFERRAL_BONUS')`
 	successFilter := `AND status IN ('SUCCESS', 'COMPLETED', 'CAPTURED', 'CAPTURE', 'APPROVED')`
 
 	// ── 1a. Today count (all transactions, informational) ──
@@ -113,11 +118,13 @@ func GetDashboardStats(userID int) (*DashboardStatsResult, error) {
 		  AND UPPER(COALESCE(currency, 'USD')) = 'RUB'
 	`, userID).Scan(&todayRubSum)
 	if err == nil && todayRubSum.GreaterThan(decimal.Zero) {
-		rate, rateErr := GetFinalRate("RUB", "USD")
-		if rateErr != nil || !rate.GreaterThan(decimal.Zero) {
-			rate = fallbackRubUsdRate
+		// Без курса рублёвые расходы не попадают в сумму в USD (лучше недосчитать, чем выдумать курс)
+		rubToUsd := decimal.Zero
+		if rate, rateErr := CrossRate("RUB", "USD"); rateErr == nil {
+			rubToUsd = todayRubSum.Mul(rate).Round(2)
+		} else {
+			log.Printf("[DASHBOARD-STATS] RUB/USD rate unavailable, RUB expenses excluded from today total: %v", rateErr)
 		}
-		rubToUsd := todayRubSum.Div(rate).Round(2)
 		todaySum = todaySum.Sub(todayRubSum).Add(rubToUsd)
 	}
 	result.TodayTotal = todaySum.StringFixed(2)
@@ -185,7 +192,7 @@ func GetDashboardStats(userID int) (*DashboardStatsResult, error) {
 		WHERE user_id = $1
 		  AND executed_at >= $2::date
 		  AND status IN ('SUCCESS', 'COMPLETED', 'CAPTURED', 'CAPTURE', 'APPROVED')
-		  AND transaction_type NOT IN ('WALLET_TOPUP', 'CARD_REFUND', 'WALLET_RECLAIM', 'REFERRAL_BONUS')
+		  AND transaction_type NOT IN ('WALLET_TOPUP', 'CARD_REFUND', 'WALLET_RECLAIM', 'TEAM_CARD_RECLAIM', 'REFERRAL_BONUS')
 		GROUP BY day, curr
 		ORDER BY day
 	`, userID, weekStart)
@@ -193,10 +200,10 @@ func GetDashboardStats(userID int) (*DashboardStatsResult, error) {
 		log.Printf("[DASHBOARD-STATS] Error fetching weekly chart for user %d: %v", userID, err)
 	} else {
 		defer weekRows.Close()
-		// Preload RUB→USD rate once (fallback to 89.45)
-		rubRate, rateErr := GetFinalRate("RUB", "USD")
-		if rateErr != nil || !rubRate.GreaterThan(decimal.Zero) {
-			rubRate = fallbackRubUsdRate
+		// Preload RUB→USD rate once (USD per 1 RUB); without it RUB expenses are left out of the chart
+		rubRate, rateErr := CrossRate("RUB", "USD")
+		if rateErr != nil {
+			log.Printf("[DASHBOARD-STATS] RUB/USD rate unavailable, RUB expenses excluded from weekly chart: %v", rateErr)
 		}
 
 		for weekRows.Next() {
@@ -209,8 +216,8 @@ func GetDashboardStats(userID int) (*DashboardStatsResult, error) {
 			dateStr := dayDate.Format("2006-01-02")
 			// Convert to USD if RUB
 			amtUsd := total
-			if curr == "RUB" && rubRate.GreaterThan(decimal.Zero) {
-				amtUsd = total.Div(rubRate).Round(2)
+			if curr == "RUB" {
+				amtUsd = total.Mul(rubRate).Round(2)
 			}
 			for j := range days {
 				if days[j].date == dateStr {
@@ -0,0 +1,375 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/shopspring/decimal"
+)
+
+// DisputeWindowDays — сколько дней после списания его можно оспорить.
+const DisputeWindowDays = 120
+
+var (
+	ErrDisputeExists   = errors.New("an open dispute already exists for this transaction")
+	ErrNotDisputable   = errors.New("transaction cannot be disputed")
+	ErrDisputeNotFound = errors.New("dispute not found")
+)
+
+// EnsureDisputeTables creates card_disputes and transactions.original_tx_id, which links
+// refunds and chargebacks to the charge they return.
+func EnsureDisputeTables() error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	_, err := GlobalDB.Exec(`
+		ALTER TABLE transactions ADD COLUMN IF NOT EXISTS original_tx_id INTEGER;
+		CREATE INDEX IF NOT EXISTS idx_transactions_original_tx ON transactions(original_tx_id) WHERE original_tx_id IS NOT NULL;
+
+		CREATE TABLE IF NOT EXISTS card_disputes (
+			id             SERIAL PRIMARY KEY,
+			user_id        INTEGER NOT NULL,
+			card_id        INTEGER,
+			transaction_id INTEGER NOT NULL,
+			amount         NUMERIC(20,4) NOT NULL,
+			reason         TEXT NOT NULL,
+			description    TEXT NOT NULL DEFAULT '',
+			status         TEXT NOT NULL DEFAULT 'OPEN',
+			admin_comment  TEXT NOT NULL DEFAULT '',
+			resolved_by    INTEGER,
+			refund_tx_id   INTEGER,
+			created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			updated_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			resolved_at    TIMESTAMPTZ
+		);
+		CREATE INDEX IF NOT EXISTS idx_card_disputes_user ON card_disputes(user_id, created_at DESC);
+		CREATE INDEX IF NOT EXISTS idx_card_disputes_status ON card_disputes(status, created_at);
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_card_disputes_open_tx ON card_disputes(transaction_id)
+			WHERE status IN ('OPEN', 'IN_REVIEW');
+		ALTER TABLE IF EXISTS card_disputes DISABLE ROW LEVEL SECURITY;
+	`)
+	if err != nil {
+		log.Printf("[DISPUTES] Error ensuring dispute tables: %v", err)
+		return err
+	}
+	// Один возврат эмитента — одна запись: повтор вебхука (в том числе параллельный) не зачислит его дважды
+	_, err = GlobalDB.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_transactions_refund_ref ON transactions(provider_tx_id, transaction_type)
+			WHERE ` + refundRefPredicate)
+	if err != nil {
+		log.Printf("[DISPUTES] Error creating refund reference index (duplicate refunds in transactions?): %v", err)
+		return err
+	}
+	log.Println("[DISPUTES] ✅ card_disputes table ensured")
+	return nil
+}
+
+const disputeSelect = `
+	SELECT d.id, d.user_id, COALESCE(u.email, ''), d.card_id, COALESCE(c.last_4_digits, ''),
+	       d.transaction_id, COALESCE(t.provider_tx_id, ''), COALESCE(t.merchant_name, ''),
+	       d.amount, d.reason, d.description, d.status, d.admin_comment, d.resolved_by, d.refund_tx_id,
+	       d.created_at, d.updated_at, d.resolved_at
+	FROM card_disputes d
+	LEFT JOIN users u ON u.id = d.user_id
+	LEFT JOIN cards c ON c.id = d.card_id
+	LEFT JOIN transactions t ON t.id = d.transaction_id`
+
+func scanDispute(row interface{ Scan(...interface{}) error }) (*domain.Dispute, error) {
+	var d domain.Dispute
+	var cardID, resolvedBy, refundTxID sql.NullInt64
+	var resolvedAt sql.NullTime
+	err := row.Scan(&d.ID, &d.UserID, &d.UserEmail, &cardID, &d.CardLast4,
+		&d.TransactionID, &d.ProviderTxID, &d.MerchantName,
+		&d.Amount, &d.Reason, &d.Description, &d.Status, &d.AdminComment, &resolvedBy, &refundTxID,
+		&d.CreatedAt, &d.UpdatedAt, &resolvedAt)
+	if err != nil {
+		return nil, err
+	}
+	if cardID.Valid {
+		id := int(cardID.Int64)
+		d.CardID = &id
+	}
+	if resolvedBy.Valid {
+		id := int(resolvedBy.Int64)
+		d.ResolvedBy = &id
+	}
+	if refundTxID.Valid {
+		id := int(refundTxID.Int64)
+		d.RefundTxID = &id
+	}
+	if resolvedAt.Valid {
+		d.ResolvedAt = &resolvedAt.Time
+	}
+	return &d, nil
+}
+
+func queryDisputes(query string, args ...interface{}) ([]domain.Dispute, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	rows, err := GlobalDB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query disputes: %w", err)
+	}
+	defer rows.Close()
+
+	disputes := []domain.Dispute{}
+	for rows.Next() {
+		d, err := scanDispute(rows)
+		if err != nil {
+			return nil, err
+		}
+		disputes = append(disputes, *d)
+	}
+	return disputes, rows.Err()
+}
+
+// CreateDispute opens a dispute on a card charge of userID. The charge must be an approved
+// CAPTURE or STORE_PURCHASE not older than DisputeWindowDays, with an unrefunded remainder and
+// no other open dispute. A zero amount disputes the whole remainder.
+func CreateDispute(userID, transactionID int, amount decimal.Decimal, reason, description string) (*domain.Dispute, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	tx, err := GlobalDB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var txType, status string
+	var txAmount decimal.Decimal
+	var cardID sql.NullInt64
+	var tooOld bool
+	err = tx.QueryRow(`
+		SELECT transaction_type, status, amount, card_id, executed_at < NOW() - make_interval(days => $3)
+		FROM transactions WHERE id = $1 AND user_id = $2 FOR UPDATE`,
+		transactionID, userID, DisputeWindowDays,
+	).Scan(&txType, &status, &txAmount, &cardID, &tooOld)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: transaction not found", ErrNotDisputable)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load transaction: %w", err)
+	}
+	if (txType != "CAPTURE" && txType != "STORE_PURCHASE") || status != "APPROVED" {
+		return nil, fmt.Errorf("%w: only approved card charges can be disputed", ErrNotDisputable)
+	}
+	if tooOld {
+		return nil, fmt.Errorf("%w: dispute window of %d days has passed", ErrNotDisputable, DisputeWindowDays)
+	}
+
+	var open bool
+	if err := tx.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM card_disputes WHERE transaction_id = $1 AND status IN ('OPEN', 'IN_REVIEW'))`,
+		transactionID,
+	).Scan(&open); err != nil {
+		return nil, fmt.Errorf("failed to check disputes: %w", err)
+	}
+	if open {
+		return nil, ErrDisputeExists
+	}
+
+	var refunded decimal.Decimal
+	if err := tx.QueryRow(
+		`SELECT COALESCE(SUM(amount), 0) FROM transactions WHERE original_tx_id = $1 AND status = 'APPROVED'`,
+		transactionID,
+	).Scan(&refunded); err != nil {
+		return nil, fmt.Errorf("failed to sum refunds: %w", err)
+	}
+	remaining := txAmount.Sub(refunded)
+	if remaining.LessThanOrEqual(decimal.Zero) {
+		return nil, fmt.Errorf("%w: transaction is already fully refunded", ErrNotDisputable)
+	}
+	if amount.LessThanOrEqual(decimal.Zero) {
+		amount = remaining
+	}
+	if amount.GreaterThan(remaining) {
+		return nil, fmt.Errorf("%w: amount exceeds the refundable $%s", ErrNotDisputable, remaining.StringFixed(2))
+	}
+
+	var cardRef interface{}
+	if cardID.Valid {
+		cardRef = cardID.Int64
+	}
+	var id int
+	err = tx.QueryRow(
+		`INSERT INTO card_disputes (user_id, card_id, transaction_id, amount, reason, description)
+		 VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		userID, cardRef, transactionID, amount, reason, description,
+	).Scan(&id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dispute: %w", err)
+	}
+	d, err := scanDispute(tx.QueryRow(disputeSelect+` WHERE d.id = $1`, id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load dispute: %w", err)
+	}
+	err = EnqueueNotification(tx, domain.UserNotification{
+		UserID:   userID,
+		Template: domain.NotifyDisputeOpened,
+		Data: map[string]string{
+			"dispute_id": strconv.Itoa(d.ID),
+			"merchant":   d.MerchantName,
+			"amount":     d.Amount.StringFixed(2),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit dispute: %w", err)
+	}
+	log.Printf("[DISPUTES] Dispute #%d opened by user %d on tx %d ($%s, %s)", id, userID, transactionID, amount.StringFixed(2), reason)
+	return d, nil
+}
+
+// GetDispute returns a dispute by id.
+func GetDispute(id int) (*domain.Dispute, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	d, err := scanDispute(GlobalDB.QueryRow(disputeSelect+` WHERE d.id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrDisputeNotFound
+	}
+	return d, err
+}
+
+// ListUserDisputes returns the user's disputes, newest first.
+func ListUserDisputes(userID int) ([]domain.Dispute, error) {
+	return queryDisputes(disputeSelect+` WHERE d.user_id = $1 ORDER BY d.created_at DESC`, userID)
+}
+
+// ListDisputes returns disputes for the admin panel, oldest unresolved first; status filters by status.
+func ListDisputes(status string) ([]domain.Dispute, error) {
+	if status != "" {
+		return queryDisputes(disputeSelect+` WHERE d.status = $1 ORDER BY d.created_at`, status)
+	}
+	return queryDisputes(disputeSelect + ` ORDER BY (d.status IN ('OPEN', 'IN_REVIEW')) DESC, d.created_at DESC LIMIT 500`)
+}
+
+// UpdateDisputeStatus moves an open dispute to status and records the admin comment.
+// WON and LOST are final and store resolved_by/resolved_at. A dispute already closed as WON
+// by a refund may be annotated again with status WON (the admin's own resolution).
+// The user's notification about the decision is enqueued in the same transaction.
+func UpdateDisputeStatus(id int, status, comment string, adminID int) (*domain.Dispute, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	tx, err := GlobalDB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	d, err := updateDisputeStatus(tx, id, status, comment, adminID)
+	if err != nil {
+		return nil, err
+	}
+	if n := disputeStatusNotification(d); n != nil {
+		if err := EnqueueNotification(tx, *n); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit dispute: %w", err)
+	}
+	return d, nil
+}
+
+func updateDisputeStatus(tx *sql.Tx, id int, status, comment string, adminID int) (*domain.Dispute, error) {
+	final := status == domain.DisputeWon || status == domain.DisputeLost || status == domain.DisputeCancelled
+	var resolvedBy interface{}
+	if final && adminID > 0 {
+		resolvedBy = adminID
+	}
+	res, err := tx.Exec(`
+		UPDATE card_disputes SET
+			status        = $2,
+			admin_comment = CASE WHEN $3 = '' THEN admin_comment ELSE $3 END,
+			resolved_by   = COALESCE($4, resolved_by),
+			resolved_at   = CASE WHEN $5 THEN COALESCE(resolved_at, NOW()) ELSE resolved_at END,
+			updated_at    = NOW()
+		WHERE id = $1 AND (status IN ('OPEN', 'IN_REVIEW') OR (status = 'WON' AND $2 = 'WON'))`,
+		id, status, comment, resolvedBy, final)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update dispute: %w", err)
+	}
+	d, err := scanDispute(tx.QueryRow(disputeSelect+` WHERE d.id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrDisputeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, fmt.Errorf("dispute %d is already resolved", id)
+	}
+	return d, nil
+}
+
+// disputeStatusNotification — уведомление пользователю о решении по спору; nil для OPEN и CANCELLED.
+func disputeStatusNotification(d *domain.Dispute) *domain.UserNotification {
+	data := map[string]string{"dispute_id": strconv.Itoa(d.ID), "comment": d.AdminComment}
+	var templateID string
+	switch d.Status {
+	case domain.DisputeInReview:
+		templateID = domain.NotifyDisputeInReview
+	case domain.DisputeWon:
+		templateID = domain.NotifyDisputeWon
+		if d.RefundTxID != nil {
+			data["refunded"] = d.Amount.StringFixed(2)
+		}
+	case domain.DisputeLost:
+		templateID = domain.NotifyDisputeLost
+	default:
+		return nil
+	}
+	return &domain.UserNotification{UserID: d.UserID, Template: templateID, Data: data}
+}
+
+// SetDisputeStatusByCharge moves the open dispute on the charge with provider_tx_id
+// originalProviderTxID (issuer chargeback updates). Returns the dispute or nil if there is none.
+// n — the chargeback notification — is enqueued in the same transaction either way.
+func SetDisputeStatusByCharge(userID int, originalProviderTxID, status string, n *domain.UserNotification) (*domain.Dispute, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	tx, err := GlobalDB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var d *domain.Dispute
+	var id int
+	err = tx.QueryRow(`
+		SELECT d.id FROM card_disputes d JOIN transactions t ON t.id = d.transaction_id
+		WHERE d.user_id = $1 AND t.provider_tx_id = $2 AND d.status IN ('OPEN', 'IN_REVIEW')
+		FOR UPDATE OF d`,
+		userID, originalProviderTxID,
+	).Scan(&id)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return nil, fmt.Errorf("failed to find dispute: %w", err)
+	default:
+		if d, err = updateDisputeStatus(tx, id, status, "", 0); err != nil {
+			return nil, err
+		}
+	}
+	if n != nil {
+		if err := EnqueueNotification(tx, *n); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit dispute: %w", err)
+	}
+	return d, nil
+}
@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/shopspring/decimal"
+)
+
+func TestDisputeStatusNotification(t *testing.T) {
+	refundTxID := 7
+	cases := []struct {
+		status, refunded string
+		refundTxID       *int
+		want             string
+	}{
+		{domain.DisputeOpen, "", nil, ""},
+		{domain.DisputeInReview, "", nil, domain.NotifyDisputeInReview},
+		{domain.DisputeWon, "", nil, domain.NotifyDisputeWon},
+		{domain.DisputeWon, "12.50", &refundTxID, domain.NotifyDisputeWon},
+		{domain.DisputeLost, "", nil, domain.NotifyDisputeLost},
+		{domain.DisputeCancelled, "", nil, ""},
+	}
+	for _, c := range cases {
+		d := &domain.Dispute{ID: 3, UserID: 5, Status: c.status, Amount: decimal.RequireFromString("12.5"), RefundTxID: c.refundTxID}
+		n := disputeStatusNotification(d)
+		if c.want == "" {
+			if n != nil {
+				t.Errorf("%s: уведомление %s, ожидалось без уведомления", c.status, n.Template)
+			}
+			continue
+		}
+		if n == nil || n.Template != c.want || n.UserID != 5 {
+			t.Errorf("%s: уведомление %+v, ожидался шаблон %s пользователю 5", c.status, n, c.want)
+			continue
+		}
+		if n.Data["dispute_id"] != "3" || n.Data["refunded"] != c.refunded {
+			t.Errorf("%s: данные %v", c.status, n.Data)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/domain"
+)
+
+// EnsureExchangeRateHistoryTables creates exchange_rate_history and the rate_snapshot_id
+// columns on transactions and fx_quotes. Every change of exchange_rates appends one row;
+// a rate snapshot is the id of the latest history row at the time of a conversion, so the
+// rate of any pair "as of" that snapshot is its newest history row with id <= snapshot.
+// If the history is empty the current exchange_rates are stored as a baseline.
+func EnsureExchangeRateHistoryTables() error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	_, err := GlobalDB.Exec(`
+		CREATE TABLE IF NOT EXISTS exchange_rate_history (
+			id             BIGSERIAL PRIMARY KEY,
+			currency_from  VARCHAR(10) NOT NULL,
+			currency_to    VARCHAR(10) NOT NULL,
+			source         TEXT NOT NULL DEFAULT '',
+			base_rate      NUMERIC(20,4) NOT NULL,
+			markup_percent NUMERIC(10,2) NOT NULL,
+			final_rate     NUMERIC(20,4) NOT NULL,
+			recorded_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_exchange_rate_history_pair ON exchange_rate_history(currency_from, currency_to, recorded_at);
+		ALTER TABLE IF EXISTS exchange_rate_history DISABLE ROW LEVEL SECURITY;
+
+		ALTER TABLE transactions ADD COLUMN IF NOT EXISTS rate_snapshot_id BIGINT;
+		ALTER TABLE IF EXISTS fx_quotes ADD COLUMN IF NOT EXISTS rate_snapshot_id BIGINT;
+
+		INSERT INTO exchange_rate_history (currency_from, currency_to, source, base_rate, markup_percent, final_rate, recorded_at)
+		SELECT currency_from, currency_to, 'baseline', base_rate, markup_percent, final_rate, COALESCE(updated_at, NOW())
+		FROM exchange_rates
+		WHERE NOT EXISTS (SELECT 1 FROM exchange_rate_history);
+	`)
+	if err != nil {
+		log.Printf("[EXCHANGE] Error ensuring exchange_rate_history table: %v", err)
+		return err
+	}
+	log.Println("[EXCHANGE] ✅ exchange_rate_history table ensured")
+	return nil
+}
+
+// withRateHistory wraps an `UPDATE exchange_rates ...` statement so that every updated row is
+// also appended to exchange_rate_history. sourceArg is the placeholder number of the source.
+func withRateHistory(update string, sourceArg int) string {
+	return fmt.Sprintf(`WITH u AS (
+		%s
+		RETURNING currency_from, currency_to, base_rate, markup_percent, final_rate
+	)
+	INSERT INTO exchange_rate_history (currency_from, currency_to, source, base_rate, markup_percent, final_rate)
+	SELECT currency_from, currency_to, $%d::text, base_rate, markup_percent, final_rate FROM u`, update, sourceArg)
+}
+
+// currentRateSnapshot returns the latest exchange_rate_history id (nil if the history is empty),
+// to be stored in transactions.rate_snapshot_id.
+func currentRateSnapshot(q interface {
+	QueryRow(string, ...interface{}) *sql.Row
+}) *int64 {
+	var id sql.NullInt64
+	if err := q.QueryRow(`SELECT MAX(id) FROM exchange_rate_history`).Scan(&id); err != nil || !id.Valid {
+		return nil
+	}
+	return &id.Int64
+}
+
+// snapshotArg converts a snapshot pointer into a query argument (NULL when unknown).
+func snapshotArg(id *int64) interface{} {
+	if id == nil {
+		return nil
+	}
+	return *id
+}
+
+const ratePointColumns = `id, currency_from, currency_to, source, base_rate, markup_percent, final_rate, recorded_at`
+
+func scanRatePoints(rows *sql.Rows) ([]domain.RatePoint, error) {
+	defer rows.Close()
+	points := []domain.RatePoint{}
+	for rows.Next() {
+		var p domain.RatePoint
+		if err := rows.Scan(&p.ID, &p.CurrencyFrom, &p.CurrencyTo, &p.Source, &p.BaseRate,
+			&p.MarkupPercent, &p.FinalRate, &p.RecordedAt); err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+// GetRateHistory returns the history of a pair between since and until, oldest first.
+// The last update before since is included as the first point, so the rate in effect at
+// the start of the range is known even if it did not change inside the range.
+func GetRateHistory(from, to string, since, until time.Time) ([]domain.RatePoint, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	rows, err := GlobalDB.Query(`
+		(SELECT `+ratePointColumns+` FROM exchange_rate_history
+		 WHERE currency_from = $1 AND currency_to = $2 AND recorded_at < $3
+		 ORDER BY recorded_at DESC, id DESC LIMIT 1)
+		UNION ALL
+		(SELECT `+ratePointColumns+` FROM exchange_rate_history
+		 WHERE currency_from = $1 AND currency_to = $2 AND recorded_at >= $3 AND recorded_at < $4)
+		ORDER BY recorded_at, id`,
+		from, to, since, until,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanRatePoints(rows)
+}
+
+// GetRatesAtSnapshot returns the rate of every pair as it was at the given snapshot.
+func GetRatesAtSnapshot(snapshotID int64) ([]domain.RatePoint, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	rows, err := GlobalDB.Query(`
+		SELECT DISTINCT ON (currency_from, currency_to) `+ratePointColumns+`
+		FROM exchange_rate_history
+		WHERE id <= $1
+		ORDER BY currency_from, currency_to, id DESC`,
+		snapshotID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanRatePoints(rows)
+}
+
+// ExchangeRatePairExists reports whether exchange_rates has the pair.
+func ExchangeRatePairExists(from, to string) bool {
+	if GlobalDB == nil {
+		return false
+	}
+	var exists bool
+	GlobalDB.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM exchange_rates WHERE currency_from = $1 AND currency_to = $2)`,
+		from, to,
+	).Scan(&exists)
+	return exists
+}
@@ -3,6 +3,9 @@ package repository
 import (
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/shopspring/decimal"
 )
@@ -70,18 +73,19 @@ func GetFinalRate(from, to string) (decimal.Decimal, error) {
 }
 
 // UpdateMarkupPercent updates the admin markup and recalculates final_rate.
-func UpdateMarkupPercent(id int, newMarkup decimal.Decimal) error {
+// source is recorded in exchange_rate_history (e.g. "admin:12").
+func UpdateMarkupPercent(id int, newMarkup decimal.Decimal, source string) error {
 	if GlobalDB == nil {
 		return fmt.Errorf("database connection not initialized")
 	}
 	// Recalculate: final_rate = base_rate * (1 + markup_percent / 100)
-	_, err := GlobalDB.Exec(
+	_, err := GlobalDB.Exec(withRateHistory(
 		`UPDATE exchange_rates 
 		 SET markup_percent = $1, 
 		     final_rate = base_rate * (1 + $1 / 100),
 		     updated_at = NOW()
-		 WHERE id = $2`,
-		newMarkup, id,
+		 WHERE id = $2`, 3),
+		newMarkup, id, source,
 	)
 	if err != nil {
 		log.Printf("UpdateMarkupPercent: DB error: %v", err)
@@ -92,17 +96,18 @@ func UpdateMarkupPercent(id int, newMarkup decimal.Decimal) error {
 }
 
 // UpdateBaseRate updates the base_rate and recalculates final_rate (used by rate fetcher).
-func UpdateBaseRate(from, to string, newBase decimal.Decimal) error {
+// source is recorded in exchange_rate_history (e.g. "fetcher:cbr,ecb").
+func UpdateBaseRate(from, to string, newBase decimal.Decimal, source string) error {
 	if GlobalDB == nil {
 		return fmt.Errorf("database connection not initialized")
 	}
-	_, err := GlobalDB.Exec(
+	_, err := GlobalDB.Exec(withRateHistory(
 		`UPDATE exchange_rates 
 		 SET base_rate = $1, 
 		     final_rate = $1 * (1 + markup_percent / 100),
 		     updated_at = NOW()
-		 WHERE currency_from = $2 AND currency_to = $3`,
-		newBase, from, to,
+		 WHERE currency_from = $2 AND currency_to = $3`, 4),
+		newBase, from, to, source,
 	)
 	if err != nil {
 		log.Printf("UpdateBaseRate %s/%s: DB error: %v", from, to, err)
@@ -113,17 +118,17 @@ func UpdateBaseRate(from, to string, newBase decimal.Decimal) error {
 }
 
 // UpdateBaseRateByID updates the base_rate by exchange_rate ID and recalculates final_rate.
-func UpdateBaseRateByID(id int, newBase decimal.Decimal) error {
+func UpdateBaseRateByID(id int, newBase decimal.Decimal, source string) error {
 	if GlobalDB == nil {
 		return fmt.Errorf("database connection not initialized")
 	}
-	_, err := GlobalDB.Exec(
+	_, err := GlobalDB.Exec(withRateHistory(
 		`UPDATE exchange_rates 
 		 SET base_rate = $1, 
 		     final_rate = $1 * (1 + markup_percent / 100),
 		     updated_at = NOW()
-		 WHERE id = $2`,
-		newBase, id,
+		 WHERE id = $2`, 3),
+		newBase, id, source,
 	)
 	if err != nil {
 		log.Printf("UpdateBaseRateByID id=%d: DB error: %v", id, err)
@@ -135,16 +140,16 @@ func UpdateBaseRateByID(id int, newBase decimal.Decimal) error {
 
 // UpdateFinalRateByID sets the final_rate directly (manual override).
 // This takes priority over auto-calculation from base_rate + markup.
-func UpdateFinalRateByID(id int, newFinal decimal.Decimal) error {
+func UpdateFinalRateByID(id int, newFinal decimal.Decimal, source string) error {
 	if GlobalDB == nil {
 		return fmt.Errorf("database connection not initialized")
 	}
-	_, err := GlobalDB.Exec(
+	_, err := GlobalDB.Exec(withRateHistory(
 		`UPDATE exchange_rates 
 		 SET final_rate = $1, 
 		     updated_at = NOW()
-		 WHERE id = $2`,
-		newFinal, id,
+		 WHERE id = $2`, 3),
+		newFinal, id, source,
 	)
 	if err != nil {
 		log.Printf("UpdateFinalRateByID id=%d: DB error: %v", id, err)
@@ -181,8 +186,13 @@ func SeedDefaultExchangeRates() {
 		finalRate := base.Mul(decimal.NewFromInt(1).Add(markup.Div(hundred)))
 
 		_, err := GlobalDB.Exec(
-			`INSERT INTO exchange_rates (currency_from, currency_to, base_rate, markup_percent, final_rate)
-			 VALUES ($1, $2, $3, $4, $5)`,
+			`WITH i AS (
+				INSERT INTO exchange_rates (currency_from, currency_to, base_rate, markup_percent, final_rate)
+				VALUES ($1, $2, $3, $4, $5)
+				RETURNING currency_from, currency_to, base_rate, markup_percent, final_rate
+			)
+			INSERT INTO exchange_rate_history (currency_from, currency_to, source, base_rate, markup_percent, final_rate)
+			SELECT currency_from, currency_to, 'seed', base_rate, markup_percent, final_rate FROM i`,
 			d.From, d.To, base, markup, finalRate,
 		)
 		if err != nil {
@@ -193,3 +203,70 @@ func SeedDefaultExchangeRates() {
 		}
 	}
 }
+
+// Defaults for the rate fetcher guards when system_settings has no value.
+const (
+	DefaultRateMaxDeviationPercent = 5
+	DefaultRateStaleHours          = 6
+)
+
+// EnsureExchangeRateGuardSettings seeds the system_settings used by the rate fetcher:
+// rate_max_deviation_percent (max base_rate move per hour) and rate_stale_hours (alarm threshold).
+func EnsureExchangeRateGuardSettings() error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	_, err := GlobalDB.Exec(`
+		INSERT INTO system_settings (setting_key, setting_value, description) VALUES
+			('rate_max_deviation_percent', '5', 'На сколько % в час может измениться базовый курс из внешних источников; больший скачок не применяется'),
+			('rate_stale_hours', '6', 'Через сколько часов без обновления курса администраторам отправляется предупреждение')
+		ON CONFLICT (setting_key) DO NOTHING
+	`)
+	if err != nil {
+		log.Printf("[EXCHANGE] Error seeding rate guard settings: %v", err)
+		return err
+	}
+	return nil
+}
+
+// GetRateGuardSettings reads rate_max_deviation_percent and rate_stale_hours from system_settings.
+func GetRateGuardSettings() (maxDeviationPercent decimal.Decimal, staleAfter time.Duration) {
+	maxDeviationPercent = decimal.NewFromInt(DefaultRateMaxDeviationPercent)
+	staleHours := DefaultRateStaleHours
+	if GlobalDB == nil {
+		return maxDeviationPercent, time.Duration(staleHours) * time.Hour
+	}
+	var value string
+	if err := GlobalDB.QueryRow(
+		`SELECT setting_value FROM system_settings WHERE setting_key = 'rate_max_deviation_percent'`,
+	).Scan(&value); err == nil {
+		if d, err := decimal.NewFromString(strings.TrimSpace(value)); err == nil && d.IsPositive() {
+			maxDeviationPercent = d
+		}
+	}
+	if err := GlobalDB.QueryRow(
+		`SELECT setting_value FROM system_settings WHERE setting_key = 'rate_stale_hours'`,
+	).Scan(&value); err == nil {
+		if h, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && h > 0 {
+			staleHours = h
+		}
+	}
+	return maxDeviationPercent, time.Duration(staleHours) * time.Hour
+}
+
+// GetBaseRate returns the base_rate of a currency pair and when the pair was last updated.
+func GetBaseRate(from, to string) (decimal.Decimal, time.Time, error) {
+	if GlobalDB == nil {
+		return decimal.Zero, time.Time{}, fmt.Errorf("database connection not initialized")
+	}
+	var rate decimal.Decimal
+	var updatedAt time.Time
+	err := GlobalDB.QueryRow(
+		`SELECT base_rate, COALESCE(updated_at, NOW()) FROM exchange_rates WHERE currency_from = $1 AND currency_to = $2`,
+		from, to,
+	).Scan(&rate, &updatedAt)
+	if err != nil {
+		return decimal.Zero, time.Time{}, fmt.Errorf("exchange rate %s/%s not found", from, to)
+	}
+	return rate, updatedAt, nil
+}
@@ -0,0 +1,259 @@
+package repository
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/shopspring/decimal"
+)
+
+// DefaultFXQuoteTTLSeconds — сколько секунд действует котировка, если fx_quote_ttl_seconds не задан в system_settings.
+const DefaultFXQuoteTTLSeconds = 60
+
+var (
+	// ErrFXQuoteNotFound — котировки нет или она выдана другому пользователю.
+	ErrFXQuoteNotFound = errors.New("fx quote not found")
+	// ErrFXQuoteExpired — срок действия котировки истёк.
+	ErrFXQuoteExpired = errors.New("fx quote expired")
+	// ErrFXQuoteUsed — котировка уже использована в другой операции.
+	ErrFXQuoteUsed = errors.New("fx quote already used")
+	// ErrFXQuoteMismatch — валютная пара котировки не совпадает с операцией.
+	ErrFXQuoteMismatch = errors.New("fx quote does not match the conversion")
+)
+
+// EnsureFXQuoteTables creates fx_quotes, adds transactions.fx_quote_id and seeds the
+// fx_quote_ttl_seconds setting. A quote locks the customer rate (base + markup) of one
+// currency pair for a short time; the conversion that uses it stores the quote ID so
+// finance can compare the executed rate with the base rate.
+func EnsureFXQuoteTables() error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	_, err := GlobalDB.Exec(`
+		CREATE TABLE IF NOT EXISTS fx_quotes (
+			id             VARCHAR(40) PRIMARY KEY,
+			user_id        INTEGER NOT NULL,
+			currency_from  VARCHAR(10) NOT NULL,
+			currency_to    VARCHAR(10) NOT NULL,
+			base_rate      NUMERIC(20,8) NOT NULL,
+			markup_percent NUMERIC(10,4) NOT NULL DEFAULT 0,
+			rate           NUMERIC(20,8) NOT NULL,
+			expires_at     TIMESTAMPTZ NOT NULL,
+			used_at        TIMESTAMPTZ,
+			transaction_id INTEGER,
+			created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_fx_quotes_user ON fx_quotes(user_id, created_at DESC);
+		CREATE INDEX IF NOT EXISTS idx_fx_quotes_used ON fx_quotes(used_at) WHERE used_at IS NOT NULL;
+		ALTER TABLE IF EXISTS fx_quotes DISABLE ROW LEVEL SECURITY;
+
+		ALTER TABLE transactions ADD COLUMN IF NOT EXISTS fx_quote_id VARCHAR(40);
+
+		INSERT INTO system_settings (setting_key, setting_value, description)
+		VALUES ('fx_quote_ttl_seconds', '60', 'Сколько секунд действует зафиксированный курс конвертации (POST /user/fx/quote)')
+		ON CONFLICT (setting_key) DO NOTHING;
+	`)
+	if err != nil {
+		log.Printf("[FX-QUOTES] Error ensuring fx_quotes table: %v", err)
+		return err
+	}
+	log.Println("[FX-QUOTES] ✅ fx_quotes table ensured")
+	return nil
+}
+
+// GetFXQuoteTTLSeconds reads fx_quote_ttl_seconds from system_settings.
+func GetFXQuoteTTLSeconds() int {
+	if GlobalDB == nil {
+		return DefaultFXQuoteTTLSeconds
+	}
+	var value string
+	if err := GlobalDB.QueryRow(
+		`SELECT setting_value FROM system_settings WHERE setting_key = 'fx_quote_ttl_seconds'`,
+	).Scan(&value); err != nil {
+		return DefaultFXQuoteTTLSeconds
+	}
+	seconds, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || seconds <= 0 {
+		return DefaultFXQuoteTTLSeconds
+	}
+	return seconds
+}
+
+// baseCrossRate — кросс-курс from → to по exchange_rates.base_rate (курс без наценки платформы).
+func baseCrossRate(from, to string) (decimal.Decimal, error) {
+	fromLeg, err := loadFXLeg(from)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	toLeg, err := loadFXLeg(to)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return fromLeg.Base.DivRound(toLeg.Base, 8), nil
+}
+
+// quoteMarkupPercent — наценка котировки в процентах: насколько клиентский курс rate хуже base
+// для клиента. Клиент всегда получает меньше валюты to, чем по base, поэтому наценка положительна
+// в обе стороны и совпадает с той, что применил tradeCrossRate.
+func quoteMarkupPercent(base, rate decimal.Decimal) decimal.Decimal {
+	if !rate.IsPositive() {
+		return decimal.Zero
+	}
+	return base.Div(rate).Sub(decimal.NewFromInt(1)).Mul(decimal.NewFromInt(100)).Round(4)
+}
+
+// CreateFXQuote фиксирует курс from → to для пользователя на GetFXQuoteTTLSeconds секунд.
+// Rate — клиентский курс (CrossRate, с наценкой), BaseRate — курс без наценки.
+func CreateFXQuote(userID int, from, to string) (*domain.FXQuote, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	from, err := NormalizeWalletCurrency(from)
+	if err != nil {
+		return nil, err
+	}
+	if to, err = NormalizeWalletCurrency(to); err != nil {
+		return nil, err
+	}
+	if from == to {
+		return nil, fmt.Errorf("source and target currency are the same")
+	}
+
+	snapshot := currentRateSnapshot(GlobalDB)
+	rate, err := CrossRate(from, to)
+	if err != nil {
+		return nil, err
+	}
+	base, err := baseCrossRate(from, to)
+	if err != nil {
+		return nil, err
+	}
+	markup := quoteMarkupPercent(base, rate)
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("failed to generate quote id: %w", err)
+	}
+	now := time.Now()
+	q := &domain.FXQuote{
+		ID:             "fxq_" + hex.EncodeToString(b),
+		UserID:         userID,
+		FromCurrency:   from,
+		ToCurrency:     to,
+		BaseRate:       base,
+		MarkupPercent:  markup,
+		Rate:           rate,
+		ExpiresAt:      now.Add(time.Duration(GetFXQuoteTTLSeconds()) * time.Second),
+		RateSnapshotID: snapshot,
+		CreatedAt:      now,
+	}
+	_, err = GlobalDB.Exec(
+		`INSERT INTO fx_quotes (id, user_id, currency_from, currency_to, base_rate, markup_percent, rate, expires_at, rate_snapshot_id, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		q.ID, q.UserID, q.FromCurrency, q.ToCurrency, q.BaseRate, q.MarkupPercent, q.Rate, q.ExpiresAt, snapshotArg(snapshot), q.CreatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save fx quote: %w", err)
+	}
+	log.Printf("[FX-QUOTES] User %d: quote %s %s→%s rate %s (base %s, markup %s%%) until %s",
+		userID, q.ID, from, to, rate.String(), base.String(), markup.String(), q.ExpiresAt.Format(time.RFC3339))
+	return q, nil
+}
+
+// scanFXQuote reads one fx_quotes row selected with fxQuoteColumns.
+func scanFXQuote(row interface{ Scan(...interface{}) error }) (*domain.FXQuote, error) {
+	var q domain.FXQuote
+	var usedAt sql.NullTime
+	var txID, snapshotID sql.NullInt64
+	if err := row.Scan(&q.ID, &q.UserID, &q.FromCurrency, &q.ToCurrency, &q.BaseRate, &q.MarkupPercent,
+		&q.Rate, &q.ExpiresAt, &usedAt, &txID, &snapshotID, &q.CreatedAt); err != nil {
+		return nil, err
+	}
+	if snapshotID.Valid {
+		q.RateSnapshotID = &snapshotID.Int64
+	}
+	if usedAt.Valid {
+		q.UsedAt = &usedAt.Time
+	}
+	if txID.Valid {
+		id := int(txID.Int64)
+		q.TransactionID = &id
+	}
+	return &q, nil
+}
+
+const fxQuoteColumns = `id, user_id, currency_from, currency_to, base_rate, markup_percent,
+	rate, expires_at, used_at, transaction_id, rate_snapshot_id, created_at`
+
+// lockFXQuote блокирует котировку внутри транзакции и проверяет, что её можно исполнить:
+// она принадлежит userID, не истекла, не использована и выдана на пару from → to.
+func lockFXQuote(tx *sql.Tx, quoteID string, userID int, from, to string) (*domain.FXQuote, error) {
+	q, err := scanFXQuote(tx.QueryRow(
+		`SELECT `+fxQuoteColumns+` FROM fx_quotes WHERE id = $1 FOR UPDATE`, strings.TrimSpace(quoteID)))
+	if err == sql.ErrNoRows || (err == nil && q.UserID != userID) {
+		return nil, ErrFXQuoteNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load fx quote: %w", err)
+	}
+	if q.UsedAt != nil {
+		return nil, ErrFXQuoteUsed
+	}
+	if time.Now().After(q.ExpiresAt) {
+		return nil, ErrFXQuoteExpired
+	}
+	if q.FromCurrency != from || q.ToCurrency != to {
+		return nil, fmt.Errorf("%w: quote is %s→%s, operation is %s→%s", ErrFXQuoteMismatch,
+			q.FromCurrency, q.ToCurrency, from, to)
+	}
+	return q, nil
+}
+
+// markFXQuoteUsed привязывает котировку к исполненной транзакции.
+func markFXQuoteUsed(tx *sql.Tx, quoteID string, transactionID int) error {
+	_, err := tx.Exec(
+		`UPDATE fx_quotes SET used_at = NOW(), transaction_id = $2 WHERE id = $1`,
+		quoteID, transactionID,
+	)
+	return err
+}
+
+// ListFXQuotes returns the latest quotes for the admin FX audit, newest first.
+// usedOnly keeps only quotes that were executed.
+func ListFXQuotes(usedOnly bool, limit int) ([]domain.FXQuote, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	query := `SELECT ` + fxQuoteColumns + ` FROM fx_quotes`
+	if usedOnly {
+		query += ` WHERE used_at IS NOT NULL`
+	}
+	query += ` ORDER BY created_at DESC LIMIT $1`
+
+	rows, err := GlobalDB.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	quotes := []domain.FXQuote{}
+	for rows.Next() {
+		q, err := scanFXQuote(rows)
+		if err != nil {
+			return nil, err
+		}
+		quotes = append(quotes, *q)
+	}
+	return quotes, rows.Err()
+}
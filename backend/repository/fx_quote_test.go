@@ -0,0 +1,27 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestQuoteMarkupPercentBothDirections(t *testing.T) {
+	d := decimal.RequireFromString
+	rub := fxLeg{Currency: "RUB", Base: decimal.NewFromInt(1)}
+	usd := fxLeg{Currency: "USD", Base: d("92.5"), Markup: d("3")}
+	eur := fxLeg{Currency: "EUR", Base: d("100.2"), Markup: d("4.5")}
+
+	for _, pair := range [][2]fxLeg{{usd, rub}, {rub, usd}, {usd, eur}, {eur, usd}} {
+		from, to := pair[0], pair[1]
+		rate, applied := tradeCrossRate(from, to)
+		base := from.Base.DivRound(to.Base, 8)
+		got := quoteMarkupPercent(base, rate)
+		if !got.IsPositive() || !got.Round(2).Equal(applied) {
+			t.Errorf("%s→%s: markup_percent котировки %s, применено %s%%", from.Currency, to.Currency, got, applied)
+		}
+	}
+	if got := quoteMarkupPercent(d("100"), decimal.Zero); !got.IsZero() {
+		t.Errorf("нулевой курс: наценка %s", got)
+	}
+}
@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// IdempotencyRecord — сохранённый запрос с Idempotency-Key и его ответ.
+type IdempotencyRecord struct {
+	RequestHash string
+	Completed   bool // false — первый запрос с этим ключом ещё выполняется
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+// EnsureIdempotencyTable creates the idempotency_keys table if it doesn't exist.
+func EnsureIdempotencyTable() error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	_, err := GlobalDB.Exec(`
+		CREATE TABLE IF NOT EXISTS idempotency_keys (
+			user_id       INTEGER NOT NULL,
+			idem_key      TEXT NOT NULL,
+			request_hash  TEXT NOT NULL,
+			status_code   INTEGER,
+			content_type  TEXT NOT NULL DEFAULT '',
+			response_body BYTEA,
+			created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			expires_at    TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (user_id, idem_key)
+		);
+		CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires ON idempotency_keys(expires_at);
+		ALTER TABLE IF EXISTS idempotency_keys DISABLE ROW LEVEL SECURITY;
+	`)
+	if err != nil {
+		log.Printf("[IDEMPOTENCY] Error creating idempotency_keys table: %v", err)
+		return err
+	}
+	log.Println("[IDEMPOTENCY] ✅ idempotency_keys table ensured")
+	return nil
+}
+
+// ReserveIdempotencyKey atomically claims (userID, key) for a new request.
+// Returns nil when the key was free and is now reserved; otherwise returns the existing record.
+// Expired keys of the user are purged first, so an expired key behaves like a new one.
+func ReserveIdempotencyKey(userID int, key, requestHash string, ttl time.Duration) (*IdempotencyRecord, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+
+	if _, err := GlobalDB.Exec(
+		`DELETE FROM idempotency_keys WHERE user_id = $1 AND expires_at < NOW()`, userID,
+	); err != nil {
+		log.Printf("[IDEMPOTENCY] ⚠️ Failed to purge expired keys for user %d: %v", userID, err)
+	}
+
+	res, err := GlobalDB.Exec(
+		`INSERT INTO idempotency_keys (user_id, idem_key, request_hash, expires_at)
+		 VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (user_id, idem_key) DO NOTHING`,
+		userID, key, requestHash, time.Now().Add(ttl),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		return nil, nil
+	}
+
+	var rec IdempotencyRecord
+	var status sql.NullInt64
+	err = GlobalDB.QueryRow(
+		`SELECT request_hash, status_code, content_type, response_body
+		 FROM idempotency_keys WHERE user_id = $1 AND idem_key = $2`,
+		userID, key,
+	).Scan(&rec.RequestHash, &status, &rec.ContentType, &rec.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load idempotency key: %w", err)
+	}
+	if status.Valid {
+		rec.Completed = true
+		rec.StatusCode = int(status.Int64)
+	}
+	return &rec, nil
+}
+
+// CompleteIdempotencyKey stores the response for a reserved key.
+func CompleteIdempotencyKey(userID int, key string, statusCode int, contentType string, body []byte) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	_, err := GlobalDB.Exec(
+		`UPDATE idempotency_keys SET status_code = $1, content_type = $2, response_body = $3
+		 WHERE user_id = $4 AND idem_key = $5`,
+		statusCode, contentType, body, userID, key,
+	)
+	return err
+}
@@ -1,15 +1,20 @@
 package repository
 
 import (
+	"database/sql"
 	"fmt"
 	"log"
 	"strings"
 	"time"
 
 	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/ledger"
 	"github.com/shopspring/decimal"
 )
 
+// WalletCurrency — валюта Кошелька (internal_balances.master_balance).
+const WalletCurrency = "USD"
+
 // GetInternalBalance — получить внутренний баланс (Кошелёк) пользователя.
 // Если записи нет — создаёт с нулевым балансом (upsert).
 func GetInternalBalance(userID int) (*domain.InternalBalance, error) {
@@ -17,16 +22,6 @@ func GetInternalBalance(userID int) (*domain.InternalBalance, error) {
 		return nil, fmt.Errorf("database connection not initialized")
 	}
 
-	query := `
-		INSERT INTO internal_balances (user_id, master_balance, updated_at)
-		VALUES ($1, 0, NOW())
-		ON CONFLICT (user_id) DO NOTHING;
-
-		SELECT id, user_id, master_balance, updated_at
-		FROM internal_balances
-		WHERE user_id = $1
-	`
-
 	// Выполняем upsert + select в два шага
 	_, _ = GlobalDB.Exec(
 		`INSERT INTO internal_balances (user_id, master_balance, updated_at)
@@ -42,28 +37,38 @@ func GetInternalBalance(userID int) (*domain.InternalBalance, error) {
 		return nil, fmt.Errorf("failed to get internal balance: %w", err)
 	}
 
-	_ = query // suppress unused warning
+	// Холды по картам уменьшают доступный баланс, но не проведённый
+	ib.AvailableBalance = ib.MasterBalance
+	if held, err := GetHeldBalance(userID); err == nil {
+		ib.HeldBalance = held
+		ib.AvailableBalance = ib.MasterBalance.Sub(held)
+	} else {
+		log.Printf("Warning: failed to get held balance for user %d: %v", userID, err)
+	}
+
 	return &ib, nil
 }
 
-// TopUpInternalBalance — пополнить Кошелёк пользователя.
-// Принимает сумму в рублях, конвертирует в USD по текущему курсу и зачисляет в master_balance (USD).
-func TopUpInternalBalance(userID int, amountRub decimal.Decimal) (*domain.InternalBalance, error) {
+// TopUpInternalBalance — пополнить Кошелёк пользователя рублями (СБП).
+// currency — Кошелёк зачисления: RUB зачисляется как есть, USD (по умолчанию) —
+// после конвертации RUB → USD по текущему курсу или по котировке quoteID (CreateFXQuote).
+func TopUpInternalBalance(userID int, amountRub decimal.Decimal, currency, quoteID string) (*domain.InternalBalance, error) {
 	if GlobalDB == nil {
 		return nil, fmt.Errorf("database connection not initialized")
 	}
 	if amountRub.LessThanOrEqual(decimal.Zero) {
 		return nil, fmt.Errorf("amount must be positive")
 	}
-
-	// Конвертируем RUB → USD по внутреннему курсу
-	rate, err := GetFinalRate("RUB", "USD")
-	if err != nil || rate.IsZero() {
-		return nil, fmt.Errorf("exchange rate not available, cannot convert RUB to USD")
+	target, err := NormalizeWalletCurrency(currency)
+	if err != nil {
+		return nil, err
 	}
-	amountUsd := amountRub.Div(rate).Round(2)
-	if amountUsd.LessThanOrEqual(decimal.Zero) {
-		return nil, fmt.Errorf("converted amount too small")
+	if target != WalletCurrency && target != "RUB" {
+		return nil, fmt.Errorf("top-up to %s wallet is not supported, convert after top-up", target)
+	}
+	quoteID = strings.TrimSpace(quoteID)
+	if quoteID != "" && target == "RUB" {
+		return nil, fmt.Errorf("%w: RUB top-up needs no conversion", ErrFXQuoteMismatch)
 	}
 
 	tx, err := GlobalDB.Begin()
@@ -72,37 +77,149 @@ func TopUpInternalBalance(userID int, amountRub decimal.Decimal) (*domain.Intern
 	}
 	defer tx.Rollback()
 
-	// Зачисляем USD в Кошелёк (upsert)
-	_, err = tx.Exec(
-		`INSERT INTO internal_balances (user_id, master_balance, updated_at)
-		 VALUES ($1, $2, NOW())
-		 ON CONFLICT (user_id) DO UPDATE SET master_balance = internal_balances.master_balance + $2, updated_at = NOW()`,
-		userID, amountUsd,
-	)
+	// Конвертируем RUB → USD по зафиксированной котировке или по текущему курсу
+	credited := amountRub.Round(2)
+	rate := decimal.NewFromInt(1)
+	var quoteRef interface{}
+	var snapshot *int64
+	details := fmt.Sprintf("Top-up wallet: %s ₽", amountRub.StringFixed(0))
+	if target == WalletCurrency {
+		if quoteID != "" {
+			q, err := lockFXQuote(tx, quoteID, userID, "RUB", target)
+			if err != nil {
+				return nil, err
+			}
+			rate, quoteRef, snapshot = q.Rate, q.ID, q.RateSnapshotID
+		} else {
+			snapshot = currentRateSnapshot(tx)
+			if rate, err = CrossRate("RUB", target); err != nil || rate.IsZero() {
+				return nil, fmt.Errorf("exchange rate not available, cannot convert RUB to USD")
+			}
+		}
+		credited = amountRub.Mul(rate).Round(2)
+		details = fmt.Sprintf("Top-up wallet: %s ₽ → $%s (rate %s ₽/$)", amountRub.StringFixed(0), credited.StringFixed(2),
+			decimal.NewFromInt(1).DivRound(rate, 4).StringFixed(2))
+	}
+	if credited.LessThanOrEqual(decimal.Zero) {
+		return nil, fmt.Errorf("converted amount too small")
+	}
+
+	// Записываем транзакцию (fx_rate — сколько единиц Кошелька за 1 ₽)
+	var txID int
+	err = tx.QueryRow(
+		`INSERT INTO transactions (user_id, amount, fee, transaction_type, status, details, currency, wallet_currency, original_amount, fx_rate, fx_quote_id, rate_snapshot_id, executed_at)
+		 VALUES ($1, $2, 0, 'WALLET_TOPUP', 'APPROVED', $3, $4, $4, $5, $6, $7, $8, $9) RETURNING id`,
+		userID, credited, details, target, amountRub, rate, quoteRef, snapshotArg(snapshot), time.Now(),
+	).Scan(&txID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record transaction: %w", err)
+	}
+	if quoteRef != nil {
+		if err := markFXQuoteUsed(tx, quoteID, txID); err != nil {
+			return nil, fmt.Errorf("failed to mark fx quote used: %w", err)
+		}
+	}
+
+	// Зачисляем в Кошелёк через журнал
+	_, err = ledger.Post(tx, ledger.Entry{
+		Type:          "WALLET_TOPUP",
+		TransactionID: txID,
+		Description:   fmt.Sprintf("SBP top-up %s RUB", amountRub.StringFixed(0)),
+		Lines:         ledger.Move(ledger.ExternalSettlement, ledger.UserWallet(userID), credited, target),
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to top up wallet: %w", err)
 	}
 
-	// Записываем транзакцию
-	_, err = tx.Exec(
-		`INSERT INTO transactions (user_id, amount, fee, transaction_type, status, details, executed_at)
-		 VALUES ($1, $2, 0, 'WALLET_TOPUP', 'APPROVED', $3, $4)`,
-		userID, amountUsd,
-		fmt.Sprintf("Top-up wallet: %s ₽ → $%s (rate %s)", amountRub.StringFixed(0), amountUsd.StringFixed(2), rate.StringFixed(2)),
-		time.Now(),
-	)
+	// Уведомление фиксируется вместе с зачислением; при зачислении в рублёвый Кошелёк баланс USD не показываем
+	data := map[string]string{"amount": amountRub.StringFixed(0), "currency": "RUB", "source": "СБП"}
+	if target != "RUB" {
+		var balance decimal.Decimal
+		if err := tx.QueryRow(`SELECT COALESCE(master_balance, 0) FROM internal_balances WHERE user_id = $1`, userID).Scan(&balance); err != nil {
+			return nil, fmt.Errorf("failed to read wallet balance: %w", err)
+		}
+		data["balance"] = "$" + balance.StringFixed(2)
+	}
+	err = EnqueueNotification(tx, domain.UserNotification{
+		UserID:   userID,
+		Template: domain.NotifyWalletToppedUp,
+		Amount:   amountRub,
+		Data:     data,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to record transaction: %w", err)
+		return nil, err
 	}
 
 	if err := tx.Commit(); err != nil {
 		return nil, fmt.Errorf("failed to commit: %w", err)
 	}
 
-	log.Printf("✅ Wallet topped up: user=%d, %s ₽ → $%s", userID, amountRub.StringFixed(0), amountUsd.StringFixed(2))
+	log.Printf("✅ Wallet topped up: user=%d, %s ₽ → %s %s", userID, amountRub.StringFixed(0), credited.StringFixed(2), target)
 	return GetInternalBalance(userID)
 }
 
+// CreditExternalTopUp — зачислить подтверждённое внешнее пополнение в Кошелёк.
+// Транзакция DEPOSIT пишется с provider_tx_id = externalTxID для idempotency.
+func CreditExternalTopUp(userID int, amount decimal.Decimal, currency, providerName, externalTxID string) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	if amount.LessThanOrEqual(decimal.Zero) {
+		return fmt.Errorf("amount must be positive")
+	}
+
+	tx, err := GlobalDB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var txID int
+	err = tx.QueryRow(
+		`INSERT INTO transactions (user_id, amount, fee, transaction_type, status, details, provider_tx_id, executed_at)
+		 VALUES ($1, $2, 0, 'DEPOSIT', 'APPROVED', $3, $4, $5) RETURNING id`,
+		userID, amount,
+		fmt.Sprintf("External top-up via %s: +%s %s", providerName, amount.String(), currency),
+		externalTxID,
+		time.Now(),
+	).Scan(&txID)
+	if err != nil {
+		return fmt.Errorf("failed to record transaction: %w", err)
+	}
+
+	_, err = ledger.Post(tx, ledger.Entry{
+		Type:          "DEPOSIT",
+		Reference:     externalTxID,
+		TransactionID: txID,
+		Description:   "External top-up via " + providerName,
+		Lines:         ledger.Move(ledger.ExternalSettlement, ledger.UserWallet(userID), amount, WalletCurrency),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to credit wallet: %w", err)
+	}
+
+	// Уведомление и вебхук фиксируются вместе с зачислением и не теряются при сбое отправки
+	err = EnqueueNotification(tx, domain.UserNotification{
+		UserID:   userID,
+		Template: domain.NotifyWalletToppedUp,
+		Data:     map[string]string{"amount": amount.String(), "currency": currency, "source": providerName},
+	})
+	if err != nil {
+		return err
+	}
+	err = EnqueueWebhookEvent(tx, userID, domain.WebhookWalletCredited, map[string]interface{}{
+		"amount": amount.String(), "currency": currency, "source": providerName, "reference": externalTxID,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit: %w", err)
+	}
+	return nil
+}
+
 // DeductWalletBalance — списать из Кошелька (internal_balances.master_balance) для оплаты выпуска карт.
 // Атомарно проверяет баланс, списывает и записывает транзакцию.
 func DeductWalletBalance(userID int, amount decimal.Decimal, details string) error {
@@ -119,12 +236,8 @@ func DeductWalletBalance(userID int, amount decimal.Decimal, details string) err
 	}
 	defer tx.Rollback()
 
-	// Проверяем master_balance
-	var balance decimal.Decimal
-	err = tx.QueryRow(
-		`SELECT COALESCE(master_balance, 0) FROM internal_balances WHERE user_id = $1 FOR UPDATE`,
-		userID,
-	).Scan(&balance)
+	// Проверяем доступный баланс (master_balance за вычетом холдов по картам)
+	balance, err := LockAvailableWalletBalance(tx, userID, 0)
 	if err != nil {
 		return fmt.Errorf("кошелёк не найден — пополните баланс")
 	}
@@ -132,23 +245,26 @@ func DeductWalletBalance(userID int, amount decimal.Decimal, details string) err
 		return fmt.Errorf("недостаточно средств (баланс: $%s, требуется: $%s)", balance.StringFixed(2), amount.StringFixed(2))
 	}
 
-	// Списываем
-	_, err = tx.Exec(
-		`UPDATE internal_balances SET master_balance = master_balance - $1, updated_at = NOW() WHERE user_id = $2`,
-		amount, userID,
-	)
-	if err != nil {
-		return fmt.Errorf("не удалось списать из кошелька: %v", err)
-	}
-
 	// Записываем транзакцию
-	_, err = tx.Exec(
+	var txID int
+	err = tx.QueryRow(
 		`INSERT INTO transactions (user_id, amount, fee, transaction_type, status, details, executed_at)
-		 VALUES ($1, $2, 0, 'CARD_ISSUE_FEE', 'APPROVED', $3, $4)`,
+		 VALUES ($1, $2, 0, 'CARD_ISSUE_FEE', 'APPROVED', $3, $4) RETURNING id`,
 		userID, amount, details, time.Now(),
-	)
+	).Scan(&txID)
+	if err != nil {
+		return fmt.Errorf("не удалось записать транзакцию: %v", err)
+	}
+
+	// Списываем: Кошелёк → доход платформы
+	_, err = ledger.Post(tx, ledger.Entry{
+		Type:          "CARD_ISSUE_FEE",
+		TransactionID: txID,
+		Description:   details,
+		Lines:         ledger.Move(ledger.UserWallet(userID), ledger.FeeRevenue, amount, WalletCurrency),
+	})
 	if err != nil {
-		log.Printf("DB Error Insert deduction transaction: %v", err)
+		return fmt.Errorf("не удалось списать из кошелька: %v", err)
 	}
 
 	if err := tx.Commit(); err != nil {
@@ -159,6 +275,58 @@ func DeductWalletBalance(userID int, amount decimal.Decimal, details string) err
 	return nil
 }
 
+// RefundWalletFee — вернуть ранее списанную комиссию (DeductWalletBalance) в Кошелёк.
+// Проводка обратная: доход платформы → Кошелёк, транзакция FEE_REFUND.
+func RefundWalletFee(userID int, amount decimal.Decimal, details string) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	if amount.LessThanOrEqual(decimal.Zero) {
+		return fmt.Errorf("сумма возврата должна быть положительной")
+	}
+
+	tx, err := GlobalDB.Begin()
+	if err != nil {
+		return fmt.Errorf("не удалось начать транзакцию: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := refundWalletFeeTx(tx, userID, amount, details); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ошибка фиксации: %v", err)
+	}
+
+	log.Printf("User %d: refunded $%s to wallet for: %s", userID, amount.StringFixed(2), details)
+	return nil
+}
+
+// refundWalletFeeTx — FEE_REFUND внутри уже открытой транзакции.
+func refundWalletFeeTx(tx *sql.Tx, userID int, amount decimal.Decimal, details string) error {
+	var txID int
+	err := tx.QueryRow(
+		`INSERT INTO transactions (user_id, amount, fee, transaction_type, status, details, executed_at)
+		 VALUES ($1, $2, 0, 'FEE_REFUND', 'APPROVED', $3, $4) RETURNING id`,
+		userID, amount, details, time.Now(),
+	).Scan(&txID)
+	if err != nil {
+		return fmt.Errorf("не удалось записать транзакцию: %v", err)
+	}
+
+	_, err = ledger.Post(tx, ledger.Entry{
+		Type:          "FEE_REFUND",
+		TransactionID: txID,
+		Description:   details,
+		Lines:         ledger.Move(ledger.FeeRevenue, ledger.UserWallet(userID), amount, WalletCurrency),
+	})
+	if err != nil {
+		return fmt.Errorf("не удалось вернуть в кошелёк: %v", err)
+	}
+	return nil
+}
+
 // PurchaseViaCard — атомарная покупка товара через карту.
 // Цепочка: Карта → (авто-пополнение из Кошелька при нехватке) → Списание с карты.
 // Прямое списание с Кошелька ЗАПРЕЩЕНО.
@@ -186,85 +354,113 @@ func PurchaseViaCard(userID int, amount decimal.Decimal, description string) (in
 	}
 	defer tx.Rollback()
 
-	// 2. Проверяем баланс карты (FOR UPDATE)
+	// 2. Проверяем баланс карты (FOR UPDATE); цена — в USD, баланс карты — в валюте карты
 	var cardBalance decimal.Decimal
+	var cardCurrency string
 	err = tx.QueryRow(
-		`SELECT COALESCE(card_balance, 0) FROM cards WHERE id = $1 AND user_id = $2 FOR UPDATE`,
+		`SELECT COALESCE(card_balance, 0), COALESCE(currency, 'USD') FROM cards WHERE id = $1 AND user_id = $2 FOR UPDATE`,
 		card.ID, userID,
-	).Scan(&cardBalance)
+	).Scan(&cardBalance, &cardCurrency)
 	if err != nil {
 		return 0, "", fmt.Errorf("не удалось получить баланс карты: %v", err)
 	}
+	if cardCurrency, err = NormalizeWalletCurrency(cardCurrency); err != nil {
+		return 0, "", err
+	}
+	rate := decimal.NewFromInt(1) // единиц валюты карты за 1 USD
+	amountInCardCurrency := amount
+	if cardCurrency != WalletCurrency {
+		if rate, err = CrossRate(WalletCurrency, cardCurrency); err != nil {
+			return 0, "", fmt.Errorf("курс %s/%s недоступен", WalletCurrency, cardCurrency)
+		}
+		amountInCardCurrency = amount.Mul(rate).Round(2)
+	}
+	var fxRate interface{}
+	if cardCurrency != WalletCurrency {
+		fxRate = rate
+	}
 
 	// 3. Авто-пополнение при нехватке средств на карте
-	if cardBalance.LessThan(amount) {
-		deficit := amount.Sub(cardBalance)
+	if cardBalance.LessThan(amountInCardCurrency) {
+		deficitInCardCurrency := amountInCardCurrency.Sub(cardBalance)
+		deficit := deficitInCardCurrency.Div(rate).RoundCeil(2)
 
-		// Проверяем баланс кошелька
-		var walletBalance decimal.Decimal
-		err = tx.QueryRow(
-			`SELECT COALESCE(master_balance, 0) FROM internal_balances WHERE user_id = $1 FOR UPDATE`,
-			userID,
-		).Scan(&walletBalance)
+		// Проверяем доступный баланс кошелька (без холдов по картам)
+		walletBalance, err := LockAvailableWalletBalance(tx, userID, 0)
 		if err != nil {
 			return 0, "", fmt.Errorf("кошелёк не найден — пополните баланс")
 		}
 
 		if walletBalance.LessThan(deficit) {
-			total := cardBalance.Add(walletBalance)
+			cardBalanceUSD := cardBalance.Div(rate).RoundFloor(2)
+			total := cardBalanceUSD.Add(walletBalance)
 			return 0, "", fmt.Errorf("INSUFFICIENT_FUNDS:card=$%s,wallet=$%s,total=$%s,required=$%s",
-				cardBalance.StringFixed(2), walletBalance.StringFixed(2),
+				cardBalanceUSD.StringFixed(2), walletBalance.StringFixed(2),
 				total.StringFixed(2), amount.StringFixed(2))
 		}
 
-		// Списываем дефицит из кошелька
-		_, err = tx.Exec(
-			`UPDATE internal_balances SET master_balance = master_balance - $1, updated_at = NOW() WHERE user_id = $2`,
-			deficit, userID,
-		)
+		// Записываем транзакцию авто-пополнения
+		var topupTxID int
+		err = tx.QueryRow(
+			`INSERT INTO transactions (user_id, card_id, amount, fee, transaction_type, status, details, currency, wallet_currency, original_amount, fx_rate, executed_at)
+			 VALUES ($1, $2, $3, 0, 'CARD_TOPUP', 'APPROVED', $4, $5, $6, $7, $8, $9) RETURNING id`,
+			userID, card.ID, deficit,
+			fmt.Sprintf("Auto top-up for purchase: $%s → card *%s", deficit.StringFixed(2), card.Last4Digits),
+			cardCurrency, WalletCurrency, deficitInCardCurrency, fxRate, time.Now(),
+		).Scan(&topupTxID)
 		if err != nil {
-			return 0, "", fmt.Errorf("не удалось списать из кошелька: %v", err)
+			return 0, "", fmt.Errorf("не удалось записать авто-пополнение: %v", err)
 		}
 
-		// Зачисляем дефицит на карту
-		_, err = tx.Exec(
-			`UPDATE cards SET card_balance = COALESCE(card_balance, 0) + $1 WHERE id = $2`,
-			deficit, card.ID,
-		)
+		// Переводим дефицит: Кошелёк → карта; при разных валютах — через позицию конвертации
+		lines := ledger.Move(ledger.UserWallet(userID), ledger.Card(card.ID), deficit, WalletCurrency)
+		if cardCurrency != WalletCurrency {
+			lines = append(
+				ledger.Move(ledger.UserWallet(userID), ledger.FX, deficit, WalletCurrency),
+				ledger.Move(ledger.FX, ledger.Card(card.ID), deficitInCardCurrency, cardCurrency)...,
+			)
+		}
+		_, err = ledger.Post(tx, ledger.Entry{
+			Type:          "CARD_TOPUP",
+			TransactionID: topupTxID,
+			Description:   "Auto top-up for purchase",
+			Lines:         lines,
+		})
 		if err != nil {
-			return 0, "", fmt.Errorf("не удалось зачислить на карту: %v", err)
+			return 0, "", fmt.Errorf("не удалось пополнить карту из кошелька: %v", err)
 		}
 
-		// Записываем транзакцию авто-пополнения
-		_, _ = tx.Exec(
-			`INSERT INTO transactions (user_id, card_id, amount, fee, transaction_type, status, details, executed_at)
-			 VALUES ($1, $2, $3, 0, 'CARD_TOPUP', 'APPROVED', $4, $5)`,
-			userID, card.ID, deficit,
-			fmt.Sprintf("Auto top-up for purchase: $%s → card *%s", deficit.StringFixed(2), card.Last4Digits),
-			time.Now(),
-		)
-
 		log.Printf("[PURCHASE-VIA-CARD] Auto top-up: user=%d, $%s wallet → card %d (*%s)",
 			userID, deficit.StringFixed(2), card.ID, card.Last4Digits)
 	}
 
-	// 4. Списание с баланса карты
-	_, err = tx.Exec(
-		`UPDATE cards SET card_balance = card_balance - $1 WHERE id = $2`,
-		amount, card.ID,
-	)
+	// 4. Записываем транзакцию покупки
+	var purchaseTxID int
+	err = tx.QueryRow(
+		`INSERT INTO transactions (user_id, card_id, amount, fee, transaction_type, status, details, currency, wallet_currency, original_amount, fx_rate, executed_at)
+		 VALUES ($1, $2, $3, 0, 'STORE_PURCHASE', 'APPROVED', $4, $5, $6, $7, $8, $9) RETURNING id`,
+		userID, card.ID, amount, description, cardCurrency, WalletCurrency, amountInCardCurrency, fxRate, time.Now(),
+	).Scan(&purchaseTxID)
 	if err != nil {
-		return 0, "", fmt.Errorf("не удалось списать с карты: %v", err)
+		return 0, "", fmt.Errorf("не удалось записать покупку: %v", err)
 	}
 
-	// 5. Записываем транзакцию покупки
-	_, err = tx.Exec(
-		`INSERT INTO transactions (user_id, card_id, amount, fee, transaction_type, status, details, executed_at)
-		 VALUES ($1, $2, $3, 0, 'STORE_PURCHASE', 'APPROVED', $4, $5)`,
-		userID, card.ID, amount, description, time.Now(),
-	)
+	// 5. Списание с баланса карты (в её валюте): карта → поставщик (в USD)
+	lines := ledger.Move(ledger.Card(card.ID), ledger.SupplierPayable, amount, WalletCurrency)
+	if cardCurrency != WalletCurrency {
+		lines = append(
+			ledger.Move(ledger.Card(card.ID), ledger.FX, amountInCardCurrency, cardCurrency),
+			ledger.Move(ledger.FX, ledger.SupplierPayable, amount, WalletCurrency)...,
+		)
+	}
+	_, err = ledger.Post(tx, ledger.Entry{
+		Type:          "STORE_PURCHASE",
+		TransactionID: purchaseTxID,
+		Description:   description,
+		Lines:         lines,
+	})
 	if err != nil {
-		log.Printf("DB Error Insert STORE_PURCHASE transaction: %v", err)
+		return 0, "", fmt.Errorf("не удалось списать с карты: %v", err)
 	}
 
 	if err := tx.Commit(); err != nil {
@@ -277,10 +473,18 @@ func PurchaseViaCard(userID int, amount decimal.Decimal, description string) (in
 	return card.ID, card.Last4Digits, nil
 }
 
-// TransferWalletToCard — перевести средства из Кошелька (USD) на карту.
-// Если карта в EUR, конвертирует USD→EUR по внутреннему курсу.
-// Атомарно: проверяет баланс, списывает из wallet, зачисляет на card_balance, записывает транзакцию.
-func TransferWalletToCard(userID int, cardID int, amountInCardCurrency decimal.Decimal, cardCurrency string) (*domain.InternalBalance, error) {
+// CardFundFunc пополняет карту у эмитента (service.CardProvider.TopUpCard).
+// Передаётся из handler, чтобы repository не зависел от пакета service.
+type CardFundFunc func(amount decimal.Decimal, currency string) error
+
+// TransferWalletToCard — перевести средства из Кошелька на карту (свою или карту команды, где у userID есть право team.manage).
+// Списание идёт из Кошелька в валюте карты (cards.currency). fromCurrency задаёт другой Кошелёк
+// явно — тогда сумма конвертируется по CrossRate или по котировке quoteID (пара fromCurrency → валюта карты),
+// проводка проходит через позицию FX.
+// Атомарно: проверяет баланс, списывает из Кошелька, зачисляет на card_balance, записывает транзакцию (PENDING).
+// fund (если задан) вызывается после фиксации, без блокировок БД (finishCardTopUp): если эмитент отклонил
+// пополнение, перевод сторнируется. n (если задано) ставится в очередь вместе с подтверждением пополнения.
+func TransferWalletToCard(userID int, cardID int, amountInCardCurrency decimal.Decimal, fromCurrency, quoteID string, fund CardFundFunc, n *domain.UserNotification) (*domain.InternalBalance, error) {
 	if GlobalDB == nil {
 		return nil, fmt.Errorf("database connection not initialized")
 	}
@@ -288,136 +492,219 @@ func TransferWalletToCard(userID int, cardID int, amountInCardCurrency decimal.D
 		return nil, fmt.Errorf("сумма должна быть положительной")
 	}
 
-	// Определяем сколько USD нужно списать из кошелька
-	var deductUSD decimal.Decimal
-	currency := strings.ToUpper(strings.TrimSpace(cardCurrency))
-	if currency == "" {
-		currency = "USD"
-	}
-
-	if currency == "EUR" || currency == "€" {
-		// Конвертируем EUR→USD: amountEUR * (rateRubPerEur / rateRubPerUsd)
-		rateRubUsd, err := GetFinalRate("RUB", "USD")
-		if err != nil || rateRubUsd.IsZero() {
-			return nil, fmt.Errorf("курс USD недоступен")
-		}
-		rateRubEur, err := GetFinalRate("RUB", "EUR")
-		if err != nil || rateRubEur.IsZero() {
-			return nil, fmt.Errorf("курс EUR недоступен")
-		}
-		deductUSD = amountInCardCurrency.Mul(rateRubEur).Div(rateRubUsd).Round(2)
-	} else {
-		// USD → USD, 1:1
-		deductUSD = amountInCardCurrency
-	}
-
 	tx, err := GlobalDB.Begin()
 	if err != nil {
 		return nil, fmt.Errorf("не удалось начать транзакцию: %v", err)
 	}
 	defer tx.Rollback()
 
-	// Проверяем master_balance и списываем
-	var balance decimal.Decimal
-	err = tx.QueryRow(
-		`SELECT COALESCE(master_balance, 0) FROM internal_balances WHERE user_id = $1 FOR UPDATE`,
-		userID,
-	).Scan(&balance)
+	// Проверяем принадлежность карты и её валюту: пополнить карту команды может также участник с правом team.manage
+	var ownerID int
+	var teamID sql.NullInt64
+	var cardCurrency string
+	err = tx.QueryRow(`SELECT user_id, team_id, COALESCE(currency, 'USD') FROM cards WHERE id = $1 FOR UPDATE`, cardID).Scan(&ownerID, &teamID, &cardCurrency)
 	if err != nil {
-		return nil, fmt.Errorf("кошелёк не найден — пополните баланс")
+		return nil, fmt.Errorf("карта не найдена")
+	}
+	if ownerID != userID {
+		var perms []string
+		if teamID.Valid {
+			if _, perms, _, err = teamMemberPermissions(tx, int(teamID.Int64), userID); err != nil {
+				return nil, fmt.Errorf("не удалось проверить права: %v", err)
+			}
+		}
+		if !HasPermission(perms, domain.PermTeamManage) {
+			return nil, fmt.Errorf("нет доступа к этой карте")
+		}
+	}
+	if cardCurrency, err = NormalizeWalletCurrency(cardCurrency); err != nil {
+		return nil, err
 	}
-	if balance.LessThan(deductUSD) {
-		return nil, fmt.Errorf("недостаточно средств (баланс: $%s, требуется: $%s)", balance.StringFixed(2), deductUSD.StringFixed(2))
+	source := cardCurrency
+	if strings.TrimSpace(fromCurrency) != "" {
+		if source, err = NormalizeWalletCurrency(fromCurrency); err != nil {
+			return nil, err
+		}
 	}
 
-	_, err = tx.Exec(
-		`UPDATE internal_balances SET master_balance = master_balance - $1, updated_at = NOW() WHERE user_id = $2`,
-		deductUSD, userID,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("не удалось списать из кошелька: %v", err)
+	// Сколько списать из Кошелька-источника: по котировке source → cardCurrency или по текущему кросс-курсу
+	rate := decimal.NewFromInt(1)
+	deduct := amountInCardCurrency
+	var quoteRef interface{}
+	var snapshot *int64
+	quoteID = strings.TrimSpace(quoteID)
+	if quoteID != "" {
+		q, err := lockFXQuote(tx, quoteID, userID, source, cardCurrency)
+		if err != nil {
+			return nil, err
+		}
+		rate, quoteRef, snapshot = decimal.NewFromInt(1).DivRound(q.Rate, 8), q.ID, q.RateSnapshotID
+		deduct = amountInCardCurrency.DivRound(q.Rate, 2)
+	} else if source != cardCurrency {
+		snapshot = currentRateSnapshot(tx)
+		// Клиент покупает валюту карты: курс source → cardCurrency, как у котировки
+		buyRate, err := CrossRate(source, cardCurrency)
+		if err != nil || !buyRate.IsPositive() {
+			return nil, fmt.Errorf("курс %s/%s недоступен", source, cardCurrency)
+		}
+		rate = decimal.NewFromInt(1).DivRound(buyRate, 8)
+		deduct = amountInCardCurrency.DivRound(buyRate, 2)
 	}
 
-	// Проверяем принадлежность карты и зачисляем на card_balance
-	var ownerID int
-	err = tx.QueryRow(`SELECT user_id FROM cards WHERE id = $1 FOR UPDATE`, cardID).Scan(&ownerID)
+	// Проверяем доступный баланс (для USD — master_balance за вычетом холдов по картам)
+	balance, err := LockWalletBalance(tx, userID, source)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("кошелёк не найден — пополните баланс")
+	}
 	if err != nil {
-		return nil, fmt.Errorf("карта не найдена")
+		return nil, fmt.Errorf("не удалось заблокировать кошелёк: %v", err)
 	}
-	if ownerID != userID {
-		return nil, fmt.Errorf("нет доступа к этой карте")
+	if balance.LessThan(deduct) {
+		msg := fmt.Sprintf("недостаточно средств в кошельке %s (баланс: %s, требуется: %s)", source, balance.StringFixed(2), deduct.StringFixed(2))
+		if source == cardCurrency {
+			msg += " — сконвертируйте средства из другого кошелька"
+		}
+		return nil, fmt.Errorf("%s", msg)
 	}
 
-	_, err = tx.Exec(
-		`UPDATE cards SET card_balance = COALESCE(card_balance, 0) + $1 WHERE id = $2`,
-		amountInCardCurrency, cardID,
-	)
+	// Записываем транзакцию: amount — в валюте Кошелька (wallet_currency), original_amount — в валюте карты
+	details := fmt.Sprintf("Card top-up: %s %s → card #%d (deducted %s %s from wallet)",
+		amountInCardCurrency.StringFixed(2), cardCurrency, cardID, deduct.StringFixed(2), source)
+
+	var fxRate interface{}
+	if source != cardCurrency {
+		fxRate = rate
+	}
+	var txID int
+	err = tx.QueryRow(
+		`INSERT INTO transactions (user_id, card_id, amount, fee, transaction_type, status, details, currency, wallet_currency, original_amount, fx_rate, fx_quote_id, rate_snapshot_id, executed_at)
+		 VALUES ($1, $2, $3, 0, 'CARD_TOPUP', 'PENDING', $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id`,
+		userID, cardID, deduct, details, cardCurrency, source, amountInCardCurrency, fxRate, quoteRef, snapshotArg(snapshot), time.Now(),
+	).Scan(&txID)
 	if err != nil {
-		return nil, fmt.Errorf("не удалось зачислить на карту: %v", err)
+		return nil, fmt.Errorf("не удалось записать транзакцию: %v", err)
 	}
-
-	// Записываем транзакцию
-	sym := "$"
-	if currency == "EUR" || currency == "€" {
-		sym = "€"
+	if quoteRef != nil {
+		if err := markFXQuoteUsed(tx, quoteID, txID); err != nil {
+			return nil, fmt.Errorf("не удалось отметить котировку: %v", err)
+		}
 	}
-	details := fmt.Sprintf("Card top-up: %s%s → card #%d (deducted $%s from wallet)",
-		sym, amountInCardCurrency.StringFixed(2), cardID, deductUSD.StringFixed(2))
 
-	_, err = tx.Exec(
-		`INSERT INTO transactions (user_id, card_id, amount, fee, transaction_type, status, details, executed_at)
-		 VALUES ($1, $2, $3, 0, 'CARD_TOPUP', 'APPROVED', $4, $5)`,
-		userID, cardID, deductUSD, details, time.Now(),
-	)
+	// Кошелёк → карта; при разных валютах — через позицию конвертации
+	lines := ledger.Move(ledger.UserWallet(userID), ledger.Card(cardID), deduct, source)
+	if source != cardCurrency {
+		lines = append(
+			ledger.Move(ledger.UserWallet(userID), ledger.FX, deduct, source),
+			ledger.Move(ledger.FX, ledger.Card(cardID), amountInCardCurrency, cardCurrency)...,
+		)
+	}
+	_, err = ledger.Post(tx, ledger.Entry{
+		Type:          "CARD_TOPUP",
+		TransactionID: txID,
+		Description:   details,
+		Lines:         lines,
+	})
 	if err != nil {
-		log.Printf("DB Error Insert CARD_TOPUP transaction: %v", err)
+		return nil, fmt.Errorf("не удалось перевести на карту: %v", err)
 	}
 
 	if err := tx.Commit(); err != nil {
 		return nil, fmt.Errorf("ошибка фиксации: %v", err)
 	}
+	if err := finishCardTopUp(txID, "CARD_TOPUP", details, lines, fund, amountInCardCurrency, cardCurrency, n); err != nil {
+		return nil, err
+	}
 
-	log.Printf("✅ User %d: transferred %s%s to card %d (deducted $%s from wallet)",
-		userID, sym, amountInCardCurrency.StringFixed(2), cardID, deductUSD.StringFixed(2))
+	log.Printf("✅ User %d: transferred %s %s to card %d (deducted %s %s from wallet)",
+		userID, amountInCardCurrency.StringFixed(2), cardCurrency, cardID, deduct.StringFixed(2), source)
 
 	return GetInternalBalance(userID)
 }
 
-// DeductInternalBalance — списать из Кошелька пользователя (вызывается Bridge при webhook).
-// Атомарно уменьшает master_balance и увеличивает spent_from_wallet на карте.
-func DeductInternalBalance(tx interface {
-	Exec(string, ...interface{}) (interface {
-		LastInsertId() (int64, error)
-		RowsAffected() (int64, error)
-	}, error)
-	QueryRow(string, ...interface{}) interface{ Scan(...interface{}) error }
-}, userID int, cardID int, amount decimal.Decimal) error {
-	// Уменьшаем master_balance
-	res := tx.QueryRow(
-		`UPDATE internal_balances SET master_balance = master_balance - $1, updated_at = NOW()
-		 WHERE user_id = $2 AND master_balance >= $1
-		 RETURNING master_balance`,
-		amount, userID,
-	)
-	var newBalance decimal.Decimal
-	if err := res.Scan(&newBalance); err != nil {
-		return fmt.Errorf("insufficient wallet balance or user not found")
+// finishCardTopUp — второй шаг пополнения карты. Транзакция txID и её проводка lines уже зафиксированы
+// со статусом PENDING; здесь вызывается эмитент (fund) — вне транзакции БД, как выпуск карт в card_issue_jobs.
+// При успехе транзакция становится APPROVED (n, если задано, ставится в очередь вместе с этим),
+// при отказе эмитента — сторнируется (reverseCardTopUp).
+// Если статус обновить не удалось, транзакция остаётся PENDING: деньги уже на карте, сверка учитывает её.
+func finishCardTopUp(txID int, entryType, details string, lines []ledger.Line, fund CardFundFunc, amount decimal.Decimal, currency string, n *domain.UserNotification) error {
+	if fund != nil {
+		if fundErr := fund(amount, currency); fundErr != nil {
+			if err := reverseCardTopUp(txID, entryType, details, lines); err != nil {
+				log.Printf("🚨 CRITICAL: card top-up tx %d rejected by issuer (%v), reversal failed: %v", txID, fundErr, err)
+			}
+			return fmt.Errorf("эмитент отклонил пополнение карты: %v", fundErr)
+		}
 	}
+	if err := approveCardTopUp(txID, n); err != nil {
+		log.Printf("⚠️  Card top-up tx %d funded at issuer but left PENDING: %v", txID, err)
+	}
+	return nil
+}
 
-	// Увеличиваем spent_from_wallet на карте
-	_, err := tx.Exec(
-		`UPDATE cards SET spent_from_wallet = COALESCE(spent_from_wallet, 0) + $1 WHERE id = $2`,
-		amount, cardID,
-	)
+// approveCardTopUp переводит пополнение txID в APPROVED и ставит уведомление n в очередь в той же транзакции.
+func approveCardTopUp(txID int, n *domain.UserNotification) error {
+	tx, err := GlobalDB.Begin()
 	if err != nil {
-		return fmt.Errorf("failed to update card spent_from_wallet: %w", err)
+		return err
 	}
+	defer tx.Rollback()
 
-	return nil
+	if _, err := tx.Exec(`UPDATE transactions SET status = 'APPROVED' WHERE id = $1 AND status = 'PENDING'`, txID); err != nil {
+		return err
+	}
+	if n != nil {
+		if err := EnqueueNotification(tx, *n); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
-// ReclaimExpiredCardLimits — возвращает неиспользованный остаток лимита
-// истёкших карт обратно в Кошелёк пользователя.
+// reverseCardTopUp сторнирует пополнение карты, отклонённое эмитентом: транзакция txID становится FAILED,
+// проводка lines проводится в обратную сторону, операция Кошелька команды (если была) удаляется —
+// лимиты участника её больше не учитывают.
+func reverseCardTopUp(txID int, entryType, details string, lines []ledger.Line) error {
+	tx, err := GlobalDB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE transactions SET status = 'FAILED' WHERE id = $1 AND status = 'PENDING'`, txID)
+	if err != nil {
+		return fmt.Errorf("failed to mark transaction failed: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("transaction %d is no longer pending", txID)
+	}
+	reversal := make([]ledger.Line, len(lines))
+	for i, l := range lines {
+		if l.Side == ledger.SideDebit {
+			l.Side = ledger.SideCredit
+		} else {
+			l.Side = ledger.SideDebit
+		}
+		reversal[i] = l
+	}
+	_, err = ledger.Post(tx, ledger.Entry{
+		Type:          entryType + "_REVERSAL",
+		TransactionID: txID,
+		Description:   "Reversal (issuer declined): " + details,
+		Lines:         reversal,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM team_wallet_operations WHERE transaction_id = $1`, txID); err != nil {
+		return fmt.Errorf("failed to drop team wallet operation: %w", err)
+	}
+	return tx.Commit()
+}
+
+// ReclaimExpiredCardLimits — закрывает истёкшие карты: неиспользованный лимит
+// списания из Кошелька обнуляется, а реальный остаток card_balance
+// возвращается в Кошелёк проводкой карта → Кошелёк.
+// Лимит (spending_limit) не резервирует деньги, поэтому сам по себе в Кошелёк не зачисляется.
 func ReclaimExpiredCardLimits() {
 	if GlobalDB == nil {
 		log.Println("[EXPIRY-RECLAIM] Database not initialized, skipping")
@@ -426,14 +713,14 @@ func ReclaimExpiredCardLimits() {
 
 	log.Println("[EXPIRY-RECLAIM] Checking for expired cards...")
 
-	// Находим карты с expiry_date в прошлом, у которых есть неиспользованный остаток лимита
+	// Находим карты с expiry_date в прошлом, у которых остался лимит или баланс
 	query := `
-		SELECT c.id, c.user_id, c.spending_limit, COALESCE(c.spent_from_wallet, 0), c.last_4_digits
+		SELECT c.id, c.user_id, c.last_4_digits
 		FROM cards c
 		WHERE c.expiry_date IS NOT NULL
 		  AND c.expiry_date < NOW()
 		  AND c.card_status != 'RECLAIMED'
-		  AND COALESCE(c.spending_limit, 0) > COALESCE(c.spent_from_wallet, 0)
+		  AND (COALESCE(c.spending_limit, 0) > COALESCE(c.spent_from_wallet, 0) OR COALESCE(c.card_balance, 0) > 0)
 	`
 
 	rows, err := GlobalDB.Query(query)
@@ -441,76 +728,95 @@ func ReclaimExpiredCardLimits() {
 		log.Printf("[EXPIRY-RECLAIM] Error querying expired cards: %v", err)
 		return
 	}
-	defer rows.Close()
-
-	var reclaimed int
+	type expiredCard struct {
+		cardID, userID int
+		last4          string
+	}
+	var expired []expiredCard
 	for rows.Next() {
-		var cardID, userID int
-		var spendingLimit, spentFromWallet decimal.Decimal
-		var last4 string
-
-		if err := rows.Scan(&cardID, &userID, &spendingLimit, &spentFromWallet, &last4); err != nil {
+		var c expiredCard
+		if err := rows.Scan(&c.cardID, &c.userID, &c.last4); err != nil {
 			log.Printf("[EXPIRY-RECLAIM] Error scanning card: %v", err)
 			continue
 		}
+		expired = append(expired, c)
+	}
+	rows.Close()
 
-		remainder := spendingLimit.Sub(spentFromWallet)
-		if remainder.LessThanOrEqual(decimal.Zero) {
+	var reclaimed int
+	for _, c := range expired {
+		if err := reclaimExpiredCard(c.cardID, c.userID, c.last4); err != nil {
+			log.Printf("[EXPIRY-RECLAIM] Card %d: %v", c.cardID, err)
 			continue
 		}
+		reclaimed++
+	}
 
-		// Атомарно: вернуть остаток в Кошелёк + пометить карту как RECLAIMED
-		tx, err := GlobalDB.Begin()
-		if err != nil {
-			log.Printf("[EXPIRY-RECLAIM] Error beginning tx: %v", err)
-			continue
-		}
+	if reclaimed > 0 {
+		log.Printf("[EXPIRY-RECLAIM] Reclaimed %d expired cards", reclaimed)
+	} else {
+		log.Println("[EXPIRY-RECLAIM] No expired cards to reclaim")
+	}
+}
 
-		_, err = tx.Exec(
-			`UPDATE internal_balances SET master_balance = master_balance + $1, updated_at = NOW() WHERE user_id = $2`,
-			remainder, userID,
-		)
-		if err != nil {
-			tx.Rollback()
-			log.Printf("[EXPIRY-RECLAIM] Error returning to wallet: %v", err)
-			continue
-		}
+// reclaimExpiredCard — атомарно: вернуть остаток карты в Кошелёк + пометить карту как RECLAIMED.
+func reclaimExpiredCard(cardID, userID int, last4 string) error {
+	tx, err := GlobalDB.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
 
-		_, err = tx.Exec(
-			`UPDATE cards SET card_status = 'RECLAIMED', spending_limit = spent_from_wallet WHERE id = $1`,
-			cardID,
-		)
-		if err != nil {
-			tx.Rollback()
-			log.Printf("[EXPIRY-RECLAIM] Error marking card reclaimed: %v", err)
-			continue
-		}
+	var cardBalance decimal.Decimal
+	var currency string
+	err = tx.QueryRow(
+		`SELECT COALESCE(card_balance, 0), COALESCE(currency, 'USD') FROM cards WHERE id = $1 AND card_status != 'RECLAIMED' FOR UPDATE`,
+		cardID,
+	).Scan(&cardBalance, &currency)
+	if err != nil {
+		return fmt.Errorf("lock card: %w", err)
+	}
+	if currency, err = NormalizeWalletCurrency(currency); err != nil {
+		return fmt.Errorf("card currency: %w", err)
+	}
 
-		_, err = tx.Exec(
-			`INSERT INTO transactions (user_id, card_id, amount, fee, transaction_type, status, details, executed_at)
-			 VALUES ($1, $2, $3, 0, 'WALLET_RECLAIM', 'APPROVED', $4, $5)`,
-			userID, cardID, remainder,
-			fmt.Sprintf("Expired card ...%s: reclaimed %s back to wallet", last4, remainder.String()),
-			time.Now(),
-		)
+	// Остаток возвращается в Кошелёк той же валюты, что и карта
+	if cardBalance.GreaterThan(decimal.Zero) {
+		var txID int
+		err = tx.QueryRow(
+			`INSERT INTO transactions (user_id, card_id, amount, fee, transaction_type, status, details, currency, wallet_currency, executed_at)
+			 VALUES ($1, $2, $3, 0, 'WALLET_RECLAIM', 'APPROVED', $4, $5, $5, $6) RETURNING id`,
+			userID, cardID, cardBalance,
+			fmt.Sprintf("Expired card ...%s: reclaimed %s %s back to wallet", last4, cardBalance.String(), currency),
+			currency, time.Now(),
+		).Scan(&txID)
 		if err != nil {
-			tx.Rollback()
-			log.Printf("[EXPIRY-RECLAIM] Error recording tx: %v", err)
-			continue
+			return fmt.Errorf("record tx: %w", err)
 		}
 
-		if err := tx.Commit(); err != nil {
-			log.Printf("[EXPIRY-RECLAIM] Error committing: %v", err)
-			continue
+		_, err = ledger.Post(tx, ledger.Entry{
+			Type:          "WALLET_RECLAIM",
+			TransactionID: txID,
+			Description:   "Expired card balance reclaim",
+			Lines:         ledger.Move(ledger.Card(cardID), ledger.UserWallet(userID), cardBalance, currency),
+		})
+		if err != nil {
+			return fmt.Errorf("return to wallet: %w", err)
 		}
+	}
 
-		log.Printf("✅ [EXPIRY-RECLAIM] Card %d (user %d): reclaimed %s back to wallet", cardID, userID, remainder.String())
-		reclaimed++
+	_, err = tx.Exec(
+		`UPDATE cards SET card_status = 'RECLAIMED', spending_limit = COALESCE(spent_from_wallet, 0) WHERE id = $1`,
+		cardID,
+	)
+	if err != nil {
+		return fmt.Errorf("mark card reclaimed: %w", err)
 	}
 
-	if reclaimed > 0 {
-		log.Printf("[EXPIRY-RECLAIM] Reclaimed %d expired cards", reclaimed)
-	} else {
-		log.Println("[EXPIRY-RECLAIM] No expired cards to reclaim")
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
 	}
+
+	log.Printf("✅ [EXPIRY-RECLAIM] Card %d (user %d): reclaimed %s %s back to wallet", cardID, userID, cardBalance.String(), currency)
+	return nil
 }
@@ -0,0 +1,156 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/djalben/xplr-core/backend/ledger"
+	"github.com/shopspring/decimal"
+)
+
+// EnsureLedgerTables creates the double-entry journal tables (ledger_entries, ledger_postings).
+func EnsureLedgerTables() error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	if err := ledger.EnsureTables(GlobalDB); err != nil {
+		log.Printf("[LEDGER] Error creating ledger tables: %v", err)
+		return err
+	}
+	log.Println("[LEDGER] ✅ ledger tables ensured")
+	return nil
+}
+
+// LedgerAccountBalance — остаток клиентского счёта (Кошелёк или карта) в одной валюте:
+// сохранённый баланс и баланс по журналу.
+type LedgerAccountBalance struct {
+	Account  ledger.Account
+	UserID   int // владелец; для карты — cards.user_id
+	Currency string
+	Stored   decimal.Decimal // internal_balances.master_balance, wallet_balances.balance или cards.card_balance
+	Journal  decimal.Decimal // ledger_postings: кредит минус дебет (оба счёта — обязательства)
+}
+
+// ledgerAccountBalancesQuery сопоставляет сохранённые балансы с журналом по коду счёта (ledger.Account.Code).
+// FULL JOIN: счёт, который есть только в журнале (или только в таблице балансов), тоже попадает в выборку.
+const ledgerAccountBalancesQuery = `
+	WITH stored AS (
+		SELECT 'user_wallet' AS account_type, 'user_wallet:' || user_id AS account_code, user_id, 0 AS card_id,
+		       'USD' AS currency, COALESCE(master_balance, 0) AS balance
+		FROM internal_balances
+		UNION ALL
+		SELECT 'user_wallet', 'user_wallet:' || user_id, user_id, 0, currency, balance
+		FROM wallet_balances WHERE currency <> 'USD'
+		UNION ALL
+		SELECT 'card', 'card:' || id, user_id, id, COALESCE(currency, 'USD'), COALESCE(card_balance, 0)
+		FROM cards
+	), journal AS (
+		SELECT account_type, account_code, MAX(COALESCE(user_id, 0)) AS user_id, MAX(COALESCE(card_id, 0)) AS card_id,
+		       currency, SUM(CASE WHEN side = 'C' THEN amount ELSE -amount END) AS balance
+		FROM ledger_postings
+		WHERE account_type IN ('user_wallet', 'card')
+		GROUP BY account_type, account_code, currency
+	)
+	SELECT COALESCE(s.account_type, j.account_type), COALESCE(s.user_id, j.user_id), COALESCE(s.card_id, j.card_id),
+	       COALESCE(s.currency, j.currency), COALESCE(s.balance, 0), COALESCE(j.balance, 0)
+	FROM stored s
+	FULL OUTER JOIN journal j ON j.account_code = s.account_code AND j.currency = s.currency`
+
+// GetLedgerAccountBalances returns the stored and journal balance of every wallet and card account.
+func GetLedgerAccountBalances() ([]LedgerAccountBalance, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	return ledgerAccountBalances(GlobalDB)
+}
+
+func ledgerAccountBalances(q interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}) ([]LedgerAccountBalance, error) {
+	rows, err := q.Query(ledgerAccountBalancesQuery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ledger balances: %w", err)
+	}
+	defer rows.Close()
+
+	var out []LedgerAccountBalance
+	for rows.Next() {
+		var b LedgerAccountBalance
+		var accountType string
+		var cardID int
+		if err := rows.Scan(&accountType, &b.UserID, &cardID, &b.Currency, &b.Stored, &b.Journal); err != nil {
+			return nil, err
+		}
+		if accountType == string(ledger.AccountCard) {
+			b.Account = ledger.Card(cardID)
+		} else {
+			b.Account = ledger.UserWallet(b.UserID)
+		}
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// ledgerOpeningSetting — отметка в system_settings о том, что начальные остатки уже перенесены в журнал.
+const ledgerOpeningSetting = "ledger_opening_balances_posted"
+
+// PostLedgerOpeningBalances переносит в журнал остатки, накопленные до его появления: для каждого
+// Кошелька и карты разница между сохранённым балансом и журналом проводится как OPENING_BALANCE
+// против счёта Suspense. Балансы при этом не меняются (ledger.Record). Выполняется один раз:
+// отметка в system_settings ставится в той же транзакции, поэтому позже найденные расхождения
+// остаются расхождениями и попадают в сверку, а не в начальные остатки.
+// Вызывается после создания wallet_balances и cards.
+func PostLedgerOpeningBalances() error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	tx, err := GlobalDB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Второй экземпляр ждёт на уникальном ключе и получает 0 строк
+	res, err := tx.Exec(
+		`INSERT INTO system_settings (setting_key, setting_value, description)
+		 VALUES ($1, NOW()::text, 'Когда начальные остатки Кошельков и карт перенесены в журнал (OPENING_BALANCE)')
+		 ON CONFLICT (setting_key) DO NOTHING`, ledgerOpeningSetting,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to mark opening balances: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+
+	balances, err := ledgerAccountBalances(tx)
+	if err != nil {
+		return err
+	}
+	posted := 0
+	for _, b := range balances {
+		diff := b.Stored.Sub(b.Journal)
+		if diff.IsZero() {
+			continue
+		}
+		lines := ledger.Move(ledger.Suspense, b.Account, diff, b.Currency)
+		if diff.IsNegative() {
+			lines = ledger.Move(b.Account, ledger.Suspense, diff.Neg(), b.Currency)
+		}
+		_, err := ledger.Record(tx, ledger.Entry{
+			Type:        "OPENING_BALANCE",
+			Description: fmt.Sprintf("Opening balance of %s: %s %s", b.Account.Code(), diff.String(), b.Currency),
+			Lines:       lines,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to post opening balance of %s: %w", b.Account.Code(), err)
+		}
+		posted++
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit opening balances: %w", err)
+	}
+	log.Printf("[LEDGER] ✅ Opening balances posted for %d accounts", posted)
+	return nil
+}
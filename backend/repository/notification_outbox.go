@@ -0,0 +1,343 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/domain"
+)
+
+// notificationLockTTL — на сколько dispatcher захватывает пачку уведомлений; по истечении
+// незавершённые уведомления (например, после рестарта процесса) снова доступны для отправки.
+const notificationLockTTL = 5 * time.Minute
+
+var (
+	ErrNotificationNotFound = errors.New("notification not found")
+	// ErrNotificationNotFailed — повторно отправить можно только уведомление с исчерпанными попытками.
+	ErrNotificationNotFailed = errors.New("only failed notifications can be resent")
+)
+
+// EnsureNotificationOutboxTables creates notification_outbox (one row per user notification and
+// channel, written in the same transaction as the business event), notification_deliveries
+// (the per-user log of every delivery attempt) and users.language used to render templates.
+func EnsureNotificationOutboxTables() error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	_, err := GlobalDB.Exec(`
+		CREATE TABLE IF NOT EXISTS notification_outbox (
+			id              SERIAL PRIMARY KEY,
+			user_id         INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			channel         VARCHAR(20) NOT NULL,
+			subject         TEXT NOT NULL DEFAULT '',
+			body            TEXT NOT NULL,
+			image_url       TEXT NOT NULL DEFAULT '',
+			status          VARCHAR(20) NOT NULL DEFAULT 'PENDING',
+			attempts        INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			locked_until    TIMESTAMPTZ,
+			last_error      TEXT NOT NULL DEFAULT '',
+			created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			sent_at         TIMESTAMPTZ
+		);
+		CREATE INDEX IF NOT EXISTS idx_notification_outbox_due ON notification_outbox(channel, next_attempt_at) WHERE status = 'PENDING';
+		CREATE INDEX IF NOT EXISTS idx_notification_outbox_user ON notification_outbox(user_id, id DESC);
+		CREATE INDEX IF NOT EXISTS idx_notification_outbox_status ON notification_outbox(status, id DESC);
+
+		CREATE TABLE IF NOT EXISTS notification_deliveries (
+			id              SERIAL PRIMARY KEY,
+			notification_id INTEGER NOT NULL REFERENCES notification_outbox(id) ON DELETE CASCADE,
+			user_id         INTEGER NOT NULL,
+			channel         VARCHAR(20) NOT NULL,
+			attempt         INTEGER NOT NULL,
+			status          VARCHAR(20) NOT NULL,
+			recipient       TEXT NOT NULL DEFAULT '',
+			error           TEXT NOT NULL DEFAULT '',
+			created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_notification_deliveries_user ON notification_deliveries(user_id, id DESC);
+		CREATE INDEX IF NOT EXISTS idx_notification_deliveries_notification ON notification_deliveries(notification_id);
+
+		-- Шаблонные уведомления: текст собирается при отправке на языке пользователя
+		ALTER TABLE notification_outbox ADD COLUMN IF NOT EXISTS template TEXT NOT NULL DEFAULT '';
+		ALTER TABLE notification_outbox ADD COLUMN IF NOT EXISTS data JSONB;
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS language VARCHAR(5) NOT NULL DEFAULT 'ru';
+		-- Событие (notification_event_prefs) и отложенные до суточной сводки уведомления
+		ALTER TABLE notification_outbox ADD COLUMN IF NOT EXISTS event VARCHAR(30) NOT NULL DEFAULT '';
+		ALTER TABLE notification_outbox ADD COLUMN IF NOT EXISTS digest BOOLEAN NOT NULL DEFAULT FALSE;
+
+		ALTER TABLE IF EXISTS notification_outbox DISABLE ROW LEVEL SECURITY;
+		ALTER TABLE IF EXISTS notification_deliveries DISABLE ROW LEVEL SECURITY;
+	`)
+	if err != nil {
+		log.Printf("[NOTIFY-OUTBOX] Error ensuring tables: %v", err)
+		return err
+	}
+	log.Println("[NOTIFY-OUTBOX] ✅ notification_outbox and notification_deliveries tables ensured")
+	return nil
+}
+
+// notificationChannels — каналы по users.notification_pref: 'email', 'telegram' или 'both'.
+// Пустое или неизвестное значение — оба канала.
+func notificationChannels(pref string) []string {
+	switch pref {
+	case "email":
+		return []string{domain.NotificationChannelEmail}
+	case "telegram":
+		return []string{domain.NotificationChannelTelegram}
+	default:
+		return []string{domain.NotificationChannelEmail, domain.NotificationChannelTelegram}
+	}
+}
+
+// EnqueueNotification ставит уведомление в notification_outbox — по строке на каждый канал,
+// включённый у пользователя для события. q — транзакция бизнес-события: уведомление фиксируется
+// вместе с ним или не фиксируется вовсе. Операция на сумму меньше порога сводки откладывается
+// до суточной сводки. Отправляет dispatcher (usecase.ProcessNotificationOutbox).
+func EnqueueNotification(q interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, n domain.UserNotification) error {
+	if n.Event == "" {
+		n.Event = domain.NotificationTemplateEvents[n.Template]
+	}
+	channels, digestBelow, err := loadNotificationSettings(q, n.UserID, n.Event)
+	if err != nil {
+		return err
+	}
+	digest := n.Amount.IsPositive() && digestBelow.IsPositive() && n.Amount.LessThan(digestBelow)
+	nextAttemptAt := time.Now()
+	if digest {
+		nextAttemptAt = NextNotificationDigestAt(nextAttemptAt)
+	}
+	var data sql.NullString
+	if n.Template != "" {
+		raw, err := json.Marshal(n.Data)
+		if err != nil {
+			return fmt.Errorf("invalid notification data: %w", err)
+		}
+		data = sql.NullString{String: string(raw), Valid: true}
+	}
+	for _, channel := range channels {
+		body, imageURL := n.EmailHTML, ""
+		if channel == domain.NotificationChannelTelegram {
+			imageURL = n.ImageURL
+			if n.TelegramHTML != "" {
+				body = n.TelegramHTML
+			}
+		}
+		if n.Template == "" && strings.TrimSpace(body) == "" {
+			continue
+		}
+		if _, err := q.Exec(
+			`INSERT INTO notification_outbox (user_id, channel, event, digest, template, data, subject, body, image_url, next_attempt_at)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+			n.UserID, channel, n.Event, digest, n.Template, data, n.Subject, body, imageURL, nextAttemptAt,
+		); err != nil {
+			return fmt.Errorf("failed to enqueue notification: %w", err)
+		}
+	}
+	return nil
+}
+
+// EnqueueUserNotification ставит уведомление в очередь вне бизнес-транзакции.
+func EnqueueUserNotification(n domain.UserNotification) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	tx, err := GlobalDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := EnqueueNotification(tx, n); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const outboxNotificationColumns = `id, user_id, channel, event, digest, template, data, subject, body, image_url, status, attempts,
+	next_attempt_at, last_error, created_at, sent_at`
+
+func scanOutboxNotifications(rows *sql.Rows) ([]domain.OutboxNotification, error) {
+	defer rows.Close()
+	list := []domain.OutboxNotification{}
+	for rows.Next() {
+		var n domain.OutboxNotification
+		var sentAt sql.NullTime
+		var data []byte
+		if err := rows.Scan(&n.ID, &n.UserID, &n.Channel, &n.Event, &n.Digest, &n.Template, &data, &n.Subject, &n.Body, &n.ImageURL, &n.Status, &n.Attempts,
+			&n.NextAttemptAt, &n.LastError, &n.CreatedAt, &sentAt); err != nil {
+			return nil, err
+		}
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &n.Data); err != nil {
+				return nil, fmt.Errorf("notification #%d: invalid data: %w", n.ID, err)
+			}
+		}
+		if sentAt.Valid {
+			n.SentAt = &sentAt.Time
+		}
+		list = append(list, n)
+	}
+	return list, rows.Err()
+}
+
+// ClaimDueNotifications locks up to limit pending notifications of the channel whose next attempt
+// is due, so that concurrent dispatchers never send the same notification twice. digest selects
+// notifications held for the daily digest instead of regular ones; they come grouped by user.
+func ClaimDueNotifications(channel string, digest bool, limit int) ([]domain.OutboxNotification, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	rows, err := GlobalDB.Query(`
+		WITH claimed AS (
+			UPDATE notification_outbox SET locked_until = NOW() + $4::interval
+			WHERE id IN (
+				SELECT id FROM notification_outbox
+				WHERE status = 'PENDING' AND channel = $1 AND digest = $2 AND next_attempt_at <= NOW()
+				  AND (locked_until IS NULL OR locked_until < NOW())
+				ORDER BY next_attempt_at, id
+				LIMIT $3
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING `+outboxNotificationColumns+`
+		)
+		SELECT `+outboxNotificationColumns+` FROM claimed ORDER BY user_id, id`,
+		channel, digest, limit, fmt.Sprintf("%d seconds", int(notificationLockTTL.Seconds())))
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim notifications: %w", err)
+	}
+	return scanOutboxNotifications(rows)
+}
+
+// FinishNotificationAttempt logs a delivery attempt and stores its outcome in the outbox.
+// status is the new outbox status: PENDING means the attempt failed and will be retried at
+// nextAttemptAt; SENT, FAILED and SKIPPED are final.
+func FinishNotificationAttempt(n *domain.OutboxNotification, status, recipient, errMsg string, nextAttemptAt time.Time) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	attemptStatus := status
+	if status == domain.NotificationPending {
+		attemptStatus = domain.NotificationFailed
+	}
+	tx, err := GlobalDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(
+		`INSERT INTO notification_deliveries (notification_id, user_id, channel, attempt, status, recipient, error)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		n.ID, n.UserID, n.Channel, n.Attempts+1, attemptStatus, recipient, errMsg,
+	); err != nil {
+		return fmt.Errorf("failed to log delivery attempt: %w", err)
+	}
+	if _, err := tx.Exec(`
+		UPDATE notification_outbox
+		SET status = $2, attempts = attempts + 1, last_error = $3, next_attempt_at = $4, locked_until = NULL,
+		    sent_at = CASE WHEN $2 = 'SENT' THEN NOW() ELSE sent_at END
+		WHERE id = $1`,
+		n.ID, status, errMsg, nextAttemptAt,
+	); err != nil {
+		return fmt.Errorf("failed to update notification: %w", err)
+	}
+	return tx.Commit()
+}
+
+// ListNotifications — уведомления очереди, новые сверху. Пустые status/channel и userID = 0 — без фильтра.
+func ListNotifications(status, channel string, userID, limit int) ([]domain.OutboxNotification, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	rows, err := GlobalDB.Query(`
+		SELECT `+outboxNotificationColumns+` FROM notification_outbox
+		WHERE ($1 = '' OR status = $1) AND ($2 = '' OR channel = $2) AND ($3 = 0 OR user_id = $3)
+		ORDER BY id DESC LIMIT $4`,
+		strings.ToUpper(status), strings.ToLower(channel), userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanOutboxNotifications(rows)
+}
+
+// ListUserNotificationDeliveries — журнал доставки уведомлений пользователя, новые сверху.
+func ListUserNotificationDeliveries(userID, limit int) ([]domain.NotificationDelivery, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	rows, err := GlobalDB.Query(`
+		SELECT d.id, d.notification_id, d.user_id, d.channel, COALESCE(o.subject, ''), d.attempt, d.status,
+		       d.recipient, d.error, d.created_at
+		FROM notification_deliveries d
+		LEFT JOIN notification_outbox o ON o.id = d.notification_id
+		WHERE d.user_id = $1
+		ORDER BY d.id DESC LIMIT $2`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []domain.NotificationDelivery{}
+	for rows.Next() {
+		var d domain.NotificationDelivery
+		if err := rows.Scan(&d.ID, &d.NotificationID, &d.UserID, &d.Channel, &d.Subject, &d.Attempt, &d.Status,
+			&d.Recipient, &d.Error, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, d)
+	}
+	return list, rows.Err()
+}
+
+// ResendNotification возвращает уведомление с исчерпанными попытками в очередь с нулевым счётчиком попыток.
+func ResendNotification(id int) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	res, err := GlobalDB.Exec(`
+		UPDATE notification_outbox
+		SET status = 'PENDING', attempts = 0, next_attempt_at = NOW(), locked_until = NULL
+		WHERE id = $1 AND status = 'FAILED'`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+	var exists bool
+	if err := GlobalDB.QueryRow(`SELECT EXISTS(SELECT 1 FROM notification_outbox WHERE id = $1)`, id).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotificationNotFound
+	}
+	return ErrNotificationNotFailed
+}
+
+// ResendFailedNotifications возвращает в очередь все уведомления с исчерпанными попытками
+// (userID > 0 — только этого пользователя). Возвращает их количество.
+func ResendFailedNotifications(userID int) (int64, error) {
+	if GlobalDB == nil {
+		return 0, fmt.Errorf("database connection not initialized")
+	}
+	res, err := GlobalDB.Exec(`
+		UPDATE notification_outbox
+		SET status = 'PENDING', attempts = 0, next_attempt_at = NOW(), locked_until = NULL
+		WHERE status = 'FAILED' AND ($1 = 0 OR user_id = $1)`, userID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
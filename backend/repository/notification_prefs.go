@@ -0,0 +1,206 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/shopspring/decimal"
+)
+
+// NotificationDigestHourUTC — час (UTC), в который отправляется суточная сводка мелких операций.
+const NotificationDigestHourUTC = 9
+
+// ErrInvalidNotificationPref — неизвестное событие, канал или отрицательный порог сводки.
+var ErrInvalidNotificationPref = errors.New("invalid notification preference")
+
+// EnsureNotificationEventPrefTables creates notification_event_prefs — per-user channel choice
+// and digest threshold for each notification event type.
+func EnsureNotificationEventPrefTables() error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	_, err := GlobalDB.Exec(`
+		CREATE TABLE IF NOT EXISTS notification_event_prefs (
+			user_id      INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			event        VARCHAR(30) NOT NULL,
+			channels     VARCHAR(20) NOT NULL DEFAULT 'default',
+			digest_below NUMERIC(20,4) NOT NULL DEFAULT 0,
+			updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (user_id, event)
+		);
+		ALTER TABLE IF EXISTS notification_event_prefs DISABLE ROW LEVEL SECURITY;
+	`)
+	if err != nil {
+		log.Printf("[NOTIFY-PREFS] Error ensuring tables: %v", err)
+		return err
+	}
+	log.Println("[NOTIFY-PREFS] ✅ notification_event_prefs table ensured")
+	return nil
+}
+
+// notificationEventChannels — каналы уведомления о событии event. Настройка события (email, telegram,
+// both, off) важнее остального; без неё старые флажки notify_* выключают свои события, а каналы
+// берутся из общего notification_pref. Уведомления без события идут по notification_pref.
+func notificationEventChannels(event, globalPref, eventPref string, legacy NotificationPrefs) []string {
+	if event != "" {
+		switch eventPref {
+		case domain.NotificationPrefOff:
+			return nil
+		case "email", "telegram", "both":
+			return notificationChannels(eventPref)
+		}
+		switch event {
+		case domain.NotificationEventTransactions, domain.NotificationEventDeclines:
+			if !legacy.NotifyTransactions {
+				return nil
+			}
+		case domain.NotificationEventTopUps:
+			if !legacy.NotifyBalance {
+				return nil
+			}
+		case domain.NotificationEventSecurity:
+			if !legacy.NotifySecurity {
+				return nil
+			}
+		}
+	}
+	return notificationChannels(globalPref)
+}
+
+// loadNotificationSettings — каналы и порог сводки уведомлений пользователя о событии.
+func loadNotificationSettings(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, userID int, event string) ([]string, decimal.Decimal, error) {
+	var pref, eventPref string
+	var legacy NotificationPrefs
+	var digestBelow decimal.Decimal
+	err := q.QueryRow(`
+		SELECT COALESCE(u.notification_pref, 'both'), COALESCE(u.notify_transactions, TRUE),
+		       COALESCE(u.notify_balance, TRUE), COALESCE(u.notify_security, TRUE),
+		       COALESCE(p.channels, ''), COALESCE(p.digest_below, 0)
+		FROM users u
+		LEFT JOIN notification_event_prefs p ON p.user_id = u.id AND p.event = $2
+		WHERE u.id = $1`, userID, event,
+	).Scan(&pref, &legacy.NotifyTransactions, &legacy.NotifyBalance, &legacy.NotifySecurity, &eventPref, &digestBelow)
+	if err != nil {
+		return nil, decimal.Zero, fmt.Errorf("notification recipient %d: %w", userID, err)
+	}
+	return notificationEventChannels(event, pref, eventPref, legacy), digestBelow, nil
+}
+
+// NotificationChannelAllowed — разрешает ли пользователь сейчас уведомления о событии в канал.
+// Dispatcher проверяет перед отправкой: настройки могли измениться после постановки в очередь.
+func NotificationChannelAllowed(userID int, event, channel string) (bool, error) {
+	if GlobalDB == nil {
+		return false, fmt.Errorf("database connection not initialized")
+	}
+	channels, _, err := loadNotificationSettings(GlobalDB, userID, event)
+	if err != nil {
+		return false, err
+	}
+	for _, c := range channels {
+		if c == channel {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// NextNotificationDigestAt — ближайшее после now время отправки суточной сводки.
+func NextNotificationDigestAt(now time.Time) time.Time {
+	now = now.UTC()
+	next := time.Date(now.Year(), now.Month(), now.Day(), NotificationDigestHourUTC, 0, 0, 0, time.UTC)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// GetNotificationEventPrefs — настройки пользователя по всем событиям (без сохранённой — default).
+func GetNotificationEventPrefs(userID int) ([]domain.NotificationEventPref, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	rows, err := GlobalDB.Query(
+		`SELECT event, channels, digest_below FROM notification_event_prefs WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	saved := map[string]domain.NotificationEventPref{}
+	for rows.Next() {
+		var p domain.NotificationEventPref
+		if err := rows.Scan(&p.Event, &p.Channels, &p.DigestBelow); err != nil {
+			return nil, err
+		}
+		saved[p.Event] = p
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	prefs := make([]domain.NotificationEventPref, 0, len(domain.NotificationEvents))
+	for _, event := range domain.NotificationEvents {
+		p, ok := saved[event]
+		if !ok {
+			p = domain.NotificationEventPref{Event: event, Channels: domain.NotificationPrefDefault}
+		}
+		prefs = append(prefs, p)
+	}
+	return prefs, nil
+}
+
+// validNotificationEventPref проверяет настройку события и приводит channels к нижнему регистру.
+func validNotificationEventPref(p *domain.NotificationEventPref) error {
+	known := false
+	for _, e := range domain.NotificationEvents {
+		known = known || e == p.Event
+	}
+	if !known {
+		return fmt.Errorf("%w: unknown event %q", ErrInvalidNotificationPref, p.Event)
+	}
+	p.Channels = strings.ToLower(strings.TrimSpace(p.Channels))
+	switch p.Channels {
+	case "":
+		p.Channels = domain.NotificationPrefDefault
+	case domain.NotificationPrefDefault, domain.NotificationPrefOff, "email", "telegram", "both":
+	default:
+		return fmt.Errorf("%w: channels must be default, email, telegram, both or off", ErrInvalidNotificationPref)
+	}
+	if p.DigestBelow.IsNegative() {
+		return fmt.Errorf("%w: digest_below must not be negative", ErrInvalidNotificationPref)
+	}
+	return nil
+}
+
+// SetNotificationEventPrefs сохраняет настройки пользователя для перечисленных событий.
+func SetNotificationEventPrefs(userID int, prefs []domain.NotificationEventPref) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	for i := range prefs {
+		if err := validNotificationEventPref(&prefs[i]); err != nil {
+			return err
+		}
+	}
+	tx, err := GlobalDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, p := range prefs {
+		if _, err := tx.Exec(`
+			INSERT INTO notification_event_prefs (user_id, event, channels, digest_below, updated_at)
+			VALUES ($1, $2, $3, $4, NOW())
+			ON CONFLICT (user_id, event) DO UPDATE SET channels = $3, digest_below = $4, updated_at = NOW()`,
+			userID, p.Event, p.Channels, p.DigestBelow,
+		); err != nil {
+			return fmt.Errorf("failed to save notification preference: %w", err)
+		}
+	}
+	return tx.Commit()
+}
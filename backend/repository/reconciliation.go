@@ -0,0 +1,273 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/shopspring/decimal"
+)
+
+// TxAggregate — сумма одобренных транзакций, сгруппированная для сверки.
+type TxAggregate struct {
+	UserID         int
+	CardID         int // 0 — транзакция без карты
+	Type           string
+	HasProviderRef bool            // provider_tx_id заполнен (Bridge/внешние вебхуки)
+	Currency       string          // Валюта Кошелька (transactions.wallet_currency), "" — USD
+	Amount         decimal.Decimal // SUM(amount), в валюте Кошелька
+	CardAmount     decimal.Decimal // SUM(COALESCE(original_amount, amount)) — в валюте карты
+}
+
+// StoredWallet — сохранённый баланс Кошелька в одной валюте.
+type StoredWallet struct {
+	UserID        int
+	Currency      string // "" — USD (internal_balances.master_balance)
+	MasterBalance decimal.Decimal
+}
+
+// StoredCard — сохранённые балансы карты.
+type StoredCard struct {
+	CardID          int
+	UserID          int
+	CardBalance     decimal.Decimal
+	SpentFromWallet decimal.Decimal
+}
+
+// EnsureReconciliationTables creates reconciliation_runs and reconciliation_drifts if they don't exist.
+func EnsureReconciliationTables() error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	_, err := GlobalDB.Exec(`
+		CREATE TABLE IF NOT EXISTS reconciliation_runs (
+			id              SERIAL PRIMARY KEY,
+			started_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			finished_at     TIMESTAMPTZ,
+			wallets_checked INTEGER NOT NULL DEFAULT 0,
+			cards_checked   INTEGER NOT NULL DEFAULT 0,
+			drift_count     INTEGER NOT NULL DEFAULT 0
+		);
+
+		CREATE TABLE IF NOT EXISTS reconciliation_drifts (
+			id       SERIAL PRIMARY KEY,
+			run_id   INTEGER NOT NULL REFERENCES reconciliation_runs(id),
+			kind     TEXT NOT NULL,
+			user_id  INTEGER NOT NULL,
+			card_id  INTEGER,
+			expected NUMERIC(20,4) NOT NULL,
+			actual   NUMERIC(20,4) NOT NULL,
+			drift    NUMERIC(20,4) NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_reconciliation_drifts_run ON reconciliation_drifts(run_id);
+
+		ALTER TABLE IF EXISTS reconciliation_runs DISABLE ROW LEVEL SECURITY;
+		ALTER TABLE IF EXISTS reconciliation_drifts DISABLE ROW LEVEL SECURITY;
+	`)
+	if err != nil {
+		log.Printf("[RECONCILE] Error creating reconciliation tables: %v", err)
+		return err
+	}
+	log.Println("[RECONCILE] ✅ reconciliation tables ensured")
+	return nil
+}
+
+// GetTransactionAggregates returns approved transaction sums grouped by user, card, type, provider ref
+// and wallet currency. Pending card top-ups are included: their wallet debit is already committed
+// while the issuer is being called (a rejected one becomes FAILED and is reversed).
+func GetTransactionAggregates() ([]TxAggregate, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	rows, err := GlobalDB.Query(`
+		SELECT user_id, COALESCE(card_id, 0), transaction_type,
+		       COALESCE(provider_tx_id, '') <> '' AS has_ref, COALESCE(wallet_currency, 'USD'),
+		       COALESCE(SUM(amount), 0), COALESCE(SUM(COALESCE(original_amount, amount)), 0)
+		FROM transactions
+		WHERE status = 'APPROVED'
+		   OR (status = 'PENDING' AND transaction_type IN ('CARD_TOPUP', 'TEAM_CARD_TOPUP'))
+		GROUP BY 1, 2, 3, 4, 5`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate transactions: %w", err)
+	}
+	defer rows.Close()
+
+	var out []TxAggregate
+	for rows.Next() {
+		var a TxAggregate
+		if err := rows.Scan(&a.UserID, &a.CardID, &a.Type, &a.HasProviderRef, &a.Currency, &a.Amount, &a.CardAmount); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// GetStoredWallets returns master_balance for every USD wallet and the balance of every other currency wallet.
+func GetStoredWallets() ([]StoredWallet, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	rows, err := GlobalDB.Query(`
+		SELECT user_id, 'USD', COALESCE(master_balance, 0) FROM internal_balances
+		UNION ALL
+		SELECT user_id, currency, balance FROM wallet_balances`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load wallets: %w", err)
+	}
+	defer rows.Close()
+
+	var out []StoredWallet
+	for rows.Next() {
+		var w StoredWallet
+		if err := rows.Scan(&w.UserID, &w.Currency, &w.MasterBalance); err != nil {
+			return nil, err
+		}
+		out = append(out, w)
+	}
+	return out, rows.Err()
+}
+
+// GetStoredCards returns card_balance and spent_from_wallet for every card.
+func GetStoredCards() ([]StoredCard, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	rows, err := GlobalDB.Query(`SELECT id, user_id, COALESCE(card_balance, 0), COALESCE(spent_from_wallet, 0) FROM cards`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load cards: %w", err)
+	}
+	defer rows.Close()
+
+	var out []StoredCard
+	for rows.Next() {
+		var c StoredCard
+		if err := rows.Scan(&c.CardID, &c.UserID, &c.CardBalance, &c.SpentFromWallet); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// SaveReconciliationRun stores a finished run with its drift rows and fills in run.ID.
+func SaveReconciliationRun(run *domain.ReconciliationRun, drifts []domain.ReconciliationDrift) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	tx, err := GlobalDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(
+		`INSERT INTO reconciliation_runs (started_at, finished_at, wallets_checked, cards_checked, drift_count)
+		 VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		run.StartedAt, run.FinishedAt, run.WalletsChecked, run.CardsChecked, len(drifts),
+	).Scan(&run.ID)
+	if err != nil {
+		return fmt.Errorf("failed to save reconciliation run: %w", err)
+	}
+	run.DriftCount = len(drifts)
+
+	for i := range drifts {
+		d := &drifts[i]
+		d.RunID = run.ID
+		var cardID interface{}
+		if d.CardID > 0 {
+			cardID = d.CardID
+		}
+		err = tx.QueryRow(
+			`INSERT INTO reconciliation_drifts (run_id, kind, user_id, card_id, expected, actual, drift)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+			run.ID, d.Kind, d.UserID, cardID, d.Expected, d.Actual, d.Drift,
+		).Scan(&d.ID)
+		if err != nil {
+			return fmt.Errorf("failed to save drift: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// GetReconciliationRun returns a run with its drifts. runID = 0 means the latest run.
+// Returns nil run (no error) when there are no runs yet.
+func GetReconciliationRun(runID int) (*domain.ReconciliationRun, []domain.ReconciliationDrift, error) {
+	if GlobalDB == nil {
+		return nil, nil, fmt.Errorf("database connection not initialized")
+	}
+
+	var run domain.ReconciliationRun
+	var finishedAt sql.NullTime
+	query := `SELECT id, started_at, finished_at, wallets_checked, cards_checked, drift_count FROM reconciliation_runs `
+	var row *sql.Row
+	if runID > 0 {
+		row = GlobalDB.QueryRow(query+`WHERE id = $1`, runID)
+	} else {
+		row = GlobalDB.QueryRow(query + `ORDER BY id DESC LIMIT 1`)
+	}
+	err := row.Scan(&run.ID, &run.StartedAt, &finishedAt, &run.WalletsChecked, &run.CardsChecked, &run.DriftCount)
+	if err == sql.ErrNoRows {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load reconciliation run: %w", err)
+	}
+	if finishedAt.Valid {
+		t := finishedAt.Time
+		run.FinishedAt = &t
+	}
+
+	rows, err := GlobalDB.Query(
+		`SELECT id, run_id, kind, user_id, COALESCE(card_id, 0), expected, actual, drift
+		 FROM reconciliation_drifts WHERE run_id = $1 ORDER BY ABS(drift) DESC`, run.ID,
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load drifts: %w", err)
+	}
+	defer rows.Close()
+
+	drifts := []domain.ReconciliationDrift{}
+	for rows.Next() {
+		var d domain.ReconciliationDrift
+		if err := rows.Scan(&d.ID, &d.RunID, &d.Kind, &d.UserID, &d.CardID, &d.Expected, &d.Actual, &d.Drift); err != nil {
+			return nil, nil, err
+		}
+		drifts = append(drifts, d)
+	}
+	return &run, drifts, rows.Err()
+}
+
+// ListReconciliationRuns returns the most recent runs (without drifts).
+func ListReconciliationRuns(limit int) ([]domain.ReconciliationRun, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 30
+	}
+	rows, err := GlobalDB.Query(
+		`SELECT id, started_at, finished_at, wallets_checked, cards_checked, drift_count
+		 FROM reconciliation_runs ORDER BY id DESC LIMIT $1`, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list reconciliation runs: %w", err)
+	}
+	defer rows.Close()
+
+	runs := []domain.ReconciliationRun{}
+	for rows.Next() {
+		var run domain.ReconciliationRun
+		var finishedAt sql.NullTime
+		if err := rows.Scan(&run.ID, &run.StartedAt, &finishedAt, &run.WalletsChecked, &run.CardsChecked, &run.DriftCount); err != nil {
+			return nil, err
+		}
+		if finishedAt.Valid {
+			t := finishedAt.Time
+			run.FinishedAt = &t
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
@@ -7,8 +7,10 @@ import (
 	"log"
 	"math/big"
 	"strings"
+	"time"
 
 	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/ledger"
 	"github.com/djalben/xplr-core/backend/notification"
 	"github.com/shopspring/decimal"
 )
@@ -251,28 +253,34 @@ func CheckAndCreditReferralBonus(referredUserID int) {
 	}
 	defer tx.Rollback()
 
-	// Credit referrer balance
-	_, err = tx.Exec(
-		"UPDATE users SET balance_rub = COALESCE(balance_rub, 0) + $1, balance = COALESCE(balance, 0) + $1 WHERE id = $2",
-		bonus, referrerID,
-	)
-	if err != nil {
-		log.Printf("[REFERRAL-BONUS] ❌ Credit balance error: %v", err)
-		return
-	}
-
-	// Record transaction
-	_, err = tx.Exec(
-		`INSERT INTO transactions (user_id, amount, fee, transaction_type, status, details, executed_at)
-		 VALUES ($1, $2, 0, 'REFERRAL_BONUS', 'APPROVED', $3, NOW())`,
+	// Record transaction. provider_tx_id отличает зачисление в Кошелёк от старых начислений на users.balance_rub
+	var txID int
+	err = tx.QueryRow(
+		`INSERT INTO transactions (user_id, amount, fee, transaction_type, status, details, provider_tx_id, executed_at)
+		 VALUES ($1, $2, 0, 'REFERRAL_BONUS', 'APPROVED', $3, $4, NOW()) RETURNING id`,
 		referrerID, bonus,
 		fmt.Sprintf("Реферальный бонус $5 за пользователя #%d (все 3 условия выполнены)", referredUserID),
-	)
+		fmt.Sprintf("xplr-referral-%d-%d", referrerID, referredUserID),
+	).Scan(&txID)
 	if err != nil {
 		log.Printf("[REFERRAL-BONUS] ❌ Record tx error: %v", err)
 		return
 	}
 
+	// Ledger: bonus is accrued from platform revenue into referral payable and paid out to the referrer's wallet
+	if _, err := ledger.Post(tx, ledger.Entry{
+		Type:          "REFERRAL_BONUS",
+		TransactionID: txID,
+		Description:   fmt.Sprintf("Referral bonus for user #%d", referredUserID),
+		Lines: append(
+			ledger.Move(ledger.FeeRevenue, ledger.ReferralPayable, bonus, WalletCurrency),
+			ledger.Move(ledger.ReferralPayable, ledger.UserWallet(referrerID), bonus, WalletCurrency)...,
+		),
+	}); err != nil {
+		log.Printf("[REFERRAL-BONUS] ❌ Ledger error: %v", err)
+		return
+	}
+
 	// Mark referral as REWARDED
 	_, err = tx.Exec(
 		`UPDATE referrals SET status = 'REWARDED', commission_earned = COALESCE(commission_earned, 0) + $1 
@@ -344,26 +352,32 @@ func CreditRevShare(referrerID int, sourceUserID int, sourceAmount decimal.Decim
 	}
 	defer tx.Rollback()
 
-	// 1. Credit referrer balance atomically
-	_, err = tx.Exec(
-		"UPDATE users SET balance_rub = COALESCE(balance_rub, 0) + $1, balance = COALESCE(balance, 0) + $1 WHERE id = $2",
-		commission, referrerID,
-	)
-	if err != nil {
-		return fmt.Errorf("credit balance: %v", err)
-	}
-
-	// 2. Record REFERRAL_REVENUE transaction
-	_, err = tx.Exec(
-		`INSERT INTO transactions (user_id, amount, fee, transaction_type, status, details, executed_at)
-		 VALUES ($1, $2, 0, 'REFERRAL_REVENUE', 'APPROVED', $3, NOW())`,
+	// 1. Record REFERRAL_REVENUE transaction (provider_tx_id — зачисление в Кошелёк, см. CheckAndCreditReferralBonus)
+	var txID int
+	err = tx.QueryRow(
+		`INSERT INTO transactions (user_id, amount, fee, transaction_type, status, details, provider_tx_id, executed_at)
+		 VALUES ($1, $2, 0, 'REFERRAL_REVENUE', 'APPROVED', $3, $4, NOW()) RETURNING id`,
 		referrerID, commission,
 		fmt.Sprintf("RevShare 5%%: %s (from user %d)", description, sourceUserID),
-	)
+		fmt.Sprintf("xplr-revshare-%d", time.Now().UnixNano()),
+	).Scan(&txID)
 	if err != nil {
 		return fmt.Errorf("record tx: %v", err)
 	}
 
+	// 2. Ledger: 5% of the fee moves from platform revenue through referral payable to the referrer's wallet
+	if _, err := ledger.Post(tx, ledger.Entry{
+		Type:          "REFERRAL_REVENUE",
+		TransactionID: txID,
+		Description:   description,
+		Lines: append(
+			ledger.Move(ledger.FeeRevenue, ledger.ReferralPayable, commission, WalletCurrency),
+			ledger.Move(ledger.ReferralPayable, ledger.UserWallet(referrerID), commission, WalletCurrency)...,
+		),
+	}); err != nil {
+		return fmt.Errorf("ledger: %v", err)
+	}
+
 	// 3. Update commission_earned in referrals table
 	_, err = tx.Exec(
 		`UPDATE referrals SET commission_earned = COALESCE(commission_earned, 0) + $1 WHERE referrer_id = $2 AND referred_id = $3`,
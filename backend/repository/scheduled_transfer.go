@@ -0,0 +1,322 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/domain"
+)
+
+// scheduledTransferLockTTL — на сколько воркер захватывает правило; брошенный захват истекает сам.
+const scheduledTransferLockTTL = 10 * time.Minute
+
+// EnsureScheduledTransferTables creates scheduled_transfers and scheduled_transfer_runs.
+// A rule moves money from the owner's wallet to one card or, split evenly, to the team's
+// cards on a cron schedule; every card transfer attempt is logged in scheduled_transfer_runs.
+func EnsureScheduledTransferTables() error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	_, err := GlobalDB.Exec(`
+		CREATE TABLE IF NOT EXISTS scheduled_transfers (
+			id              SERIAL PRIMARY KEY,
+			user_id         INTEGER NOT NULL,
+			card_id         INTEGER REFERENCES cards(id) ON DELETE CASCADE,
+			team_id         INTEGER REFERENCES teams(id) ON DELETE CASCADE,
+			amount          NUMERIC(20,4) NOT NULL,
+			currency        VARCHAR(10) NOT NULL DEFAULT 'USD',
+			from_currency   VARCHAR(10) NOT NULL DEFAULT '',
+			schedule        TEXT NOT NULL,
+			timezone        TEXT NOT NULL DEFAULT 'UTC',
+			is_active       BOOLEAN NOT NULL DEFAULT TRUE,
+			next_run_at     TIMESTAMPTZ NOT NULL,
+			pending_run_for TIMESTAMPTZ,
+			attempt         INTEGER NOT NULL DEFAULT 0,
+			last_run_at     TIMESTAMPTZ,
+			last_status     TEXT NOT NULL DEFAULT '',
+			last_error      TEXT NOT NULL DEFAULT '',
+			locked_until    TIMESTAMPTZ,
+			created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			CHECK ((card_id IS NULL) <> (team_id IS NULL))
+		);
+		CREATE INDEX IF NOT EXISTS idx_scheduled_transfers_user ON scheduled_transfers(user_id);
+		CREATE INDEX IF NOT EXISTS idx_scheduled_transfers_due ON scheduled_transfers(next_run_at) WHERE is_active;
+		ALTER TABLE IF EXISTS scheduled_transfers DISABLE ROW LEVEL SECURITY;
+
+		CREATE TABLE IF NOT EXISTS scheduled_transfer_runs (
+			id            SERIAL PRIMARY KEY,
+			rule_id       INTEGER NOT NULL REFERENCES scheduled_transfers(id) ON DELETE CASCADE,
+			scheduled_for TIMESTAMPTZ NOT NULL,
+			attempt       INTEGER NOT NULL,
+			card_id       INTEGER NOT NULL,
+			amount        NUMERIC(20,4) NOT NULL,
+			currency      VARCHAR(10) NOT NULL,
+			status        TEXT NOT NULL,
+			error         TEXT NOT NULL DEFAULT '',
+			created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_scheduled_transfer_runs_rule ON scheduled_transfer_runs(rule_id, scheduled_for DESC);
+		ALTER TABLE IF EXISTS scheduled_transfer_runs DISABLE ROW LEVEL SECURITY;
+	`)
+	if err != nil {
+		log.Printf("[SCHEDULED-TRANSFERS] Error ensuring tables: %v", err)
+		return err
+	}
+	log.Println("[SCHEDULED-TRANSFERS] ✅ scheduled_transfers and scheduled_transfer_runs tables ensured")
+	return nil
+}
+
+const scheduledTransferColumns = `id, user_id, card_id, team_id, amount, currency, from_currency, schedule, timezone,
+	is_active, next_run_at, pending_run_for, attempt, last_run_at, last_status, last_error, created_at`
+
+func scanScheduledTransfer(row interface{ Scan(...interface{}) error }) (*domain.ScheduledTransfer, error) {
+	var t domain.ScheduledTransfer
+	var cardID, teamID sql.NullInt64
+	var pendingFor, lastRun sql.NullTime
+	if err := row.Scan(&t.ID, &t.UserID, &cardID, &teamID, &t.Amount, &t.Currency, &t.FromCurrency, &t.Schedule,
+		&t.Timezone, &t.IsActive, &t.NextRunAt, &pendingFor, &t.Attempt, &lastRun, &t.LastStatus, &t.LastError,
+		&t.CreatedAt); err != nil {
+		return nil, err
+	}
+	if cardID.Valid {
+		id := int(cardID.Int64)
+		t.CardID = &id
+	}
+	if teamID.Valid {
+		id := int(teamID.Int64)
+		t.TeamID = &id
+	}
+	if pendingFor.Valid {
+		t.PendingRunFor = &pendingFor.Time
+	}
+	if lastRun.Valid {
+		t.LastRunAt = &lastRun.Time
+	}
+	return &t, nil
+}
+
+func scanScheduledTransfers(rows *sql.Rows) ([]domain.ScheduledTransfer, error) {
+	defer rows.Close()
+	list := []domain.ScheduledTransfer{}
+	for rows.Next() {
+		t, err := scanScheduledTransfer(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *t)
+	}
+	return list, rows.Err()
+}
+
+// CreateScheduledTransfer stores a new rule and fills in its ID and created_at.
+func CreateScheduledTransfer(t *domain.ScheduledTransfer) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	return GlobalDB.QueryRow(`
+		INSERT INTO scheduled_transfers (user_id, card_id, team_id, amount, currency, from_currency, schedule, timezone, is_active, next_run_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id, created_at`,
+		t.UserID, t.CardID, t.TeamID, t.Amount, t.Currency, t.FromCurrency, t.Schedule, t.Timezone, t.IsActive, t.NextRunAt,
+	).Scan(&t.ID, &t.CreatedAt)
+}
+
+// ListUserScheduledTransfers returns the rules created by a user.
+func ListUserScheduledTransfers(userID int) ([]domain.ScheduledTransfer, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	rows, err := GlobalDB.Query(`SELECT `+scheduledTransferColumns+` FROM scheduled_transfers WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list scheduled transfers: %w", err)
+	}
+	return scanScheduledTransfers(rows)
+}
+
+// GetScheduledTransfer returns a rule of the user (sql.ErrNoRows if it belongs to someone else).
+func GetScheduledTransfer(id, userID int) (*domain.ScheduledTransfer, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	return scanScheduledTransfer(GlobalDB.QueryRow(
+		`SELECT `+scheduledTransferColumns+` FROM scheduled_transfers WHERE id = $1 AND user_id = $2`, id, userID))
+}
+
+// SetScheduledTransferActive pauses or resumes a rule. Resuming resets retries and sets the next run.
+func SetScheduledTransferActive(id, userID int, active bool, nextRunAt time.Time) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	res, err := GlobalDB.Exec(`
+		UPDATE scheduled_transfers
+		SET is_active = $3, next_run_at = $4, pending_run_for = NULL, attempt = 0
+		WHERE id = $1 AND user_id = $2`, id, userID, active, nextRunAt)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteScheduledTransfer removes a rule together with its run log.
+func DeleteScheduledTransfer(id, userID int) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	res, err := GlobalDB.Exec(`DELETE FROM scheduled_transfers WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ClaimDueScheduledTransfers locks up to limit active rules whose next_run_at has come, so that
+// concurrent workers never execute the same rule twice.
+func ClaimDueScheduledTransfers(limit int) ([]domain.ScheduledTransfer, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	rows, err := GlobalDB.Query(`
+		UPDATE scheduled_transfers SET locked_until = NOW() + $2::interval
+		WHERE id IN (
+			SELECT id FROM scheduled_transfers
+			WHERE is_active AND next_run_at <= NOW() AND (locked_until IS NULL OR locked_until < NOW())
+			ORDER BY next_run_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+scheduledTransferColumns,
+		limit, fmt.Sprintf("%d seconds", int(scheduledTransferLockTTL.Seconds())))
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim scheduled transfers: %w", err)
+	}
+	return scanScheduledTransfers(rows)
+}
+
+// FinishScheduledTransfer stores the outcome of a run and releases the worker lock.
+// pendingRunFor is the occurrence still being retried (nil once it is done or given up).
+// n — the owner's notification about the run — is enqueued in the same transaction.
+func FinishScheduledTransfer(id int, nextRunAt time.Time, pendingRunFor *time.Time, attempt int, status, errMsg string, n *domain.UserNotification) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	tx, err := GlobalDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE scheduled_transfers
+		SET next_run_at = $2, pending_run_for = $3, attempt = $4, last_status = $5, last_error = $6,
+		    last_run_at = NOW(), locked_until = NULL
+		WHERE id = $1`,
+		id, nextRunAt, pendingRunFor, attempt, status, errMsg)
+	if err != nil {
+		return err
+	}
+	if n != nil {
+		if err := EnqueueNotification(tx, *n); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// RecordScheduledTransferRun logs one card transfer attempt.
+func RecordScheduledTransferRun(run *domain.ScheduledTransferRun) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	return GlobalDB.QueryRow(`
+		INSERT INTO scheduled_transfer_runs (rule_id, scheduled_for, attempt, card_id, amount, currency, status, error)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at`,
+		run.RuleID, run.ScheduledFor, run.Attempt, run.CardID, run.Amount, run.Currency, run.Status, run.Error,
+	).Scan(&run.ID, &run.CreatedAt)
+}
+
+// FundedScheduledCards returns the cards already funded for an occurrence of a rule,
+// so a retry only repeats the transfers that failed.
+func FundedScheduledCards(ruleID int, scheduledFor time.Time) (map[int]bool, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	rows, err := GlobalDB.Query(`
+		SELECT card_id FROM scheduled_transfer_runs
+		WHERE rule_id = $1 AND scheduled_for = $2 AND status = $3`,
+		ruleID, scheduledFor, domain.ScheduledTransferSuccess)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	funded := map[int]bool{}
+	for rows.Next() {
+		var cardID int
+		if err := rows.Scan(&cardID); err != nil {
+			return nil, err
+		}
+		funded[cardID] = true
+	}
+	return funded, rows.Err()
+}
+
+// ListScheduledTransferRuns returns the latest attempts of a rule, newest first.
+func ListScheduledTransferRuns(ruleID, limit int) ([]domain.ScheduledTransferRun, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	rows, err := GlobalDB.Query(`
+		SELECT id, rule_id, scheduled_for, attempt, card_id, amount, currency, status, error, created_at
+		FROM scheduled_transfer_runs WHERE rule_id = $1
+		ORDER BY id DESC LIMIT $2`, ruleID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	runs := []domain.ScheduledTransferRun{}
+	for rows.Next() {
+		var r domain.ScheduledTransferRun
+		if err := rows.Scan(&r.ID, &r.RuleID, &r.ScheduledFor, &r.Attempt, &r.CardID, &r.Amount, &r.Currency,
+			&r.Status, &r.Error, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+// GetTeamFundableCards returns the active cards of a team in the given currency, ordered by ID.
+func GetTeamFundableCards(teamID int, currency string) ([]domain.Card, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	rows, err := GlobalDB.Query(`
+		SELECT id, user_id, COALESCE(last_4_digits, ''), COALESCE(currency, 'USD')
+		FROM cards
+		WHERE team_id = $1 AND UPPER(COALESCE(card_status, '')) = 'ACTIVE' AND UPPER(COALESCE(currency, 'USD')) = $2
+		ORDER BY id`, teamID, currency)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var cards []domain.Card
+	for rows.Next() {
+		var c domain.Card
+		if err := rows.Scan(&c.ID, &c.UserID, &c.Last4Digits, &c.Currency); err != nil {
+			return nil, err
+		}
+		cards = append(cards, c)
+	}
+	return cards, rows.Err()
+}
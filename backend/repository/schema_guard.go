@@ -15,6 +15,7 @@ type requiredColumn struct {
 // allRequiredColumns is the single source of truth for columns the backend needs.
 var allRequiredColumns = []requiredColumn{
 	// --- users ---
+	// balance* retired: остатки переносятся в Кошельки (EnsureWalletBalanceTables), колонки больше не читаются
 	{"users", "balance", "NUMERIC(20,4) DEFAULT 0.0000"},
 	{"users", "balance_rub", "NUMERIC(20,4) DEFAULT 0.0000 NOT NULL"},
 	{"users", "balance_arbitrage", "NUMERIC(20,4) DEFAULT 0.0000"},
@@ -4,7 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"strings"
 	"time"
+
+	"github.com/djalben/xplr-core/backend/domain"
 )
 
 // ── User Sessions ──
@@ -314,6 +317,36 @@ func UpdateNotificationPrefs(userID int, p NotificationPrefs) error {
 	return err
 }
 
+// GetUserLanguage returns the language notifications are rendered in (users.language, 'ru' by default).
+func GetUserLanguage(userID int) string {
+	if GlobalDB == nil {
+		return domain.DefaultNotificationLanguage
+	}
+	var lang string
+	err := GlobalDB.QueryRow(`SELECT COALESCE(language, '') FROM users WHERE id = $1`, userID).Scan(&lang)
+	if err != nil || lang == "" {
+		return domain.DefaultNotificationLanguage
+	}
+	return lang
+}
+
+// SetUserLanguage updates the notification language of the user.
+func SetUserLanguage(userID int, lang string) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	supported := false
+	for _, l := range domain.NotificationLanguages {
+		supported = supported || l == lang
+	}
+	if !supported {
+		return fmt.Errorf("invalid language: must be one of %s", strings.Join(domain.NotificationLanguages, ", "))
+	}
+	_, err := GlobalDB.Exec(`UPDATE users SET language = $1 WHERE id = $2`, lang, userID)
+	return err
+}
+
 // ── Display Name ──
 
 func UpdateDisplayName(userID int, name string) error {
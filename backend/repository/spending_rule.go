@@ -0,0 +1,270 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/shopspring/decimal"
+)
+
+// EnsureSpendingRuleTables creates spending_rules, authorization_declines and transactions.merchant_name
+// (used for per-merchant monthly caps).
+func EnsureSpendingRuleTables() error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	_, err := GlobalDB.Exec(`
+		CREATE TABLE IF NOT EXISTS spending_rules (
+			id          SERIAL PRIMARY KEY,
+			card_id     INTEGER REFERENCES cards(id) ON DELETE CASCADE,
+			team_id     INTEGER REFERENCES teams(id) ON DELETE CASCADE,
+			rule_type   TEXT NOT NULL,
+			value       TEXT NOT NULL DEFAULT '',
+			monthly_cap NUMERIC(20,4) NOT NULL DEFAULT 0,
+			timezone    TEXT NOT NULL DEFAULT '',
+			is_active   BOOLEAN NOT NULL DEFAULT TRUE,
+			created_by  INTEGER NOT NULL DEFAULT 0,
+			created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			CHECK ((card_id IS NULL) <> (team_id IS NULL))
+		);
+		CREATE INDEX IF NOT EXISTS idx_spending_rules_card ON spending_rules(card_id) WHERE card_id IS NOT NULL;
+		CREATE INDEX IF NOT EXISTS idx_spending_rules_team ON spending_rules(team_id) WHERE team_id IS NOT NULL;
+		ALTER TABLE IF EXISTS spending_rules DISABLE ROW LEVEL SECURITY;
+
+		CREATE TABLE IF NOT EXISTS authorization_declines (
+			id            SERIAL PRIMARY KEY,
+			card_id       INTEGER NOT NULL,
+			user_id       INTEGER NOT NULL,
+			rule_id       INTEGER,
+			reason        TEXT NOT NULL,
+			merchant_name TEXT NOT NULL DEFAULT '',
+			mcc           TEXT NOT NULL DEFAULT '',
+			country       TEXT NOT NULL DEFAULT '',
+			amount        NUMERIC(20,4) NOT NULL DEFAULT 0,
+			source        TEXT NOT NULL,
+			created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_authorization_declines_card ON authorization_declines(card_id, created_at DESC);
+		ALTER TABLE IF EXISTS authorization_declines DISABLE ROW LEVEL SECURITY;
+
+		ALTER TABLE transactions ADD COLUMN IF NOT EXISTS merchant_name TEXT;
+	`)
+	if err != nil {
+		log.Printf("[SPENDING-RULES] Error ensuring tables: %v", err)
+		return err
+	}
+	log.Println("[SPENDING-RULES] ✅ spending_rules and authorization_declines tables ensured")
+	return nil
+}
+
+const spendingRuleColumns = `id, card_id, team_id, rule_type, value, monthly_cap, timezone, is_active, created_by, created_at`
+
+func scanSpendingRules(rows *sql.Rows) ([]domain.SpendingRule, error) {
+	defer rows.Close()
+	rules := []domain.SpendingRule{}
+	for rows.Next() {
+		var rule domain.SpendingRule
+		var cardID, teamID sql.NullInt64
+		if err := rows.Scan(&rule.ID, &cardID, &teamID, &rule.RuleType, &rule.Value, &rule.MonthlyCap,
+			&rule.Timezone, &rule.IsActive, &rule.CreatedBy, &rule.CreatedAt); err != nil {
+			return nil, err
+		}
+		if cardID.Valid {
+			id := int(cardID.Int64)
+			rule.CardID = &id
+		}
+		if teamID.Valid {
+			id := int(teamID.Int64)
+			rule.TeamID = &id
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// ListCardSpendingRules returns rules attached directly to a card.
+func ListCardSpendingRules(cardID int) ([]domain.SpendingRule, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	rows, err := GlobalDB.Query(`SELECT `+spendingRuleColumns+` FROM spending_rules WHERE card_id = $1 ORDER BY id`, cardID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list card rules: %w", err)
+	}
+	return scanSpendingRules(rows)
+}
+
+// ListTeamSpendingRules returns rules applied to every card of a team.
+func ListTeamSpendingRules(teamID int) ([]domain.SpendingRule, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	rows, err := GlobalDB.Query(`SELECT `+spendingRuleColumns+` FROM spending_rules WHERE team_id = $1 ORDER BY id`, teamID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list team rules: %w", err)
+	}
+	return scanSpendingRules(rows)
+}
+
+// GetActiveSpendingRules returns the active rules that apply to a card: its own and its team's.
+func GetActiveSpendingRules(cardID int, teamID *int) ([]domain.SpendingRule, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	var team sql.NullInt64
+	if teamID != nil {
+		team = sql.NullInt64{Int64: int64(*teamID), Valid: true}
+	}
+	rows, err := GlobalDB.Query(`
+		SELECT `+spendingRuleColumns+` FROM spending_rules
+		WHERE is_active AND (card_id = $1 OR ($2::INTEGER IS NOT NULL AND team_id = $2))
+		ORDER BY id`, cardID, team)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load spending rules: %w", err)
+	}
+	return scanSpendingRules(rows)
+}
+
+// CreateSpendingRule stores a new rule and fills in its ID and created_at.
+func CreateSpendingRule(rule *domain.SpendingRule) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	return GlobalDB.QueryRow(`
+		INSERT INTO spending_rules (card_id, team_id, rule_type, value, monthly_cap, timezone, is_active, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at`,
+		rule.CardID, rule.TeamID, rule.RuleType, rule.Value, rule.MonthlyCap, rule.Timezone, rule.IsActive, rule.CreatedBy,
+	).Scan(&rule.ID, &rule.CreatedAt)
+}
+
+// GetSpendingRule returns a rule by ID or nil if it does not exist.
+func GetSpendingRule(id int) (*domain.SpendingRule, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	rows, err := GlobalDB.Query(`SELECT `+spendingRuleColumns+` FROM spending_rules WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := scanSpendingRules(rows)
+	if err != nil || len(rules) == 0 {
+		return nil, err
+	}
+	return &rules[0], nil
+}
+
+// DeleteSpendingRule removes a rule.
+func DeleteSpendingRule(id int) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	res, err := GlobalDB.Exec(`DELETE FROM spending_rules WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("rule not found")
+	}
+	return nil
+}
+
+// GetMonthlyMerchantSpend sums approved card spend for the current calendar month per merchant,
+// active holds included. With teamID set the sum covers every card of the team, otherwise only cardID.
+func GetMonthlyMerchantSpend(cardID int, teamID *int) (map[string]decimal.Decimal, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	query := `
+		SELECT LOWER(merchant_name), COALESCE(SUM(amount), 0)
+		FROM (
+			SELECT t.merchant_name, t.amount FROM transactions t
+			WHERE t.card_id = $1 AND t.merchant_name IS NOT NULL
+			  AND t.transaction_type = 'CAPTURE' AND t.status = 'APPROVED'
+			  AND t.executed_at >= date_trunc('month', NOW())
+			UNION ALL
+			SELECT h.merchant_name, h.amount FROM card_holds h
+			WHERE h.card_id = $1 AND h.status = 'ACTIVE'
+		) spend
+		GROUP BY LOWER(merchant_name)`
+	arg := cardID
+	if teamID != nil {
+		query = `
+			SELECT LOWER(merchant_name), COALESCE(SUM(amount), 0)
+			FROM (
+				SELECT t.merchant_name, t.amount FROM transactions t JOIN cards c ON c.id = t.card_id
+				WHERE c.team_id = $1 AND t.merchant_name IS NOT NULL
+				  AND t.transaction_type = 'CAPTURE' AND t.status = 'APPROVED'
+				  AND t.executed_at >= date_trunc('month', NOW())
+				UNION ALL
+				SELECT h.merchant_name, h.amount FROM card_holds h JOIN cards c ON c.id = h.card_id
+				WHERE c.team_id = $1 AND h.status = 'ACTIVE'
+			) spend
+			GROUP BY LOWER(merchant_name)`
+		arg = *teamID
+	}
+	rows, err := GlobalDB.Query(query, arg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load merchant spend: %w", err)
+	}
+	defer rows.Close()
+
+	spend := map[string]decimal.Decimal{}
+	for rows.Next() {
+		var merchant string
+		var sum decimal.Decimal
+		if err := rows.Scan(&merchant, &sum); err != nil {
+			return nil, err
+		}
+		spend[merchant] = sum
+	}
+	return spend, rows.Err()
+}
+
+// RecordAuthorizationDecline logs a declined authorization together with the rule that declined it.
+func RecordAuthorizationDecline(d domain.AuthorizationDecline) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	_, err := GlobalDB.Exec(`
+		INSERT INTO authorization_declines (card_id, user_id, rule_id, reason, merchant_name, mcc, country, amount, source)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		d.CardID, d.UserID, d.RuleID, d.Reason, d.MerchantName, d.MCC, strings.ToUpper(d.Country), d.Amount, d.Source)
+	return err
+}
+
+// ListAuthorizationDeclines returns the most recent declines for a card.
+func ListAuthorizationDeclines(cardID int, limit int) ([]domain.AuthorizationDecline, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	rows, err := GlobalDB.Query(`
+		SELECT id, card_id, user_id, rule_id, reason, merchant_name, mcc, country, amount, source, created_at
+		FROM authorization_declines
+		WHERE card_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2`, cardID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list declines: %w", err)
+	}
+	defer rows.Close()
+
+	declines := []domain.AuthorizationDecline{}
+	for rows.Next() {
+		var d domain.AuthorizationDecline
+		var ruleID sql.NullInt64
+		if err := rows.Scan(&d.ID, &d.CardID, &d.UserID, &ruleID, &d.Reason, &d.MerchantName,
+			&d.MCC, &d.Country, &d.Amount, &d.Source, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		if ruleID.Valid {
+			id := int(ruleID.Int64)
+			d.RuleID = &id
+		}
+		declines = append(declines, d)
+	}
+	return declines, rows.Err()
+}
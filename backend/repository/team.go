@@ -2,6 +2,7 @@ package repository
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
 
@@ -108,10 +109,11 @@ func GetTeamMembers(teamID int) ([]domain.TeamMember, error) {
 	}
 
 	query := `
-		SELECT tm.id, tm.team_id, tm.user_id, tm.role, tm.invited_by, tm.joined_at,
-		       u.id, u.email, u.balance, COALESCE(u.balance_rub, 0), u.status
+		SELECT tm.id, tm.team_id, tm.user_id, tm.role, tm.role_id, tr.permissions, tm.invited_by, tm.joined_at,
+		       u.id, u.email, u.status
 		FROM team_members tm
 		INNER JOIN users u ON tm.user_id = u.id
+		LEFT JOIN team_roles tr ON tr.id = tm.role_id AND tr.team_id = tm.team_id
 		WHERE tm.team_id = $1
 		ORDER BY tm.joined_at ASC
 	`
@@ -126,12 +128,13 @@ func GetTeamMembers(teamID int) ([]domain.TeamMember, error) {
 	for rows.Next() {
 		var member domain.TeamMember
 		var user domain.User
-		var invitedBy sql.NullInt64
+		var invitedBy, roleID sql.NullInt64
+		var customPerms []byte
 
 		err := rows.Scan(
-			&member.ID, &member.TeamID, &member.UserID, &member.Role,
+			&member.ID, &member.TeamID, &member.UserID, &member.Role, &roleID, &customPerms,
 			&invitedBy, &member.JoinedAt,
-			&user.ID, &user.Email, &user.Balance, &user.BalanceRub, &user.Status,
+			&user.ID, &user.Email, &user.Status,
 		)
 		if err != nil {
 			log.Printf("Error scanning team member: %v", err)
@@ -143,6 +146,16 @@ func GetTeamMembers(teamID int) ([]domain.TeamMember, error) {
 			member.InvitedBy = &invitedByVal
 		}
 
+		// Права: кастомная роль заменяет шаблон
+		var custom []string
+		if roleID.Valid && customPerms != nil {
+			roleIDVal := int(roleID.Int64)
+			member.RoleID = &roleIDVal
+			custom = []string{}
+			json.Unmarshal(customPerms, &custom)
+		}
+		member.Permissions = ResolveTeamPermissions(member.Role, custom)
+
 		member.User = &user
 		members = append(members, member)
 	}
@@ -150,70 +163,19 @@ func GetTeamMembers(teamID int) ([]domain.TeamMember, error) {
 	return members, nil
 }
 
-// InviteTeamMember - Пригласить участника в команду
-func InviteTeamMember(teamID int, inviterID int, email string, role string) error {
-	if GlobalDB == nil {
-		return fmt.Errorf("database connection not initialized")
-	}
-
-	// 1. Проверить права приглашающего (должен быть owner или admin)
-	hasAccess, inviterRole, err := CheckTeamAccess(teamID, inviterID)
-	if err != nil || !hasAccess {
-		return fmt.Errorf("access denied")
-	}
-	if inviterRole != "owner" && inviterRole != "admin" {
-		return fmt.Errorf("insufficient permissions: only owner or admin can invite members")
-	}
-
-	// 2. Найти пользователя по email
-	user, err := GetUserByEmail(email)
-	if err != nil {
-		return fmt.Errorf("user with email %s not found", email)
-	}
-
-	// 3. Проверить, что пользователь еще не в команде
-	var existingMemberID int
-	err = GlobalDB.QueryRow(
-		"SELECT id FROM team_members WHERE team_id = $1 AND user_id = $2",
-		teamID, user.ID,
-	).Scan(&existingMemberID)
-	if err == nil {
-		return fmt.Errorf("user is already a member of this team")
-	}
-
-	// 4. Валидация роли
-	if role != "admin" && role != "member" {
-		return fmt.Errorf("invalid role: must be 'admin' or 'member'")
-	}
-
-	// 5. Добавить участника
-	_, err = GlobalDB.Exec(
-		`INSERT INTO team_members (team_id, user_id, role, invited_by) 
-		 VALUES ($1, $2, $3, $4)`,
-		teamID, user.ID, role, inviterID,
-	)
-	if err != nil {
-		log.Printf("DB Error inviting team member: %v", err)
-		return fmt.Errorf("failed to invite team member")
-	}
-
-	log.Printf("✅ User %d invited to team %d as %s by user %d", user.ID, teamID, role, inviterID)
-	return nil
-}
-
 // RemoveTeamMember - Удалить участника из команды
 func RemoveTeamMember(teamID int, userID int, removerID int) error {
 	if GlobalDB == nil {
 		return fmt.Errorf("database connection not initialized")
 	}
 
-	// 1. Проверить права удаляющего
-	hasAccess, removerRole, err := CheckTeamAccess(teamID, removerID)
+	// 1. Проверить права удаляющего (members.invite)
+	_, perms, hasAccess, err := GetTeamMemberPermissions(teamID, removerID)
 	if err != nil || !hasAccess {
 		return fmt.Errorf("access denied")
 	}
-	if removerRole != "owner" && removerRole != "admin" {
-		return fmt.Errorf("insufficient permissions: only owner or admin can remove members")
+	if !HasPermission(perms, domain.PermMembersInvite) {
+		return fmt.Errorf("insufficient permissions: members.invite required")
 	}
 
 	// 2. Нельзя удалить владельца команды
@@ -228,6 +190,9 @@ func RemoveTeamMember(teamID int, userID int, removerID int) error {
 	if memberRole == "owner" {
 		return fmt.Errorf("cannot remove team owner")
 	}
+	if memberRole == "admin" && !HasPermission(perms, domain.PermTeamManage) {
+		return fmt.Errorf("insufficient permissions: team.manage required to remove admins")
+	}
 
 	// 3. Удалить участника
 	_, err = GlobalDB.Exec(
@@ -243,24 +208,31 @@ func RemoveTeamMember(teamID int, userID int, removerID int) error {
 	return nil
 }
 
-// UpdateTeamMemberRole - Изменить роль участника
-func UpdateTeamMemberRole(teamID int, userID int, newRole string, updaterID int) error {
+// UpdateTeamMemberRole - Изменить роль участника: шаблон (newRole) или кастомная роль команды (roleID)
+func UpdateTeamMemberRole(teamID int, userID int, newRole string, roleID *int, updaterID int) error {
 	if GlobalDB == nil {
 		return fmt.Errorf("database connection not initialized")
 	}
 
-	// 1. Проверить права (только owner может менять роли)
-	hasAccess, updaterRole, err := CheckTeamAccess(teamID, updaterID)
+	// 1. Проверить права (roles.manage)
+	_, perms, hasAccess, err := GetTeamMemberPermissions(teamID, updaterID)
 	if err != nil || !hasAccess {
 		return fmt.Errorf("access denied")
 	}
-	if updaterRole != "owner" {
-		return fmt.Errorf("insufficient permissions: only owner can change roles")
+	if !HasPermission(perms, domain.PermRolesManage) {
+		return fmt.Errorf("insufficient permissions: roles.manage required")
 	}
 
-	// 2. Валидация новой роли
-	if newRole != "admin" && newRole != "member" {
-		return fmt.Errorf("invalid role: must be 'admin' or 'member'")
+	// 2. Валидация новой роли: участник с кастомной ролью хранит шаблон 'member'
+	if roleID != nil {
+		var exists bool
+		GlobalDB.QueryRow(`SELECT EXISTS(SELECT 1 FROM team_roles WHERE id = $1 AND team_id = $2)`, *roleID, teamID).Scan(&exists)
+		if !exists {
+			return fmt.Errorf("invalid role: custom role not found in this team")
+		}
+		newRole = "member"
+	} else if !isAssignableTeamRole(newRole) {
+		return fmt.Errorf("invalid role: must be one of 'admin', 'member', 'buyer', 'finance'")
 	}
 
 	// 3. Нельзя изменить роль владельца
@@ -278,8 +250,8 @@ func UpdateTeamMemberRole(teamID int, userID int, newRole string, updaterID int)
 
 	// 4. Обновить роль
 	_, err = GlobalDB.Exec(
-		"UPDATE team_members SET role = $1 WHERE team_id = $2 AND user_id = $3",
-		newRole, teamID, userID,
+		"UPDATE team_members SET role = $1, role_id = $4 WHERE team_id = $2 AND user_id = $3",
+		newRole, teamID, userID, roleID,
 	)
 	if err != nil {
 		log.Printf("DB Error updating team member role: %v", err)
@@ -290,6 +262,12 @@ func UpdateTeamMemberRole(teamID int, userID int, newRole string, updaterID int)
 	return nil
 }
 
+// isAssignableTeamRole - Шаблон роли, который можно выдать участнику (owner не передаётся)
+func isAssignableTeamRole(role string) bool {
+	_, ok := domain.TeamRoleTemplates[role]
+	return ok && role != "owner"
+}
+
 // CheckTeamAccess - Проверить доступ пользователя к команде
 func CheckTeamAccess(teamID int, userID int) (bool, string, error) {
 	if GlobalDB == nil {
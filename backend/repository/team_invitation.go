@@ -0,0 +1,354 @@
+package repository
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/pkg/utils"
+)
+
+// TeamInvitationTTL — срок действия приглашения в команду.
+const TeamInvitationTTL = 7 * 24 * time.Hour
+
+var (
+	// ErrTeamInvitationInvalid — токен приглашения подделан или не найден.
+	ErrTeamInvitationInvalid = errors.New("invalid team invitation")
+	// ErrTeamInvitationExpired — срок приглашения истёк.
+	ErrTeamInvitationExpired = errors.New("team invitation expired")
+	// ErrTeamInvitationClosed — приглашение уже принято или отозвано.
+	ErrTeamInvitationClosed = errors.New("team invitation is no longer pending")
+	// ErrTeamInvitationEmailMismatch — приглашение выписано на другой email.
+	ErrTeamInvitationEmailMismatch = errors.New("team invitation was sent to a different email")
+)
+
+// EnsureTeamInvitationTables creates team_invitations. Only a SHA-256 hash of the emailed token
+// is stored; the token itself is "<id>.<nonce>" signed with utils.SignToken.
+func EnsureTeamInvitationTables() error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	_, err := GlobalDB.Exec(`
+		CREATE TABLE IF NOT EXISTS team_invitations (
+			id          SERIAL PRIMARY KEY,
+			team_id     INTEGER NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+			email       VARCHAR(255) NOT NULL,
+			role        VARCHAR(50) NOT NULL DEFAULT 'member',
+			role_id     INTEGER REFERENCES team_roles(id) ON DELETE SET NULL,
+			invited_by  INTEGER NOT NULL REFERENCES users(id),
+			token_hash  VARCHAR(64) NOT NULL DEFAULT '',
+			status      VARCHAR(20) NOT NULL DEFAULT 'PENDING',
+			expires_at  TIMESTAMPTZ NOT NULL,
+			accepted_by INTEGER REFERENCES users(id),
+			accepted_at TIMESTAMPTZ,
+			created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_team_invitations_team ON team_invitations(team_id, status);
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_team_invitations_pending_email
+			ON team_invitations(team_id, LOWER(email)) WHERE status = 'PENDING';
+		ALTER TABLE IF EXISTS team_invitations DISABLE ROW LEVEL SECURITY;
+	`)
+	if err != nil {
+		log.Printf("[TEAM-INVITE] Error ensuring tables: %v", err)
+		return err
+	}
+	log.Println("[TEAM-INVITE] ✅ team_invitations table ensured")
+	return nil
+}
+
+func hashInvitationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// teamInvitationColumns — статус PENDING с истёкшим сроком отдаётся как EXPIRED.
+const teamInvitationColumns = `ti.id, ti.team_id, t.name, ti.email, ti.role, ti.role_id, ti.invited_by,
+	CASE WHEN ti.status = 'PENDING' AND ti.expires_at < NOW() THEN 'EXPIRED' ELSE ti.status END,
+	ti.expires_at, ti.accepted_by, ti.accepted_at, ti.created_at`
+
+func scanTeamInvitation(row interface{ Scan(...interface{}) error }) (*domain.TeamInvitation, error) {
+	var inv domain.TeamInvitation
+	var roleID, acceptedBy sql.NullInt64
+	var acceptedAt sql.NullTime
+	err := row.Scan(&inv.ID, &inv.TeamID, &inv.TeamName, &inv.Email, &inv.Role, &roleID, &inv.InvitedBy,
+		&inv.Status, &inv.ExpiresAt, &acceptedBy, &acceptedAt, &inv.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if roleID.Valid {
+		v := int(roleID.Int64)
+		inv.RoleID = &v
+	}
+	if acceptedBy.Valid {
+		v := int(acceptedBy.Int64)
+		inv.AcceptedBy = &v
+	}
+	if acceptedAt.Valid {
+		inv.AcceptedAt = &acceptedAt.Time
+	}
+	return &inv, nil
+}
+
+// CreateTeamInvitation — создать приглашение и вернуть его вместе с токеном для письма.
+// Нужно право members.invite; приглашённая роль не может давать прав, которых нет у пригласившего
+// (у owner есть все права). Предыдущее ожидающее приглашение на тот же email отзывается.
+func CreateTeamInvitation(teamID, inviterID int, email, role string, roleID *int) (*domain.TeamInvitation, string, error) {
+	if GlobalDB == nil {
+		return nil, "", fmt.Errorf("database connection not initialized")
+	}
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" || !strings.Contains(email, "@") {
+		return nil, "", fmt.Errorf("invalid email")
+	}
+
+	_, perms, ok, err := GetTeamMemberPermissions(teamID, inviterID)
+	if err != nil || !ok {
+		return nil, "", fmt.Errorf("access denied")
+	}
+	if !HasPermission(perms, domain.PermMembersInvite) {
+		return nil, "", fmt.Errorf("insufficient permissions: members.invite required")
+	}
+	var custom []string
+	if roleID != nil {
+		var raw []byte
+		err := GlobalDB.QueryRow(`SELECT permissions FROM team_roles WHERE id = $1 AND team_id = $2`, *roleID, teamID).Scan(&raw)
+		if err == sql.ErrNoRows {
+			return nil, "", fmt.Errorf("invalid role: custom role not found in this team")
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to load custom role: %v", err)
+		}
+		if err := json.Unmarshal(raw, &custom); err != nil {
+			return nil, "", fmt.Errorf("failed to load custom role: %v", err)
+		}
+		if custom == nil {
+			custom = []string{}
+		}
+		role = "member"
+	} else if !isAssignableTeamRole(role) {
+		return nil, "", fmt.Errorf("invalid role: must be one of 'admin', 'member', 'buyer', 'finance'")
+	}
+	if missing := MissingPermissions(ResolveTeamPermissions(role, custom), perms); len(missing) > 0 {
+		return nil, "", fmt.Errorf("insufficient permissions: cannot grant %s", strings.Join(missing, ", "))
+	}
+
+	var isMember bool
+	GlobalDB.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM team_members tm JOIN users u ON u.id = tm.user_id
+		 WHERE tm.team_id = $1 AND LOWER(u.email) = $2)`, teamID, email,
+	).Scan(&isMember)
+	if isMember {
+		return nil, "", fmt.Errorf("user is already a member of this team")
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, "", fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	tx, err := GlobalDB.Begin()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		`UPDATE team_invitations SET status = 'REVOKED' WHERE team_id = $1 AND LOWER(email) = $2 AND status = 'PENDING'`,
+		teamID, email,
+	); err != nil {
+		return nil, "", fmt.Errorf("failed to revoke previous invitation: %v", err)
+	}
+	var id int
+	err = tx.QueryRow(
+		`INSERT INTO team_invitations (team_id, email, role, role_id, invited_by, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		teamID, email, role, roleID, inviterID, time.Now().Add(TeamInvitationTTL),
+	).Scan(&id)
+	if err != nil {
+		log.Printf("[TEAM-INVITE] DB Error creating invitation: %v", err)
+		return nil, "", fmt.Errorf("failed to create invitation")
+	}
+	token := utils.SignToken(strconv.Itoa(id) + "." + hex.EncodeToString(nonce))
+	if _, err := tx.Exec(`UPDATE team_invitations SET token_hash = $1 WHERE id = $2`, hashInvitationToken(token), id); err != nil {
+		return nil, "", fmt.Errorf("failed to create invitation")
+	}
+	inv, err := scanTeamInvitation(tx.QueryRow(
+		`SELECT `+teamInvitationColumns+` FROM team_invitations ti JOIN teams t ON t.id = ti.team_id WHERE ti.id = $1`, id,
+	))
+	if err != nil {
+		return nil, "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, "", fmt.Errorf("failed to commit invitation: %v", err)
+	}
+	log.Printf("[TEAM-INVITE] Invitation #%d to team %d for %s (%s) created by user %d", id, teamID, email, role, inviterID)
+	return inv, token, nil
+}
+
+// invitationIDFromToken проверяет подпись токена и достаёт из него id приглашения.
+func invitationIDFromToken(token string) (int, error) {
+	payload, ok := utils.VerifySignedToken(strings.TrimSpace(token))
+	if !ok {
+		return 0, ErrTeamInvitationInvalid
+	}
+	idPart, _, _ := strings.Cut(payload, ".")
+	id, err := strconv.Atoi(idPart)
+	if err != nil || id <= 0 {
+		return 0, ErrTeamInvitationInvalid
+	}
+	return id, nil
+}
+
+// loadInvitationByToken загружает приглашение по токену и сверяет хеш токена.
+func loadInvitationByToken(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, token string, forUpdate bool) (*domain.TeamInvitation, error) {
+	id, err := invitationIDFromToken(token)
+	if err != nil {
+		return nil, err
+	}
+	query := `SELECT ` + teamInvitationColumns + `, ti.token_hash FROM team_invitations ti JOIN teams t ON t.id = ti.team_id WHERE ti.id = $1`
+	if forUpdate {
+		query += ` FOR UPDATE OF ti`
+	}
+	var inv domain.TeamInvitation
+	var roleID, acceptedBy sql.NullInt64
+	var acceptedAt sql.NullTime
+	var tokenHash string
+	err = q.QueryRow(query, id).Scan(&inv.ID, &inv.TeamID, &inv.TeamName, &inv.Email, &inv.Role, &roleID, &inv.InvitedBy,
+		&inv.Status, &inv.ExpiresAt, &acceptedBy, &acceptedAt, &inv.CreatedAt, &tokenHash)
+	if err == sql.ErrNoRows {
+		return nil, ErrTeamInvitationInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(tokenHash), []byte(hashInvitationToken(strings.TrimSpace(token)))) != 1 {
+		return nil, ErrTeamInvitationInvalid
+	}
+	if roleID.Valid {
+		v := int(roleID.Int64)
+		inv.RoleID = &v
+	}
+	switch inv.Status {
+	case domain.TeamInvitationExpired:
+		return &inv, ErrTeamInvitationExpired
+	case domain.TeamInvitationPending:
+		return &inv, nil
+	default:
+		return &inv, ErrTeamInvitationClosed
+	}
+}
+
+// GetTeamInvitationByToken — приглашение по токену из письма (для страницы принятия).
+// Вместе с ErrTeamInvitationExpired/ErrTeamInvitationClosed возвращается и само приглашение.
+func GetTeamInvitationByToken(token string) (*domain.TeamInvitation, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	return loadInvitationByToken(GlobalDB, token, false)
+}
+
+// AcceptTeamInvitation — принять приглашение: добавить пользователя в команду с ролью из приглашения.
+// Email пользователя должен совпадать с адресом, на который выслано приглашение.
+func AcceptTeamInvitation(token string, userID int) (*domain.TeamInvitation, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	tx, err := GlobalDB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	inv, err := loadInvitationByToken(tx, token, true)
+	if err != nil {
+		return nil, err
+	}
+	var email string
+	if err := tx.QueryRow(`SELECT email FROM users WHERE id = $1`, userID).Scan(&email); err != nil {
+		return nil, fmt.Errorf("user not found")
+	}
+	if !strings.EqualFold(strings.TrimSpace(email), inv.Email) {
+		return nil, ErrTeamInvitationEmailMismatch
+	}
+	_, err = tx.Exec(
+		`INSERT INTO team_members (team_id, user_id, role, role_id, invited_by) VALUES ($1, $2, $3, $4, $5)
+		 ON CONFLICT (team_id, user_id) DO NOTHING`,
+		inv.TeamID, userID, inv.Role, inv.RoleID, inv.InvitedBy,
+	)
+	if err != nil {
+		log.Printf("[TEAM-INVITE] DB Error adding member from invitation #%d: %v", inv.ID, err)
+		return nil, fmt.Errorf("failed to join team")
+	}
+	now := time.Now()
+	if _, err := tx.Exec(
+		`UPDATE team_invitations SET status = 'ACCEPTED', accepted_by = $1, accepted_at = $2 WHERE id = $3`,
+		userID, now, inv.ID,
+	); err != nil {
+		return nil, fmt.Errorf("failed to accept invitation: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to accept invitation: %v", err)
+	}
+	inv.Status = domain.TeamInvitationAccepted
+	inv.AcceptedBy = &userID
+	inv.AcceptedAt = &now
+	log.Printf("[TEAM-INVITE] ✅ User %d accepted invitation #%d to team %d as %s", userID, inv.ID, inv.TeamID, inv.Role)
+	return inv, nil
+}
+
+// RevokeTeamInvitation — отозвать ожидающее приглашение. sql.ErrNoRows — приглашение не найдено
+// в команде или уже закрыто.
+func RevokeTeamInvitation(teamID, invitationID int) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	res, err := GlobalDB.Exec(
+		`UPDATE team_invitations SET status = 'REVOKED' WHERE id = $1 AND team_id = $2 AND status = 'PENDING'`,
+		invitationID, teamID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	log.Printf("[TEAM-INVITE] Invitation #%d of team %d revoked", invitationID, teamID)
+	return nil
+}
+
+// ListTeamInvitations — приглашения команды (ожидающие и истёкшие сверху), последние 100.
+func ListTeamInvitations(teamID int) ([]domain.TeamInvitation, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	rows, err := GlobalDB.Query(
+		`SELECT `+teamInvitationColumns+` FROM team_invitations ti JOIN teams t ON t.id = ti.team_id
+		 WHERE ti.team_id = $1
+		 ORDER BY (ti.status = 'PENDING') DESC, ti.created_at DESC LIMIT 100`, teamID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	invitations := []domain.TeamInvitation{}
+	for rows.Next() {
+		inv, err := scanTeamInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, *inv)
+	}
+	return invitations, rows.Err()
+}
@@ -0,0 +1,267 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/djalben/xplr-core/backend/domain"
+)
+
+var (
+	// ErrInvalidTeamPermission — неизвестное право в кастомной роли.
+	ErrInvalidTeamPermission = errors.New("invalid team permission")
+	// ErrInvalidTeamRole — неизвестный шаблон роли или роль другой команды.
+	ErrInvalidTeamRole = errors.New("invalid team role")
+)
+
+// EnsureTeamRoleTables creates team_roles (custom roles with a JSONB permission list) and
+// adds team_members.role_id. A member without role_id gets the permissions of the template
+// stored in team_members.role (domain.TeamRoleTemplates).
+func EnsureTeamRoleTables() error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	_, err := GlobalDB.Exec(`
+		CREATE TABLE IF NOT EXISTS team_roles (
+			id          SERIAL PRIMARY KEY,
+			team_id     INTEGER NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+			name        VARCHAR(64) NOT NULL,
+			permissions JSONB NOT NULL DEFAULT '[]'::jsonb,
+			created_by  INTEGER REFERENCES users(id),
+			created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			UNIQUE (team_id, name)
+		);
+		ALTER TABLE IF EXISTS team_roles DISABLE ROW LEVEL SECURITY;
+		ALTER TABLE team_members ADD COLUMN IF NOT EXISTS role_id INTEGER REFERENCES team_roles(id) ON DELETE SET NULL;
+	`)
+	if err != nil {
+		log.Printf("[TEAM-RBAC] Error ensuring tables: %v", err)
+		return err
+	}
+	log.Println("[TEAM-RBAC] ✅ team_roles table and team_members.role_id ensured")
+	return nil
+}
+
+// ResolveTeamPermissions returns the effective permissions of a member: the owner always has
+// every permission, a custom role (custom != nil) replaces the template, otherwise the template
+// named by role applies. Unknown roles get no permissions.
+func ResolveTeamPermissions(role string, custom []string) []string {
+	if role == "owner" {
+		return domain.TeamPermissions
+	}
+	if custom != nil {
+		return custom
+	}
+	return domain.TeamRoleTemplates[role]
+}
+
+// HasPermission reports whether perm is in perms.
+func HasPermission(perms []string, perm string) bool {
+	for _, p := range perms {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// MissingPermissions returns the permissions from granted that are not in held.
+func MissingPermissions(granted, held []string) []string {
+	var missing []string
+	for _, p := range granted {
+		if !HasPermission(held, p) {
+			missing = append(missing, p)
+		}
+	}
+	return missing
+}
+
+// NormalizeTeamPermissions validates a permission list and returns it deduplicated,
+// in the order of domain.TeamPermissions.
+func NormalizeTeamPermissions(perms []string) ([]string, error) {
+	wanted := make(map[string]bool, len(perms))
+	for _, p := range perms {
+		p = strings.TrimSpace(strings.ToLower(p))
+		if !HasPermission(domain.TeamPermissions, p) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidTeamPermission, p)
+		}
+		wanted[p] = true
+	}
+	out := make([]string, 0, len(wanted))
+	for _, p := range domain.TeamPermissions {
+		if wanted[p] {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+// teamMemberPermissions loads the member's template role and effective permissions.
+// ok is false when the user is not a member of the team.
+func teamMemberPermissions(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, teamID, userID int) (role string, perms []string, ok bool, err error) {
+	var custom []byte
+	err = q.QueryRow(
+		`SELECT tm.role, tr.permissions
+		 FROM team_members tm
+		 LEFT JOIN team_roles tr ON tr.id = tm.role_id AND tr.team_id = tm.team_id
+		 WHERE tm.team_id = $1 AND tm.user_id = $2`,
+		teamID, userID,
+	).Scan(&role, &custom)
+	if err == sql.ErrNoRows {
+		return "", nil, false, nil
+	}
+	if err != nil {
+		return "", nil, false, err
+	}
+	var customPerms []string
+	if custom != nil {
+		if err := json.Unmarshal(custom, &customPerms); err != nil {
+			return "", nil, false, err
+		}
+		if customPerms == nil {
+			customPerms = []string{}
+		}
+	}
+	return role, ResolveTeamPermissions(role, customPerms), true, nil
+}
+
+// GetTeamMemberPermissions returns the member's template role and effective permissions.
+func GetTeamMemberPermissions(teamID, userID int) (role string, perms []string, ok bool, err error) {
+	if GlobalDB == nil {
+		return "", nil, false, fmt.Errorf("database connection not initialized")
+	}
+	return teamMemberPermissions(GlobalDB, teamID, userID)
+}
+
+// HasTeamPermission reports whether the user is a member of the team with the permission.
+func HasTeamPermission(teamID, userID int, perm string) (bool, error) {
+	_, perms, ok, err := GetTeamMemberPermissions(teamID, userID)
+	if err != nil || !ok {
+		return false, err
+	}
+	return HasPermission(perms, perm), nil
+}
+
+// GetCardTeamID returns the team a card was issued for, or 0 for a personal card.
+func GetCardTeamID(cardID int) (int, error) {
+	if GlobalDB == nil {
+		return 0, fmt.Errorf("database connection not initialized")
+	}
+	var teamID sql.NullInt64
+	if err := GlobalDB.QueryRow(`SELECT team_id FROM cards WHERE id = $1`, cardID).Scan(&teamID); err != nil {
+		return 0, err
+	}
+	return int(teamID.Int64), nil
+}
+
+func scanTeamRole(row interface{ Scan(...interface{}) error }) (*domain.TeamRole, error) {
+	var role domain.TeamRole
+	var perms []byte
+	var createdBy sql.NullInt64
+	if err := row.Scan(&role.ID, &role.TeamID, &role.Name, &perms, &createdBy, &role.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(perms, &role.Permissions); err != nil {
+		return nil, err
+	}
+	if role.Permissions == nil {
+		role.Permissions = []string{}
+	}
+	role.CreatedBy = int(createdBy.Int64)
+	return &role, nil
+}
+
+// ListTeamRoles returns the custom roles of a team.
+func ListTeamRoles(teamID int) ([]domain.TeamRole, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	rows, err := GlobalDB.Query(
+		`SELECT id, team_id, name, permissions, created_by, created_at
+		 FROM team_roles WHERE team_id = $1 ORDER BY name`, teamID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	roles := []domain.TeamRole{}
+	for rows.Next() {
+		role, err := scanTeamRole(rows)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, *role)
+	}
+	return roles, rows.Err()
+}
+
+// CreateTeamRole creates a custom role. Names of the built-in templates are reserved.
+func CreateTeamRole(teamID, creatorID int, name string, permissions []string) (*domain.TeamRole, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 64 {
+		return nil, fmt.Errorf("%w: name must be 1-64 characters", ErrInvalidTeamRole)
+	}
+	if _, reserved := domain.TeamRoleTemplates[strings.ToLower(name)]; reserved {
+		return nil, fmt.Errorf("%w: %q is a built-in role", ErrInvalidTeamRole, name)
+	}
+	perms, err := NormalizeTeamPermissions(permissions)
+	if err != nil {
+		return nil, err
+	}
+	raw, _ := json.Marshal(perms)
+	role, err := scanTeamRole(GlobalDB.QueryRow(
+		`INSERT INTO team_roles (team_id, name, permissions, created_by) VALUES ($1, $2, $3, $4)
+		 RETURNING id, team_id, name, permissions, created_by, created_at`,
+		teamID, name, raw, creatorID,
+	))
+	if err != nil {
+		if strings.Contains(err.Error(), "unique") || strings.Contains(err.Error(), "duplicate") {
+			return nil, fmt.Errorf("%w: role %q already exists", ErrInvalidTeamRole, name)
+		}
+		return nil, err
+	}
+	log.Printf("[TEAM-RBAC] Role %q (#%d) created in team %d by user %d: %v", name, role.ID, teamID, creatorID, perms)
+	return role, nil
+}
+
+// UpdateTeamRolePermissions replaces the permissions of a custom role.
+// Returns sql.ErrNoRows if the role does not belong to the team.
+func UpdateTeamRolePermissions(teamID, roleID int, permissions []string) (*domain.TeamRole, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	perms, err := NormalizeTeamPermissions(permissions)
+	if err != nil {
+		return nil, err
+	}
+	raw, _ := json.Marshal(perms)
+	return scanTeamRole(GlobalDB.QueryRow(
+		`UPDATE team_roles SET permissions = $3 WHERE id = $1 AND team_id = $2
+		 RETURNING id, team_id, name, permissions, created_by, created_at`,
+		roleID, teamID, raw,
+	))
+}
+
+// DeleteTeamRole deletes a custom role; its members fall back to their template role.
+func DeleteTeamRole(teamID, roleID int) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	res, err := GlobalDB.Exec(`DELETE FROM team_roles WHERE id = $1 AND team_id = $2`, roleID, teamID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,664 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/ledger"
+	"github.com/shopspring/decimal"
+)
+
+var (
+	// ErrTeamAccessDenied — пользователь не участник команды или его роли недостаточно для операции.
+	ErrTeamAccessDenied = errors.New("team access denied")
+	// ErrTeamWalletInsufficientFunds — в Кошельке команды не хватает средств.
+	ErrTeamWalletInsufficientFunds = errors.New("insufficient funds in team wallet")
+	// ErrTeamAllowanceExceeded — операция превышает месячный лимит участника или лимит на карту.
+	ErrTeamAllowanceExceeded = errors.New("team allowance exceeded")
+)
+
+// EnsureTeamWalletTables creates team_wallet_balances, team_member_allowances and
+// team_wallet_operations. The team wallet is a ledger account (ledger.TeamWallet) whose
+// balance per currency is kept in team_wallet_balances; every operation is also logged with
+// the member who made it, which is what allowances and the spend breakdown are computed from.
+func EnsureTeamWalletTables() error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	_, err := GlobalDB.Exec(`
+		CREATE TABLE IF NOT EXISTS team_wallet_balances (
+			team_id    INTEGER NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+			currency   VARCHAR(10) NOT NULL,
+			balance    NUMERIC(20,4) NOT NULL DEFAULT 0,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (team_id, currency)
+		);
+		ALTER TABLE IF EXISTS team_wallet_balances DISABLE ROW LEVEL SECURITY;
+
+		CREATE TABLE IF NOT EXISTS team_member_allowances (
+			team_id        INTEGER NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+			user_id        INTEGER NOT NULL,
+			currency       VARCHAR(10) NOT NULL DEFAULT 'USD',
+			monthly_limit  NUMERIC(20,4),
+			per_card_limit NUMERIC(20,4),
+			updated_by     INTEGER,
+			updated_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (team_id, user_id)
+		);
+		ALTER TABLE IF EXISTS team_member_allowances DISABLE ROW LEVEL SECURITY;
+
+		CREATE TABLE IF NOT EXISTS team_wallet_operations (
+			id             SERIAL PRIMARY KEY,
+			team_id        INTEGER NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+			user_id        INTEGER NOT NULL,
+			card_id        INTEGER,
+			operation      TEXT NOT NULL,
+			amount         NUMERIC(20,4) NOT NULL,
+			currency       VARCHAR(10) NOT NULL,
+			transaction_id INTEGER NOT NULL,
+			created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_team_wallet_operations_member ON team_wallet_operations(team_id, user_id, created_at);
+		CREATE INDEX IF NOT EXISTS idx_team_wallet_operations_card ON team_wallet_operations(card_id) WHERE card_id IS NOT NULL;
+		ALTER TABLE IF EXISTS team_wallet_operations DISABLE ROW LEVEL SECURITY;
+	`)
+	if err != nil {
+		log.Printf("[TEAM-WALLET] Error ensuring tables: %v", err)
+		return err
+	}
+	log.Println("[TEAM-WALLET] ✅ team_wallet_balances, team_member_allowances and team_wallet_operations tables ensured")
+	return nil
+}
+
+// requireTeamPermission checks inside a transaction that the user is a member with perm
+// and returns the member's effective permissions.
+func requireTeamPermission(tx *sql.Tx, teamID, userID int, perm string) ([]string, error) {
+	_, perms, ok, err := teamMemberPermissions(tx, teamID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось проверить права: %v", err)
+	}
+	if !ok || !HasPermission(perms, perm) {
+		return nil, ErrTeamAccessDenied
+	}
+	return perms, nil
+}
+
+// lockTeamWalletBalance locks the team wallet row in one currency and returns its balance.
+func lockTeamWalletBalance(tx *sql.Tx, teamID int, currency string) (decimal.Decimal, error) {
+	_, err := tx.Exec(
+		`INSERT INTO team_wallet_balances (team_id, currency, balance, updated_at) VALUES ($1, $2, 0, NOW())
+		 ON CONFLICT (team_id, currency) DO NOTHING`, teamID, currency,
+	)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	var balance decimal.Decimal
+	err = tx.QueryRow(
+		`SELECT balance FROM team_wallet_balances WHERE team_id = $1 AND currency = $2 FOR UPDATE`, teamID, currency,
+	).Scan(&balance)
+	return balance, err
+}
+
+// insertTeamWalletOperation logs a team wallet movement together with the member who made it.
+func insertTeamWalletOperation(tx *sql.Tx, teamID, userID int, cardID *int, operation string, amount decimal.Decimal, currency string, txID int) error {
+	_, err := tx.Exec(
+		`INSERT INTO team_wallet_operations (team_id, user_id, card_id, operation, amount, currency, transaction_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		teamID, userID, cardID, operation, amount, currency, txID,
+	)
+	if err != nil {
+		return fmt.Errorf("не удалось записать операцию Кошелька команды: %v", err)
+	}
+	return nil
+}
+
+// memberSpentSince sums what a member spent from the team wallet (card funding plus issue fees
+// net of refunds) since the given time, converted into currency. cardID > 0 limits the sum to
+// funding of that card.
+func memberSpentSince(q interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}, teamID, userID, cardID int, since time.Time, currency string) (decimal.Decimal, error) {
+	query := `
+		SELECT currency, SUM(CASE WHEN operation = $4 THEN -amount ELSE amount END)
+		FROM team_wallet_operations
+		WHERE team_id = $1 AND user_id = $2 AND created_at >= $3 AND operation IN ($5, $6, $4)`
+	args := []interface{}{teamID, userID, since, domain.TeamWalletFeeRefund, domain.TeamWalletCardFund, domain.TeamWalletCardIssueFee}
+	if cardID > 0 {
+		query += ` AND card_id = $7`
+		args = append(args, cardID)
+	}
+	rows, err := q.Query(query+` GROUP BY currency`, args...)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	defer rows.Close()
+	total := decimal.Zero
+	for rows.Next() {
+		var cur string
+		var sum decimal.Decimal
+		if err := rows.Scan(&cur, &sum); err != nil {
+			return decimal.Zero, err
+		}
+		rate, err := CrossRate(cur, currency)
+		if err != nil {
+			return decimal.Zero, err
+		}
+		total = total.Add(sum.Mul(rate).Round(2))
+	}
+	return total, rows.Err()
+}
+
+// monthStartUTC — начало текущего календарного месяца (UTC), с которого считается месячный лимит.
+func monthStartUTC(now time.Time) time.Time {
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// checkTeamAllowance проверяет лимиты участника перед тратой amount (в currency) из Кошелька команды.
+// Строка лимитов блокируется, чтобы параллельные траты одного участника не обошли лимит.
+// cardID > 0 — пополнение карты (проверяется и лимит на карту).
+func checkTeamAllowance(tx *sql.Tx, teamID, userID, cardID int, amount decimal.Decimal, currency string) error {
+	var limitCurrency string
+	var monthly, perCard decimal.NullDecimal
+	err := tx.QueryRow(
+		`SELECT currency, monthly_limit, per_card_limit FROM team_member_allowances
+		 WHERE team_id = $1 AND user_id = $2 FOR UPDATE`, teamID, userID,
+	).Scan(&limitCurrency, &monthly, &perCard)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("не удалось проверить лимиты участника: %v", err)
+	}
+	rate, err := CrossRate(currency, limitCurrency)
+	if err != nil {
+		return fmt.Errorf("курс %s/%s недоступен", currency, limitCurrency)
+	}
+	converted := amount.Mul(rate).Round(2)
+
+	if monthly.Valid {
+		spent, err := memberSpentSince(tx, teamID, userID, 0, monthStartUTC(time.Now()), limitCurrency)
+		if err != nil {
+			return fmt.Errorf("не удалось посчитать траты участника: %v", err)
+		}
+		if spent.Add(converted).GreaterThan(monthly.Decimal) {
+			return fmt.Errorf("%w: monthly budget %s %s, already spent %s, requested %s",
+				ErrTeamAllowanceExceeded, monthly.Decimal.StringFixed(2), limitCurrency, spent.StringFixed(2), converted.StringFixed(2))
+		}
+	}
+	if perCard.Valid && cardID > 0 {
+		funded, err := memberSpentSince(tx, teamID, userID, cardID, time.Time{}, limitCurrency)
+		if err != nil {
+			return fmt.Errorf("не удалось посчитать пополнения карты: %v", err)
+		}
+		if funded.Add(converted).GreaterThan(perCard.Decimal) {
+			return fmt.Errorf("%w: per-card cap %s %s, card already funded with %s, requested %s",
+				ErrTeamAllowanceExceeded, perCard.Decimal.StringFixed(2), limitCurrency, funded.StringFixed(2), converted.StringFixed(2))
+		}
+	}
+	return nil
+}
+
+// GetTeamWalletBalances — Кошельки команды во всех валютах WalletCurrencies (отсутствующие — с нулём).
+func GetTeamWalletBalances(teamID int) ([]domain.WalletBalance, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	rows, err := GlobalDB.Query(`SELECT currency, balance, updated_at FROM team_wallet_balances WHERE team_id = $1`, teamID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get team wallet balances: %w", err)
+	}
+	defer rows.Close()
+	byCurrency := map[string]domain.WalletBalance{}
+	for rows.Next() {
+		var w domain.WalletBalance
+		var updated time.Time
+		if err := rows.Scan(&w.Currency, &w.Balance, &updated); err != nil {
+			return nil, err
+		}
+		w.AvailableBalance = w.Balance
+		w.UpdatedAt = &updated
+		byCurrency[w.Currency] = w
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	wallets := make([]domain.WalletBalance, 0, len(WalletCurrencies))
+	for _, c := range WalletCurrencies {
+		w, ok := byCurrency[c]
+		if !ok {
+			w = domain.WalletBalance{Currency: c}
+		}
+		wallets = append(wallets, w)
+	}
+	return wallets, nil
+}
+
+// TopUpTeamWallet — перевести amount из Кошелька пользователя в той же валюте в Кошелёк команды.
+// Нужно право wallet.fund.
+func TopUpTeamWallet(teamID, userID int, amount decimal.Decimal, currency string) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	if !amount.IsPositive() {
+		return fmt.Errorf("сумма должна быть положительной")
+	}
+	currency, err := NormalizeWalletCurrency(currency)
+	if err != nil {
+		return err
+	}
+	amount = amount.Round(2)
+
+	tx, err := GlobalDB.Begin()
+	if err != nil {
+		return fmt.Errorf("не удалось начать транзакцию: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := requireTeamPermission(tx, teamID, userID, domain.PermWalletFund); err != nil {
+		return err
+	}
+	balance, err := LockWalletBalance(tx, userID, currency)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("кошелёк не найден — пополните баланс")
+	}
+	if err != nil {
+		return fmt.Errorf("не удалось заблокировать кошелёк: %v", err)
+	}
+	if balance.LessThan(amount) {
+		return fmt.Errorf("недостаточно средств в кошельке %s (баланс: %s, требуется: %s)", currency, balance.StringFixed(2), amount.StringFixed(2))
+	}
+
+	details := fmt.Sprintf("Team wallet top-up: %s %s → team #%d", amount.StringFixed(2), currency, teamID)
+	var txID int
+	err = tx.QueryRow(
+		`INSERT INTO transactions (user_id, amount, fee, transaction_type, status, details, currency, wallet_currency, executed_at)
+		 VALUES ($1, $2, 0, 'TEAM_WALLET_TOPUP', 'APPROVED', $3, $4, $4, $5) RETURNING id`,
+		userID, amount, details, currency, time.Now(),
+	).Scan(&txID)
+	if err != nil {
+		return fmt.Errorf("не удалось записать транзакцию: %v", err)
+	}
+	_, err = ledger.Post(tx, ledger.Entry{
+		Type:          "TEAM_WALLET_TOPUP",
+		TransactionID: txID,
+		Description:   details,
+		Lines:         ledger.Move(ledger.UserWallet(userID), ledger.TeamWallet(teamID), amount, currency),
+	})
+	if err != nil {
+		return fmt.Errorf("не удалось пополнить Кошелёк команды: %v", err)
+	}
+	if err := insertTeamWalletOperation(tx, teamID, userID, nil, domain.TeamWalletTopUp, amount, currency, txID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ошибка фиксации: %v", err)
+	}
+	log.Printf("[TEAM-WALLET] User %d topped up team %d wallet with %s %s", userID, teamID, amount.StringFixed(2), currency)
+	return nil
+}
+
+// FundTeamCard — пополнить карту команды из Кошелька команды в валюте карты.
+// Нужно право wallet.fund: с правом team.manage — любую карту команды, без него — только выпущенные участником карты;
+// трата проверяется по лимитам участника (team_member_allowances).
+// fund (если задан) вызывается после фиксации, без блокировок БД (finishCardTopUp): если эмитент отклонил
+// пополнение, перевод сторнируется.
+func FundTeamCard(teamID, userID, cardID int, amount decimal.Decimal, fund CardFundFunc) (decimal.Decimal, error) {
+	if GlobalDB == nil {
+		return decimal.Zero, fmt.Errorf("database connection not initialized")
+	}
+	if !amount.IsPositive() {
+		return decimal.Zero, fmt.Errorf("сумма должна быть положительной")
+	}
+	amount = amount.Round(2)
+
+	tx, err := GlobalDB.Begin()
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("не удалось начать транзакцию: %v", err)
+	}
+	defer tx.Rollback()
+
+	var ownerID int
+	var cardTeamID sql.NullInt64
+	var currency string
+	err = tx.QueryRow(`SELECT user_id, team_id, COALESCE(currency, 'USD') FROM cards WHERE id = $1 FOR UPDATE`, cardID).
+		Scan(&ownerID, &cardTeamID, &currency)
+	if err != nil || !cardTeamID.Valid || int(cardTeamID.Int64) != teamID {
+		return decimal.Zero, fmt.Errorf("карта не найдена в команде")
+	}
+	// Чужие карты команды пополняют только участники с правом team.manage
+	perms, err := requireTeamPermission(tx, teamID, userID, domain.PermWalletFund)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	if ownerID != userID && !HasPermission(perms, domain.PermTeamManage) {
+		return decimal.Zero, ErrTeamAccessDenied
+	}
+	if currency, err = NormalizeWalletCurrency(currency); err != nil {
+		return decimal.Zero, err
+	}
+	if err := checkTeamAllowance(tx, teamID, userID, cardID, amount, currency); err != nil {
+		return decimal.Zero, err
+	}
+	balance, err := lockTeamWalletBalance(tx, teamID, currency)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("не удалось заблокировать Кошелёк команды: %v", err)
+	}
+	if balance.LessThan(amount) {
+		return decimal.Zero, fmt.Errorf("%w: balance %s %s, required %s", ErrTeamWalletInsufficientFunds,
+			balance.StringFixed(2), currency, amount.StringFixed(2))
+	}
+
+	details := fmt.Sprintf("Team card top-up: %s %s → card #%d from team #%d wallet", amount.StringFixed(2), currency, cardID, teamID)
+	var txID int
+	err = tx.QueryRow(
+		`INSERT INTO transactions (user_id, card_id, amount, fee, transaction_type, status, details, currency, wallet_currency, executed_at)
+		 VALUES ($1, $2, $3, 0, 'TEAM_CARD_TOPUP', 'PENDING', $4, $5, $5, $6) RETURNING id`,
+		userID, cardID, amount, details, currency, time.Now(),
+	).Scan(&txID)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("не удалось записать транзакцию: %v", err)
+	}
+	lines := ledger.Move(ledger.TeamWallet(teamID), ledger.Card(cardID), amount, currency)
+	_, err = ledger.Post(tx, ledger.Entry{
+		Type:          "TEAM_CARD_TOPUP",
+		TransactionID: txID,
+		Description:   details,
+		Lines:         lines,
+	})
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("не удалось перевести на карту: %v", err)
+	}
+	if err := insertTeamWalletOperation(tx, teamID, userID, &cardID, domain.TeamWalletCardFund, amount, currency, txID); err != nil {
+		return decimal.Zero, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return decimal.Zero, fmt.Errorf("ошибка фиксации: %v", err)
+	}
+	if err := finishCardTopUp(txID, "TEAM_CARD_TOPUP", details, lines, fund, amount, currency, nil); err != nil {
+		return decimal.Zero, err
+	}
+	log.Printf("[TEAM-WALLET] User %d funded team %d card %d with %s %s", userID, teamID, cardID, amount.StringFixed(2), currency)
+	return balance.Sub(amount), nil
+}
+
+// DeductTeamWalletFee — списать комиссию за выпуск карт команды из Кошелька команды (USD)
+// с учётом месячного лимита участника. Нужно право cards.issue.
+func DeductTeamWalletFee(teamID, userID int, amount decimal.Decimal, details string) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	if !amount.IsPositive() {
+		return fmt.Errorf("сумма списания должна быть положительной")
+	}
+
+	tx, err := GlobalDB.Begin()
+	if err != nil {
+		return fmt.Errorf("не удалось начать транзакцию: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := requireTeamPermission(tx, teamID, userID, domain.PermCardsIssue); err != nil {
+		return err
+	}
+	if err := checkTeamAllowance(tx, teamID, userID, 0, amount, WalletCurrency); err != nil {
+		return err
+	}
+	balance, err := lockTeamWalletBalance(tx, teamID, WalletCurrency)
+	if err != nil {
+		return fmt.Errorf("не удалось заблокировать Кошелёк команды: %v", err)
+	}
+	if balance.LessThan(amount) {
+		return fmt.Errorf("%w: balance $%s, required $%s", ErrTeamWalletInsufficientFunds, balance.StringFixed(2), amount.StringFixed(2))
+	}
+
+	var txID int
+	err = tx.QueryRow(
+		`INSERT INTO transactions (user_id, amount, fee, transaction_type, status, details, executed_at)
+		 VALUES ($1, $2, 0, 'TEAM_CARD_ISSUE_FEE', 'APPROVED', $3, $4) RETURNING id`,
+		userID, amount, details, time.Now(),
+	).Scan(&txID)
+	if err != nil {
+		return fmt.Errorf("не удалось записать транзакцию: %v", err)
+	}
+	_, err = ledger.Post(tx, ledger.Entry{
+		Type:          "TEAM_CARD_ISSUE_FEE",
+		TransactionID: txID,
+		Description:   details,
+		Lines:         ledger.Move(ledger.TeamWallet(teamID), ledger.FeeRevenue, amount, WalletCurrency),
+	})
+	if err != nil {
+		return fmt.Errorf("не удалось списать из Кошелька команды: %v", err)
+	}
+	if err := insertTeamWalletOperation(tx, teamID, userID, nil, domain.TeamWalletCardIssueFee, amount, WalletCurrency, txID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ошибка фиксации: %v", err)
+	}
+	log.Printf("[TEAM-WALLET] User %d: charged team %d wallet $%s for: %s", userID, teamID, amount.StringFixed(2), details)
+	return nil
+}
+
+// RefundTeamWalletFee — вернуть комиссию (DeductTeamWalletFee) в Кошелёк команды; возврат уменьшает траты участника.
+func RefundTeamWalletFee(teamID, userID int, amount decimal.Decimal, details string) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	if !amount.IsPositive() {
+		return fmt.Errorf("сумма возврата должна быть положительной")
+	}
+
+	tx, err := GlobalDB.Begin()
+	if err != nil {
+		return fmt.Errorf("не удалось начать транзакцию: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := refundTeamWalletFeeTx(tx, teamID, userID, amount, details); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ошибка фиксации: %v", err)
+	}
+	log.Printf("[TEAM-WALLET] User %d: refunded $%s to team %d wallet for: %s", userID, amount.StringFixed(2), teamID, details)
+	return nil
+}
+
+// refundTeamWalletFeeTx — возврат комиссии в Кошелёк команды внутри транзакции вызывающего:
+// TEAM_FEE_REFUND, проводка доход → Кошелёк команды и строка team_wallet_operations на участника.
+func refundTeamWalletFeeTx(tx *sql.Tx, teamID, userID int, amount decimal.Decimal, details string) error {
+	var txID int
+	err := tx.QueryRow(
+		`INSERT INTO transactions (user_id, amount, fee, transaction_type, status, details, executed_at)
+		 VALUES ($1, $2, 0, 'TEAM_FEE_REFUND', 'APPROVED', $3, $4) RETURNING id`,
+		userID, amount, details, time.Now(),
+	).Scan(&txID)
+	if err != nil {
+		return fmt.Errorf("не удалось записать транзакцию: %v", err)
+	}
+	_, err = ledger.Post(tx, ledger.Entry{
+		Type:          "TEAM_FEE_REFUND",
+		TransactionID: txID,
+		Description:   details,
+		Lines:         ledger.Move(ledger.FeeRevenue, ledger.TeamWallet(teamID), amount, WalletCurrency),
+	})
+	if err != nil {
+		return fmt.Errorf("не удалось вернуть в Кошелёк команды: %v", err)
+	}
+	return insertTeamWalletOperation(tx, teamID, userID, nil, domain.TeamWalletFeeRefund, amount, WalletCurrency, txID)
+}
+
+// SetTeamMemberAllowance задаёт лимиты участника (nil — без лимита). Владелец команды лимитов не имеет.
+func SetTeamMemberAllowance(teamID, userID int, currency string, monthly, perCard *decimal.Decimal, updatedBy int) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	currency, err := NormalizeWalletCurrency(currency)
+	if err != nil {
+		return err
+	}
+	for _, l := range []*decimal.Decimal{monthly, perCard} {
+		if l != nil && l.IsNegative() {
+			return fmt.Errorf("limits must not be negative")
+		}
+	}
+	ok, _, err := CheckTeamAccess(teamID, userID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("member not found")
+	}
+	var ownerID int
+	if err := GlobalDB.QueryRow(`SELECT owner_id FROM teams WHERE id = $1`, teamID).Scan(&ownerID); err != nil {
+		return err
+	}
+	if ownerID == userID {
+		return fmt.Errorf("team owner has no allowance")
+	}
+	_, err = GlobalDB.Exec(`
+		INSERT INTO team_member_allowances (team_id, user_id, currency, monthly_limit, per_card_limit, updated_by, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		ON CONFLICT (team_id, user_id) DO UPDATE
+		SET currency = $3, monthly_limit = $4, per_card_limit = $5, updated_by = $6, updated_at = NOW()`,
+		teamID, userID, currency, monthly, perCard, updatedBy)
+	if err != nil {
+		return fmt.Errorf("failed to save allowance: %w", err)
+	}
+	log.Printf("[TEAM-WALLET] Team %d: allowance of user %d set by %d (monthly=%v, per_card=%v %s)",
+		teamID, userID, updatedBy, monthly, perCard, currency)
+	return nil
+}
+
+// DeleteTeamMemberAllowance снимает лимиты участника.
+func DeleteTeamMemberAllowance(teamID, userID int) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	_, err := GlobalDB.Exec(`DELETE FROM team_member_allowances WHERE team_id = $1 AND user_id = $2`, teamID, userID)
+	return err
+}
+
+// GetTeamMemberAllowances returns the allowances of a team with each member's spend this month.
+// userID > 0 limits the result to one member.
+func GetTeamMemberAllowances(teamID, userID int) ([]domain.TeamMemberAllowance, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	query := `
+		SELECT a.team_id, a.user_id, COALESCE(u.email, ''), a.currency, a.monthly_limit, a.per_card_limit,
+		       COALESCE(a.updated_by, 0), a.updated_at
+		FROM team_member_allowances a
+		LEFT JOIN users u ON u.id = a.user_id
+		WHERE a.team_id = $1`
+	args := []interface{}{teamID}
+	if userID > 0 {
+		query += ` AND a.user_id = $2`
+		args = append(args, userID)
+	}
+	rows, err := GlobalDB.Query(query+` ORDER BY a.user_id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list allowances: %w", err)
+	}
+	defer rows.Close()
+	list := []domain.TeamMemberAllowance{}
+	for rows.Next() {
+		var a domain.TeamMemberAllowance
+		var monthly, perCard decimal.NullDecimal
+		if err := rows.Scan(&a.TeamID, &a.UserID, &a.Email, &a.Currency, &monthly, &perCard, &a.UpdatedBy, &a.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if monthly.Valid {
+			a.MonthlyLimit = &monthly.Decimal
+		}
+		if perCard.Valid {
+			a.PerCardLimit = &perCard.Decimal
+		}
+		list = append(list, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range list {
+		spent, err := memberSpentSince(GlobalDB, teamID, list[i].UserID, 0, monthStartUTC(time.Now()), list[i].Currency)
+		if err != nil {
+			log.Printf("[TEAM-WALLET] Failed to sum spend of user %d in team %d: %v", list[i].UserID, teamID, err)
+			continue
+		}
+		list[i].MonthSpent = spent
+	}
+	return list, nil
+}
+
+// GetTeamSpendBreakdown returns per-member spend from the team wallet in [since, until), one row per member and currency.
+func GetTeamSpendBreakdown(teamID int, since, until time.Time) ([]domain.TeamMemberSpend, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	rows, err := GlobalDB.Query(`
+		SELECT o.user_id, COALESCE(u.email, ''), o.currency,
+		       COALESCE(SUM(o.amount) FILTER (WHERE o.operation = $4), 0),
+		       COALESCE(SUM(o.amount) FILTER (WHERE o.operation = $5), 0)
+		         - COALESCE(SUM(o.amount) FILTER (WHERE o.operation = $6), 0),
+		       COALESCE(SUM(o.amount) FILTER (WHERE o.operation = $7), 0),
+		       COUNT(DISTINCT o.card_id) FILTER (WHERE o.operation = $4)
+		FROM team_wallet_operations o
+		LEFT JOIN users u ON u.id = o.user_id
+		WHERE o.team_id = $1 AND o.created_at >= $2 AND o.created_at < $3
+		GROUP BY o.user_id, u.email, o.currency
+		ORDER BY o.user_id, o.currency`,
+		teamID, since, until, domain.TeamWalletCardFund, domain.TeamWalletCardIssueFee, domain.TeamWalletFeeRefund, domain.TeamWalletTopUp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build spend breakdown: %w", err)
+	}
+	defer rows.Close()
+	list := []domain.TeamMemberSpend{}
+	for rows.Next() {
+		var s domain.TeamMemberSpend
+		if err := rows.Scan(&s.UserID, &s.Email, &s.Currency, &s.CardFunding, &s.IssueFees, &s.TopUps, &s.CardsFunded); err != nil {
+			return nil, err
+		}
+		s.Total = s.CardFunding.Add(s.IssueFees)
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
+
+// ListTeamWalletOperations returns the latest team wallet operations, newest first.
+func ListTeamWalletOperations(teamID, limit int) ([]domain.TeamWalletOperation, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	rows, err := GlobalDB.Query(`
+		SELECT id, team_id, user_id, card_id, operation, amount, currency, transaction_id, created_at
+		FROM team_wallet_operations WHERE team_id = $1
+		ORDER BY id DESC LIMIT $2`, teamID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ops := []domain.TeamWalletOperation{}
+	for rows.Next() {
+		var op domain.TeamWalletOperation
+		var cardID sql.NullInt64
+		if err := rows.Scan(&op.ID, &op.TeamID, &op.UserID, &cardID, &op.Operation, &op.Amount, &op.Currency,
+			&op.TransactionID, &op.CreatedAt); err != nil {
+			return nil, err
+		}
+		if cardID.Valid {
+			id := int(cardID.Int64)
+			op.CardID = &id
+		}
+		ops = append(ops, op)
+	}
+	return ops, rows.Err()
+}
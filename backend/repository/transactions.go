@@ -34,6 +34,7 @@ func RecordTransaction(userID int, cardID *int, amount decimal.Decimal, fee deci
 
 // GetUnifiedTransactions — получить все транзакции пользователя с JOIN на cards для last4.
 // Поддерживает фильтры: start_date, end_date, source_type, search, limit, offset.
+// Активные холды по картам (source_type=card_hold, статус PENDING) добавляются в начало первой страницы.
 func GetUnifiedTransactions(userID int, filters map[string]interface{}) ([]domain.Transaction, int, error) {
 	if GlobalDB == nil {
 		return nil, 0, fmt.Errorf("database connection not initialized")
@@ -166,6 +167,17 @@ func GetUnifiedTransactions(userID int, filters map[string]interface{}) ([]domai
 		txs = append(txs, tx)
 	}
 
+	// Незавершённые холды показываются сверху первой страницы как PENDING
+	if ov, _ := filters["offset"].(int); ov <= 0 {
+		holds, err := pendingHoldTransactions(userID, filters)
+		if err != nil {
+			log.Printf("Error loading pending holds for user %d: %v", userID, err)
+		} else if len(holds) > 0 {
+			txs = append(holds, txs...)
+			total += len(holds)
+		}
+	}
+
 	if txs == nil {
 		txs = []domain.Transaction{}
 	}
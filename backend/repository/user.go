@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/ledger"
 	"github.com/shopspring/decimal"
 )
 
@@ -22,14 +23,14 @@ func CreateUser(user domain.User) (domain.User, error) {
 	}
 
 	queryUser := `
-		INSERT INTO users (email, password_hash, balance, balance_rub, kyc_status, active_mode, status) 
-		VALUES ($1, $2, 0.00, 0.00, 'pending', 'personal', 'ACTIVE') 
-		RETURNING id, created_at, balance, balance_rub
+		INSERT INTO users (email, password_hash, kyc_status, active_mode, status) 
+		VALUES ($1, $2, 'pending', 'personal', 'ACTIVE') 
+		RETURNING id, created_at
 	`
 	var createdUser domain.User
 
 	err := GlobalDB.QueryRow(queryUser, user.Email, user.PasswordHash).
-		Scan(&createdUser.ID, &createdUser.CreatedAt, &createdUser.Balance, &createdUser.BalanceRub)
+		Scan(&createdUser.ID, &createdUser.CreatedAt)
 
 	if err != nil {
 		log.Printf("Error creating user %s: %v", user.Email, err)
@@ -74,7 +75,7 @@ func GetUserByEmail(email string) (domain.User, error) {
 		return domain.User{}, fmt.Errorf("database connection not initialized")
 	}
 
-	query := `SELECT id, email, password_hash, balance, COALESCE(balance_rub, 0), COALESCE(balance_arbitrage, 0), COALESCE(balance_personal, 0), COALESCE(kyc_status, ''), COALESCE(active_mode, 'personal'), created_at, status, telegram_chat_id, COALESCE(is_admin, FALSE), COALESCE(is_verified, FALSE), COALESCE(role, 'user') FROM users WHERE email = $1`
+	query := `SELECT id, email, password_hash, COALESCE(kyc_status, ''), COALESCE(active_mode, 'personal'), created_at, status, telegram_chat_id, COALESCE(is_admin, FALSE), COALESCE(is_verified, FALSE), COALESCE(role, 'user') FROM users WHERE email = $1`
 
 	var user domain.User
 
@@ -82,10 +83,6 @@ func GetUserByEmail(email string) (domain.User, error) {
 		&user.ID,
 		&user.Email,
 		&user.PasswordHash,
-		&user.Balance,
-		&user.BalanceRub,
-		&user.BalanceArbitrage,
-		&user.BalancePersonal,
 		&user.KYCStatus,
 		&user.ActiveMode,
 		&user.CreatedAt,
@@ -113,7 +110,7 @@ func GetUserByID(userID int) (domain.User, error) {
 		return domain.User{}, fmt.Errorf("database connection not initialized")
 	}
 
-	query := `SELECT id, email, password_hash, balance, COALESCE(balance_rub, 0), COALESCE(balance_arbitrage, 0), COALESCE(balance_personal, 0), COALESCE(kyc_status, ''), COALESCE(active_mode, 'personal'), created_at, status, telegram_chat_id, COALESCE(is_admin, FALSE), COALESCE(is_verified, FALSE), COALESCE(role, 'user'), COALESCE(display_name, '') FROM users WHERE id = $1`
+	query := `SELECT id, email, password_hash, COALESCE(kyc_status, ''), COALESCE(active_mode, 'personal'), created_at, status, telegram_chat_id, COALESCE(is_admin, FALSE), COALESCE(is_verified, FALSE), COALESCE(role, 'user'), COALESCE(display_name, '') FROM users WHERE id = $1`
 
 	var user domain.User
 
@@ -121,10 +118,6 @@ func GetUserByID(userID int) (domain.User, error) {
 		&user.ID,
 		&user.Email,
 		&user.PasswordHash,
-		&user.Balance,
-		&user.BalanceRub,
-		&user.BalanceArbitrage,
-		&user.BalancePersonal,
 		&user.KYCStatus,
 		&user.ActiveMode,
 		&user.CreatedAt,
@@ -165,59 +158,7 @@ func UpdateTelegramChatID(userID int, chatID int) error {
 	return nil
 }
 
-// DeductBalance - Списывает сумму с баланса пользователя (для оплаты выпуска карт и т.д.)
-func DeductBalance(userID int, amount decimal.Decimal, details string) error {
-	if GlobalDB == nil {
-		return fmt.Errorf("database connection not initialized")
-	}
-	if amount.LessThanOrEqual(decimal.Zero) {
-		return fmt.Errorf("сумма списания должна быть положительной")
-	}
-
-	tx, err := GlobalDB.Begin()
-	if err != nil {
-		return fmt.Errorf("не удалось начать транзакцию: %v", err)
-	}
-	defer tx.Rollback()
-
-	// Check balance
-	var balance decimal.Decimal
-	err = tx.QueryRow("SELECT COALESCE(balance, 0) FROM users WHERE id = $1", userID).Scan(&balance)
-	if err != nil {
-		return fmt.Errorf("не удалось получить баланс: %v", err)
-	}
-	if balance.LessThan(amount) {
-		return fmt.Errorf("недостаточно средств (баланс: $%s, требуется: $%s)", balance.StringFixed(2), amount.StringFixed(2))
-	}
-
-	// Deduct
-	_, err = tx.Exec(
-		"UPDATE users SET balance_rub = COALESCE(balance_rub, 0) - $1, balance = balance - $2 WHERE id = $3",
-		amount, amount, userID,
-	)
-	if err != nil {
-		return fmt.Errorf("не удалось списать баланс: %v", err)
-	}
-
-	// Record transaction
-	_, err = tx.Exec(
-		`INSERT INTO transactions (user_id, amount, fee, transaction_type, status, details, executed_at)
-			VALUES ($1, $2, $3, 'CARD_ISSUE_FEE', 'APPROVED', $4, $5)`,
-		userID, amount, decimal.Zero, details, time.Now(),
-	)
-	if err != nil {
-		log.Printf("DB Error Insert deduction transaction: %v", err)
-	}
-
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("ошибка фиксации: %v", err)
-	}
-
-	log.Printf("User %d: deducted $%s for: %s", userID, amount.StringFixed(2), details)
-	return nil
-}
-
-// ProcessDeposit - Обрабатывает пополнение баланса пользователя и записывает транзакцию.
+// ProcessDeposit - Зачисляет пополнение (USD) в Кошелёк пользователя и записывает транзакцию DEPOSIT.
 func ProcessDeposit(userID int, amount decimal.Decimal) error {
 	if GlobalDB == nil {
 		return fmt.Errorf("database connection not initialized")
@@ -235,31 +176,34 @@ func ProcessDeposit(userID int, amount decimal.Decimal) error {
 	}
 	defer tx.Rollback()
 
-	// 2. Увеличение баланса пользователя в рублях (XPLR: balance_rub)
-	_, err = tx.Exec(
-		"UPDATE users SET balance_rub = COALESCE(balance_rub, 0) + $1, balance = balance + $2 WHERE id = $3",
-		amount, amount, userID,
-	)
-	if err != nil {
-		log.Printf("DB Error Update Balance: %v", err)
-		return fmt.Errorf("не удалось обновить баланс")
-	}
-
-	// 3. Запись транзакции пополнения (FUND)
-	_, err = tx.Exec(
-		`INSERT INTO transactions (user_id, amount, fee, transaction_type, status, details, executed_at)
-			VALUES ($1, $2, $3, 'FUND', 'APPROVED', $4, $5)`,
+	// 2. Запись транзакции пополнения (DEPOSIT)
+	var txID int
+	err = tx.QueryRow(
+		`INSERT INTO transactions (user_id, amount, fee, transaction_type, status, details, currency, executed_at)
+			VALUES ($1, $2, 0, 'DEPOSIT', 'APPROVED', $3, $4, $5) RETURNING id`,
 		userID,
 		amount,
-		decimal.Zero, // Комиссия
 		fmt.Sprintf("Deposit via API. Amount: %s", amount.String()),
+		WalletCurrency,
 		time.Now(),
-	)
+	).Scan(&txID)
 	if err != nil {
 		log.Printf("DB Error Insert Transaction: %v", err)
 		return fmt.Errorf("не удалось записать транзакцию")
 	}
 
+	// 3. Зачисление в Кошелёк через журнал
+	_, err = ledger.Post(tx, ledger.Entry{
+		Type:          "DEPOSIT",
+		TransactionID: txID,
+		Description:   "Deposit via API",
+		Lines:         ledger.Move(ledger.ExternalSettlement, ledger.UserWallet(userID), amount, WalletCurrency),
+	})
+	if err != nil {
+		log.Printf("DB Error Credit Wallet: %v", err)
+		return fmt.Errorf("не удалось обновить баланс")
+	}
+
 	// 4. КОММИТ
 	if err := tx.Commit(); err != nil {
 		log.Printf("DB Error Commit: %v", err)
@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/shopspring/decimal"
+)
+
+// Коды отказа по скользящим лимитам (domain.AuthResponse.DeclineCode)
+const (
+	DeclineVelocityDaily   = "velocity_daily"
+	DeclineVelocityWeekly  = "velocity_weekly"
+	DeclineVelocityMonthly = "velocity_monthly"
+	DeclineVelocityHourly  = "velocity_hourly_count"
+)
+
+// VelocityDecline — превышенный скользящий лимит. Как ошибка возвращается из PlaceCardHold и
+// CaptureCardPayment, когда лимит превышен при повторной проверке под блокировкой карты.
+type VelocityDecline struct {
+	Code   string
+	Reason string
+}
+
+func (v *VelocityDecline) Error() string {
+	return "velocity limit exceeded: " + v.Reason
+}
+
+// EnsureVelocityLimitColumns adds weekly/monthly/hourly velocity limits to cards
+// and an index that keeps the rolling-window sums cheap.
+func EnsureVelocityLimitColumns() error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	_, err := GlobalDB.Exec(`
+		ALTER TABLE cards ADD COLUMN IF NOT EXISTS weekly_spend_limit NUMERIC(20,4) NOT NULL DEFAULT 0;
+		ALTER TABLE cards ADD COLUMN IF NOT EXISTS monthly_spend_limit NUMERIC(20,4) NOT NULL DEFAULT 0;
+		ALTER TABLE cards ADD COLUMN IF NOT EXISTS max_tx_per_hour INTEGER NOT NULL DEFAULT 0;
+		CREATE INDEX IF NOT EXISTS idx_transactions_card_executed ON transactions(card_id, executed_at);
+	`)
+	if err != nil {
+		log.Printf("[VELOCITY] Error ensuring velocity limit columns: %v", err)
+		return err
+	}
+	log.Println("[VELOCITY] ✅ Velocity limit columns ensured")
+	return nil
+}
+
+// GetCardVelocityLimits returns the velocity limits configured on a card.
+func GetCardVelocityLimits(cardID int) (domain.VelocityLimits, error) {
+	var l domain.VelocityLimits
+	if GlobalDB == nil {
+		return l, fmt.Errorf("database connection not initialized")
+	}
+	err := GlobalDB.QueryRow(`
+		SELECT COALESCE(daily_spend_limit, 0), COALESCE(weekly_spend_limit, 0),
+		       COALESCE(monthly_spend_limit, 0), COALESCE(max_tx_per_hour, 0)
+		FROM cards WHERE id = $1`, cardID,
+	).Scan(&l.Daily, &l.Weekly, &l.Monthly, &l.MaxTxPerHour)
+	if err == sql.ErrNoRows {
+		return l, fmt.Errorf("card not found")
+	}
+	return l, err
+}
+
+// SetCardVelocityLimits overwrites all velocity limits of a card.
+func SetCardVelocityLimits(cardID int, l domain.VelocityLimits) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	res, err := GlobalDB.Exec(`
+		UPDATE cards SET daily_spend_limit = $2, weekly_spend_limit = $3, monthly_spend_limit = $4, max_tx_per_hour = $5
+		WHERE id = $1`, cardID, l.Daily, l.Weekly, l.Monthly, l.MaxTxPerHour)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("card not found")
+	}
+	return nil
+}
+
+// EvaluateVelocity проверяет, уложится ли новая операция amount в скользящие лимиты карты.
+// Считается накопленная сумма вместе с новой операцией, а не одна операция.
+func EvaluateVelocity(limits domain.VelocityLimits, usage domain.VelocityUsage, amount decimal.Decimal) *VelocityDecline {
+	if limits.MaxTxPerHour > 0 && usage.TxLastHour+1 > limits.MaxTxPerHour {
+		return &VelocityDecline{DeclineVelocityHourly,
+			fmt.Sprintf("превышено число операций в час (лимит: %d)", limits.MaxTxPerHour)}
+	}
+	windows := []struct {
+		code  string
+		limit decimal.Decimal
+		used  decimal.Decimal
+		name  string
+	}{
+		{DeclineVelocityDaily, limits.Daily, usage.Day, "дневной"},
+		{DeclineVelocityWeekly, limits.Weekly, usage.Week, "недельный"},
+		{DeclineVelocityMonthly, limits.Monthly, usage.Month, "месячный"},
+	}
+	for _, win := range windows {
+		if win.limit.GreaterThan(decimal.Zero) && win.used.Add(amount).GreaterThan(win.limit) {
+			return &VelocityDecline{win.code,
+				fmt.Sprintf("превышен %s лимит (лимит: $%s, уже потрачено: $%s)",
+					win.name, win.limit.StringFixed(2), win.used.StringFixed(2))}
+		}
+	}
+	return nil
+}
+
+// GetCardVelocityUsage sums approved card spend over the rolling 24h / 7d / 30d windows
+// and counts approved charges in the last hour. Active holds count as spend.
+func GetCardVelocityUsage(cardID int) (domain.VelocityUsage, error) {
+	if GlobalDB == nil {
+		return domain.VelocityUsage{}, fmt.Errorf("database connection not initialized")
+	}
+	return cardVelocityUsage(GlobalDB, cardID, 0)
+}
+
+// cardVelocityUsage is GetCardVelocityUsage on q; the hold excludeHoldID (the one a capture
+// settles) is not counted.
+func cardVelocityUsage(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, cardID, excludeHoldID int) (domain.VelocityUsage, error) {
+	var u domain.VelocityUsage
+	err := q.QueryRow(`
+		SELECT
+			COALESCE(SUM(amount) FILTER (WHERE executed_at > NOW() - INTERVAL '24 hours'), 0),
+			COALESCE(SUM(amount) FILTER (WHERE executed_at > NOW() - INTERVAL '7 days'), 0),
+			COALESCE(SUM(amount), 0),
+			COUNT(*) FILTER (WHERE executed_at > NOW() - INTERVAL '1 hour')
+		FROM (
+			SELECT amount, executed_at FROM transactions
+			WHERE card_id = $1 AND transaction_type = 'CAPTURE' AND status = 'APPROVED'
+			UNION ALL
+			SELECT amount, created_at FROM card_holds
+			WHERE card_id = $1 AND status = 'ACTIVE' AND id <> $2
+		) spend
+		WHERE executed_at > NOW() - INTERVAL '30 days'`, cardID, excludeHoldID,
+	).Scan(&u.Day, &u.Week, &u.Month, &u.TxLastHour)
+	return u, err
+}
+
+// checkCardVelocity re-evaluates the velocity limits of a card inside tx. The caller must hold
+// the card row lock (cards ... FOR UPDATE), so concurrent authorizations of the same card see
+// each other's holds and captures. Returns *VelocityDecline when amount does not fit.
+func checkCardVelocity(tx *sql.Tx, cardID, excludeHoldID int, amount decimal.Decimal) error {
+	var l domain.VelocityLimits
+	err := tx.QueryRow(`
+		SELECT COALESCE(daily_spend_limit, 0), COALESCE(weekly_spend_limit, 0),
+		       COALESCE(monthly_spend_limit, 0), COALESCE(max_tx_per_hour, 0)
+		FROM cards WHERE id = $1`, cardID,
+	).Scan(&l.Daily, &l.Weekly, &l.Monthly, &l.MaxTxPerHour)
+	if err != nil {
+		return fmt.Errorf("failed to get velocity limits: %w", err)
+	}
+	if !l.Daily.IsPositive() && !l.Weekly.IsPositive() && !l.Monthly.IsPositive() && l.MaxTxPerHour <= 0 {
+		return nil
+	}
+	usage, err := cardVelocityUsage(tx, cardID, excludeHoldID)
+	if err != nil {
+		return fmt.Errorf("failed to get velocity usage: %w", err)
+	}
+	if v := EvaluateVelocity(l, usage, amount); v != nil {
+		return v
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -41,9 +42,14 @@ func NewWallesterRepository() *WallesterRepository {
 		apiURL = "https://api.wallester.com/v1" // Дефолтный URL
 	}
 
+	return NewWallesterClient(apiKey, apiURL)
+}
+
+// NewWallesterClient создает репозиторий с явными ключом и адресом API (без чтения env)
+func NewWallesterClient(apiKey, apiURL string) *WallesterRepository {
 	return &WallesterRepository{
 		apiKey: apiKey,
-		apiURL: apiURL,
+		apiURL: strings.TrimRight(apiURL, "/"),
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
@@ -81,9 +87,133 @@ type WallesterCardDetailsResponse struct {
 
 // WallesterBalanceResponse - ответ с балансом карты
 type WallesterBalanceResponse struct {
-	Success bool   `json:"success"`
-	Balance string `json:"balance,omitempty"`
-	Error   string `json:"error,omitempty"`
+	Success  bool   `json:"success"`
+	Balance  string `json:"balance,omitempty"`
+	Currency string `json:"currency,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// WallesterTopUpRequest - запрос на пополнение карты в Wallester
+type WallesterTopUpRequest struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// doRequest - общий вызов Wallester API: авторизация, проверка статуса, разбор JSON в out
+func (wr *WallesterRepository) doRequest(method, path string, payload interface{}, out interface{}) error {
+	if wr.apiKey == "" {
+		return fmt.Errorf("WALLESTER_API_KEY not configured")
+	}
+
+	var reqBody io.Reader
+	if payload != nil {
+		jsonData, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		reqBody = bytes.NewBuffer(jsonData)
+	}
+
+	req, err := http.NewRequest(method, wr.apiURL+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", wr.apiKey))
+
+	resp, err := wr.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("wallester API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("wallester API error (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to parse wallester response: %w", err)
+	}
+	return nil
+}
+
+// CreateCard - создание карты в Wallester (только вызов API, без записи в БД)
+func (wr *WallesterRepository) CreateCard(reqBody WallesterCardRequest) (*WallesterCardResponse, error) {
+	var wallesterResp WallesterCardResponse
+	if err := wr.doRequest("POST", "/cards", reqBody, &wallesterResp); err != nil {
+		return nil, err
+	}
+
+	if !wallesterResp.Success || wallesterResp.CardID == "" {
+		return nil, fmt.Errorf("wallester card creation failed: %s", wallesterResp.Error)
+	}
+	return &wallesterResp, nil
+}
+
+// GetBalance - текущий баланс карты в Wallester и его валюта
+func (wr *WallesterRepository) GetBalance(externalID string) (decimal.Decimal, string, error) {
+	var balanceResp WallesterBalanceResponse
+	if err := wr.doRequest("GET", "/cards/"+externalID+"/balance", nil, &balanceResp); err != nil {
+		return decimal.Zero, "", err
+	}
+
+	if !balanceResp.Success {
+		return decimal.Zero, "", fmt.Errorf("wallester error: %s", balanceResp.Error)
+	}
+
+	balance, err := decimal.NewFromString(balanceResp.Balance)
+	if err != nil {
+		return decimal.Zero, "", fmt.Errorf("invalid balance format: %w", err)
+	}
+	return balance, balanceResp.Currency, nil
+}
+
+// TopUpCard - пополнение карты в Wallester, возвращает новый баланс
+func (wr *WallesterRepository) TopUpCard(externalID string, amount decimal.Decimal, currency string) (decimal.Decimal, error) {
+	var balanceResp WallesterBalanceResponse
+	reqBody := WallesterTopUpRequest{Amount: amount.StringFixed(2), Currency: currency}
+	if err := wr.doRequest("POST", "/cards/"+externalID+"/top-up", reqBody, &balanceResp); err != nil {
+		return decimal.Zero, err
+	}
+
+	if !balanceResp.Success {
+		return decimal.Zero, fmt.Errorf("wallester top-up failed: %s", balanceResp.Error)
+	}
+
+	balance, err := decimal.NewFromString(balanceResp.Balance)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("invalid balance format: %w", err)
+	}
+	return balance, nil
+}
+
+// BlockCard - заморозка карты в Wallester
+func (wr *WallesterRepository) BlockCard(externalID string) (*WallesterCardResponse, error) {
+	return wr.changeCardState(externalID, "block")
+}
+
+// UnblockCard - разморозка карты в Wallester
+func (wr *WallesterRepository) UnblockCard(externalID string) (*WallesterCardResponse, error) {
+	return wr.changeCardState(externalID, "unblock")
+}
+
+func (wr *WallesterRepository) changeCardState(externalID, action string) (*WallesterCardResponse, error) {
+	var cardResp WallesterCardResponse
+	if err := wr.doRequest("POST", "/cards/"+externalID+"/"+action, nil, &cardResp); err != nil {
+		return nil, err
+	}
+
+	if !cardResp.Success {
+		return nil, fmt.Errorf("wallester %s failed: %s", action, cardResp.Error)
+	}
+	return &cardResp, nil
 }
 
 // GetServiceIDBySlug получает service_id из таблицы services по slug
@@ -119,17 +249,17 @@ func (wr *WallesterRepository) IssueCard(
 		return nil, fmt.Errorf("database connection not initialized")
 	}
 
-	// 1. Проверка баланса пользователя (balance_rub)
-	user, err := GetUserByID(userID)
+	// 1. Проверка доступного баланса Кошелька (USD)
+	wallet, err := GetInternalBalance(userID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user: %w", err)
+		return nil, fmt.Errorf("failed to get wallet: %w", err)
 	}
 
-	// Минимальная сумма для выпуска карты + начальное пополнение (например, 100 руб)
+	// Минимальная сумма для выпуска карты + начальное пополнение
 	minRequired := decimal.NewFromInt(100)
-	if user.BalanceRub.LessThan(minRequired) {
+	if wallet.AvailableBalance.LessThan(minRequired) {
 		return nil, fmt.Errorf("insufficient funds: required %s, available %s",
-			minRequired.String(), user.BalanceRub.String())
+			minRequired.String(), wallet.AvailableBalance.String())
 	}
 
 	// 2. Получение service_id по slug
@@ -138,51 +268,15 @@ func (wr *WallesterRepository) IssueCard(
 		return nil, fmt.Errorf("failed to get service_id: %w", err)
 	}
 
-	// 3. Подготовка запроса к Wallester API
-	reqBody := WallesterCardRequest{
+	// 3. Создание карты в Wallester
+	wallesterResp, err := wr.CreateCard(WallesterCardRequest{
 		Currency: "USD",
 		CardType: cardType,
 		CardName: nickname,
 		Amount:   "0", // Начальный баланс 0, пополнение через наш баланс
-	}
-
-	jsonData, err := json.Marshal(reqBody)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	// 4. POST-запрос к Wallester
-	url := fmt.Sprintf("%s/cards", wr.apiURL)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", wr.apiKey))
-
-	resp, err := wr.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("wallester API request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return nil, fmt.Errorf("wallester API error (status %d): %s", resp.StatusCode, string(body))
-	}
-
-	var wallesterResp WallesterCardResponse
-	if err := json.Unmarshal(body, &wallesterResp); err != nil {
-		return nil, fmt.Errorf("failed to parse wallester response: %w", err)
-	}
-
-	if !wallesterResp.Success || wallesterResp.CardID == "" {
-		return nil, fmt.Errorf("wallester card creation failed: %s", wallesterResp.Error)
+		return nil, err
 	}
 
 	// 5. Дефолтные лимиты по типу карты
@@ -221,8 +315,8 @@ func (wr *WallesterRepository) IssueCard(
 		INSERT INTO cards (
 			user_id, service_id, provider_card_id, external_id, bin, last_4_digits,
 			card_status, status, nickname, service_slug, daily_spend_limit,
-			card_type, card_balance, spending_limit, default_max_limit, created_at
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+			card_type, card_balance, spending_limit, default_max_limit, created_at, provider
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, 'wallester')
 		RETURNING id
 	`
 
@@ -260,36 +354,9 @@ func (wr *WallesterRepository) IssueCard(
 
 // GetCardDetails - Получение реквизитов карты (PAN, CVV, expiry) из Wallester
 func (wr *WallesterRepository) GetCardDetails(externalID string) (*WallesterCardDetailsResponse, error) {
-	if wr.apiKey == "" {
-		return nil, fmt.Errorf("WALLESTER_API_KEY not configured")
-	}
-
-	url := fmt.Sprintf("%s/cards/%s/details", wr.apiURL, externalID)
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", wr.apiKey))
-
-	resp, err := wr.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("wallester API request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("wallester API error (status %d): %s", resp.StatusCode, string(body))
-	}
-
 	var details WallesterCardDetailsResponse
-	if err := json.Unmarshal(body, &details); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
+	if err := wr.doRequest("GET", "/cards/"+externalID+"/details", nil, &details); err != nil {
+		return nil, err
 	}
 
 	if !details.Success {
@@ -299,66 +366,37 @@ func (wr *WallesterRepository) GetCardDetails(externalID string) (*WallesterCard
 	return &details, nil
 }
 
-// SyncBalance - Синхронизация баланса карты из Wallester в нашу БД
+// SyncBalance - Сверка баланса карты в Wallester с нашей БД.
+// cards.card_balance меняется только проводками журнала, поэтому здесь он не перезаписывается:
+// расхождение с эмитентом логируется и разбирается по журналу (ledger_postings).
 func (wr *WallesterRepository) SyncBalance(cardID int, externalID string) error {
 	if GlobalDB == nil {
 		return fmt.Errorf("database connection not initialized")
 	}
 
-	if wr.apiKey == "" {
-		return fmt.Errorf("WALLESTER_API_KEY not configured")
-	}
-
 	// Запрос баланса из Wallester
-	url := fmt.Sprintf("%s/cards/%s/balance", wr.apiURL, externalID)
-	req, err := http.NewRequest("GET", url, nil)
+	balance, _, err := wr.GetBalance(externalID)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return err
 	}
 
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", wr.apiKey))
-
-	resp, err := wr.httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("wallester API request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read response: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("wallester API error (status %d): %s", resp.StatusCode, string(body))
-	}
-
-	var balanceResp WallesterBalanceResponse
-	if err := json.Unmarshal(body, &balanceResp); err != nil {
-		return fmt.Errorf("failed to parse response: %w", err)
-	}
-
-	if !balanceResp.Success {
-		return fmt.Errorf("wallester error: %s", balanceResp.Error)
-	}
-
-	// Парсинг баланса
-	balance, err := decimal.NewFromString(balanceResp.Balance)
+	var stored decimal.Decimal
+	var currency string
+	err = GlobalDB.QueryRow(
+		`SELECT COALESCE(card_balance, 0), COALESCE(currency, 'USD') FROM cards WHERE id = $1`,
+		cardID,
+	).Scan(&stored, &currency)
 	if err != nil {
-		return fmt.Errorf("invalid balance format: %w", err)
+		return fmt.Errorf("failed to read card balance: %w", err)
 	}
 
-	// Обновление card_balance в БД
-	_, err = GlobalDB.Exec(
-		"UPDATE cards SET card_balance = $1 WHERE id = $2",
-		balance, cardID,
-	)
-
-	if err != nil {
-		return fmt.Errorf("failed to update card balance: %w", err)
+	if !stored.Equal(balance) {
+		log.Printf("⚠️  Balance mismatch for card %d (external_id=%s): ledger %s %s, Wallester %s %s (diff %s)",
+			cardID, externalID, stored.String(), currency, balance.String(), currency, balance.Sub(stored).String())
+		return nil
 	}
 
-	log.Printf("✅ Synced balance for card %d (external_id=%s): %s",
+	log.Printf("✅ Balance of card %d (external_id=%s) matches Wallester: %s",
 		cardID, externalID, balance.String())
 
 	return nil
@@ -418,16 +456,54 @@ func (wr *WallesterRepository) SyncAllCardsBalances() error {
 
 // WallesterWebhookPayload - структура для обработки webhook от Wallester
 type WallesterWebhookPayload struct {
-	EventType     string                 `json:"event_type"`     // transaction, balance_update, 3ds_authentication, etc.
-	CardID        string                 `json:"card_id"`        // external_id карты
-	TransactionID string                 `json:"transaction_id"` // ID транзакции от Wallester (для idempotency)
-	Amount        string                 `json:"amount"`
-	Currency      string                 `json:"currency"`
-	Status        string                 `json:"status"`
-	Timestamp     string                 `json:"timestamp"`
-	AuthCode      string                 `json:"auth_code,omitempty"`     // Код подтверждения для 3DS
-	MerchantName  string                 `json:"merchant_name,omitempty"` // Название магазина
-	Metadata      map[string]interface{} `json:"metadata,omitempty"`
+	EventType             string                 `json:"event_type"`                        // transaction, balance_update, 3ds_authentication, etc.
+	CardID                string                 `json:"card_id"`                           // external_id карты
+	TransactionID         string                 `json:"transaction_id"`                    // ID транзакции от Wallester (для idempotency)
+	AuthorizationID       string                 `json:"authorization_id,omitempty"`        // ID исходной авторизации (для capture/reversal холда)
+	OriginalTransactionID string                 `json:"original_transaction_id,omitempty"` // ID исходного списания (для refund/chargeback)
+	Amount                string                 `json:"amount"`
+	Currency              string                 `json:"currency"`
+	Status                string                 `json:"status"`
+	Timestamp             string                 `json:"timestamp"`
+	AuthCode              string                 `json:"auth_code,omitempty"`        // Код подтверждения для 3DS
+	MerchantName          string                 `json:"merchant_name,omitempty"`    // Название магазина
+	MCC                   string                 `json:"mcc,omitempty"`              // Merchant Category Code
+	Country               string                 `json:"merchant_country,omitempty"` // ISO-код страны мерчанта
+	Metadata              map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// AuthorizationRef — ID авторизации, под которой стоит холд: authorization_id, а если эмитент
+// его не передал — transaction_id (capture пришёл с тем же ID, что и авторизация).
+func (p WallesterWebhookPayload) AuthorizationRef() string {
+	if p.AuthorizationID != "" {
+		return p.AuthorizationID
+	}
+	return p.TransactionID
+}
+
+// OriginalRef — provider_tx_id списания, к которому относится refund/chargeback:
+// original_transaction_id, а если его нет — authorization_id.
+func (p WallesterWebhookPayload) OriginalRef() string {
+	if p.OriginalTransactionID != "" {
+		return p.OriginalTransactionID
+	}
+	return p.AuthorizationID
+}
+
+// notification — уведомление владельцу карты о событии вебхука по шаблону templateID.
+// amount — сумма операции для суточной сводки (0 — уведомление не откладывается).
+func (p WallesterWebhookPayload) notification(userID int, last4, templateID string, amount decimal.Decimal) *domain.UserNotification {
+	data := map[string]string{"last4": last4, "amount": p.Amount, "currency": p.Currency, "merchant": p.MerchantName}
+	if data["amount"] == "" {
+		data["amount"] = "0"
+	}
+	if data["currency"] == "" {
+		data["currency"] = "USD"
+	}
+	if data["merchant"] == "" {
+		data["merchant"] = "Unknown"
+	}
+	return &domain.UserNotification{UserID: userID, Template: templateID, Amount: amount.Abs(), Data: data}
 }
 
 // CheckIPWhitelist проверяет, что IP-адрес находится в whitelist Wallester
@@ -511,8 +587,9 @@ func CheckTransactionIdempotency(providerTxID string) (bool, error) {
 }
 
 // ProcessWebhook - Обработка webhook от Wallester
-// Обновляет balance_rub пользователя на основе транзакций
+// Обновляет Кошелёк и карты пользователя на основе транзакций
 // Включает проверку idempotency через provider_tx_id
+// Уведомление пользователю ставится в очередь в транзакции самого события (холд, списание, возврат)
 func (wr *WallesterRepository) ProcessWebhook(payload WallesterWebhookPayload) error {
 	if GlobalDB == nil {
 		return fmt.Errorf("database connection not initialized")
@@ -569,162 +646,132 @@ func (wr *WallesterRepository) ProcessWebhook(payload WallesterWebhookPayload) e
 			authCode, merchantName, userID)
 		return nil
 
-	case "transaction", "capture", "authorization", "payment_success":
-		// ═══════════════════════════════════════════════════════════════
-		// THE BRIDGE: Списание из Кошелька (internal_balances) вместо balance_rub
-		// 1. Проверяем internal_balance >= amount
-		// 2. Проверяем spending_limit карты >= (spent_from_wallet + amount)
-		// 3. Если ОК — списываем из Кошелька и одобряем транзакцию
-		// ═══════════════════════════════════════════════════════════════
-		if payload.Status == "approved" || payload.Status == "completed" || payload.EventType == "payment_success" {
-			// Начинаем транзакцию БД для атомарности
-			tx, err := GlobalDB.Begin()
+	case "authorization":
+		// Авторизация только резервирует средства Кошелька (холд); списание — по capture.
+		// Решение об авторизации принимает usecase.EvaluateAuthorization (в хендлере).
+		if payload.Status == "approved" || payload.Status == "pending" || payload.Status == "completed" {
+			err := PlaceCardHold(&domain.CardHold{
+				UserID:       userID,
+				CardID:       cardID,
+				Amount:       amount,
+				MerchantName: payload.MerchantName,
+				ProviderTxID: payload.TransactionID,
+			}, payload.notification(userID, last4Digits, domain.NotifyCardHoldPlaced, amount))
 			if err != nil {
-				return fmt.Errorf("failed to begin transaction: %w", err)
-			}
-			defer tx.Rollback()
-
-			// 1. Проверяем Кошелёк (internal_balance) — с блокировкой строки
-			var walletBalance decimal.Decimal
-			err = tx.QueryRow(
-				`SELECT COALESCE(master_balance, 0) FROM internal_balances WHERE user_id = $1 FOR UPDATE`,
-				userID,
-			).Scan(&walletBalance)
-			if err != nil {
-				return fmt.Errorf("wallet not found for user %d (create wallet first)", userID)
-			}
-
-			if walletBalance.LessThan(amount) {
-				return fmt.Errorf("insufficient wallet balance: required %s, available %s",
-					amount.String(), walletBalance.String())
-			}
-
-			// 2. Проверяем spending_limit карты
-			var spendingLimit, spentFromWallet decimal.Decimal
-			err = tx.QueryRow(
-				`SELECT COALESCE(spending_limit, 0), COALESCE(spent_from_wallet, 0) FROM cards WHERE id = $1 FOR UPDATE`,
-				cardID,
-			).Scan(&spendingLimit, &spentFromWallet)
-			if err != nil {
-				return fmt.Errorf("failed to get card limits: %w", err)
-			}
-
-			// spending_limit = 0 означает «без лимита» (unlimited)
-			if spendingLimit.GreaterThan(decimal.Zero) {
-				remaining := spendingLimit.Sub(spentFromWallet)
-				if amount.GreaterThan(remaining) {
-					return fmt.Errorf("card spending limit exceeded: limit remaining %s, tx amount %s",
-						remaining.String(), amount.String())
-				}
-			}
-
-			// 3. Списываем из Кошелька
-			_, err = tx.Exec(
-				`UPDATE internal_balances SET master_balance = master_balance - $1, updated_at = NOW() WHERE user_id = $2`,
-				amount, userID,
-			)
-			if err != nil {
-				return fmt.Errorf("failed to deduct wallet: %w", err)
-			}
-
-			// 4. Обновляем spent_from_wallet на карте
-			_, err = tx.Exec(
-				`UPDATE cards SET spent_from_wallet = COALESCE(spent_from_wallet, 0) + $1 WHERE id = $2`,
-				amount, cardID,
-			)
-			if err != nil {
-				return fmt.Errorf("failed to update card spent: %w", err)
-			}
-
-			// 5. Получаем новый баланс Кошелька для уведомления
-			var newWalletBalance decimal.Decimal
-			err = tx.QueryRow("SELECT master_balance FROM internal_balances WHERE user_id = $1", userID).Scan(&newWalletBalance)
-			if err != nil {
-				return fmt.Errorf("failed to get new wallet balance: %w", err)
+				return err
 			}
+		}
 
-			// 6. Записываем транзакцию с provider_tx_id для idempotency
+	case "transaction", "capture", "payment_success":
+		// THE BRIDGE: списание из Кошелька (internal_balances) вместо balance_rub.
+		// Если по авторизации стоит холд — списание подтверждает его (полностью или частично),
+		// иначе это прямое списание с той же повторной проверкой под блокировкой, что и в AuthorizeCard.
+		if payload.Status == "approved" || payload.Status == "completed" || payload.EventType == "payment_success" {
 			merchantName := payload.MerchantName
 			if merchantName == "" {
 				merchantName = "Unknown"
 			}
-			_, err = tx.Exec(
-				`INSERT INTO transactions (user_id, card_id, amount, fee, transaction_type, status, details, provider_tx_id, executed_at)
-				 VALUES ($1, $2, $3, $4, 'CAPTURE', 'APPROVED', $5, $6, $7)`,
-				userID,
-				cardID,
-				amount,
-				decimal.Zero,
-				fmt.Sprintf("Bridge: %s from wallet via card %s, merchant: %s", payload.EventType, payload.CardID, merchantName),
-				payload.TransactionID,
-				time.Now(),
-			)
+			capture := CardCapture{
+				UserID:       userID,
+				CardID:       cardID,
+				Amount:       amount,
+				Fee:          decimal.Zero,
+				MerchantName: merchantName,
+				ProviderTxID: payload.TransactionID,
+				Details:      fmt.Sprintf("Bridge: %s from wallet via card %s, merchant: %s", payload.EventType, payload.CardID, merchantName),
+				Description:  "Wallester " + payload.EventType + ": " + merchantName,
+				Notification: payload.notification(userID, last4Digits, domain.NotifyCardCharged, amount),
+			}
+			hold, err := GetActiveCardHoldByRef(payload.AuthorizationRef())
 			if err != nil {
-				return fmt.Errorf("failed to record transaction: %w", err)
+				return fmt.Errorf("failed to find card hold: %w", err)
 			}
-
-			// 7. Коммитим транзакцию
-			if err := tx.Commit(); err != nil {
-				return fmt.Errorf("failed to commit transaction: %w", err)
+			if hold != nil && hold.CardID == cardID {
+				capture.HoldID = hold.ID
+				capture.Details = fmt.Sprintf("Bridge: %s of hold #%d (%s held) via card %s, merchant: %s",
+					payload.EventType, hold.ID, hold.Amount.String(), payload.CardID, merchantName)
+			}
+			if _, err := CaptureCardPayment(capture); err != nil {
+				return err
 			}
-
-			log.Printf("✅ Bridge: Deducted %s from wallet (user %d, card %s, tx_id=%s). Wallet balance: %s",
-				amount.String(), userID, payload.CardID, payload.TransactionID, newWalletBalance.String())
-
-			// Уведомление пользователю отправляется в handler (sendWallesterNotification → service.NotifyUser)
 		}
 
 	case "refund", "reversal":
-		// Возврат средств в Кошелёк пользователя (вместо balance_rub)
-		tx, err := GlobalDB.Begin()
-		if err != nil {
-			return fmt.Errorf("failed to begin transaction: %w", err)
-		}
-		defer tx.Rollback()
-
-		// Возвращаем в Кошелёк
-		_, err = tx.Exec(
-			`UPDATE internal_balances SET master_balance = master_balance + $1, updated_at = NOW() WHERE user_id = $2`,
-			amount, userID,
-		)
-		if err != nil {
-			return fmt.Errorf("failed to refund to wallet: %w", err)
+		// Reversal авторизации, которая ещё не списана, только снимает холд (частично, если сумма меньше).
+		// Повтор reversal по уже снятому или истёкшему холду ничего не возвращает.
+		if payload.EventType == "reversal" {
+			hold, err := GetCardHoldByRef(payload.AuthorizationRef())
+			if err != nil {
+				return fmt.Errorf("failed to find card hold: %w", err)
+			}
+			if hold != nil && hold.CardID == cardID && hold.Status != domain.HoldCaptured {
+				if hold.Status != domain.HoldActive {
+					log.Printf("⚠️  Reversal %s: hold #%d already %s, skipping", payload.TransactionID, hold.ID, hold.Status)
+					return nil
+				}
+				released := payload.notification(userID, last4Digits, domain.NotifyCardHoldReleased, decimal.Zero)
+				if _, err := ReleaseCardHold(hold.ID, amount, domain.HoldReleased, released); err != nil && err != ErrHoldNotActive {
+					return err
+				}
+				return nil
+			}
 		}
 
-		// Уменьшаем spent_from_wallet на карте
-		_, err = tx.Exec(
-			`UPDATE cards SET spent_from_wallet = GREATEST(COALESCE(spent_from_wallet, 0) - $1, 0) WHERE id = $2`,
-			amount, cardID,
-		)
-		if err != nil {
-			return fmt.Errorf("failed to update card spent_from_wallet: %w", err)
+		// Возврат мерчанта: в Кошелёк или на карту — туда, откуда было исходное списание
+		_, err := CreditCardRefund(CardRefund{
+			UserID:               userID,
+			CardID:               cardID,
+			Kind:                 RefundKindMerchant,
+			Amount:               amount,
+			ProviderTxID:         payload.TransactionID,
+			OriginalProviderTxID: payload.OriginalRef(),
+			Details:              fmt.Sprintf("Bridge refund: %s back via card %s, merchant: %s", payload.EventType, payload.CardID, payload.MerchantName),
+			Notification:         payload.notification(userID, last4Digits, domain.NotifyCardRefunded, amount),
+		})
+		if errors.Is(err, ErrNothingToRefund) || errors.Is(err, ErrRefundAlreadyRecorded) {
+			log.Printf("⚠️  Refund %s ignored: %v", payload.TransactionID, err)
+			return nil
 		}
-
-		// Записываем транзакцию возврата
-		_, err = tx.Exec(
-			`INSERT INTO transactions (user_id, card_id, amount, fee, transaction_type, status, details, provider_tx_id, executed_at)
-			 VALUES ($1, $2, $3, $4, 'REFUND', 'APPROVED', $5, $6, $7)`,
-			userID,
-			cardID,
-			amount,
-			decimal.Zero,
-			fmt.Sprintf("Bridge refund: %s back to wallet via card %s", payload.EventType, payload.CardID),
-			payload.TransactionID,
-			time.Now(),
-		)
 		if err != nil {
-			return fmt.Errorf("failed to record refund transaction: %w", err)
+			return err
 		}
 
-		if err := tx.Commit(); err != nil {
-			return fmt.Errorf("failed to commit refund transaction: %w", err)
+	case "chargeback":
+		// Chargeback идёт через спор: открыт/на рассмотрении → спор IN_REVIEW, проигран → LOST,
+		// выигран (won/approved/completed) → средства возвращаются так же, как при refund
+		switch payload.Status {
+		case "opened", "pending", "in_review":
+			opened := payload.notification(userID, last4Digits, domain.NotifyCardChargebackOpened, decimal.Zero)
+			if _, err := SetDisputeStatusByCharge(userID, payload.OriginalRef(), domain.DisputeInReview, opened); err != nil {
+				return err
+			}
+		case "lost", "rejected", "declined":
+			lost := payload.notification(userID, last4Digits, domain.NotifyCardChargebackLost, decimal.Zero)
+			if _, err := SetDisputeStatusByCharge(userID, payload.OriginalRef(), domain.DisputeLost, lost); err != nil {
+				return err
+			}
+		default:
+			_, err := CreditCardRefund(CardRefund{
+				UserID:               userID,
+				CardID:               cardID,
+				Kind:                 RefundKindChargeback,
+				Amount:               amount,
+				ProviderTxID:         payload.TransactionID,
+				OriginalProviderTxID: payload.OriginalRef(),
+				Details:              fmt.Sprintf("Chargeback via card %s, merchant: %s", payload.CardID, payload.MerchantName),
+				Notification:         payload.notification(userID, last4Digits, domain.NotifyCardChargebackWon, decimal.Zero),
+			})
+			if errors.Is(err, ErrNothingToRefund) || errors.Is(err, ErrRefundAlreadyRecorded) {
+				log.Printf("⚠️  Chargeback %s ignored: %v", payload.TransactionID, err)
+				return nil
+			}
+			if err != nil {
+				return err
+			}
 		}
 
-		log.Printf("✅ Bridge: Refunded %s to wallet (user %d, card %s, tx_id=%s)",
-			amount.String(), userID, payload.CardID, payload.TransactionID)
-
 	case "balance_update":
-		// Прямое обновление баланса карты (синхронизация)
+		// Сверка баланса карты с эмитентом (без перезаписи card_balance)
 		return wr.SyncBalance(cardID, payload.CardID)
 
 	default:
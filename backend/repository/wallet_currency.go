@@ -0,0 +1,417 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/ledger"
+	"github.com/shopspring/decimal"
+)
+
+// WalletCurrencies — валюты, в которых у пользователя есть Кошелёк.
+// WalletCurrency (USD) хранится в internal_balances.master_balance, остальные — в wallet_balances.
+var WalletCurrencies = []string{WalletCurrency, "EUR", "RUB", "USDT"}
+
+// rateBaseCurrency — валюта, к которой заданы все курсы exchange_rates (RUB за 1 единицу валюты).
+const rateBaseCurrency = "RUB"
+
+var (
+	// ErrUnsupportedCurrency — валюта не входит в WalletCurrencies.
+	ErrUnsupportedCurrency = errors.New("unsupported wallet currency")
+	// ErrInsufficientWalletFunds — в Кошельке нужной валюты не хватает средств.
+	ErrInsufficientWalletFunds = errors.New("insufficient wallet funds")
+)
+
+// NormalizeWalletCurrency приводит код валюты к виду WalletCurrencies ("€" → EUR, "" → USD).
+func NormalizeWalletCurrency(currency string) (string, error) {
+	c := strings.ToUpper(strings.TrimSpace(currency))
+	switch c {
+	case "", "$":
+		return WalletCurrency, nil
+	case "€":
+		return "EUR", nil
+	case "₽", "RUR":
+		return "RUB", nil
+	}
+	for _, wc := range WalletCurrencies {
+		if c == wc {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnsupportedCurrency, currency)
+}
+
+// EnsureWalletBalanceTables creates wallet_balances, the transactions.fx_rate and wallet_currency
+// columns (currency of the wallet that amount moved; NULL means USD) and the RUB/USDT rate,
+// then moves the retired users.balance* columns into the wallets.
+func EnsureWalletBalanceTables() error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	_, err := GlobalDB.Exec(`
+		CREATE TABLE IF NOT EXISTS wallet_balances (
+			user_id    INTEGER NOT NULL REFERENCES users(id),
+			currency   VARCHAR(10) NOT NULL,
+			balance    NUMERIC(20,4) NOT NULL DEFAULT 0,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (user_id, currency)
+		);
+		ALTER TABLE IF EXISTS wallet_balances DISABLE ROW LEVEL SECURITY;
+
+		ALTER TABLE transactions ADD COLUMN IF NOT EXISTS fx_rate NUMERIC(20,8);
+		ALTER TABLE transactions ADD COLUMN IF NOT EXISTS wallet_currency VARCHAR(10);
+
+		INSERT INTO exchange_rates (currency_from, currency_to, base_rate, markup_percent, final_rate)
+		SELECT 'RUB', 'USDT', 96.5, 3.00, 96.5 * 1.03
+		WHERE NOT EXISTS (SELECT 1 FROM exchange_rates WHERE currency_from = 'RUB' AND currency_to = 'USDT');
+	`)
+	if err != nil {
+		log.Printf("[WALLETS] Error creating wallet tables: %v", err)
+		return err
+	}
+	if err := migrateLegacyBalances(); err != nil {
+		log.Printf("[WALLETS] Legacy balance migration failed: %v", err)
+		return err
+	}
+	log.Println("[WALLETS] ✅ wallet_balances ensured")
+	return nil
+}
+
+// migrateLegacyBalances переносит остатки из retired-колонок users в USD Кошелёк.
+// users.balance_rub, несмотря на имя, пополнялся в долларах (ProcessDeposit, реферальные
+// начисления, корректировки админа); balance, balance_arbitrage и balance_personal — его копии
+// и разбивка, поэтому зачисляется только balance_rub. После переноса все четыре колонки обнуляются.
+func migrateLegacyBalances() error {
+	if ok, err := columnExists("users", "balance_rub"); err != nil || !ok {
+		return err
+	}
+	rows, err := GlobalDB.Query(`SELECT id, balance_rub FROM users WHERE COALESCE(balance_rub, 0) > 0`)
+	if err != nil {
+		return fmt.Errorf("failed to load legacy balances: %w", err)
+	}
+	type legacyBalance struct {
+		userID int
+		amount decimal.Decimal
+	}
+	var pending []legacyBalance
+	for rows.Next() {
+		var b legacyBalance
+		if err := rows.Scan(&b.userID, &b.amount); err != nil {
+			rows.Close()
+			return err
+		}
+		pending = append(pending, b)
+	}
+	rows.Close()
+
+	for _, b := range pending {
+		if err := migrateLegacyBalance(b.userID, b.amount); err != nil {
+			log.Printf("[WALLETS] ❌ User %d: legacy balance %s not migrated: %v", b.userID, b.amount.String(), err)
+			continue
+		}
+		log.Printf("[WALLETS] User %d: legacy balance $%s moved to wallet", b.userID, b.amount.StringFixed(2))
+	}
+
+	// Остатки, которые не зачисляются (копии, отрицательные), просто обнуляем
+	_, err = GlobalDB.Exec(`UPDATE users SET balance = 0, balance_rub = 0, balance_arbitrage = 0, balance_personal = 0
+		WHERE COALESCE(balance_rub, 0) <= 0
+		  AND (COALESCE(balance, 0) <> 0 OR COALESCE(balance_rub, 0) <> 0
+		       OR COALESCE(balance_arbitrage, 0) <> 0 OR COALESCE(balance_personal, 0) <> 0)`)
+	return err
+}
+
+// migrateLegacyBalance — LEGACY_MIGRATION одного пользователя: зачисление и обнуление колонок в одной транзакции.
+func migrateLegacyBalance(userID int, amount decimal.Decimal) error {
+	tx, err := GlobalDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Повторная проверка под блокировкой: миграция могла пройти в параллельном инстансе
+	var locked decimal.Decimal
+	if err := tx.QueryRow(`SELECT COALESCE(balance_rub, 0) FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&locked); err != nil {
+		return err
+	}
+	if !locked.Equal(amount) {
+		return nil
+	}
+
+	var txID int
+	err = tx.QueryRow(
+		`INSERT INTO transactions (user_id, amount, fee, transaction_type, status, details, currency, wallet_currency, executed_at)
+		 VALUES ($1, $2, 0, 'LEGACY_MIGRATION', 'APPROVED', $3, $4, $4, NOW()) RETURNING id`,
+		userID, amount, "Legacy account balance moved to wallet", WalletCurrency,
+	).Scan(&txID)
+	if err != nil {
+		return fmt.Errorf("record transaction: %w", err)
+	}
+
+	// Старые балансы жили вне журнала — источник проводки suspense (системные корректировки)
+	_, err = ledger.Post(tx, ledger.Entry{
+		Type:          "LEGACY_MIGRATION",
+		TransactionID: txID,
+		Description:   "Legacy users.balance_rub migration",
+		Lines:         ledger.Move(ledger.Suspense, ledger.UserWallet(userID), amount, WalletCurrency),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE users SET balance = 0, balance_rub = 0, balance_arbitrage = 0, balance_personal = 0 WHERE id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("clear legacy columns: %w", err)
+	}
+	return tx.Commit()
+}
+
+// LockWalletBalance блокирует Кошелёк валюты currency до конца транзакции и возвращает доступный остаток.
+// Для USD это master_balance за вычетом холдов (LockAvailableWalletBalance); отсутствие Кошелька — sql.ErrNoRows.
+// Кошельки остальных валют создаются с нулём, если их ещё нет.
+func LockWalletBalance(tx *sql.Tx, userID int, currency string) (decimal.Decimal, error) {
+	if currency == WalletCurrency {
+		return LockAvailableWalletBalance(tx, userID, 0)
+	}
+	_, err := tx.Exec(
+		`INSERT INTO wallet_balances (user_id, currency, balance, updated_at) VALUES ($1, $2, 0, NOW())
+		 ON CONFLICT (user_id, currency) DO NOTHING`, userID, currency,
+	)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	var balance decimal.Decimal
+	err = tx.QueryRow(
+		`SELECT balance FROM wallet_balances WHERE user_id = $1 AND currency = $2 FOR UPDATE`, userID, currency,
+	).Scan(&balance)
+	return balance, err
+}
+
+// GetWalletBalances — все Кошельки пользователя в порядке WalletCurrencies (отсутствующие — с нулём).
+func GetWalletBalances(userID int) ([]domain.WalletBalance, error) {
+	ib, err := GetInternalBalance(userID)
+	if err != nil {
+		return nil, err
+	}
+	usdUpdated := ib.UpdatedAt
+	byCurrency := map[string]domain.WalletBalance{
+		WalletCurrency: {
+			Currency:         WalletCurrency,
+			Balance:          ib.MasterBalance,
+			HeldBalance:      ib.HeldBalance,
+			AvailableBalance: ib.AvailableBalance,
+			UpdatedAt:        &usdUpdated,
+		},
+	}
+
+	rows, err := GlobalDB.Query(`SELECT currency, balance, updated_at FROM wallet_balances WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get wallet balances: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var w domain.WalletBalance
+		var updated time.Time
+		if err := rows.Scan(&w.Currency, &w.Balance, &updated); err != nil {
+			return nil, err
+		}
+		w.AvailableBalance = w.Balance
+		w.UpdatedAt = &updated
+		byCurrency[w.Currency] = w
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	wallets := make([]domain.WalletBalance, 0, len(WalletCurrencies))
+	for _, c := range WalletCurrencies {
+		w, ok := byCurrency[c]
+		if !ok {
+			w = domain.WalletBalance{Currency: c}
+		}
+		wallets = append(wallets, w)
+	}
+	return wallets, nil
+}
+
+// fxLeg — курс валюты к рублю: Base — рублей за 1 единицу (exchange_rates.base_rate, для RUB — 1),
+// Markup — наценка платформы в процентах, заложенная в final_rate (учитывает ручную правку final_rate).
+type fxLeg struct {
+	Currency string
+	Base     decimal.Decimal
+	Markup   decimal.Decimal
+}
+
+// loadFXLeg reads the RUB leg of currency from exchange_rates.
+func loadFXLeg(currency string) (fxLeg, error) {
+	if currency == rateBaseCurrency {
+		return fxLeg{Currency: currency, Base: decimal.NewFromInt(1)}, nil
+	}
+	var base, final decimal.Decimal
+	err := GlobalDB.QueryRow(
+		`SELECT base_rate, final_rate FROM exchange_rates WHERE currency_from = $1 AND currency_to = $2`,
+		rateBaseCurrency, currency,
+	).Scan(&base, &final)
+	if err != nil || !base.IsPositive() {
+		return fxLeg{}, fmt.Errorf("exchange rate %s/%s is not set", rateBaseCurrency, currency)
+	}
+	markup := final.Div(base).Sub(decimal.NewFromInt(1)).Mul(decimal.NewFromInt(100))
+	if markup.IsNegative() {
+		markup = decimal.Zero
+	}
+	return fxLeg{Currency: currency, Base: base, Markup: markup}, nil
+}
+
+// tradeCrossRate — клиентский курс from → to (сколько единиц to за 1 from) и применённая наценка.
+// Обе ноги берутся по base_rate, наценка применяется один раз против клиента: дорожает покупаемая
+// валюта to. Когда покупаются рубли, наценка берётся с продаваемой валюты — у рубля своей наценки нет.
+func tradeCrossRate(from, to fxLeg) (rate, markup decimal.Decimal) {
+	markup = to.Markup
+	if to.Currency == rateBaseCurrency {
+		markup = from.Markup
+	}
+	factor := decimal.NewFromInt(1).Add(markup.Div(decimal.NewFromInt(100)))
+	return from.Base.Div(to.Base).DivRound(factor, 8), markup
+}
+
+// CrossRate — сколько единиц to получает клиент за 1 единицу from: кросс-курс через рубль
+// по base_rate с наценкой платформы (tradeCrossRate).
+func CrossRate(from, to string) (decimal.Decimal, error) {
+	if from == to {
+		return decimal.NewFromInt(1), nil
+	}
+	fromLeg, err := loadFXLeg(from)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	toLeg, err := loadFXLeg(to)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	rate, _ := tradeCrossRate(fromLeg, toLeg)
+	return rate, nil
+}
+
+// ConvertWalletCurrency — явная конвертация amount из Кошелька from в Кошелёк to по CrossRate
+// или по котировке quoteID (CreateFXQuote), если она передана.
+// Пишет две транзакции (FX_SELL в валюте from и FX_BUY в валюте to, связанную через original_tx_id)
+// и одну проводку через позицию конвертации ledger.FX. Уведомление пользователю ставится в очередь
+// в той же транзакции.
+func ConvertWalletCurrency(userID int, from, to string, amount decimal.Decimal, quoteID string) (*domain.FXConversion, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	if !amount.IsPositive() {
+		return nil, fmt.Errorf("amount must be positive")
+	}
+	if from == to {
+		return nil, fmt.Errorf("source and target currency are the same")
+	}
+
+	tx, err := GlobalDB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var rate decimal.Decimal
+	var quoteRef interface{}
+	var snapshot *int64
+	if quoteID = strings.TrimSpace(quoteID); quoteID != "" {
+		q, err := lockFXQuote(tx, quoteID, userID, from, to)
+		if err != nil {
+			return nil, err
+		}
+		rate, quoteRef, snapshot = q.Rate, q.ID, q.RateSnapshotID
+	} else {
+		snapshot = currentRateSnapshot(tx)
+		if rate, err = CrossRate(from, to); err != nil {
+			return nil, err
+		}
+	}
+	conv := &domain.FXConversion{
+		FromCurrency:   from,
+		ToCurrency:     to,
+		FromAmount:     amount.Round(2),
+		ToAmount:       amount.Mul(rate).Round(2),
+		Rate:           rate,
+		RateSnapshotID: snapshot,
+		CreatedAt:      time.Now(),
+	}
+	if quoteRef != nil {
+		conv.QuoteID = &quoteID
+	}
+	if !conv.ToAmount.IsPositive() {
+		return nil, fmt.Errorf("converted amount too small")
+	}
+
+	available, err := LockWalletBalance(tx, userID, from)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to lock %s wallet: %w", from, err)
+	}
+	if available.LessThan(conv.FromAmount) {
+		return nil, fmt.Errorf("%w: %s wallet has %s, required %s", ErrInsufficientWalletFunds,
+			from, available.StringFixed(2), conv.FromAmount.StringFixed(2))
+	}
+
+	details := fmt.Sprintf("FX: %s %s → %s %s (rate %s)",
+		conv.FromAmount.StringFixed(2), from, conv.ToAmount.StringFixed(2), to, rate.StringFixed(6))
+	err = tx.QueryRow(
+		`INSERT INTO transactions (user_id, amount, fee, transaction_type, status, details, currency, wallet_currency, fx_rate, fx_quote_id, rate_snapshot_id, executed_at)
+		 VALUES ($1, $2, 0, 'FX_SELL', 'APPROVED', $3, $4, $4, $5, $6, $7, $8) RETURNING id`,
+		userID, conv.FromAmount, details, from, rate, quoteRef, snapshotArg(snapshot), conv.CreatedAt,
+	).Scan(&conv.SellTxID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record FX_SELL: %w", err)
+	}
+	err = tx.QueryRow(
+		`INSERT INTO transactions (user_id, amount, fee, transaction_type, status, details, currency, wallet_currency, fx_rate, fx_quote_id, rate_snapshot_id, original_tx_id, executed_at)
+		 VALUES ($1, $2, 0, 'FX_BUY', 'APPROVED', $3, $4, $4, $5, $6, $7, $8, $9) RETURNING id`,
+		userID, conv.ToAmount, details, to, rate, quoteRef, snapshotArg(snapshot), conv.SellTxID, conv.CreatedAt,
+	).Scan(&conv.BuyTxID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record FX_BUY: %w", err)
+	}
+	if quoteRef != nil {
+		if err := markFXQuoteUsed(tx, quoteID, conv.SellTxID); err != nil {
+			return nil, fmt.Errorf("failed to mark fx quote used: %w", err)
+		}
+	}
+
+	_, err = ledger.Post(tx, ledger.Entry{
+		Type:          "FX_EXCHANGE",
+		TransactionID: conv.SellTxID,
+		Description:   details,
+		Lines: append(
+			ledger.Move(ledger.UserWallet(userID), ledger.FX, conv.FromAmount, from),
+			ledger.Move(ledger.FX, ledger.UserWallet(userID), conv.ToAmount, to)...,
+		),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to post conversion: %w", err)
+	}
+	err = EnqueueNotification(tx, domain.UserNotification{
+		UserID:   userID,
+		Template: domain.NotifyWalletConverted,
+		Data: map[string]string{
+			"from_amount":   conv.FromAmount.StringFixed(2),
+			"from_currency": from,
+			"to_amount":     conv.ToAmount.StringFixed(2),
+			"to_currency":   to,
+			"rate":          rate.StringFixed(4),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit conversion: %w", err)
+	}
+	log.Printf("[WALLETS] ✅ User %d: %s", userID, details)
+	return conv, nil
+}
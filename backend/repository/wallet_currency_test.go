@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestTradeCrossRateChargesMarkupOnce(t *testing.T) {
+	d := decimal.RequireFromString
+	rub := fxLeg{Currency: "RUB", Base: decimal.NewFromInt(1)}
+	usd := fxLeg{Currency: "USD", Base: d("100"), Markup: d("3")}
+	eur := fxLeg{Currency: "EUR", Base: d("110"), Markup: d("5")}
+
+	cases := []struct {
+		name       string
+		from, to   fxLeg
+		wantRate   string
+		wantMarkup string
+	}{
+		// 1 USD по base — 100 ₽, минус 3% наценки
+		{"USD→RUB", usd, rub, "97.08737864", "3"},
+		// за 1 ₽ — 1/103 USD
+		{"RUB→USD", rub, usd, "0.00970874", "3"},
+		// 100/110 EUR за 1 USD, наценка покупаемого EUR
+		{"USD→EUR", usd, eur, "0.86580087", "5"},
+		{"EUR→USD", eur, usd, "1.06796117", "3"},
+	}
+	for _, c := range cases {
+		rate, markup := tradeCrossRate(c.from, c.to)
+		if !rate.Equal(d(c.wantRate)) || !markup.Equal(d(c.wantMarkup)) {
+			t.Errorf("%s: курс %s (наценка %s%%), ожидался %s (%s%%)", c.name, rate, markup, c.wantRate, c.wantMarkup)
+		}
+	}
+
+	// Любой круг конвертаций оставляет клиенту меньше исходной суммы
+	for _, pair := range [][2]fxLeg{{usd, rub}, {rub, eur}, {usd, eur}} {
+		there, _ := tradeCrossRate(pair[0], pair[1])
+		back, _ := tradeCrossRate(pair[1], pair[0])
+		if roundTrip := there.Mul(back); !roundTrip.LessThan(decimal.NewFromInt(1)) {
+			t.Errorf("%s→%s→%s: клиент получает %s от суммы, спред платформы потерян",
+				pair[0].Currency, pair[1].Currency, pair[0].Currency, roundTrip)
+		}
+	}
+}
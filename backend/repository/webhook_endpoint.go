@@ -0,0 +1,584 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/domain"
+)
+
+// WebhookMaxEndpoints — сколько endpoint может зарегистрировать один пользователь.
+const WebhookMaxEndpoints = 10
+
+// webhookLockTTL — на сколько доставка резервируется за dispatcher (таймаут запроса с запасом).
+const webhookLockTTL = time.Minute
+
+var (
+	ErrWebhookNotFound          = errors.New("webhook endpoint not found")
+	ErrInvalidWebhook           = errors.New("invalid webhook endpoint")
+	ErrWebhookLimitReached      = fmt.Errorf("webhook endpoint limit reached (%d)", WebhookMaxEndpoints)
+	ErrWebhookDeliveryNotFound  = errors.New("webhook delivery not found")
+	ErrWebhookDeliveryScheduled = errors.New("webhook delivery is already scheduled")
+)
+
+// EnsureWebhookTables creates webhook_endpoints (customer integration URLs and signing secrets),
+// webhook_deliveries (the outgoing event queue) and webhook_delivery_attempts (one row per HTTP attempt).
+func EnsureWebhookTables() error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	_, err := GlobalDB.Exec(`
+		CREATE TABLE IF NOT EXISTS webhook_endpoints (
+			id          SERIAL PRIMARY KEY,
+			user_id     INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			url         TEXT NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			events      JSONB NOT NULL DEFAULT '[]',
+			secret      TEXT NOT NULL,
+			is_active   BOOLEAN NOT NULL DEFAULT TRUE,
+			created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_webhook_endpoints_user ON webhook_endpoints(user_id);
+		ALTER TABLE IF EXISTS webhook_endpoints DISABLE ROW LEVEL SECURITY;
+
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id               SERIAL PRIMARY KEY,
+			endpoint_id      INTEGER NOT NULL REFERENCES webhook_endpoints(id) ON DELETE CASCADE,
+			user_id          INTEGER NOT NULL,
+			event_id         VARCHAR(40) NOT NULL,
+			event            VARCHAR(50) NOT NULL,
+			payload          TEXT NOT NULL,
+			status           VARCHAR(20) NOT NULL DEFAULT 'PENDING',
+			attempts         INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			locked_until     TIMESTAMPTZ,
+			last_status_code INTEGER NOT NULL DEFAULT 0,
+			last_error       TEXT NOT NULL DEFAULT '',
+			created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			delivered_at     TIMESTAMPTZ
+		);
+		CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE status = 'PENDING';
+		CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_endpoint ON webhook_deliveries(endpoint_id, id DESC);
+		ALTER TABLE IF EXISTS webhook_deliveries DISABLE ROW LEVEL SECURITY;
+
+		CREATE TABLE IF NOT EXISTS webhook_delivery_attempts (
+			id          SERIAL PRIMARY KEY,
+			delivery_id INTEGER NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+			attempt     INTEGER NOT NULL,
+			status_code INTEGER NOT NULL DEFAULT 0,
+			error       TEXT NOT NULL DEFAULT '',
+			duration_ms BIGINT NOT NULL DEFAULT 0,
+			created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_webhook_delivery_attempts_delivery ON webhook_delivery_attempts(delivery_id);
+		ALTER TABLE IF EXISTS webhook_delivery_attempts DISABLE ROW LEVEL SECURITY;
+	`)
+	if err != nil {
+		log.Printf("[WEBHOOKS] Error ensuring tables: %v", err)
+		return err
+	}
+	log.Println("[WEBHOOKS] ✅ webhook_endpoints, webhook_deliveries and webhook_delivery_attempts tables ensured")
+	return nil
+}
+
+// IsPublicWebhookIP — можно ли отправлять вебхук на адрес ip: не loopback, не частная сеть,
+// не link-local (метаданные облака) и не multicast.
+func IsPublicWebhookIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+// validWebhookEndpoint проверяет адрес и список событий endpoint и приводит их к каноничному виду.
+func validWebhookEndpoint(e *domain.WebhookEndpoint) error {
+	e.URL = strings.TrimSpace(e.URL)
+	u, err := url.Parse(e.URL)
+	if err != nil || len(e.URL) > 2000 {
+		return fmt.Errorf("%w: url is not valid", ErrInvalidWebhook)
+	}
+	if u.Scheme != "https" || u.Hostname() == "" || u.User != nil {
+		return fmt.Errorf("%w: url must be https://host/...", ErrInvalidWebhook)
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") || strings.HasSuffix(host, ".internal") {
+		return fmt.Errorf("%w: url must point to a public host", ErrInvalidWebhook)
+	}
+	if ip := net.ParseIP(host); ip != nil && !IsPublicWebhookIP(ip) {
+		return fmt.Errorf("%w: url must point to a public host", ErrInvalidWebhook)
+	}
+
+	e.Description = strings.TrimSpace(e.Description)
+	if len([]rune(e.Description)) > 200 {
+		return fmt.Errorf("%w: description is longer than 200 characters", ErrInvalidWebhook)
+	}
+
+	seen := map[string]bool{}
+	events := make([]string, 0, len(e.Events))
+	for _, ev := range e.Events {
+		ev = strings.ToLower(strings.TrimSpace(ev))
+		known := false
+		for _, w := range domain.WebhookEvents {
+			known = known || w == ev
+		}
+		if !known {
+			return fmt.Errorf("%w: unknown event %q", ErrInvalidWebhook, ev)
+		}
+		if !seen[ev] {
+			seen[ev] = true
+			events = append(events, ev)
+		}
+	}
+	if len(events) == 0 {
+		return fmt.Errorf("%w: subscribe to at least one event", ErrInvalidWebhook)
+	}
+	e.Events = events
+	return nil
+}
+
+// newWebhookSecret — секрет подписи endpoint: whsec_ и 64 hex-символа.
+func newWebhookSecret() (string, error) {
+	s, err := generateRandomString(32)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return "whsec_" + s, nil
+}
+
+const webhookEndpointColumns = `id, user_id, url, description, events, is_active, created_at, updated_at`
+
+func scanWebhookEndpoint(row interface {
+	Scan(dest ...interface{}) error
+}) (*domain.WebhookEndpoint, error) {
+	var e domain.WebhookEndpoint
+	var events []byte
+	if err := row.Scan(&e.ID, &e.UserID, &e.URL, &e.Description, &events, &e.IsActive, &e.CreatedAt, &e.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(events, &e.Events); err != nil {
+		return nil, fmt.Errorf("webhook endpoint %d events: %w", e.ID, err)
+	}
+	return &e, nil
+}
+
+// CreateWebhookEndpoint регистрирует endpoint пользователя и генерирует секрет подписи
+// (возвращается в e.Secret только здесь и при RotateWebhookSecret).
+func CreateWebhookEndpoint(e *domain.WebhookEndpoint) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	if err := validWebhookEndpoint(e); err != nil {
+		return err
+	}
+	var count int
+	if err := GlobalDB.QueryRow(`SELECT COUNT(*) FROM webhook_endpoints WHERE user_id = $1`, e.UserID).Scan(&count); err != nil {
+		return err
+	}
+	if count >= WebhookMaxEndpoints {
+		return ErrWebhookLimitReached
+	}
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return err
+	}
+	events, _ := json.Marshal(e.Events) // JSONB передаётся строкой
+	if err := GlobalDB.QueryRow(`
+		INSERT INTO webhook_endpoints (user_id, url, description, events, secret)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, is_active, created_at, updated_at`,
+		e.UserID, e.URL, e.Description, string(events), secret,
+	).Scan(&e.ID, &e.IsActive, &e.CreatedAt, &e.UpdatedAt); err != nil {
+		return fmt.Errorf("failed to create webhook endpoint: %w", err)
+	}
+	e.Secret = secret
+	log.Printf("[WEBHOOKS] User %d registered endpoint #%d (%s) for %v", e.UserID, e.ID, e.URL, e.Events)
+	return nil
+}
+
+// ListWebhookEndpoints — endpoint пользователя (без секретов).
+func ListWebhookEndpoints(userID int) ([]domain.WebhookEndpoint, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	rows, err := GlobalDB.Query(`SELECT `+webhookEndpointColumns+` FROM webhook_endpoints WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook endpoints: %w", err)
+	}
+	defer rows.Close()
+	list := []domain.WebhookEndpoint{}
+	for rows.Next() {
+		e, err := scanWebhookEndpoint(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *e)
+	}
+	return list, rows.Err()
+}
+
+// GetWebhookEndpoint — endpoint пользователя (без секрета); чужой или удалённый — ErrWebhookNotFound.
+func GetWebhookEndpoint(userID, id int) (*domain.WebhookEndpoint, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	e, err := scanWebhookEndpoint(GlobalDB.QueryRow(
+		`SELECT `+webhookEndpointColumns+` FROM webhook_endpoints WHERE id = $1 AND user_id = $2`, id, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrWebhookNotFound
+	}
+	return e, err
+}
+
+// UpdateWebhookEndpoint сохраняет адрес, описание, события и активность endpoint пользователя.
+func UpdateWebhookEndpoint(e *domain.WebhookEndpoint) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	if err := validWebhookEndpoint(e); err != nil {
+		return err
+	}
+	events, _ := json.Marshal(e.Events)
+	err := GlobalDB.QueryRow(`
+		UPDATE webhook_endpoints SET url = $3, description = $4, events = $5, is_active = $6, updated_at = NOW()
+		WHERE id = $1 AND user_id = $2
+		RETURNING updated_at`,
+		e.ID, e.UserID, e.URL, e.Description, string(events), e.IsActive,
+	).Scan(&e.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrWebhookNotFound
+	}
+	return err
+}
+
+// DeleteWebhookEndpoint удаляет endpoint пользователя вместе с его очередью и журналом.
+func DeleteWebhookEndpoint(userID, id int) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	res, err := GlobalDB.Exec(`DELETE FROM webhook_endpoints WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrWebhookNotFound
+	}
+	log.Printf("[WEBHOOKS] User %d deleted endpoint #%d", userID, id)
+	return nil
+}
+
+// RotateWebhookSecret выдаёт endpoint новый секрет; следующие попытки подписываются уже им.
+func RotateWebhookSecret(userID, id int) (string, error) {
+	if GlobalDB == nil {
+		return "", fmt.Errorf("database connection not initialized")
+	}
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return "", err
+	}
+	res, err := GlobalDB.Exec(
+		`UPDATE webhook_endpoints SET secret = $3, updated_at = NOW() WHERE id = $1 AND user_id = $2`, id, userID, secret)
+	if err != nil {
+		return "", err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", ErrWebhookNotFound
+	}
+	log.Printf("[WEBHOOKS] User %d rotated secret of endpoint #%d", userID, id)
+	return secret, nil
+}
+
+// webhookPayload — тело запроса вебхука: одно и то же для всех endpoint и всех попыток.
+func webhookPayload(eventID, event string, createdAt time.Time, data map[string]interface{}) (string, error) {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	body, err := json.Marshal(struct {
+		ID        string                 `json:"id"`
+		Event     string                 `json:"event"`
+		CreatedAt string                 `json:"created_at"`
+		Data      map[string]interface{} `json:"data"`
+	}{eventID, event, createdAt.UTC().Format(time.RFC3339), data})
+	if err != nil {
+		return "", fmt.Errorf("invalid webhook data: %w", err)
+	}
+	return string(body), nil
+}
+
+func newWebhookEventID() (string, error) {
+	s, err := generateRandomString(12)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate webhook event id: %w", err)
+	}
+	return "evt_" + s, nil
+}
+
+// EnqueueWebhookEvent ставит событие в очередь доставки каждому активному endpoint пользователя,
+// подписанному на event. q — транзакция бизнес-события: вебхук фиксируется вместе с ним.
+// Отправляет dispatcher (usecase.ProcessWebhookDeliveries).
+func EnqueueWebhookEvent(q interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}, userID int, event string, data map[string]interface{}) error {
+	rows, err := q.Query(`SELECT id, events FROM webhook_endpoints WHERE user_id = $1 AND is_active`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to load webhook endpoints: %w", err)
+	}
+	var endpointIDs []int
+	for rows.Next() {
+		var id int
+		var raw []byte
+		var events []string
+		if err := rows.Scan(&id, &raw); err != nil {
+			rows.Close()
+			return err
+		}
+		_ = json.Unmarshal(raw, &events)
+		for _, ev := range events {
+			if ev == event {
+				endpointIDs = append(endpointIDs, id)
+				break
+			}
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(endpointIDs) == 0 {
+		return nil
+	}
+
+	eventID, err := newWebhookEventID()
+	if err != nil {
+		return err
+	}
+	payload, err := webhookPayload(eventID, event, time.Now(), data)
+	if err != nil {
+		return err
+	}
+	for _, endpointID := range endpointIDs {
+		if _, err := q.Exec(
+			`INSERT INTO webhook_deliveries (endpoint_id, user_id, event_id, event, payload) VALUES ($1, $2, $3, $4, $5)`,
+			endpointID, userID, eventID, event, payload,
+		); err != nil {
+			return fmt.Errorf("failed to enqueue webhook: %w", err)
+		}
+	}
+	return nil
+}
+
+// EmitWebhookEvent — EnqueueWebhookEvent вне транзакции бизнес-события.
+func EmitWebhookEvent(userID int, event string, data map[string]interface{}) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	return EnqueueWebhookEvent(GlobalDB, userID, event, data)
+}
+
+const webhookDeliveryColumns = `id, endpoint_id, user_id, event_id, event, payload, status, attempts, next_attempt_at,
+	last_status_code, last_error, created_at, delivered_at`
+
+// webhookDeliveryTarget добавляет к доставке адрес и секрет её endpoint.
+const webhookDeliveryTarget = `, (SELECT e.url FROM webhook_endpoints e WHERE e.id = endpoint_id),
+	(SELECT e.secret FROM webhook_endpoints e WHERE e.id = endpoint_id)`
+
+func scanWebhookDeliveries(rows *sql.Rows, withTarget bool) ([]domain.WebhookDelivery, error) {
+	defer rows.Close()
+	list := []domain.WebhookDelivery{}
+	for rows.Next() {
+		var d domain.WebhookDelivery
+		var deliveredAt sql.NullTime
+		dest := []interface{}{&d.ID, &d.EndpointID, &d.UserID, &d.EventID, &d.Event, &d.Payload, &d.Status, &d.Attempts,
+			&d.NextAttemptAt, &d.LastStatusCode, &d.LastError, &d.CreatedAt, &deliveredAt}
+		if withTarget {
+			dest = append(dest, &d.URL, &d.Secret)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if deliveredAt.Valid {
+			d.DeliveredAt = &deliveredAt.Time
+		}
+		list = append(list, d)
+	}
+	return list, rows.Err()
+}
+
+// ClaimDueWebhookDeliveries locks up to limit pending deliveries of active endpoints whose next
+// attempt is due, so that concurrent dispatchers never post the same event twice at once.
+func ClaimDueWebhookDeliveries(limit int) ([]domain.WebhookDelivery, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	rows, err := GlobalDB.Query(`
+		UPDATE webhook_deliveries SET locked_until = NOW() + $2::interval
+		WHERE id IN (
+			SELECT d.id FROM webhook_deliveries d
+			JOIN webhook_endpoints e ON e.id = d.endpoint_id AND e.is_active
+			WHERE d.status = 'PENDING' AND d.next_attempt_at <= NOW()
+			  AND (d.locked_until IS NULL OR d.locked_until < NOW())
+			ORDER BY d.next_attempt_at, d.id
+			LIMIT $1
+			FOR UPDATE OF d SKIP LOCKED
+		)
+		RETURNING `+webhookDeliveryColumns+webhookDeliveryTarget,
+		limit, fmt.Sprintf("%d seconds", int(webhookLockTTL.Seconds())))
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim webhook deliveries: %w", err)
+	}
+	return scanWebhookDeliveries(rows, true)
+}
+
+// CreateWebhookTestDelivery ставит тестовое событие endpoint пользователя (без учёта подписки)
+// и сразу резервирует его за вызывающим, чтобы отправить без очереди.
+func CreateWebhookTestDelivery(userID, endpointID int) (*domain.WebhookDelivery, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	if _, err := GetWebhookEndpoint(userID, endpointID); err != nil {
+		return nil, err
+	}
+	eventID, err := newWebhookEventID()
+	if err != nil {
+		return nil, err
+	}
+	payload, err := webhookPayload(eventID, domain.WebhookTest, time.Now(), map[string]interface{}{
+		"endpoint_id": endpointID,
+		"message":     "Test event from XPLR",
+	})
+	if err != nil {
+		return nil, err
+	}
+	rows, err := GlobalDB.Query(`
+		INSERT INTO webhook_deliveries (endpoint_id, user_id, event_id, event, payload, locked_until)
+		VALUES ($1, $2, $3, $4, $5, NOW() + $6::interval)
+		RETURNING `+webhookDeliveryColumns+webhookDeliveryTarget,
+		endpointID, userID, eventID, domain.WebhookTest, payload, fmt.Sprintf("%d seconds", int(webhookLockTTL.Seconds())))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create test delivery: %w", err)
+	}
+	list, err := scanWebhookDeliveries(rows, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, ErrWebhookNotFound
+	}
+	return &list[0], nil
+}
+
+// FinishWebhookAttempt logs an HTTP attempt and stores its outcome in the delivery queue.
+// status is the new delivery status: PENDING means the attempt failed and will be retried at
+// nextAttemptAt; DELIVERED and FAILED are final. statusCode 0 means no response was received.
+func FinishWebhookAttempt(d *domain.WebhookDelivery, status string, statusCode int, errMsg string, duration time.Duration, nextAttemptAt time.Time) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	tx, err := GlobalDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(
+		`INSERT INTO webhook_delivery_attempts (delivery_id, attempt, status_code, error, duration_ms) VALUES ($1, $2, $3, $4, $5)`,
+		d.ID, d.Attempts+1, statusCode, errMsg, duration.Milliseconds(),
+	); err != nil {
+		return fmt.Errorf("failed to log webhook attempt: %w", err)
+	}
+	if _, err := tx.Exec(`
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = attempts + 1, last_status_code = $3, last_error = $4, next_attempt_at = $5,
+		    locked_until = NULL, delivered_at = CASE WHEN $2 = 'DELIVERED' THEN NOW() ELSE delivered_at END
+		WHERE id = $1`,
+		d.ID, status, statusCode, errMsg, nextAttemptAt,
+	); err != nil {
+		return fmt.Errorf("failed to update webhook delivery: %w", err)
+	}
+	return tx.Commit()
+}
+
+// ListWebhookDeliveries — доставки endpoint пользователя, новые сверху.
+func ListWebhookDeliveries(userID, endpointID, limit int) ([]domain.WebhookDelivery, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	rows, err := GlobalDB.Query(`
+		SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries
+		WHERE endpoint_id = $1 AND user_id = $2
+		ORDER BY id DESC LIMIT $3`, endpointID, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+	return scanWebhookDeliveries(rows, false)
+}
+
+// ListWebhookDeliveryAttempts — журнал попыток доставки пользователя по порядку.
+func ListWebhookDeliveryAttempts(userID, endpointID, deliveryID int) ([]domain.WebhookDeliveryAttempt, error) {
+	if GlobalDB == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	var exists bool
+	if err := GlobalDB.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM webhook_deliveries WHERE id = $1 AND endpoint_id = $2 AND user_id = $3)`,
+		deliveryID, endpointID, userID,
+	).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrWebhookDeliveryNotFound
+	}
+	rows, err := GlobalDB.Query(`
+		SELECT id, delivery_id, attempt, status_code, error, duration_ms, created_at
+		FROM webhook_delivery_attempts WHERE delivery_id = $1 ORDER BY id`, deliveryID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook attempts: %w", err)
+	}
+	defer rows.Close()
+	list := []domain.WebhookDeliveryAttempt{}
+	for rows.Next() {
+		var a domain.WebhookDeliveryAttempt
+		if err := rows.Scan(&a.ID, &a.DeliveryID, &a.Attempt, &a.StatusCode, &a.Error, &a.DurationMs, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+// RedeliverWebhook ставит доставку (проваленную или уже доставленную) в очередь заново с тем же телом.
+func RedeliverWebhook(userID, endpointID, deliveryID int) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	res, err := GlobalDB.Exec(`
+		UPDATE webhook_deliveries SET status = 'PENDING', attempts = 0, next_attempt_at = NOW(), locked_until = NULL
+		WHERE id = $1 AND endpoint_id = $2 AND user_id = $3 AND status <> 'PENDING'`,
+		deliveryID, endpointID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		var exists bool
+		if err := GlobalDB.QueryRow(
+			`SELECT EXISTS(SELECT 1 FROM webhook_deliveries WHERE id = $1 AND endpoint_id = $2 AND user_id = $3)`,
+			deliveryID, endpointID, userID,
+		).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return ErrWebhookDeliveryScheduled
+		}
+		return ErrWebhookDeliveryNotFound
+	}
+	log.Printf("[WEBHOOKS] User %d requeued delivery #%d", userID, deliveryID)
+	return nil
+}
@@ -5,11 +5,13 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"html"
 	"io"
 	"log"
 	"net"
 	nethttp "net/http"
 	"net/smtp"
+	"net/url"
 	"os"
 	"strings"
 	"time"
@@ -594,3 +596,21 @@ func SendGenericEmail(toEmail, subject, htmlContent string) error {
 	log.Printf("[SMTP-OK] Email delivered to %s (subject=%q)", toEmail, subject)
 	return nil
 }
+
+// SendTeamInvitationEmail — приглашение в команду: ссылка ведёт на страницу принятия,
+// где существующий пользователь входит, а новый — регистрируется по приглашению.
+func SendTeamInvitationEmail(toEmail, teamName, inviterEmail, token string, expiresAt time.Time) error {
+	cfg := loadSMTPConfig()
+	inviteURL := fmt.Sprintf("%s/team-invite?token=%s", cfg.Domain, url.QueryEscape(token))
+
+	content := fmt.Sprintf(`
+    <p style="color:#cbd5e1;font-size:15px;line-height:1.6;margin:0 0 20px;">Здравствуйте!</p>
+    <p style="color:#94a3b8;font-size:14px;line-height:1.6;margin:0 0 24px;"><strong style="color:#fff;">%s</strong> приглашает вас в команду <strong style="color:#fff;">%s</strong> в XPLR.</p>
+    <div style="text-align:center;margin:0 0 24px;">
+      <a href="%s" style="display:inline-block;padding:14px 40px;background:linear-gradient(135deg,#3b82f6,#8b5cf6);color:#fff;text-decoration:none;border-radius:12px;font-size:14px;font-weight:600;">Принять приглашение</a>
+    </div>
+    <p style="color:#64748b;font-size:12px;line-height:1.5;margin:0;">Приглашение действительно до %s (UTC). Если вы не ждали этого письма — просто проигнорируйте его.</p>`,
+		html.EscapeString(inviterEmail), html.EscapeString(teamName), inviteURL, expiresAt.UTC().Format("02.01.2006 15:04"))
+
+	return SendGenericEmail(toEmail, "Приглашение в команду "+teamName, content)
+}
@@ -1,98 +1,238 @@
 package service
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/djalben/xplr-core/backend/repository"
 	"github.com/shopspring/decimal"
 )
 
+// rateBase — валюта, к которой заданы курсы exchange_rates (рублей за 1 единицу валюты).
+const rateBase = "RUB"
+
+// ratePegs — валюты без собственного курса в источниках, привязанные к другой валюте 1:1.
+var ratePegs = map[string]string{"USDT": "USD"}
+
+// rateStaleAlerted — предупреждение об устаревших курсах уже отправлено (сбрасывается, когда курсы обновятся).
+// Меняется только из горутины StartExchangeRateFetcher.
+var rateStaleAlerted bool
+
 // StartExchangeRateFetcher starts a background goroutine that updates base rates every hour.
 func StartExchangeRateFetcher() {
 	log.Println("[EXCHANGE] Rate fetcher started (updating every 1 hour)")
 
 	// Immediate first fetch
-	fetchAndUpdateRates()
+	fetchAndUpdateRates(DefaultRateSources())
 
 	ticker := time.NewTicker(1 * time.Hour)
 	for range ticker.C {
-		fetchAndUpdateRates()
+		fetchAndUpdateRates(DefaultRateSources())
 	}
 }
 
-func fetchAndUpdateRates() {
+// fetchAndUpdateRates опрашивает все источники, берёт медиану по каждой валюте и обновляет
+// base_rate пар RUB/*, если скачок не превышает rate_max_deviation_percent в час.
+func fetchAndUpdateRates(sources []RateSource) {
 	log.Println("[EXCHANGE] Fetching latest exchange rates...")
 
-	// Try free API: exchangerate-api.com (no key needed for USD base)
-	rubUsd, err := fetchRateFromAPI("USD", "RUB")
+	rates, err := repository.GetAllExchangeRates()
 	if err != nil {
-		log.Printf("[EXCHANGE] Warning: API fetch failed, using fallback: %v", err)
+		log.Printf("[EXCHANGE] Failed to load exchange_rates: %v", err)
 		return
 	}
+	var currencies []string
+	for _, r := range rates {
+		if r.CurrencyFrom == rateBase {
+			currencies = append(currencies, r.CurrencyTo)
+		}
+	}
 
-	if rubUsd.GreaterThan(decimal.Zero) {
-		if err := repository.UpdateBaseRate("RUB", "USD", rubUsd); err != nil {
-			log.Printf("[EXCHANGE] Failed to update RUB/USD: %v", err)
+	var snapshots []*RateSnapshot
+	for _, src := range sources {
+		snap, err := src.Fetch()
+		if err != nil {
+			log.Printf("[EXCHANGE] Warning: source %s failed: %v", src.Name(), err)
+			continue
 		}
+		snap.Source = src.Name()
+		snapshots = append(snapshots, snap)
 	}
 
-	// EUR rate: derive from USD/EUR cross rate
-	eurUsd, err := fetchRateFromAPI("USD", "EUR")
-	if err == nil && eurUsd.GreaterThan(decimal.Zero) {
-		// RUB/EUR = RUB/USD * USD/EUR  (how many RUB per 1 EUR)
-		// Actually: if rubUsd = how many RUB per 1 USD, and eurUsd = how many EUR per 1 USD
-		// then RUB per 1 EUR = rubUsd / eurUsd
-		rubEur := rubUsd.Div(eurUsd)
-		if err := repository.UpdateBaseRate("RUB", "EUR", rubEur); err != nil {
-			log.Printf("[EXCHANGE] Failed to update RUB/EUR: %v", err)
+	maxDeviation, staleAfter := repository.GetRateGuardSettings()
+	if len(snapshots) == 0 {
+		log.Println("[EXCHANGE] Warning: all rate sources failed, keeping current rates")
+	} else {
+		aggregated := aggregateRates(snapshots, currencies)
+		var rejected []string
+		for _, currency := range currencies {
+			newRate, ok := aggregated[currency]
+			if !ok {
+				log.Printf("[EXCHANGE] Warning: no source has %s/%s", rateBase, currency)
+				continue
+			}
+			oldRate, updatedAt, err := repository.GetBaseRate(rateBase, currency)
+			if err == nil && deviationExceeded(oldRate, newRate, maxDeviation, time.Since(updatedAt)) {
+				log.Printf("[EXCHANGE] ❌ %s/%s jump %s → %s rejected (max %s%%/h)",
+					rateBase, currency, oldRate.StringFixed(4), newRate.StringFixed(4), maxDeviation.String())
+				rejected = append(rejected, fmt.Sprintf("%s/%s: %s → %s", rateBase, currency, oldRate.StringFixed(4), newRate.StringFixed(4)))
+				continue
+			}
+			if err := repository.UpdateBaseRate(rateBase, currency, newRate, "fetcher:"+snapshotSources(snapshots)); err != nil {
+				log.Printf("[EXCHANGE] Failed to update %s/%s: %v", rateBase, currency, err)
+			}
+		}
+		if len(rejected) > 0 {
+			go NotifyAdmins("Скачок курса отклонён",
+				fmt.Sprintf("⚠️ <b>Курс не обновлён</b>\n\n"+
+					"Изменение больше %s%% в час:\n%s\n\n"+
+					"Источники: %s. Проверьте курс в админ-панели.",
+					maxDeviation.String(), strings.Join(rejected, "\n"), snapshotSources(snapshots)))
 		}
 	}
 
+	checkRateStaleness(currencies, staleAfter)
 	log.Println("[EXCHANGE] Rate update complete")
 }
 
-// fetchRateFromAPI fetches the exchange rate for base->target from a free API.
-// Returns how many units of `target` per 1 unit of `base`.
-func fetchRateFromAPI(base, target string) (decimal.Decimal, error) {
-	url := fmt.Sprintf("https://open.er-api.com/v6/latest/%s", base)
+// checkRateStaleness предупреждает администраторов, если курс пары не обновлялся дольше staleAfter.
+func checkRateStaleness(currencies []string, staleAfter time.Duration) {
+	var stale []string
+	for _, currency := range currencies {
+		_, updatedAt, err := repository.GetBaseRate(rateBase, currency)
+		if err != nil || time.Since(updatedAt) <= staleAfter {
+			continue
+		}
+		stale = append(stale, fmt.Sprintf("%s/%s — обновлён %s", rateBase, currency, updatedAt.Format("02.01.2006 15:04")))
+	}
+	if len(stale) == 0 {
+		rateStaleAlerted = false
+		return
+	}
+	if rateStaleAlerted {
+		return
+	}
+	rateStaleAlerted = true
+	log.Printf("[EXCHANGE] ⚠️ Stale rates: %s", strings.Join(stale, "; "))
+	go NotifyAdmins("Курсы валют устарели",
+		fmt.Sprintf("⏰ <b>Курсы не обновлялись больше %.0f ч</b>\n\n%s",
+			staleAfter.Hours(), strings.Join(stale, "\n")))
+}
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Get(url)
-	if err != nil {
-		return decimal.Zero, fmt.Errorf("HTTP request failed: %v", err)
+// aggregateRates — медиана рублёвых курсов (рублей за 1 единицу) по всем источникам.
+// Источники с рублём участвуют напрямую; остальные — через медиану RUB/USD, если в них есть USD.
+func aggregateRates(snapshots []*RateSnapshot, currencies []string) map[string]decimal.Decimal {
+	samples := map[string][]decimal.Decimal{}
+	var indirect []*RateSnapshot
+	for _, s := range snapshots {
+		direct := rubRatesFromSnapshot(s, currencies, decimal.Zero)
+		if direct == nil {
+			indirect = append(indirect, s)
+			continue
+		}
+		for c, r := range direct {
+			samples[c] = append(samples[c], r)
+		}
+	}
+	if anchor, ok := medianRate(samples["USD"]); ok {
+		for _, s := range indirect {
+			for c, r := range rubRatesFromSnapshot(s, currencies, anchor) {
+				samples[c] = append(samples[c], r)
+			}
+		}
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		return decimal.Zero, fmt.Errorf("API returned status %d", resp.StatusCode)
+	result := map[string]decimal.Decimal{}
+	for _, c := range currencies {
+		if m, ok := medianRate(samples[c]); ok {
+			result[c] = m.Round(4)
+		}
+	}
+	for c, peg := range ratePegs {
+		if _, ok := result[c]; !ok {
+			if r, ok := result[peg]; ok && containsCurrency(currencies, c) {
+				result[c] = r
+			}
+		}
 	}
+	return result
+}
 
-	var result struct {
-		Result string                 `json:"result"`
-		Rates  map[string]json.Number `json:"rates"`
+// rubRatesFromSnapshot — рублей за 1 единицу каждой из currencies по снимку. Если рубля в снимке нет,
+// курсы выводятся через anchorRubPerUSD (RUB/USD из других источников); без якоря возвращает nil.
+func rubRatesFromSnapshot(s *RateSnapshot, currencies []string, anchorRubPerUSD decimal.Decimal) map[string]decimal.Decimal {
+	out := map[string]decimal.Decimal{}
+	if rub, ok := s.rate(rateBase); ok {
+		for _, c := range currencies {
+			if r, ok := s.rate(c); ok {
+				out[c] = rub.DivRound(r, 8)
+			}
+		}
+		return out
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return decimal.Zero, fmt.Errorf("JSON decode error: %v", err)
+	usd, ok := s.rate("USD")
+	if !ok || !anchorRubPerUSD.IsPositive() {
+		return nil
 	}
+	for _, c := range currencies {
+		if c == "USD" {
+			continue // RUB/USD — сам якорь, из этого снимка новой информации о нём нет
+		}
+		if r, ok := s.rate(c); ok {
+			out[c] = anchorRubPerUSD.Mul(usd).DivRound(r, 8)
+		}
+	}
+	return out
+}
 
-	if result.Result != "success" {
-		return decimal.Zero, fmt.Errorf("API result: %s", result.Result)
+// medianRate — медиана выборки (для чётного размера — среднее двух центральных).
+func medianRate(values []decimal.Decimal) (decimal.Decimal, bool) {
+	if len(values) == 0 {
+		return decimal.Zero, false
 	}
+	sorted := append([]decimal.Decimal(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].LessThan(sorted[j]) })
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return sorted[mid], true
+	}
+	return sorted[mid-1].Add(sorted[mid]).Div(decimal.NewFromInt(2)), true
+}
 
-	rateStr, ok := result.Rates[target]
-	if !ok {
-		return decimal.Zero, fmt.Errorf("target currency %s not found in response", target)
+// deviationExceeded — новый курс отличается от текущего больше, чем на maxPercentPerHour за каждый
+// прошедший час (не меньше 1 и не больше 24 часов), поэтому застрявший курс со временем догоняет рынок.
+func deviationExceeded(oldRate, newRate, maxPercentPerHour decimal.Decimal, elapsed time.Duration) bool {
+	if !oldRate.IsPositive() {
+		return false
+	}
+	hours := elapsed.Hours()
+	if hours < 1 {
+		hours = 1
 	}
+	if hours > 24 {
+		hours = 24
+	}
+	allowed := maxPercentPerHour.Mul(decimal.NewFromFloat(hours))
+	change := newRate.Sub(oldRate).Abs().Div(oldRate).Mul(decimal.NewFromInt(100))
+	return change.GreaterThan(allowed)
+}
 
-	rate, err := decimal.NewFromString(rateStr.String())
-	if err != nil {
-		return decimal.Zero, fmt.Errorf("failed to parse rate: %v", err)
+func snapshotSources(snapshots []*RateSnapshot) string {
+	names := make([]string, 0, len(snapshots))
+	for _, s := range snapshots {
+		names = append(names, s.Source)
 	}
+	return strings.Join(names, ", ")
+}
 
-	log.Printf("[EXCHANGE] Fetched %s/%s = %s", base, target, rate.StringFixed(4))
-	return rate, nil
+func containsCurrency(list []string, c string) bool {
+	for _, v := range list {
+		if v == c {
+			return true
+		}
+	}
+	return false
 }
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"log"
 	"time"
+
+	"github.com/djalben/xplr-core/backend/domain"
 )
 
 // GoldExpiryWorker checks for users whose Gold tier is about to expire
@@ -66,7 +68,7 @@ func notifyUsersExpiringIn(days int) {
 			days, daysWord,
 		)
 
-		go NotifyUser(userID, "Gold истекает", msg)
+		go NotifyUserEvent(userID, domain.NotificationEventPurchases, "Gold истекает", msg)
 		count++
 	}
 
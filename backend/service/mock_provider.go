@@ -128,20 +128,14 @@ func (m *MockProvider) IssueCard(request IssueCardRequest) (*IssuedCard, error)
 	return issued, nil
 }
 
-// TopUpCard пополняет баланс карты в БД
+// TopUpCard подтверждает пополнение карты.
+// card_balance в БД XPLR ведет ledger при переводе Кошелек → карта, поэтому mock его не меняет.
 func (m *MockProvider) TopUpCard(cardID int, amount float64, currency string) error {
-	_, err := m.db.Exec(`
-		UPDATE cards 
-		SET card_balance = card_balance + $1,
-		    updated_at = NOW()
-		WHERE id = $2
-	`, amount, cardID)
-
-	if err != nil {
+	if amount <= 0 {
 		return &ProviderError{
 			Provider: "MockProvider",
 			Code:     "TOPUP_FAILED",
-			Message:  fmt.Sprintf("Failed to top up card %d: %v", cardID, err),
+			Message:  fmt.Sprintf("Invalid top-up amount %.2f for card %d", amount, cardID),
 		}
 	}
 
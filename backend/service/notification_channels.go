@@ -0,0 +1,71 @@
+package service
+
+import (
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/repository"
+)
+
+// Channel — канал доставки уведомлений пользователю (email, Telegram).
+type Channel interface {
+	Name() string
+	// Render собирает тему и текст шаблонного уведомления для канала на языке lang.
+	Render(templateID, lang string, data map[string]string) (subject, body string, err error)
+	// Send отправляет уведомление и возвращает получателя (email, chat_id).
+	// ErrNotificationRecipientMissing — канал у пользователя не подключён.
+	Send(n domain.OutboxNotification) (recipient string, err error)
+}
+
+type emailChannel struct{}
+
+func (emailChannel) Name() string { return domain.NotificationChannelEmail }
+
+func (c emailChannel) Render(templateID, lang string, data map[string]string) (string, string, error) {
+	return RenderNotification(templateID, c.Name(), lang, data)
+}
+
+func (emailChannel) Send(n domain.OutboxNotification) (string, error) {
+	return SendEmailNotification(n.UserID, n.Subject, n.Body)
+}
+
+type telegramChannel struct{}
+
+func (telegramChannel) Name() string { return domain.NotificationChannelTelegram }
+
+func (c telegramChannel) Render(templateID, lang string, data map[string]string) (string, string, error) {
+	return RenderNotification(templateID, c.Name(), lang, data)
+}
+
+func (telegramChannel) Send(n domain.OutboxNotification) (string, error) {
+	return SendTelegramNotification(n.UserID, n.Body, n.ImageURL)
+}
+
+var notificationChannels = []Channel{emailChannel{}, telegramChannel{}}
+
+// NotificationChannels — все каналы доставки уведомлений.
+func NotificationChannels() []Channel {
+	return notificationChannels
+}
+
+// NotificationChannelByName — канал по имени из notification_outbox.channel.
+func NotificationChannelByName(name string) (Channel, bool) {
+	for _, ch := range notificationChannels {
+		if ch.Name() == name {
+			return ch, true
+		}
+	}
+	return nil, false
+}
+
+// PrepareNotification собирает тему и текст шаблонного уведомления на языке пользователя.
+// Уведомления с готовым текстом не меняются.
+func PrepareNotification(ch Channel, n *domain.OutboxNotification) error {
+	if n.Template == "" {
+		return nil
+	}
+	subject, body, err := ch.Render(n.Template, repository.GetUserLanguage(n.UserID), n.Data)
+	if err != nil {
+		return err
+	}
+	n.Subject, n.Body = subject, body
+	return nil
+}
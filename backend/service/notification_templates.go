@@ -0,0 +1,427 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/repository"
+)
+
+// ErrUnknownNotificationTemplate — у шаблона нет текста ни в translations, ни среди встроенных.
+var ErrUnknownNotificationTemplate = errors.New("unknown notification template")
+
+// notificationTemplateDefaults — встроенные тексты шаблонов уведомлений по языкам.
+// Ключи: notify.<id>.subject, notify.<id>.body, необязательно notify.<id>.email / notify.<id>.telegram.
+// Любой текст можно переопределить в translations (админка → Переводы) под тем же ключом.
+// Значения Data подставляются как {{.key}}; в тексте они экранируются как HTML.
+var notificationTemplateDefaults = map[string]map[string]string{
+	"ru": {
+		"notify.card.declined.subject": "Транзакция отклонена",
+		"notify.card.declined.body": "❌ <b>Транзакция по карте *{{.last4}} отклонена</b>\n\n" +
+			"Причина: {{.reason}}.\n\n" +
+			`{{if .insufficient_funds}}<a href="https://xplr.pro/wallet">Пополнить кошелёк</a>{{else}}<a href="https://xplr.pro/cards">Открыть карты</a>{{end}}`,
+
+		"notify.card.blocked.subject": "Карта заблокирована",
+		"notify.card.blocked.body": "🔒 <b>Карта *{{.last4}} заблокирована</b>\n\n" +
+			"Причина: {{.reason}}.\n\n" +
+			`<a href="https://xplr.pro/cards">Открыть карты</a>`,
+
+		"notify.card.auto_replenished.subject": "Автопополнение карты",
+		"notify.card.auto_replenished.body": "✅ <b>Автопополнение</b>\n\n" +
+			"С вашего кошелька переведено <b>{{.amount}} {{.currency}}</b> на карту *{{.last4}}.\n" +
+			"Новый баланс карты: <b>{{.balance}} {{.currency}}</b>\n\n" +
+			`<a href="https://xplr.pro/cards">Открыть карты</a>`,
+
+		"notify.card.auto_replenish_failed.subject": "Автопополнение не удалось",
+		"notify.card.auto_replenish_failed.body": "⚠️ <b>Автопополнение не удалось</b>\n\n" +
+			"Недостаточно средств в кошельке {{.currency}} для пополнения карты *{{.last4}}.\n" +
+			"Требуется: <b>{{.required}} {{.currency}}</b>, доступно: <b>{{.available}} {{.currency}}</b>\n\n" +
+			`<a href="https://xplr.pro/wallet">Пополнить кошелёк</a>`,
+
+		"notify.team.cards_reassigned.subject": "Вам переданы карты команды",
+		"notify.team.cards_reassigned.body": "💳 <b>Вам переданы карты команды</b>\n\n{{.cards}}\n\n" +
+			"{{if .reclaimed}}Остаток части карт возвращён в Кошелёк команды.\n\n{{end}}" +
+			`<a href="https://xplr.pro/cards">Открыть карты</a>`,
+
+		"notify.wallet.topped_up.subject": "Пополнение баланса",
+		"notify.wallet.topped_up.body": "💰 <b>Кошелёк пополнен</b>\n\n" +
+			"Сумма: <b>{{.amount}} {{.currency}}</b>\n" +
+			"{{if .source}}Источник: <b>{{.source}}</b>\n{{end}}" +
+			"{{if .balance}}Баланс: <b>{{.balance}}</b>\n{{end}}\n" +
+			`<a href="https://xplr.pro/wallet">Открыть кошелёк</a>`,
+
+		"notify.order.ready.subject": "Заказ готов — XPLR Store",
+		"notify.order.ready.body": "🛒 <b>Заказ #{{.order_id}} — Готов к выдаче!</b>\n\n" +
+			"Товар: <b>{{.product}}</b>\n" +
+			"Цена: <b>${{.price}}</b>\n\n" +
+			"{{if .has_qr}}QR-код для активации eSIM доступен в вашем кабинете.\n\n{{else if .activation_key}}Ваш ключ активации: <code>{{.activation_key}}</code>\n\n{{end}}" +
+			`<a href="https://xplr.pro/purchases">Мои покупки</a>`,
+
+		"notify.tier.upgraded.subject": "Gold {{if .extended}}продлён{{else}}активирован{{end}}",
+		"notify.tier.upgraded.body": "🏆 <b>Gold {{if .extended}}продлён{{else}}активирован{{end}}!</b>\n\n" +
+			"Срок: до <b>{{.expires_at}}</b>\n" +
+			"Лимит карт: <b>{{.card_limit}}</b>\n" +
+			"Стоимость: <b>${{.price}}</b>\n\n" +
+			`<a href="https://xplr.pro/dashboard">Открыть дашборд</a>`,
+
+		"notify.card.hold_placed.subject": "Резерв по карте",
+		"notify.card.hold_placed.body": "⏳ <b>Средства зарезервированы по карте *{{.last4}}</b>\n\n" +
+			"Сумма: <b>{{.amount}} {{.currency}}</b>\n" +
+			"Магазин: {{.merchant}}\n\n" +
+			"Списание произойдёт после подтверждения магазином.\n\n" +
+			`<a href="https://xplr.pro/cards">Открыть карты</a>`,
+
+		"notify.card.charged.subject": "Списание с карты",
+		"notify.card.charged.body": "💸 <b>Списание с карты *{{.last4}}</b>\n\n" +
+			"Сумма: <b>{{.amount}} {{.currency}}</b>\n" +
+			"Магазин: {{.merchant}}\n" +
+			"{{if .fee}}Комиссия: {{.fee}}\n{{end}}\n" +
+			`<a href="https://xplr.pro/cards">Открыть карты</a>`,
+
+		"notify.card.hold_released.subject": "Резерв снят",
+		"notify.card.hold_released.body": "↩️ <b>Резерв по карте *{{.last4}} снят</b>\n\n" +
+			"Магазин: {{.merchant}}\n\n" +
+			`<a href="https://xplr.pro/wallet">Открыть кошелёк</a>`,
+
+		"notify.card.hold_expired.subject": "Резерв снят",
+		"notify.card.hold_expired.body": "↩️ <b>Резерв ${{.amount}} снят</b>\n\n" +
+			"Магазин {{.merchant}} не подтвердил списание в течение {{.days}} дн. — средства снова доступны в Кошельке.\n\n" +
+			`<a href="https://xplr.pro/wallet">Открыть кошелёк</a>`,
+
+		"notify.card.refunded.subject": "Возврат средств",
+		"notify.card.refunded.body": "💰 <b>Возврат средств на кошелёк</b>\n\n" +
+			"Карта: *{{.last4}}\n" +
+			"Сумма возврата: <b>{{.amount}} {{.currency}}</b>\n\n" +
+			`<a href="https://xplr.pro/wallet">Открыть кошелёк</a>`,
+
+		"notify.card.chargeback_opened.subject": "Chargeback открыт",
+		"notify.card.chargeback_opened.body": "🔎 <b>Эмитент рассматривает chargeback по карте *{{.last4}}</b>\n\n" +
+			"Магазин: {{.merchant}}\n\n" +
+			`<a href="https://xplr.pro/history">История операций</a>`,
+
+		"notify.card.chargeback_won.subject": "Chargeback одобрен",
+		"notify.card.chargeback_won.body": "✅ <b>Chargeback по карте *{{.last4}} одобрен</b>\n\n" +
+			"Магазин: {{.merchant}}\n" +
+			"Сумма возврата: <b>{{.amount}} {{.currency}}</b>\n\n" +
+			`<a href="https://xplr.pro/history">История операций</a>`,
+
+		"notify.card.chargeback_lost.subject": "Chargeback отклонён",
+		"notify.card.chargeback_lost.body": "❌ <b>Chargeback по карте *{{.last4}} отклонён</b>\n\n" +
+			"Магазин: {{.merchant}}\n\n" +
+			`<a href="https://xplr.pro/history">История операций</a>`,
+
+		"notify.card.funded.subject": "Карта пополнена",
+		"notify.card.funded.body": "💳 <b>Карта пополнена</b>\n\n" +
+			"Карта: *{{.last4}}\n" +
+			"Сумма: <b>{{.amount}} {{.currency}}</b>\n\n" +
+			`<a href="https://xplr.pro/cards">Открыть карты</a>`,
+
+		"notify.cards.issued.subject": "Карта выпущена",
+		"notify.cards.issued.body": "💳 <b>Карта успешно выпущена!</b>\n\n" +
+			"📦 <b>Количество:</b> {{.count}}\n" +
+			"🏷 <b>Категория:</b> {{.category}}\n" +
+			"💰 <b>Комиссия:</b> ${{.fee}}\n" +
+			"📊 <b>Дневной лимит:</b> ${{.daily_limit}}\n\n" +
+			"{{if .failed}}⚠️ Не выпущено карт: {{.failed}}, комиссия ${{.refunded}} возвращена в {{if .team_wallet}}Кошелёк команды{{else}}Кошелёк{{end}}.\n\n{{end}}" +
+			`Карта уже доступна в <a href="https://xplr.pro/cards">личном кабинете</a>.`,
+
+		"notify.cards.issue_failed.subject": "Карты не выпущены",
+		"notify.cards.issue_failed.body": "⚠️ <b>Не удалось выпустить карты</b>\n\n" +
+			"📦 <b>Запрошено:</b> {{.requested}}\n" +
+			"💰 <b>Комиссия возвращена:</b> ${{.refunded}}\n\n" +
+			"Попробуйте позже или напишите в поддержку.",
+
+		"notify.dispute.opened.subject": "Спор открыт",
+		"notify.dispute.opened.body": "⚖️ <b>Спор #{{.dispute_id}} открыт</b>\n\n" +
+			"Транзакция: {{.merchant}}, ${{.amount}}\n" +
+			"Мы рассмотрим обращение и сообщим о решении.\n\n" +
+			`<a href="https://xplr.pro/history">История операций</a>`,
+
+		"notify.dispute.in_review.subject": "Спор на рассмотрении",
+		"notify.dispute.in_review.body": "🔎 <b>Спор #{{.dispute_id}} передан на рассмотрение</b>" +
+			"{{if .comment}}\n\nКомментарий: {{.comment}}{{end}}\n\n" +
+			`<a href="https://xplr.pro/history">История операций</a>`,
+
+		"notify.dispute.won.subject": "Спор решён в вашу пользу",
+		"notify.dispute.won.body": "✅ <b>Спор #{{.dispute_id}} решён в вашу пользу</b>" +
+			"{{if .refunded}}\n\nВозвращено: <b>${{.refunded}}</b>{{end}}" +
+			"{{if .comment}}\n\nКомментарий: {{.comment}}{{end}}\n\n" +
+			`<a href="https://xplr.pro/history">История операций</a>`,
+
+		"notify.dispute.lost.subject": "Спор отклонён",
+		"notify.dispute.lost.body": "❌ <b>Спор #{{.dispute_id}} отклонён</b>" +
+			"{{if .comment}}\n\nКомментарий: {{.comment}}{{end}}\n\n" +
+			`<a href="https://xplr.pro/history">История операций</a>`,
+
+		"notify.wallet.converted.subject": "Конвертация валюты",
+		"notify.wallet.converted.body": "💱 <b>Конвертация выполнена</b>\n\n" +
+			"Списано: <b>{{.from_amount}} {{.from_currency}}</b>\n" +
+			"Зачислено: <b>{{.to_amount}} {{.to_currency}}</b>\n" +
+			"Курс: 1 {{.from_currency}} = {{.rate}} {{.to_currency}}\n\n" +
+			`<a href="https://xplr.pro/wallet">Открыть кошелёк</a>`,
+
+		"notify.wallet.auto_topup_changed.subject": "Автопополнение {{if .enabled}}включено ✅{{else}}выключено ❌{{end}}",
+		"notify.wallet.auto_topup_changed.body": "⚙️ <b>Настройка изменена</b>\n\n" +
+			"Автопополнение карт: <b>{{if .enabled}}включено ✅{{else}}выключено ❌{{end}}</b>\n\n" +
+			"При нехватке средств на карте система {{if .enabled}}будет{{else}}не будет{{end}} переводить средства из Кошелька автоматически.",
+
+		"notify.scheduled_transfer.completed.subject": "Перевод по расписанию выполнен",
+		"notify.scheduled_transfer.completed.body": "🗓 <b>Перевод по расписанию #{{.rule_id}} выполнен</b>\n\n" +
+			"{{.cards}}\n\n" +
+			"Следующий: {{.next}}\n\n" +
+			`<a href="https://xplr.pro/wallet">Открыть кошелёк</a>`,
+
+		"notify.scheduled_transfer.failed.subject": "Перевод по расписанию не выполнен",
+		"notify.scheduled_transfer.failed.body": "❌ <b>Перевод по расписанию #{{.rule_id}} не выполнен</b>\n\n" +
+			"Попыток: {{.attempts}}\n{{.errors}}\n\n" +
+			"Следующий срок: {{.next}}\n\n" +
+			`<a href="https://xplr.pro/wallet">Открыть кошелёк</a>`,
+
+		"notify.scheduled_transfer.retrying.subject": "Ошибка перевода по расписанию",
+		"notify.scheduled_transfer.retrying.body": "⚠️ <b>Перевод по расписанию #{{.rule_id}}: ошибка</b>\n\n" +
+			"{{.errors}}\n\n" +
+			"Повтор (попытка {{.attempt}} из {{.max_attempts}}): {{.next}}\n\n" +
+			`<a href="https://xplr.pro/wallet">Открыть кошелёк</a>`,
+
+		"notify.scheduled_transfer.paused.subject": "Перевод по расписанию приостановлен",
+		"notify.scheduled_transfer.paused.body": "⛔ <b>Перевод по расписанию #{{.rule_id}} приостановлен</b>\n\n" +
+			"У вас больше нет доступа к картам правила. Включите правило снова, когда доступ будет восстановлен.\n\n" +
+			`<a href="https://xplr.pro/wallet">Открыть кошелёк</a>`,
+
+		"notify.digest.subject": "Сводка операций за сутки",
+		"notify.digest.body":    "📋 <b>Сводка операций</b>\n\nМелкие операции за сутки: <b>{{.count}}</b>",
+	},
+	"en": {
+		"notify.card.declined.subject": "Transaction declined",
+		"notify.card.declined.body": "❌ <b>Transaction on card *{{.last4}} declined</b>\n\n" +
+			"Reason: {{.reason}}.\n\n" +
+			`{{if .insufficient_funds}}<a href="https://xplr.pro/wallet">Top up wallet</a>{{else}}<a href="https://xplr.pro/cards">Open cards</a>{{end}}`,
+
+		"notify.card.blocked.subject": "Card blocked",
+		"notify.card.blocked.body": "🔒 <b>Card *{{.last4}} blocked</b>\n\n" +
+			"Reason: {{.reason}}.\n\n" +
+			`<a href="https://xplr.pro/cards">Open cards</a>`,
+
+		"notify.card.auto_replenished.subject": "Card auto top-up",
+		"notify.card.auto_replenished.body": "✅ <b>Auto top-up</b>\n\n" +
+			"<b>{{.amount}} {{.currency}}</b> moved from your wallet to card *{{.last4}}.\n" +
+			"New card balance: <b>{{.balance}} {{.currency}}</b>\n\n" +
+			`<a href="https://xplr.pro/cards">Open cards</a>`,
+
+		"notify.card.auto_replenish_failed.subject": "Auto top-up failed",
+		"notify.card.auto_replenish_failed.body": "⚠️ <b>Auto top-up failed</b>\n\n" +
+			"Not enough funds in your {{.currency}} wallet to top up card *{{.last4}}.\n" +
+			"Required: <b>{{.required}} {{.currency}}</b>, available: <b>{{.available}} {{.currency}}</b>\n\n" +
+			`<a href="https://xplr.pro/wallet">Top up wallet</a>`,
+
+		"notify.team.cards_reassigned.subject": "Team cards assigned to you",
+		"notify.team.cards_reassigned.body": "💳 <b>Team cards assigned to you</b>\n\n{{.cards}}\n\n" +
+			"{{if .reclaimed}}The balance of some cards was returned to the team wallet.\n\n{{end}}" +
+			`<a href="https://xplr.pro/cards">Open cards</a>`,
+
+		"notify.wallet.topped_up.subject": "Wallet top-up",
+		"notify.wallet.topped_up.body": "💰 <b>Wallet topped up</b>\n\n" +
+			"Amount: <b>{{.amount}} {{.currency}}</b>\n" +
+			"{{if .source}}Source: <b>{{.source}}</b>\n{{end}}" +
+			"{{if .balance}}Balance: <b>{{.balance}}</b>\n{{end}}\n" +
+			`<a href="https://xplr.pro/wallet">Open wallet</a>`,
+
+		"notify.order.ready.subject": "Order ready — XPLR Store",
+		"notify.order.ready.body": "🛒 <b>Order #{{.order_id}} is ready!</b>\n\n" +
+			"Product: <b>{{.product}}</b>\n" +
+			"Price: <b>${{.price}}</b>\n\n" +
+			"{{if .has_qr}}The eSIM activation QR code is available in your account.\n\n{{else if .activation_key}}Your activation key: <code>{{.activation_key}}</code>\n\n{{end}}" +
+			`<a href="https://xplr.pro/purchases">My purchases</a>`,
+
+		"notify.tier.upgraded.subject": "Gold {{if .extended}}extended{{else}}activated{{end}}",
+		"notify.tier.upgraded.body": "🏆 <b>Gold {{if .extended}}extended{{else}}activated{{end}}!</b>\n\n" +
+			"Valid until: <b>{{.expires_at}}</b>\n" +
+			"Card limit: <b>{{.card_limit}}</b>\n" +
+			"Price: <b>${{.price}}</b>\n\n" +
+			`<a href="https://xplr.pro/dashboard">Open dashboard</a>`,
+
+		"notify.card.hold_placed.subject": "Card hold",
+		"notify.card.hold_placed.body": "⏳ <b>Funds held on card *{{.last4}}</b>\n\n" +
+			"Amount: <b>{{.amount}} {{.currency}}</b>\n" +
+			"Merchant: {{.merchant}}\n\n" +
+			"The charge will be completed once the merchant confirms it.\n\n" +
+			`<a href="https://xplr.pro/cards">Open cards</a>`,
+
+		"notify.card.charged.subject": "Card charge",
+		"notify.card.charged.body": "💸 <b>Card *{{.last4}} charged</b>\n\n" +
+			"Amount: <b>{{.amount}} {{.currency}}</b>\n" +
+			"Merchant: {{.merchant}}\n" +
+			"{{if .fee}}Fee: {{.fee}}\n{{end}}\n" +
+			`<a href="https://xplr.pro/cards">Open cards</a>`,
+
+		"notify.card.hold_released.subject": "Hold released",
+		"notify.card.hold_released.body": "↩️ <b>Hold on card *{{.last4}} released</b>\n\n" +
+			"Merchant: {{.merchant}}\n\n" +
+			`<a href="https://xplr.pro/wallet">Open wallet</a>`,
+
+		"notify.card.hold_expired.subject": "Hold released",
+		"notify.card.hold_expired.body": "↩️ <b>${{.amount}} hold released</b>\n\n" +
+			"{{.merchant}} did not confirm the charge within {{.days}} days — the funds are available in your wallet again.\n\n" +
+			`<a href="https://xplr.pro/wallet">Open wallet</a>`,
+
+		"notify.card.refunded.subject": "Refund received",
+		"notify.card.refunded.body": "💰 <b>Refund to your wallet</b>\n\n" +
+			"Card: *{{.last4}}\n" +
+			"Refund amount: <b>{{.amount}} {{.currency}}</b>\n\n" +
+			`<a href="https://xplr.pro/wallet">Open wallet</a>`,
+
+		"notify.card.chargeback_opened.subject": "Chargeback opened",
+		"notify.card.chargeback_opened.body": "🔎 <b>The issuer is reviewing a chargeback on card *{{.last4}}</b>\n\n" +
+			"Merchant: {{.merchant}}\n\n" +
+			`<a href="https://xplr.pro/history">Transaction history</a>`,
+
+		"notify.card.chargeback_won.subject": "Chargeback approved",
+		"notify.card.chargeback_won.body": "✅ <b>Chargeback on card *{{.last4}} approved</b>\n\n" +
+			"Merchant: {{.merchant}}\n" +
+			"Refund amount: <b>{{.amount}} {{.currency}}</b>\n\n" +
+			`<a href="https://xplr.pro/history">Transaction history</a>`,
+
+		"notify.card.chargeback_lost.subject": "Chargeback declined",
+		"notify.card.chargeback_lost.body": "❌ <b>Chargeback on card *{{.last4}} declined</b>\n\n" +
+			"Merchant: {{.merchant}}\n\n" +
+			`<a href="https://xplr.pro/history">Transaction history</a>`,
+
+		"notify.card.funded.subject": "Card topped up",
+		"notify.card.funded.body": "💳 <b>Card topped up</b>\n\n" +
+			"Card: *{{.last4}}\n" +
+			"Amount: <b>{{.amount}} {{.currency}}</b>\n\n" +
+			`<a href="https://xplr.pro/cards">Open cards</a>`,
+
+		"notify.cards.issued.subject": "Card issued",
+		"notify.cards.issued.body": "💳 <b>Card issued successfully!</b>\n\n" +
+			"📦 <b>Quantity:</b> {{.count}}\n" +
+			"🏷 <b>Category:</b> {{.category}}\n" +
+			"💰 <b>Fee:</b> ${{.fee}}\n" +
+			"📊 <b>Daily limit:</b> ${{.daily_limit}}\n\n" +
+			"{{if .failed}}⚠️ Cards not issued: {{.failed}}, the ${{.refunded}} fee was returned to {{if .team_wallet}}the team wallet{{else}}your wallet{{end}}.\n\n{{end}}" +
+			`The card is already available in <a href="https://xplr.pro/cards">your account</a>.`,
+
+		"notify.cards.issue_failed.subject": "Cards not issued",
+		"notify.cards.issue_failed.body": "⚠️ <b>Cards could not be issued</b>\n\n" +
+			"📦 <b>Requested:</b> {{.requested}}\n" +
+			"💰 <b>Fee refunded:</b> ${{.refunded}}\n\n" +
+			"Please try again later or contact support.",
+
+		"notify.dispute.opened.subject": "Dispute opened",
+		"notify.dispute.opened.body": "⚖️ <b>Dispute #{{.dispute_id}} opened</b>\n\n" +
+			"Transaction: {{.merchant}}, ${{.amount}}\n" +
+			"We will review it and let you know the decision.\n\n" +
+			`<a href="https://xplr.pro/history">Transaction history</a>`,
+
+		"notify.dispute.in_review.subject": "Dispute under review",
+		"notify.dispute.in_review.body": "🔎 <b>Dispute #{{.dispute_id}} is under review</b>" +
+			"{{if .comment}}\n\nComment: {{.comment}}{{end}}\n\n" +
+			`<a href="https://xplr.pro/history">Transaction history</a>`,
+
+		"notify.dispute.won.subject": "Dispute resolved in your favour",
+		"notify.dispute.won.body": "✅ <b>Dispute #{{.dispute_id}} resolved in your favour</b>" +
+			"{{if .refunded}}\n\nRefunded: <b>${{.refunded}}</b>{{end}}" +
+			"{{if .comment}}\n\nComment: {{.comment}}{{end}}\n\n" +
+			`<a href="https://xplr.pro/history">Transaction history</a>`,
+
+		"notify.dispute.lost.subject": "Dispute declined",
+		"notify.dispute.lost.body": "❌ <b>Dispute #{{.dispute_id}} declined</b>" +
+			"{{if .comment}}\n\nComment: {{.comment}}{{end}}\n\n" +
+			`<a href="https://xplr.pro/history">Transaction history</a>`,
+
+		"notify.wallet.converted.subject": "Currency conversion",
+		"notify.wallet.converted.body": "💱 <b>Conversion completed</b>\n\n" +
+			"Debited: <b>{{.from_amount}} {{.from_currency}}</b>\n" +
+			"Credited: <b>{{.to_amount}} {{.to_currency}}</b>\n" +
+			"Rate: 1 {{.from_currency}} = {{.rate}} {{.to_currency}}\n\n" +
+			`<a href="https://xplr.pro/wallet">Open wallet</a>`,
+
+		"notify.wallet.auto_topup_changed.subject": "Auto top-up {{if .enabled}}enabled ✅{{else}}disabled ❌{{end}}",
+		"notify.wallet.auto_topup_changed.body": "⚙️ <b>Setting changed</b>\n\n" +
+			"Card auto top-up: <b>{{if .enabled}}enabled ✅{{else}}disabled ❌{{end}}</b>\n\n" +
+			"When a card runs low, funds {{if .enabled}}will{{else}}will not{{end}} be moved from your wallet automatically.",
+
+		"notify.scheduled_transfer.completed.subject": "Scheduled transfer completed",
+		"notify.scheduled_transfer.completed.body": "🗓 <b>Scheduled transfer #{{.rule_id}} completed</b>\n\n" +
+			"{{.cards}}\n\n" +
+			"Next: {{.next}}\n\n" +
+			`<a href="https://xplr.pro/wallet">Open wallet</a>`,
+
+		"notify.scheduled_transfer.failed.subject": "Scheduled transfer failed",
+		"notify.scheduled_transfer.failed.body": "❌ <b>Scheduled transfer #{{.rule_id}} failed</b>\n\n" +
+			"Attempts: {{.attempts}}\n{{.errors}}\n\n" +
+			"Next run: {{.next}}\n\n" +
+			`<a href="https://xplr.pro/wallet">Open wallet</a>`,
+
+		"notify.scheduled_transfer.retrying.subject": "Scheduled transfer error",
+		"notify.scheduled_transfer.retrying.body": "⚠️ <b>Scheduled transfer #{{.rule_id}}: error</b>\n\n" +
+			"{{.errors}}\n\n" +
+			"Retry (attempt {{.attempt}} of {{.max_attempts}}): {{.next}}\n\n" +
+			`<a href="https://xplr.pro/wallet">Open wallet</a>`,
+
+		"notify.scheduled_transfer.paused.subject": "Scheduled transfer paused",
+		"notify.scheduled_transfer.paused.body": "⛔ <b>Scheduled transfer #{{.rule_id}} paused</b>\n\n" +
+			"You no longer have access to the rule's cards. Turn the rule back on once access is restored.\n\n" +
+			`<a href="https://xplr.pro/wallet">Open wallet</a>`,
+
+		"notify.digest.subject": "Daily transactions digest",
+		"notify.digest.body":    "📋 <b>Daily digest</b>\n\nSmall transactions in the last day: <b>{{.count}}</b>",
+	},
+}
+
+// notificationText — текст по ключу на языке lang: translations, затем встроенный текст;
+// если на языке пользователя текста нет — то же для русского.
+func notificationText(key, lang string) (string, bool) {
+	for _, l := range []string{lang, domain.DefaultNotificationLanguage} {
+		if v := repository.T(key, l); v != key {
+			return v, true
+		}
+		if v, ok := notificationTemplateDefaults[l][key]; ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// RenderNotification собирает тему и текст уведомления templateID для канала (email, telegram)
+// на языке lang. Тема — обычный текст, текст — HTML с экранированными значениями data.
+func RenderNotification(templateID, channel, lang string, data map[string]string) (string, string, error) {
+	key := "notify." + templateID
+	subjectText, ok := notificationText(key+".subject", lang)
+	if !ok {
+		return "", "", fmt.Errorf("%w: %s", ErrUnknownNotificationTemplate, templateID)
+	}
+	bodyText, ok := notificationText(key+"."+channel, lang)
+	if !ok {
+		if bodyText, ok = notificationText(key+".body", lang); !ok {
+			return "", "", fmt.Errorf("%w: %s has no body", ErrUnknownNotificationTemplate, templateID)
+		}
+	}
+	if data == nil {
+		data = map[string]string{}
+	}
+
+	subjectTpl, err := texttemplate.New(key).Option("missingkey=zero").Parse(subjectText)
+	if err != nil {
+		return "", "", fmt.Errorf("template %s subject: %w", templateID, err)
+	}
+	var subject strings.Builder
+	if err := subjectTpl.Execute(&subject, data); err != nil {
+		return "", "", fmt.Errorf("template %s subject: %w", templateID, err)
+	}
+
+	bodyTpl, err := htmltemplate.New(key).Option("missingkey=zero").Parse(bodyText)
+	if err != nil {
+		return "", "", fmt.Errorf("template %s body: %w", templateID, err)
+	}
+	var body bytes.Buffer
+	if err := bodyTpl.Execute(&body, data); err != nil {
+		return "", "", fmt.Errorf("template %s body: %w", templateID, err)
+	}
+	return subject.String(), body.String(), nil
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/djalben/xplr-core/backend/domain"
+)
+
+func TestNotificationTemplatesRenderInAllLanguages(t *testing.T) {
+	templates := []string{domain.NotifyDigest}
+	for id := range domain.NotificationTemplateEvents {
+		templates = append(templates, id)
+	}
+	for _, lang := range domain.NotificationLanguages {
+		for _, id := range templates {
+			for _, ch := range NotificationChannels() {
+				if _, ok := notificationTemplateDefaults[lang]["notify."+id+".subject"]; !ok {
+					t.Errorf("%s: no built-in %s subject", id, lang)
+				}
+				subject, body, err := ch.Render(id, lang, map[string]string{"last4": "4242"})
+				if err != nil {
+					t.Fatalf("Render(%s, %s, %s): %v", id, ch.Name(), lang, err)
+				}
+				if subject == "" || body == "" {
+					t.Errorf("Render(%s, %s, %s) = %q, %q", id, ch.Name(), lang, subject, body)
+				}
+			}
+		}
+	}
+}
+
+func TestRenderNotificationEscapesDataAndFallsBack(t *testing.T) {
+	subject, body, err := RenderNotification(domain.NotifyCardDeclined, domain.NotificationChannelTelegram, "de",
+		map[string]string{"last4": "1234", "reason": "<script>", "insufficient_funds": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "Транзакция отклонена" {
+		t.Errorf("unknown language should fall back to ru, got subject %q", subject)
+	}
+	if strings.Contains(body, "<script>") || !strings.Contains(body, "&lt;script&gt;") {
+		t.Errorf("data must be HTML-escaped, got %q", body)
+	}
+	if !strings.Contains(body, "https://xplr.pro/wallet") {
+		t.Errorf("insufficient funds decline should link to the wallet, got %q", body)
+	}
+
+	subject, _, err = RenderNotification(domain.NotifyTierUpgraded, domain.NotificationChannelEmail, "en",
+		map[string]string{"extended": "1"})
+	if err != nil || subject != "Gold extended" {
+		t.Errorf("tier subject = %q, %v", subject, err)
+	}
+
+	if _, _, err := RenderNotification("no.such", domain.NotificationChannelEmail, "ru", nil); !errors.Is(err, ErrUnknownNotificationTemplate) {
+		t.Errorf("unknown template error = %v", err)
+	}
+}
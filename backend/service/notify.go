@@ -1,94 +1,51 @@
 package service
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"strconv"
 
+	"github.com/djalben/xplr-core/backend/domain"
 	"github.com/djalben/xplr-core/backend/repository"
 	"github.com/djalben/xplr-core/backend/telegram"
+	"github.com/shopspring/decimal"
 )
 
+// ErrNotificationRecipientMissing — канал не подключён: у пользователя нет email или не привязан Telegram.
+// Такое уведомление не повторяется.
+var ErrNotificationRecipientMissing = errors.New("notification channel not linked")
+
 // NotifyUser sends a notification to a user based on their notification_pref setting.
 // pref = 'email' → email only, 'telegram' → TG only, 'both' → both channels.
 // subject is used for email; htmlMsg is used for both email body and TG message.
 //
-// CRITICAL DESIGN: Each channel (Email, Telegram) is fully isolated in its own goroutine
-// with its own DB lookup. A failure in one channel NEVER blocks or prevents the other.
-// No HTTP context is used — safe for background execution.
+// The notification is written to notification_outbox (one row per channel) and delivered by the
+// dispatcher worker with retries, so an SMTP or Bot API failure in one channel never affects the
+// other and a restart does not lose it. Code that already runs a DB transaction for the event
+// should call repository.EnqueueNotification with that transaction instead.
+// New notifications should use a template (NotifyUserTemplate) rather than hand-built HTML.
+// NotifyUser ignores per-event preferences — user-facing events go through NotifyUserEvent.
 func NotifyUser(userID int, subject string, htmlMsg string) {
-	pref := repository.GetNotificationPref(userID)
-	log.Printf("[NOTIFY-START] UserID: %d, Subject: %q, Pref: %q", userID, subject, pref)
-
-	sendEmail := pref == "email" || pref == "both"
-	sendTG := pref == "telegram" || pref == "both"
-
-	// Fallback: if pref is empty or unexpected, send to both channels
-	if pref == "" || (!sendEmail && !sendTG) {
-		log.Printf("[NOTIFY] ⚠️ User %d has unexpected pref=%q — falling back to 'both'", userID, pref)
-		sendEmail = true
-		sendTG = true
-	}
-
-	// ── Email channel — fully independent goroutine ──
-	if sendEmail {
-		go func(uid int, subj, body string) {
-			defer func() {
-				if r := recover(); r != nil {
-					log.Printf("[NOTIFY-PANIC] Email goroutine panic for user %d: %v", uid, r)
-				}
-			}()
-
-			user, err := repository.GetUserByID(uid)
-			if err != nil {
-				log.Printf("[NOTIFY-FAIL] Email: cannot fetch user %d from DB: %v", uid, err)
-				return
-			}
-			if user.Email == "" {
-				log.Printf("[NOTIFY-FAIL] Email: user %d has empty email — skipping", uid)
-				return
-			}
-
-			log.Printf("[NOTIFY] 📧 Sending email to user %d (%s), subject=%q", uid, user.Email, subj)
-			if err := SendGenericEmail(user.Email, subj, body); err != nil {
-				log.Printf("[NOTIFY-FAIL] Email to user %d (%s) failed: %v", uid, user.Email, err)
-			} else {
-				log.Printf("[NOTIFY-SUCCESS] Sent to user %d (%s) via EMAIL", uid, user.Email)
-			}
-		}(userID, subject, htmlMsg)
-	}
-
-	// ── Telegram channel — fully independent goroutine ──
-	if sendTG {
-		go func(uid int, body string) {
-			defer func() {
-				if r := recover(); r != nil {
-					log.Printf("[NOTIFY-PANIC] Telegram goroutine panic for user %d: %v", uid, r)
-				}
-			}()
-
-			user, err := repository.GetUserByID(uid)
-			if err != nil {
-				log.Printf("[NOTIFY-FAIL] Telegram: cannot fetch user %d from DB: %v", uid, err)
-				return
-			}
-
-			tgValid := user.TelegramChatID.Valid
-			tgID := user.TelegramChatID.Int64
-
-			if !tgValid || tgID == 0 {
-				log.Printf("[NOTIFY-FAIL] Telegram: user %d has no linked TG (valid=%v, id=%d) — skipping", uid, tgValid, tgID)
-				return
-			}
+	enqueueOrSend(domain.UserNotification{UserID: userID, Subject: subject, EmailHTML: htmlMsg})
+}
 
-			log.Printf("[NOTIFY] 📱 Sending TG to user %d (chat_id=%d)", uid, tgID)
-			if err := telegram.SendMessageHTMLSafe(tgID, body); err != nil {
-				log.Printf("[NOTIFY-FAIL] Telegram to user %d (chat_id=%d) failed: %v", uid, tgID, err)
-			} else {
-				log.Printf("[NOTIFY-SUCCESS] Sent to user %d (chat_id=%d) via TELEGRAM", uid, tgID)
-			}
-		}(userID, htmlMsg)
-	}
+// NotifyUserEvent sends a notification about event (domain.NotificationEvent*): the channels come
+// from the user's preference for that event type instead of the global notification_pref.
+func NotifyUserEvent(userID int, event string, subject string, htmlMsg string) {
+	enqueueOrSend(domain.UserNotification{UserID: userID, Event: event, Subject: subject, EmailHTML: htmlMsg})
+}
 
-	log.Printf("[NOTIFY-END] Dispatched notifications for user %d (email=%v, tg=%v)", userID, sendEmail, sendTG)
+// NotifyUserOperation is NotifyUserEvent for a money operation: if amount is below the user's
+// digest threshold for the event, the notification is held for the daily digest.
+func NotifyUserOperation(userID int, event string, amount decimal.Decimal, subject string, htmlMsg string) {
+	enqueueOrSend(domain.UserNotification{
+		UserID:    userID,
+		Event:     event,
+		Amount:    amount.Abs(),
+		Subject:   subject,
+		EmailHTML: htmlMsg,
+	})
 }
 
 // NotifyUserNews sends a news notification with image-first layout.
@@ -96,67 +53,149 @@ func NotifyUser(userID int, subject string, htmlMsg string) {
 // emailBody is the HTML content for the email (image is prepended automatically).
 // imageURL is the direct link to the news image.
 func NotifyUserNews(userID int, subject string, tgCaption string, emailBody string, imageURL string) {
-	pref := repository.GetNotificationPref(userID)
-	log.Printf("[NOTIFY-NEWS] UserID: %d, Subject: %q, Pref: %q, HasImage: %v", userID, subject, pref, imageURL != "")
-
-	sendEmail := pref == "email" || pref == "both"
-	sendTG := pref == "telegram" || pref == "both"
+	NotifyUserEventWithImage(userID, domain.NotificationEventNews, subject, tgCaption, emailBody, imageURL)
+}
 
-	if pref == "" || (!sendEmail && !sendTG) {
-		sendEmail = true
-		sendTG = true
+// NotifyUserEventWithImage is NotifyUserEvent with the image-first layout of NotifyUserNews.
+func NotifyUserEventWithImage(userID int, event string, subject string, tgCaption string, emailBody string, imageURL string) {
+	// Build email body: clickable image first, then text
+	fullBody := ""
+	if imageURL != "" {
+		fullBody += `<div style="text-align:center;margin:0 0 24px;"><a href="https://xplr.pro/news"><img src="` + imageURL + `" alt="" style="max-width:100%;height:auto;border-radius:12px;border:1px solid rgba(255,255,255,0.06);" /></a></div>`
 	}
+	fullBody += emailBody
+
+	enqueueOrSend(domain.UserNotification{
+		UserID:       userID,
+		Event:        event,
+		Subject:      subject,
+		EmailHTML:    fullBody,
+		TelegramHTML: tgCaption,
+		ImageURL:     imageURL,
+	})
+}
 
-	// ── Email channel — image first ──
-	if sendEmail {
-		go func(uid int, subj, body, imgURL string) {
-			defer func() {
-				if r := recover(); r != nil {
-					log.Printf("[NOTIFY-PANIC] Email goroutine panic for user %d: %v", uid, r)
-				}
-			}()
+// NotifyUserTemplate sends a templated notification: the text of templateID (see domain.Notify*)
+// is rendered with data for each channel in the user's language when it is delivered.
+func NotifyUserTemplate(userID int, templateID string, data map[string]string) {
+	enqueueOrSend(domain.UserNotification{UserID: userID, Template: templateID, Data: data})
+}
 
-			user, err := repository.GetUserByID(uid)
-			if err != nil || user.Email == "" {
-				return
-			}
+// NotifyUserOperationTemplate is NotifyUserTemplate for a money operation: below the user's digest
+// threshold for the template's event the notification is held for the daily digest.
+func NotifyUserOperationTemplate(userID int, templateID string, amount decimal.Decimal, data map[string]string) {
+	enqueueOrSend(domain.UserNotification{UserID: userID, Template: templateID, Amount: amount.Abs(), Data: data})
+}
 
-			// Build email body: clickable image first, then text
-			fullBody := ""
-			if imgURL != "" {
-				fullBody += `<div style="text-align:center;margin:0 0 24px;"><a href="https://xplr.pro/news"><img src="` + imgURL + `" alt="" style="max-width:100%;height:auto;border-radius:12px;border:1px solid rgba(255,255,255,0.06);" /></a></div>`
-			}
-			fullBody += body
+// enqueueOrSend ставит уведомление в очередь; если БД недоступна — отправляет сразу,
+// как раньше, каждый канал в своей горутине (без повторов), с учётом настроек события.
+func enqueueOrSend(n domain.UserNotification) {
+	label := n.Subject
+	if n.Template != "" {
+		label = n.Template
+	}
+	err := repository.EnqueueUserNotification(n)
+	if err == nil {
+		log.Printf("[NOTIFY] Queued %q for user %d", label, n.UserID)
+		return
+	}
+	log.Printf("[NOTIFY] ⚠️ Failed to queue %q for user %d: %v — sending directly", label, n.UserID, err)
 
-			if err := SendGenericEmail(user.Email, subj, fullBody); err != nil {
-				log.Printf("[NOTIFY-FAIL] Email to user %d failed: %v", uid, err)
-			}
-		}(userID, subject, emailBody, imageURL)
+	for _, ch := range NotificationChannels() {
+		if directSendAllowed(n, ch.Name()) {
+			go sendDirect(ch, n)
+		}
 	}
+}
 
-	// ── Telegram channel — sendPhoto with caption ──
-	if sendTG {
-		go func(uid int, caption, imgURL string) {
-			defer func() {
-				if r := recover(); r != nil {
-					log.Printf("[NOTIFY-PANIC] Telegram goroutine panic for user %d: %v", uid, r)
-				}
-			}()
+// directSendAllowed — отправлять ли уведомление в канал в обход очереди. Для события действуют
+// те же настройки, что и в очереди (включая отключение события); без события или если настройки
+// не прочитать — глобальный notification_pref.
+func directSendAllowed(n domain.UserNotification, channel string) bool {
+	event := n.Event
+	if event == "" {
+		event = domain.NotificationTemplateEvents[n.Template]
+	}
+	if event != "" {
+		allowed, err := repository.NotificationChannelAllowed(n.UserID, event, channel)
+		if err == nil {
+			return allowed
+		}
+		log.Printf("[NOTIFY] ⚠️ Could not check %s preferences of user %d: %v — using notification_pref", event, n.UserID, err)
+	}
+	// 'both', пустое или неизвестное значение — во все каналы
+	pref := repository.GetNotificationPref(n.UserID)
+	return pref == channel || (pref != domain.NotificationChannelEmail && pref != domain.NotificationChannelTelegram)
+}
 
-			user, err := repository.GetUserByID(uid)
-			if err != nil {
-				return
-			}
-			if !user.TelegramChatID.Valid || user.TelegramChatID.Int64 == 0 {
-				return
-			}
+// sendDirect отправляет уведомление в канал в обход очереди.
+func sendDirect(ch Channel, n domain.UserNotification) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[NOTIFY-PANIC] %s goroutine panic for user %d: %v", ch.Name(), n.UserID, r)
+		}
+	}()
+	msg := domain.OutboxNotification{
+		UserID:   n.UserID,
+		Channel:  ch.Name(),
+		Template: n.Template,
+		Data:     n.Data,
+		Subject:  n.Subject,
+		Body:     n.EmailHTML,
+	}
+	if ch.Name() == domain.NotificationChannelTelegram {
+		msg.ImageURL = n.ImageURL
+		if n.TelegramHTML != "" {
+			msg.Body = n.TelegramHTML
+		}
+	}
+	if err := PrepareNotification(ch, &msg); err != nil {
+		log.Printf("[NOTIFY-FAIL] %s to user %d: %v", ch.Name(), n.UserID, err)
+		return
+	}
+	if _, err := ch.Send(msg); err != nil {
+		log.Printf("[NOTIFY-FAIL] %s to user %d failed: %v", ch.Name(), n.UserID, err)
+	}
+}
 
-			tgID := user.TelegramChatID.Int64
-			if err := telegram.SendPhotoWithCaption(tgID, imgURL, caption); err != nil {
-				log.Printf("[NOTIFY-FAIL] Telegram photo to user %d (chat_id=%d) failed: %v", uid, tgID, err)
-			}
-		}(userID, tgCaption, imageURL)
+// SendEmailNotification отправляет уведомление на email пользователя и возвращает адрес.
+func SendEmailNotification(userID int, subject, htmlMsg string) (string, error) {
+	user, err := repository.GetUserByID(userID)
+	if err != nil {
+		return "", fmt.Errorf("cannot fetch user %d: %w", userID, err)
+	}
+	if user.Email == "" {
+		return "", ErrNotificationRecipientMissing
+	}
+	if err := SendGenericEmail(user.Email, subject, htmlMsg); err != nil {
+		return user.Email, err
+	}
+	log.Printf("[NOTIFY-SUCCESS] Sent to user %d (%s) via EMAIL", userID, user.Email)
+	return user.Email, nil
+}
+
+// SendTelegramNotification отправляет уведомление в Telegram пользователя (с фото, если imageURL задан)
+// и возвращает chat_id.
+func SendTelegramNotification(userID int, htmlMsg, imageURL string) (string, error) {
+	user, err := repository.GetUserByID(userID)
+	if err != nil {
+		return "", fmt.Errorf("cannot fetch user %d: %w", userID, err)
+	}
+	if !user.TelegramChatID.Valid || user.TelegramChatID.Int64 == 0 {
+		return "", ErrNotificationRecipientMissing
+	}
+	tgID := user.TelegramChatID.Int64
+	recipient := strconv.FormatInt(tgID, 10)
+	if imageURL != "" {
+		err = telegram.SendPhotoWithCaption(tgID, imageURL, htmlMsg)
+	} else {
+		err = telegram.SendMessageHTMLSafe(tgID, htmlMsg)
+	}
+	if err != nil {
+		return recipient, err
 	}
+	log.Printf("[NOTIFY-SUCCESS] Sent to user %d (chat_id=%d) via TELEGRAM", userID, tgID)
+	return recipient, nil
 }
 
 // NotifyAdmins sends a notification to all users with is_admin=true in the database.
@@ -2,44 +2,193 @@ package service
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
 	"os"
+	"strings"
+	"sync"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/repository"
+	"github.com/shopspring/decimal"
+)
+
+// Имена провайдеров — значения CARD_PROVIDER, cards.provider и card_provider_routes.provider
+const (
+	ProviderMock      = "mock"
+	ProviderArmenia   = "armenia"
+	ProviderWallester = "wallester"
 )
 
-var globalProvider CardProvider
+var (
+	providersMu         sync.RWMutex
+	providers           = map[string]CardProvider{}
+	defaultProviderName = ProviderMock
+)
 
-// InitCardProvider инициализирует глобальный провайдер карт
-// Выбирает между MockProvider и ArmeniaProvider в зависимости от конфигурации
+// InitCardProvider регистрирует всех настроенных провайдеров карт.
+// MockProvider доступен всегда; ArmeniaProvider и WallesterProvider — если заданы их ключи.
+// Провайдер по умолчанию (CARD_PROVIDER) используется, когда ни одно правило маршрутизации не подошло.
 func InitCardProvider(db *sql.DB) {
-	providerType := os.Getenv("CARD_PROVIDER") // "mock" или "armenia"
-	
-	if providerType == "armenia" {
-		apiKey := os.Getenv("ARMENIA_API_KEY")
-		apiBaseURL := os.Getenv("ARMENIA_API_URL")
-		
-		if apiKey == "" || apiBaseURL == "" {
-			log.Println("⚠️  [PROVIDER] ARMENIA_API_KEY or ARMENIA_API_URL not set, falling back to MockProvider")
-			globalProvider = NewMockProvider(db)
-		} else {
-			globalProvider = NewArmeniaProvider(db, apiKey, apiBaseURL)
-			log.Printf("✅ [PROVIDER] Initialized ArmeniaProvider (API: %s)", apiBaseURL)
+	RegisterCardProvider(ProviderMock, NewMockProvider(db))
+	log.Println("✅ [PROVIDER] Registered MockProvider (using XPLR database)")
+
+	if apiKey, apiBaseURL := os.Getenv("ARMENIA_API_KEY"), os.Getenv("ARMENIA_API_URL"); apiKey != "" && apiBaseURL != "" {
+		RegisterCardProvider(ProviderArmenia, NewArmeniaProvider(db, apiKey, apiBaseURL))
+		log.Printf("✅ [PROVIDER] Registered ArmeniaProvider (API: %s)", apiBaseURL)
+	}
+
+	if apiKey := os.Getenv("WALLESTER_API_KEY"); apiKey != "" {
+		apiURL := os.Getenv("WALLESTER_API_URL")
+		if apiURL == "" {
+			apiURL = "https://api.wallester.com/v1"
 		}
+		RegisterCardProvider(ProviderWallester, NewWallesterProvider(db, apiKey, apiURL))
+		log.Printf("✅ [PROVIDER] Registered WallesterProvider (API: %s)", apiURL)
+	}
+
+	name := strings.ToLower(os.Getenv("CARD_PROVIDER")) // "mock", "armenia" или "wallester"
+	if name == "" {
+		name = ProviderMock
+	}
+	providersMu.Lock()
+	if _, ok := providers[name]; ok {
+		defaultProviderName = name
 	} else {
-		// По умолчанию используем MockProvider
-		globalProvider = NewMockProvider(db)
-		log.Println("✅ [PROVIDER] Initialized MockProvider (using XPLR database)")
+		log.Printf("⚠️  [PROVIDER] CARD_PROVIDER=%s is not configured, falling back to MockProvider", name)
+		defaultProviderName = ProviderMock
+	}
+	providersMu.Unlock()
+	log.Printf("✅ [PROVIDER] Default card provider: %s", DefaultCardProviderName())
+}
+
+// RegisterCardProvider добавляет (или заменяет) провайдера под именем name
+func RegisterCardProvider(name string, provider CardProvider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[name] = provider
+}
+
+// CardProviderNames возвращает имена зарегистрированных провайдеров
+func CardProviderNames() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
 	}
+	return names
+}
+
+// DefaultCardProviderName возвращает имя провайдера по умолчанию
+func DefaultCardProviderName() string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	return defaultProviderName
 }
 
-// GetCardProvider возвращает глобальный экземпляр провайдера
+// GetCardProvider возвращает провайдера по умолчанию
 func GetCardProvider() CardProvider {
-	if globalProvider == nil {
+	providersMu.RLock()
+	provider := providers[defaultProviderName]
+	providersMu.RUnlock()
+	if provider == nil {
 		log.Fatal("🚨 [FATAL] Card provider not initialized! Call InitCardProvider first.")
 	}
-	return globalProvider
+	return provider
+}
+
+// GetCardProviderByName возвращает зарегистрированного провайдера; пустое имя — провайдер по умолчанию
+func GetCardProviderByName(name string) (CardProvider, error) {
+	if name == "" {
+		name = DefaultCardProviderName()
+	}
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	provider, ok := providers[name]
+	if !ok {
+		return nil, &ProviderError{
+			Provider: name,
+			Code:     "PROVIDER_NOT_CONFIGURED",
+			Message:  fmt.Sprintf("Card provider %q is not configured", name),
+		}
+	}
+	return provider, nil
 }
 
-// SetCardProvider устанавливает провайдер (для тестирования)
+// SetCardProvider устанавливает провайдера по умолчанию (для тестирования)
 func SetCardProvider(provider CardProvider) {
-	globalProvider = provider
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[defaultProviderName] = provider
+}
+
+// CardProviderForCard возвращает эмитента, выпустившего карту (cards.provider)
+func CardProviderForCard(cardID int) (CardProvider, error) {
+	name, err := repository.GetCardProviderName(cardID)
+	if err != nil {
+		return nil, err
+	}
+	return GetCardProviderByName(name)
+}
+
+// CardFundFunc возвращает функцию пополнения карты у её эмитента для repository.TransferWalletToCard
+func CardFundFunc(cardID int) repository.CardFundFunc {
+	return func(amount decimal.Decimal, currency string) error {
+		provider, err := CardProviderForCard(cardID)
+		if err != nil {
+			return err
+		}
+		return provider.TopUpCard(cardID, amount.InexactFloat64(), currency)
+	}
+}
+
+// routeMatches — совпадает ли значение правила ('*' или пустое — любое) со значением карты
+func routeMatches(ruleValue, value string) bool {
+	return ruleValue == "" || ruleValue == "*" || strings.EqualFold(ruleValue, value)
+}
+
+// MatchCardProviderRoute выбирает провайдера для выпуска по правилам маршрутизации.
+// Побеждает самое точное правило (больше полей без '*'), при равенстве — больший Priority, затем меньший ID.
+func MatchCardProviderRoute(routes []domain.CardProviderRoute, category, currency, cardType string) (string, bool) {
+	best := -1
+	bestScore := -1
+	for i, rt := range routes {
+		if !rt.IsActive || !routeMatches(rt.Category, category) || !routeMatches(rt.Currency, currency) || !routeMatches(rt.CardType, cardType) {
+			continue
+		}
+		score := 0
+		for _, v := range []string{rt.Category, rt.Currency, rt.CardType} {
+			if v != "" && v != "*" {
+				score++
+			}
+		}
+		if best < 0 || score > bestScore ||
+			(score == bestScore && (rt.Priority > routes[best].Priority ||
+				(rt.Priority == routes[best].Priority && rt.ID < routes[best].ID))) {
+			best, bestScore = i, score
+		}
+	}
+	if best < 0 {
+		return "", false
+	}
+	return routes[best].Provider, true
+}
+
+// RouteCardIssue выбирает эмитента для выпуска карты по таблице card_provider_routes.
+// Возвращает имя провайдера (сохраняется в cards.provider) и сам провайдер.
+func RouteCardIssue(category, currency, cardType string) (string, CardProvider, error) {
+	routes, err := repository.ListCardProviderRoutes(true)
+	if err != nil {
+		return "", nil, err
+	}
+	name, ok := MatchCardProviderRoute(routes, category, currency, cardType)
+	if !ok {
+		name = DefaultCardProviderName()
+	}
+	provider, err := GetCardProviderByName(name)
+	if err != nil {
+		return "", nil, err
+	}
+	return name, provider, nil
 }
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/djalben/xplr-core/backend/domain"
+)
+
+func TestMatchCardProviderRoute(t *testing.T) {
+	routes := []domain.CardProviderRoute{
+		{ID: 1, Category: "*", Currency: "*", CardType: "*", Provider: "mock", IsActive: true},
+		{ID: 2, Category: "travel", Currency: "*", CardType: "*", Provider: "armenia", IsActive: true},
+		{ID: 3, Category: "*", Currency: "EUR", CardType: "*", Provider: "wallester", IsActive: true},
+		{ID: 4, Category: "travel", Currency: "EUR", CardType: "*", Provider: "wallester", IsActive: true},
+		{ID: 5, Category: "arbitrage", Currency: "*", CardType: "*", Provider: "armenia", Priority: 10, IsActive: true},
+		{ID: 6, Category: "arbitrage", Currency: "*", CardType: "*", Provider: "wallester", IsActive: true},
+		{ID: 7, Category: "services", Currency: "*", CardType: "*", Provider: "armenia", IsActive: false},
+	}
+
+	cases := []struct {
+		name                         string
+		category, currency, cardType string
+		want                         string
+	}{
+		{"только общее правило", "services", "USD", "VISA", "mock"},
+		{"категория", "travel", "USD", "VISA", "armenia"},
+		{"валюта", "services", "eur", "VISA", "wallester"},
+		{"категория и валюта точнее", "travel", "EUR", "MasterCard", "wallester"},
+		{"при равной точности — приоритет", "arbitrage", "USD", "VISA", "armenia"},
+	}
+	for _, c := range cases {
+		got, ok := MatchCardProviderRoute(routes, c.category, c.currency, c.cardType)
+		if !ok || got != c.want {
+			t.Errorf("%s: got %q (ok=%v), want %q", c.name, got, ok, c.want)
+		}
+	}
+
+	if _, ok := MatchCardProviderRoute(routes[6:], "services", "USD", "VISA"); ok {
+		t.Error("выключенное правило не должно срабатывать")
+	}
+	if _, ok := MatchCardProviderRoute(nil, "travel", "USD", "VISA"); ok {
+		t.Error("без правил маршрут не выбирается")
+	}
+}
@@ -0,0 +1,244 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// RateSnapshot — курсы одного источника: сколько единиц валюты за 1 единицу Base.
+type RateSnapshot struct {
+	Source string
+	Base   string
+	Date   string
+	Rates  map[string]decimal.Decimal
+}
+
+// rate возвращает курс валюты в снимке (для Base — 1).
+func (s *RateSnapshot) rate(currency string) (decimal.Decimal, bool) {
+	if currency == s.Base {
+		return decimal.NewFromInt(1), true
+	}
+	r, ok := s.Rates[currency]
+	return r, ok && r.IsPositive()
+}
+
+// RateSource — внешний источник курсов валют.
+type RateSource interface {
+	Name() string
+	Fetch() (*RateSnapshot, error)
+}
+
+// DefaultRateSources — источники, которые опрашивает StartExchangeRateFetcher.
+func DefaultRateSources() []RateSource {
+	return []RateSource{
+		&CBRRateSource{URL: "https://www.cbr.ru/scripts/XML_daily.asp"},
+		&ECBRateSource{URL: "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-daily.xml"},
+		&JSONRateSource{SourceName: "open.er-api", URL: "https://open.er-api.com/v6/latest/USD", RatesKey: "rates"},
+	}
+}
+
+// fetchRateBody скачивает ответ источника курсов (не больше 2 МБ).
+func fetchRateBody(url string) ([]byte, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API returned status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 2<<20))
+}
+
+// parseRateValue разбирает число курса; ЦБ РФ пишет дробную часть через запятую.
+func parseRateValue(s string) (decimal.Decimal, error) {
+	return decimal.NewFromString(strings.ReplaceAll(strings.TrimSpace(s), ",", "."))
+}
+
+// ── ЦБ РФ ──
+
+// CBRRateSource — ежедневный XML ЦБ РФ (рублей за Nominal единиц валюты).
+type CBRRateSource struct {
+	URL string
+}
+
+func (s *CBRRateSource) Name() string { return "cbr" }
+
+func (s *CBRRateSource) Fetch() (*RateSnapshot, error) {
+	body, err := fetchRateBody(s.URL)
+	if err != nil {
+		return nil, err
+	}
+	return parseCBRXML(body)
+}
+
+// parseCBRXML разбирает XML_daily.asp (кодировка windows-1251).
+func parseCBRXML(body []byte) (*RateSnapshot, error) {
+	var doc struct {
+		Date    string `xml:"Date,attr"`
+		Valutes []struct {
+			CharCode string `xml:"CharCode"`
+			Nominal  string `xml:"Nominal"`
+			Value    string `xml:"Value"`
+		} `xml:"Valute"`
+	}
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	dec.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		if strings.EqualFold(charset, "windows-1251") {
+			return charmap.Windows1251.NewDecoder().Reader(input), nil
+		}
+		return nil, fmt.Errorf("unsupported charset %q", charset)
+	}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("CBR XML decode error: %v", err)
+	}
+
+	snap := &RateSnapshot{Source: "cbr", Base: "RUB", Date: doc.Date, Rates: map[string]decimal.Decimal{}}
+	for _, v := range doc.Valutes {
+		value, err := parseRateValue(v.Value)
+		if err != nil || !value.IsPositive() {
+			continue
+		}
+		nominal, err := parseRateValue(v.Nominal)
+		if err != nil || !nominal.IsPositive() {
+			nominal = decimal.NewFromInt(1)
+		}
+		// Value рублей за Nominal единиц → единиц валюты за 1 рубль
+		snap.Rates[strings.ToUpper(strings.TrimSpace(v.CharCode))] = nominal.DivRound(value, 12)
+	}
+	if len(snap.Rates) == 0 {
+		return nil, fmt.Errorf("CBR XML has no rates")
+	}
+	return snap, nil
+}
+
+// ── ЕЦБ ──
+
+// ECBRateSource — ежедневный XML ЕЦБ (единиц валюты за 1 EUR). Рубль ЕЦБ не публикует,
+// поэтому рублёвые курсы из него выводятся через агрегированный RUB/USD.
+type ECBRateSource struct {
+	URL string
+}
+
+func (s *ECBRateSource) Name() string { return "ecb" }
+
+func (s *ECBRateSource) Fetch() (*RateSnapshot, error) {
+	body, err := fetchRateBody(s.URL)
+	if err != nil {
+		return nil, err
+	}
+	return parseECBXML(body)
+}
+
+// parseECBXML разбирает eurofxref-daily.xml; берётся самый свежий день.
+func parseECBXML(body []byte) (*RateSnapshot, error) {
+	var doc struct {
+		Cube struct {
+			Days []struct {
+				Time  string `xml:"time,attr"`
+				Rates []struct {
+					Currency string `xml:"currency,attr"`
+					Rate     string `xml:"rate,attr"`
+				} `xml:"Cube"`
+			} `xml:"Cube"`
+		} `xml:"Cube"`
+	}
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("ECB XML decode error: %v", err)
+	}
+	if len(doc.Cube.Days) == 0 {
+		return nil, fmt.Errorf("ECB XML has no rates")
+	}
+
+	day := doc.Cube.Days[0]
+	snap := &RateSnapshot{Source: "ecb", Base: "EUR", Date: day.Time, Rates: map[string]decimal.Decimal{}}
+	for _, r := range day.Rates {
+		rate, err := parseRateValue(r.Rate)
+		if err != nil || !rate.IsPositive() {
+			continue
+		}
+		snap.Rates[strings.ToUpper(strings.TrimSpace(r.Currency))] = rate
+	}
+	if len(snap.Rates) == 0 {
+		return nil, fmt.Errorf("ECB XML has no rates")
+	}
+	return snap, nil
+}
+
+// ── Произвольный JSON ──
+
+// JSONRateSource — JSON-эндпоинт вида {"base_code": "USD", "rates": {"RUB": 96.5, ...}}.
+// RatesKey — поле с курсами; Base задаёт базовую валюту, если её нет в ответе (base_code / base).
+type JSONRateSource struct {
+	SourceName string
+	URL        string
+	RatesKey   string
+	Base       string
+}
+
+func (s *JSONRateSource) Name() string { return s.SourceName }
+
+func (s *JSONRateSource) Fetch() (*RateSnapshot, error) {
+	body, err := fetchRateBody(s.URL)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := parseJSONRates(body, s.RatesKey, s.Base)
+	if err != nil {
+		return nil, err
+	}
+	snap.Source = s.SourceName
+	return snap, nil
+}
+
+// parseJSONRates разбирает ответ JSON-источника. Поле "result" (если есть) должно быть "success".
+func parseJSONRates(body []byte, ratesKey, base string) (*RateSnapshot, error) {
+	if ratesKey == "" {
+		ratesKey = "rates"
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("JSON decode error: %v", err)
+	}
+	if raw, ok := doc["result"]; ok {
+		var result string
+		if json.Unmarshal(raw, &result) == nil && result != "success" {
+			return nil, fmt.Errorf("API result: %s", result)
+		}
+	}
+	for _, key := range []string{"base_code", "base"} {
+		if raw, ok := doc[key]; ok && base == "" {
+			json.Unmarshal(raw, &base)
+		}
+	}
+	if base == "" {
+		return nil, fmt.Errorf("JSON rates have no base currency")
+	}
+
+	var rates map[string]json.Number
+	if err := json.Unmarshal(doc[ratesKey], &rates); err != nil {
+		return nil, fmt.Errorf("JSON field %q is not a rate map: %v", ratesKey, err)
+	}
+	snap := &RateSnapshot{Base: strings.ToUpper(base), Rates: map[string]decimal.Decimal{}}
+	for currency, n := range rates {
+		rate, err := parseRateValue(n.String())
+		if err != nil || !rate.IsPositive() {
+			continue
+		}
+		snap.Rates[strings.ToUpper(currency)] = rate
+	}
+	if len(snap.Rates) == 0 {
+		return nil, fmt.Errorf("JSON rates are empty")
+	}
+	return snap, nil
+}
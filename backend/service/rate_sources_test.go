@@ -0,0 +1,108 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func loadRateFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("fixture %s: %v", name, err)
+	}
+	return body
+}
+
+func TestParseRateSources(t *testing.T) {
+	cbr, err := parseCBRXML(loadRateFixture(t, "cbr_daily.xml"))
+	if err != nil {
+		t.Fatalf("parseCBRXML: %v", err)
+	}
+	if cbr.Base != "RUB" || cbr.Date != "16.10.2026" {
+		t.Errorf("CBR: base=%s date=%s", cbr.Base, cbr.Date)
+	}
+	// Nominal учитывается: 100 AMD = 24,80 ₽ → 4.0323 AMD за 1 ₽
+	if got := cbr.Rates["AMD"].Round(4); !got.Equal(decimal.RequireFromString("4.0323")) {
+		t.Errorf("CBR AMD per RUB = %s", got)
+	}
+
+	ecb, err := parseECBXML(loadRateFixture(t, "ecb_daily.xml"))
+	if err != nil {
+		t.Fatalf("parseECBXML: %v", err)
+	}
+	if ecb.Base != "EUR" || ecb.Date != "2026-10-16" || !ecb.Rates["USD"].Equal(decimal.RequireFromString("1.08")) {
+		t.Errorf("ECB: base=%s date=%s USD=%s", ecb.Base, ecb.Date, ecb.Rates["USD"])
+	}
+
+	js, err := parseJSONRates(loadRateFixture(t, "er_api_latest.json"), "rates", "")
+	if err != nil {
+		t.Fatalf("parseJSONRates: %v", err)
+	}
+	if js.Base != "USD" || !js.Rates["RUB"].Equal(decimal.RequireFromString("96.9")) {
+		t.Errorf("JSON: base=%s RUB=%s", js.Base, js.Rates["RUB"])
+	}
+
+	if _, err := parseJSONRates(loadRateFixture(t, "er_api_error.json"), "rates", ""); err == nil {
+		t.Error("JSON с result=error должен возвращать ошибку")
+	}
+}
+
+func TestAggregateRatesMedian(t *testing.T) {
+	var snapshots []*RateSnapshot
+	for _, f := range []struct {
+		name  string
+		parse func([]byte) (*RateSnapshot, error)
+	}{
+		{"cbr_daily.xml", parseCBRXML},
+		{"ecb_daily.xml", parseECBXML},
+		{"er_api_latest.json", func(b []byte) (*RateSnapshot, error) { return parseJSONRates(b, "rates", "") }},
+	} {
+		s, err := f.parse(loadRateFixture(t, f.name))
+		if err != nil {
+			t.Fatalf("%s: %v", f.name, err)
+		}
+		snapshots = append(snapshots, s)
+	}
+
+	got := aggregateRates(snapshots, []string{"USD", "EUR", "USDT"})
+	want := map[string]string{
+		"USD":  "96.7",    // медиана ЦБ 96.5 и JSON 96.9; ЕЦБ рубля не даёт
+		"EUR":  "104.436", // ЦБ 104.2, JSON 104.6549, ЕЦБ через якорь 96.7 × 1.08
+		"USDT": "96.7",    // привязка к USD
+	}
+	for c, w := range want {
+		if !got[c].Equal(decimal.RequireFromString(w)) {
+			t.Errorf("%s: got %s, want %s", c, got[c], w)
+		}
+	}
+}
+
+func TestDeviationExceeded(t *testing.T) {
+	old := decimal.NewFromInt(100)
+	five := decimal.NewFromInt(5)
+	cases := []struct {
+		name    string
+		newRate string
+		elapsed time.Duration
+		want    bool
+	}{
+		{"в пределах часа", "104.9", 10 * time.Minute, false},
+		{"скачок за час", "106", time.Hour, true},
+		{"скачок вниз", "94", time.Hour, true},
+		{"за 3 часа допустимо 15%", "112", 3 * time.Hour, false},
+		{"больше суток — не больше 120%", "230", 72 * time.Hour, true},
+	}
+	for _, c := range cases {
+		if got := deviationExceeded(old, decimal.RequireFromString(c.newRate), five, c.elapsed); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+	if deviationExceeded(decimal.Zero, old, five, time.Hour) {
+		t.Error("без текущего курса новый курс применяется")
+	}
+}
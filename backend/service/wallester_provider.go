@@ -0,0 +1,205 @@
+package service
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/repository"
+	"github.com/shopspring/decimal"
+)
+
+// WallesterProvider - провайдер карт поверх Wallester API (repository.WallesterRepository)
+// Карта XPLR связана с картой Wallester через cards.external_id / cards.provider_card_id
+type WallesterProvider struct {
+	db     *sql.DB
+	client *repository.WallesterRepository
+
+	// externalID находит ID карты в Wallester по ID карты XPLR (подменяется в тестах)
+	externalID func(cardID int) (string, error)
+}
+
+// NewWallesterProvider создает провайдер Wallester
+func NewWallesterProvider(db *sql.DB, apiKey string, apiURL string) *WallesterProvider {
+	p := &WallesterProvider{
+		db:     db,
+		client: repository.NewWallesterClient(apiKey, apiURL),
+	}
+	p.externalID = p.lookupExternalID
+	return p
+}
+
+// GetProviderName возвращает имя провайдера
+func (p *WallesterProvider) GetProviderName() string {
+	return "WallesterProvider"
+}
+
+// GetCardDetails получает реквизиты и баланс карты из Wallester
+func (p *WallesterProvider) GetCardDetails(cardID int) (*CardDetails, error) {
+	extID, err := p.externalID(cardID)
+	if err != nil {
+		return nil, err
+	}
+
+	details, err := p.client.GetCardDetails(extID)
+	if err != nil {
+		return nil, p.providerError("DETAILS_FAILED", "Failed to get details of card %d: %v", cardID, err)
+	}
+
+	balance, currency, err := p.client.GetBalance(extID)
+	if err != nil {
+		return nil, p.providerError("DETAILS_FAILED", "Failed to get balance of card %d: %v", cardID, err)
+	}
+	if currency == "" {
+		currency = "USD"
+	}
+
+	result := &CardDetails{
+		CardNumber:     details.PAN,
+		CVV:            details.CVV,
+		ExpiryDate:     details.Expiry,
+		HolderName:     "XPLR CARDHOLDER",
+		Balance:        balance.InexactFloat64(),
+		Currency:       currency,
+		Status:         "active",
+		ProviderCardID: extID,
+	}
+	if len(details.PAN) >= 10 {
+		result.BIN = details.PAN[:6]
+		result.Last4 = details.PAN[len(details.PAN)-4:]
+	}
+
+	log.Printf("[WALLESTER-PROVIDER] GetCardDetails: card_id=%d, external_id=%s, balance=%s %s",
+		cardID, extID, balance.StringFixed(2), currency)
+
+	return result, nil
+}
+
+// IssueCard выпускает карту в Wallester (запись карты в БД делает вызывающий код)
+func (p *WallesterProvider) IssueCard(request IssueCardRequest) (*IssuedCard, error) {
+	cardType := request.CardType
+	if cardType == "" {
+		cardType = "VISA"
+	}
+	currency := strings.ToUpper(request.Currency)
+	if currency == "" {
+		currency = "USD"
+	}
+
+	resp, err := p.client.CreateCard(repository.WallesterCardRequest{
+		Currency: currency,
+		CardType: cardType,
+		CardName: request.HolderName,
+		Amount:   "0", // Баланс пополняется отдельно через TopUpCard
+	})
+	if err != nil {
+		return nil, p.providerError("ISSUE_FAILED", "Failed to issue card for user %d: %v", request.UserID, err)
+	}
+
+	status := resp.Status
+	if status == "" {
+		status = "ACTIVE"
+	}
+
+	issued := &IssuedCard{
+		ProviderCardID: resp.CardID,
+		CardNumber:     resp.PAN,
+		Last4:          resp.Last4,
+		BIN:            resp.BIN,
+		Status:         status,
+		CreatedAt:      time.Now(),
+	}
+
+	// CVV и срок действия Wallester отдает только через /details
+	if details, err := p.client.GetCardDetails(resp.CardID); err == nil {
+		issued.CardNumber = details.PAN
+		issued.CVV = details.CVV
+		issued.ExpiryDate = details.Expiry
+	} else {
+		log.Printf("[WALLESTER-PROVIDER] ⚠️ Card %s issued, but details are unavailable: %v", resp.CardID, err)
+	}
+
+	log.Printf("[WALLESTER-PROVIDER] IssueCard: user_id=%d, card_type=%s, currency=%s, external_id=%s, last4=%s",
+		request.UserID, cardType, currency, resp.CardID, resp.Last4)
+
+	return issued, nil
+}
+
+// TopUpCard пополняет карту в Wallester.
+// card_balance в БД XPLR не меняется: его ведет ledger при переводе Кошелек → карта.
+func (p *WallesterProvider) TopUpCard(cardID int, amount float64, currency string) error {
+	if amount <= 0 {
+		return p.providerError("TOPUP_FAILED", "Invalid top-up amount %.2f for card %d", amount, cardID)
+	}
+
+	extID, err := p.externalID(cardID)
+	if err != nil {
+		return err
+	}
+
+	balance, err := p.client.TopUpCard(extID, decimal.NewFromFloat(amount), strings.ToUpper(currency))
+	if err != nil {
+		return p.providerError("TOPUP_FAILED", "Failed to top up card %d: %v", cardID, err)
+	}
+
+	log.Printf("[WALLESTER-PROVIDER] TopUpCard: card_id=%d, amount=%.2f %s, new balance=%s",
+		cardID, amount, currency, balance.StringFixed(2))
+	return nil
+}
+
+// FreezeCard блокирует карту в Wallester
+func (p *WallesterProvider) FreezeCard(cardID int) error {
+	extID, err := p.externalID(cardID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := p.client.BlockCard(extID); err != nil {
+		return p.providerError("FREEZE_FAILED", "Failed to freeze card %d: %v", cardID, err)
+	}
+
+	log.Printf("[WALLESTER-PROVIDER] FreezeCard: card_id=%d, external_id=%s", cardID, extID)
+	return nil
+}
+
+// UnfreezeCard разблокирует карту в Wallester
+func (p *WallesterProvider) UnfreezeCard(cardID int) error {
+	extID, err := p.externalID(cardID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := p.client.UnblockCard(extID); err != nil {
+		return p.providerError("UNFREEZE_FAILED", "Failed to unfreeze card %d: %v", cardID, err)
+	}
+
+	log.Printf("[WALLESTER-PROVIDER] UnfreezeCard: card_id=%d, external_id=%s", cardID, extID)
+	return nil
+}
+
+// lookupExternalID берет ID карты в Wallester из БД (external_id, иначе provider_card_id)
+func (p *WallesterProvider) lookupExternalID(cardID int) (string, error) {
+	var extID string
+	err := p.db.QueryRow(`
+		SELECT COALESCE(NULLIF(external_id, ''), provider_card_id, '')
+		FROM cards
+		WHERE id = $1
+	`, cardID).Scan(&extID)
+	if err == sql.ErrNoRows || (err == nil && extID == "") {
+		return "", p.providerError("CARD_NOT_FOUND", "Card %d is not linked to Wallester", cardID)
+	}
+	if err != nil {
+		return "", err
+	}
+	return extID, nil
+}
+
+func (p *WallesterProvider) providerError(code, format string, args ...interface{}) *ProviderError {
+	return &ProviderError{
+		Provider: "WallesterProvider",
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+	}
+}
@@ -0,0 +1,201 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+// fakeWallester — httptest-сервер с тем же контрактом, что и Wallester API.
+type fakeWallester struct {
+	*httptest.Server
+	mu     sync.Mutex
+	cards  map[string]*fakeWallesterCard
+	nextID int
+}
+
+type fakeWallesterCard struct {
+	PAN      string
+	CVV      string
+	Expiry   string
+	Currency string
+	Balance  decimal.Decimal
+	Status   string
+}
+
+const fakeWallesterKey = "test-key"
+
+func newFakeWallester(t *testing.T) *fakeWallester {
+	f := &fakeWallester{cards: make(map[string]*fakeWallesterCard)}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeWallester) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Header.Get("Authorization") != "Bearer "+fakeWallesterKey {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "unauthorized"})
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if r.Method == http.MethodPost && len(parts) == 1 && parts[0] == "cards" {
+		var req struct {
+			Currency string `json:"currency"`
+			CardType string `json:"card_type"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		f.nextID++
+		id := fmt.Sprintf("wl-%d", f.nextID)
+		pan := fmt.Sprintf("4111110000%06d", f.nextID)
+		f.cards[id] = &fakeWallesterCard{PAN: pan, CVV: "123", Expiry: "12/29", Currency: req.Currency, Status: "ACTIVE"}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true, "card_id": id, "pan": "411111******" + pan[12:],
+			"bin": pan[:6], "last_4": pan[12:], "status": "ACTIVE", "balance": "0",
+		})
+		return
+	}
+	if len(parts) != 3 || parts[0] != "cards" {
+		http.NotFound(w, r)
+		return
+	}
+
+	card, ok := f.cards[parts[1]]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "card not found"})
+		return
+	}
+
+	reply := func(extra map[string]interface{}) {
+		extra["success"] = true
+		json.NewEncoder(w).Encode(extra)
+	}
+	switch r.Method + " " + parts[2] {
+	case "GET details":
+		reply(map[string]interface{}{"pan": card.PAN, "cvv": card.CVV, "expiry": card.Expiry})
+	case "GET balance":
+		reply(map[string]interface{}{"balance": card.Balance.String(), "currency": card.Currency})
+	case "POST top-up":
+		var req struct {
+			Amount   string `json:"amount"`
+			Currency string `json:"currency"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		amount, err := decimal.NewFromString(req.Amount)
+		if err != nil || req.Currency != card.Currency || card.Status != "ACTIVE" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "top-up rejected"})
+			return
+		}
+		card.Balance = card.Balance.Add(amount)
+		reply(map[string]interface{}{"balance": card.Balance.String(), "currency": card.Currency})
+	case "POST block":
+		card.Status = "BLOCKED"
+		reply(map[string]interface{}{"card_id": parts[1], "status": card.Status})
+	case "POST unblock":
+		card.Status = "ACTIVE"
+		reply(map[string]interface{}{"card_id": parts[1], "status": card.Status})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// newTestWallesterProvider связывает карты XPLR с картами фейкового Wallester без БД.
+func newTestWallesterProvider(f *fakeWallester, links map[int]string) *WallesterProvider {
+	p := NewWallesterProvider(nil, fakeWallesterKey, f.URL)
+	p.externalID = func(cardID int) (string, error) {
+		if id, ok := links[cardID]; ok {
+			return id, nil
+		}
+		return "", p.providerError("CARD_NOT_FOUND", "Card %d is not linked to Wallester", cardID)
+	}
+	return p
+}
+
+func TestWallesterProviderLifecycle(t *testing.T) {
+	f := newFakeWallester(t)
+	links := make(map[int]string)
+	p := newTestWallesterProvider(f, links)
+
+	issued, err := p.IssueCard(IssueCardRequest{UserID: 1, CardType: "VISA", Currency: "eur", HolderName: "TEST USER"})
+	if err != nil {
+		t.Fatalf("IssueCard: %v", err)
+	}
+	if issued.ProviderCardID == "" || issued.Last4 == "" || issued.BIN != "411111" {
+		t.Fatalf("IssueCard вернул неполную карту: %+v", issued)
+	}
+	if issued.CVV != "123" || issued.ExpiryDate != "12/29" || len(issued.CardNumber) != 16 {
+		t.Fatalf("IssueCard не подтянул реквизиты: %+v", issued)
+	}
+	links[10] = issued.ProviderCardID
+
+	if err := p.TopUpCard(10, 25.50, "EUR"); err != nil {
+		t.Fatalf("TopUpCard: %v", err)
+	}
+	details, err := p.GetCardDetails(10)
+	if err != nil {
+		t.Fatalf("GetCardDetails: %v", err)
+	}
+	if details.Balance != 25.50 || details.Currency != "EUR" || details.Last4 != issued.Last4 {
+		t.Fatalf("GetCardDetails = %+v", details)
+	}
+
+	if err := p.FreezeCard(10); err != nil {
+		t.Fatalf("FreezeCard: %v", err)
+	}
+	if got := f.cards[issued.ProviderCardID].Status; got != "BLOCKED" {
+		t.Fatalf("после FreezeCard статус = %s", got)
+	}
+	if err := p.TopUpCard(10, 5, "EUR"); err == nil {
+		t.Fatal("пополнение замороженной карты должно отклоняться")
+	}
+
+	if err := p.UnfreezeCard(10); err != nil {
+		t.Fatalf("UnfreezeCard: %v", err)
+	}
+	if err := p.TopUpCard(10, 5, "EUR"); err != nil {
+		t.Fatalf("TopUpCard после разморозки: %v", err)
+	}
+	if got := f.cards[issued.ProviderCardID].Balance; !got.Equal(decimal.RequireFromString("30.5")) {
+		t.Fatalf("баланс = %s, want 30.5", got)
+	}
+}
+
+func TestWallesterProviderErrors(t *testing.T) {
+	f := newFakeWallester(t)
+	p := newTestWallesterProvider(f, map[int]string{7: "wl-missing"})
+
+	cases := []struct {
+		name string
+		err  error
+		code string
+	}{
+		{"карта не привязана", p.FreezeCard(99), "CARD_NOT_FOUND"},
+		{"карты нет в Wallester", p.UnfreezeCard(7), "UNFREEZE_FAILED"},
+		{"нулевая сумма", p.TopUpCard(7, 0, "USD"), "TOPUP_FAILED"},
+	}
+	for _, c := range cases {
+		var pe *ProviderError
+		if !errors.As(c.err, &pe) || pe.Code != c.code {
+			t.Errorf("%s: err = %v, want code %s", c.name, c.err, c.code)
+		}
+	}
+
+	bad := NewWallesterProvider(nil, "wrong-key", f.URL)
+	if _, err := bad.IssueCard(IssueCardRequest{UserID: 1}); err == nil {
+		t.Error("IssueCard с неверным ключом должен вернуть ошибку")
+	}
+}
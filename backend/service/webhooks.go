@@ -0,0 +1,17 @@
+package service
+
+import (
+	"log"
+
+	"github.com/djalben/xplr-core/backend/repository"
+)
+
+// EmitWebhookEvent queues event (domain.Webhook*) for every active webhook endpoint of the user
+// subscribed to it; the webhook dispatcher signs and delivers it with retries. It is called next to
+// the user notification about the same event. Code that already runs a DB transaction for the
+// event should call repository.EnqueueWebhookEvent with that transaction instead.
+func EmitWebhookEvent(userID int, event string, data map[string]interface{}) {
+	if err := repository.EmitWebhookEvent(userID, event, data); err != nil {
+		log.Printf("[WEBHOOKS] ⚠️ Failed to queue %s for user %d: %v", event, userID, err)
+	}
+}
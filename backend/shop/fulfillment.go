@@ -4,8 +4,10 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"strconv"
 	"time"
 
+	"github.com/djalben/xplr-core/backend/domain"
 	"github.com/shopspring/decimal"
 )
 
@@ -45,9 +47,12 @@ type FulfillmentResult struct {
 	Error         string `json:"error,omitempty"`
 }
 
-// UserNotifier sends a notification (email + TG) to a user about their purchase.
+// UserNotifier sends a templated notification (email + TG, see domain.Notify*) to a user about their purchase.
 // Injected to avoid circular imports with service package.
-type UserNotifier func(userID int, subject string, tgMsg string, emailBody string)
+type UserNotifier func(userID int, templateID string, data map[string]string)
+
+// EventEmitter queues an outgoing webhook event (see domain.Webhook*) for the user's integrations.
+type EventEmitter func(userID int, event string, data map[string]interface{})
 
 // PremiumEmailSender sends the premium purchase receipt email.
 // Signature: (toEmail, orderID, productName, priceUSD, cardLast4, isESIM, activationData)
@@ -58,6 +63,7 @@ type FulfillmentEngine struct {
 	db            *sql.DB
 	registry      *Registry
 	notifyUser    UserNotifier
+	emitEvent     EventEmitter
 	notifyAdmins  AdminNotifier
 	sendReceipt   PremiumEmailSender
 }
@@ -67,6 +73,7 @@ func NewFulfillmentEngine(
 	db *sql.DB,
 	registry *Registry,
 	notifyUser UserNotifier,
+	emitEvent EventEmitter,
 	notifyAdmins AdminNotifier,
 	sendReceipt PremiumEmailSender,
 ) *FulfillmentEngine {
@@ -74,6 +81,7 @@ func NewFulfillmentEngine(
 		db:           db,
 		registry:     registry,
 		notifyUser:   notifyUser,
+		emitEvent:    emitEvent,
 		notifyAdmins: notifyAdmins,
 		sendReceipt:  sendReceipt,
 	}
@@ -172,29 +180,26 @@ func (fe *FulfillmentEngine) sendNotifications(req FulfillmentRequest, orderID i
 
 	isESIM := req.ProductType == "esim"
 
-	// Build flag emoji for eSIM
-	flagEmoji := ""
-	if isESIM && len(req.ExternalID) >= 2 {
-		// CountryCode might be embedded; use product name as fallback
-	}
-
-	// Telegram + generic notification
-	var resultInfo string
-	if result.QRData != "" {
-		resultInfo = "QR-код для активации eSIM доступен в вашем кабинете."
-	} else if result.ActivationKey != "" {
-		resultInfo = fmt.Sprintf("Ваш ключ активации: <code>%s</code>", result.ActivationKey)
-	}
-
-	tgMsg := fmt.Sprintf("🛒 <b>Заказ #%d — Готов к выдаче!</b>\n\n"+
-		"%sТовар: <b>%s</b>\n"+
-		"Цена: <b>$%s</b>\n\n"+
-		"%s\n\n"+
-		`<a href="https://xplr.pro/purchases">Мои покупки</a>`,
-		orderID, flagEmoji, req.ProductName, req.PriceUSD.StringFixed(2), resultInfo)
-
+	// Telegram + generic notification (template order.ready)
 	if fe.notifyUser != nil {
-		fe.notifyUser(req.UserID, "Заказ готов — XPLR Store", tgMsg, "")
+		data := map[string]string{
+			"order_id":       strconv.Itoa(orderID),
+			"product":        req.ProductName,
+			"price":          req.PriceUSD.StringFixed(2),
+			"activation_key": result.ActivationKey,
+		}
+		if result.QRData != "" {
+			data["has_qr"] = "1"
+		}
+		fe.notifyUser(req.UserID, domain.NotifyOrderReady, data)
+	}
+	if fe.emitEvent != nil {
+		fe.emitEvent(req.UserID, domain.WebhookOrderReady, map[string]interface{}{
+			"order_id":     orderID,
+			"product":      req.ProductName,
+			"product_type": req.ProductType,
+			"price":        req.PriceUSD.StringFixed(2),
+		})
 	}
 
 	// Premium email with receipt
@@ -0,0 +1,275 @@
+package usecase
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/configs"
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/repository"
+	"github.com/djalben/xplr-core/backend/service"
+	"github.com/shopspring/decimal"
+)
+
+// Коды отказа авторизации (domain.AuthResponse.DeclineCode), кроме скользящих лимитов (velocity.go)
+const (
+	DeclineCardNotFound      = "card_not_found"
+	DeclineFraudBlocked      = "card_blocked_fraud"
+	DeclineCardInactive      = "card_inactive"
+	DeclineRecurringBlocked  = "recurring_blocked"
+	DeclineSpendingRule      = "spending_rule"
+	DeclineCardSpendingLimit = "card_spending_limit"
+	DeclineInsufficientFunds = "insufficient_funds"
+	DeclineInternalError     = "internal_error"
+)
+
+// AuthorizationRequest — авторизация по карте, откуда бы она ни пришла (AuthorizeCard или вебхук Wallester).
+type AuthorizationRequest struct {
+	CardID       int
+	Amount       decimal.Decimal
+	MerchantName string
+	MCC          string
+	Country      string
+	IsRecurring  bool
+	Source       string // 'authorize' или 'wallester' — для authorization_declines
+	At           time.Time
+}
+
+// AuthorizationState — всё, от чего зависит решение: карта, Кошелёк владельца, лимиты, правила.
+type AuthorizationState struct {
+	Card             domain.Card
+	Balances         repository.CardAuthBalances
+	Velocity         domain.VelocityLimits
+	Usage            domain.VelocityUsage
+	Rules            []domain.SpendingRule
+	RecurringAllowed bool
+}
+
+// AuthorizationDecision — результат движка авторизации.
+type AuthorizationDecision struct {
+	Approved     bool
+	Code         string // код отказа
+	Reason       string // причина для пользователя (уведомление, decline_reason)
+	Message      string // сообщение API
+	RuleID       int    // правило расходов, отклонившее операцию
+	BlockCard    bool   // анти-фрод: карту нужно заблокировать
+	CountFailure bool   // отказ увеличивает failed_auth_count
+}
+
+func declined(code, reason, message string) AuthorizationDecision {
+	return AuthorizationDecision{Code: code, Reason: reason, Message: message}
+}
+
+// Decide принимает решение по авторизации на основе снимка состояния. Порядок проверок:
+// анти-фрод, статус карты, автосписания, правила расходов, скользящие лимиты, лимит карты, баланс Кошелька.
+// Функция не обращается к БД, кроме spent (месячные суммы по мерчантам для merchant_cap).
+func Decide(req AuthorizationRequest, st AuthorizationState, spent MerchantSpendFunc) (AuthorizationDecision, error) {
+	card := st.Card
+
+	if card.FailedAuthCount >= configs.MaxFailedAttempts {
+		d := declined(DeclineFraudBlocked, "множественные неудачные попытки авторизации",
+			"Card blocked due to multiple failed attempts.")
+		d.BlockCard = true
+		return d, nil
+	}
+
+	if card.CardStatus != "ACTIVE" {
+		return declined(DeclineCardInactive, fmt.Sprintf("карта не активна (статус: %s)", card.CardStatus),
+			"Card is blocked or inactive."), nil
+	}
+
+	if req.IsRecurring && !st.RecurringAllowed {
+		return declined(DeclineRecurringBlocked, "автосписание заблокировано пользователем",
+			"Recurring payment blocked by card holder."), nil
+	}
+
+	ruleDecline, err := EvaluateSpendingRules(st.Rules, SpendingAttempt{
+		MerchantName: req.MerchantName,
+		MCC:          req.MCC,
+		Country:      req.Country,
+		Amount:       req.Amount,
+		At:           req.At,
+	}, spent)
+	if err != nil {
+		return AuthorizationDecision{}, err
+	}
+	if ruleDecline != nil {
+		d := declined(DeclineSpendingRule, ruleDecline.Reason, "Declined by spending rule.")
+		d.RuleID = ruleDecline.Rule.ID
+		return d, nil
+	}
+
+	if v := EvaluateVelocity(st.Velocity, st.Usage, req.Amount); v != nil {
+		return declined(v.Code, v.Reason, "Velocity limit exceeded."), nil
+	}
+
+	b := st.Balances
+	if b.SpendingLimit.GreaterThan(decimal.Zero) && req.Amount.GreaterThan(b.SpendingLimit.Sub(b.SpentFromWallet)) {
+		return declined(DeclineCardSpendingLimit,
+			fmt.Sprintf("превышен лимит карты (осталось: $%s)", b.SpendingLimit.Sub(b.SpentFromWallet).StringFixed(2)),
+			"Card spending limit exceeded."), nil
+	}
+
+	if b.Wallet.LessThan(req.Amount) {
+		d := declined(DeclineInsufficientFunds,
+			fmt.Sprintf("недостаточно средств (баланс: $%s, сумма: $%s)", b.Wallet.StringFixed(2), req.Amount.StringFixed(2)),
+			"Insufficient wallet balance.")
+		d.CountFailure = true
+		return d, nil
+	}
+
+	return AuthorizationDecision{Approved: true, Message: "Transaction approved."}, nil
+}
+
+// LoadAuthorizationState читает из БД состояние, нужное Decide.
+func LoadAuthorizationState(req AuthorizationRequest) (*AuthorizationState, error) {
+	card, err := repository.GetCardByID(req.CardID)
+	if err != nil {
+		return nil, err
+	}
+	st := &AuthorizationState{Card: card, RecurringAllowed: true}
+
+	if st.Balances, err = repository.GetCardAuthBalances(card.ID, card.UserID); err != nil {
+		return nil, err
+	}
+	if st.Rules, err = repository.GetActiveSpendingRules(card.ID, card.TeamID); err != nil {
+		return nil, err
+	}
+	if st.Velocity, err = repository.GetCardVelocityLimits(card.ID); err != nil {
+		return nil, err
+	}
+	v := st.Velocity
+	if v.Daily.IsPositive() || v.Weekly.IsPositive() || v.Monthly.IsPositive() || v.MaxTxPerHour > 0 {
+		if st.Usage, err = repository.GetCardVelocityUsage(card.ID); err != nil {
+			return nil, err
+		}
+	}
+	if req.IsRecurring {
+		st.RecurringAllowed = CheckRecurringAllowed(card.ID, req.MerchantName, true)
+	}
+	return st, nil
+}
+
+// EvaluateAuthorization — единый движок авторизации для AuthorizeCard и вебхука Wallester.
+// Загружает состояние, принимает решение и выполняет побочные эффекты отказа: запись в
+// authorization_declines, счётчик неудачных попыток, блокировку карты и уведомление владельца.
+func EvaluateAuthorization(req AuthorizationRequest) (AuthorizationDecision, *AuthorizationState) {
+	if req.At.IsZero() {
+		req.At = time.Now()
+	}
+	st, err := LoadAuthorizationState(req)
+	if err != nil {
+		log.Printf("[AUTH] Card %d: failed to load state: %v", req.CardID, err)
+		if err.Error() == "card not found" || err.Error() == "database connection not initialized" {
+			return declined(DeclineCardNotFound, "карта не найдена", "Card not found."), nil
+		}
+		return declined(DeclineInternalError, "внутренняя ошибка", "Internal system error during authorization."), nil
+	}
+
+	decision, err := Decide(req, *st, monthlyMerchantSpend(st.Card))
+	if err != nil {
+		log.Printf("[AUTH] Card %d: evaluation failed: %v", req.CardID, err)
+		return declined(DeclineInternalError, "внутренняя ошибка", "Internal system error during authorization."), st
+	}
+	if !decision.Approved {
+		applyDecline(req, st.Card, decision)
+	}
+	return decision, st
+}
+
+// applyDecline записывает отказ и уведомляет владельца карты.
+func applyDecline(req AuthorizationRequest, card domain.Card, d AuthorizationDecision) {
+	log.Printf("DECLINED [%s]: Card %d %s: %s (amount %s, merchant %q)",
+		req.Source, card.ID, d.Code, d.Reason, req.Amount.String(), req.MerchantName)
+
+	decline := domain.AuthorizationDecline{
+		CardID:       card.ID,
+		UserID:       card.UserID,
+		Reason:       d.Code + ": " + d.Reason,
+		MerchantName: req.MerchantName,
+		MCC:          req.MCC,
+		Country:      req.Country,
+		Amount:       req.Amount,
+		Source:       req.Source,
+	}
+	if d.RuleID > 0 {
+		ruleID := d.RuleID
+		decline.RuleID = &ruleID
+	}
+	if err := repository.RecordAuthorizationDecline(decline); err != nil {
+		log.Printf("[AUTH] ❌ Failed to record decline for card %d: %v", card.ID, err)
+	}
+
+	if d.CountFailure {
+		repository.IncrementFailedAuthCount(card.ID)
+	}
+
+	go service.EmitWebhookEvent(card.UserID, domain.WebhookTransactionDeclined, map[string]interface{}{
+		"card_id":      card.ID,
+		"last4":        card.Last4Digits,
+		"amount":       req.Amount.String(),
+		"currency":     card.Currency,
+		"merchant":     req.MerchantName,
+		"mcc":          req.MCC,
+		"country":      req.Country,
+		"code":         d.Code,
+		"reason":       d.Reason,
+		"card_blocked": d.BlockCard,
+	})
+
+	if d.BlockCard {
+		if err := repository.BlockCard(card.ID); err != nil {
+			log.Printf("ERROR: Failed to block card %d: %v", card.ID, err)
+		}
+		go service.NotifyUserTemplate(card.UserID, domain.NotifyCardBlocked, map[string]string{
+			"last4":  card.Last4Digits,
+			"reason": d.Reason,
+		})
+		return
+	}
+
+	data := map[string]string{"last4": card.Last4Digits, "reason": d.Reason}
+	if d.Code == DeclineInsufficientFunds {
+		data["insufficient_funds"] = "1"
+	}
+	go service.NotifyUserTemplate(card.UserID, domain.NotifyCardDeclined, data)
+}
+
+// CheckRecurringAllowed проверяет, можно ли провести автосписание (подписку) по карте.
+// Возвращает false, если автосписания на карте выключены или мерчант заблокирован.
+// При ошибке БД разрешает (fail-open), как и раньше.
+func CheckRecurringAllowed(cardID int, merchantName string, isRecurring bool) bool {
+	if !isRecurring {
+		return true
+	}
+	autoPay, blocked, err := repository.GetRecurringPolicy(cardID, merchantName)
+	if err != nil {
+		log.Printf("[RECURRING-CHECK] ❌ DB error for card %d: %v", cardID, err)
+		return true
+	}
+	if !autoPay {
+		log.Printf("[RECURRING-CHECK] 🚫 Card %d has auto-pay DISABLED — declining recurring tx from %q", cardID, merchantName)
+		return false
+	}
+	if blocked {
+		log.Printf("[RECURRING-CHECK] 🚫 Merchant %q blocked on card %d", merchantName, cardID)
+		return false
+	}
+	return true
+}
+
+// AuthResponseFor переводит решение движка в ответ API.
+func AuthResponseFor(d AuthorizationDecision, fee decimal.Decimal) domain.AuthResponse {
+	if d.Approved {
+		return domain.AuthResponse{Success: true, Status: "APPROVED", Message: d.Message, Fee: fee}
+	}
+	return domain.AuthResponse{
+		Success:       false,
+		Status:        "DECLINED",
+		Message:       d.Message,
+		Fee:           decimal.NewFromFloat(configs.DeclineFee),
+		DeclineCode:   d.Code,
+		DeclineReason: d.Reason,
+	}
+}
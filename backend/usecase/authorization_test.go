@@ -0,0 +1,96 @@
+package usecase
+
+import (
+	"testing"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/configs"
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/repository"
+	"github.com/shopspring/decimal"
+)
+
+func TestDecide(t *testing.T) {
+	d := decimal.NewFromInt
+	noon := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	// base — активная карта, $1000 в Кошельке, без лимитов и правил
+	base := func() AuthorizationState {
+		return AuthorizationState{
+			Card:             domain.Card{ID: 1, UserID: 7, CardStatus: "ACTIVE"},
+			Balances:         repository.CardAuthBalances{Wallet: d(1000)},
+			RecurringAllowed: true,
+		}
+	}
+	req := func(amount int64) AuthorizationRequest {
+		return AuthorizationRequest{CardID: 1, Amount: d(amount), MerchantName: "Facebook Ads", At: noon}
+	}
+	noSpend := func(domain.SpendingRule) (decimal.Decimal, error) { return decimal.Zero, nil }
+
+	cases := []struct {
+		name         string
+		req          AuthorizationRequest
+		state        func(*AuthorizationState)
+		wantCode     string // "" — одобрено
+		wantBlock    bool
+		wantCountErr bool
+	}{
+		{"одобрено", req(100), nil, "", false, false},
+		{"анти-фрод блокирует карту", req(1), func(s *AuthorizationState) {
+			s.Card.FailedAuthCount = configs.MaxFailedAttempts
+		}, DeclineFraudBlocked, true, false},
+		{"карта заморожена", req(1), func(s *AuthorizationState) {
+			s.Card.CardStatus = "FROZEN"
+		}, DeclineCardInactive, false, false},
+		{"автосписание запрещено", AuthorizationRequest{CardID: 1, Amount: d(10), MerchantName: "Netflix", IsRecurring: true, At: noon},
+			func(s *AuthorizationState) { s.RecurringAllowed = false }, DeclineRecurringBlocked, false, false},
+		{"разовая покупка при запрете автосписаний", req(10),
+			func(s *AuthorizationState) { s.RecurringAllowed = false }, "", false, false},
+		{"правило расходов", req(10), func(s *AuthorizationState) {
+			s.Rules = []domain.SpendingRule{{ID: 5, RuleType: domain.RuleMerchantDeny, Value: "facebook", IsActive: true}}
+		}, DeclineSpendingRule, false, false},
+		{"дневной лимит считается накопительно", req(90), func(s *AuthorizationState) {
+			s.Velocity = domain.VelocityLimits{Daily: d(100)}
+			s.Usage = domain.VelocityUsage{Day: d(90)}
+		}, DeclineVelocityDaily, false, false},
+		{"лимит карты", req(60), func(s *AuthorizationState) {
+			s.Balances.SpendingLimit = d(500)
+			s.Balances.SpentFromWallet = d(450)
+		}, DeclineCardSpendingLimit, false, false},
+		{"недостаточно средств в Кошельке", req(1001), nil, DeclineInsufficientFunds, false, true},
+		{"ровно весь баланс", req(1000), nil, "", false, false},
+		{"статус проверяется раньше баланса", req(5000), func(s *AuthorizationState) {
+			s.Card.CardStatus = "BLOCKED"
+		}, DeclineCardInactive, false, false},
+	}
+	for _, c := range cases {
+		st := base()
+		if c.state != nil {
+			c.state(&st)
+		}
+		got, err := Decide(c.req, st, noSpend)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got.Approved != (c.wantCode == "") || got.Code != c.wantCode {
+			t.Errorf("%s: approved=%v code=%q, want code %q", c.name, got.Approved, got.Code, c.wantCode)
+		}
+		if got.BlockCard != c.wantBlock || got.CountFailure != c.wantCountErr {
+			t.Errorf("%s: block=%v countFailure=%v, want %v/%v", c.name, got.BlockCard, got.CountFailure, c.wantBlock, c.wantCountErr)
+		}
+		if !got.Approved && got.Reason == "" {
+			t.Errorf("%s: decline without reason", c.name)
+		}
+	}
+}
+
+func TestAuthResponseFor(t *testing.T) {
+	resp := AuthResponseFor(AuthorizationDecision{Code: DeclineInsufficientFunds, Reason: "недостаточно средств", Message: "Insufficient wallet balance."}, decimal.NewFromInt(5))
+	if resp.Success || resp.Status != "DECLINED" || resp.DeclineCode != DeclineInsufficientFunds || !resp.Fee.Equal(decimal.NewFromFloat(configs.DeclineFee)) {
+		t.Errorf("unexpected decline response: %+v", resp)
+	}
+	resp = AuthResponseFor(AuthorizationDecision{Approved: true, Message: "Transaction approved."}, decimal.NewFromInt(5))
+	if !resp.Success || resp.Status != "APPROVED" || !resp.Fee.Equal(decimal.NewFromInt(5)) || resp.DeclineCode != "" {
+		t.Errorf("unexpected approve response: %+v", resp)
+	}
+}
@@ -1,11 +1,13 @@
 package usecase
 
 import (
+	"database/sql"
 	"fmt"
 	"log"
 	"time"
 
 	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/ledger"
 	"github.com/djalben/xplr-core/backend/repository"
 	"github.com/djalben/xplr-core/backend/service"
 	"github.com/shopspring/decimal"
@@ -45,27 +47,13 @@ func processCardReplenishment(card domain.Card) error {
 	log.Printf("[AUTO-REPLENISH] Processing card %d (user %d, balance: %s, threshold: %s)",
 		card.ID, card.UserID, card.CardBalance.String(), card.AutoReplenishThreshold.String())
 
-	// 1. Проверить Кошелёк пользователя
-	wallet, err := repository.GetInternalBalance(card.UserID)
+	// 1. Карта пополняется из Кошелька в своей валюте
+	currency, err := repository.NormalizeWalletCurrency(card.Currency)
 	if err != nil {
-		return fmt.Errorf("failed to get wallet: %w", err)
+		return fmt.Errorf("card currency: %w", err)
 	}
 
-	// 2. Проверить баланс Кошелька
-	if wallet.MasterBalance.LessThan(card.AutoReplenishAmount) {
-		log.Printf("[AUTO-REPLENISH] Insufficient wallet balance for card %d. Wallet: $%s, Required: $%s",
-			card.ID, wallet.MasterBalance.StringFixed(2), card.AutoReplenishAmount.StringFixed(2))
-
-		go service.NotifyUser(card.UserID, "Автопополнение не удалось",
-			fmt.Sprintf("⚠️ <b>Автопополнение не удалось</b>\n\n"+
-				"Недостаточно средств для пополнения карты *%s.\n"+
-				"Требуется: <b>$%s</b>, доступно: <b>$%s</b>\n\n"+
-				"<a href=\"https://xplr.pro/wallet\">Пополнить кошелёк</a>",
-				card.Last4Digits, card.AutoReplenishAmount.StringFixed(2), wallet.MasterBalance.StringFixed(2)))
-		return fmt.Errorf("insufficient wallet balance")
-	}
-
-	// 3. Начать транзакцию БД для атомарности
+	// 2. Начать транзакцию БД для атомарности
 	if repository.GlobalDB == nil {
 		return fmt.Errorf("database connection not initialized")
 	}
@@ -75,54 +63,67 @@ func processCardReplenishment(card domain.Card) error {
 	}
 	defer tx.Rollback()
 
-	// 4. Списать из Кошелька (internal_balances.master_balance)
-	_, err = tx.Exec(
-		"UPDATE internal_balances SET master_balance = master_balance - $1, updated_at = NOW() WHERE user_id = $2 AND master_balance >= $1",
-		card.AutoReplenishAmount, card.UserID,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to deduct from wallet: %w", err)
+	// 3. Проверить баланс Кошелька под блокировкой
+	// Для USD доступный баланс — master_balance за вычетом холдов по картам
+	walletBalance, err := repository.LockWalletBalance(tx, card.UserID, currency)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to lock wallet: %w", err)
 	}
-
-	// 5. Пополнить карту
-	_, err = tx.Exec(
-		"UPDATE cards SET card_balance = card_balance + $1 WHERE id = $2",
-		card.AutoReplenishAmount, card.ID,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to replenish card: %w", err)
+	if walletBalance.LessThan(card.AutoReplenishAmount) {
+		log.Printf("[AUTO-REPLENISH] Insufficient %s wallet balance for card %d. Wallet: %s, Required: %s",
+			currency, card.ID, walletBalance.StringFixed(2), card.AutoReplenishAmount.StringFixed(2))
+
+		go service.NotifyUserTemplate(card.UserID, domain.NotifyCardAutoReplenishFailed, map[string]string{
+			"last4":     card.Last4Digits,
+			"currency":  currency,
+			"required":  card.AutoReplenishAmount.StringFixed(2),
+			"available": walletBalance.StringFixed(2),
+		})
+		return fmt.Errorf("insufficient wallet balance")
 	}
 
-	// 6. Записать транзакцию FUND
-	_, err = tx.Exec(
-		`INSERT INTO transactions (user_id, card_id, amount, fee, transaction_type, status, details, executed_at)
-		 VALUES ($1, $2, $3, $4, 'FUND', 'APPROVED', $5, $6)`,
+	// 4. Записать транзакцию FUND
+	var txID int
+	err = tx.QueryRow(
+		`INSERT INTO transactions (user_id, card_id, amount, fee, transaction_type, status, details, currency, wallet_currency, executed_at)
+		 VALUES ($1, $2, $3, $4, 'FUND', 'APPROVED', $5, $6, $6, $7) RETURNING id`,
 		card.UserID,
 		card.ID,
 		card.AutoReplenishAmount,
 		decimal.Zero, // Комиссия за автопополнение = 0
 		fmt.Sprintf("Auto-replenishment: Card ...%s replenished with %s", card.Last4Digits, card.AutoReplenishAmount.String()),
+		currency,
 		time.Now(),
-	)
+	).Scan(&txID)
 	if err != nil {
 		return fmt.Errorf("failed to record transaction: %w", err)
 	}
 
-	// 7. Коммит транзакции
+	// 5. Кошелёк → карта через журнал
+	_, err = ledger.Post(tx, ledger.Entry{
+		Type:          "FUND",
+		TransactionID: txID,
+		Description:   "Auto-replenishment",
+		Lines:         ledger.Move(ledger.UserWallet(card.UserID), ledger.Card(card.ID), card.AutoReplenishAmount, currency),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to replenish card: %w", err)
+	}
+
+	// 6. Коммит транзакции
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	log.Printf("[AUTO-REPLENISH] ✅ Card %d replenished successfully with %s", card.ID, card.AutoReplenishAmount.String())
 
-	// 8. Отправить уведомление пользователю (TG + Email)
-	go service.NotifyUser(card.UserID, "Автопополнение карты",
-		fmt.Sprintf("✅ <b>Автопополнение</b>\n\n"+
-			"С вашего кошелька переведено <b>$%s</b> на карту *%s.\n"+
-			"Новый баланс карты: <b>$%s</b>\n\n"+
-			"<a href=\"https://xplr.pro/cards\">Открыть карты</a>",
-			card.AutoReplenishAmount.StringFixed(2), card.Last4Digits,
-			card.CardBalance.Add(card.AutoReplenishAmount).StringFixed(2)))
+	// 7. Отправить уведомление пользователю (TG + Email)
+	go service.NotifyUserTemplate(card.UserID, domain.NotifyCardAutoReplenished, map[string]string{
+		"last4":    card.Last4Digits,
+		"amount":   card.AutoReplenishAmount.StringFixed(2),
+		"currency": currency,
+		"balance":  card.CardBalance.Add(card.AutoReplenishAmount).StringFixed(2),
+	})
 
 	return nil
 }
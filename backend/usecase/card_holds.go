@@ -0,0 +1,47 @@
+package usecase
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/repository"
+	"github.com/djalben/xplr-core/backend/service"
+)
+
+// ExpireCardHolds освобождает холды, по которым эмитент не прислал capture или reversal
+// дольше card_hold_expiry_days. Возвращает число освобождённых холдов.
+func ExpireCardHolds() (int, error) {
+	days := repository.GetCardHoldExpiryDays()
+	holds, err := repository.ExpireStaleCardHolds(days)
+	if err != nil {
+		return 0, err
+	}
+	for _, h := range holds {
+		log.Printf("[CARD-HOLDS] Hold #%d expired after %d days: %s released (user %d, card %d, ref=%s)",
+			h.ID, days, h.Amount.String(), h.UserID, h.CardID, h.ProviderTxID)
+		go service.NotifyUserOperationTemplate(h.UserID, domain.NotifyCardHoldExpired, h.Amount, map[string]string{
+			"amount":   h.Amount.StringFixed(2),
+			"merchant": h.MerchantName,
+			"days":     strconv.Itoa(days),
+		})
+	}
+	return len(holds), nil
+}
+
+// StartCardHoldExpiryWorker — фоновый процесс: раз в час освобождает просроченные холды.
+func StartCardHoldExpiryWorker() {
+	log.Println("[CARD-HOLDS] Starting card hold expiry worker...")
+
+	ticker := time.NewTicker(time.Hour)
+	go func() {
+		for range ticker.C {
+			if _, err := ExpireCardHolds(); err != nil {
+				log.Printf("[CARD-HOLDS] ❌ Worker error: %v", err)
+			}
+		}
+	}()
+
+	log.Println("[CARD-HOLDS] Card hold expiry worker started (checking every hour)")
+}
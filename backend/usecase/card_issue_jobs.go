@@ -0,0 +1,225 @@
+package usecase
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/ledger"
+	"github.com/djalben/xplr-core/backend/repository"
+	"github.com/djalben/xplr-core/backend/service"
+	"github.com/shopspring/decimal"
+)
+
+// cardIssueConcurrency — сколько карт одного задания выпускается у провайдера одновременно.
+const cardIssueConcurrency = 5
+
+// cardIssueJobStaleAfter — RUNNING-задание без прогресса дольше этого срока считается брошенным.
+const cardIssueJobStaleAfter = 10 * time.Minute
+
+// cardIssueJobPollInterval — как часто воркер ищет новые и брошенные задания.
+const cardIssueJobPollInterval = 30 * time.Second
+
+// providerCardIssuer выпускает карты задания у эмитента, выбранного по card_provider_routes.
+func providerCardIssuer(job *domain.CardIssueJob) repository.ProviderIssueFunc {
+	name, provider, routeErr := service.RouteCardIssue(job.Request.Category, job.Request.Currency, job.Request.CardType)
+	if routeErr == nil {
+		log.Printf("[CARD-JOBS] Job #%d routed to %s (%s/%s/%s)", job.ID, name,
+			job.Request.Category, job.Request.Currency, job.Request.CardType)
+	}
+	return func(currency, cardType string) (*repository.IssuedProviderCard, error) {
+		if routeErr != nil {
+			return nil, routeErr
+		}
+		issued, err := provider.IssueCard(service.IssueCardRequest{
+			UserID:     job.UserID,
+			CardType:   cardType,
+			Currency:   currency,
+			DailyLimit: job.Request.DailyLimit.InexactFloat64(),
+			Category:   job.Request.Category,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &repository.IssuedProviderCard{
+			Provider:       name,
+			ProviderCardID: issued.ProviderCardID,
+			BIN:            issued.BIN,
+			Last4:          issued.Last4,
+			Status:         issued.Status,
+		}, nil
+	}
+}
+
+// forEachBounded вызывает fn для каждого индекса, не более limit вызовов одновременно.
+func forEachBounded(indexes []int, limit int, fn func(idx int)) {
+	if limit < 1 {
+		limit = 1
+	}
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for _, idx := range indexes {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(idx int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(idx)
+		}(idx)
+	}
+	wg.Wait()
+}
+
+// pendingCardIndexes — индексы карт задания, которые ещё не обработаны.
+func pendingCardIndexes(total int, done map[int]bool) []int {
+	var out []int
+	for i := 0; i < total; i++ {
+		if !done[i] {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// RunCardIssueJob выпускает оставшиеся карты задания и закрывает его.
+// Комиссия за каждую невыпущенную карту возвращается в Кошелёк сразу при её обработке.
+func RunCardIssueJob(job *domain.CardIssueJob) error {
+	done, err := repository.GetCardIssueJobDoneIndexes(job.ID)
+	if err != nil {
+		return err
+	}
+	pending := pendingCardIndexes(job.Total, done)
+	log.Printf("[CARD-JOBS] Job #%d: issuing %d of %d cards (user %d)", job.ID, len(pending), job.Total, job.UserID)
+
+	issue := providerCardIssuer(job)
+	forEachBounded(pending, cardIssueConcurrency, func(idx int) {
+		issued, err := issue(job.Request.Currency, job.Request.CardType)
+		if err != nil {
+			log.Printf("[CARD-JOBS] Job #%d card %d: provider failed: %v", job.ID, idx, err)
+			if _, rerr := repository.RecordFailedCard(job, idx, fmt.Sprintf("Provider failed to issue card: %v", err)); rerr != nil {
+				log.Printf("[CARD-JOBS] ❌ Job #%d card %d: failed to record failure: %v", job.ID, idx, rerr)
+			}
+			return
+		}
+		if _, err := repository.RecordIssuedCard(job, idx, issued); err != nil {
+			log.Printf("[CARD-JOBS] ❌ Job #%d card %d: provider card %s not saved: %v", job.ID, idx, issued.ProviderCardID, err)
+			if _, rerr := repository.RecordFailedCard(job, idx, "Failed to save issued card"); rerr != nil {
+				log.Printf("[CARD-JOBS] ❌ Job #%d card %d: failed to record failure: %v", job.ID, idx, rerr)
+			}
+		}
+	})
+
+	finished, err := repository.FinishCardIssueJob(job.ID, cardIssueJobNotification)
+	if err != nil {
+		return err
+	}
+	if finished == nil {
+		return nil // задание закрыл другой обработчик
+	}
+
+	log.Printf("[CARD-JOBS] ✅ Job #%d %s: %d issued, %d failed, refunded $%s",
+		finished.ID, finished.Status, finished.Succeeded, finished.Failed, finished.Refunded.StringFixed(2))
+	notifyCardIssueJobFinished(finished)
+	return nil
+}
+
+// cardIssueJobNotification — уведомление пользователю по итогам задания.
+func cardIssueJobNotification(job *domain.CardIssueJob) *domain.UserNotification {
+	if job.Succeeded == 0 {
+		return &domain.UserNotification{
+			UserID:   job.UserID,
+			Template: domain.NotifyCardsIssueFailed,
+			Data: map[string]string{
+				"requested": strconv.Itoa(job.Total),
+				"refunded":  job.Refunded.StringFixed(2),
+			},
+		}
+	}
+	return &domain.UserNotification{UserID: job.UserID, Template: domain.NotifyCardsIssued, Data: cardsIssuedData(job)}
+}
+
+// notifyCardIssueJobFinished — уведомления админам и рефереру по итогам задания.
+func notifyCardIssueJobFinished(job *domain.CardIssueJob) {
+	if job.Succeeded == 0 {
+		return
+	}
+
+	// Check referral bonus eligibility (condition 3: first card purchase)
+	go repository.CheckAndCreditReferralBonus(job.UserID)
+	repository.NotifyCardsIssued(job)
+}
+
+// cardsIssuedData — данные шаблона cards.issued. team_wallet — комиссия за невыпущенные карты
+// вернулась в Кошелёк команды, а не в личный Кошелёк.
+func cardsIssuedData(job *domain.CardIssueJob) map[string]string {
+	fee := job.FeePerCard.Mul(decimal.NewFromInt(int64(job.Succeeded)))
+	data := map[string]string{
+		"count":       strconv.Itoa(job.Succeeded),
+		"category":    job.Request.Category,
+		"fee":         fee.StringFixed(2),
+		"daily_limit": job.Request.DailyLimit.StringFixed(2),
+	}
+	if job.Failed > 0 {
+		data["failed"] = strconv.Itoa(job.Failed)
+		data["refunded"] = job.Refunded.StringFixed(2)
+		if repository.CardIssueFeeAccount(job).Type == ledger.AccountTeamWallet {
+			data["team_wallet"] = "1"
+		}
+	}
+	return data
+}
+
+// StartCardIssueJob запускает только что созданное задание (если его ещё не взял воркер).
+func StartCardIssueJob(jobID int) {
+	job, err := repository.ClaimCardIssueJob(jobID)
+	if err != nil {
+		log.Printf("[CARD-JOBS] ❌ Failed to claim job #%d: %v", jobID, err)
+		return
+	}
+	if job == nil {
+		return
+	}
+	if err := RunCardIssueJob(job); err != nil {
+		log.Printf("[CARD-JOBS] ❌ Job #%d failed: %v", jobID, err)
+	}
+}
+
+// ProcessCardIssueJobs выполняет все ожидающие и брошенные задания. Возвращает число обработанных.
+func ProcessCardIssueJobs() (int, error) {
+	processed := 0
+	for {
+		job, err := repository.ClaimNextCardIssueJob(cardIssueJobStaleAfter)
+		if err != nil {
+			return processed, err
+		}
+		if job == nil {
+			return processed, nil
+		}
+		if err := RunCardIssueJob(job); err != nil {
+			log.Printf("[CARD-JOBS] ❌ Job #%d failed: %v", job.ID, err)
+		}
+		processed++
+	}
+}
+
+// StartCardIssueJobWorker — фоновый процесс: подхватывает задания, которые не были запущены
+// сразу или были брошены после падения процесса.
+func StartCardIssueJobWorker() {
+	log.Println("[CARD-JOBS] Starting card issue job worker...")
+
+	ticker := time.NewTicker(cardIssueJobPollInterval)
+	go func() {
+		for range ticker.C {
+			if _, err := ProcessCardIssueJobs(); err != nil {
+				log.Printf("[CARD-JOBS] ❌ Worker error: %v", err)
+			}
+		}
+	}()
+
+	log.Printf("[CARD-JOBS] Card issue job worker started (poll every %s, concurrency %d)", cardIssueJobPollInterval, cardIssueConcurrency)
+}
@@ -0,0 +1,74 @@
+package usecase
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/ledger"
+	"github.com/djalben/xplr-core/backend/repository"
+)
+
+func TestPendingCardIndexes(t *testing.T) {
+	got := pendingCardIndexes(5, map[int]bool{0: true, 3: true})
+	if want := []int{1, 2, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pendingCardIndexes = %v, want %v", got, want)
+	}
+	if got := pendingCardIndexes(2, map[int]bool{0: true, 1: true}); len(got) != 0 {
+		t.Errorf("все карты обработаны, а pending = %v", got)
+	}
+}
+
+func TestForEachBounded(t *testing.T) {
+	indexes := make([]int, 40)
+	for i := range indexes {
+		indexes[i] = i
+	}
+
+	var running, peak int32
+	var mu sync.Mutex
+	seen := make(map[int]bool)
+	forEachBounded(indexes, 3, func(idx int) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		seen[idx] = true
+		mu.Unlock()
+		atomic.AddInt32(&running, -1)
+	})
+
+	if len(seen) != len(indexes) {
+		t.Errorf("обработано %d карт из %d", len(seen), len(indexes))
+	}
+	if peak > 3 {
+		t.Errorf("одновременно выпускалось %d карт, лимит 3", peak)
+	}
+}
+
+func TestCardIssueFeeRefundGoesToFeeSource(t *testing.T) {
+	teamID := 7
+	teamJob := &domain.CardIssueJob{ID: 1, UserID: 42, FeeTeamID: &teamID, Succeeded: 1, Failed: 1}
+	if got := repository.CardIssueFeeAccount(teamJob); got != ledger.TeamWallet(7) {
+		t.Errorf("комиссия командного задания возвращается на %s, ожидался %s", got.Code(), ledger.TeamWallet(7).Code())
+	}
+	if got := cardsIssuedData(teamJob)["team_wallet"]; got != "1" {
+		t.Errorf("уведомление о командном задании: team_wallet=%q", got)
+	}
+
+	personalJob := &domain.CardIssueJob{ID: 2, UserID: 42, Succeeded: 1, Failed: 1}
+	if got := repository.CardIssueFeeAccount(personalJob); got != ledger.UserWallet(42) {
+		t.Errorf("комиссия личного задания возвращается на %s, ожидался %s", got.Code(), ledger.UserWallet(42).Code())
+	}
+	if got := cardsIssuedData(personalJob)["team_wallet"]; got != "" {
+		t.Errorf("уведомление о личном задании: team_wallet=%q", got)
+	}
+}
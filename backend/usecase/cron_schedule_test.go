@@ -0,0 +1,66 @@
+package usecase
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	moscow := time.FixedZone("MSK", 3*3600)
+	// 2026-10-17 — суббота
+	after := time.Date(2026, 10, 17, 10, 30, 0, 0, time.UTC)
+	cases := []struct {
+		expr string
+		loc  *time.Location
+		want time.Time
+	}{
+		{"0 9 * * 1", time.UTC, time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)},
+		{"0 9 1 * *", time.UTC, time.Date(2026, 11, 1, 9, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.UTC, time.Date(2026, 10, 17, 10, 45, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.UTC, time.Date(2026, 10, 17, 13, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.UTC, time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.UTC, time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+		// День месяца и день недели заданы оба — срабатывает любой: 20-е число или ближайший понедельник
+		{"0 9 20 * 1", time.UTC, time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)},
+		// 13:30 по Москве = 10:30 UTC — срабатывание ровно в after не считается
+		{"30 13 * * *", moscow, time.Date(2026, 10, 18, 13, 30, 0, 0, moscow)},
+	}
+	for _, c := range cases {
+		s, err := ParseCronSchedule(c.expr)
+		if err != nil {
+			t.Fatalf("%q: %v", c.expr, err)
+		}
+		if got := s.Next(after, c.loc); !got.Equal(c.want) {
+			t.Errorf("%q: got %s, want %s", c.expr, got, c.want)
+		}
+	}
+
+	if s, _ := ParseCronSchedule("0 0 31 2 *"); !s.Next(after, time.UTC).IsZero() {
+		t.Error("31 февраля не должно наступать")
+	}
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "0 24 * * *", "0 0 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := ParseCronSchedule(expr); err == nil {
+			t.Errorf("%q: ожидалась ошибка", expr)
+		}
+	}
+}
+
+func TestSplitScheduledAmount(t *testing.T) {
+	parts := SplitScheduledAmount(decimal.RequireFromString("1000"), 3)
+	want := []string{"333.33", "333.33", "333.34"}
+	sum := decimal.Zero
+	for i, p := range parts {
+		if !p.Equal(decimal.RequireFromString(want[i])) {
+			t.Errorf("part %d: got %s, want %s", i, p, want[i])
+		}
+		sum = sum.Add(p)
+	}
+	if !sum.Equal(decimal.NewFromInt(1000)) {
+		t.Errorf("sum %s != 1000", sum)
+	}
+	if SplitScheduledAmount(decimal.NewFromInt(10), 0) != nil {
+		t.Error("0 карт — ожидался nil")
+	}
+}
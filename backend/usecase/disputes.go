@@ -0,0 +1,91 @@
+package usecase
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strings"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/repository"
+	"github.com/djalben/xplr-core/backend/service"
+	"github.com/shopspring/decimal"
+)
+
+// DisputeReasons — допустимые причины спора и их описание для администратора.
+var DisputeReasons = map[string]string{
+	"not_received":           "товар или услуга не получены",
+	"not_as_described":       "не соответствует описанию",
+	"duplicate":              "повторное списание",
+	"incorrect_amount":       "неверная сумма",
+	"cancelled_subscription": "списание после отмены подписки",
+	"fraud":                  "операция не совершалась держателем карты",
+	"other":                  "другое",
+}
+
+// OpenDispute открывает спор пользователя по списанию и уведомляет администраторов.
+// Уведомление пользователю ставит в очередь repository.CreateDispute.
+func OpenDispute(userID, transactionID int, amount decimal.Decimal, reason, description string) (*domain.Dispute, error) {
+	reason = strings.TrimSpace(reason)
+	if _, ok := DisputeReasons[reason]; !ok {
+		return nil, fmt.Errorf("%w: unknown reason %q", repository.ErrNotDisputable, reason)
+	}
+	description = strings.TrimSpace(description)
+	if len(description) > 2000 {
+		description = description[:2000]
+	}
+	if amount.IsNegative() {
+		return nil, fmt.Errorf("%w: amount must be positive", repository.ErrNotDisputable)
+	}
+
+	d, err := repository.CreateDispute(userID, transactionID, amount, reason, description)
+	if err != nil {
+		return nil, err
+	}
+
+	go service.NotifyAdmins("Новый спор по транзакции",
+		fmt.Sprintf("⚖️ <b>Спор #%d</b>\n\n"+
+			"Пользователь: %s (ID %d)\n"+
+			"Транзакция: #%d, %s\n"+
+			"Сумма: <b>$%s</b>\n"+
+			"Причина: %s\n\n%s",
+			d.ID, html.EscapeString(d.UserEmail), d.UserID, d.TransactionID, html.EscapeString(d.MerchantName),
+			d.Amount.StringFixed(2), DisputeReasons[reason], html.EscapeString(description)))
+	return d, nil
+}
+
+// ResolveDispute меняет статус спора администратором. При status=WON и refund=true оспоренная
+// сумма возвращается как CHARGEBACK (в Кошелёк или на карту — откуда было списание).
+func ResolveDispute(disputeID, adminID int, status, comment string, refund bool) (*domain.Dispute, error) {
+	status = strings.ToUpper(strings.TrimSpace(status))
+	switch status {
+	case domain.DisputeInReview, domain.DisputeWon, domain.DisputeLost:
+	default:
+		return nil, fmt.Errorf("invalid dispute status %q", status)
+	}
+	d, err := repository.GetDispute(disputeID)
+	if err != nil {
+		return nil, err
+	}
+	if d.Status != domain.DisputeOpen && d.Status != domain.DisputeInReview {
+		return nil, fmt.Errorf("dispute %d is already resolved", disputeID)
+	}
+
+	if status == domain.DisputeWon && refund {
+		res, err := repository.CreditCardRefund(repository.CardRefund{
+			UserID:       d.UserID,
+			Kind:         repository.RefundKindChargeback,
+			Amount:       d.Amount,
+			ProviderTxID: fmt.Sprintf("xplr-dispute-%d", d.ID),
+			OriginalTxID: d.TransactionID,
+			Details:      fmt.Sprintf("Dispute #%d resolved in favour of the cardholder", d.ID),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to refund dispute: %w", err)
+		}
+		log.Printf("[DISPUTES] Dispute #%d: $%s refunded by admin %d (tx %d)", d.ID, res.Amount.StringFixed(2), adminID, res.TransactionID)
+	}
+
+	// Уведомление пользователю о решении фиксируется вместе со статусом
+	return repository.UpdateDisputeStatus(disputeID, status, strings.TrimSpace(comment), adminID)
+}
@@ -0,0 +1,276 @@
+package usecase
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/repository"
+	"github.com/djalben/xplr-core/backend/service"
+)
+
+// NotificationMaxAttempts — сколько раз отправляется уведомление, прежде чем оно считается проваленным.
+const NotificationMaxAttempts = 6
+
+// notificationRetryBase и notificationRetryMax — экспоненциальная пауза между попытками: 30с, 1м, 2м, 4м, 8м…
+const (
+	notificationRetryBase = 30 * time.Second
+	notificationRetryMax  = time.Hour
+)
+
+// notificationBatchSize — сколько уведомлений канала dispatcher забирает за один проход.
+const notificationBatchSize = 50
+
+// notificationDigestBatchSize и notificationDigestMaxItems — сколько отложенных в сводку уведомлений
+// забирается за проход и сколько операций помещается в одно сообщение сводки (лимит длины Telegram).
+const (
+	notificationDigestBatchSize = 500
+	notificationDigestMaxItems  = 15
+)
+
+// notificationDigestSeparator разделяет операции в тексте сводки.
+const notificationDigestSeparator = "\n\n➖➖➖\n\n"
+
+// notificationChannelIntervals — минимальная пауза между отправками в канале (лимит канала):
+// SMTP-провайдер — не больше 5 писем в секунду, Bot API — не больше 25 сообщений в секунду.
+var notificationChannelIntervals = map[string]time.Duration{
+	domain.NotificationChannelEmail:    200 * time.Millisecond,
+	domain.NotificationChannelTelegram: 40 * time.Millisecond,
+}
+
+// notificationRetryDelay — пауза перед следующей попыткой после attempt неудачных.
+func notificationRetryDelay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	delay := notificationRetryBase
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= notificationRetryMax {
+			return notificationRetryMax
+		}
+	}
+	return delay
+}
+
+// channelThrottle выдерживает минимальную паузу между отправками в одном канале.
+type channelThrottle struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func (t *channelThrottle) Wait() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	if t.next.After(now) {
+		time.Sleep(t.next.Sub(now))
+		now = t.next
+	}
+	t.next = now.Add(t.interval)
+}
+
+var notificationThrottles = func() map[string]*channelThrottle {
+	m := make(map[string]*channelThrottle, len(notificationChannelIntervals))
+	for channel, interval := range notificationChannelIntervals {
+		m[channel] = &channelThrottle{interval: interval}
+	}
+	return m
+}()
+
+// finishNotification записывает результат попытки отправки n.
+func finishNotification(n *domain.OutboxNotification, status, recipient, errMsg string, next time.Time) {
+	if err := repository.FinishNotificationAttempt(n, status, recipient, errMsg, next); err != nil {
+		log.Printf("[NOTIFY-OUTBOX] ❌ Notification #%d: failed to record result: %v", n.ID, err)
+	}
+}
+
+// finishSendAttempt записывает результат отправки: SENT, SKIPPED (канал не подключён),
+// PENDING с паузой перед повтором или FAILED после NotificationMaxAttempts попыток.
+func finishSendAttempt(n *domain.OutboxNotification, recipient string, err error) {
+	status, errMsg, next := domain.NotificationSent, "", time.Now()
+	switch {
+	case errors.Is(err, service.ErrNotificationRecipientMissing):
+		status, errMsg = domain.NotificationSkipped, err.Error()
+	case err != nil:
+		errMsg = err.Error()
+		if n.Attempts+1 >= NotificationMaxAttempts {
+			status = domain.NotificationFailed
+			log.Printf("[NOTIFY-OUTBOX] ❌ Notification #%d (%s, user %d) failed after %d attempts: %v",
+				n.ID, n.Channel, n.UserID, n.Attempts+1, err)
+		} else {
+			status = domain.NotificationPending
+			next = next.Add(notificationRetryDelay(n.Attempts + 1))
+			log.Printf("[NOTIFY-OUTBOX] Notification #%d (%s, user %d) attempt %d failed, retry at %s: %v",
+				n.ID, n.Channel, n.UserID, n.Attempts+1, next.Format(time.RFC3339), err)
+		}
+	}
+	finishNotification(n, status, recipient, errMsg, next)
+}
+
+// notificationStillWanted — не отключил ли пользователь событие в этом канале после постановки в очередь.
+// Отключённое уведомление помечается SKIPPED. Если настройки не прочитать — уведомление отправляется.
+func notificationStillWanted(n *domain.OutboxNotification) bool {
+	if n.Event == "" {
+		return true
+	}
+	allowed, err := repository.NotificationChannelAllowed(n.UserID, n.Event, n.Channel)
+	if err != nil {
+		log.Printf("[NOTIFY-OUTBOX] Notification #%d: could not check preferences: %v", n.ID, err)
+		return true
+	}
+	if !allowed {
+		finishNotification(n, domain.NotificationSkipped, "", "disabled in notification settings", time.Now())
+	}
+	return allowed
+}
+
+// prepareNotification собирает текст уведомления для канала. Шаблон без текста
+// на языке пользователя не отправить и повтором — такое уведомление сразу FAILED.
+func prepareNotification(ch service.Channel, n *domain.OutboxNotification) bool {
+	if err := service.PrepareNotification(ch, n); err != nil {
+		log.Printf("[NOTIFY-OUTBOX] ❌ Notification #%d (%s): %v", n.ID, n.Template, err)
+		finishNotification(n, domain.NotificationFailed, "", err.Error(), time.Now())
+		return false
+	}
+	return true
+}
+
+// deliverNotification делает одну попытку отправки и записывает её результат.
+func deliverNotification(n domain.OutboxNotification) {
+	ch, ok := service.NotificationChannelByName(n.Channel)
+	if !ok {
+		finishNotification(&n, domain.NotificationFailed, "", "unknown channel", time.Now())
+		return
+	}
+	if !notificationStillWanted(&n) || !prepareNotification(ch, &n) {
+		return
+	}
+	if throttle, ok := notificationThrottles[n.Channel]; ok {
+		throttle.Wait()
+	}
+	recipient, err := ch.Send(n)
+	finishSendAttempt(&n, recipient, err)
+}
+
+// deliverDigest отправляет пользователю одно сообщение со сводкой items (все — одного канала)
+// и записывает результат для каждой операции.
+func deliverDigest(items []domain.OutboxNotification) {
+	ch, ok := service.NotificationChannelByName(items[0].Channel)
+	if !ok {
+		for i := range items {
+			finishNotification(&items[i], domain.NotificationFailed, "", "unknown channel", time.Now())
+		}
+		return
+	}
+	ready := make([]*domain.OutboxNotification, 0, len(items))
+	bodies := make([]string, 0, len(items))
+	for i := range items {
+		n := &items[i]
+		if !notificationStillWanted(n) || !prepareNotification(ch, n) {
+			continue
+		}
+		ready = append(ready, n)
+		bodies = append(bodies, n.Body)
+	}
+	if len(ready) == 0 {
+		return
+	}
+
+	digest := domain.OutboxNotification{
+		UserID:   ready[0].UserID,
+		Channel:  ch.Name(),
+		Template: domain.NotifyDigest,
+		Data:     map[string]string{"count": strconv.Itoa(len(ready))},
+	}
+	if err := service.PrepareNotification(ch, &digest); err != nil {
+		log.Printf("[NOTIFY-OUTBOX] ❌ Digest for user %d: %v", digest.UserID, err)
+		for _, n := range ready {
+			finishNotification(n, domain.NotificationFailed, "", err.Error(), time.Now())
+		}
+		return
+	}
+	digest.Body += notificationDigestSeparator + strings.Join(bodies, notificationDigestSeparator)
+
+	if throttle, ok := notificationThrottles[digest.Channel]; ok {
+		throttle.Wait()
+	}
+	recipient, err := ch.Send(digest)
+	for _, n := range ready {
+		finishSendAttempt(n, recipient, err)
+	}
+}
+
+// groupDigestNotifications делит отложенные уведомления (отсортированы по user_id) на сообщения
+// сводки: одно сообщение — один пользователь и не больше notificationDigestMaxItems операций.
+func groupDigestNotifications(batch []domain.OutboxNotification) [][]domain.OutboxNotification {
+	var groups [][]domain.OutboxNotification
+	for i := 0; i < len(batch); {
+		j := i + 1
+		for j < len(batch) && batch[j].UserID == batch[i].UserID && j-i < notificationDigestMaxItems {
+			j++
+		}
+		groups = append(groups, batch[i:j])
+		i = j
+	}
+	return groups
+}
+
+// ProcessNotificationOutbox отправляет уведомления, срок попытки которых наступил.
+// Каналы обрабатываются параллельно, чтобы сбой или лимит одного не задерживал другой.
+// Возвращает число обработанных уведомлений.
+func ProcessNotificationOutbox() (int, error) {
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		processed int
+		firstErr  error
+	)
+	for _, ch := range service.NotificationChannels() {
+		wg.Add(1)
+		go func(channel string) {
+			defer wg.Done()
+			batch, err := repository.ClaimDueNotifications(channel, false, notificationBatchSize)
+			if err == nil {
+				for _, n := range batch {
+					deliverNotification(n)
+				}
+				var digests []domain.OutboxNotification
+				digests, err = repository.ClaimDueNotifications(channel, true, notificationDigestBatchSize)
+				for _, group := range groupDigestNotifications(digests) {
+					deliverDigest(group)
+				}
+				batch = append(batch, digests...)
+			}
+			mu.Lock()
+			processed += len(batch)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			mu.Unlock()
+		}(ch.Name())
+	}
+	wg.Wait()
+	return processed, firstErr
+}
+
+// StartNotificationDispatcher запускает отправку уведомлений из notification_outbox.
+func StartNotificationDispatcher() {
+	log.Println("[NOTIFY-OUTBOX] Starting notification dispatcher...")
+
+	ticker := time.NewTicker(5 * time.Second)
+	go func() {
+		for range ticker.C {
+			if _, err := ProcessNotificationOutbox(); err != nil {
+				log.Printf("[NOTIFY-OUTBOX] Dispatch failed: %v", err)
+			}
+		}
+	}()
+
+	log.Println("[NOTIFY-OUTBOX] Notification dispatcher started (checking every 5 seconds)")
+}
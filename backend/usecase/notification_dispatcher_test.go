@@ -0,0 +1,62 @@
+package usecase
+
+import (
+	"testing"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/domain"
+)
+
+func TestNotificationRetryDelay(t *testing.T) {
+	cases := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{5, 8 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{50, time.Hour},
+	}
+	for _, c := range cases {
+		if got := notificationRetryDelay(c.attempt); got != c.want {
+			t.Errorf("notificationRetryDelay(%d) = %s, want %s", c.attempt, got, c.want)
+		}
+	}
+}
+
+func TestChannelThrottleSpacesSends(t *testing.T) {
+	th := &channelThrottle{interval: 20 * time.Millisecond}
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		th.Wait()
+	}
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("4 sends took %s, want at least 60ms", elapsed)
+	}
+}
+
+func TestGroupDigestNotifications(t *testing.T) {
+	var batch []domain.OutboxNotification
+	for i := 0; i < notificationDigestMaxItems+2; i++ {
+		batch = append(batch, domain.OutboxNotification{ID: i, UserID: 1})
+	}
+	batch = append(batch, domain.OutboxNotification{ID: 100, UserID: 2})
+
+	groups := groupDigestNotifications(batch)
+	if len(groups) != 3 {
+		t.Fatalf("got %d groups, want 3", len(groups))
+	}
+	if len(groups[0]) != notificationDigestMaxItems || len(groups[1]) != 2 || len(groups[2]) != 1 {
+		t.Errorf("group sizes = %d, %d, %d", len(groups[0]), len(groups[1]), len(groups[2]))
+	}
+	if groups[2][0].UserID != 2 {
+		t.Errorf("last group belongs to user %d, want 2", groups[2][0].UserID)
+	}
+	if len(groupDigestNotifications(nil)) != 0 {
+		t.Error("empty batch should give no groups")
+	}
+}
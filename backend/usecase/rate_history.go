@@ -0,0 +1,96 @@
+package usecase
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/repository"
+)
+
+// RateHistoryMaxDays — максимальная длина диапазона GET /rates/history.
+const RateHistoryMaxDays = 366
+
+// ParseRatePair разбирает пару вида "RUB/USD" (также "RUB-USD", "rubusd").
+func ParseRatePair(pair string) (string, string, error) {
+	p := strings.ToUpper(strings.TrimSpace(pair))
+	var from, to string
+	switch {
+	case strings.Contains(p, "/"):
+		from, to, _ = strings.Cut(p, "/")
+	case strings.Contains(p, "-"):
+		from, to, _ = strings.Cut(p, "-")
+	case len(p) == 6:
+		from, to = p[:3], p[3:]
+	}
+	from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+	if from == "" || to == "" || from == to {
+		return "", "", fmt.Errorf("invalid pair %q, expected e.g. RUB/USD", pair)
+	}
+	return from, to, nil
+}
+
+// GetDailyRateCandles возвращает дневные OHLC-свечи final_rate пары за дни from..to включительно (UTC).
+func GetDailyRateCandles(currencyFrom, currencyTo string, from, to time.Time) ([]domain.RateCandle, error) {
+	from, to = truncateDayUTC(from), truncateDayUTC(to)
+	if to.Before(from) {
+		return nil, fmt.Errorf("'to' is before 'from'")
+	}
+	if to.Sub(from) > RateHistoryMaxDays*24*time.Hour {
+		return nil, fmt.Errorf("range is longer than %d days", RateHistoryMaxDays)
+	}
+	points, err := repository.GetRateHistory(currencyFrom, currencyTo, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+	return BuildDailyRateCandles(points, from, to), nil
+}
+
+// BuildDailyRateCandles раскладывает обновления курса (по возрастанию времени) по дням from..to.
+// День без обновлений получает плоскую свечу по последнему известному курсу (Updates = 0);
+// дни до первого известного курса пропускаются.
+func BuildDailyRateCandles(points []domain.RatePoint, from, to time.Time) []domain.RateCandle {
+	candles := []domain.RateCandle{}
+	var last *domain.RatePoint
+	i := 0
+	for day := truncateDayUTC(from); !day.After(truncateDayUTC(to)); day = day.AddDate(0, 0, 1) {
+		next := day.AddDate(0, 0, 1)
+		for i < len(points) && points[i].RecordedAt.Before(day) {
+			last = &points[i]
+			i++
+		}
+
+		var c domain.RateCandle
+		if last != nil {
+			c = domain.RateCandle{Open: last.FinalRate, High: last.FinalRate, Low: last.FinalRate, Close: last.FinalRate}
+		}
+		for i < len(points) && points[i].RecordedAt.Before(next) {
+			r := points[i].FinalRate
+			if last == nil && c.Updates == 0 {
+				c.Open, c.High, c.Low = r, r, r
+			}
+			if r.GreaterThan(c.High) {
+				c.High = r
+			}
+			if r.LessThan(c.Low) {
+				c.Low = r
+			}
+			c.Close = r
+			c.Updates++
+			last = &points[i]
+			i++
+		}
+		if last == nil {
+			continue
+		}
+		c.Date = day.Format("2006-01-02")
+		candles = append(candles, c)
+	}
+	return candles
+}
+
+func truncateDayUTC(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,64 @@
+package usecase
+
+import (
+	"testing"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/shopspring/decimal"
+)
+
+func TestBuildDailyRateCandles(t *testing.T) {
+	at := func(day, hour int) time.Time { return time.Date(2026, 10, day, hour, 0, 0, 0, time.UTC) }
+	point := func(ts time.Time, rate string) domain.RatePoint {
+		return domain.RatePoint{RecordedAt: ts, FinalRate: decimal.RequireFromString(rate)}
+	}
+	points := []domain.RatePoint{
+		point(at(9, 12), "99.00"), // до диапазона — курс на начало 10-го
+		point(at(10, 3), "99.50"),
+		point(at(10, 9), "98.70"),
+		point(at(10, 15), "99.20"),
+		point(at(12, 1), "100.10"),
+	}
+
+	got := BuildDailyRateCandles(points, at(10, 0), at(12, 0))
+	want := []struct {
+		date                   string
+		open, high, low, close string
+		updates                int
+	}{
+		{"2026-10-10", "99.00", "99.50", "98.70", "99.20", 3},
+		{"2026-10-11", "99.20", "99.20", "99.20", "99.20", 0},
+		{"2026-10-12", "99.20", "100.10", "99.20", "100.10", 1},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d candles, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		c := got[i]
+		if c.Date != w.date || c.Updates != w.updates ||
+			!c.Open.Equal(decimal.RequireFromString(w.open)) || !c.High.Equal(decimal.RequireFromString(w.high)) ||
+			!c.Low.Equal(decimal.RequireFromString(w.low)) || !c.Close.Equal(decimal.RequireFromString(w.close)) {
+			t.Errorf("%s: got %+v", w.date, c)
+		}
+	}
+
+	// Дни до первого известного курса пропускаются
+	if got := BuildDailyRateCandles(points[4:], at(10, 0), at(12, 0)); len(got) != 1 || !got[0].Open.Equal(decimal.RequireFromString("100.10")) {
+		t.Errorf("без предыстории: got %+v", got)
+	}
+}
+
+func TestParseRatePair(t *testing.T) {
+	for _, in := range []string{"RUB/USD", "rub-usd", " rubusd "} {
+		from, to, err := ParseRatePair(in)
+		if err != nil || from != "RUB" || to != "USD" {
+			t.Errorf("%q: got %s/%s, %v", in, from, to, err)
+		}
+	}
+	for _, in := range []string{"", "RUB", "RUB/RUB", "/USD"} {
+		if _, _, err := ParseRatePair(in); err == nil {
+			t.Errorf("%q: ожидалась ошибка", in)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package usecase
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/ledger"
+	"github.com/djalben/xplr-core/backend/repository"
+	"github.com/djalben/xplr-core/backend/service"
+	"github.com/shopspring/decimal"
+)
+
+// reconciliationTolerance — расхождения меньше цента считаются округлением.
+var reconciliationTolerance = decimal.NewFromFloat(0.01)
+
+// reconciliationHourUTC — час (UTC), в который запускается ночная сверка.
+const reconciliationHourUTC = 3
+
+// walletEffect — как транзакция меняет Кошелёк в валюте a.Currency.
+func walletEffect(a repository.TxAggregate) decimal.Decimal {
+	switch a.Type {
+	case "WALLET_TOPUP", "DEPOSIT", "CARD_REFUND", "WALLET_RECLAIM", "FEE_REFUND",
+		"FX_BUY", "LEGACY_MIGRATION", "ADMIN_CREDIT":
+		return a.Amount
+	case "CARD_ISSUE_FEE", "CARD_TOPUP", "EMERGENCY_FREEZE", "FX_SELL", "ADMIN_DEBIT", "TEAM_WALLET_TOPUP":
+		return a.Amount.Neg()
+	case "REFERRAL_BONUS", "REFERRAL_REVENUE":
+		// Без provider_tx_id — начисление на legacy users.balance_rub (перенесено LEGACY_MIGRATION)
+		if a.HasProviderRef {
+			return a.Amount
+		}
+	case "FUND":
+		// FUND без карты — legacy-пополнение users.balance_rub (ProcessDeposit)
+		if a.CardID > 0 {
+			return a.Amount.Neg()
+		}
+	case "CAPTURE":
+		// CAPTURE без provider_tx_id — legacy-списание users.balance_rub (старый ProcessCardPayment)
+		if a.HasProviderRef {
+			return a.Amount.Neg()
+		}
+	case "REFUND", "CHARGEBACK":
+		if a.HasProviderRef {
+			return a.Amount
+		}
+	}
+	return decimal.Zero
+}
+
+// cardBalanceEffect — как транзакция меняет cards.card_balance (в валюте карты).
+func cardBalanceEffect(a repository.TxAggregate) decimal.Decimal {
+	if a.CardID == 0 {
+		return decimal.Zero
+	}
+	switch a.Type {
+	case "CARD_TOPUP":
+		return a.CardAmount
+	case "STORE_PURCHASE":
+		// Цена в USD (amount), списание с карты — в её валюте (original_amount)
+		return a.CardAmount.Neg()
+	case "FUND", "TEAM_CARD_TOPUP":
+		// TEAM_CARD_TOPUP — пополнение из Кошелька команды, Кошелёк участника не меняется
+		return a.Amount
+	case "CARD_REFUND", "WALLET_RECLAIM", "TEAM_CARD_RECLAIM":
+		// TEAM_CARD_RECLAIM — остаток карты возвращён в Кошелёк команды при передаче карты
+		return a.Amount.Neg()
+	case "REFUND_CARD", "CHARGEBACK_CARD":
+		// Возврат по покупке, оплаченной с баланса карты (repository.CreditCardRefund)
+		return a.Amount
+	}
+	return decimal.Zero
+}
+
+// cardSpentEffect — как транзакция меняет cards.spent_from_wallet (Bridge).
+func cardSpentEffect(a repository.TxAggregate) decimal.Decimal {
+	if a.CardID == 0 || !a.HasProviderRef {
+		return decimal.Zero
+	}
+	switch a.Type {
+	case "CAPTURE":
+		return a.Amount
+	case "REFUND", "CHARGEBACK":
+		return a.Amount.Neg()
+	}
+	return decimal.Zero
+}
+
+// walletKey — Кошелёк пользователя в одной валюте.
+type walletKey struct {
+	userID   int
+	currency string
+}
+
+func newWalletKey(userID int, currency string) walletKey {
+	if currency == "" {
+		currency = repository.WalletCurrency
+	}
+	return walletKey{userID: userID, currency: currency}
+}
+
+// kind — вид расхождения: "wallet" для USD, "wallet_eur" и т.п. для остальных валют.
+func (k walletKey) kind() string {
+	if k.currency == repository.WalletCurrency {
+		return "wallet"
+	}
+	return "wallet_" + strings.ToLower(k.currency)
+}
+
+// computeDrifts сравнивает сохранённые балансы с пересчитанными из транзакций.
+func computeDrifts(aggs []repository.TxAggregate, wallets []repository.StoredWallet, cards []repository.StoredCard) []domain.ReconciliationDrift {
+	expectedWallet := make(map[walletKey]decimal.Decimal)
+	expectedCard := make(map[int]decimal.Decimal)
+	expectedSpent := make(map[int]decimal.Decimal)
+	for _, a := range aggs {
+		k := newWalletKey(a.UserID, a.Currency)
+		expectedWallet[k] = expectedWallet[k].Add(walletEffect(a))
+		if a.CardID > 0 {
+			expectedCard[a.CardID] = expectedCard[a.CardID].Add(cardBalanceEffect(a))
+			expectedSpent[a.CardID] = expectedSpent[a.CardID].Add(cardSpentEffect(a))
+		}
+	}
+
+	var drifts []domain.ReconciliationDrift
+	check := func(kind string, userID, cardID int, expected, actual decimal.Decimal) {
+		diff := actual.Sub(expected)
+		if diff.Abs().GreaterThanOrEqual(reconciliationTolerance) {
+			drifts = append(drifts, domain.ReconciliationDrift{
+				Kind: kind, UserID: userID, CardID: cardID,
+				Expected: expected, Actual: actual, Drift: diff,
+			})
+		}
+	}
+
+	seenWallet := make(map[walletKey]bool)
+	for _, w := range wallets {
+		k := newWalletKey(w.UserID, w.Currency)
+		seenWallet[k] = true
+		check(k.kind(), w.UserID, 0, expectedWallet[k], w.MasterBalance)
+	}
+	// Движение по Кошельку есть, а записи internal_balances / wallet_balances нет
+	for k, exp := range expectedWallet {
+		if !seenWallet[k] {
+			check(k.kind(), k.userID, 0, exp, decimal.Zero)
+		}
+	}
+
+	for _, c := range cards {
+		check("card_balance", c.UserID, c.CardID, expectedCard[c.CardID], c.CardBalance)
+		// spent_from_wallet при возврате не уходит ниже нуля (GREATEST), поэтому сравниваем с тем же правилом
+		check("card_spent", c.UserID, c.CardID, decimal.Max(expectedSpent[c.CardID], decimal.Zero), c.SpentFromWallet)
+	}
+
+	sort.SliceStable(drifts, func(i, j int) bool {
+		return drifts[i].Drift.Abs().GreaterThan(drifts[j].Drift.Abs())
+	})
+	return drifts
+}
+
+// ledgerDrifts сравнивает сохранённые балансы Кошельков и карт с журналом двойной записи.
+// Виды расхождений: "ledger_wallet" (USD), "ledger_wallet_eur" и т.п., "ledger_card".
+func ledgerDrifts(balances []repository.LedgerAccountBalance) []domain.ReconciliationDrift {
+	var drifts []domain.ReconciliationDrift
+	for _, b := range balances {
+		diff := b.Stored.Sub(b.Journal)
+		if diff.Abs().LessThan(reconciliationTolerance) {
+			continue
+		}
+		d := domain.ReconciliationDrift{
+			Kind: "ledger_" + newWalletKey(b.UserID, b.Currency).kind(), UserID: b.UserID,
+			Expected: b.Journal, Actual: b.Stored, Drift: diff,
+		}
+		if b.Account.Type == ledger.AccountCard {
+			d.Kind, d.CardID = "ledger_card", b.Account.CardID
+		}
+		drifts = append(drifts, d)
+	}
+	return drifts
+}
+
+// RunReconciliation пересчитывает балансы Кошельков и карт из transactions и сверяет их с журналом,
+// сохраняет расхождения и уведомляет админов, если они есть.
+func RunReconciliation() (*domain.ReconciliationRun, []domain.ReconciliationDrift, error) {
+	log.Println("[RECONCILE] Starting balance reconciliation...")
+	run := &domain.ReconciliationRun{StartedAt: time.Now()}
+
+	aggs, err := repository.GetTransactionAggregates()
+	if err != nil {
+		return nil, nil, err
+	}
+	wallets, err := repository.GetStoredWallets()
+	if err != nil {
+		return nil, nil, err
+	}
+	cards, err := repository.GetStoredCards()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	journal, err := repository.GetLedgerAccountBalances()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	drifts := append(computeDrifts(aggs, wallets, cards), ledgerDrifts(journal)...)
+	sort.SliceStable(drifts, func(i, j int) bool {
+		return drifts[i].Drift.Abs().GreaterThan(drifts[j].Drift.Abs())
+	})
+	finished := time.Now()
+	run.FinishedAt = &finished
+	run.WalletsChecked = len(wallets)
+	run.CardsChecked = len(cards)
+
+	if err := repository.SaveReconciliationRun(run, drifts); err != nil {
+		return nil, nil, err
+	}
+
+	log.Printf("[RECONCILE] ✅ Run #%d: %d wallets, %d cards checked, %d drifts",
+		run.ID, run.WalletsChecked, run.CardsChecked, len(drifts))
+
+	if len(drifts) > 0 {
+		go service.NotifyAdmins("Расхождение балансов", reconciliationAlert(run, drifts))
+	}
+	return run, drifts, nil
+}
+
+// reconciliationAlert формирует сообщение админам (не более 10 строк расхождений).
+func reconciliationAlert(run *domain.ReconciliationRun, drifts []domain.ReconciliationDrift) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "⚖️ <b>Сверка балансов #%d</b>\n\n"+
+		"Найдено расхождений: <b>%d</b>\n"+
+		"Проверено кошельков: %d, карт: %d\n\n",
+		run.ID, len(drifts), run.WalletsChecked, run.CardsChecked)
+	for i, d := range drifts {
+		if i == 10 {
+			fmt.Fprintf(&b, "…и ещё %d\n", len(drifts)-10)
+			break
+		}
+		target := fmt.Sprintf("user #%d", d.UserID)
+		if d.CardID > 0 {
+			target = fmt.Sprintf("card #%d (user #%d)", d.CardID, d.UserID)
+		}
+		fmt.Fprintf(&b, "• %s %s: ожидалось %s, в БД %s (Δ %s)\n",
+			d.Kind, target, d.Expected.StringFixed(2), d.Actual.StringFixed(2), d.Drift.StringFixed(2))
+	}
+	b.WriteString("\n<a href=\"https://xplr.pro/admin/reconciliation\">Открыть отчёт</a>")
+	return b.String()
+}
+
+// nextReconciliationAt — ближайший момент запуска ночной сверки после now.
+func nextReconciliationAt(now time.Time) time.Time {
+	now = now.UTC()
+	next := time.Date(now.Year(), now.Month(), now.Day(), reconciliationHourUTC, 0, 0, 0, time.UTC)
+	if !next.After(now) {
+		next = next.Add(24 * time.Hour)
+	}
+	return next
+}
+
+// StartReconciliationWorker — фоновый процесс: каждую ночь в 03:00 UTC сверяет балансы.
+func StartReconciliationWorker() {
+	log.Println("[RECONCILE] Starting reconciliation worker...")
+
+	go func() {
+		for {
+			next := nextReconciliationAt(time.Now())
+			log.Printf("[RECONCILE] Next run at %s", next.Format(time.RFC3339))
+			time.Sleep(time.Until(next))
+			if _, _, err := RunReconciliation(); err != nil {
+				log.Printf("[RECONCILE] ❌ Run failed: %v", err)
+			}
+		}
+	}()
+
+	log.Printf("[RECONCILE] Reconciliation worker started (daily at %02d:00 UTC)", reconciliationHourUTC)
+}
@@ -0,0 +1,137 @@
+package usecase
+
+import (
+	"testing"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/ledger"
+	"github.com/djalben/xplr-core/backend/repository"
+	"github.com/shopspring/decimal"
+)
+
+func dec(s string) decimal.Decimal { return decimal.RequireFromString(s) }
+
+func TestComputeDriftsClean(t *testing.T) {
+	aggs := []repository.TxAggregate{
+		{UserID: 1, Type: "WALLET_TOPUP", Amount: dec("100"), CardAmount: dec("100")},
+		{UserID: 1, Type: "CARD_ISSUE_FEE", Amount: dec("6.70"), CardAmount: dec("6.70")},
+		{UserID: 1, CardID: 10, Type: "CARD_TOPUP", Amount: dec("21.60"), CardAmount: dec("20")},
+		{UserID: 1, CardID: 10, Type: "STORE_PURCHASE", Amount: dec("5"), CardAmount: dec("5")},
+		{UserID: 1, CardID: 10, Type: "CAPTURE", HasProviderRef: true, Amount: dec("10"), CardAmount: dec("10")},
+		{UserID: 1, CardID: 10, Type: "REFUND", HasProviderRef: true, Amount: dec("4"), CardAmount: dec("4")},
+		{UserID: 1, CardID: 10, Type: "CHARGEBACK", HasProviderRef: true, Amount: dec("2"), CardAmount: dec("2")},
+		{UserID: 1, CardID: 10, Type: "REFUND_CARD", HasProviderRef: true, Amount: dec("1"), CardAmount: dec("1")},
+		// legacy balance_rub — не влияет на Кошелёк
+		{UserID: 1, CardID: 10, Type: "CAPTURE", Amount: dec("999"), CardAmount: dec("999")},
+		{UserID: 1, Type: "FUND", Amount: dec("500"), CardAmount: dec("500")},
+		// Кошелёк команды: взнос списывается с Кошелька, пополнение карты из Кошелька команды его не трогает
+		{UserID: 1, Type: "TEAM_WALLET_TOPUP", Amount: dec("10"), CardAmount: dec("10")},
+		{UserID: 1, CardID: 10, Type: "TEAM_CARD_TOPUP", Amount: dec("3"), CardAmount: dec("3")},
+		// Передача карты с возвратом остатка в Кошелёк команды
+		{UserID: 1, CardID: 10, Type: "TEAM_CARD_RECLAIM", Amount: dec("2"), CardAmount: dec("2")},
+	}
+	wallets := []repository.StoredWallet{{UserID: 1, MasterBalance: dec("57.70")}}
+	cards := []repository.StoredCard{{CardID: 10, UserID: 1, CardBalance: dec("17"), SpentFromWallet: dec("4")}}
+
+	if drifts := computeDrifts(aggs, wallets, cards); len(drifts) != 0 {
+		t.Fatalf("ожидалось 0 расхождений, получено %d: %+v", len(drifts), drifts)
+	}
+}
+
+func TestComputeDriftsDetectsDivergence(t *testing.T) {
+	// Сценарий сломанного refund в UpgradeTierHandler: комиссия списана, в Кошелёк вернулось больше
+	aggs := []repository.TxAggregate{
+		{UserID: 2, Type: "WALLET_TOPUP", Amount: dec("100")},
+		{UserID: 2, Type: "CARD_ISSUE_FEE", Amount: dec("50")},
+	}
+	wallets := []repository.StoredWallet{{UserID: 2, MasterBalance: dec("100.55")}}
+	cards := []repository.StoredCard{{CardID: 20, UserID: 2, CardBalance: dec("3"), SpentFromWallet: dec("0")}}
+
+	drifts := computeDrifts(aggs, wallets, cards)
+	if len(drifts) != 2 {
+		t.Fatalf("ожидалось 2 расхождения, получено %d: %+v", len(drifts), drifts)
+	}
+	if drifts[0].Kind != "wallet" || !drifts[0].Drift.Equal(dec("50.55")) {
+		t.Errorf("первое расхождение: %+v", drifts[0])
+	}
+	if drifts[1].Kind != "card_balance" || drifts[1].CardID != 20 || !drifts[1].Drift.Equal(dec("3")) {
+		t.Errorf("второе расхождение: %+v", drifts[1])
+	}
+}
+
+func TestComputeDriftsMultiCurrency(t *testing.T) {
+	aggs := []repository.TxAggregate{
+		{UserID: 5, Type: "WALLET_TOPUP", Amount: dec("100")},
+		{UserID: 5, Type: "WALLET_TOPUP", Currency: "RUB", Amount: dec("5000")},
+		{UserID: 5, Type: "FX_SELL", Amount: dec("40")},
+		{UserID: 5, Type: "FX_BUY", Currency: "EUR", Amount: dec("37.12")},
+		{UserID: 5, CardID: 50, Type: "CARD_TOPUP", Currency: "EUR", Amount: dec("30"), CardAmount: dec("30")},
+		// Покупка в магазине по цене в USD с EUR-карты: карта уменьшается на original_amount
+		{UserID: 5, CardID: 50, Type: "STORE_PURCHASE", Amount: dec("10"), CardAmount: dec("9.20")},
+		{UserID: 5, Type: "LEGACY_MIGRATION", Amount: dec("12.50")},
+		{UserID: 5, Type: "REFERRAL_BONUS", HasProviderRef: true, Amount: dec("5")},
+		// начисление на legacy users.balance_rub — уже учтено в LEGACY_MIGRATION
+		{UserID: 5, Type: "REFERRAL_BONUS", Amount: dec("5")},
+	}
+	wallets := []repository.StoredWallet{
+		{UserID: 5, MasterBalance: dec("77.50")},
+		{UserID: 5, Currency: "RUB", MasterBalance: dec("5000")},
+		{UserID: 5, Currency: "EUR", MasterBalance: dec("9.12")},
+	}
+	cards := []repository.StoredCard{{CardID: 50, UserID: 5, CardBalance: dec("20.80")}}
+
+	drifts := computeDrifts(aggs, wallets, cards)
+	if len(drifts) != 1 {
+		t.Fatalf("ожидалось 1 расхождение, получено %d: %+v", len(drifts), drifts)
+	}
+	if drifts[0].Kind != "wallet_eur" || !drifts[0].Drift.Equal(dec("2")) {
+		t.Errorf("расхождение EUR кошелька: %+v", drifts[0])
+	}
+}
+
+func TestComputeDriftsMissingWallet(t *testing.T) {
+	aggs := []repository.TxAggregate{{UserID: 3, Type: "DEPOSIT", HasProviderRef: true, Amount: dec("10")}}
+	drifts := computeDrifts(aggs, nil, nil)
+	if len(drifts) != 1 || drifts[0].UserID != 3 || !drifts[0].Drift.Equal(dec("-10")) {
+		t.Fatalf("ожидалось расхождение по отсутствующему кошельку: %+v", drifts)
+	}
+}
+
+func TestComputeDriftsIgnoresRounding(t *testing.T) {
+	aggs := []repository.TxAggregate{{UserID: 4, Type: "WALLET_TOPUP", Amount: dec("10.004")}}
+	wallets := []repository.StoredWallet{{UserID: 4, MasterBalance: dec("10.00")}}
+	if drifts := computeDrifts(aggs, wallets, nil); len(drifts) != 0 {
+		t.Fatalf("расхождение меньше цента не должно попадать в отчёт: %+v", drifts)
+	}
+}
+
+func TestLedgerDrifts(t *testing.T) {
+	balances := []repository.LedgerAccountBalance{
+		{Account: ledger.UserWallet(1), UserID: 1, Currency: "USD", Stored: dec("50"), Journal: dec("50")},
+		// Пополнение мимо журнала
+		{Account: ledger.UserWallet(1), UserID: 1, Currency: "EUR", Stored: dec("12"), Journal: dec("10")},
+		{Account: ledger.Card(10), UserID: 1, Currency: "USD", Stored: dec("3"), Journal: dec("5")},
+		{Account: ledger.Card(11), UserID: 2, Currency: "USD", Stored: dec("1.004"), Journal: dec("1")},
+	}
+	drifts := ledgerDrifts(balances)
+	if len(drifts) != 2 {
+		t.Fatalf("ожидалось 2 расхождения, получено %d: %+v", len(drifts), drifts)
+	}
+	if d := drifts[0]; d.Kind != "ledger_wallet_eur" || d.UserID != 1 || !d.Drift.Equal(dec("2")) || !d.Expected.Equal(dec("10")) {
+		t.Errorf("Кошелёк EUR: %+v", d)
+	}
+	if d := drifts[1]; d.Kind != "ledger_card" || d.CardID != 10 || d.UserID != 1 || !d.Drift.Equal(dec("-2")) {
+		t.Errorf("карта: %+v", d)
+	}
+}
+
+func TestNextReconciliationAt(t *testing.T) {
+	before := time.Date(2026, 3, 1, 1, 30, 0, 0, time.UTC)
+	if got := nextReconciliationAt(before); !got.Equal(time.Date(2026, 3, 1, 3, 0, 0, 0, time.UTC)) {
+		t.Errorf("до 03:00: %v", got)
+	}
+	after := time.Date(2026, 3, 1, 3, 0, 0, 0, time.UTC)
+	if got := nextReconciliationAt(after); !got.Equal(time.Date(2026, 3, 2, 3, 0, 0, 0, time.UTC)) {
+		t.Errorf("ровно в 03:00: %v", got)
+	}
+}
@@ -0,0 +1,352 @@
+package usecase
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/repository"
+	"github.com/djalben/xplr-core/backend/service"
+	"github.com/shopspring/decimal"
+)
+
+// ScheduledTransferMaxAttempts — сколько раз выполняется один срок правила, прежде чем он считается проваленным.
+const ScheduledTransferMaxAttempts = 3
+
+// scheduledTransferRetryDelays — пауза перед 2-й и 3-й попыткой.
+var scheduledTransferRetryDelays = []time.Duration{5 * time.Minute, 30 * time.Minute}
+
+var (
+	// ErrScheduledTransferInvalid — правило заполнено неверно (сумма, расписание, часовой пояс, валюта).
+	ErrScheduledTransferInvalid = errors.New("invalid scheduled transfer")
+	// ErrScheduledTransferForbidden — у пользователя нет права пополнять эту карту или карты команды.
+	ErrScheduledTransferForbidden = errors.New("no access to the card or team")
+)
+
+// CreateScheduledTransfer проверяет и сохраняет правило перевода по расписанию.
+// Карта должна принадлежать пользователю либо её команде, где у него есть право team.manage;
+// для правила команды у пользователя должно быть право team.manage. Те же права проверяются
+// перед каждым выполнением (checkScheduledTransferAccess).
+func CreateScheduledTransfer(t *domain.ScheduledTransfer) error {
+	if (t.CardID == nil) == (t.TeamID == nil) {
+		return fmt.Errorf("%w: set either card_id or team_id", ErrScheduledTransferInvalid)
+	}
+	if !t.Amount.IsPositive() {
+		return fmt.Errorf("%w: amount must be positive", ErrScheduledTransferInvalid)
+	}
+	t.Amount = t.Amount.Round(2)
+	schedule, err := ParseCronSchedule(t.Schedule)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrScheduledTransferInvalid, err)
+	}
+	t.Schedule = strings.TrimSpace(t.Schedule)
+	if t.Timezone = strings.TrimSpace(t.Timezone); t.Timezone == "" {
+		t.Timezone = "UTC"
+	}
+	loc, err := time.LoadLocation(t.Timezone)
+	if err != nil {
+		return fmt.Errorf("%w: unknown timezone %q", ErrScheduledTransferInvalid, t.Timezone)
+	}
+	if t.FromCurrency = strings.TrimSpace(t.FromCurrency); t.FromCurrency != "" {
+		if t.FromCurrency, err = repository.NormalizeWalletCurrency(t.FromCurrency); err != nil {
+			return fmt.Errorf("%w: %v", ErrScheduledTransferInvalid, err)
+		}
+	}
+
+	if t.CardID != nil {
+		card, err := repository.GetCardByID(*t.CardID)
+		if err != nil || !canFundCard(t.UserID, card) {
+			return ErrScheduledTransferForbidden
+		}
+		t.Currency = card.Currency
+	} else {
+		if !canFundTeamCards(t.UserID, *t.TeamID) {
+			return ErrScheduledTransferForbidden
+		}
+	}
+	if t.Currency == "" {
+		t.Currency = repository.WalletCurrency
+	}
+	if t.Currency, err = repository.NormalizeWalletCurrency(t.Currency); err != nil {
+		return fmt.Errorf("%w: %v", ErrScheduledTransferInvalid, err)
+	}
+
+	t.NextRunAt = schedule.Next(time.Now(), loc)
+	if t.NextRunAt.IsZero() {
+		return fmt.Errorf("%w: schedule never fires", ErrScheduledTransferInvalid)
+	}
+	t.IsActive = true
+	if err := repository.CreateScheduledTransfer(t); err != nil {
+		return err
+	}
+	log.Printf("[SCHEDULED-TRANSFERS] User %d created rule %d: %s %s on %q (%s), next run %s",
+		t.UserID, t.ID, t.Amount.StringFixed(2), t.Currency, t.Schedule, t.Timezone, t.NextRunAt.Format(time.RFC3339))
+	return nil
+}
+
+// canFundTeamCards — может ли пользователь пополнять из своего Кошелька карты всех участников команды.
+// TransferWalletToCard пускает к чужим картам команды только с правом team.manage.
+func canFundTeamCards(userID, teamID int) bool {
+	canManage, err := repository.HasTeamPermission(teamID, userID, domain.PermTeamManage)
+	return err == nil && canManage
+}
+
+// checkScheduledTransferAccess перепроверяет права владельца правила на момент выполнения:
+// участник мог потерять роль или карту могли передать другому.
+func checkScheduledTransferAccess(t *domain.ScheduledTransfer, cards []domain.Card) error {
+	if t.TeamID != nil && !canFundTeamCards(t.UserID, *t.TeamID) {
+		return ErrScheduledTransferForbidden
+	}
+	for _, card := range cards {
+		if !canFundCard(t.UserID, card) {
+			return fmt.Errorf("%w: card *%s", ErrScheduledTransferForbidden, card.Last4Digits)
+		}
+	}
+	return nil
+}
+
+// canFundCard — может ли пользователь пополнять карту: своя карта или карта команды, где у него есть право team.manage.
+func canFundCard(userID int, card domain.Card) bool {
+	if card.UserID == userID {
+		return true
+	}
+	if card.TeamID == nil {
+		return false
+	}
+	canManage, err := repository.HasTeamPermission(*card.TeamID, userID, domain.PermTeamManage)
+	return err == nil && canManage
+}
+
+// SetScheduledTransferActive ставит правило на паузу или возобновляет его со следующего срока.
+func SetScheduledTransferActive(ruleID, userID int, active bool) (*domain.ScheduledTransfer, error) {
+	t, err := repository.GetScheduledTransfer(ruleID, userID)
+	if err != nil {
+		return nil, err
+	}
+	next := t.NextRunAt
+	if active {
+		// Правило могло быть приостановлено из-за потери доступа — включить его можно только с правами
+		var cards []domain.Card
+		if t.CardID != nil {
+			card, err := repository.GetCardByID(*t.CardID)
+			if err != nil {
+				return nil, ErrScheduledTransferForbidden
+			}
+			cards = append(cards, card)
+		}
+		if err := checkScheduledTransferAccess(t, cards); err != nil {
+			return nil, err
+		}
+		if next, err = nextScheduledRun(t, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	if err := repository.SetScheduledTransferActive(ruleID, userID, active, next); err != nil {
+		return nil, err
+	}
+	return repository.GetScheduledTransfer(ruleID, userID)
+}
+
+// nextScheduledRun — следующий срок правила после after.
+func nextScheduledRun(t *domain.ScheduledTransfer, after time.Time) (time.Time, error) {
+	schedule, err := ParseCronSchedule(t.Schedule)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc, err := time.LoadLocation(t.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	next := schedule.Next(after, loc)
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("schedule %q never fires", t.Schedule)
+	}
+	return next, nil
+}
+
+// SplitScheduledAmount делит сумму поровну на n карт с точностью до цента; остаток достаётся последней карте.
+func SplitScheduledAmount(total decimal.Decimal, n int) []decimal.Decimal {
+	if n <= 0 {
+		return nil
+	}
+	share := total.Div(decimal.NewFromInt(int64(n))).RoundDown(2)
+	parts := make([]decimal.Decimal, n)
+	for i := range parts {
+		parts[i] = share
+	}
+	parts[n-1] = total.Sub(share.Mul(decimal.NewFromInt(int64(n - 1))))
+	return parts
+}
+
+// ProcessScheduledTransfers выполняет все правила, срок которых наступил.
+func ProcessScheduledTransfers() {
+	rules, err := repository.ClaimDueScheduledTransfers(50)
+	if err != nil {
+		log.Printf("[SCHEDULED-TRANSFERS] ❌ Failed to claim due rules: %v", err)
+		return
+	}
+	for i := range rules {
+		runScheduledTransfer(&rules[i])
+	}
+}
+
+// runScheduledTransfer выполняет один срок правила. Карты, уже пополненные по этому сроку,
+// пропускаются, поэтому повтор после частичного сбоя не переводит деньги дважды.
+func runScheduledTransfer(t *domain.ScheduledTransfer) {
+	scheduledFor := t.NextRunAt
+	if t.PendingRunFor != nil {
+		scheduledFor = *t.PendingRunFor
+	}
+	attempt := t.Attempt + 1
+
+	cards, amounts, err := scheduledTransferTargets(t)
+	if err == nil {
+		err = checkScheduledTransferAccess(t, cards)
+	}
+	if errors.Is(err, ErrScheduledTransferForbidden) {
+		// Повторы не помогут: правило ставится на паузу, пока владелец не вернёт доступ и не включит его
+		log.Printf("[SCHEDULED-TRANSFERS] ⛔ Rule %d (user %d): %v — pausing", t.ID, t.UserID, err)
+		paused := &domain.UserNotification{
+			UserID:   t.UserID,
+			Template: domain.NotifyScheduledTransferPaused,
+			Data:     map[string]string{"rule_id": strconv.Itoa(t.ID)},
+		}
+		if fErr := repository.FinishScheduledTransfer(t.ID, time.Now(), nil, 0, domain.ScheduledTransferFailed, err.Error(), paused); fErr != nil {
+			log.Printf("[SCHEDULED-TRANSFERS] ❌ Rule %d: failed to save state: %v", t.ID, fErr)
+		}
+		repository.SetScheduledTransferActive(t.ID, t.UserID, false, time.Now())
+		return
+	}
+	var failures []string
+	funded := 0
+	if err == nil {
+		done, dErr := repository.FundedScheduledCards(t.ID, scheduledFor)
+		if dErr != nil {
+			err = dErr
+		}
+		for i, card := range cards {
+			if err != nil {
+				break
+			}
+			if done[card.ID] {
+				continue
+			}
+			run := domain.ScheduledTransferRun{
+				RuleID: t.ID, ScheduledFor: scheduledFor, Attempt: attempt,
+				CardID: card.ID, Amount: amounts[i], Currency: t.Currency, Status: domain.ScheduledTransferSuccess,
+			}
+			if _, tErr := repository.TransferWalletToCard(t.UserID, card.ID, amounts[i], t.FromCurrency, "", service.CardFundFunc(card.ID), nil); tErr != nil {
+				run.Status, run.Error = domain.ScheduledTransferFailed, tErr.Error()
+				failures = append(failures, fmt.Sprintf("*%s: %s", card.Last4Digits, tErr.Error()))
+			} else {
+				funded++
+			}
+			if rErr := repository.RecordScheduledTransferRun(&run); rErr != nil {
+				log.Printf("[SCHEDULED-TRANSFERS] ⚠️ Rule %d: failed to log run for card %d: %v", t.ID, card.ID, rErr)
+			}
+		}
+	}
+	if err != nil {
+		failures = append(failures, err.Error())
+	}
+
+	now := time.Now()
+	next, nErr := nextScheduledRun(t, now)
+	if nErr != nil {
+		// Правило с невыполнимым расписанием ставим на паузу, чтобы воркер не брал его каждую минуту
+		log.Printf("[SCHEDULED-TRANSFERS] ❌ Rule %d: %v — pausing", t.ID, nErr)
+		repository.SetScheduledTransferActive(t.ID, t.UserID, false, now)
+		return
+	}
+
+	status, errMsg := domain.ScheduledTransferSuccess, strings.Join(failures, "; ")
+	var pending *time.Time
+	nextAttempt := 0
+	switch {
+	case len(failures) == 0:
+	case attempt < ScheduledTransferMaxAttempts:
+		status = domain.ScheduledTransferRetry
+		if funded > 0 {
+			status = domain.ScheduledTransferPartial
+		}
+		pending, nextAttempt = &scheduledFor, attempt
+		next = now.Add(scheduledTransferRetryDelays[attempt-1])
+	default:
+		status = domain.ScheduledTransferFailed
+	}
+
+	report := scheduledTransferNotification(t, status, attempt, next, failures, cards, amounts)
+	if err := repository.FinishScheduledTransfer(t.ID, next, pending, nextAttempt, status, errMsg, report); err != nil {
+		log.Printf("[SCHEDULED-TRANSFERS] ❌ Rule %d: failed to save state: %v", t.ID, err)
+	}
+	log.Printf("[SCHEDULED-TRANSFERS] Rule %d (user %d) run for %s, attempt %d: %s, %d card(s) funded, next %s",
+		t.ID, t.UserID, scheduledFor.Format(time.RFC3339), attempt, status, funded, next.Format(time.RFC3339))
+}
+
+// scheduledTransferTargets — карты правила и сумма на каждую.
+func scheduledTransferTargets(t *domain.ScheduledTransfer) ([]domain.Card, []decimal.Decimal, error) {
+	if t.CardID != nil {
+		card, err := repository.GetCardByID(*t.CardID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("card %d not found", *t.CardID)
+		}
+		return []domain.Card{card}, []decimal.Decimal{t.Amount}, nil
+	}
+	cards, err := repository.GetTeamFundableCards(*t.TeamID, t.Currency)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load team cards: %v", err)
+	}
+	if len(cards) == 0 {
+		return nil, nil, fmt.Errorf("team has no active %s cards", t.Currency)
+	}
+	return cards, SplitScheduledAmount(t.Amount, len(cards)), nil
+}
+
+// scheduledTransferNotification — уведомление владельцу правила о результате выполнения (шаблон scheduled_transfer.*).
+func scheduledTransferNotification(t *domain.ScheduledTransfer, status string, attempt int, next time.Time, failures []string, cards []domain.Card, amounts []decimal.Decimal) *domain.UserNotification {
+	if loc, err := time.LoadLocation(t.Timezone); err == nil {
+		next = next.In(loc)
+	}
+	data := map[string]string{
+		"rule_id": strconv.Itoa(t.ID),
+		"next":    next.Format("02.01.2006 15:04 MST"),
+	}
+	var templateID string
+	switch status {
+	case domain.ScheduledTransferSuccess:
+		templateID = domain.NotifyScheduledTransferDone
+		var lines []string
+		for i, c := range cards {
+			lines = append(lines, fmt.Sprintf("*%s — %s %s", c.Last4Digits, amounts[i].StringFixed(2), t.Currency))
+		}
+		data["cards"] = strings.Join(lines, "\n")
+	case domain.ScheduledTransferFailed:
+		templateID = domain.NotifyScheduledTransferFailed
+		data["attempts"] = strconv.Itoa(attempt)
+		data["errors"] = strings.Join(failures, "\n")
+	default:
+		templateID = domain.NotifyScheduledTransferRetry
+		data["errors"] = strings.Join(failures, "\n")
+		data["attempt"] = strconv.Itoa(attempt + 1)
+		data["max_attempts"] = strconv.Itoa(ScheduledTransferMaxAttempts)
+	}
+	return &domain.UserNotification{UserID: t.UserID, Template: templateID, Data: data}
+}
+
+// StartScheduledTransferWorker — фоновый процесс: каждую минуту выполняет наступившие переводы по расписанию.
+func StartScheduledTransferWorker() {
+	log.Println("[SCHEDULED-TRANSFERS] Starting scheduled transfer worker...")
+
+	ticker := time.NewTicker(1 * time.Minute)
+	go func() {
+		for range ticker.C {
+			ProcessScheduledTransfers()
+		}
+	}()
+
+	log.Println("[SCHEDULED-TRANSFERS] Scheduled transfer worker started (checking every minute)")
+}
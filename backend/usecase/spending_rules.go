@@ -0,0 +1,306 @@
+package usecase
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/repository"
+	"github.com/shopspring/decimal"
+)
+
+// SpendingAttempt — то, что известно об авторизации на момент проверки правил.
+// Пустые MCC/Country означают «эмитент не передал»: запрещающие правила по ним не срабатывают,
+// разрешающие — отклоняют (проверить соответствие нельзя).
+type SpendingAttempt struct {
+	MerchantName string
+	MCC          string
+	Country      string
+	Amount       decimal.Decimal
+	At           time.Time
+}
+
+// RuleDecline — правило, отклонившее авторизацию, и человекочитаемая причина.
+type RuleDecline struct {
+	Rule   domain.SpendingRule
+	Reason string
+}
+
+// MerchantSpendFunc возвращает потраченное за текущий месяц у мерчантов, подходящих под правило.
+type MerchantSpendFunc func(rule domain.SpendingRule) (decimal.Decimal, error)
+
+// matchMerchantPattern сравнивает название мерчанта с шаблоном без учёта регистра.
+// '*' — любая последовательность символов; шаблон без '*' ищется как подстрока.
+func matchMerchantPattern(pattern, name string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	name = strings.ToLower(strings.TrimSpace(name))
+	if pattern == "" {
+		return false
+	}
+	if !strings.Contains(pattern, "*") {
+		return strings.Contains(name, pattern)
+	}
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(name, parts[0]) {
+		return false
+	}
+	name = name[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(name, part)
+		if i < 0 {
+			return false
+		}
+		name = name[i+len(part):]
+	}
+	return strings.HasSuffix(name, last)
+}
+
+// splitRuleList разбивает значение правила "a, b c" на элементы.
+func splitRuleList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' '
+	})
+}
+
+// matchMCC проверяет MCC по списку кодов и диапазонов ("5411, 5960-5969").
+func matchMCC(list, mcc string) bool {
+	code, err := strconv.Atoi(strings.TrimSpace(mcc))
+	if err != nil {
+		return false
+	}
+	for _, item := range splitRuleList(list) {
+		lo, hi, isRange := strings.Cut(item, "-")
+		from, err := strconv.Atoi(lo)
+		if err != nil {
+			continue
+		}
+		to := from
+		if isRange {
+			if to, err = strconv.Atoi(hi); err != nil {
+				continue
+			}
+		}
+		if code >= from && code <= to {
+			return true
+		}
+	}
+	return false
+}
+
+// matchCountry проверяет ISO-код страны по списку.
+func matchCountry(list, country string) bool {
+	country = strings.TrimSpace(country)
+	if country == "" {
+		return false
+	}
+	for _, item := range splitRuleList(list) {
+		if strings.EqualFold(item, country) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseClock разбирает "HH:MM" в минуты от начала суток.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// inTimeWindow проверяет, попадает ли момент в окно "HH:MM-HH:MM" (окно может переходить через полночь).
+func inTimeWindow(rule domain.SpendingRule, at time.Time) (bool, error) {
+	from, to, ok := strings.Cut(rule.Value, "-")
+	if !ok {
+		return false, fmt.Errorf("time window must be HH:MM-HH:MM")
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return false, err
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return false, err
+	}
+	loc := time.UTC
+	if rule.Timezone != "" {
+		if loc, err = time.LoadLocation(rule.Timezone); err != nil {
+			return false, err
+		}
+	}
+	local := at.In(loc)
+	now := local.Hour()*60 + local.Minute()
+	if start <= end {
+		return now >= start && now < end, nil
+	}
+	return now >= start || now < end, nil
+}
+
+// ValidateSpendingRule проверяет тип и значение правила до сохранения.
+func ValidateSpendingRule(rule domain.SpendingRule) error {
+	switch rule.RuleType {
+	case domain.RuleMerchantAllow, domain.RuleMerchantDeny:
+		if strings.TrimSpace(rule.Value) == "" {
+			return fmt.Errorf("merchant pattern is required")
+		}
+	case domain.RuleMCCAllow, domain.RuleMCCDeny:
+		items := splitRuleList(rule.Value)
+		if len(items) == 0 {
+			return fmt.Errorf("at least one MCC is required")
+		}
+		for _, item := range items {
+			lo, hi, isRange := strings.Cut(item, "-")
+			if _, err := strconv.Atoi(lo); err != nil {
+				return fmt.Errorf("invalid MCC %q", item)
+			}
+			if _, err := strconv.Atoi(hi); isRange && err != nil {
+				return fmt.Errorf("invalid MCC range %q", item)
+			}
+		}
+	case domain.RuleCountryAllow, domain.RuleCountryDeny:
+		items := splitRuleList(rule.Value)
+		if len(items) == 0 {
+			return fmt.Errorf("at least one country code is required")
+		}
+		for _, item := range items {
+			if len(item) != 2 {
+				return fmt.Errorf("invalid country code %q (ISO 3166 alpha-2 expected)", item)
+			}
+		}
+	case domain.RuleMerchantCap:
+		if strings.TrimSpace(rule.Value) == "" {
+			return fmt.Errorf("merchant pattern is required")
+		}
+		if !rule.MonthlyCap.GreaterThan(decimal.Zero) {
+			return fmt.Errorf("monthly_cap must be positive")
+		}
+	case domain.RuleTimeWindow:
+		if _, err := inTimeWindow(rule, time.Now()); err != nil {
+			return fmt.Errorf("invalid time window: %v", err)
+		}
+	default:
+		return fmt.Errorf("unknown rule type %q", rule.RuleType)
+	}
+	return nil
+}
+
+// EvaluateSpendingRules применяет правила к авторизации и возвращает первое отклонившее её правило.
+// Порядок: запреты, затем списки разрешённого (по каждому типу достаточно совпадения с одним правилом),
+// затем временные окна и месячные лимиты по мерчантам. Лимиты считаются только если до них дошло.
+func EvaluateSpendingRules(rules []domain.SpendingRule, a SpendingAttempt, spent MerchantSpendFunc) (*RuleDecline, error) {
+	allow := map[string][]domain.SpendingRule{}
+	var caps []domain.SpendingRule
+
+	for _, rule := range rules {
+		if !rule.IsActive {
+			continue
+		}
+		switch rule.RuleType {
+		case domain.RuleMerchantDeny:
+			if matchMerchantPattern(rule.Value, a.MerchantName) {
+				return &RuleDecline{rule, fmt.Sprintf("мерчант %q запрещён правилом #%d", a.MerchantName, rule.ID)}, nil
+			}
+		case domain.RuleMCCDeny:
+			if matchMCC(rule.Value, a.MCC) {
+				return &RuleDecline{rule, fmt.Sprintf("категория мерчанта (MCC %s) запрещена правилом #%d", a.MCC, rule.ID)}, nil
+			}
+		case domain.RuleCountryDeny:
+			if matchCountry(rule.Value, a.Country) {
+				return &RuleDecline{rule, fmt.Sprintf("страна %s запрещена правилом #%d", strings.ToUpper(a.Country), rule.ID)}, nil
+			}
+		case domain.RuleMerchantAllow, domain.RuleMCCAllow, domain.RuleCountryAllow, domain.RuleTimeWindow:
+			allow[rule.RuleType] = append(allow[rule.RuleType], rule)
+		case domain.RuleMerchantCap:
+			caps = append(caps, rule)
+		}
+	}
+
+	allowChecks := []struct {
+		ruleType string
+		match    func(domain.SpendingRule) (bool, error)
+		reason   string
+	}{
+		{domain.RuleMerchantAllow, func(r domain.SpendingRule) (bool, error) {
+			return matchMerchantPattern(r.Value, a.MerchantName), nil
+		}, fmt.Sprintf("мерчант %q не входит в список разрешённых", a.MerchantName)},
+		{domain.RuleMCCAllow, func(r domain.SpendingRule) (bool, error) {
+			return matchMCC(r.Value, a.MCC), nil
+		}, fmt.Sprintf("категория мерчанта (MCC %s) не входит в список разрешённых", a.MCC)},
+		{domain.RuleCountryAllow, func(r domain.SpendingRule) (bool, error) {
+			return matchCountry(r.Value, a.Country), nil
+		}, fmt.Sprintf("страна %s не входит в список разрешённых", strings.ToUpper(a.Country))},
+		{domain.RuleTimeWindow, func(r domain.SpendingRule) (bool, error) {
+			return inTimeWindow(r, a.At)
+		}, "операция вне разрешённого времени"},
+	}
+	for _, check := range allowChecks {
+		group := allow[check.ruleType]
+		if len(group) == 0 {
+			continue
+		}
+		matched := false
+		for _, rule := range group {
+			ok, err := check.match(rule)
+			if err != nil {
+				log.Printf("[SPENDING-RULES] Rule #%d is invalid: %v", rule.ID, err)
+				continue
+			}
+			if ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return &RuleDecline{group[0], fmt.Sprintf("%s (правило #%d)", check.reason, group[0].ID)}, nil
+		}
+	}
+
+	for _, rule := range caps {
+		if !matchMerchantPattern(rule.Value, a.MerchantName) {
+			continue
+		}
+		used, err := spent(rule)
+		if err != nil {
+			return nil, err
+		}
+		if used.Add(a.Amount).GreaterThan(rule.MonthlyCap) {
+			return &RuleDecline{rule, fmt.Sprintf("месячный лимит $%s по мерчанту «%s» исчерпан (потрачено $%s, правило #%d)",
+				rule.MonthlyCap.StringFixed(2), rule.Value, used.StringFixed(2), rule.ID)}, nil
+		}
+	}
+	return nil, nil
+}
+
+// monthlyMerchantSpend возвращает MerchantSpendFunc, которая считает месячные траты карты
+// (или всей команды — для командных правил) у мерчантов, подходящих под шаблон правила.
+func monthlyMerchantSpend(card domain.Card) MerchantSpendFunc {
+	cache := map[bool]map[string]decimal.Decimal{}
+	return func(rule domain.SpendingRule) (decimal.Decimal, error) {
+		teamScope := rule.TeamID != nil
+		byMerchant, ok := cache[teamScope]
+		if !ok {
+			var teamID *int
+			if teamScope {
+				teamID = rule.TeamID
+			}
+			var err error
+			if byMerchant, err = repository.GetMonthlyMerchantSpend(card.ID, teamID); err != nil {
+				return decimal.Zero, err
+			}
+			cache[teamScope] = byMerchant
+		}
+		total := decimal.Zero
+		for merchant, sum := range byMerchant {
+			if matchMerchantPattern(rule.Value, merchant) {
+				total = total.Add(sum)
+			}
+		}
+		return total, nil
+	}
+}
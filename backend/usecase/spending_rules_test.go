@@ -0,0 +1,113 @@
+package usecase
+
+import (
+	"testing"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/shopspring/decimal"
+)
+
+func TestMatchMerchantPattern(t *testing.T) {
+	cases := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"facebook", "FACEBK *Facebook Ads", true},
+		{"FACEBK*ADS", "facebk *facebook ads", true},
+		{"google*", "Google Ads", true},
+		{"google*", "Ads by Google", false},
+		{"*ads", "TikTok Ads", true},
+		{"a*a", "a", false},
+		{"", "anything", false},
+	}
+	for _, c := range cases {
+		if got := matchMerchantPattern(c.pattern, c.name); got != c.want {
+			t.Errorf("matchMerchantPattern(%q, %q) = %v, want %v", c.pattern, c.name, got, c.want)
+		}
+	}
+}
+
+func TestMatchMCC(t *testing.T) {
+	if !matchMCC("5411, 5960-5969", "5967") || !matchMCC("5411,7311", "5411") {
+		t.Error("MCC из списка/диапазона должен совпадать")
+	}
+	if matchMCC("5960-5969", "7995") || matchMCC("5411", "") {
+		t.Error("MCC вне списка или пустой не должен совпадать")
+	}
+}
+
+func TestEvaluateSpendingRules(t *testing.T) {
+	noon := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	night := time.Date(2026, 3, 10, 23, 30, 0, 0, time.UTC)
+	rule := func(id int, ruleType, value string) domain.SpendingRule {
+		return domain.SpendingRule{ID: id, RuleType: ruleType, Value: value, IsActive: true}
+	}
+	capRule := rule(9, domain.RuleMerchantCap, "facebook")
+	capRule.MonthlyCap = decimal.NewFromInt(500)
+	spent := func(domain.SpendingRule) (decimal.Decimal, error) { return decimal.NewFromInt(450), nil }
+
+	cases := []struct {
+		name    string
+		rules   []domain.SpendingRule
+		attempt SpendingAttempt
+		want    int // ID отклонившего правила, 0 — одобрено
+	}{
+		{"без правил", nil, SpendingAttempt{MerchantName: "Any", At: noon}, 0},
+		{"запрещённый мерчант", []domain.SpendingRule{rule(1, domain.RuleMerchantDeny, "casino")},
+			SpendingAttempt{MerchantName: "Royal Casino", At: noon}, 1},
+		{"запрещённый MCC", []domain.SpendingRule{rule(2, domain.RuleMCCDeny, "7995")},
+			SpendingAttempt{MerchantName: "Bet", MCC: "7995", At: noon}, 2},
+		{"разрешённый мерчант", []domain.SpendingRule{rule(3, domain.RuleMerchantAllow, "facebook"), rule(4, domain.RuleMerchantAllow, "google*")},
+			SpendingAttempt{MerchantName: "Google Ads", At: noon}, 0},
+		{"мерчант не в списке разрешённых", []domain.SpendingRule{rule(3, domain.RuleMerchantAllow, "facebook"), rule(4, domain.RuleMerchantAllow, "google*")},
+			SpendingAttempt{MerchantName: "TikTok", At: noon}, 3},
+		{"страна не передана при списке разрешённых", []domain.SpendingRule{rule(5, domain.RuleCountryAllow, "DE, FR")},
+			SpendingAttempt{MerchantName: "Shop", At: noon}, 5},
+		{"страна запрещена", []domain.SpendingRule{rule(6, domain.RuleCountryDeny, "ru")},
+			SpendingAttempt{MerchantName: "Shop", Country: "RU", At: noon}, 6},
+		{"внутри окна", []domain.SpendingRule{rule(7, domain.RuleTimeWindow, "09:00-18:00")},
+			SpendingAttempt{MerchantName: "Shop", At: noon}, 0},
+		{"вне окна", []domain.SpendingRule{rule(7, domain.RuleTimeWindow, "09:00-18:00")},
+			SpendingAttempt{MerchantName: "Shop", At: night}, 7},
+		{"окно через полночь", []domain.SpendingRule{rule(8, domain.RuleTimeWindow, "22:00-06:00")},
+			SpendingAttempt{MerchantName: "Shop", At: night}, 0},
+		{"лимит по мерчанту не исчерпан", []domain.SpendingRule{capRule},
+			SpendingAttempt{MerchantName: "Facebook Ads", Amount: decimal.NewFromInt(50), At: noon}, 0},
+		{"лимит по мерчанту исчерпан", []domain.SpendingRule{capRule},
+			SpendingAttempt{MerchantName: "Facebook Ads", Amount: decimal.NewFromInt(51), At: noon}, 9},
+		{"лимит другого мерчанта не мешает", []domain.SpendingRule{capRule},
+			SpendingAttempt{MerchantName: "Google Ads", Amount: decimal.NewFromInt(1000), At: noon}, 0},
+	}
+	for _, c := range cases {
+		decline, err := EvaluateSpendingRules(c.rules, c.attempt, spent)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		got := 0
+		if decline != nil {
+			got = decline.Rule.ID
+		}
+		if got != c.want {
+			t.Errorf("%s: declined by #%d, want #%d", c.name, got, c.want)
+		}
+	}
+}
+
+func TestValidateSpendingRule(t *testing.T) {
+	bad := []domain.SpendingRule{
+		{RuleType: "unknown", Value: "x"},
+		{RuleType: domain.RuleMCCDeny, Value: "abc"},
+		{RuleType: domain.RuleCountryAllow, Value: "DEU"},
+		{RuleType: domain.RuleMerchantCap, Value: "facebook"},
+		{RuleType: domain.RuleTimeWindow, Value: "9-18"},
+	}
+	for _, r := range bad {
+		if ValidateSpendingRule(r) == nil {
+			t.Errorf("rule %s=%q should be rejected", r.RuleType, r.Value)
+		}
+	}
+	if err := ValidateSpendingRule(domain.SpendingRule{RuleType: domain.RuleTimeWindow, Value: "09:00-18:00", Timezone: "Europe/Moscow"}); err != nil {
+		t.Errorf("valid time window rejected: %v", err)
+	}
+}
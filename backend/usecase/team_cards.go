@@ -0,0 +1,133 @@
+package usecase
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/repository"
+	"github.com/djalben/xplr-core/backend/service"
+)
+
+// Что делать с картами команды удаляемого участника
+const (
+	RemovedMemberCardsKeep     = "keep"     // Карты остаются за участником (как раньше)
+	RemovedMemberCardsReassign = "reassign" // Передать карты другому участнику или владельцу команды
+	RemovedMemberCardsFreeze   = "freeze"   // Заморозить карты
+)
+
+// ErrTeamCardsInvalid — некорректный запрос на передачу или заморозку карт команды.
+var ErrTeamCardsInvalid = errors.New("invalid team cards request")
+
+// ReassignTeamCards — передать карты команды участнику toUserID (0 — владельцу команды).
+// cardIDs пуст и fromUserID > 0 — передаются все незакрытые карты команды этого участника.
+// Новый владелец получает уведомление (ставится в очередь в транзакции передачи).
+func ReassignTeamCards(teamID, actorID int, cardIDs []int, fromUserID, toUserID int, balanceMode, reason string) ([]domain.CardOwnershipTransfer, error) {
+	if len(cardIDs) == 0 && fromUserID > 0 {
+		ids, err := repository.GetMemberTeamCardIDs(teamID, fromUserID)
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			return []domain.CardOwnershipTransfer{}, nil
+		}
+		cardIDs = ids
+	}
+	if len(cardIDs) == 0 {
+		return nil, fmt.Errorf("%w: card_ids or from_user_id is required", ErrTeamCardsInvalid)
+	}
+	transfers, err := repository.TransferTeamCards(teamID, actorID, cardIDs, toUserID, balanceMode, reason)
+	if err != nil {
+		return nil, err
+	}
+	return transfers, nil
+}
+
+// FreezeTeamMemberCards — заморозить незакрытые карты команды участника (у эмитента и в БД).
+// Нужно право cards.freeze. Возвращает id замороженных карт.
+func FreezeTeamMemberCards(teamID, actorID, memberID int) ([]int, error) {
+	allowed, err := repository.HasTeamPermission(teamID, actorID, domain.PermCardsFreeze)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, repository.ErrTeamAccessDenied
+	}
+	ids, err := repository.GetMemberTeamCardIDs(teamID, memberID)
+	if err != nil {
+		return nil, err
+	}
+	var frozen []int
+	for _, cardID := range ids {
+		if provider, err := service.CardProviderForCard(cardID); err == nil {
+			if err := provider.FreezeCard(cardID); err != nil {
+				log.Printf("[CARD-TRANSFER] Provider freeze of card %d failed: %v", cardID, err)
+			}
+		}
+		if err := repository.UpdateCardStatus(cardID, memberID, "FROZEN"); err != nil {
+			return frozen, fmt.Errorf("не удалось заморозить карту #%d: %v", cardID, err)
+		}
+		frozen = append(frozen, cardID)
+	}
+	log.Printf("[CARD-TRANSFER] User %d froze %d team %d cards of member %d", actorID, len(frozen), teamID, memberID)
+	return frozen, nil
+}
+
+// RemoveTeamMember — удалить участника из команды, предварительно передав или заморозив его карты
+// команды (cardsMode). Карты обрабатываются до удаления: если это не удалось, участник остаётся в команде.
+func RemoveTeamMember(teamID, memberID, removerID int, cardsMode string, toUserID int, balanceMode string) (map[string]interface{}, error) {
+	if cardsMode == "" {
+		cardsMode = RemovedMemberCardsKeep
+	}
+	result := map[string]interface{}{"cards": cardsMode}
+	switch cardsMode {
+	case RemovedMemberCardsKeep:
+	case RemovedMemberCardsReassign, RemovedMemberCardsFreeze:
+		// Проверяем права на удаление заранее, чтобы не трогать карты, если удалить участника нельзя
+		_, role, err := repository.CheckTeamAccess(teamID, memberID)
+		if err != nil {
+			return nil, err
+		}
+		if role == "" {
+			return nil, fmt.Errorf("member not found")
+		}
+		if role == "owner" {
+			return nil, fmt.Errorf("cannot remove team owner")
+		}
+		canRemove, err := repository.HasTeamPermission(teamID, removerID, domain.PermMembersInvite)
+		if err != nil {
+			return nil, err
+		}
+		if !canRemove {
+			return nil, fmt.Errorf("insufficient permissions: members.invite required")
+		}
+		if role == "admin" {
+			if canManage, _ := repository.HasTeamPermission(teamID, removerID, domain.PermTeamManage); !canManage {
+				return nil, fmt.Errorf("insufficient permissions: team.manage required to remove admins")
+			}
+		}
+		if cardsMode == RemovedMemberCardsReassign {
+			if toUserID == memberID {
+				return nil, fmt.Errorf("%w: cannot reassign cards to the removed member", ErrTeamCardsInvalid)
+			}
+			transfers, err := ReassignTeamCards(teamID, removerID, nil, memberID, toUserID, balanceMode, "member removed from team")
+			if err != nil {
+				return nil, err
+			}
+			result["transfers"] = transfers
+		} else {
+			frozen, err := FreezeTeamMemberCards(teamID, removerID, memberID)
+			if err != nil {
+				return nil, err
+			}
+			result["frozen_card_ids"] = frozen
+		}
+	default:
+		return nil, fmt.Errorf("%w: cards must be keep, reassign or freeze", ErrTeamCardsInvalid)
+	}
+	if err := repository.RemoveTeamMember(teamID, memberID, removerID); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
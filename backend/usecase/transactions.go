@@ -1,10 +1,11 @@
 package usecase
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"time"
 
-	"github.com/djalben/xplr-core/backend/configs"
 	"github.com/djalben/xplr-core/backend/domain"
 	"github.com/djalben/xplr-core/backend/repository"
 	"github.com/djalben/xplr-core/backend/service"
@@ -13,128 +14,38 @@ import (
 
 // AuthorizeCardRequest - запрос авторизации от провайдера
 type AuthorizeCardRequest struct {
-	CardID       int             `json:"card_id"`       // ID карты в нашей системе
-	Amount       decimal.Decimal `json:"amount"`        // Сумма транзакции
-	MerchantName string          `json:"merchant_name"` // Название мерчанта
+	CardID       int             `json:"card_id"`          // ID карты в нашей системе
+	Amount       decimal.Decimal `json:"amount"`           // Сумма транзакции
+	MerchantName string          `json:"merchant_name"`    // Название мерчанта
+	MCC          string          `json:"mcc"`              // Merchant Category Code (если известен)
+	Country      string          `json:"merchant_country"` // ISO-код страны мерчанта (если известен)
 }
 
 // authorizeCard - Центральная функция, которая обрабатывает все проверки и записывает транзакцию.
-// Это ядро Zero Decline Logic.
+// Это ядро Zero Decline Logic. Решение принимает общий движок EvaluateAuthorization —
+// тот же, что и для вебхука Wallester, поэтому при одинаковом состоянии оба пути отвечают одинаково.
 func AuthorizeCard(req AuthorizeCardRequest) domain.AuthResponse {
-	// 1. Получаем карту по ID
-	card, err := repository.GetCardByID(req.CardID)
-	if err != nil {
-		return domain.AuthResponse{
-			Success: false,
-			Status:  "DECLINED",
-			Message: "Card not found.",
-			Fee:     decimal.NewFromFloat(configs.DeclineFee),
-		}
-	}
-
-	// 2. Получаем пользователя
-	user, err := repository.GetUserByID(card.UserID)
-	if err != nil {
-		return domain.AuthResponse{
-			Success: false,
-			Status:  "DECLINED",
-			Message: "User not found.",
-			Fee:     decimal.NewFromFloat(configs.DeclineFee),
-		}
-	}
-
-	// 2.5. АНТИ-ФРОД: Автоматическая блокировка после MaxFailedAttempts
-	if card.FailedAuthCount >= configs.MaxFailedAttempts {
-		log.Printf("ANTI-FRAUD: Card %d has %d failed attempts, blocking card", card.ID, card.FailedAuthCount)
-
-		// Блокируем карту в БД
-		err := repository.BlockCard(card.ID)
-		if err != nil {
-			log.Printf("ERROR: Failed to block card %d: %v", card.ID, err)
-		}
-
-		// Уведомление о блокировке (TG + Email)
-		go service.NotifyUser(user.ID, "Карта заблокирована",
-			fmt.Sprintf("🔒 <b>Карта *%s заблокирована</b>\n\n"+
-				"Причина: множественные неудачные попытки авторизации.\n\n"+
-				"<a href=\"https://xplr.pro/cards\">Открыть карты</a>",
-				card.Last4Digits))
-
-		return domain.AuthResponse{
-			Success: false,
-			Status:  "DECLINED",
-			Message: "Card blocked due to multiple failed attempts.",
-			Fee:     decimal.NewFromFloat(configs.DeclineFee),
-		}
-	}
-
-	// 3. БИЗНЕС-ЛОГИКА: Проверка баланса и лимитов (Zero Decline Logic)
-
-	// Проверка 3.1: Статус карты (блокировка)
-	if card.CardStatus != "ACTIVE" {
-		log.Printf("DECLINED: Card %d is not active (Status: %s)", req.CardID, card.CardStatus)
-
-		// Уведомление о DECLINE (TG + Email)
-		go service.NotifyUser(user.ID, "Транзакция отклонена",
-			fmt.Sprintf("❌ <b>Транзакция по карте *%s отклонена</b>\n\n"+
-				"Причина: карта не активна (статус: %s).\n\n"+
-				"<a href=\"https://xplr.pro/cards\">Открыть карты</a>",
-				card.Last4Digits, card.CardStatus))
-
-		return domain.AuthResponse{
-			Success: false,
-			Status:  "DECLINED",
-			Message: "Card is blocked or inactive.",
-			Fee:     decimal.NewFromFloat(configs.DeclineFee),
-		}
-	}
-
-	// Проверка 3.2: Баланс (XPLR: BalanceRub — основной баланс в рублях)
-	if user.BalanceRub.LessThan(req.Amount) {
-		log.Printf("DECLINED: User %d balance_rub (%s) is insufficient for transaction %s", user.ID, user.BalanceRub.String(), req.Amount.String())
-
-		go service.NotifyUser(user.ID, "Транзакция отклонена",
-			fmt.Sprintf("❌ <b>Транзакция по карте *%s отклонена</b>\n\n"+
-				"Причина: недостаточно средств.\n"+
-				"Баланс: <b>%s</b>, сумма: <b>%s</b>\n\n"+
-				"<a href=\"https://xplr.pro/wallet\">Пополнить кошелёк</a>",
-				card.Last4Digits, user.BalanceRub.String(), req.Amount.String()))
-
-		repository.IncrementFailedAuthCount(card.ID)
-
-		return domain.AuthResponse{
-			Success: false,
-			Status:  "DECLINED",
-			Message: "Insufficient user balance.",
-			Fee:     decimal.NewFromFloat(configs.DeclineFee),
-		}
-	}
-
-	// Проверка 3.3: Дневной лимит (Rule-Based Blocking)
-	if req.Amount.GreaterThan(card.DailySpendLimit) && card.DailySpendLimit.GreaterThan(decimal.Zero) {
-		log.Printf("DECLINED: Card %d daily limit (%s) exceeded by transaction %s", req.CardID, card.DailySpendLimit.String(), req.Amount.String())
-
-		// Уведомление о DECLINE (TG + Email)
-		go service.NotifyUser(user.ID, "Транзакция отклонена",
-			fmt.Sprintf("❌ <b>Транзакция по карте *%s отклонена</b>\n\n"+
-				"Причина: превышен дневной лимит (лимит: <b>$%s</b>).\n\n"+
-				"<a href=\"https://xplr.pro/cards\">Открыть карты</a>",
-				card.Last4Digits, card.DailySpendLimit.String()))
-
-		return domain.AuthResponse{
-			Success: false,
-			Status:  "DECLINED",
-			Message: "Daily spend limit exceeded.",
-			Fee:     decimal.NewFromFloat(configs.DeclineFee),
-		}
+	// 1-3. Анти-фрод, статус карты, правила расходов, скользящие лимиты, лимит карты, Кошелёк
+	decision, st := EvaluateAuthorization(AuthorizationRequest{
+		CardID:       req.CardID,
+		Amount:       req.Amount,
+		MerchantName: req.MerchantName,
+		MCC:          req.MCC,
+		Country:      req.Country,
+		Source:       "authorize",
+		At:           time.Now(),
+	})
+	if !decision.Approved {
+		return AuthResponseFor(decision, decimal.Zero)
 	}
+	card := st.Card
 
 	// 4. УСПЕХ (APPROVED)
 
 	// 4.1. Получить Grade пользователя и вычислить комиссию ПЕРЕД обработкой платежа
-	userGrade, err := repository.GetUserGrade(user.ID)
+	userGrade, err := repository.GetUserGrade(card.UserID)
 	if err != nil {
-		log.Printf("Warning: Failed to get user grade for user %d: %v", user.ID, err)
+		log.Printf("Warning: Failed to get user grade for user %d: %v", card.UserID, err)
 		// Используем стандартную комиссию 6.7% если Grade не найден (как у e.pn)
 		userGrade = &domain.UserGrade{
 			FeePercent: decimal.NewFromFloat(6.70),
@@ -144,34 +55,60 @@ func AuthorizeCard(req AuthorizeCardRequest) domain.AuthResponse {
 	// Вычислить комиссию на основе Grade (fee_percent в процентах, например 6.70 = 6.7%)
 	fee := req.Amount.Mul(userGrade.FeePercent).Div(decimal.NewFromInt(100))
 
-	// 4.2. Списание средств и запись транзакции в рамках атомарной операции (с комиссией)
-	err = repository.ProcessCardPayment(user.ID, card.ID, req.Amount, fee, req.MerchantName, card.Last4Digits)
+	// 4.2. Списание из Кошелька и запись транзакции в рамках атомарной операции (общая с вебхуком)
+	_, err = repository.CaptureCardPayment(repository.CardCapture{
+		UserID:       card.UserID,
+		CardID:       card.ID,
+		Amount:       req.Amount,
+		Fee:          fee,
+		MerchantName: req.MerchantName,
+		ProviderTxID: fmt.Sprintf("xplr-auth-%d-%d", card.ID, time.Now().UnixNano()),
+		Details:      fmt.Sprintf("Card payment: %s from ...%s", req.MerchantName, card.Last4Digits),
+		Description:  "Card payment: " + req.MerchantName,
+		// Уведомление об УСПЕШНОЙ транзакции фиксируется вместе со списанием
+		Notification: &domain.UserNotification{
+			UserID:   card.UserID,
+			Template: domain.NotifyCardCharged,
+			Amount:   req.Amount.Abs(),
+			Data: map[string]string{
+				"last4":    card.Last4Digits,
+				"amount":   req.Amount.String(),
+				"currency": card.Currency,
+				"merchant": req.MerchantName,
+				"fee":      fee.String(),
+			},
+		},
+	})
 	if err != nil {
-		log.Printf("CRITICAL DB ERROR: Failed to process payment for user %d: %v", user.ID, err)
-		// Если произошла ошибка БД, отклоняем списание, но БЕЗ комиссии.
-		return domain.AuthResponse{
-			Success: false,
-			Status:  "DECLINED",
-			Message: "Internal system error during payment processing.",
-			Fee:     decimal.NewFromFloat(configs.DeclineFee),
+		log.Printf("CRITICAL DB ERROR: Failed to process payment for user %d: %v", card.UserID, err)
+		// Состояние изменилось между проверкой и списанием — отвечаем так же, как движок
+		var velocity *repository.VelocityDecline
+		switch {
+		case errors.As(err, &velocity):
+			decision = declined(velocity.Code, velocity.Reason, "Velocity limit exceeded.")
+		case errors.Is(err, repository.ErrInsufficientWallet):
+			decision = declined(DeclineInsufficientFunds, "недостаточно средств", "Insufficient wallet balance.")
+		case errors.Is(err, repository.ErrCardSpendingLimit):
+			decision = declined(DeclineCardSpendingLimit, "превышен лимит карты", "Card spending limit exceeded.")
+		default:
+			// Если произошла ошибка БД, отклоняем списание, но БЕЗ комиссии.
+			decision = declined(DeclineInternalError, "внутренняя ошибка", "Internal system error during payment processing.")
 		}
+		return AuthResponseFor(decision, decimal.Zero)
 	}
 
-	// 4.3. Уведомление об УСПЕШНОЙ транзакции (TG + Email)
-	go service.NotifyUser(user.ID, "Списание с карты",
-		fmt.Sprintf("💸 <b>Списание с карты *%s</b>\n\n"+
-			"Сумма: <b>%s</b>\n"+
-			"Магазин: %s\n"+
-			"Комиссия: %s\n\n"+
-			"<a href=\"https://xplr.pro/cards\">Открыть карты</a>",
-			card.Last4Digits, req.Amount.String(), req.MerchantName, fee.String()))
-
-	return domain.AuthResponse{
-		Success: true,
-		Status:  "APPROVED",
-		Message: "Transaction approved.",
-		Fee:     fee, // Комиссия на основе Grade пользователя
-	}
+	// 4.3. Исходящий вебхук об УСПЕШНОЙ транзакции
+	go service.EmitWebhookEvent(card.UserID, domain.WebhookTransactionApproved, map[string]interface{}{
+		"card_id":  card.ID,
+		"last4":    card.Last4Digits,
+		"stage":    "capture",
+		"amount":   req.Amount.String(),
+		"fee":      fee.String(),
+		"currency": card.Currency,
+		"merchant": req.MerchantName,
+	})
+
+	return AuthResponseFor(decision, fee) // Комиссия на основе Grade пользователя
 }
 
 // TestAuthorizeCard - Заглушка для тестирования (POST /v1/authorize)
@@ -0,0 +1,24 @@
+package usecase
+
+import (
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/repository"
+	"github.com/shopspring/decimal"
+)
+
+// Коды отказа по скользящим лимитам (domain.AuthResponse.DeclineCode)
+const (
+	DeclineVelocityDaily   = repository.DeclineVelocityDaily
+	DeclineVelocityWeekly  = repository.DeclineVelocityWeekly
+	DeclineVelocityMonthly = repository.DeclineVelocityMonthly
+	DeclineVelocityHourly  = repository.DeclineVelocityHourly
+)
+
+// VelocityDecline — превышенный скользящий лимит.
+type VelocityDecline = repository.VelocityDecline
+
+// EvaluateVelocity проверяет, уложится ли новая операция amount в скользящие лимиты карты.
+// Та же проверка повторяется под блокировкой карты в PlaceCardHold и CaptureCardPayment.
+func EvaluateVelocity(limits domain.VelocityLimits, usage domain.VelocityUsage, amount decimal.Decimal) *VelocityDecline {
+	return repository.EvaluateVelocity(limits, usage, amount)
+}
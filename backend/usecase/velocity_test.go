@@ -0,0 +1,39 @@
+package usecase
+
+import (
+	"testing"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/shopspring/decimal"
+)
+
+func TestEvaluateVelocity(t *testing.T) {
+	d := decimal.NewFromInt
+	limits := domain.VelocityLimits{Daily: d(100), Weekly: d(500), Monthly: d(1000), MaxTxPerHour: 10}
+
+	cases := []struct {
+		name   string
+		limits domain.VelocityLimits
+		usage  domain.VelocityUsage
+		amount decimal.Decimal
+		want   string
+	}{
+		{"в пределах лимитов", limits, domain.VelocityUsage{Day: d(10), Week: d(10), Month: d(10)}, d(90), ""},
+		{"десятая операция по $90 не проходит дневной лимит", limits,
+			domain.VelocityUsage{Day: d(90), Week: d(90), Month: d(90), TxLastHour: 1}, d(90), DeclineVelocityDaily},
+		{"недельный лимит", limits, domain.VelocityUsage{Day: d(0), Week: d(450), Month: d(450)}, d(60), DeclineVelocityWeekly},
+		{"месячный лимит", limits, domain.VelocityUsage{Week: d(100), Month: d(990)}, d(20), DeclineVelocityMonthly},
+		{"число операций за час", limits, domain.VelocityUsage{TxLastHour: 10}, d(1), DeclineVelocityHourly},
+		{"нулевые лимиты не ограничивают", domain.VelocityLimits{},
+			domain.VelocityUsage{Day: d(100000), TxLastHour: 1000}, d(5000), ""},
+	}
+	for _, c := range cases {
+		got := ""
+		if decline := EvaluateVelocity(c.limits, c.usage, c.amount); decline != nil {
+			got = decline.Code
+		}
+		if got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
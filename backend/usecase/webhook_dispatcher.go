@@ -0,0 +1,202 @@
+package usecase
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/domain"
+	"github.com/djalben/xplr-core/backend/repository"
+)
+
+// WebhookMaxAttempts — сколько раз отправляется событие, прежде чем доставка считается проваленной.
+const WebhookMaxAttempts = 8
+
+// webhookRetryBase и webhookRetryMax — экспоненциальная пауза между попытками: 1м, 2м, 4м… до 6ч.
+const (
+	webhookRetryBase = time.Minute
+	webhookRetryMax  = 6 * time.Hour
+)
+
+// webhookTimeout — сколько ждём ответа endpoint; webhookBatchSize и webhookWorkers — сколько
+// доставок забирается за проход и сколько отправляется параллельно (медленный endpoint не держит остальные).
+const (
+	webhookTimeout   = 10 * time.Second
+	webhookBatchSize = 50
+	webhookWorkers   = 5
+)
+
+// Заголовки запроса вебхука. Подпись: X-XPLR-Signature: t=<unix-время>,v1=<hex HMAC-SHA256>
+// от строки "<t>.<тело>" с секретом endpoint — получатель сверяет её и отбрасывает старые t.
+const (
+	WebhookSignatureHeader = "X-XPLR-Signature"
+	WebhookEventHeader     = "X-XPLR-Event"
+	WebhookEventIDHeader   = "X-XPLR-Event-Id"
+	WebhookDeliveryHeader  = "X-XPLR-Delivery"
+)
+
+var errWebhookPrivateAddress = errors.New("webhook host resolves to a non-public address")
+
+// webhookHTTPClient не ходит в частные сети (проверяется адрес, к которому реально подключаемся,
+// а не только URL), не следует редиректам и не использует прокси из окружения.
+var webhookHTTPClient = &http.Client{
+	Timeout: webhookTimeout,
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || !repository.IsPublicWebhookIP(ip) {
+					return errWebhookPrivateAddress
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: webhookTimeout,
+		MaxIdleConnsPerHost:   2,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// SignWebhookPayload — значение v1 подписи: hex HMAC-SHA256 от "<timestamp>.<payload>".
+func SignWebhookPayload(secret string, timestamp int64, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "." + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookRetryDelay — пауза перед следующей попыткой после attempt неудачных.
+func webhookRetryDelay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	delay := webhookRetryBase
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= webhookRetryMax {
+			return webhookRetryMax
+		}
+	}
+	return delay
+}
+
+// postWebhook отправляет доставку d и возвращает HTTP-код ответа (0 — ответа не было).
+func postWebhook(d domain.WebhookDelivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, d.URL, strings.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	ts := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "XPLR-Webhooks/1.0")
+	req.Header.Set(WebhookEventHeader, d.Event)
+	req.Header.Set(WebhookEventIDHeader, d.EventID)
+	req.Header.Set(WebhookDeliveryHeader, strconv.Itoa(d.ID))
+	req.Header.Set(WebhookSignatureHeader, fmt.Sprintf("t=%d,v1=%s", ts, SignWebhookPayload(d.Secret, ts, d.Payload)))
+
+	resp, err := webhookHTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 300))
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(snippet)))
+	}
+	return resp.StatusCode, nil
+}
+
+// DeliverWebhook делает одну попытку доставки и записывает её результат в журнал.
+// Тестовое событие не повторяется: результат сразу виден пользователю.
+func DeliverWebhook(d domain.WebhookDelivery) domain.WebhookDeliveryAttempt {
+	start := time.Now()
+	statusCode, err := postWebhook(d)
+	duration := time.Since(start)
+
+	attempt := domain.WebhookDeliveryAttempt{
+		DeliveryID: d.ID,
+		Attempt:    d.Attempts + 1,
+		StatusCode: statusCode,
+		DurationMs: duration.Milliseconds(),
+		CreatedAt:  time.Now(),
+	}
+	status, next := domain.WebhookDeliveryDelivered, time.Now()
+	if err != nil {
+		attempt.Error = err.Error()
+		if d.Event == domain.WebhookTest || d.Attempts+1 >= WebhookMaxAttempts {
+			status = domain.WebhookDeliveryFailed
+			log.Printf("[WEBHOOKS] ❌ Delivery #%d (%s → endpoint %d) failed after %d attempts: %v",
+				d.ID, d.Event, d.EndpointID, d.Attempts+1, err)
+		} else {
+			status = domain.WebhookDeliveryPending
+			next = next.Add(webhookRetryDelay(d.Attempts + 1))
+			log.Printf("[WEBHOOKS] Delivery #%d (%s → endpoint %d) attempt %d failed, retry at %s: %v",
+				d.ID, d.Event, d.EndpointID, d.Attempts+1, next.Format(time.RFC3339), err)
+		}
+	}
+	if err := repository.FinishWebhookAttempt(&d, status, statusCode, attempt.Error, duration, next); err != nil {
+		log.Printf("[WEBHOOKS] ❌ Delivery #%d: failed to record result: %v", d.ID, err)
+	}
+	return attempt
+}
+
+// ProcessWebhookDeliveries отправляет события, срок попытки которых наступил.
+// Возвращает число обработанных доставок.
+func ProcessWebhookDeliveries() (int, error) {
+	batch, err := repository.ClaimDueWebhookDeliveries(webhookBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	jobs := make(chan domain.WebhookDelivery)
+	var wg sync.WaitGroup
+	for i := 0; i < webhookWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range jobs {
+				DeliverWebhook(d)
+			}
+		}()
+	}
+	for _, d := range batch {
+		jobs <- d
+	}
+	close(jobs)
+	wg.Wait()
+	return len(batch), nil
+}
+
+// StartWebhookDispatcher запускает отправку исходящих вебхуков из webhook_deliveries.
+func StartWebhookDispatcher() {
+	log.Println("[WEBHOOKS] Starting webhook dispatcher...")
+
+	ticker := time.NewTicker(10 * time.Second)
+	go func() {
+		for range ticker.C {
+			if _, err := ProcessWebhookDeliveries(); err != nil {
+				log.Printf("[WEBHOOKS] Dispatch failed: %v", err)
+			}
+		}
+	}()
+
+	log.Println("[WEBHOOKS] Webhook dispatcher started (checking every 10 seconds)")
+}
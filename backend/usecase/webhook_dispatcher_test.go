@@ -0,0 +1,58 @@
+package usecase
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/djalben/xplr-core/backend/domain"
+)
+
+func TestSignWebhookPayload(t *testing.T) {
+	got := SignWebhookPayload("whsec_test", 1700000000, `{"id":"evt_1"}`)
+	want := "c89214b5b5da833daed6f0b8c5bb6bd58cea9022bd80ccc78230f3942d632925"
+	if got != want {
+		t.Errorf("signature = %s, want %s", got, want)
+	}
+	if SignWebhookPayload("whsec_other", 1700000000, `{"id":"evt_1"}`) == want {
+		t.Error("signature must depend on the secret")
+	}
+}
+
+func TestWebhookRetryDelay(t *testing.T) {
+	cases := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, time.Minute},
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{7, 64 * time.Minute},
+		{9, 256 * time.Minute},
+		{10, 6 * time.Hour},
+		{40, 6 * time.Hour},
+	}
+	for _, c := range cases {
+		if got := webhookRetryDelay(c.attempt); got != c.want {
+			t.Errorf("webhookRetryDelay(%d) = %s, want %s", c.attempt, got, c.want)
+		}
+	}
+}
+
+func TestPostWebhookRefusesPrivateAddresses(t *testing.T) {
+	called := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer srv.Close()
+
+	code, err := postWebhook(domain.WebhookDelivery{ID: 1, URL: srv.URL, Secret: "whsec_test", Payload: "{}"})
+	if code != 0 || !errors.Is(err, errWebhookPrivateAddress) {
+		t.Errorf("postWebhook to loopback = %d, %v; want refused", code, err)
+	}
+	if called {
+		t.Error("loopback endpoint must not receive the request")
+	}
+}
@@ -141,17 +141,17 @@ export const CardIssuePage = () => {
               <input
                 type="range"
                 min="1"
-                max="100"
+                max="200"
                 value={quantity}
                 onChange={(e) => setQuantity(parseInt(e.target.value))}
                 className="w-full h-2 bg-white/10 rounded-lg appearance-none cursor-pointer accent-blue-500"
               />
               <div className="flex justify-between text-xs text-slate-500 mt-1">
                 <span>1</span>
-                <span>25</span>
                 <span>50</span>
-                <span>75</span>
                 <span>100</span>
+                <span>150</span>
+                <span>200</span>
               </div>
             </div>
 
@@ -56,7 +56,6 @@ interface AdminUser {
   id: number;
   display_name: string;
   email: string;
-  balance_rub: string;
   status: string;
   is_admin: boolean;
   role: string;
@@ -76,7 +75,6 @@ interface UserFullDetails {
   email: string;
   status: string;
   wallet_balance: string;
-  balance_rub: string;
   is_admin: boolean;
   is_verified: boolean;
   is_blocked: boolean;
@@ -1587,7 +1585,7 @@ export const StaffOnlyZone = () => {
                   </div>
                   <div className="space-y-1 text-sm text-slate-300">
                     <p><strong className="text-slate-400">Email:</strong> {selectedUser.email}</p>
-                    <p><strong className="text-slate-400">Баланс:</strong> ${parseFloat(selectedUser.balance_rub).toFixed(2)}</p>
+                    <p><strong className="text-slate-400">Баланс:</strong> ${parseFloat(selectedUser.wallet_balance || '0').toFixed(2)}</p>
                     <p><strong className="text-slate-400">Статус:</strong> {selectedUser.status}</p>
                     <p><strong className="text-slate-400">Роль:</strong> {selectedUser.role}</p>
                     <p><strong className="text-slate-400">Verified:</strong> {selectedUser.is_verified ? 'Да' : 'Нет'}</p>
@@ -67,12 +67,58 @@ export interface MassIssueResponse {
   results: CardIssueResult[];
 }
 
-// Выпустить виртуальные карты
-export const issueCards = async (data: MassIssueRequest): Promise<MassIssueResponse> => {
-  const response = await apiClient.post<MassIssueResponse>('/user/cards/issue', data);
+export interface CardIssueJobCreated {
+  job_id: number;
+  status: string;
+  total: number;
+  fee_per_card: string;
+  status_url: string;
+}
+
+export interface CardIssueJob {
+  id: number;
+  user_id: number;
+  status: 'PENDING' | 'RUNNING' | 'COMPLETED' | 'FAILED';
+  request: MassIssueRequest;
+  fee_per_card: string;
+  total: number;
+  succeeded: number;
+  failed: number;
+  refunded: string;
+  results: CardIssueResult[];
+  created_at: string;
+  started_at?: string;
+  finished_at?: string;
+}
+
+// Поставить в очередь выпуск виртуальных карт
+export const createCardIssueJob = async (data: MassIssueRequest): Promise<CardIssueJobCreated> => {
+  const response = await apiClient.post<CardIssueJobCreated>('/user/cards/issue', data);
+  return response.data;
+};
+
+// Прогресс задания выпуска карт
+export const getCardIssueJob = async (jobId: number): Promise<CardIssueJob> => {
+  const response = await apiClient.get<CardIssueJob>(`/user/cards/issue-jobs/${jobId}`);
   return response.data;
 };
 
+// Выпустить виртуальные карты: создаёт задание и ждёт его завершения
+export const issueCards = async (
+  data: MassIssueRequest,
+  onProgress?: (job: CardIssueJob) => void
+): Promise<MassIssueResponse> => {
+  const { job_id } = await createCardIssueJob(data);
+  for (;;) {
+    const job = await getCardIssueJob(job_id);
+    onProgress?.(job);
+    if (job.status === 'COMPLETED' || job.status === 'FAILED') {
+      return { successful_count: job.succeeded, failed_count: job.failed, results: job.results };
+    }
+    await new Promise((resolve) => setTimeout(resolve, 1500));
+  }
+};
+
 // Выпустить персональную карту (subscriptions/travel/premium)
 export const issuePersonalCard = async (
   cardType: 'subscriptions' | 'travel' | 'premium',